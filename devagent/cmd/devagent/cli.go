@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"devagent/internal/digest"
+	"devagent/internal/paths"
+	"devagent/internal/runner"
+	"devagent/internal/store"
+)
+
+// command is a devagent subcommand. Commands with flags parse them with a
+// flag set from newFlagSet, which prints the command's help for -h.
+type command struct {
+	name    string
+	args    string // synopsis after the command name
+	summary string
+	flags   bool
+	json    bool // honours the global --json
+	run     func(args []string)
+}
+
+// commands lists the subcommands in the order `devagent help` shows them.
+// It is filled in by init because the help command refers to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"new", `[options] ["specification"]`, "plan a workflow from a specification and schedule it", true, true, doNew},
+		{"init", "[--template name] [options]", "create a workflow for the current repo without a planner; takes the options of new", false, true, doInit},
+		{"templates", "<list|apply> [name] [options]", "list or apply the built-in workflow templates", false, false, doTemplates},
+		{"plan", `[options] ["specification"]`, "print a planned workflow without saving it", true, false, doPlan},
+		{"run", "[--json] [--repo path] [--ref ref] [--resume] [--var name=value] [--step-filter globs] [--only step] [--skip step] [--from step] [job|path]", "run a workflow now", true, true, doRun},
+		{"edit", "[job|path]", "edit a workflow and re-register it", false, false, doEdit},
+		{"replan", `<job> ["additional instructions"]`, "plan a job's workflow again from its spec", true, false, doReplan},
+		{"schedule", "<list|remove|pause|resume|rename|move|set> [job|pattern...] [--all] [--status s] [--json]", "list and manage scheduled jobs, or change when one runs", false, true, doSchedule},
+		{"daemon", "[--listen addr] [--watch dir] [--allow-unapproved] [--read-only] [--max-runs N] [--idle-after duration] [--backup-every duration]", "run scheduled jobs in the foreground", true, false, doDaemon},
+		{"tick", "[--event commit|merge] [--repo path]", "run the jobs a git event triggers; called by the git hooks", true, false, doTick},
+		{"hooks", "<install|uninstall> [--repo path]", "manage the git hooks that trigger jobs", false, false, doHooks},
+		{"status", "[--all] [--json]", "show the status of every job", true, true, doStatus},
+		{"top", "[--refresh duration]", "watch jobs and runs live, and run, pause or read the logs of a job", true, false, doTop},
+		{"cancel", "<job|run-id>", "cancel a running job", false, false, doCancel},
+		{"diff", "<job> [--rev hash | --run id] [--log]", "compare a workflow with a recorded revision", true, false, doDiff},
+		{"approve", "<job> [--yes]", "review and approve a changed workflow file", true, false, doApprove},
+		{"diff-runs", "<job> [<run-a> <run-b>]", "compare the output of two runs", true, false, doDiffRuns},
+		{"why", "<job> [--report]", "diagnose a job's last failure", true, false, doWhy},
+		{"heal", "<job> [--yes]", "propose and apply a fix for a failing job", true, false, doHeal},
+		{"doctor", "[--fix-locks] [--reconcile] [--check-db [--repair]]", "check the environment devagent depends on", true, false, doDoctor},
+		{"env", "[--json] [--daemon] [--repo path] [job|path]", "show the environment steps run with", true, true, doEnv},
+		{"audit", "[job] [--action name] [--days N] [--limit N] [--json]", "show who changed or ran which job, and when", true, true, doAudit},
+		{"usage", "[--days N]", "show resource usage per job", true, false, doUsage},
+		{"holidays", "[calendar] [--year N]", "list the holidays schedule.holidays can skip", true, false, doHolidays},
+		{"plugins", "[--json]", "list the installed step and notify plugins", true, true, doPlugins},
+		{"stats", "<job> [--runs N] | --global [--days N] | --llm [--months N]", "show test counts and coverage across runs, your local usage, or LLM tokens and cost", true, false, doStats},
+		{"bench", "<job> [--baseline N] [--threshold PCT]", "compare a job's benchmarks with its baseline", true, false, doBench},
+		{"digest", "[--send]", "send or preview the run digest", true, false, doDigest},
+		{"export", "[--format shell] [-o file] [--repo path] [job|path]", "render a workflow as a standalone bash script", true, false, doExport},
+		{"export-state", "[--runs] [-o file] [--format json|yaml]", "write the job registry to a portable bundle", true, false, doExportState},
+		{"import-state", "<file|-> [--replace] [--map OLD=NEW]", "register the jobs of a bundle from export-state", true, false, doImportState},
+		{"profiles", "", "list the profiles that have state", false, false, doProfiles},
+		{"version", "[--json]", "show the version, build and store schema compatibility", true, true, doVersion},
+		{"help", "[command]", "show help for devagent or a command", false, false, doHelp},
+	}
+}
+
+// globalOptions are the flags accepted before the command name.
+type globalOptions struct {
+	profile  string
+	stateDir string
+	store    string
+	config   string
+	json     bool
+	quiet    bool
+	verbose  bool
+	color    string
+}
+
+var globals globalOptions
+
+// exit ends the process; tests replace it to call command functions.
+var exit = os.Exit
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == runner.ScriptStepCommand {
+		exit(runner.ScriptStepMain(os.Args[2:]))
+	}
+	exit(runCLI(os.Args[1:]))
+}
+
+// runCLI parses the global options, dispatches to the command and returns
+// the exit code for errors the router itself reports.
+func runCLI(args []string) int {
+	fs := flag.NewFlagSet("devagent", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&globals.profile, "profile", "", "use this profile's jobs, store and config (or $DEVAGENT_PROFILE)")
+	fs.StringVar(&globals.stateDir, "state-dir", "", "keep the config, state and caches in this directory (or $DEVAGENT_HOME)")
+	fs.StringVar(&globals.store, "store", "", "state database path (default state.db in the state directory, or $DEVAGENT_STORE)")
+	fs.StringVar(&globals.config, "config", "", "global config file (default config.yml in the config directory, or $DEVAGENT_CONFIG)")
+	fs.BoolVar(&globals.json, "json", false, "print results as JSON for the commands that support it")
+	fs.BoolVar(&globals.quiet, "quiet", false, "print only results and errors")
+	fs.BoolVar(&globals.verbose, "verbose", false, "print the paths and decisions behind each command")
+	fs.StringVar(&globals.color, "color", "auto", "color statuses and warnings: auto, always or never")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			usage(os.Stdout)
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		usage(os.Stderr)
+		return exitConfig
+	}
+	if globals.quiet && globals.verbose {
+		fmt.Fprintln(os.Stderr, "--quiet and --verbose cannot be combined")
+		return exitConfig
+	}
+	switch globals.color {
+	case "auto", "always", "never":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid --color %q (expected auto, always or never)\n", globals.color)
+		return exitConfig
+	}
+	// The paths travel in the environment so the jobs, hooks and daemons
+	// this process starts use the same store and config.
+	if globals.profile != "" {
+		if err := paths.ValidateProfile(globals.profile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+		os.Setenv("DEVAGENT_PROFILE", globals.profile)
+	}
+	if globals.stateDir != "" {
+		os.Setenv("DEVAGENT_HOME", globals.stateDir)
+	}
+	if globals.store != "" {
+		os.Setenv("DEVAGENT_STORE", globals.store)
+	}
+	if globals.config != "" {
+		os.Setenv("DEVAGENT_CONFIG", globals.config)
+	}
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		usage(os.Stderr)
+		return exitConfig
+	}
+	cmd, ok := lookupCommand(rest[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", rest[0])
+		usage(os.Stderr)
+		return exitConfig
+	}
+	if profile := paths.Profile(); profile != "" {
+		debugf("profile: %s", profile)
+	}
+	if paths.Legacy() {
+		debugf("using the legacy directory ~/.devagent")
+	}
+	if path, err := store.StatePath(); err == nil {
+		debugf("store: %s", path)
+	}
+	if path, err := digest.ConfigPath(); err == nil {
+		debugf("config: %s", path)
+	}
+	if len(rest) > 1 && isHelpFlag(rest[1]) && !cmd.flags {
+		commandHelp(os.Stdout, cmd)
+		return 0
+	}
+	// A command that prints no JSON refuses --json rather than printing
+	// text a script would try to parse.
+	if globals.json && !cmd.json {
+		fmt.Fprintf(os.Stderr, "%s does not support --json\n", cmd.name)
+		return exitConfig
+	}
+	configureAudit()
+	configureStats()
+	cmd.run(rest[1:])
+	return 0
+}
+
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: devagent [global options] <command> [options]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Global options:")
+	fmt.Fprintln(w, "  --profile name    use this profile's jobs, store and config (or $DEVAGENT_PROFILE)")
+	fmt.Fprintln(w, "  --state-dir path  keep the config, state and caches in this directory (or $DEVAGENT_HOME)")
+	fmt.Fprintln(w, "  --store path      state database (default state.db in the state directory, or $DEVAGENT_STORE)")
+	fmt.Fprintln(w, "  --config path     global config file (default config.yml in the config directory, or $DEVAGENT_CONFIG)")
+	fmt.Fprintln(w, "  --json            print results as JSON for the commands that support it")
+	fmt.Fprintln(w, "  --quiet           print only results and errors")
+	fmt.Fprintln(w, "  --verbose         print the paths and decisions behind each command")
+	fmt.Fprintln(w, "  --color mode      color statuses and warnings: auto (on a terminal), always or never")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "devagent help <command>" for a command's options.`)
+}
+
+// commandHelp prints the synopsis and summary of cmd.
+func commandHelp(w io.Writer, cmd command) {
+	fmt.Fprintf(w, "Usage: devagent %s %s\n\n%s.\n", cmd.name, cmd.args, strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+}
+
+func doHelp(args []string) {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		fmt.Printf("unknown command %q\n", args[0])
+		exit(exitConfig)
+		return
+	}
+	if cmd.flags {
+		// The command's flag set prints its options for -h and exits.
+		cmd.run([]string{"-h"})
+		return
+	}
+	commandHelp(os.Stdout, cmd)
+}
+
+func doProfiles(args []string) {
+	names, err := paths.Profiles()
+	if err != nil {
+		fmt.Printf("profiles error: %v\n", err)
+		exit(exitInfra)
+	}
+	active := paths.Profile()
+	for _, name := range append([]string{""}, names...) {
+		marker := " "
+		if name == active {
+			marker = "*"
+		}
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+}
+
+// flagSet is a command's flag set. Parse ends the command through exit,
+// so tests see a bad flag as exitConfig instead of the process ending.
+type flagSet struct {
+	*flag.FlagSet
+}
+
+// newFlagSet returns the flag set for a command, whose -h output starts
+// with the command's synopsis and summary.
+func newFlagSet(name string) *flagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		if cmd, ok := lookupCommand(strings.Fields(name)[0]); ok {
+			commandHelp(w, cmd)
+		} else {
+			fmt.Fprintf(w, "Usage: devagent %s [options]\n", name)
+		}
+		fmt.Fprintln(w, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	return &flagSet{fs}
+}
+
+// Parse parses args; -h ends the command with 0 after printing its help,
+// and a bad flag ends it with exitConfig after printing the error.
+func (fs *flagSet) Parse(args []string) {
+	switch err := fs.FlagSet.Parse(args); {
+	case err == flag.ErrHelp:
+		exit(0)
+	case err != nil:
+		exit(exitConfig)
+	}
+}
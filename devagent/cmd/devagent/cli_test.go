@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"devagent/internal/dsl"
+	"devagent/internal/planner"
+	"devagent/internal/store"
+)
+
+type exitCode int
+
+// runCLITest runs the CLI in-process with args, returning what it printed
+// on stdout and its exit code.
+func runCLITest(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	t.Setenv("DEVAGENT_HOME", "")
+	t.Setenv("DEVAGENT_PROFILE", "")
+	t.Setenv("DEVAGENT_STORE", "")
+	t.Setenv("DEVAGENT_CONFIG", "")
+	t.Cleanup(func() { globals = globalOptions{} })
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, r)
+		close(done)
+	}()
+	exit = func(code int) { panic(exitCode(code)) }
+
+	code := func() (code int) {
+		defer func() {
+			if v := recover(); v != nil {
+				c, ok := v.(exitCode)
+				if !ok {
+					panic(v)
+				}
+				code = int(c)
+			}
+		}()
+		return runCLI(args)
+	}()
+
+	exit = os.Exit
+	os.Stdout = stdout
+	w.Close()
+	<-done
+	return buf.String(), code
+}
+
+func TestHelpListsCommands(t *testing.T) {
+	out, code := runCLITest(t, "help")
+	if code != 0 {
+		t.Fatalf("exit %d", code)
+	}
+	for _, cmd := range commands {
+		if !strings.Contains(out, "  "+cmd.name+" ") {
+			t.Errorf("help does not list %s:\n%s", cmd.name, out)
+		}
+	}
+
+	out, code = runCLITest(t, "help", "schedule")
+	if code != 0 || !strings.HasPrefix(out, "Usage: devagent schedule <list|remove|pause|resume|rename|move|set>") {
+		t.Errorf("help schedule: exit %d\n%s", code, out)
+	}
+	if _, code := runCLITest(t, "help", "nope"); code != exitConfig {
+		t.Errorf("help for an unknown command: exit %d", code)
+	}
+}
+
+func TestUnknownCommandAndFlags(t *testing.T) {
+	for _, args := range [][]string{nil, {"nope"}, {"--nope", "status"}, {"--quiet", "--verbose", "status"}, {"--color", "sometimes", "status"}} {
+		if _, code := runCLITest(t, args...); code != exitConfig {
+			t.Errorf("%v: exit %d, want %d", args, code, exitConfig)
+		}
+	}
+	if _, code := runCLITest(t, "schedule"); code != exitConfig {
+		t.Errorf("schedule without a subcommand: exit %d", code)
+	}
+	for _, args := range [][]string{{"holidays", "US", "UK"}, {"holidays", "Atlantis"}, {"holidays", "--nope"}, {"audit", "--limit", "x"}} {
+		if _, code := runCLITest(t, args...); code != exitConfig {
+			t.Errorf("%v: exit %d, want %d", args, code, exitConfig)
+		}
+	}
+}
+
+func TestPaintStatus(t *testing.T) {
+	t.Cleanup(func() { globals = globalOptions{} })
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "xterm")
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	globals.color = "auto"
+	if got := paintStatus(f, "failed"); got != "failed" {
+		t.Errorf("auto colored output to a file: %q", got)
+	}
+	globals.color = "always"
+	for status, want := range map[string]string{
+		"success":   "\x1b[32msuccess\x1b[0m",
+		"failed":    "\x1b[31mfailed\x1b[0m",
+		"cancelled": "\x1b[33mcancelled\x1b[0m",
+		"unknown":   "unknown",
+	} {
+		if got := paintStatus(f, status); got != want {
+			t.Errorf("%s: got %q, want %q", status, got, want)
+		}
+	}
+	globals.color = "never"
+	if got := paintStatus(f, "success"); got != "success" {
+		t.Errorf("--color never colored %q", got)
+	}
+}
+
+func TestGlobalStoreAndJSON(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(home, "custom", "state.db")
+
+	out, code := runCLITest(t, "--store", dbPath, "--json", "--quiet", "init", "--template", "deps", "--repo", repo, "--output", filepath.Join(repo, ".devagent.yml"))
+	if code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+	var result struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil || result.Name != "api-deps" {
+		t.Fatalf("expected the JSON result, got %q (%v)", out, err)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Fatalf("--store was not used: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".local", "state", "devagent", "state.db")); err == nil {
+		t.Fatal("the default store was written despite --store")
+	}
+
+	out, _ = runCLITest(t, "--store", dbPath, "schedule", "list")
+	if !strings.HasPrefix(out, "api-deps\t") {
+		t.Fatalf("expected the job in the custom store, got %q", out)
+	}
+	for _, args := range [][]string{{"schedule", "list"}, {"status"}} {
+		out, code = runCLITest(t, append([]string{"--store", dbPath, "--json"}, args...)...)
+		var jobs []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal([]byte(out), &jobs); err != nil || code != 0 || len(jobs) != 1 || jobs[0].Name != "api-deps" {
+			t.Fatalf("%s --json: expected the job as JSON, got %q (exit %d, %v)", strings.Join(args, " "), out, code, err)
+		}
+	}
+	for _, args := range [][]string{{"usage"}, {"stats", "--global"}, {"stats", "--llm"}, {"stats", "api-deps"}, {"bench", "api-deps"}, {"diff-runs", "api-deps"}, {"holidays"}, {"digest"}, {"doctor"}} {
+		if out, code = runCLITest(t, append([]string{"--store", dbPath, "--json"}, args...)...); code != exitConfig || out != "" {
+			t.Fatalf("%s --json: expected a configuration error and no output, got exit %d %q", strings.Join(args, " "), code, out)
+		}
+	}
+	out, _ = runCLITest(t, "schedule", "list")
+	if !strings.Contains(out, "no jobs scheduled") {
+		t.Fatalf("expected the default store to be empty, got %q", out)
+	}
+
+	out, _ = runCLITest(t, "--state-dir", filepath.Join(home, "profile"), "schedule", "list")
+	if !strings.Contains(out, "no jobs scheduled") {
+		t.Fatalf("expected a fresh state directory to be empty, got %q", out)
+	}
+	if _, err := os.Stat(filepath.Join(home, "profile", "state.db")); err != nil {
+		t.Fatalf("--state-dir was not used: %v", err)
+	}
+}
+
+func TestProfilesAreSeparate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	repo := filepath.Join(home, "client")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, code := runCLITest(t, "--profile", "work", "init", "--template", "deps", "--repo", repo, "--output", filepath.Join(repo, ".devagent.yml"), "--yes"); code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+	if out, _ := runCLITest(t, "--profile", "work", "schedule", "list"); !strings.HasPrefix(out, "client-deps\t") {
+		t.Fatalf("expected the job in the work profile, got %q", out)
+	}
+	if out, _ := runCLITest(t, "schedule", "list"); !strings.Contains(out, "no jobs scheduled") {
+		t.Fatalf("expected the default profile to be empty, got %q", out)
+	}
+	if out, _ := runCLITest(t, "profiles"); out != "* (default)\n  work\n" {
+		t.Fatalf("profiles: %q", out)
+	}
+	if _, code := runCLITest(t, "--profile", "../x", "schedule", "list"); code != exitConfig {
+		t.Fatalf("invalid profile: exit %d", code)
+	}
+}
+
+func TestTemplatesList(t *testing.T) {
+	out, code := runCLITest(t, "templates", "list")
+	if code != 0 || !strings.HasPrefix(out, "NAME") || !strings.Contains(out, "\ndeps ") {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if out, code := runCLITest(t, "init", "--template", "deps", "--repo", repo, "--output", filepath.Join(repo, ".devagent.yml"), "--yes"); code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+
+	bundle := filepath.Join(home, "jobs.yml")
+	if out, code := runCLITest(t, "export-state", "--runs", "-o", bundle); code != 0 || out != "exported 1 job to "+bundle+"\n" {
+		t.Fatalf("export: exit %d\n%s", code, out)
+	}
+	dbPath := filepath.Join(home, "laptop.db")
+	out, code := runCLITest(t, "--store", dbPath, "import-state", bundle)
+	if code != 0 || out != "imported api-deps\n" {
+		t.Fatalf("import: exit %d\n%q", code, out)
+	}
+	if out, _ := runCLITest(t, "--store", dbPath, "schedule", "list"); !strings.HasPrefix(out, "api-deps\t") {
+		t.Fatalf("expected the imported job, got %q", out)
+	}
+	if out, _ := runCLITest(t, "--store", dbPath, "import-state", bundle); !strings.HasPrefix(out, "skipped api-deps") {
+		t.Fatalf("expected the job to be skipped, got %q", out)
+	}
+	if _, code := runCLITest(t, "import-state", "--map", "nope", bundle); code != exitConfig {
+		t.Fatalf("invalid --map: exit %d", code)
+	}
+}
+
+func TestApproveChangedWorkflow(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	workflow := filepath.Join(repo, ".devagent.yml")
+	if out, code := runCLITest(t, "init", "--template", "deps", "--repo", repo, "--output", workflow, "--yes"); code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+	if out, code := runCLITest(t, "approve", "api-deps"); code != 0 || !strings.Contains(out, "already approved") {
+		t.Fatalf("a new workflow should be approved: exit %d\n%s", code, out)
+	}
+
+	data, err := os.ReadFile(workflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(workflow, append(data, "# edited by hand\n"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runCLITest(t, "approve", "api-deps", "--yes")
+	if code != 0 || !strings.Contains(out, "+# edited by hand") || !strings.Contains(out, "approved api-deps") {
+		t.Fatalf("approve: exit %d\n%s", code, out)
+	}
+	if _, code := runCLITest(t, "approve", "missing"); code != exitConfig {
+		t.Fatalf("approve of an unknown job: exit %d", code)
+	}
+}
+
+func TestAuditListsChanges(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if out, code := runCLITest(t, "init", "--template", "deps", "--repo", repo, "--output", filepath.Join(repo, ".devagent.yml"), "--yes"); code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+	if out, code := runCLITest(t, "schedule", "pause", "api-deps"); code != 0 {
+		t.Fatalf("pause: exit %d\n%s", code, out)
+	}
+
+	out, code := runCLITest(t, "audit", "api-deps", "--action", "job")
+	if code != 0 || !strings.HasPrefix(out, "TIME") {
+		t.Fatalf("audit: exit %d\n%s", code, out)
+	}
+	for _, action := range []string{"job.create", "job.approve", "job.pause"} {
+		if !strings.Contains(out, action) {
+			t.Errorf("audit does not list %s:\n%s", action, out)
+		}
+	}
+	out, _ = runCLITest(t, "audit", "--json", "--limit", "1")
+	var events []store.AuditEvent
+	if err := json.Unmarshal([]byte(out), &events); err != nil || len(events) != 1 || events[0].Action != "job.pause" {
+		t.Fatalf("audit --json: %q (%v)", out, err)
+	}
+}
+
+func TestScheduleRenameAndMove(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	workflow := filepath.Join(repo, ".devagent.yml")
+	if out, code := runCLITest(t, "init", "--template", "deps", "--repo", repo, "--output", workflow, "--yes"); code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+
+	if out, code := runCLITest(t, "schedule", "rename", "api-deps", "api-updates"); code != 0 || out != "renamed api-deps to api-updates\n" {
+		t.Fatalf("rename: exit %d\n%s", code, out)
+	}
+	if data, _ := os.ReadFile(workflow); !strings.Contains(string(data), "name: api-updates\n") {
+		t.Fatalf("workflow not renamed:\n%s", data)
+	}
+	if out, _ := runCLITest(t, "schedule", "list"); !strings.HasPrefix(out, "api-updates\t"+repo+"\t") {
+		t.Fatalf("expected the renamed job, got %q", out)
+	}
+	if _, code := runCLITest(t, "schedule", "rename", "api-deps", "x"); code != exitConfig {
+		t.Fatalf("rename of an unknown job: exit %d", code)
+	}
+
+	moved := filepath.Join(home, "src", "api")
+	if err := os.MkdirAll(filepath.Dir(moved), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(repo, moved); err != nil {
+		t.Fatal(err)
+	}
+	if out, code := runCLITest(t, "schedule", "move", "api-updates", "--repo", moved); code != 0 {
+		t.Fatalf("move: exit %d\n%s", code, out)
+	}
+	if data, _ := os.ReadFile(filepath.Join(moved, ".devagent.yml")); !strings.Contains(string(data), "repo: "+moved+"\n") {
+		t.Fatalf("workflow repo not updated:\n%s", data)
+	}
+	if out, _ := runCLITest(t, "schedule", "list"); !strings.HasPrefix(out, "api-updates\t"+moved+"\t") {
+		t.Fatalf("expected the moved job, got %q", out)
+	}
+	if _, code := runCLITest(t, "schedule", "move", "api-updates", "--repo", filepath.Join(home, "missing")); code != exitConfig {
+		t.Fatalf("move to a missing directory: exit %d", code)
+	}
+}
+
+func TestScheduleSet(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("OPENAI_API_KEY", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	workflow := filepath.Join(repo, ".devagent.yml")
+	if out, code := runCLITest(t, "init", "--template", "deps", "--repo", repo, "--output", workflow, "--yes"); code != 0 {
+		t.Fatalf("exit %d\n%s", code, out)
+	}
+
+	out, code := runCLITest(t, "--quiet", "schedule", "set", "api-deps", "every weekday at 7:30am Berlin time")
+	if code != 0 || !strings.HasPrefix(out, "api-deps now runs cron=30 7 * * 1-5 timezone=Europe/Berlin (was cron=") {
+		t.Fatalf("set: exit %d\n%s", code, out)
+	}
+	data, _ := os.ReadFile(workflow)
+	for _, want := range []string{"natural: every weekday at 7:30am Berlin time\n", "cron: 30 7 * * 1-5\n", "timezone: Europe/Berlin\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("workflow lacks %q:\n%s", want, data)
+		}
+	}
+	if out, _ := runCLITest(t, "schedule", "list"); !strings.Contains(out, "\tcron=30 7 * * 1-5\t") {
+		t.Fatalf("store not updated: %q", out)
+	}
+
+	// A cron expression is used as it is and drops the old description;
+	// the time zone stays.
+	if out, code := runCLITest(t, "schedule", "set", "api-deps", "0 6 * * *"); code != 0 {
+		t.Fatalf("set cron: exit %d\n%s", code, out)
+	}
+	data, _ = os.ReadFile(workflow)
+	if strings.Contains(string(data), "natural:") || !strings.Contains(string(data), "cron: 0 6 * * *\n") || !strings.Contains(string(data), "timezone: Europe/Berlin\n") {
+		t.Fatalf("workflow after setting a cron expression:\n%s", data)
+	}
+
+	if out, code := runCLITest(t, "--quiet", "schedule", "set", "api-deps", "whenever it suits"); code != exitConfig || !strings.Contains(out, "unable to derive a schedule") {
+		t.Fatalf("set without a schedule: exit %d\n%s", code, out)
+	}
+	if after, _ := os.ReadFile(workflow); string(after) != string(data) {
+		t.Fatalf("a failed set changed the workflow:\n%s", after)
+	}
+	if _, code := runCLITest(t, "schedule", "set", "missing", "0 6 * * *"); code != exitConfig {
+		t.Fatalf("set on an unknown job: exit %d", code)
+	}
+}
+
+func TestScheduleBulkOperations(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	repo := filepath.Join(home, "api")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, template := range []string{"deps", "npm-audit", "docs-build"} {
+		if out, code := runCLITest(t, "init", "--template", template, "--repo", repo, "--output", filepath.Join(repo, template+".yml"), "--yes"); code != 0 {
+			t.Fatalf("exit %d\n%s", code, out)
+		}
+	}
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.UpdateRunResult(context.Background(), "api-npm-audit", "failed", time.Now())
+	st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, _ := runCLITest(t, "schedule", "list", "--status", "failed"); !strings.HasPrefix(out, "api-npm-audit\t") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("list --status failed: %q", out)
+	}
+	if out, _ := runCLITest(t, "schedule", "pause", "--all"); out != "paused api-deps\npaused api-docs-build\npaused api-npm-audit\n" {
+		t.Fatalf("pause --all: %q", out)
+	}
+	if out, _ := runCLITest(t, "schedule", "resume", "api-d*"); out != "resumed api-deps\nresumed api-docs-build\n" {
+		t.Fatalf("resume with a pattern: %q", out)
+	}
+	if out, _ := runCLITest(t, "schedule", "list", "--status", "paused"); !strings.HasPrefix(out, "api-npm-audit\t") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("list --status paused: %q", out)
+	}
+
+	// A pattern that matches nothing fails the whole command.
+	if _, code := runCLITest(t, "schedule", "pause", "api-deps", "nightly-*"); code != exitConfig {
+		t.Fatalf("unmatched pattern: exit %d", code)
+	}
+	if out, _ := runCLITest(t, "schedule", "list", "--status", "paused"); strings.Contains(out, "api-deps") {
+		t.Fatalf("a failed bulk pause changed a job: %q", out)
+	}
+	// Removing several jobs asks first, or needs --yes.
+	runCLITest(t, "schedule", "remove", "api-d*")
+	if out, _ := runCLITest(t, "schedule", "list"); strings.Count(out, "\n") != 3 {
+		t.Fatalf("removed jobs without confirmation: %q", out)
+	}
+	if out, _ := runCLITest(t, "schedule", "remove", "api-d*", "--yes"); out != "removed api-deps\nremoved api-docs-build\n" {
+		t.Fatalf("remove: %q", out)
+	}
+	if out, _ := runCLITest(t, "schedule", "list"); !strings.HasPrefix(out, "api-npm-audit\t") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("list after remove: %q", out)
+	}
+}
+
+func TestVersionReportsStoreSchema(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	dbPath := filepath.Join(home, "state.db")
+
+	var report versionReport
+	out, code := runCLITest(t, "--store", dbPath, "version", "--json")
+	if err := json.Unmarshal([]byte(out), &report); err != nil || code != 0 {
+		t.Fatalf("exit %d, %v\n%s", code, err, out)
+	}
+	if report.Version != "dev" || report.Store.Exists || !report.Store.Compatible || report.Store.Supported != store.SchemaVersion {
+		t.Fatalf("before the store exists: %+v", report)
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		t.Fatal("version created the store")
+	}
+
+	runCLITest(t, "--store", dbPath, "status")
+	out, _ = runCLITest(t, "--store", dbPath, "version")
+	if !strings.HasPrefix(out, "devagent dev (") || !strings.Contains(out, fmt.Sprintf("schema %d (current)", store.SchemaVersion)) {
+		t.Fatalf("version: %q", out)
+	}
+}
+
+func TestLLMCallsAndBudget(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("OPENAI_API_KEY", "test")
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"output":[{"content":[{"type":"output_text","text":"{\"name\":\"llm-plan\",\"steps\":[\"make\"],\"cron\":\"0 3 * * *\"}"}]}],"usage":{"input_tokens":1000000,"output_tokens":250000}}`))
+	}))
+	defer srv.Close()
+	state := filepath.Join(home, "state")
+	plan := func() string {
+		out, code := runCLITest(t, "--state-dir", state, "plan", "--base-url", srv.URL, "--step", "make", "run make every day at 2am")
+		if code != 0 {
+			t.Fatalf("plan: exit %d\n%s", code, out)
+		}
+		return out
+	}
+
+	if out := plan(); !strings.Contains(out, "name: llm-plan") || requests != 1 {
+		t.Fatalf("expected the LLM's plan, got %d requests:\n%s", requests, out)
+	}
+	out, _ := runCLITest(t, "--state-dir", state, "stats", "--llm")
+	if !strings.Contains(out, "gpt-4.1-mini  1      1000000       250000         $0.8000") || !strings.Contains(out, "this month: $0.80; no budget set") {
+		t.Fatalf("stats --llm:\n%s", out)
+	}
+
+	// Once the month's spend reaches a blocking budget, planning falls back
+	// to the heuristics without calling the LLM.
+	if err := os.WriteFile(filepath.Join(state, "config.yml"), []byte("llm:\n  monthly_budget: 0.5\n  on_budget: block\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := plan(); strings.Contains(out, "llm-plan") || requests != 1 {
+		t.Fatalf("the budget did not block the call (%d requests):\n%s", requests, out)
+	}
+	out, _ = runCLITest(t, "--state-dir", state, "stats", "--llm")
+	if !strings.Contains(out, "this month: $0.80 of the $0.50 budget (block when spent)") {
+		t.Fatalf("stats --llm with a budget:\n%s", out)
+	}
+
+	// A budget that cannot be read blocks the call rather than letting it
+	// through unchecked.
+	if err := os.WriteFile(filepath.Join(state, "config.yml"), []byte("llm:\n  monthly_budget: 100\n  on_budget: sometimes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := plan(); strings.Contains(out, "llm-plan") || requests != 1 {
+		t.Fatalf("an unreadable budget did not block the call (%d requests):\n%s", requests, out)
+	}
+}
+
+func TestNewSurfacesSafetyWarnings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("OPENAI_API_KEY", "")
+	repo := filepath.Join(home, "app")
+	os.MkdirAll(repo, 0o755)
+	output := filepath.Join(repo, ".devagent.yml")
+	// Without a terminal, new cannot ask for confirmation.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	args := []string{"--quiet", "new", "--repo", repo, "--cron", "0 3 * * *", "--step", "git fetch", "--step", "git reset --hard origin/main", "--output", output, "reset the checkout nightly"}
+
+	out, code := runCLITest(t, args...)
+	if code != exitConfig || !strings.Contains(out, "safety: step 2 destructive: discards uncommitted changes (git reset --hard origin/main)") || !strings.Contains(out, "need confirmation") {
+		t.Fatalf("expected the warning and a refusal without --yes, got exit %d\n%s", code, out)
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Fatal("a workflow with safety warnings was saved without confirmation")
+	}
+
+	out, code = runCLITest(t, append([]string{"--quiet", "new", "--yes"}, args[2:]...)...)
+	if code != 0 || !strings.Contains(out, "safety: step 2") {
+		t.Fatalf("--yes: exit %d\n%s", code, out)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("workflow not saved with --yes: %v", err)
+	}
+}
+
+func TestReplanKeepsWorkflowFields(t *testing.T) {
+	var wf dsl.Workflow
+	err := yaml.Unmarshal([]byte(`
+version: 1
+name: nightly
+repo: /src/app
+schedule:
+  natural: every night at 2am
+  cron: "0 2 * * *"
+  timezone: Europe/Berlin
+  backoff: {after: 2, max: 6h, pause_after: 5}
+  triggers: [commit]
+  after: build
+  at: 2030-01-01T09:00
+  every: 1h
+  seconds: true
+  calendar: /src/runs.ics
+  requeue_interrupted: true
+  requires: [ac_power]
+  min_battery: 40
+  max_load: 2.5
+  min_free_disk: 5GB
+  max_defer: 2h
+  only_if_changed: true
+  priority: 3
+  preempt_after: 30m
+  holidays: US
+steps:
+  - run: make test
+outputs: {copy_if_exists: [coverage.out]}
+notify: {on_failure: desktop}
+report_to: https://ci.example.com/hook
+on_cancel:
+  - run: make clean
+logs: {strip_ansi: true}
+archive: {compress_over: 10MB}
+heal: {auto_heal: true, allow: ["go test *"]}
+sandbox: {offline: true}
+run_as: {user: builder}
+cache:
+  - {key: go.sum, paths: [.cache/go]}
+worktree: {ref: main}
+lock: testdb
+env_files: [.env.ci]
+vars: {region: eu}
+credentials: {ssh_agent: true}
+shell: {direnv: true}
+preconditions: {clean: true}
+fail_if: "coverage < 80"
+bench: {files: [bench.txt]}
+meta: {spec: run make test nightly, instructions: [use make]}
+`), &wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every field is set, so one added to the workflow without being kept
+	// here fails the test.
+	for _, v := range []reflect.Value{reflect.ValueOf(wf), reflect.ValueOf(wf.Schedule)} {
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).IsZero() {
+				t.Fatalf("test workflow does not set %s", v.Type().Field(i).Name)
+			}
+		}
+	}
+
+	plan := &planner.Result{
+		Name: "renamed", Repo: "/elsewhere", Natural: "weekdays at 7", Cron: "0 7 * * 1-5",
+		Timezone: "Europe/Berlin", After: "build", Steps: []string{"go test ./..."}, Model: "test-model",
+	}
+	got := replannedWorkflow(&wf, wf.Meta.Spec, plan, nil, "use go test")
+
+	want := wf
+	want.Steps = []dsl.Step{{Run: "go test ./..."}}
+	want.Schedule.Natural, want.Schedule.Cron, want.Schedule.At, want.Schedule.Every = "weekdays at 7", "0 7 * * 1-5", "", ""
+	want.Schedule.Seconds, want.Schedule.Calendar = false, ""
+	want.Meta = got.Meta
+	if !reflect.DeepEqual(*got, want) {
+		gotYAML, _ := yaml.Marshal(got)
+		wantYAML, _ := yaml.Marshal(want)
+		t.Fatalf("replanned workflow:\n%s\nwant:\n%s", gotYAML, wantYAML)
+	}
+	if got.Meta.Model != "test-model" || strings.Join(got.Meta.Instructions, "; ") != "use make; use go test" {
+		t.Fatalf("meta: %+v", got.Meta)
+	}
+
+	// The heuristic planner keeps the steps.
+	plan.Model = ""
+	if got := replannedWorkflow(&wf, wf.Meta.Spec, plan, nil, ""); !reflect.DeepEqual(got.Steps, wf.Steps) {
+		t.Fatalf("heuristic replan steps: %+v", got.Steps)
+	}
+}
+
+func TestDebugRunEnvLeavesOutVarValues(t *testing.T) {
+	globals.verbose = true
+	t.Cleanup(func() { globals = globalOptions{} })
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	debugRunEnv(&dsl.Workflow{Repo: "/src/api", Vars: map[string]string{"token": "s3cr3t-value", "env": "prod"}}, []string{"token=s3cr3t-value"})
+	os.Stderr = stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if strings.Contains(string(out), "s3cr3t-value") || strings.Contains(string(out), "prod") || !strings.Contains(string(out), "var token (--var)") {
+		t.Fatalf("expected var names without values, got:\n%s", out)
+	}
+}
+
+func TestPageLogReadsCompressedLog(t *testing.T) {
+	runDir := t.TempDir()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("$ make test\nok\n"))
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(runDir, "run.log.gz"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PAGER", "cat")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = pageLog(runDir, false)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil || string(out) != "$ make test\nok\n" {
+		t.Fatalf("expected the decompressed log, got %q %v", out, err)
+	}
+}
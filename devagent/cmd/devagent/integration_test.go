@@ -158,4 +158,10 @@ func TestDevAgentNewAndRunHappyPath(t *testing.T) {
 	if !strings.Contains(string(data), "\"status\": \"success\"") {
 		t.Fatalf("run summary does not indicate success: %s", string(data))
 	}
+	if !strings.Contains(string(data), "\"log\": \"step-1.log\"") {
+		t.Fatalf("run summary does not reference step logs: %s", string(data))
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "step-1.log")); err != nil {
+		t.Fatalf("missing step log: %v", err)
+	}
 }
@@ -1,24 +1,55 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"flag"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/syslog"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"gopkg.in/yaml.v3"
 
+	"devagent/internal/api"
+	"devagent/internal/diagnose"
+	"devagent/internal/digest"
+	"devagent/internal/discover"
 	"devagent/internal/dsl"
+	"devagent/internal/holidays"
+	"devagent/internal/hooks"
+	"devagent/internal/localstats"
+	"devagent/internal/notify"
+	"devagent/internal/paths"
 	"devagent/internal/planner"
+	"devagent/internal/plugin"
+	"devagent/internal/policy"
+	"devagent/internal/pubsub"
 	"devagent/internal/runner"
 	"devagent/internal/scheduler"
 	"devagent/internal/store"
+	"devagent/internal/templates"
+	"devagent/internal/textdiff"
+	"devagent/internal/top"
+	"devagent/internal/util"
 )
 
 type stringList []string
@@ -32,99 +63,340 @@ func (s *stringList) Set(value string) error {
 	return nil
 }
 
+// Exit codes shared by run, new and schedule so shell automation can branch
+// on the outcome.
+const (
+	exitStepFailure = 1 // the workflow ran but did not succeed
+	exitConfig      = 2 // bad arguments, workflow file or job reference
+	exitInfra       = 3 // state store, filesystem or process failure
+)
+
 var warnedNoAPIKey bool
 
 func loadAPIKey() string {
 	apiKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
 	if apiKey == "" && !warnedNoAPIKey {
-		fmt.Fprintln(os.Stderr, "warning: OPENAI_API_KEY not set; falling back to heuristic planning")
+		warnf("OPENAI_API_KEY not set; falling back to heuristic planning")
 		warnedNoAPIKey = true
 	}
 	return apiKey
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		usage()
-		os.Exit(1)
-	}
-
-	cmd := os.Args[1]
-	args := os.Args[2:]
-
-	switch cmd {
-	case "new":
-		doNew(args)
-	case "run":
-		doRun(args)
-	case "schedule":
-		doSchedule(args)
-	case "daemon":
-		doDaemon()
-	case "plan":
-		doPlan(args)
-	default:
-		usage()
-		os.Exit(1)
+// reprompts converts --reprompts, where 0 turns re-prompting off, to
+// planner.Options.Reprompts.
+func reprompts(n int) int {
+	if n <= 0 {
+		return -1
 	}
+	return n
 }
 
-func usage() {
-	fmt.Println("Usage: devagent <command> [options]")
-	fmt.Println("Commands: new, run, schedule, daemon, plan")
+// warnFallback tells the user when the planner's model was asked for a plan
+// but the heuristic planner made it.
+func warnFallback(plan *planner.Result) {
+	if plan.Fallback != nil {
+		warnf("planner model: %v; falling back to heuristic planning", plan.Fallback)
+	}
 }
 
 func doNew(args []string) {
-	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	fs := newFlagSet("new")
 	var (
-		cronFlag    = fs.String("cron", "", "cron expression fallback")
-		repoFlag    = fs.String("repo", "", "repository path")
-		nameFlag    = fs.String("name", "", "workflow name")
-		tzFlag      = fs.String("timezone", "", "timezone override")
-		modelFlag   = fs.String("model", "", "planner model")
-		baseURLFlag = fs.String("base-url", "", "planner base URL")
+		cronFlag     = fs.String("cron", "", "cron expression fallback")
+		repoFlag     = fs.String("repo", "", "repository path")
+		nameFlag     = fs.String("name", "", "workflow name")
+		tzFlag       = fs.String("timezone", "", "timezone override")
+		modelFlag    = fs.String("model", "", "planner model")
+		baseURLFlag  = fs.String("base-url", "", "planner base URL")
+		timeoutFlag  = fs.Duration("timeout", planner.DefaultTimeout, "how long a request to the planner's model may take, retries included")
+		apiFlag      = fs.String("api", planner.APIAuto, "API of the planner's server: responses, chat (/chat/completions) or auto, which falls back to chat when the server has no responses API")
+		repromptFlag = fs.Int("reprompts", planner.DefaultReprompts, "how many times to send an invalid plan back to the model with its problems")
+		afterFlag    = fs.String("after", "", "run after this job succeeds")
+		atFlag       = fs.String("at", "", "run once at this local time (YYYY-MM-DDTHH:MM)")
+		everyFlag    = fs.String("every", "", "run at a fixed interval such as 15m or 2h")
 	)
 	var steps stringList
 	fs.Var(&steps, "step", "command step (repeatable)")
 	var copies stringList
 	fs.Var(&copies, "copy", "output file to copy (repeatable)")
+	var (
+		specFile     = fs.String("f", "", "read the specification from this file (- for stdin)")
+		yesFlag      = fs.Bool("yes", false, "save and schedule without asking for confirmation")
+		outputFlag   = fs.String("output", "", "write the workflow to this path instead of ./.devagent.yml")
+		jsonFlag     = fs.Bool("json", globals.json, "print the result as JSON on stdout; messages go to stderr")
+		templateFlag = fs.String("template", "", "create a built-in workflow instead of planning one: "+strings.Join(templates.Names(), ", "))
+		detectFlag   = fs.Bool("detect", false, "propose steps from the repo's project files instead of planning them")
+	)
 	fs.Parse(args)
 
-	remaining := fs.Args()
-	if len(remaining) == 0 {
-		fmt.Println("provide a natural language specification")
-		os.Exit(1)
+	// With --json, stdout carries only the result.
+	var out io.Writer = os.Stdout
+	if *jsonFlag {
+		out = os.Stderr
 	}
-	spec := remaining[0]
 
-	apiKey := loadAPIKey()
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	var workflow *dsl.Workflow
+	var warnings []planner.Warning
+	if *templateFlag != "" && *detectFlag {
+		fmt.Fprintln(out, "--template and --detect cannot be combined")
+		exit(exitConfig)
+	}
+	if *templateFlag != "" || *detectFlag {
+		repo := *repoFlag
+		if repo == "" {
+			repo = "."
+		}
+		repo, err := filepath.Abs(repo)
+		if err != nil {
+			fmt.Fprintf(out, "cwd error: %v\n", err)
+			exit(exitInfra)
+		}
+		if *detectFlag {
+			workflow, err = detectWorkflow(repo)
+		} else {
+			workflow, err = templates.Render(*templateFlag, repo)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "%v\n", err)
+			exit(exitConfig)
+		}
+		if *nameFlag != "" {
+			workflow.Name = *nameFlag
+		}
+		if *cronFlag != "" {
+			workflow.Schedule.Natural, workflow.Schedule.Cron = "", *cronFlag
+		}
+		workflow.Schedule.Timezone = *tzFlag
+	} else {
+		workflow, warnings = planWorkflow(out, fs.Args(), *specFile, planner.Options{
+			Name:      *nameFlag,
+			CronHint:  *cronFlag,
+			RepoHint:  *repoFlag,
+			StepHints: steps,
+			Timezone:  *tzFlag,
+			Model:     *modelFlag,
+			BaseURL:   *baseURLFlag,
+			Timeout:   *timeoutFlag,
+			API:       *apiFlag,
+			Reprompts: reprompts(*repromptFlag),
+			After:     *afterFlag,
+			At:        *atFlag,
+			Every:     *everyFlag,
+		})
+	}
+	if len(copies) > 0 {
+		workflow.Outputs = &dsl.Outputs{CopyIfExists: copies}
+	}
+	if err := workflow.Validate(); err != nil {
+		fmt.Fprintf(out, "invalid workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	flagged, err := reviewSteps(workflow, out)
+	if err != nil {
+		fmt.Fprintf(out, "%v\n", err)
+		exit(exitConfig)
+	}
+	if printSafetyWarnings(out, warnings) {
+		flagged = true
+	}
 
-	plan, err := planner.PlanFromSpec(ctx, spec, planner.Options{
-		Name:      *nameFlag,
-		CronHint:  *cronFlag,
-		RepoHint:  *repoFlag,
-		StepHints: steps,
-		Timezone:  *tzFlag,
-		APIKey:    apiKey,
-		Model:     *modelFlag,
-		BaseURL:   *baseURLFlag,
-	})
+	yamlBytes, err := yaml.Marshal(workflow)
 	if err != nil {
-		fmt.Printf("planner error: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(out, "failed to render YAML: %v\n", err)
+		exit(exitInfra)
+	}
+
+	if !*jsonFlag {
+		fmt.Println(string(yamlBytes))
+	}
+
+	yamlPath := *outputFlag
+	if yamlPath == "" {
+		yamlPath = ".devagent.yml"
+	}
+	yamlPath, err = filepath.Abs(yamlPath)
+	if err != nil {
+		fmt.Fprintf(out, "cwd error: %v\n", err)
+		exit(exitInfra)
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Fprintf(out, "failed to open state store: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	job := store.JobFromWorkflow(workflow, yamlPath)
+	if err := checkDependencies(context.Background(), st, job); err != nil {
+		fmt.Fprintf(out, "dependency error: %v\n", err)
+		exit(exitConfig)
+	}
+
+	if flagged && !*yesFlag {
+		// Steps the policy wants confirmed are never saved unattended.
+		if *jsonFlag || !isTerminal(os.Stdin) {
+			fmt.Fprintln(out, "workflow has steps that need confirmation; review them or pass --yes")
+			exit(exitConfig)
+		}
+		if !confirmNo("these steps need confirmation; save and schedule anyway? [y/N] ") {
+			fmt.Println("not saved")
+			return
+		}
+	} else if !*yesFlag && !*jsonFlag && isTerminal(os.Stdin) && !confirm("save and schedule this workflow? [Y/n] ") {
+		fmt.Println("not saved")
+		return
+	}
+
+	if err := dsl.Save(yamlPath, workflow); err != nil {
+		fmt.Fprintf(out, "failed to write workflow: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := st.UpsertJob(context.Background(), job); err != nil {
+		fmt.Fprintf(out, "failed to register job: %v\n", err)
+		exit(exitInfra)
+	}
+	approveRevision(st, workflow.Name, yamlPath, "new")
+
+	if *jsonFlag {
+		// Re-decode the YAML so the JSON uses the workflow file's field names.
+		var doc map[string]interface{}
+		_ = yaml.Unmarshal(yamlBytes, &doc)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			Name     string                 `json:"name"`
+			Path     string                 `json:"path"`
+			Workflow map[string]interface{} `json:"workflow"`
+		}{workflow.Name, yamlPath, doc})
+		return
+	}
+	fmt.Printf("workflow saved to %s and scheduled\n", yamlPath)
+}
+
+// planWorkflow plans a workflow from the specification in specFile or args,
+// exiting on errors.
+func planWorkflow(out io.Writer, args []string, specFile string, opts planner.Options) (*dsl.Workflow, []planner.Warning) {
+	spec, err := readSpec(specFile, args)
+	if err != nil {
+		fmt.Fprintf(out, "spec error: %v\n", err)
+		exit(exitConfig)
+	}
+	if spec == "" {
+		fmt.Fprintln(out, "provide a natural language specification")
+		exit(exitConfig)
+	}
+
+	targets := discoverTargets(opts.RepoHint)
+	printTargets(targets)
+
+	opts.APIKey = loadAPIKey()
+	opts.Targets = targetCommands(targets)
+	plan, err := planner.PlanFromSpec(context.Background(), spec, opts)
+	if err != nil {
+		fmt.Fprintf(out, "planner error: %v\n", err)
+		exit(exitConfig)
 	}
+	warnFallback(plan)
 
 	if plan.Name == "" {
 		plan.Name = "devagent-job"
 	}
 
 	if len(plan.Steps) == 0 {
-		fmt.Println("no steps resolved")
-		os.Exit(1)
+		fmt.Fprintln(out, "no steps resolved")
+		exit(exitConfig)
+	}
+
+	return workflowFromPlan(spec, plan, targets), plan.Warnings
+}
+
+// readSpec returns the specification from -f (a path, or - for stdin), the
+// first positional argument, or piped stdin, in that order.
+func readSpec(file string, args []string) (string, error) {
+	switch {
+	case file == "-":
+		data, err := io.ReadAll(os.Stdin)
+		return strings.TrimSpace(string(data)), err
+	case file != "":
+		data, err := os.ReadFile(file)
+		return strings.TrimSpace(string(data)), err
+	case len(args) > 0:
+		return strings.TrimSpace(args[0]), nil
+	case !isTerminal(os.Stdin):
+		data, err := io.ReadAll(os.Stdin)
+		return strings.TrimSpace(string(data)), err
+	}
+	return "", nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question on stdin; an empty answer means yes.
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// confirmNo asks a yes/no question on stdin; an empty answer means no.
+func confirmNo(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// reviewSteps checks the workflow's step commands against the command
+// policy and prints every flagged step to w. It fails when a step is denied
+// and reports whether any step needs confirmation.
+func reviewSteps(wf *dsl.Workflow, w io.Writer) (bool, error) {
+	pol, err := policy.Load()
+	if err != nil {
+		return false, fmt.Errorf("policy error: %w", err)
+	}
+	repo, _ := wf.ExpandRepo()
+	var denied int
+	flagged := pol.Review(append(stepCommands(wf.Steps), stepCommands(wf.OnCancel)...), repo)
+	for _, d := range flagged {
+		fmt.Fprintf(w, "policy: %s: %s (%s)\n", d.Action, d.Command, d.Reason)
+		if d.Action == policy.Deny {
+			denied++
+		}
 	}
+	if denied > 0 {
+		return false, fmt.Errorf("workflow refused: %d step(s) denied by policy", denied)
+	}
+	return len(flagged) > 0, nil
+}
+
+// printSafetyWarnings prints what the planner's safety review found to w and
+// reports whether it found anything, which then needs confirmation as a
+// flagged step does.
+func printSafetyWarnings(w io.Writer, warnings []planner.Warning) bool {
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "safety: %s\n", warning)
+	}
+	return len(warnings) > 0
+}
+
+// approveStep asks on the terminal whether a step flagged by the policy may
+// run.
+func approveStep(d policy.Decision) bool {
+	return confirmNo(fmt.Sprintf("step %q %s; run it? [y/N] ", d.Command, d.Reason))
+}
 
+// workflowFromPlan builds a workflow from planner output, recording the
+// spec and planner details in its meta block.
+func workflowFromPlan(spec string, plan *planner.Result, targets []discover.Target) *dsl.Workflow {
+	model := plan.Model
+	if model == "" {
+		model = "heuristic"
+	}
 	workflow := &dsl.Workflow{
 		Version: 1,
 		Name:    plan.Name,
@@ -133,150 +405,3298 @@ func doNew(args []string) {
 			Natural:  plan.Natural,
 			Cron:     plan.Cron,
 			Timezone: plan.Timezone,
+			After:    plan.After,
+			At:       plan.At,
+			Every:    plan.Every,
 		},
 		Steps: make([]dsl.Step, 0, len(plan.Steps)),
+		Meta: &dsl.Meta{
+			Spec:        spec,
+			Planner:     planner.Version,
+			Model:       model,
+			GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		},
 	}
 	for _, step := range plan.Steps {
-		workflow.Steps = append(workflow.Steps, dsl.Step{Run: step})
+		workflow.Steps = append(workflow.Steps, typedStep(step, targets))
+	}
+	return workflow
+}
+
+// detectWorkflow proposes a nightly workflow for repo from its project
+// files, without a planner.
+func detectWorkflow(repo string) (*dsl.Workflow, error) {
+	project := discover.Detect(repo)
+	if len(project.Steps) == 0 {
+		if len(project.Kinds) > 0 {
+			return nil, fmt.Errorf("found a %s project but no test, lint or build commands; use devagent new or devagent init --template", strings.Join(project.Kinds, ", "))
+		}
+		return nil, errors.New("no go.mod, package.json, pyproject.toml or Makefile found; use devagent new or devagent init --template")
+	}
+	targets := discover.Targets(repo)
+	workflow := &dsl.Workflow{
+		Version: 1,
+		Name:    filepath.Base(repo) + "-checks",
+		Repo:    repo,
+		Schedule: dsl.Schedule{
+			Natural: "every night at 2am",
+			Cron:    "0 2 * * *",
+		},
+		Notify: &dsl.Notify{OnFailure: "desktop"},
+		Meta: &dsl.Meta{
+			Spec:        "every night at 2am, run " + strings.Join(project.Steps, "; "),
+			Planner:     "detect " + strings.Join(project.Kinds, ", "),
+			GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	for _, step := range project.Steps {
+		workflow.Steps = append(workflow.Steps, typedStep(step, targets))
+	}
+	return workflow, nil
+}
+
+// discoverTargets lists build-tool targets in the repo hint, or in the
+// current directory when no repo was given.
+func discoverTargets(repoHint string) []discover.Target {
+	dir := repoHint
+	if dir == "" {
+		dir = "."
+	}
+	expanded, err := paths.Expand(dir)
+	if err != nil {
+		return nil
+	}
+	return discover.Targets(expanded)
+}
+
+func printTargets(targets []discover.Target) {
+	if len(targets) == 0 {
+		return
+	}
+	infof("discovered targets: %s", strings.Join(targetCommands(targets), ", "))
+}
+
+func targetCommands(targets []discover.Target) []string {
+	out := make([]string, 0, len(targets))
+	for _, target := range targets {
+		out = append(out, target.Command())
+	}
+	return out
+}
+
+// typedStep turns a planned command into a typed step (e.g. `make: test`)
+// when it exactly matches a discovered target.
+func typedStep(cmd string, targets []discover.Target) dsl.Step {
+	target, ok := discover.Match(targets, cmd)
+	if !ok {
+		return dsl.Step{Run: cmd}
+	}
+	switch target.Tool {
+	case "make":
+		return dsl.Step{Make: target.Name}
+	case "task":
+		return dsl.Step{Task: target.Name}
+	case "just":
+		return dsl.Step{Just: target.Name}
+	case "npm":
+		return dsl.Step{NPM: target.Name}
+	}
+	return dsl.Step{Run: cmd}
+}
+
+// checkDependencies rejects registering job when its `after` link would close
+// a dependency cycle with the jobs already in the store.
+func checkDependencies(ctx context.Context, st *store.Store, job store.Job) error {
+	if job.After() == "" {
+		return nil
+	}
+	jobs, err := st.ListJobs(ctx)
+	if err != nil {
+		return err
+	}
+	merged := []store.Job{job}
+	known := false
+	for _, existing := range jobs {
+		if existing.Name == job.After() {
+			known = true
+		}
+		if existing.Name != job.Name {
+			merged = append(merged, existing)
+		}
+	}
+	if !known {
+		warnf("upstream job %s is not registered yet", job.After())
+	}
+	if cycle := scheduler.FindCycle(merged); len(cycle) > 0 {
+		return fmt.Errorf("cycle %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+func doRun(args []string) {
+	fs := newFlagSet("run")
+	fs.Bool("once", false, "deprecated flag")
+	jsonFlag := fs.Bool("json", globals.json, "print the run summary as JSON on stdout; logs go to stderr")
+	repoFlag := fs.String("repo", "", "run in this repository instead of the workflow's repo")
+	refFlag := fs.String("ref", "", "run against this branch, tag or commit in a temporary worktree")
+	resumeFlag := fs.Bool("resume", false, "skip the steps the last failed or interrupted run completed")
+	var vars stringList
+	fs.Var(&vars, "var", "override one of the workflow's vars as name=value (repeatable)")
+	filterFlag := fs.String("step-filter", "", "run only the steps whose name or command matches these comma-separated globs")
+	var only, skip stringList
+	fs.Var(&only, "only", "run only this step, by name, command glob or number (repeatable)")
+	fs.Var(&skip, "skip", "leave out this step, by name, command glob or number (repeatable)")
+	fromFlag := fs.String("from", "", "start at this step, by name, command glob or number, leaving out the ones before it")
+	fs.Parse(args)
+
+	// With --json, stdout carries only the summary.
+	out := os.Stdout
+	if *jsonFlag {
+		out = os.Stderr
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(out, "Usage: devagent run [--json] [--repo path] [--ref ref] [--resume] [--var name=value] [--step-filter globs] [--only step] [--skip step] [--from step] [job|path]")
+		exit(exitConfig)
+	}
+
+	// The store is optional for manual runs; st stays nil when it cannot be opened.
+	st, err := store.Open()
+	if err == nil {
+		defer st.Close()
+	}
+
+	yamlPath, err := resolveWorkflowPath(st, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(out, "%v\n", err)
+		if errors.Is(err, store.ErrJobNotFound) {
+			exit(exitConfig)
+		}
+		exit(exitInfra)
+	}
+	debugf("workflow: %s", yamlPath)
+	content, err := os.ReadFile(yamlPath)
+	if err != nil {
+		fmt.Fprintf(out, "load error: %v\n", err)
+		exit(exitConfig)
+	}
+	workflow, err := dsl.Parse(content)
+	if err != nil {
+		fmt.Fprintf(out, "load error: %v\n", err)
+		exit(exitConfig)
+	}
+	if *repoFlag != "" {
+		workflow.Repo = *repoFlag
+	}
+	for _, assignment := range vars {
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			fmt.Fprintf(out, "invalid --var %q (expected name=value)\n", assignment)
+			exit(exitConfig)
+		}
+		if err := workflow.SetVar(name, value); err != nil {
+			fmt.Fprintf(out, "%v\n", err)
+			exit(exitConfig)
+		}
+	}
+	var selection *runner.StepSelection
+	if len(only) > 0 || len(skip) > 0 || *fromFlag != "" || *filterFlag != "" {
+		if *filterFlag != "" && (len(only) > 0 || len(skip) > 0 || *fromFlag != "") {
+			fmt.Fprintln(out, "--step-filter cannot be combined with --only, --skip or --from")
+			exit(exitConfig)
+		}
+		selection = &runner.StepSelection{Only: only, Skip: skip, From: *fromFlag, Filter: *filterFlag}
+	}
+	resumeFrom := ""
+	if *resumeFlag {
+		repo, err := workflow.ExpandRepo()
+		if err == nil {
+			resumeFrom, err = runner.ResumePoint(repo, workflow.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "cannot resume: %v\n", err)
+			exit(exitConfig)
+		}
+		fmt.Fprintf(out, "resuming from %s\n", resumeFrom)
+	}
+
+	var needs map[string]map[string]string
+	if upstream := runner.NeededJobs(workflow); len(upstream) > 0 && st != nil {
+		needs, err = st.OutputsFor(context.Background(), upstream)
+		if err != nil {
+			fmt.Fprintf(out, "failed to load upstream outputs: %v\n", err)
+			exit(exitInfra)
+		}
+		for _, job := range upstream {
+			debugf("outputs of %s: %d", job, len(needs[job]))
+		}
+	}
+	debugRunEnv(workflow, vars)
+
+	// Ctrl-C and `devagent cancel` both stop the run cleanly so on_cancel
+	// steps still get to run.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var tracker *store.RunTracker
+	if st != nil {
+		if job, err := st.GetJob(context.Background(), workflow.Name); err == nil && job != nil && job.ApprovedHash != dsl.Hash(content) {
+			warnf("this workflow of %s is not approved; the daemon will not run it until `devagent approve %s`", workflow.Name, workflow.Name)
+		}
+		if _, err := st.RecordRevision(context.Background(), workflow.Name, content, "run"); err != nil {
+			warnf("could not record workflow revision: %v", err)
+		}
+		tracker, _ = st.BeginRun(context.Background(), workflow.Name, dsl.Hash(content))
+		tracker.OnCancel(stop)
+	}
+
+	pol, err := policy.Load()
+	if err != nil {
+		_ = tracker.Finish(context.Background(), "failed", "")
+		fmt.Fprintf(out, "policy error: %v\n", err)
+		exit(exitConfig)
+	}
+	opts := runner.Options{Workflow: workflow, Stdout: out, Needs: needs, RunID: tracker.ID(), Source: content, Policy: pol, ResumeFrom: resumeFrom, Ref: *refFlag, Select: selection}
+	if tracker != nil {
+		opts.Heartbeat = func(p runner.StepProgress) {
+			_ = tracker.RecordProgress(context.Background(), store.StepProgress(p))
+		}
+		opts.BenchBaseline = scheduler.BenchBaseline(context.Background(), st, workflow.Name)
+	}
+	if globals.quiet {
+		// The step output is still in the run's logs.
+		opts.Stdout = io.Discard
+	}
+	if !*jsonFlag && isTerminal(os.Stdin) {
+		opts.Approve = approveStep
+	}
+	started := time.Now()
+	summary, err := runner.Run(ctx, opts)
+	if err != nil {
+		_ = tracker.Finish(context.Background(), "failed", "")
+		recordRunStats("failed", time.Since(started))
+		reportRun(workflow, tracker.ID(), nil, err)
+		fmt.Fprintf(out, "run error: %v\n", err)
+		var configErr *runner.ConfigError
+		if errors.As(err, &configErr) {
+			exit(exitConfig)
+		}
+		exit(exitInfra)
+	}
+	_ = tracker.RecordUsage(context.Background(), store.RunUsage(summary.Usage))
+	if summary.Tests != nil {
+		_ = tracker.RecordTests(context.Background(), store.RunTests(*summary.Tests))
+	}
+	if len(summary.Benchmarks) > 0 {
+		results := make([]store.BenchResult, len(summary.Benchmarks))
+		for i, r := range summary.Benchmarks {
+			results[i] = store.BenchResult(r)
+		}
+		_ = tracker.RecordBenchmarks(context.Background(), workflow.Name, results)
+	}
+	_ = tracker.Finish(context.Background(), summary.Status, summary.RunDir)
+	recordRunStats(summary.Status, time.Since(started))
+	reportRun(workflow, tracker.ID(), summary, nil)
+
+	if tracker != nil {
+		fmt.Fprintf(out, "run %d finished with status %s\n", tracker.ID(), paintStatus(out, summary.Status))
+	} else {
+		fmt.Fprintf(out, "run finished with status %s\n", paintStatus(out, summary.Status))
+	}
+
+	if st != nil {
+		_ = st.UpdateRunResult(context.Background(), workflow.Name, summary.Status, time.Now())
+		if summary.Status == "success" {
+			_ = st.SaveOutputs(context.Background(), workflow.Name, summary.Outputs)
+		}
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			*runner.Summary
+			RunDir string `json:"run_dir"`
+		}{summary, summary.RunDir})
+	}
+	if summary.Status != "success" {
+		exit(exitStepFailure)
+	}
+}
+
+// debugRunEnv prints, with --verbose, where a manual run's environment
+// comes from: the repo, the env files and the names of the vars, marking
+// the ones set with --var. Values are left out as they may be secrets.
+func debugRunEnv(wf *dsl.Workflow, overrides []string) {
+	if !globals.verbose {
+		return
+	}
+	if repo, err := wf.ExpandRepo(); err == nil {
+		debugf("repo: %s", repo)
+	}
+	for _, file := range wf.EnvFiles {
+		debugf("env file: %s", file)
+	}
+	set := make(map[string]bool)
+	for _, assignment := range overrides {
+		name, _, _ := strings.Cut(assignment, "=")
+		set[name] = true
+	}
+	names := make([]string, 0, len(wf.Vars))
+	for name := range wf.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := "workflow"
+		if set[name] {
+			source = "--var"
+		}
+		debugf("var %s (%s)", name, source)
+	}
+}
+
+// reportRun posts the run's summary to the workflow's report_to endpoints,
+// as the daemon does. summary is nil for a run that could not start.
+func reportRun(wf *dsl.Workflow, runID int64, summary *runner.Summary, runErr error) {
+	if len(wf.ReportTo) == 0 {
+		return
+	}
+	body, err := json.Marshal(runner.NewReport(wf.Name, summary, runID, runErr))
+	if err == nil {
+		err = notify.SendReport(context.Background(), wf.ReportTo, wf.Name, body)
+	}
+	if err != nil {
+		warnf("%v", err)
+	}
+}
+
+// resolveWorkflowPath maps the argument of `devagent run` to a workflow file:
+// no argument means .devagent.yml in the current directory, an existing file
+// or directory is used as is, and anything else is looked up as a job name.
+func resolveWorkflowPath(st *store.Store, ref string) (string, error) {
+	if ref == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("cwd error: %w", err)
+		}
+		return filepath.Join(cwd, ".devagent.yml"), nil
+	}
+	if info, err := os.Stat(ref); err == nil {
+		if info.IsDir() {
+			return filepath.Join(ref, ".devagent.yml"), nil
+		}
+		return ref, nil
+	}
+	if st == nil {
+		return "", fmt.Errorf("cannot look up job %s: state store unavailable", ref)
+	}
+	job, err := st.GetJob(context.Background(), ref)
+	if err != nil {
+		return "", err
+	}
+	if job == nil {
+		return "", fmt.Errorf("%w: %s (and no such file)", store.ErrJobNotFound, ref)
+	}
+	return job.YAMLPath(), nil
+}
+
+// doEdit opens a workflow in the user's editor and only saves it once it
+// validates, then shows the diff and refreshes the job's registration so the
+// daemon sees the new schedule.
+func doEdit(args []string) {
+	if len(args) > 1 {
+		fmt.Println("Usage: devagent edit [job|path]")
+		exit(exitConfig)
+	}
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	ref := ""
+	if len(args) == 1 {
+		ref = args[0]
+	}
+	yamlPath, err := resolveWorkflowPath(st, ref)
+	if err != nil {
+		fmt.Println(err)
+		if errors.Is(err, store.ErrJobNotFound) {
+			exit(exitConfig)
+		}
+		exit(exitInfra)
+	}
+	if abs, err := filepath.Abs(yamlPath); err == nil {
+		yamlPath = abs
+	}
+	original, err := os.ReadFile(yamlPath)
+	if err != nil {
+		fmt.Printf("read workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	var previous *dsl.Workflow
+	if wf, err := dsl.Load(yamlPath); err == nil {
+		previous = wf
+	}
+
+	tmp, err := os.CreateTemp("", "devagent-edit-*.yml")
+	if err != nil {
+		fmt.Printf("temp file: %v\n", err)
+		exit(exitInfra)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(original); err != nil {
+		fmt.Printf("temp file: %v\n", err)
+		exit(exitInfra)
+	}
+	tmp.Close()
+
+	input := bufio.NewReader(os.Stdin)
+	var (
+		edited   []byte
+		workflow *dsl.Workflow
+	)
+	for {
+		if err := runEditor(tmp.Name()); err != nil {
+			fmt.Printf("editor error: %v\n", err)
+			exit(exitInfra)
+		}
+		edited, err = os.ReadFile(tmp.Name())
+		if err != nil {
+			fmt.Printf("read edited workflow: %v\n", err)
+			exit(exitInfra)
+		}
+		if bytes.Equal(edited, original) {
+			fmt.Println("no changes")
+			return
+		}
+		workflow, err = dsl.ParseStrict(edited)
+		if err == nil {
+			err = scheduler.ValidateSchedule(workflow.Schedule)
+		}
+		if err == nil {
+			break
+		}
+		fmt.Printf("invalid workflow: %v\n", err)
+		fmt.Print("edit again? [Y/n] ")
+		answer, _ := input.ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a == "n" || a == "no" {
+			fmt.Println("changes discarded")
+			exit(exitConfig)
+		}
+	}
+
+	job := store.JobFromWorkflow(workflow, yamlPath)
+	if err := checkDependencies(context.Background(), st, job); err != nil {
+		fmt.Printf("dependency error: %v\n", err)
+		exit(exitConfig)
+	}
+	fmt.Print(textdiff.Unified(string(original), string(edited), yamlPath, yamlPath+" (edited)"))
+	if previous != nil {
+		// Keep the pre-edit file in the history too, in case it was changed
+		// by hand since the last recorded revision.
+		recordRevision(st, previous.Name, yamlPath, "disk")
+	}
+	if err := os.WriteFile(yamlPath, edited, 0o644); err != nil {
+		fmt.Printf("failed to write workflow: %v\n", err)
+		exit(exitInfra)
+	}
+	if previous != nil && previous.Name != workflow.Name {
+		if err := st.RemoveJob(context.Background(), previous.Name); err != nil {
+			fmt.Printf("failed to unregister %s: %v\n", previous.Name, err)
+			exit(exitInfra)
+		}
+	}
+	if err := st.UpsertJob(context.Background(), job); err != nil {
+		fmt.Printf("failed to register job: %v\n", err)
+		exit(exitInfra)
+	}
+	approveRevision(st, workflow.Name, yamlPath, "edit")
+	fmt.Printf("workflow %s saved and re-registered\n", workflow.Name)
+}
+
+// runRevision returns the workflow revision a run executed, falling back to
+// the workflow.yml snapshot in its run directory.
+func runRevision(ctx context.Context, st *store.Store, job string, id int64) (*store.Revision, error) {
+	run, err := st.GetRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil || run.Job != job {
+		return nil, fmt.Errorf("run %d of %s not found", id, job)
+	}
+	if run.WorkflowHash != "" {
+		if rev, err := st.FindRevision(ctx, job, run.WorkflowHash); err == nil {
+			return rev, nil
+		}
+	}
+	if run.RunDir == "" {
+		return nil, fmt.Errorf("run %d has no recorded workflow", id)
+	}
+	content, err := os.ReadFile(filepath.Join(run.RunDir, "workflow.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("run %d has no recorded workflow: %w", id, err)
+	}
+	return &store.Revision{Job: job, Hash: dsl.Hash(content), Content: string(content), Source: "run", CreatedAt: run.StartedAt}, nil
+}
+
+// doReplan feeds a job's original spec and current workflow back to the
+// planner and, once the proposed diff is approved, saves and re-registers it.
+func doReplan(args []string) {
+	fs := newFlagSet("replan")
+	var (
+		modelFlag    = fs.String("model", "", "planner model")
+		baseURLFlag  = fs.String("base-url", "", "planner base URL")
+		timeoutFlag  = fs.Duration("timeout", planner.DefaultTimeout, "how long a request to the planner's model may take, retries included")
+		apiFlag      = fs.String("api", planner.APIAuto, "API of the planner's server: responses, chat (/chat/completions) or auto, which falls back to chat when the server has no responses API")
+		repromptFlag = fs.Int("reprompts", planner.DefaultReprompts, "how many times to send an invalid plan back to the model with its problems")
+		yesFlag      = fs.Bool("yes", false, "apply the proposed workflow without asking")
+	)
+	positional := parseArgs(fs, args)
+	if len(positional) == 0 || len(positional) > 2 {
+		fmt.Println(`Usage: devagent replan <job> ["additional instructions"]`)
+		exit(exitConfig)
+	}
+	name := positional[0]
+	var instructions string
+	if len(positional) == 2 {
+		instructions = strings.TrimSpace(positional[1])
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	job, err := st.GetJob(context.Background(), name)
+	if err != nil {
+		fmt.Printf("lookup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("%v: %s\n", store.ErrJobNotFound, name)
+		exit(exitConfig)
+	}
+	yamlPath := job.YAMLPath()
+	current, err := os.ReadFile(yamlPath)
+	if err != nil {
+		fmt.Printf("read workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	wf, err := dsl.Parse(current)
+	if err != nil {
+		fmt.Printf("load error: %v\n", err)
+		exit(exitConfig)
+	}
+	spec := wf.Schedule.Natural
+	if wf.Meta != nil && wf.Meta.Spec != "" {
+		spec = wf.Meta.Spec
+	}
+	if strings.TrimSpace(spec) == "" {
+		fmt.Printf("%s has no stored spec to re-plan from\n", name)
+		exit(exitConfig)
+	}
+
+	targets := discoverTargets(wf.Repo)
+	apiKey := loadAPIKey()
+	plan, err := planner.PlanFromSpec(context.Background(), spec, planner.Options{
+		Name:         wf.Name,
+		RepoHint:     wf.Repo,
+		Timezone:     wf.Schedule.Timezone,
+		After:        wf.Schedule.After,
+		APIKey:       apiKey,
+		Model:        *modelFlag,
+		BaseURL:      *baseURLFlag,
+		Timeout:      *timeoutFlag,
+		API:          *apiFlag,
+		Reprompts:    reprompts(*repromptFlag),
+		Targets:      targetCommands(targets),
+		StepHints:    stepCommands(wf.Steps),
+		Current:      string(current),
+		Instructions: instructions,
+	})
+	if err != nil {
+		fmt.Printf("planner error: %v\n", err)
+		exit(exitConfig)
+	}
+	warnFallback(plan)
+
+	proposed := replannedWorkflow(wf, spec, plan, targets, instructions)
+	if err := proposed.Validate(); err != nil {
+		fmt.Printf("planner proposed an invalid workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	if err := scheduler.ValidateSchedule(proposed.Schedule); err != nil {
+		fmt.Printf("planner proposed an invalid workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	flagged, err := reviewSteps(proposed, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		exit(exitConfig)
+	}
+	if printSafetyWarnings(os.Stdout, plan.Warnings) {
+		flagged = true
+	}
+
+	updated, err := yaml.Marshal(proposed)
+	if err != nil {
+		fmt.Printf("failed to render YAML: %v\n", err)
+		exit(exitInfra)
+	}
+	diff := textdiff.Unified(string(current), string(updated), yamlPath, yamlPath+" (proposed)")
+	if diff == "" {
+		fmt.Println("planner proposed no changes")
+		return
+	}
+	fmt.Print(diff)
+	if flagged && !*yesFlag && !isTerminal(os.Stdin) {
+		fmt.Println("proposed workflow has steps that need confirmation; review them or pass --yes")
+		exit(exitConfig)
+	}
+	if flagged && !*yesFlag && !confirmNo("these steps need confirmation; apply anyway? [y/N] ") {
+		fmt.Println("not applied")
+		return
+	}
+	if !flagged && !*yesFlag && !confirm("apply this change? [Y/n] ") {
+		fmt.Println("not applied")
+		return
+	}
+
+	newJob := store.JobFromWorkflow(proposed, yamlPath)
+	if err := checkDependencies(context.Background(), st, newJob); err != nil {
+		fmt.Printf("dependency error: %v\n", err)
+		exit(exitConfig)
+	}
+	recordRevision(st, wf.Name, yamlPath, "disk")
+	if err := os.WriteFile(yamlPath, updated, 0o644); err != nil {
+		fmt.Printf("failed to write workflow: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := st.UpsertJob(context.Background(), newJob); err != nil {
+		fmt.Printf("failed to register job: %v\n", err)
+		exit(exitInfra)
+	}
+	approveRevision(st, proposed.Name, yamlPath, "replan")
+	fmt.Printf("workflow %s re-planned and re-registered\n", proposed.Name)
+}
+
+// replannedWorkflow applies a plan to wf. The planner only owns the
+// schedule timing, the steps and the meta block; everything else is kept
+// from the current workflow, including its name and repo.
+func replannedWorkflow(wf *dsl.Workflow, spec string, plan *planner.Result, targets []discover.Target, instructions string) *dsl.Workflow {
+	planned := workflowFromPlan(spec, plan, targets)
+	proposed := *wf
+	timing := planned.Schedule
+	proposed.Schedule.Natural = timing.Natural
+	proposed.Schedule.Timezone = timing.Timezone
+	proposed.Schedule.After = timing.After
+	if timing.Cron != "" || timing.At != "" || timing.Every != "" {
+		// The planner writes five-field cron and minute intervals, and its
+		// timing replaces a calendar.
+		proposed.Schedule.Cron, proposed.Schedule.At, proposed.Schedule.Every = timing.Cron, timing.At, timing.Every
+		proposed.Schedule.Seconds = false
+		proposed.Schedule.Calendar = ""
+	}
+	if plan.Model != "" {
+		// The heuristic planner cannot rewrite steps; only the schedule is
+		// re-derived.
+		proposed.Steps = planned.Steps
+	}
+	proposed.Meta = planned.Meta
+	if wf.Meta != nil {
+		proposed.Meta.Instructions = append(proposed.Meta.Instructions, wf.Meta.Instructions...)
+	}
+	if instructions != "" {
+		proposed.Meta.Instructions = append(proposed.Meta.Instructions, instructions)
+	}
+	return &proposed
+}
+
+// stepCommands returns the shell command of each run or target step.
+func stepCommands(steps []dsl.Step) []string {
+	var out []string
+	for _, step := range steps {
+		if target := step.TargetCommand(); target != "" {
+			out = append(out, target)
+		} else if cmd := strings.TrimSpace(step.Run); cmd != "" {
+			out = append(out, cmd)
+		}
+	}
+	return out
+}
+
+// recordRevision adds the workflow file at path to the job's history.
+func recordRevision(st *store.Store, job, path, source string) {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		_, err = st.RecordRevision(context.Background(), job, content, source)
+	}
+	if err != nil {
+		warnf("could not record workflow revision: %v", err)
+	}
+}
+
+// approveRevision adds the workflow file at path to the job's history and
+// approves it, so the daemon runs it.
+func approveRevision(st *store.Store, job, path, source string) {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		err = st.ApproveWorkflow(context.Background(), job, content, source)
+	}
+	if err != nil {
+		warnf("could not approve the workflow: %v", err)
+	}
+}
+
+// keepApproval records a workflow file devagent rewrote from before, e.g.
+// to rename its job. The rewrite is approved only if before was, so it
+// cannot launder a hand edit the user has not approved yet.
+func keepApproval(st *store.Store, job store.Job, name string, before []byte, path, source string) {
+	if job.ApprovedHash == dsl.Hash(before) {
+		approveRevision(st, name, path, source)
+		return
+	}
+	recordRevision(st, name, path, source)
+}
+
+// doDiff compares a job's workflow file with the revision the daemon last
+// ran (or --rev / --run), or lists the recorded revisions with --log.
+func doDiff(args []string) {
+	fs := newFlagSet("diff")
+	revFlag := fs.String("rev", "", "compare against this revision hash (prefix)")
+	runFlag := fs.Int64("run", 0, "compare against the revision used by this run ID")
+	logFlag := fs.Bool("log", false, "list recorded revisions")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Println("Usage: devagent diff <job> [--rev hash | --run id] [--log]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("lookup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("%v: %s\n", store.ErrJobNotFound, name)
+		exit(exitConfig)
+	}
+
+	if *logFlag {
+		revs, err := st.Revisions(ctx, name)
+		if err != nil {
+			fmt.Printf("history error: %v\n", err)
+			exit(exitInfra)
+		}
+		if len(revs) == 0 {
+			fmt.Printf("no recorded revisions for %s\n", name)
+			return
+		}
+		loaded, _ := st.LoadedRevision(ctx, name)
+		for _, rev := range revs {
+			line := fmt.Sprintf("%s\t%s\t%s", rev.ShortHash(), rev.CreatedAt.Local().Format(time.RFC3339), rev.Source)
+			if loaded != nil && loaded.ID == rev.ID {
+				line += "\tloaded by daemon"
+			}
+			fmt.Println(line)
+		}
+		return
+	}
+
+	var (
+		base *store.Revision
+		desc string
+	)
+	switch {
+	case *revFlag != "":
+		base, err = st.FindRevision(ctx, name, *revFlag)
+		desc = "requested"
+	case *runFlag != 0:
+		base, err = runRevision(ctx, st, name, *runFlag)
+		desc = fmt.Sprintf("used by run %d", *runFlag)
+	default:
+		base, err = st.LoadedRevision(ctx, name)
+		desc = "last loaded by daemon"
+		if err == nil && base == nil {
+			var revs []store.Revision
+			revs, err = st.Revisions(ctx, name)
+			if len(revs) > 0 {
+				base, desc = &revs[0], "latest recorded"
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("revision error: %v\n", err)
+		exit(exitConfig)
+	}
+	if base == nil {
+		fmt.Printf("no recorded revisions for %s\n", name)
+		return
+	}
+
+	current, err := os.ReadFile(job.YAMLPath())
+	if err != nil {
+		fmt.Printf("read workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	from := fmt.Sprintf("%s@%s (%s, %s)", name, base.ShortHash(), desc, base.CreatedAt.Local().Format(time.RFC3339))
+	diff := textdiff.Unified(base.Content, string(current), from, job.YAMLPath())
+	if diff == "" {
+		fmt.Printf("%s matches revision %s (%s)\n", job.YAMLPath(), base.ShortHash(), desc)
+		return
+	}
+	fmt.Print(diff)
+}
+
+// doApprove shows how a job's workflow file differs from the version last
+// approved and, once confirmed, approves it so the daemon runs it again.
+func doApprove(args []string) {
+	fs := newFlagSet("approve")
+	yesFlag := fs.Bool("yes", false, "approve without asking")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Println("Usage: devagent approve <job> [--yes]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("lookup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("%v: %s\n", store.ErrJobNotFound, name)
+		exit(exitConfig)
+	}
+	content, err := os.ReadFile(job.YAMLPath())
+	if err != nil {
+		fmt.Printf("read workflow: %v\n", err)
+		exit(exitConfig)
+	}
+	wf, err := dsl.Parse(content)
+	if err != nil {
+		fmt.Printf("invalid workflow %s: %v\n", job.YAMLPath(), err)
+		exit(exitConfig)
+	}
+	if wf.Name != name {
+		fmt.Printf("%s now declares job %s; run `devagent doctor --reconcile` first\n", job.YAMLPath(), wf.Name)
+		exit(exitConfig)
+	}
+	hash := dsl.Hash(content)
+	if job.ApprovedHash == hash {
+		fmt.Printf("%s is already approved (%.12s)\n", job.YAMLPath(), hash)
+		return
+	}
+
+	base, from := "", name+" (never approved)"
+	if job.ApprovedHash != "" {
+		if rev, err := st.FindRevision(ctx, name, job.ApprovedHash); err == nil {
+			base = rev.Content
+			from = fmt.Sprintf("%s@%s (approved %s)", name, rev.ShortHash(), rev.CreatedAt.Local().Format(time.RFC3339))
+		}
+	}
+	fmt.Print(textdiff.Unified(base, string(content), from, job.YAMLPath()))
+	flagged, err := reviewSteps(wf, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		exit(exitConfig)
+	}
+	if !*yesFlag {
+		if !isTerminal(os.Stdin) {
+			fmt.Println("review the change and pass --yes to approve it")
+			exit(exitConfig)
+		}
+		if flagged && !confirmNo("these steps need confirmation; approve anyway? [y/N] ") ||
+			!flagged && !confirm("approve this workflow? [Y/n] ") {
+			fmt.Println("not approved")
+			return
+		}
+	}
+	if err := st.ApproveWorkflow(ctx, name, content, "approve"); err != nil {
+		fmt.Printf("approve error: %v\n", err)
+		exit(exitInfra)
+	}
+	fmt.Printf("approved %s (%.12s)\n", name, hash)
+}
+
+// doDiffRuns compares two runs of a job: durations, exit codes, published
+// outputs and the files left in the run directories. Runs are given by run
+// ID or run directory name; without them the last two runs are compared.
+func doDiffRuns(args []string) {
+	fs := newFlagSet("diff-runs")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 && len(positional) != 3 {
+		fmt.Println("Usage: devagent diff-runs <job> [<run-a> <run-b>]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("lookup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("%v: %s\n", store.ErrJobNotFound, name)
+		exit(exitConfig)
+	}
+	wf, err := dsl.Load(job.YAMLPath())
+	if err != nil {
+		fmt.Printf("load error: %v\n", err)
+		exit(exitConfig)
+	}
+	repo, err := wf.ExpandRepo()
+	if err != nil {
+		fmt.Printf("config error: %v\n", err)
+		exit(exitConfig)
+	}
+
+	var a, b *runner.Summary
+	if len(positional) == 1 {
+		recent := runner.RecentRuns(repo, name, 2)
+		if len(recent) < 2 {
+			fmt.Printf("%s has fewer than two runs in %s\n", name, filepath.Join(repo, "devagent_runs"))
+			exit(exitConfig)
+		}
+		a, b = recent[1], recent[0]
+	} else {
+		for i, ref := range positional[1:] {
+			summary, err := findRunSummary(ctx, st, name, repo, ref)
+			if err != nil {
+				fmt.Printf("run error: %v\n", err)
+				exit(exitConfig)
+			}
+			if i == 0 {
+				a = summary
+			} else {
+				b = summary
+			}
+		}
+	}
+	fmt.Print(runner.DiffRuns(a, b))
+}
+
+// findRunSummary loads the summary of job's run ref, a run ID or the name
+// of a directory under the repo's devagent_runs.
+func findRunSummary(ctx context.Context, st *store.Store, job, repo, ref string) (*runner.Summary, error) {
+	runDir := filepath.Join(repo, "devagent_runs", filepath.Base(ref))
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		run, err := st.GetRun(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if run == nil || run.Job != job {
+			return nil, fmt.Errorf("run %d of %s not found", id, job)
+		}
+		if run.RunDir == "" {
+			return nil, fmt.Errorf("run %d has no run directory", id)
+		}
+		runDir = run.RunDir
+	}
+	summary, err := runner.LoadSummary(runDir)
+	if err != nil {
+		return nil, fmt.Errorf("run %s of %s: %w", ref, job, err)
+	}
+	if summary.Name != job {
+		return nil, fmt.Errorf("run %s belongs to %s, not %s", ref, summary.Name, job)
+	}
+	return summary, nil
+}
+
+// doWhy gathers the evidence about a job's last failed run and asks the
+// planner's LLM for a probable cause and fix. Without an API key it prints
+// the evidence alone.
+func doWhy(args []string) {
+	fs := newFlagSet("why")
+	var (
+		modelFlag   = fs.String("model", "", "planner model")
+		baseURLFlag = fs.String("base-url", "", "planner base URL")
+		timeoutFlag = fs.Duration("timeout", planner.DefaultTimeout, "how long a request to the planner's model may take, retries included")
+		apiFlag     = fs.String("api", planner.APIAuto, "API of the planner's server: responses, chat (/chat/completions) or auto, which falls back to chat when the server has no responses API")
+		reportFlag  = fs.Bool("report", false, "also print the evidence sent to the model")
+	)
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Println("Usage: devagent why <job> [--report]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("lookup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("%v: %s\n", store.ErrJobNotFound, name)
+		exit(exitConfig)
+	}
+	run, err := st.LastFailedRun(ctx, name)
+	if err != nil {
+		fmt.Printf("history error: %v\n", err)
+		exit(exitInfra)
+	}
+	if run == nil {
+		fmt.Printf("no failed runs recorded for %s\n", name)
+		return
+	}
+
+	report := diagnose.Report(ctx, st, *job, run)
+	fmt.Printf("run %d of %s ended with status %s at %s\n", run.ID, name, run.Status, run.StartedAt.Local().Format(time.RFC3339))
+
+	apiKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+	if apiKey == "" {
+		warnf("OPENAI_API_KEY not set; printing the gathered evidence without a diagnosis")
+		fmt.Print("\n" + report)
+		return
+	}
+	if *reportFlag {
+		fmt.Print("\n" + report + "\n")
+	}
+	diag, err := planner.Diagnose(ctx, report, planner.Options{
+		APIKey:  apiKey,
+		Model:   *modelFlag,
+		BaseURL: *baseURLFlag,
+		Timeout: *timeoutFlag,
+		API:     *apiFlag,
+	})
+	if err != nil {
+		fmt.Printf("diagnosis error: %v\n", err)
+		exit(exitInfra)
+	}
+	fmt.Printf("\nProbable cause:\n  %s\n", strings.TrimSpace(diag.Cause))
+	if fix := strings.TrimSpace(diag.Fix); fix != "" {
+		fmt.Printf("\nSuggested fix:\n  %s\n", fix)
+	}
+}
+
+// doHeal asks the planner's model for remediation commands for a job's last
+// failed run and, once approved, runs them and re-runs the job.
+func doHeal(args []string) {
+	fs := newFlagSet("heal")
+	var (
+		modelFlag   = fs.String("model", "", "planner model")
+		baseURLFlag = fs.String("base-url", "", "planner base URL")
+		timeoutFlag = fs.Duration("timeout", planner.DefaultTimeout, "how long a request to the planner's model may take, retries included")
+		apiFlag     = fs.String("api", planner.APIAuto, "API of the planner's server: responses, chat (/chat/completions) or auto, which falls back to chat when the server has no responses API")
+		yesFlag     = fs.Bool("yes", false, "run the proposed commands without asking")
+	)
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Println("Usage: devagent heal <job> [--yes]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("lookup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("%v: %s\n", store.ErrJobNotFound, name)
+		exit(exitConfig)
+	}
+	wf, err := dsl.Load(job.YAMLPath())
+	if err != nil {
+		fmt.Printf("load error: %v\n", err)
+		exit(exitConfig)
+	}
+	if wf.Heal == nil {
+		fmt.Printf("self-healing is not enabled for %s; add a heal block to %s\n", name, job.YAMLPath())
+		exit(exitConfig)
+	}
+	run, err := st.LastFailedRun(ctx, name)
+	if err != nil {
+		fmt.Printf("history error: %v\n", err)
+		exit(exitInfra)
+	}
+	if run == nil {
+		fmt.Printf("no failed runs recorded for %s\n", name)
+		return
+	}
+	apiKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+	if apiKey == "" {
+		fmt.Println("devagent heal requires OPENAI_API_KEY")
+		exit(exitConfig)
+	}
+
+	fix, err := planner.ProposeFix(ctx, diagnose.Report(ctx, st, *job, run), planner.Options{
+		APIKey:  apiKey,
+		Model:   *modelFlag,
+		BaseURL: *baseURLFlag,
+		Timeout: *timeoutFlag,
+		API:     *apiFlag,
+	})
+	if err != nil {
+		fmt.Printf("heal error: %v\n", err)
+		exit(exitInfra)
+	}
+	pol, err := policy.Load()
+	if err != nil {
+		fmt.Printf("policy error: %v\n", err)
+		exit(exitConfig)
+	}
+	repo, _ := wf.ExpandRepo()
+	fmt.Printf("run %d of %s failed: %s\n\nProposed fix:\n", run.ID, name, strings.TrimSpace(fix.Cause))
+	denied := false
+	for _, command := range fix.Steps {
+		mark := "needs approval"
+		if d := pol.Check(command, repo); d.Action == policy.Deny {
+			mark = "denied by policy: " + d.Reason
+			denied = true
+		} else if d.Action == policy.Confirm {
+			mark = "needs approval: " + d.Reason
+		} else if wf.Heal.Allows(command) {
+			mark = "allowed"
+		}
+		fmt.Printf("  $ %s\t(%s)\n", command, mark)
+	}
+	if denied {
+		fmt.Println("fix refused by policy")
+		exit(exitConfig)
+	}
+	if !*yesFlag && (!isTerminal(os.Stdin) || !confirm(fmt.Sprintf("run these commands and re-run %s? [Y/n] ", name))) {
+		fmt.Println("fix not applied")
+		return
+	}
+
+	var failed *runner.Summary
+	if run.RunDir != "" {
+		failed, _ = runner.LoadSummary(run.RunDir)
+	}
+	healCtx, stop := signalContext()
+	_, ok, err := runner.Heal(healCtx, wf, failed, fix.Steps, os.Stdout)
+	stop()
+	if err != nil {
+		fmt.Printf("heal error: %v\n", err)
+		var cfgErr *runner.ConfigError
+		if errors.As(err, &cfgErr) {
+			exit(exitConfig)
+		}
+		exit(exitInfra)
+	}
+	if !ok {
+		fmt.Println("fix failed; not re-running")
+		exit(exitStepFailure)
+	}
+	doRun([]string{name})
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, falling back to vi.
+func runEditor(path string) error {
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "devagent-edit", path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func doSchedule(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: devagent schedule <list|remove|pause|resume|rename|move|set>")
+		exit(exitConfig)
+	}
+	sub := args[0]
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	switch sub {
+	case "list":
+		fs := newFlagSet("schedule list")
+		statusFlag := fs.String("status", "", "only jobs whose last run ended with this status (success, failed, ...), or paused or never")
+		jsonFlag := fs.Bool("json", globals.json, "print the jobs as JSON")
+		patterns := parseArgs(fs, args[1:])
+		jobs, _ := selectJobs(st, patterns, *statusFlag)
+		if *jsonFlag {
+			rows := make([]api.JobStatus, 0, len(jobs))
+			for _, job := range jobs {
+				rows = append(rows, api.StatusFromJob(job))
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(rows)
+			return
+		}
+		if len(jobs) == 0 {
+			if len(patterns) > 0 || *statusFlag != "" {
+				fmt.Println("no matching jobs")
+			} else {
+				fmt.Println("no jobs scheduled")
+			}
+			return
+		}
+		for _, job := range jobs {
+			last := "never"
+			if job.LastRun.Valid {
+				last = job.LastRun.Time.Format(time.RFC3339)
+			}
+			status := "unknown"
+			if job.LastStatus.Valid {
+				status = job.LastStatus.String
+			}
+			line := fmt.Sprintf("%s\t%s\t%s\tlast=%s", job.Name, job.Repo, scheduleDesc(job), fmt.Sprintf("%s (%s)", last, status))
+			if job.FailureStreak > 0 {
+				line += fmt.Sprintf("\tfailing=%d", job.FailureStreak)
+			}
+			if job.Paused {
+				line += "\t" + pausedLabel(job)
+			}
+			fmt.Println(line)
+		}
+	case "remove", "pause", "resume":
+		fs := newFlagSet("schedule " + sub)
+		allFlag := fs.Bool("all", false, "apply to every job (narrowed by --status)")
+		statusFlag := fs.String("status", "", "only jobs whose last run ended with this status, or paused or never")
+		yesFlag := fs.Bool("yes", false, "remove several jobs without asking")
+		patterns := parseArgs(fs, args[1:])
+		if len(patterns) == 0 && !*allFlag {
+			fmt.Printf("provide job names or patterns such as 'nightly-*' to %s, or --all\n", sub)
+			exit(exitConfig)
+		}
+		if len(patterns) > 0 && *allFlag {
+			fmt.Println("--all cannot be combined with job names")
+			exit(exitConfig)
+		}
+		jobs, unmatched := selectJobs(st, patterns, *statusFlag)
+		if len(unmatched) > 0 {
+			fmt.Printf("no job matches %s\n", strings.Join(unmatched, ", "))
+			exit(exitConfig)
+		}
+		if len(jobs) == 0 {
+			fmt.Println("no matching jobs")
+			return
+		}
+		names := make([]string, len(jobs))
+		for i, job := range jobs {
+			names[i] = job.Name
+		}
+		var err error
+		if sub == "remove" {
+			if len(names) > 1 && !*yesFlag {
+				if !isTerminal(os.Stdin) {
+					fmt.Printf("this removes %d jobs (%s); pass --yes to confirm\n", len(names), strings.Join(names, ", "))
+					exit(exitConfig)
+				}
+				if !confirmNo(fmt.Sprintf("remove %d jobs (%s)? [y/N] ", len(names), strings.Join(names, ", "))) {
+					fmt.Println("nothing removed")
+					return
+				}
+			}
+			err = st.RemoveJobs(context.Background(), names)
+		} else {
+			err = st.SetPausedJobs(context.Background(), names, sub == "pause")
+		}
+		if err != nil {
+			fmt.Printf("%s error: %v\n", sub, err)
+			exit(exitInfra)
+		}
+		verb := map[string]string{"remove": "removed", "pause": "paused", "resume": "resumed"}[sub]
+		for _, name := range names {
+			fmt.Println(verb, name)
+		}
+	case "rename":
+		scheduleRename(st, args[1:])
+	case "move":
+		scheduleMove(st, args[1:])
+	case "set":
+		scheduleSet(st, args[1:])
+	default:
+		fmt.Println("Usage: devagent schedule <list|remove|pause|resume|rename|move|set>")
+		exit(exitConfig)
+	}
+}
+
+// selectJobs returns the registered jobs, in store order, whose names
+// match any of patterns (shell globs such as 'nightly-*'; all jobs when
+// there are none) and whose status is status, if set. It also returns the
+// patterns that matched no job.
+func selectJobs(st *store.Store, patterns []string, status string) ([]store.Job, []string) {
+	jobs, err := st.ListJobs(context.Background())
+	if err != nil {
+		fmt.Printf("list error: %v\n", err)
+		exit(exitInfra)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Printf("invalid pattern %q\n", pattern)
+			exit(exitConfig)
+		}
+	}
+	matched := make([]bool, len(patterns))
+	var selected []store.Job
+	for _, job := range jobs {
+		hit := len(patterns) == 0
+		for i, pattern := range patterns {
+			if ok, _ := path.Match(pattern, job.Name); ok {
+				matched[i] = true
+				hit = true
+			}
+		}
+		if hit && hasStatus(job, status) {
+			selected = append(selected, job)
+		}
+	}
+	var unmatched []string
+	for i, pattern := range patterns {
+		if !matched[i] {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+	return selected, unmatched
+}
+
+// hasStatus reports whether the job's last run ended with status; "paused"
+// matches paused jobs, "never" jobs that have not run and "" every job.
+func hasStatus(job store.Job, status string) bool {
+	switch status {
+	case "":
+		return true
+	case "paused":
+		return job.Paused
+	case "never":
+		return !job.LastRun.Valid
+	}
+	return job.LastStatus.Valid && job.LastStatus.String == status
+}
+
+// scheduleRename renames a job in the store, its workflow file, the
+// workflow files of the jobs that run after it, its lock, caches, worktree
+// and run summaries.
+func scheduleRename(st *store.Store, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: devagent schedule rename <old> <new>")
+		exit(exitConfig)
+	}
+	ctx := context.Background()
+	oldName, newName := args[0], strings.TrimSpace(args[1])
+	job, err := st.GetJob(ctx, oldName)
+	if err != nil {
+		fmt.Printf("rename error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("unknown job %s\n", oldName)
+		exit(exitConfig)
+	}
+	if newName == "" || newName == oldName {
+		fmt.Println("provide a new, different job name")
+		exit(exitConfig)
+	}
+	content, err := os.ReadFile(job.YAMLPath())
+	if err != nil {
+		fmt.Printf("rename error: %v\n", err)
+		exit(exitInfra)
+	}
+	renamed, err := dsl.SetField(content, newName, "name")
+	if err == nil {
+		_, err = dsl.Parse(renamed)
+	}
+	if err != nil {
+		fmt.Printf("rename error: %s: %v\n", job.YAMLPath(), err)
+		exit(exitConfig)
+	}
+
+	// The lock moves first: it fails while the job runs.
+	if err := scheduler.RenameLock(oldName, newName); err != nil {
+		fmt.Printf("rename error: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := st.RenameJob(ctx, oldName, newName); err != nil {
+		_ = scheduler.RenameLock(newName, oldName)
+		fmt.Printf("rename error: %v\n", err)
+		if errors.Is(err, store.ErrJobExists) {
+			exit(exitConfig)
+		}
+		exit(exitInfra)
+	}
+	if err := os.WriteFile(job.YAMLPath(), renamed, 0o644); err != nil {
+		_ = st.RenameJob(ctx, newName, oldName)
+		_ = scheduler.RenameLock(newName, oldName)
+		fmt.Printf("rename error: %v\n", err)
+		exit(exitInfra)
+	}
+	keepApproval(st, *job, newName, content, job.YAMLPath(), "rename")
+
+	jobs, err := st.ListJobs(ctx)
+	if err != nil {
+		warnf("could not update dependent jobs: %v", err)
+	}
+	for _, dependent := range jobs {
+		if dependent.After() != newName {
+			continue
+		}
+		before, err := os.ReadFile(dependent.YAMLPath())
+		var data []byte
+		if err == nil {
+			data, err = dsl.SetField(before, newName, "schedule", "after")
+		}
+		if err == nil {
+			err = os.WriteFile(dependent.YAMLPath(), data, 0o644)
+		}
+		if err != nil {
+			warnf("%s runs after %s but its workflow could not be updated: %v", dependent.Name, oldName, err)
+			continue
+		}
+		keepApproval(st, dependent, dependent.Name, before, dependent.YAMLPath(), "rename")
+		fmt.Printf("updated %s to run after %s\n", dependent.Name, newName)
+	}
+
+	if err := runner.RenameCache(oldName, newName); err != nil {
+		warnf("could not move the step caches: %v", err)
+	}
+	if err := runner.MoveWorktree(ctx, job.Repo, oldName, newName); err != nil {
+		warnf("could not move the worktree, the next run recreates it: %v", err)
+	}
+	if _, err := runner.RenameRuns(job.Repo, oldName, newName); err != nil {
+		warnf("could not update the run summaries: %v", err)
+	}
+	fmt.Printf("renamed %s to %s\n", oldName, newName)
+}
+
+// scheduleMove points a job at a repo that moved, taking its workflow file
+// along when it lived inside the old repo.
+func scheduleMove(st *store.Store, args []string) {
+	fs := newFlagSet("schedule move")
+	repoFlag := fs.String("repo", "", "the repo's new path")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 || *repoFlag == "" {
+		fmt.Println("Usage: devagent schedule move <job> --repo <path>")
+		exit(exitConfig)
+	}
+	ctx := context.Background()
+	name := positional[0]
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("move error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("unknown job %s\n", name)
+		exit(exitConfig)
+	}
+	repo, err := filepath.Abs(*repoFlag)
+	if err != nil {
+		fmt.Printf("move error: %v\n", err)
+		exit(exitConfig)
+	}
+	if info, err := os.Stat(repo); err != nil || !info.IsDir() {
+		fmt.Printf("%s is not a directory\n", repo)
+		exit(exitConfig)
+	}
+
+	yamlPath := job.YAMLPath()
+	if rel, err := filepath.Rel(job.Repo, yamlPath); err == nil && !strings.HasPrefix(rel, "..") {
+		yamlPath = filepath.Join(repo, rel)
+	}
+	content, err := os.ReadFile(yamlPath)
+	if os.IsNotExist(err) {
+		// The repo was copied rather than moved, or the file is untracked.
+		content, err = os.ReadFile(job.YAMLPath())
+	}
+	if err != nil {
+		fmt.Printf("move error: %v\n", err)
+		exit(exitInfra)
+	}
+	moved, err := dsl.SetField(content, repo, "repo")
+	if err == nil {
+		_, err = dsl.Parse(moved)
+	}
+	if err != nil {
+		fmt.Printf("move error: %s: %v\n", job.YAMLPath(), err)
+		exit(exitConfig)
+	}
+	if err := os.MkdirAll(filepath.Dir(yamlPath), 0o755); err != nil {
+		fmt.Printf("move error: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := os.WriteFile(yamlPath, moved, 0o644); err != nil {
+		fmt.Printf("move error: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := st.MoveJob(ctx, name, repo, yamlPath); err != nil {
+		fmt.Printf("move error: %v\n", err)
+		exit(exitInfra)
+	}
+	keepApproval(st, *job, name, content, yamlPath, "move")
+	fmt.Printf("moved %s to %s\n", name, repo)
+}
+
+// scheduleSet changes when a job runs from a description such as "every
+// weekday at 7:30am Berlin time" or a cron expression, in its workflow file
+// and the store together.
+func scheduleSet(st *store.Store, args []string) {
+	fs := newFlagSet("schedule set")
+	var (
+		tzFlag       = fs.String("timezone", "", "time zone of the schedule (default: the one the description names, else the job's)")
+		modelFlag    = fs.String("model", "", "planner model")
+		baseURLFlag  = fs.String("base-url", "", "planner base URL")
+		timeoutFlag  = fs.Duration("timeout", planner.DefaultTimeout, "how long a request to the planner's model may take, retries included")
+		apiFlag      = fs.String("api", planner.APIAuto, "API of the planner's server: responses, chat (/chat/completions) or auto, which falls back to chat when the server has no responses API")
+		repromptFlag = fs.Int("reprompts", planner.DefaultReprompts, "how many times to send an invalid schedule back to the model with its problems")
+	)
+	positional := parseArgs(fs, args)
+	if len(positional) != 2 {
+		fmt.Println(`Usage: devagent schedule set <job> "<schedule>"`)
+		exit(exitConfig)
+	}
+	ctx := context.Background()
+	name := positional[0]
+	job, err := st.GetJob(ctx, name)
+	if err != nil {
+		fmt.Printf("schedule error: %v\n", err)
+		exit(exitInfra)
+	}
+	if job == nil {
+		fmt.Printf("unknown job %s\n", name)
+		exit(exitConfig)
+	}
+	path := job.YAMLPath()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("schedule error: %v\n", err)
+		exit(exitInfra)
+	}
+	wf, err := dsl.Parse(content)
+	if err != nil {
+		fmt.Printf("schedule error: %s: %v\n", path, err)
+		exit(exitConfig)
+	}
+
+	sched, err := planner.PlanSchedule(ctx, positional[1], planner.Options{
+		Timezone:  *tzFlag,
+		APIKey:    loadAPIKey(),
+		Model:     *modelFlag,
+		BaseURL:   *baseURLFlag,
+		Timeout:   *timeoutFlag,
+		API:       *apiFlag,
+		Reprompts: reprompts(*repromptFlag),
+	})
+	if err != nil {
+		fmt.Printf("planner error: %v\n", err)
+		exit(exitConfig)
+	}
+	if sched.Fallback != nil {
+		warnf("planner model: %v; falling back to heuristic planning", sched.Fallback)
+	}
+
+	// The new timing replaces every other way the job was scheduled,
+	// including running after another job; the rest of the schedule, such
+	// as backoff and requirements, is kept.
+	updated := content
+	for _, field := range []struct{ key, value string }{
+		{"natural", sched.Natural},
+		{"cron", sched.Cron},
+		{"at", sched.At},
+		{"every", sched.Every},
+		{"calendar", ""},
+		{"after", ""},
+		{"timezone", sched.Timezone},
+	} {
+		switch {
+		case field.value != "":
+			updated, err = dsl.SetField(updated, field.value, "schedule", field.key)
+		case field.key == "cron":
+			// cron is always written, as new and init write it.
+			updated, err = dsl.SetField(updated, "", "schedule", field.key)
+		case field.key != "timezone":
+			updated, err = dsl.RemoveField(updated, "schedule", field.key)
+		}
+		if err != nil {
+			fmt.Printf("schedule error: %s: %v\n", path, err)
+			exit(exitConfig)
+		}
+	}
+	proposed, err := dsl.Parse(updated)
+	if err == nil {
+		err = scheduler.ValidateSchedule(proposed.Schedule)
+	}
+	if err != nil {
+		fmt.Printf("invalid schedule: %v\n", err)
+		exit(exitConfig)
+	}
+
+	// The file is written aside and renamed into place once the store has
+	// the new schedule, which is put back if the rename fails, so the two
+	// never disagree.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, updated, 0o644); err != nil {
+		fmt.Printf("schedule error: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := st.UpsertJob(ctx, store.JobFromWorkflow(proposed, path)); err != nil {
+		os.Remove(tmp)
+		fmt.Printf("schedule error: %v\n", err)
+		exit(exitInfra)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = st.UpsertJob(ctx, *job)
+		os.Remove(tmp)
+		fmt.Printf("schedule error: %v\n", err)
+		exit(exitInfra)
+	}
+	keepApproval(st, *job, name, content, path, "schedule")
+
+	if wf.Schedule.After != "" {
+		fmt.Printf("%s no longer runs after %s\n", name, wf.Schedule.After)
+	}
+	changed := store.JobFromWorkflow(proposed, path)
+	desc := scheduleDesc(changed)
+	if tz := changed.Timezone(); tz != "" {
+		desc += " timezone=" + tz
+	}
+	fmt.Printf("%s now runs %s (was %s)\n", name, desc, scheduleDesc(*job))
+}
+
+func doStatus(args []string) {
+	fs := newFlagSet("status")
+	allFlag := fs.Bool("all", false, "include jobs from remote daemons listed in remotes.yml")
+	jsonFlag := fs.Bool("json", globals.json, "print the jobs as JSON")
+	fs.Parse(args)
+
+	if *allFlag {
+		doFleetStatus(*jsonFlag)
+		return
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(1)
+	}
+	defer st.Close()
+
+	jobs, err := st.ListJobs(context.Background())
+	if err != nil {
+		fmt.Printf("list error: %v\n", err)
+		exit(1)
+	}
+	// With --json, stdout carries only the jobs.
+	out := os.Stdout
+	if *jsonFlag {
+		out = os.Stderr
+	}
+	defer func() {
+		if conflict, err := scheduler.DaemonConflict(context.Background(), st); err == nil && conflict != "" {
+			fmt.Fprintf(out, "daemon conflict: %s\n", conflict)
+		}
+	}()
+	running, _ := api.RunningByJob(context.Background(), st)
+	if *jsonFlag {
+		rows := make([]api.JobStatus, 0, len(jobs))
+		for _, job := range jobs {
+			status := api.StatusFromJob(job)
+			if p, ok := running[job.Name]; ok {
+				status.Running = &p
+			}
+			rows = append(rows, status)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rows)
+		return
+	}
+	if len(jobs) == 0 {
+		fmt.Println("no jobs scheduled")
+		return
+	}
+
+	known := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		known[job.Name] = true
+	}
+	line := func(job store.Job) string {
+		if p, ok := running[job.Name]; ok {
+			return statusLine(job) + "\t" + p.Describe(time.Now())
+		}
+		return statusLine(job)
+	}
+	printed := make(map[string]bool, len(jobs))
+	var printTree func(job store.Job, prefix, branch string)
+	printTree = func(job store.Job, prefix, branch string) {
+		if printed[job.Name] {
+			return
+		}
+		printed[job.Name] = true
+		fmt.Printf("%s%s%s\n", prefix, branch, line(job))
+		childPrefix := prefix
+		switch branch {
+		case "├── ":
+			childPrefix += "│   "
+		case "└── ":
+			childPrefix += "    "
+		}
+		children := scheduler.Dependents(jobs, job.Name)
+		for i, child := range children {
+			next := "├── "
+			if i == len(children)-1 {
+				next = "└── "
+			}
+			printTree(child, childPrefix, next)
+		}
+	}
+	for _, job := range jobs {
+		if job.After() == "" || !known[job.After()] {
+			printTree(job, "", "")
+		}
+	}
+	if cycle := scheduler.FindCycle(jobs); len(cycle) > 0 {
+		fmt.Printf("dependency cycle: %s\n", strings.Join(cycle, " -> "))
+	}
+	for _, job := range jobs {
+		if !printed[job.Name] {
+			fmt.Printf("%s (in cycle)\n", line(job))
+		}
+	}
+}
+
+// doTop shows the jobs and their runs in progress in a terminal UI that
+// refreshes itself, with keys to run, pause and resume jobs and read logs.
+func doTop(args []string) {
+	fs := newFlagSet("top")
+	refreshFlag := fs.Duration("refresh", time.Second, "how often to reload the jobs")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Println("Usage: devagent top [--refresh duration]")
+		exit(exitConfig)
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	opts := top.Options{
+		Refresh: *refreshFlag,
+		In:      os.Stdin,
+		Out:     os.Stdout,
+		Load: func(ctx context.Context) ([]top.Job, error) {
+			jobs, err := st.ListJobs(ctx)
+			if err != nil {
+				return nil, err
+			}
+			running, err := api.RunningByJob(ctx, st)
+			if err != nil {
+				return nil, err
+			}
+			now := time.Now()
+			out := make([]top.Job, 0, len(jobs))
+			for _, job := range jobs {
+				row := top.Job{JobStatus: api.StatusFromJob(job), Schedule: scheduleDesc(job)}
+				if p, ok := running[job.Name]; ok {
+					row.Running = &p
+				}
+				row.Next, _ = scheduler.NextFire(job, now)
+				out = append(out, row)
+			}
+			return out, nil
+		},
+		Trigger: func(name string) (string, error) {
+			running, err := api.RunningByJob(context.Background(), st)
+			if err != nil {
+				return "", err
+			}
+			if _, ok := running[name]; ok {
+				return "", errors.New("it is already running")
+			}
+			// The run outlives top in its own session; it records its
+			// progress in the store like any other run.
+			binary, err := os.Executable()
+			if err != nil {
+				binary = "devagent"
+			}
+			cmd := exec.Command(binary, "--quiet", "run", name)
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+			if err := cmd.Start(); err != nil {
+				return "", err
+			}
+			pid := cmd.Process.Pid
+			go cmd.Wait()
+			return fmt.Sprintf("started %s (pid %d)", name, pid), nil
+		},
+		SetPaused: func(name string, paused bool) error {
+			return st.SetPaused(context.Background(), name, paused)
+		},
+		OpenLogs: func(name string) error {
+			job, err := st.GetJob(context.Background(), name)
+			if err != nil {
+				return err
+			}
+			runs := runner.RecentRuns(job.Repo, name, 1)
+			if len(runs) == 0 {
+				return errors.New("no runs yet")
+			}
+			return pageLog(runs[0].RunDir, runs[0].Status == runner.StatusRunning)
+		},
+	}
+	if err := top.Run(context.Background(), opts); err != nil {
+		fmt.Printf("top: %v\n", err)
+		exit(exitConfig)
+	}
+}
+
+// pageLog shows the log of the run in runDir in $PAGER, less by default,
+// following it as it grows when follow is set. A running run's log is
+// never compressed, so it is followed as a file; a finished one is read
+// through runner.OpenRunLog, which decompresses an archived log.
+func pageLog(runDir string, follow bool) error {
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less", "-R"}
+	}
+	if filepath.Base(pager[0]) == "less" {
+		if follow {
+			pager = append(pager, "+F")
+		} else {
+			pager = append(pager, "+G")
+		}
+	}
+	if follow {
+		cmd := exec.Command(pager[0], append(pager[1:], filepath.Join(runDir, "run.log"))...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+	log, err := runner.OpenRunLog(runDir)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = log, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// doFleetStatus prints one table with the local jobs and those of every
+// configured remote daemon, or with asJSON the jobs as JSON. Unreachable
+// remotes are reported, not fatal.
+func doFleetStatus(asJSON bool) {
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(1)
+	}
+	defer st.Close()
+
+	jobs, err := st.ListJobs(context.Background())
+	if err != nil {
+		fmt.Printf("list error: %v\n", err)
+		exit(1)
+	}
+	var rows []api.JobStatus
+	running, _ := api.RunningByJob(context.Background(), st)
+	for _, job := range jobs {
+		status := api.StatusFromJob(job)
+		status.Host = "local"
+		if p, ok := running[job.Name]; ok {
+			status.Running = &p
+		}
+		rows = append(rows, status)
+	}
+
+	remotes, err := api.LoadRemotes()
+	if err != nil {
+		fmt.Printf("remotes error: %v\n", err)
+		exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	var unreachable []string
+	for _, remote := range remotes {
+		remoteJobs, err := api.FetchJobs(ctx, nil, remote)
+		if err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s (%s): %v", remote.Name, remote.URL, err))
+			continue
+		}
+		rows = append(rows, remoteJobs...)
+	}
+
+	if asJSON {
+		if rows == nil {
+			rows = []api.JobStatus{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rows)
+		for _, line := range unreachable {
+			fmt.Fprintf(os.Stderr, "unreachable: %s\n", line)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tJOB\tHEALTH\tLAST RUN\tSTATUS\tFAILING")
+	for _, row := range rows {
+		last := "never"
+		if row.LastRun != nil {
+			last = row.LastRun.Local().Format(time.RFC3339)
+		}
+		status := row.LastStatus
+		if status == "" {
+			status = "unknown"
+		}
+		if row.Running != nil {
+			status = row.Running.Describe(time.Now())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", row.Host, row.Name, row.Health(), last, status, row.FailureStreak)
+	}
+	w.Flush()
+	for _, line := range unreachable {
+		fmt.Printf("unreachable: %s\n", line)
+	}
+}
+
+// doCancel asks the process running a job to stop it. The request is
+// recorded in the store and the owner (the daemon or a `devagent run`) is
+// signalled so it cancels right away.
+func doCancel(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: devagent cancel <job|run-id>")
+		exit(1)
+	}
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(1)
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	run, err := st.FindRunningRun(ctx, args[0])
+	if err != nil {
+		fmt.Printf("cancel error: %v\n", err)
+		exit(1)
+	}
+	if run == nil {
+		fmt.Printf("no running run matches %s\n", args[0])
+		exit(1)
+	}
+	if err := st.RequestCancel(ctx, run.ID); err != nil {
+		fmt.Printf("cancel error: %v\n", err)
+		exit(1)
+	}
+	if err := syscall.Kill(run.PID, store.CancelSignal); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			_ = st.MarkRunInterrupted(ctx, run.ID)
+			fmt.Printf("run %d (%s) is no longer alive; marked interrupted\n", run.ID, run.Job)
+			return
+		}
+		fmt.Printf("signal pid %d: %v\n", run.PID, err)
+		exit(1)
+	}
+	fmt.Printf("cancel requested for run %d (%s, pid %d)\n", run.ID, run.Job, run.PID)
+}
+
+// doUsage prints the CPU time, peak memory and disk writes of each job's
+// runs over the last few days, heaviest first.
+func doUsage(args []string) {
+	fs := newFlagSet("usage")
+	daysFlag := fs.Int("days", 7, "number of days to include")
+	fs.Parse(args)
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(1)
+	}
+	defer st.Close()
+
+	usage, err := st.UsageByJob(context.Background(), time.Now().AddDate(0, 0, -*daysFlag))
+	if err != nil {
+		fmt.Printf("usage error: %v\n", err)
+		exit(1)
+	}
+	if len(usage) == 0 {
+		fmt.Printf("no runs in the last %d days\n", *daysFlag)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tRUNS\tCPU\tPEAK RSS\tWRITTEN")
+	for _, u := range usage {
+		cpu := time.Duration(u.Usage.CPUSec * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", u.Job, u.Runs, cpu, formatBytes(u.Usage.MaxRSSBytes), formatBytes(u.Usage.WrittenBytes))
+	}
+	w.Flush()
+}
+
+// doHolidays lists the built-in holiday calendars, or the holidays of one
+// calendar in a year.
+func doHolidays(args []string) {
+	fs := newFlagSet("holidays")
+	yearFlag := fs.Int("year", time.Now().Year(), "year to list")
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		fmt.Println("Usage: devagent holidays [calendar] [--year N]")
+		exit(exitConfig)
+	}
+	if len(positional) == 0 {
+		fmt.Printf("built-in calendars: %s\n", strings.Join(holidays.Builtin(), ", "))
+		return
+	}
+	wd, _ := os.Getwd()
+	cal, err := holidays.Load(positional[0], wd)
+	if err != nil {
+		fmt.Println(err)
+		exit(exitConfig)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, h := range cal.Year(*yearFlag) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", h.Date.Format("2006-01-02"), h.Date.Weekday().String()[:3], h.Name)
+	}
+	w.Flush()
+}
+
+// doPlugins lists the step and notify plugins found in the plugins
+// directory and on PATH.
+func doPlugins(args []string) {
+	fs := newFlagSet("plugins")
+	jsonFlag := fs.Bool("json", globals.json, "print the plugins as JSON")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		fmt.Println("Usage: devagent plugins [--json]")
+		exit(exitConfig)
+	}
+	plugins, err := plugin.List()
+	if err != nil {
+		fmt.Printf("plugins error: %v\n", err)
+		exit(exitInfra)
+	}
+	if *jsonFlag {
+		if plugins == nil {
+			plugins = []plugin.Plugin{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(plugins)
+		return
+	}
+	if len(plugins) == 0 {
+		dir, _ := plugin.Dir()
+		fmt.Printf("no plugins installed; add devagent-step-<name> or devagent-notify-<name> to %s or PATH\n", dir)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tPATH")
+	for _, p := range plugins {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Kind, p.Name, p.Path)
+	}
+	w.Flush()
+}
+
+// doAudit prints the audit log: who created, changed, paused or ran which
+// job, and when.
+func doAudit(args []string) {
+	fs := newFlagSet("audit")
+	jsonFlag := fs.Bool("json", globals.json, "print the entries as JSON")
+	actionFlag := fs.String("action", "", "only this action (e.g. job.pause), or kind of action (job or run)")
+	daysFlag := fs.Int("days", 0, "only the last N days (0 for all)")
+	limitFlag := fs.Int("limit", 50, "show at most the newest N entries (0 for all)")
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		fmt.Println("Usage: devagent audit [job] [--action name] [--days N] [--limit N] [--json]")
+		exit(exitConfig)
+	}
+	q := store.AuditQuery{Action: *actionFlag, Limit: *limitFlag}
+	if len(positional) == 1 {
+		q.Job = positional[0]
+	}
+	if *daysFlag > 0 {
+		q.Since = time.Now().AddDate(0, 0, -*daysFlag)
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	events, err := st.AuditLog(context.Background(), q)
+	if err != nil {
+		fmt.Printf("audit error: %v\n", err)
+		exit(exitInfra)
+	}
+	if *jsonFlag {
+		if events == nil {
+			events = []store.AuditEvent{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(events)
+		return
+	}
+	if len(events) == 0 {
+		fmt.Println("no audit entries")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tSOURCE\tACTION\tJOB\tDETAIL")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.At.Local().Format("2006-01-02 15:04:05"), e.Actor, e.Source, e.Action, e.Job, e.Detail)
+	}
+	w.Flush()
+}
+
+// configureAudit copies audit entries to syslog when the global config
+// asks for it:
+//
+//	audit:
+//	  syslog: true
+func configureAudit() {
+	path, err := digest.ConfigPath()
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var cfg struct {
+		Audit struct {
+			Syslog bool `yaml:"syslog"`
+		} `yaml:"audit"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil || !cfg.Audit.Syslog {
+		return
+	}
+	w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_USER, "devagent")
+	if err != nil {
+		warnf("audit entries are not sent to syslog: %v", err)
+		return
+	}
+	store.SetAuditSink(func(e store.AuditEvent) {
+		_ = w.Notice(fmt.Sprintf("audit action=%s job=%q actor=%q source=%s detail=%q", e.Action, e.Job, e.Actor, e.Source, e.Detail))
+	})
+}
+
+// configureStats records every LLM request with its tokens and estimated
+// cost, for `devagent stats --llm`, and counts it in the local usage stats
+// `devagent stats --global` shows. Requests past the monthly budget in the
+// global config's llm section are refused or warned about; with on_budget
+// block, so are requests whose spend cannot be checked. The requests of one
+// command share a store, opened on the first one.
+func configureStats() {
+	var (
+		once    sync.Once
+		st      *store.Store
+		openErr error
+	)
+	openStore := func() (*store.Store, error) {
+		once.Do(func() { st, openErr = store.Open() })
+		return st, openErr
+	}
+	planner.SetUsageSink(func(u planner.TokenUsage) {
+		if err := localstats.RecordPlannerCall(u.InputTokens, u.OutputTokens); err != nil {
+			debugf("record usage stats: %v", err)
+		}
+		budget, err := planner.LoadBudget()
+		if err != nil {
+			budget = &planner.Budget{}
+		}
+		call := store.LLMCall{At: time.Now(), Model: u.Model, Purpose: u.Purpose, InputTokens: u.InputTokens, OutputTokens: u.OutputTokens}
+		if cost, ok := budget.Cost(u.Model, u.InputTokens, u.OutputTokens); ok {
+			call.CostUSD = &cost
+		}
+		st, err := openStore()
+		if err == nil {
+			err = st.RecordLLMCall(context.Background(), call)
+		}
+		if err != nil {
+			warnf("could not record the LLM call: %v", err)
+		}
+	})
+	// Jobs in the daemon can make requests at the same time.
+	var (
+		unpricedMu sync.Mutex
+		unpriced   = make(map[string]bool)
+	)
+	planner.SetUsageGate(func(model string) error {
+		budget, err := planner.LoadBudget()
+		if err != nil {
+			return fmt.Errorf("LLM budget: %w", err)
+		}
+		if budget.Monthly <= 0 {
+			return nil
+		}
+		if _, ok := budget.Cost(model, 0, 0); !ok {
+			unpricedMu.Lock()
+			if !unpriced[model] {
+				unpriced[model] = true
+				warnf("%s has no price in llm.prices; its calls do not count towards the monthly budget", model)
+			}
+			unpricedMu.Unlock()
+		}
+		st, err := openStore()
+		var spent float64
+		if err == nil {
+			spent, err = st.LLMSpend(context.Background(), monthStart(time.Now()))
+		}
+		if err != nil {
+			if budget.OnBudget == "block" {
+				return fmt.Errorf("check the LLM budget: %w", err)
+			}
+			warnf("could not check the LLM budget: %v", err)
+			return nil
+		}
+		warning, err := budget.Check(spent)
+		if warning != "" {
+			warnf("%s", warning)
+		}
+		return err
+	})
+}
+
+// monthStart returns the start of t's calendar month, in local time.
+func monthStart(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+// recordRunStats counts a manual run in the local usage stats.
+func recordRunStats(status string, d time.Duration) {
+	if err := localstats.RecordRun(status, d); err != nil {
+		debugf("record usage stats: %v", err)
+	}
+}
+
+// doStats prints the test counts and coverage recorded for a job's recent
+// runs, newest first, and how they moved over those runs.
+func doStats(args []string) {
+	fs := newFlagSet("stats")
+	runsFlag := fs.Int("runs", 10, "number of runs to show")
+	globalFlag := fs.Bool("global", false, "summarize your own usage across jobs from the local stats file instead")
+	daysFlag := fs.Int("days", 30, "with --global, number of days to summarize")
+	llmFlag := fs.Bool("llm", false, "show the tokens and estimated cost of LLM calls, and the monthly budget, instead")
+	monthsFlag := fs.Int("months", 1, "with --llm, number of calendar months to show")
+	positional := parseArgs(fs, args)
+	switch {
+	case *globalFlag && !*llmFlag && len(positional) == 0:
+		doGlobalStats(*daysFlag)
+		return
+	case *llmFlag && !*globalFlag && len(positional) == 0:
+		doLLMStats(*monthsFlag)
+		return
+	}
+	if len(positional) != 1 || *globalFlag || *llmFlag {
+		fmt.Println("Usage: devagent stats <job> [--runs N] | --global [--days N] | --llm [--months N]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	history, err := st.TestHistory(context.Background(), name, *runsFlag)
+	if err != nil {
+		fmt.Printf("stats error: %v\n", err)
+		exit(exitInfra)
+	}
+	if len(history) == 0 {
+		fmt.Printf("no test results recorded for %s; list report files under outputs.reports\n", name)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tSTATUS\tPASSED\tFAILED\tSKIPPED\tCOVERAGE")
+	for _, run := range history {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%s\n", run.ID, run.StartedAt.Local().Format("2006-01-02 15:04"), run.Status, run.Tests.Passed, run.Tests.Failed, run.Tests.Skipped, formatCoverage(run.Tests.Coverage))
+	}
+	w.Flush()
+
+	if len(history) < 2 {
+		return
+	}
+	oldest, newest := history[len(history)-1].Tests, history[0].Tests
+	fmt.Printf("\nover %d runs: tests %d -> %d, failed %d -> %d", len(history), oldest.Passed+oldest.Failed+oldest.Skipped, newest.Passed+newest.Failed+newest.Skipped, oldest.Failed, newest.Failed)
+	if oldest.Coverage != nil && newest.Coverage != nil {
+		fmt.Printf(", coverage %s -> %s (%+.1f points)", formatCoverage(oldest.Coverage), formatCoverage(newest.Coverage), *newest.Coverage-*oldest.Coverage)
+	}
+	fmt.Println()
+}
+
+// doGlobalStats summarizes the local usage stats of the last days days:
+// runs, their durations and the planner's LLM usage.
+func doGlobalStats(days int) {
+	if days < 1 {
+		fmt.Println("--days must be at least 1")
+		exit(exitConfig)
+	}
+	summary, err := localstats.Summarize(time.Now(), days)
+	if err != nil {
+		fmt.Printf("stats error: %v\n", err)
+		exit(exitInfra)
+	}
+	if len(summary.Days) == 0 {
+		fmt.Printf("no usage recorded in the last %d %s\n", days, util.Plural(days, "day"))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tRUNS\tFAILED\tAVG DURATION\tPLANNER CALLS\tINPUT TOKENS\tOUTPUT TOKENS")
+	for _, day := range summary.Days {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%d\t%d\n", day.Date, day.Runs, day.Failed, formatAverage(day.Day), day.PlannerCalls, day.InputTokens, day.OutputTokens)
+	}
+	w.Flush()
+
+	total := summary.Total
+	fmt.Printf("\nover %d %s: %d %s (%d failed, %.1f a day), average %s; %d planner %s using %d input and %d output tokens\n",
+		days, util.Plural(days, "day"), total.Runs, util.Plural(total.Runs, "run"), total.Failed, float64(total.Runs)/float64(days), formatAverage(total),
+		total.PlannerCalls, util.Plural(total.PlannerCalls, "call"), total.InputTokens, total.OutputTokens)
+}
+
+// doLLMStats shows the LLM calls of the last months calendar months by
+// month and model, with their tokens and estimated cost, and this month's
+// spend against the budget.
+func doLLMStats(months int) {
+	if months < 1 {
+		fmt.Println("--months must be at least 1")
+		exit(exitConfig)
+	}
+	budget, err := planner.LoadBudget()
+	if err != nil {
+		fmt.Printf("config error: %v\n", err)
+		exit(exitConfig)
+	}
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+
+	thisMonth := monthStart(time.Now())
+	calls, err := st.LLMCalls(context.Background(), thisMonth.AddDate(0, 1-months, 0))
+	if err != nil {
+		fmt.Printf("stats error: %v\n", err)
+		exit(exitInfra)
+	}
+	type row struct {
+		month, model  string
+		calls         int
+		input, output int64
+		cost          float64
+		unpriced      bool
+	}
+	var rows []*row
+	index := make(map[string]*row)
+	spent := 0.0
+	for _, call := range calls {
+		month := call.At.Local().Format("2006-01")
+		r := index[month+"\x00"+call.Model]
+		if r == nil {
+			r = &row{month: month, model: call.Model}
+			index[month+"\x00"+call.Model] = r
+			rows = append(rows, r)
+		}
+		r.calls++
+		r.input += call.InputTokens
+		r.output += call.OutputTokens
+		if call.CostUSD == nil {
+			r.unpriced = true
+		} else {
+			r.cost += *call.CostUSD
+			if !call.At.Before(thisMonth) {
+				spent += *call.CostUSD
+			}
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Printf("no LLM calls recorded in the last %d %s\n", months, util.Plural(months, "month"))
+	} else {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if rows[i].month != rows[j].month {
+				return rows[i].month < rows[j].month
+			}
+			return rows[i].model < rows[j].model
+		})
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MONTH\tMODEL\tCALLS\tINPUT TOKENS\tOUTPUT TOKENS\tEST. COST")
+		for _, r := range rows {
+			cost := fmt.Sprintf("$%.4f", r.cost)
+			if r.unpriced {
+				cost += " (price unknown for some calls)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", r.month, r.model, r.calls, r.input, r.output, cost)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	if budget.Monthly > 0 {
+		action := "warn"
+		if budget.OnBudget == "block" {
+			action = "block"
+		}
+		fmt.Printf("this month: $%.2f of the $%.2f budget (%s when spent)\n", spent, budget.Monthly, action)
+	} else {
+		fmt.Printf("this month: $%.2f; no budget set (llm.monthly_budget in the global config)\n", spent)
+	}
+}
+
+func formatAverage(day localstats.Day) string {
+	if day.Runs == 0 {
+		return "-"
+	}
+	return day.AverageRun().Round(time.Second).String()
+}
+
+func formatCoverage(coverage *float64) string {
+	if coverage == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", *coverage)
+}
+
+// doBench compares a job's latest benchmark results with the median of its
+// previous runs, using the workflow's bench settings unless overridden.
+func doBench(args []string) {
+	fs := newFlagSet("bench")
+	baselineFlag := fs.Int("baseline", 0, "number of previous runs in the baseline (default from the workflow, or 5)")
+	thresholdFlag := fs.Float64("threshold", -1, "regression threshold in percent (default from the workflow, or 10)")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Println("Usage: devagent bench <job> [--baseline N] [--threshold PCT]")
+		exit(exitConfig)
+	}
+	name := positional[0]
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	bench := &dsl.Bench{}
+	if job, err := st.GetJob(ctx, name); err == nil && job != nil {
+		if wf, err := dsl.Load(job.YAMLPath()); err == nil && wf.Bench != nil {
+			bench = wf.Bench
+		}
+	}
+	baseline, threshold := bench.BaselineRuns(), bench.Threshold()
+	if *baselineFlag > 0 {
+		baseline = *baselineFlag
+	}
+	if *thresholdFlag >= 0 {
+		threshold = *thresholdFlag
+	}
+
+	history, err := st.BenchHistory(ctx, name, baseline+1)
+	if err != nil {
+		fmt.Printf("bench error: %v\n", err)
+		exit(exitInfra)
+	}
+	if len(history) == 0 {
+		fmt.Printf("no benchmark results recorded for %s; list benchmark files under bench.files\n", name)
+		return
+	}
+	toRunner := func(results []store.BenchResult) []runner.BenchResult {
+		out := make([]runner.BenchResult, len(results))
+		for i, r := range results {
+			out[i] = runner.BenchResult(r)
+		}
+		return out
+	}
+	latest := toRunner(history[0].Results)
+	var previous [][]runner.BenchResult
+	for _, run := range history[1:] {
+		previous = append(previous, toRunner(run.Results))
+	}
+	changes := make(map[string]runner.BenchChange)
+	for _, change := range runner.CompareBench(latest, previous, threshold) {
+		changes[runner.BenchResult{Name: change.Name, Unit: change.Unit}.Key()] = change
+	}
+
+	fmt.Printf("run %d (%s, %s) against the median of %d previous %s, threshold %.1f%%\n\n", history[0].ID, history[0].StartedAt.Local().Format("2006-01-02 15:04"), history[0].Status, len(previous), util.Plural(len(previous), "run"), threshold)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BENCHMARK\tUNIT\tBASELINE\tLATEST\tCHANGE\t")
+	regressions := 0
+	for _, r := range latest {
+		change, ok := changes[r.Key()]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%s\t-\t%g\tnew\t\n", r.Name, r.Unit, r.Value)
+			continue
+		}
+		mark := ""
+		if change.Regression {
+			mark = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%g\t%g\t%+.1f%%\t%s\n", r.Name, r.Unit, change.Baseline, r.Value, change.ChangePct, mark)
+	}
+	w.Flush()
+	if regressions > 0 {
+		fmt.Printf("\n%d %s beyond %.1f%%\n", regressions, util.Plural(regressions, "regression"), threshold)
+	}
+}
+
+// doDigest prints the digest for the latest period configured in the global
+// config, or sends it through the configured channel with --send.
+func doDigest(args []string) {
+	fs := newFlagSet("digest")
+	sendFlag := fs.Bool("send", false, "send the digest through the configured channel")
+	fs.Parse(args)
+
+	cfg, err := digest.LoadConfig()
+	if err != nil {
+		fmt.Printf("config error: %v\n", err)
+		exit(exitConfig)
+	}
+	if cfg == nil {
+		if *sendFlag {
+			path, _ := digest.ConfigPath()
+			fmt.Printf("no digest configured; add a digest section to %s\n", path)
+			exit(exitConfig)
+		}
+		cfg = &digest.Config{Every: "daily"}
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	start, end := cfg.Period(time.Now())
+	msg, err := digest.Collect(ctx, st, start, end)
+	if err != nil {
+		fmt.Printf("digest error: %v\n", err)
+		exit(exitInfra)
+	}
+	if !*sendFlag {
+		fmt.Println(msg.Title)
+		fmt.Println(msg.Body)
+		return
+	}
+	if err := notify.Send(ctx, cfg.Channel, msg); err != nil {
+		fmt.Printf("send error: %v\n", err)
+		exit(exitInfra)
+	}
+	fmt.Printf("digest sent to %s\n", cfg.Channel)
+}
+
+// doEnv prints the environment a workflow's steps would see and how their
+// commands resolve, optionally starting from the environment the daemon
+// runs with.
+func doEnv(args []string) {
+	fs := newFlagSet("env")
+	jsonFlag := fs.Bool("json", globals.json, "print the report as JSON")
+	daemonFlag := fs.Bool("daemon", false, "start from the environment recorded by the running daemon")
+	repoFlag := fs.String("repo", "", "use this repository instead of the workflow's repo")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Println("Usage: devagent env [--json] [--daemon] [--repo path] [job|path]")
+		exit(exitConfig)
+	}
+
+	st, err := store.Open()
+	if err == nil {
+		defer st.Close()
+	}
+	yamlPath, err := resolveWorkflowPath(st, fs.Arg(0))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		exit(exitConfig)
+	}
+	wf, err := dsl.Load(yamlPath)
+	if err != nil {
+		fmt.Printf("load error: %v\n", err)
+		exit(exitConfig)
+	}
+	if *repoFlag != "" {
+		wf.Repo = *repoFlag
+	}
+	opts := runner.ProbeOptions{Workflow: wf}
+	if upstream := runner.NeededJobs(wf); len(upstream) > 0 && st != nil {
+		if opts.Needs, err = st.OutputsFor(context.Background(), upstream); err != nil {
+			fmt.Printf("failed to load upstream outputs: %v\n", err)
+			exit(exitInfra)
+		}
+	}
+	if *daemonFlag {
+		if opts.Base, err = runner.LoadEnvSnapshot(); err != nil {
+			fmt.Printf("no daemon environment recorded (start `devagent daemon` first): %v\n", err)
+			exit(exitConfig)
+		}
+	}
+
+	report, err := runner.Probe(context.Background(), opts)
+	if err != nil {
+		fmt.Printf("env error: %v\n", err)
+		var configErr *runner.ConfigError
+		if errors.As(err, &configErr) {
+			exit(exitConfig)
+		}
+		exit(exitInfra)
+	}
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+		return
+	}
+
+	source := "this terminal"
+	if *daemonFlag {
+		source = "the daemon"
+	}
+	fmt.Printf("Environment of %s steps, starting from %s\n", wf.Name, source)
+	fmt.Printf("Repo:  %s\n", report.Repo)
+	fmt.Printf("Shell: %s -lc (login shell)\n", report.Shell)
+	if report.Sandbox != "" {
+		fmt.Printf("Sandbox: %s\n", report.Sandbox)
+	}
+	if report.User != "" {
+		fmt.Printf("User:  %s\n", report.User)
+	}
+	if len(report.Removed) > 0 {
+		fmt.Printf("Withheld: %s\n", strings.Join(report.Removed, ", "))
+	}
+	for _, note := range report.Credentials {
+		fmt.Printf("Credentials: %s\n", strings.TrimPrefix(note, "credentials: "))
+	}
+	fmt.Println("\nPATH:")
+	for _, dir := range report.Path {
+		fmt.Printf("  %s\n", dir)
+	}
+	fmt.Println("\nSteps:")
+	for i, step := range report.Steps {
+		resolved := step.Resolved
+		if resolved == "" {
+			resolved = "NOT FOUND"
+		}
+		fmt.Printf("  %d. %s\n     %s -> %s\n", i+1, step.Cmd, step.Program, resolved)
+	}
+	fmt.Println("\nVariables:")
+	for _, kv := range report.Env {
+		fmt.Printf("  %s\n", kv)
+	}
+}
+
+// version is the release devagent was built as, set with
+// -ldflags "-X main.version=v1.2.3"; builds without it report "dev".
+var version = "dev"
+
+// versionReport is the output of `devagent version --json`.
+type versionReport struct {
+	Version    string             `json:"version"`
+	Commit     string             `json:"commit,omitempty"`
+	CommitTime string             `json:"commit_time,omitempty"`
+	Modified   bool               `json:"modified,omitempty"`
+	Go         string             `json:"go"`
+	Platform   string             `json:"platform"`
+	Store      versionStoreReport `json:"store"`
+}
+
+type versionStoreReport struct {
+	Path           string   `json:"path"`
+	Exists         bool     `json:"exists"`
+	SchemaVersion  int      `json:"schema_version"`
+	Supported      int      `json:"supported_schema_version"`
+	NeedsMigration bool     `json:"needs_migration"`
+	Compatible     bool     `json:"compatible"`
+	Missing        []string `json:"missing,omitempty"`
+}
+
+// doVersion reports the binary's version and build, and whether this
+// binary can use the store as it is on disk. It reads the store without
+// migrating it, and exits with exitConfig when the store is from a newer
+// devagent.
+func doVersion(args []string) {
+	fs := newFlagSet("version")
+	jsonFlag := fs.Bool("json", globals.json, "print the report as JSON")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Println("Usage: devagent version [--json]")
+		exit(exitConfig)
+	}
+
+	report := versionReport{Version: version, Go: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				report.Commit = setting.Value
+			case "vcs.time":
+				report.CommitTime = setting.Value
+			case "vcs.modified":
+				report.Modified = setting.Value == "true"
+			}
+		}
+	}
+	path, err := store.StatePath()
+	if err != nil {
+		fmt.Printf("store error: %v\n", err)
+		exit(exitInfra)
+	}
+	schema, err := store.InspectSchema(context.Background(), path)
+	if err != nil {
+		fmt.Printf("store error: %v\n", err)
+		exit(exitInfra)
+	}
+	report.Store = versionStoreReport{
+		Path:           path,
+		Exists:         schema.Exists,
+		SchemaVersion:  schema.Version,
+		Supported:      store.SchemaVersion,
+		NeedsMigration: schema.NeedsMigration(),
+		Compatible:     schema.Compatible(),
+		Missing:        schema.Missing,
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		build := report.Go + " " + report.Platform
+		if report.Commit != "" {
+			commit := report.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			if report.Modified {
+				commit += "-dirty"
+			}
+			build = "commit " + commit + ", " + build
+		}
+		fmt.Printf("devagent %s (%s)\n", report.Version, build)
+		switch {
+		case !schema.Exists:
+			fmt.Printf("store: %s (not created yet; schema %d)\n", path, store.SchemaVersion)
+		case !schema.Compatible():
+			fmt.Printf("store: %s, schema %d, newer than this binary supports (%d); upgrade devagent\n", path, schema.Version, store.SchemaVersion)
+		case schema.NeedsMigration():
+			fmt.Printf("store: %s, schema %d, upgraded to %d the next time devagent opens it\n", path, schema.Version, store.SchemaVersion)
+		default:
+			fmt.Printf("store: %s, schema %d (current)\n", path, schema.Version)
+		}
 	}
-	if len(copies) > 0 {
-		workflow.Outputs = &dsl.Outputs{CopyIfExists: copies}
+	if !schema.Compatible() {
+		exit(exitConfig)
 	}
+}
 
-	yamlBytes, err := yaml.Marshal(workflow)
-	if err != nil {
-		fmt.Printf("failed to render YAML: %v\n", err)
-		os.Exit(1)
+// formatBytes renders n with a binary unit suffix, e.g. 12.5MB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
 	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// doDoctor reports job locks and, with --fix-locks, removes the ones left
+// behind by crashed runs. Locks held by live processes are never removed.
+func doDoctor(args []string) {
+	fs := newFlagSet("doctor")
+	fixLocks := fs.Bool("fix-locks", false, "remove stale lock files")
+	checkDB := fs.Bool("check-db", false, "run SQLite's integrity check on the state store")
+	repairDB := fs.Bool("repair", false, "with --check-db, rebuild a corrupt store or restore its newest sound backup (stop the daemon first)")
+	reconcile := fs.Bool("reconcile", false, "resync jobs from their workflow files, removing jobs whose file is gone")
+	fs.Parse(args)
 
-	fmt.Println(string(yamlBytes))
+	if *checkDB {
+		doctorStore(*repairDB)
+	} else if *repairDB {
+		fmt.Println("--repair requires --check-db")
+		exit(exitConfig)
+	}
+	doctorWorkflowFiles(*reconcile)
 
-	cwd, err := os.Getwd()
+	locks, err := scheduler.InspectLocks()
 	if err != nil {
-		fmt.Printf("cwd error: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("lock error: %v\n", err)
+		exit(1)
 	}
-	yamlPath := filepath.Join(cwd, ".devagent.yml")
-	if err := dsl.Save(yamlPath, workflow); err != nil {
-		fmt.Printf("failed to write workflow: %v\n", err)
-		os.Exit(1)
+	stale := 0
+	for _, lock := range locks {
+		switch {
+		case lock.Held && lock.Group != "":
+			fmt.Printf("lock group %s: held by %s (pid %d) since %s\n", lock.Group, lock.Job, lock.PID, lock.StartedAt.Local().Format(time.RFC3339))
+		case lock.Held && lock.Repo != "":
+			fmt.Printf("lock on repo %s: held by %s (pid %d) since %s\n", lock.Repo, lock.Job, lock.PID, lock.StartedAt.Local().Format(time.RFC3339))
+		case lock.Held:
+			fmt.Printf("lock %s: held by pid %d since %s\n", lock.Name, lock.PID, lock.StartedAt.Local().Format(time.RFC3339))
+		case lock.Stale():
+			stale++
+			fmt.Printf("lock %s: stale, pid %d (started %s) is gone\n", lock.Name, lock.PID, lock.StartedAt.Local().Format(time.RFC3339))
+		}
 	}
+	if !*fixLocks {
+		if stale > 0 {
+			fmt.Println("run `devagent doctor --fix-locks` to remove stale locks")
+		} else {
+			fmt.Println("no stale locks")
+		}
+		return
+	}
+	removed, err := scheduler.RemoveStaleLocks()
+	if err != nil {
+		fmt.Printf("lock cleanup error: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("removed %d stale lock(s)\n", len(removed))
+}
 
+// doctorWorkflowFiles reports jobs that disagree with their workflow
+// files, resyncing them when reconcile is set.
+func doctorWorkflowFiles(reconcile bool) {
 	st, err := store.Open()
 	if err != nil {
-		fmt.Printf("failed to open state store: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
 	}
 	defer st.Close()
-
-	job := store.NewJob(workflow.Name, workflow.Repo, workflow.Schedule.Cron, workflow.Schedule.Natural, workflow.Schedule.Timezone, yamlPath)
-	if err := st.UpsertJob(context.Background(), job); err != nil {
-		fmt.Printf("failed to register job: %v\n", err)
-		os.Exit(1)
+	ctx := context.Background()
+	drifts, err := st.CheckWorkflowFiles(ctx)
+	if err != nil {
+		fmt.Printf("workflow check error: %v\n", err)
+		exit(exitInfra)
+	}
+	if len(drifts) == 0 {
+		fmt.Println("all jobs match their workflow files")
+		return
+	}
+	failed := false
+	for _, drift := range drifts {
+		if !reconcile {
+			fmt.Println(drift)
+			continue
+		}
+		done, err := st.Reconcile(ctx, drift)
+		if err != nil {
+			fmt.Printf("could not reconcile %v\n", err)
+			failed = true
+			continue
+		}
+		if drift.Workflow != nil {
+			recordRevision(st, drift.Workflow.Name, drift.Job.YAMLPath(), "reconcile")
+		}
+		fmt.Println(done)
+	}
+	if !reconcile {
+		fmt.Println("run `devagent doctor --reconcile` to resync the store from the workflow files")
+	} else if failed {
+		exit(exitConfig)
 	}
-
-	fmt.Printf("workflow saved to %s and scheduled\n", yamlPath)
 }
 
-func doRun(args []string) {
-	fs := flag.NewFlagSet("run", flag.ExitOnError)
-	fs.Bool("once", false, "deprecated flag")
-	fs.Parse(args)
-
-	cwd, err := os.Getwd()
+// doctorStore reports the integrity of the state store and its backups,
+// repairing a corrupt store when repair is set.
+func doctorStore(repair bool) {
+	ctx := context.Background()
+	path, err := store.StatePath()
 	if err != nil {
-		fmt.Printf("cwd error: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("store error: %v\n", err)
+		exit(exitInfra)
+	}
+	problems, err := store.CheckIntegrity(ctx, path)
+	if os.IsNotExist(err) {
+		fmt.Printf("store %s: not created yet\n", path)
+		return
 	}
-	yamlPath := filepath.Join(cwd, ".devagent.yml")
-	workflow, err := dsl.Load(yamlPath)
 	if err != nil {
-		fmt.Printf("load error: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("store error: %v\n", err)
+		exit(exitInfra)
 	}
-
-	summary, err := runner.Run(context.Background(), runner.Options{Workflow: workflow, Stdout: os.Stdout})
+	backups, err := store.Backups()
+	if err != nil {
+		fmt.Printf("backup error: %v\n", err)
+		exit(exitInfra)
+	}
+	if len(backups) == 0 {
+		fmt.Println("no backups yet; the daemon backs up the store daily")
+	} else {
+		fmt.Printf("%d %s, newest %s\n", len(backups), util.Plural(len(backups), "backup"), backups[0].TakenAt.Local().Format(time.RFC3339))
+	}
+	if len(problems) == 0 {
+		fmt.Printf("store %s: ok\n", path)
+		return
+	}
+	fmt.Printf("store %s: corrupt\n", path)
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	if !repair {
+		fmt.Println("stop the daemon and run `devagent doctor --check-db --repair` to repair it")
+		exit(exitInfra)
+	}
+	done, err := store.Repair(ctx, path)
 	if err != nil {
-		fmt.Printf("run error: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("repair failed: %v\n", err)
+		exit(exitInfra)
 	}
+	fmt.Println(done)
+}
 
-	fmt.Printf("run finished with status %s\n", summary.Status)
+func statusLine(job store.Job) string {
+	last := "never"
+	if job.LastRun.Valid {
+		last = job.LastRun.Time.Format(time.RFC3339)
+	}
+	status := "unknown"
+	if job.LastStatus.Valid {
+		status = job.LastStatus.String
+	}
+	line := fmt.Sprintf("%s\t%s\tlast=%s (%s)", job.Name, scheduleDesc(job), last, paintStatus(os.Stdout, status))
+	if job.FailureStreak > 0 {
+		line += fmt.Sprintf("\tfailing=%d", job.FailureStreak)
+	}
+	if job.Paused {
+		line += "\t" + pausedLabel(job)
+	}
+	return line
+}
 
-	st, err := store.Open()
-	if err == nil {
-		defer st.Close()
-		_ = st.UpdateRunResult(context.Background(), workflow.Name, summary.Status, time.Now())
+// scheduleDesc summarises when a job runs.
+func scheduleDesc(job store.Job) string {
+	var when []string
+	if job.Cron() != "" {
+		when = append(when, "cron="+job.Cron())
+	}
+	if job.Every() != "" {
+		when = append(when, "every="+job.Every())
 	}
+	if job.At() != "" {
+		when = append(when, "at="+job.At())
+	}
+	if job.Calendar() != "" {
+		when = append(when, "calendar="+job.Calendar())
+	}
+	if job.After() != "" {
+		when = append(when, "after="+job.After())
+	}
+	return strings.Join(when, " ")
 }
 
-func doSchedule(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: devagent schedule <list|remove>")
-		os.Exit(1)
+// pausedLabel distinguishes one-shot jobs that already fired from jobs
+// paused by backoff or by hand.
+func pausedLabel(job store.Job) string {
+	if job.At() != "" && job.LastRun.Valid {
+		return "done"
 	}
-	sub := args[0]
+	return "paused"
+}
+
+func doDaemon(args []string) {
+	fs := newFlagSet("daemon")
+	listenFlag := fs.String("listen", "", "serve the read-only status API on this address (e.g. 127.0.0.1:7777), or on a Unix socket with unix:PATH (unix alone means daemon.sock in the profile's state directory)")
+	idleFlag := fs.Duration("idle-after", scheduler.DefaultIdleAfter, "time without keyboard or mouse input that counts as idle for jobs requiring idle")
+	backupEvery := fs.Duration("backup-every", scheduler.DefaultBackupEvery, "how often to back up the state store (0 disables backups)")
+	backupKeep := fs.Int("backup-keep", scheduler.DefaultBackupKeep, "number of store backups to keep")
+	allowUnapproved := fs.Bool("allow-unapproved", false, "run workflow files changed since they were approved, with a warning, instead of refusing them")
+	readOnly := fs.Bool("read-only", false, "schedule no jobs, e.g. to serve the status API while another daemon runs them")
+	maxRuns := fs.Int("max-runs", 0, "run at most this many jobs at once, starting waiting jobs by schedule.priority (0 for no limit)")
+	var watchDirs stringList
+	fs.Var(&watchDirs, "watch", "register, update and remove jobs to match the workflow files in this directory (repeatable)")
+	fs.Parse(args)
+
 	st, err := store.Open()
 	if err != nil {
-		fmt.Printf("failed to open state: %v\n", err)
-		os.Exit(1)
+		log.Fatalf("failed to open store: %v", err)
 	}
 	defer st.Close()
 
-	switch sub {
-	case "list":
-		jobs, err := st.ListJobs(context.Background())
+	logger := log.New(os.Stdout, "devagent ", log.LstdFlags)
+	store.SetAuditSource("daemon")
+	daemon := scheduler.New(st, logger)
+	daemon.IdleAfter = *idleFlag
+	daemon.BackupEvery = *backupEvery
+	daemon.BackupKeep = *backupKeep
+	daemon.AllowUnapproved = *allowUnapproved
+	daemon.ReadOnly = *readOnly
+	daemon.MaxRuns = *maxRuns
+	daemon.Verbose = globals.verbose
+	for _, dir := range watchDirs {
+		abs, err := filepath.Abs(dir)
+		if err == nil {
+			_, err = os.Stat(abs)
+		}
 		if err != nil {
-			fmt.Printf("list error: %v\n", err)
-			os.Exit(1)
+			log.Fatalf("--watch: %v", err)
 		}
-		if len(jobs) == 0 {
-			fmt.Println("no jobs scheduled")
-			return
+		daemon.WatchDirs = append(daemon.WatchDirs, abs)
+	}
+	if !*readOnly {
+		if err := runner.WriteEnvSnapshot(); err != nil {
+			logger.Printf("record environment for `devagent env --daemon`: %v", err)
 		}
-		for _, job := range jobs {
-			last := "never"
-			if job.LastRun.Valid {
-				last = job.LastRun.Time.Format(time.RFC3339)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var sinks []func(string, runner.StepEvent)
+	if cfg, err := pubsub.LoadConfig(); err != nil {
+		logger.Printf("run events: %v", err)
+	} else if cfg != nil && !*readOnly {
+		if pub, err := pubsub.New(cfg, logger.Printf); err != nil {
+			logger.Printf("run events: %v", err)
+		} else {
+			go pub.Run(ctx)
+			sinks = append(sinks, pub.Publish)
+		}
+	}
+	if *listenFlag != "" {
+		listener, addr, err := daemonListener(*listenFlag)
+		if err != nil {
+			log.Fatalf("status API: %v", err)
+		}
+		hub := api.NewHub()
+		sinks = append(sinks, hub.Publish)
+		server := &http.Server{Handler: api.Handler(st, os.Getenv("DEVAGENT_API_TOKEN"), hub)}
+		go func() {
+			logger.Printf("status API listening on %s", addr)
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Printf("status API error: %v", err)
 			}
-			status := "unknown"
-			if job.LastStatus.Valid {
-				status = job.LastStatus.String
+		}()
+		defer server.Close()
+	}
+
+	if len(sinks) > 0 {
+		daemon.Events = func(job string, ev runner.StepEvent) {
+			for _, sink := range sinks {
+				sink(job, ev)
 			}
-			fmt.Printf("%s\t%s\tcron=%s\tlast=%s\n", job.Name, job.Repo, job.Cron(), fmt.Sprintf("%s (%s)", last, status))
 		}
-	case "remove":
-		if len(args) < 2 {
-			fmt.Println("provide a job name to remove")
-			os.Exit(1)
+	}
+
+	if err := daemon.Run(ctx); err != nil {
+		log.Fatalf("daemon error: %v", err)
+	}
+}
+
+// daemonListener opens the status API listener for --listen: a TCP
+// address, or a Unix socket for "unix" and "unix:PATH". The socket is only
+// accessible to the current user.
+func daemonListener(listen string) (net.Listener, string, error) {
+	if listen != "unix" && !strings.HasPrefix(listen, "unix:") {
+		l, err := net.Listen("tcp", listen)
+		return l, listen, err
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(listen, "unix"), ":")
+	if path == "" {
+		dir, err := paths.StateDir()
+		if err != nil {
+			return nil, "", err
 		}
-		name := args[1]
-		if err := st.RemoveJob(context.Background(), name); err != nil {
-			fmt.Printf("remove error: %v\n", err)
-			os.Exit(1)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, "", err
+		}
+		path = filepath.Join(dir, "daemon.sock")
+	}
+	// A socket left behind by a daemon that died would fail the listen.
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("another daemon is listening on %s", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, "", err
+	}
+	return l, path, nil
+}
+
+func doHooks(args []string) {
+	if len(args) == 0 || (args[0] != "install" && args[0] != "uninstall") {
+		fmt.Println("Usage: devagent hooks <install|uninstall> [--repo path]")
+		exit(1)
+	}
+	sub := args[0]
+	fs := newFlagSet("hooks " + sub)
+	repoFlag := fs.String("repo", "", "repository path (defaults to the current directory)")
+	fs.Parse(args[1:])
+
+	dir := *repoFlag
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Printf("cwd error: %v\n", err)
+			exit(1)
+		}
+		dir = cwd
+	}
+	root, err := hooks.RepoRoot(dir)
+	if err != nil {
+		fmt.Printf("hooks error: %v\n", err)
+		exit(1)
+	}
+
+	var changed []string
+	if sub == "install" {
+		binary, err := os.Executable()
+		if err != nil {
+			binary = "devagent"
+		}
+		changed, err = hooks.Install(root, binary, paths.Profile())
+		if err != nil {
+			fmt.Printf("hooks install error: %v\n", err)
+			exit(1)
+		}
+	} else {
+		changed, err = hooks.Uninstall(root)
+		if err != nil {
+			fmt.Printf("hooks uninstall error: %v\n", err)
+			exit(1)
 		}
-		fmt.Println("removed", name)
-	default:
-		fmt.Println("Usage: devagent schedule <list|remove>")
-		os.Exit(1)
+	}
+	if len(changed) == 0 {
+		fmt.Println("no hooks changed")
+		return
+	}
+	for _, path := range changed {
+		fmt.Printf("%sed %s\n", sub, path)
 	}
 }
 
-func doDaemon() {
+func doTick(args []string) {
+	fs := newFlagSet("tick")
+	eventFlag := fs.String("event", "commit", "git event that fired (commit or merge)")
+	repoFlag := fs.String("repo", "", "repository path (defaults to the current directory)")
+	fs.Parse(args)
+
+	dir := *repoFlag
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Printf("cwd error: %v\n", err)
+			exit(1)
+		}
+		dir = cwd
+	}
+	target := canonicalPath(dir)
+	store.SetAuditSource("hook")
+
 	st, err := store.Open()
 	if err != nil {
-		log.Fatalf("failed to open store: %v", err)
+		fmt.Printf("failed to open state store: %v\n", err)
+		exit(1)
 	}
 	defer st.Close()
 
+	jobs, err := st.ListJobs(context.Background())
+	if err != nil {
+		fmt.Printf("list error: %v\n", err)
+		exit(1)
+	}
+
 	daemon := scheduler.New(st, log.New(os.Stdout, "devagent ", log.LstdFlags))
+	triggered := 0
+	for _, job := range jobs {
+		if job.Paused {
+			continue
+		}
+		wf, err := dsl.Load(job.YAMLPath())
+		if err != nil || !wf.Schedule.HasTrigger(*eventFlag) {
+			continue
+		}
+		repo, err := wf.ExpandRepo()
+		if err != nil || canonicalPath(repo) != target {
+			continue
+		}
+		daemon.Trigger(job)
+		triggered++
+	}
+	if triggered == 0 {
+		fmt.Printf("no workflows triggered by %s in %s\n", *eventFlag, dir)
+	}
+}
 
-	ctx, cancel := signalContext()
-	defer cancel()
+// parseArgs parses fs while allowing flags both before and after positional
+// arguments, and returns the positional arguments.
+func parseArgs(fs *flagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-	if err := daemon.Run(ctx); err != nil {
-		log.Fatalf("daemon error: %v", err)
+// canonicalPath resolves symlinks so hook paths and workflow repos compare equal.
+func canonicalPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
 	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return filepath.Clean(path)
 }
 
 func signalContext() (context.Context, context.CancelFunc) {
@@ -291,14 +3711,20 @@ func signalContext() (context.Context, context.CancelFunc) {
 }
 
 func doPlan(args []string) {
-	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	fs := newFlagSet("plan")
 	var (
-		cronFlag    = fs.String("cron", "", "cron expression fallback")
-		repoFlag    = fs.String("repo", "", "repository path")
-		nameFlag    = fs.String("name", "", "workflow name")
-		tzFlag      = fs.String("timezone", "", "timezone override")
-		modelFlag   = fs.String("model", "", "planner model")
-		baseURLFlag = fs.String("base-url", "", "planner base URL")
+		cronFlag     = fs.String("cron", "", "cron expression fallback")
+		repoFlag     = fs.String("repo", "", "repository path")
+		nameFlag     = fs.String("name", "", "workflow name")
+		tzFlag       = fs.String("timezone", "", "timezone override")
+		modelFlag    = fs.String("model", "", "planner model")
+		baseURLFlag  = fs.String("base-url", "", "planner base URL")
+		timeoutFlag  = fs.Duration("timeout", planner.DefaultTimeout, "how long a request to the planner's model may take, retries included")
+		apiFlag      = fs.String("api", planner.APIAuto, "API of the planner's server: responses, chat (/chat/completions) or auto, which falls back to chat when the server has no responses API")
+		repromptFlag = fs.Int("reprompts", planner.DefaultReprompts, "how many times to send an invalid plan back to the model with its problems")
+		afterFlag    = fs.String("after", "", "run after this job succeeds")
+		atFlag       = fs.String("at", "", "run once at this local time (YYYY-MM-DDTHH:MM)")
+		everyFlag    = fs.String("every", "", "run at a fixed interval such as 15m or 2h")
 	)
 	var steps stringList
 	fs.Var(&steps, "step", "command step (repeatable)")
@@ -307,15 +3733,15 @@ func doPlan(args []string) {
 	remaining := fs.Args()
 	if len(remaining) == 0 {
 		fmt.Println("provide a natural language specification")
-		os.Exit(1)
+		exit(1)
 	}
 	spec := remaining[0]
 
-	apiKey := loadAPIKey()
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	targets := discoverTargets(*repoFlag)
+	printTargets(targets)
 
-	plan, err := planner.PlanFromSpec(ctx, spec, planner.Options{
+	apiKey := loadAPIKey()
+	plan, err := planner.PlanFromSpec(context.Background(), spec, planner.Options{
 		Name:      *nameFlag,
 		CronHint:  *cronFlag,
 		RepoHint:  *repoFlag,
@@ -324,31 +3750,259 @@ func doPlan(args []string) {
 		APIKey:    apiKey,
 		Model:     *modelFlag,
 		BaseURL:   *baseURLFlag,
+		Timeout:   *timeoutFlag,
+		API:       *apiFlag,
+		Reprompts: reprompts(*repromptFlag),
+		After:     *afterFlag,
+		At:        *atFlag,
+		Every:     *everyFlag,
+		Targets:   targetCommands(targets),
 	})
 	if err != nil {
 		fmt.Printf("planner error: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
+	warnFallback(plan)
 
-	workflow := &dsl.Workflow{
-		Version: 1,
-		Name:    plan.Name,
-		Repo:    plan.Repo,
-		Schedule: dsl.Schedule{
-			Natural:  plan.Natural,
-			Cron:     plan.Cron,
-			Timezone: plan.Timezone,
-		},
-		Steps: make([]dsl.Step, 0, len(plan.Steps)),
-	}
-	for _, step := range plan.Steps {
-		workflow.Steps = append(workflow.Steps, dsl.Step{Run: step})
+	workflow := workflowFromPlan(spec, plan, targets)
+	if _, err := reviewSteps(workflow, os.Stderr); err != nil {
+		warnf("%v", err)
 	}
+	printSafetyWarnings(os.Stderr, plan.Warnings)
 
 	out, err := yaml.Marshal(workflow)
 	if err != nil {
 		fmt.Printf("failed to marshal workflow: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	fmt.Print(string(out))
 }
+
+func doTemplates(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: devagent templates <list|apply> [name] [options]")
+		exit(exitConfig)
+	}
+	switch args[0] {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDESCRIPTION")
+		for _, tmpl := range templates.List() {
+			fmt.Fprintf(w, "%s\t%s\n", tmpl.Name, tmpl.Description)
+		}
+		w.Flush()
+	case "apply":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			fmt.Println("Usage: devagent templates apply <name> [--repo DIR] [--name NAME] [--cron EXPR] [--yes]")
+			exit(exitConfig)
+		}
+		doNew(append([]string{"--template", args[1]}, args[2:]...))
+	default:
+		fmt.Printf("unknown templates command %q\n", args[0])
+		exit(exitConfig)
+	}
+}
+
+// doInit creates a workflow for the current repo without a planner: from
+// a template with --template, otherwise from the project files it finds.
+func doInit(args []string) {
+	for _, arg := range args {
+		if arg == "--template" || arg == "-template" || strings.HasPrefix(arg, "--template=") || strings.HasPrefix(arg, "-template=") {
+			doNew(args)
+			return
+		}
+	}
+	doNew(append([]string{"--detect"}, args...))
+}
+
+// doExport renders a workflow as a standalone bash script for machines
+// without devagent.
+func doExport(args []string) {
+	fs := newFlagSet("export")
+	formatFlag := fs.String("format", "shell", "output format (shell)")
+	outputFlag := fs.String("o", "", "write the script to this file instead of stdout")
+	repoFlag := fs.String("repo", "", "use this repository instead of the workflow's repo")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Println("Usage: devagent export [--format shell] [-o file] [--repo path] [job|path]")
+		exit(exitConfig)
+	}
+	if *formatFlag != "shell" {
+		fmt.Printf("unknown format %q (expected shell)\n", *formatFlag)
+		exit(exitConfig)
+	}
+
+	st, err := store.Open()
+	if err == nil {
+		defer st.Close()
+	}
+	yamlPath, err := resolveWorkflowPath(st, fs.Arg(0))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		exit(exitConfig)
+	}
+	wf, err := dsl.Load(yamlPath)
+	if err != nil {
+		fmt.Printf("load error: %v\n", err)
+		exit(exitConfig)
+	}
+	if *repoFlag != "" {
+		wf.Repo = *repoFlag
+	}
+	var needs map[string]map[string]string
+	if upstream := runner.NeededJobs(wf); len(upstream) > 0 && st != nil {
+		if needs, err = st.OutputsFor(context.Background(), upstream); err != nil {
+			fmt.Printf("failed to load upstream outputs: %v\n", err)
+			exit(exitInfra)
+		}
+	}
+
+	script, notes, err := runner.ExportShell(wf, needs)
+	if err != nil {
+		fmt.Printf("export error: %v\n", err)
+		exit(exitConfig)
+	}
+	for _, note := range notes {
+		warnf("not exported: %s", note)
+	}
+	if *outputFlag == "" {
+		fmt.Print(script)
+		return
+	}
+	if err := os.WriteFile(*outputFlag, []byte(script), 0o755); err != nil {
+		fmt.Printf("export error: %v\n", err)
+		exit(exitInfra)
+	}
+	fmt.Printf("exported %s to %s\n", wf.Name, *outputFlag)
+}
+
+// doExportState writes the job registry as a portable bundle, YAML when the
+// output file ends in .yml or .yaml and JSON otherwise.
+func doExportState(args []string) {
+	fs := newFlagSet("export-state")
+	runsFlag := fs.Bool("runs", false, "include the run history")
+	outputFlag := fs.String("o", "", "write the bundle to this file instead of stdout")
+	formatFlag := fs.String("format", "", "json or yaml (default from the -o extension, else json)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Println("Usage: devagent export-state [--runs] [-o file] [--format json|yaml]")
+		exit(exitConfig)
+	}
+	format := *formatFlag
+	if format == "" {
+		format = "json"
+		if ext := filepath.Ext(*outputFlag); ext == ".yml" || ext == ".yaml" {
+			format = "yaml"
+		}
+	}
+	if format != "json" && format != "yaml" {
+		fmt.Printf("unknown format %q (expected json or yaml)\n", format)
+		exit(exitConfig)
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	bundle, err := st.Export(context.Background(), *runsFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export error: %v\n", err)
+		exit(exitInfra)
+	}
+
+	var data []byte
+	if format == "yaml" {
+		data, err = yaml.Marshal(bundle)
+	} else {
+		data, err = json.MarshalIndent(bundle, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export error: %v\n", err)
+		exit(exitInfra)
+	}
+	if *outputFlag == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	// The bundle holds workflow files and outputs, which may be private.
+	if err := os.WriteFile(*outputFlag, data, 0o600); err != nil {
+		fmt.Printf("export error: %v\n", err)
+		exit(exitInfra)
+	}
+	fmt.Printf("exported %d %s to %s\n", len(bundle.Jobs), util.Plural(len(bundle.Jobs), "job"), *outputFlag)
+}
+
+// doImportState registers the jobs of a bundle written by export-state.
+func doImportState(args []string) {
+	fs := newFlagSet("import-state")
+	replaceFlag := fs.Bool("replace", false, "overwrite jobs that are already registered")
+	var maps stringList
+	fs.Var(&maps, "map", "rewrite a path prefix, as OLD=NEW (repeatable), e.g. when the home directory changed")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fmt.Println("Usage: devagent import-state <file|-> [--replace] [--map OLD=NEW]")
+		exit(exitConfig)
+	}
+	pathMap := make(map[string]string)
+	for _, m := range maps {
+		from, to, ok := strings.Cut(m, "=")
+		if !ok || from == "" || to == "" {
+			fmt.Printf("invalid --map %q (expected OLD=NEW)\n", m)
+			exit(exitConfig)
+		}
+		pathMap[from] = to
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if positional[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(positional[0])
+	}
+	if err != nil {
+		fmt.Printf("import error: %v\n", err)
+		exit(exitConfig)
+	}
+	// JSON is valid YAML, so one decoder reads both formats.
+	var bundle store.Bundle
+	if err := yaml.Unmarshal(data, &bundle); err != nil {
+		fmt.Printf("invalid bundle: %v\n", err)
+		exit(exitConfig)
+	}
+	if err := bundle.Validate(); err != nil {
+		fmt.Printf("invalid bundle: %v\n", err)
+		exit(exitConfig)
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		fmt.Printf("failed to open state: %v\n", err)
+		exit(exitInfra)
+	}
+	defer st.Close()
+	result, err := st.Import(context.Background(), &bundle, store.ImportOptions{Replace: *replaceFlag, PathMap: pathMap})
+	for _, path := range result.WrittenWorkflows {
+		fmt.Printf("restored %s\n", path)
+	}
+	for _, name := range result.Imported {
+		fmt.Printf("imported %s\n", name)
+		if job, err := st.GetJob(context.Background(), name); err == nil && job != nil {
+			if _, statErr := os.Stat(job.Repo); statErr != nil && !strings.Contains(job.Repo, "://") {
+				warnf("%s: repo %s does not exist; re-import with --map to point it elsewhere", name, job.Repo)
+			}
+		}
+	}
+	for _, name := range result.Skipped {
+		fmt.Printf("skipped %s (already registered; use --replace to overwrite)\n", name)
+	}
+	if err != nil {
+		fmt.Printf("import error: %v\n", err)
+		exit(exitInfra)
+	}
+}
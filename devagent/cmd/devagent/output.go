@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Commands print results on stdout and everything else through the
+// helpers below, which honour the global --quiet, --verbose and --color
+// options: warnf and infof are silenced by --quiet, debugf only prints
+// with --verbose, and paint colors text for a terminal.
+
+// warnf reports a problem that does not stop the command, unless --quiet
+// is set.
+func warnf(format string, args ...interface{}) {
+	if !globals.quiet {
+		fmt.Fprintf(os.Stderr, paint(os.Stderr, colorYellow, "warning:")+" "+format+"\n", args...)
+	}
+}
+
+// infof prints a progress message to stderr, unless --quiet is set.
+func infof(format string, args ...interface{}) {
+	if !globals.quiet {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// debugf prints a detail to stderr when --verbose is set.
+func debugf(format string, args ...interface{}) {
+	if globals.verbose {
+		fmt.Fprintln(os.Stderr, paint(os.Stderr, colorDim, fmt.Sprintf("devagent: "+format, args...)))
+	}
+}
+
+// ANSI color codes used by paint.
+const (
+	colorDim    = "2"
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+)
+
+// useColor reports whether output to f is colored: always with
+// --color always, and with the default --color auto when f is a terminal,
+// NO_COLOR is unset and TERM is not dumb.
+func useColor(f *os.File) bool {
+	switch globals.color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(f)
+}
+
+// paint wraps text in the color code when output to f is colored.
+func paint(f *os.File, code, text string) string {
+	if !useColor(f) {
+		return text
+	}
+	return "\x1b[" + code + "m" + text + "\x1b[0m"
+}
+
+// paintStatus colors a run status for f: green for success, red for
+// failures and yellow for the rest, e.g. cancelled or skipped runs.
+func paintStatus(f *os.File, status string) string {
+	switch status {
+	case "success":
+		return paint(f, colorGreen, status)
+	case "failed", "precondition_failed", "timeout":
+		return paint(f, colorRed, status)
+	case "", "unknown":
+		return status
+	}
+	return paint(f, colorYellow, status)
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"devagent/internal/paths"
+	"devagent/internal/store"
+)
+
+// JobStatus is the wire representation of a registered job.
+type JobStatus struct {
+	Host          string     `json:"host,omitempty"`
+	Name          string     `json:"name"`
+	Repo          string     `json:"repo"`
+	Cron          string     `json:"cron,omitempty"`
+	At            string     `json:"at,omitempty"`
+	Every         string     `json:"every,omitempty"`
+	Calendar      string     `json:"calendar,omitempty"`
+	After         string     `json:"after,omitempty"`
+	LastStatus    string     `json:"last_status,omitempty"`
+	LastRun       *time.Time `json:"last_run,omitempty"`
+	FailureStreak int        `json:"failure_streak"`
+	Paused        bool       `json:"paused"`
+	// Running is set while the job has a run in progress.
+	Running *RunProgress `json:"running,omitempty"`
+}
+
+// Health summarises a job for fleet views: paused, unapproved, failing,
+// pending or ok.
+func (j JobStatus) Health() string {
+	switch {
+	case j.Paused:
+		return "paused"
+	case j.LastStatus == store.RunStatusUnapproved:
+		return "unapproved"
+	case j.FailureStreak > 0:
+		return "failing"
+	case j.LastStatus == "":
+		return "pending"
+	default:
+		return "ok"
+	}
+}
+
+// StatusFromJob converts a store job into its API form.
+func StatusFromJob(job store.Job) JobStatus {
+	status := JobStatus{
+		Name:          job.Name,
+		Repo:          job.Repo,
+		Cron:          job.Cron(),
+		At:            job.At(),
+		Every:         job.Every(),
+		Calendar:      job.Calendar(),
+		After:         job.After(),
+		FailureStreak: job.FailureStreak,
+		Paused:        job.Paused,
+	}
+	if job.LastStatus.Valid {
+		status.LastStatus = job.LastStatus.String
+	}
+	if job.LastRun.Valid {
+		last := job.LastRun.Time.UTC()
+		status.LastRun = &last
+	}
+	return status
+}
+
+// Handler serves the read-only daemon API. When token is non-empty every
+// request must carry it as a bearer token. Step events published to hub
+// are streamed from /api/runs/stream; a nil hub disables the stream.
+func Handler(st *store.Store, token string, hub *Hub) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jobs, err := st.ListJobs(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		running, err := RunningByJob(r.Context(), st)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := make([]JobStatus, 0, len(jobs))
+		for _, job := range jobs {
+			status := StatusFromJob(job)
+			if p, ok := running[job.Name]; ok {
+				status.Running = &p
+			}
+			out = append(out, status)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+	mux.HandleFunc("/api/runs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		runs, err := st.RunningRuns(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out := make([]RunProgress, 0, len(runs))
+		for _, run := range runs {
+			out = append(out, ProgressFromRun(run))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+	if hub != nil {
+		mux.HandleFunc("/api/runs/stream", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			hub.serveStream(w, r)
+		})
+	}
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// RunningByJob returns the progress of each job's latest run in progress.
+func RunningByJob(ctx context.Context, st *store.Store) (map[string]RunProgress, error) {
+	runs, err := st.RunningRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]RunProgress, len(runs))
+	for _, run := range runs {
+		out[run.Job] = ProgressFromRun(run)
+	}
+	return out, nil
+}
+
+// Remote is another devagent daemon whose jobs appear in fleet status.
+type Remote struct {
+	Name  string `yaml:"name"`
+	URL   string `yaml:"url"`
+	Token string `yaml:"token,omitempty"`
+}
+
+// RemotesPath returns the location of the remotes configuration file.
+func RemotesPath() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "remotes.yml"), nil
+}
+
+// LoadRemotes reads the configured remotes; a missing file means none.
+func LoadRemotes() ([]Remote, error) {
+	path, err := RemotesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cfg struct {
+		Remotes []Remote `yaml:"remotes"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg.Remotes, nil
+}
+
+// FetchJobs lists the jobs of a remote daemon, tagging each with the remote name.
+func FetchJobs(ctx context.Context, client *http.Client, remote Remote) ([]JobStatus, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(remote.URL, "/")+"/api/jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+	if remote.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+remote.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("remote returned status %d", resp.StatusCode)
+	}
+	var jobs []JobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		jobs[i].Host = remote.Name
+	}
+	return jobs, nil
+}
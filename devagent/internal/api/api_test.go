@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/runner"
+	"devagent/internal/store"
+)
+
+func TestFetchJobsFromHandler(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.UpsertJob(context.Background(), store.NewJob("nightly", "~/app", "0 2 * * *", "", "Local", "/tmp/x.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(Handler(st, "secret", nil))
+	defer server.Close()
+
+	if _, err := FetchJobs(context.Background(), server.Client(), Remote{Name: "desktop", URL: server.URL}); err == nil {
+		t.Fatalf("expected unauthorized error without token")
+	}
+	jobs, err := FetchJobs(context.Background(), server.Client(), Remote{Name: "desktop", URL: server.URL, Token: "secret"})
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "nightly" || jobs[0].Host != "desktop" || jobs[0].Health() != "pending" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestRunsAndStream(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	tracker, err := st.BeginRun(ctx, "nightly", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Finish(ctx, "success", "")
+	stepStart := time.Now().Add(-252 * time.Second)
+	if err := tracker.RecordProgress(ctx, store.StepProgress{Step: 3, Total: 7, Cmd: "go test ./...", StartedAt: stepStart, LastOutput: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	hub := NewHub()
+	server := httptest.NewServer(Handler(st, "", hub))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []RunProgress
+	err = json.NewDecoder(resp.Body).Decode(&runs)
+	resp.Body.Close()
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("runs %+v: %v", runs, err)
+	}
+	if got := runs[0].Describe(stepStart.Add(252 * time.Second)); got != "step 3/7 (go test ./...) running for 4m12s" {
+		t.Fatalf("unexpected description %q", got)
+	}
+
+	resp, err = http.Get(server.URL + "/api/runs/stream?job=nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	hub.Publish("other", runner.StepEvent{Kind: runner.EventStepStart, Step: 1, Total: 1})
+	hub.Publish("nightly", runner.StepEvent{Kind: runner.EventOutput, Step: 3, Total: 7, Output: "ok\n"})
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "event: output" || !strings.Contains(lines[1], `"job":"nightly"`) || !strings.Contains(lines[1], `"output":"ok\n"`) {
+		t.Fatalf("unexpected stream: %q", lines)
+	}
+}
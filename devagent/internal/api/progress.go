@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"devagent/internal/runner"
+	"devagent/internal/store"
+)
+
+// RunProgress is the wire representation of a run in progress.
+type RunProgress struct {
+	ID        int64     `json:"id"`
+	Job       string    `json:"job"`
+	StartedAt time.Time `json:"started_at"`
+	// Step is 0 until the first step starts.
+	Step          int        `json:"step,omitempty"`
+	Total         int        `json:"total,omitempty"`
+	Cmd           string     `json:"cmd,omitempty"`
+	StepStartedAt *time.Time `json:"step_started_at,omitempty"`
+	LastOutput    *time.Time `json:"last_output,omitempty"`
+	Stalled       bool       `json:"stalled,omitempty"`
+}
+
+// ProgressFromRun converts a running store run into its API form.
+func ProgressFromRun(run store.Run) RunProgress {
+	p := RunProgress{
+		ID:        run.ID,
+		Job:       run.Job,
+		StartedAt: run.StartedAt.UTC(),
+		Step:      run.Step,
+		Total:     run.StepTotal,
+		Cmd:       run.StepCmd,
+		Stalled:   run.Stalled,
+	}
+	if run.StepStartedAt.Valid {
+		at := run.StepStartedAt.Time.UTC()
+		p.StepStartedAt = &at
+	}
+	if run.OutputAt.Valid {
+		at := run.OutputAt.Time.UTC()
+		p.LastOutput = &at
+	}
+	return p
+}
+
+// Describe summarises the run as of now, e.g. "step 3/7 (go test ./...)
+// running for 4m12s".
+func (p RunProgress) Describe(now time.Time) string {
+	if p.Step == 0 || p.StepStartedAt == nil {
+		return fmt.Sprintf("running for %s", now.Sub(p.StartedAt).Round(time.Second))
+	}
+	step := fmt.Sprintf("step %d/%d (%s)", p.Step, p.Total, p.Cmd)
+	if p.Stalled && p.LastOutput != nil {
+		return fmt.Sprintf("%s stalled, no output for %s", step, now.Sub(*p.LastOutput).Round(time.Second))
+	}
+	return fmt.Sprintf("%s running for %s", step, now.Sub(*p.StepStartedAt).Round(time.Second))
+}
+
+// Event is a step event of one of the daemon's runs.
+type Event struct {
+	Job string `json:"job"`
+	runner.StepEvent
+}
+
+// subscriberBuffer is how many events a slow stream client may fall
+// behind before further events are dropped for it.
+const subscriberBuffer = 256
+
+// Hub fans the step events of the daemon's runs out to stream clients.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]string
+}
+
+// NewHub returns a hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]string)}
+}
+
+// Publish passes ev to every client following job or all jobs. It never
+// blocks: a client too slow to keep up misses events.
+func (h *Hub) Publish(job string, ev runner.StepEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch, filter := range h.subs {
+		if filter != "" && filter != job {
+			continue
+		}
+		select {
+		case ch <- Event{Job: job, StepEvent: ev}:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of the events of job, or of every job when
+// job is empty, and a function that ends the subscription.
+func (h *Hub) subscribe(job string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = job
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// serveStream streams step events as server-sent events until the client
+// goes away. The event name is the event kind.
+func (h *Hub) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := h.subscribe(r.URL.Query().Get("job"))
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Kind, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package diagnose gathers the evidence about a failed run that is handed
+// to the planner's model by `devagent why` and self-healing.
+package diagnose
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/runner"
+	"devagent/internal/store"
+)
+
+// Report describes a failed run for diagnosis: the workflow steps with
+// their exit codes, the failing step's output tail and the state of the
+// repository.
+func Report(ctx context.Context, st *store.Store, job store.Job, run *store.Run) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Job: %s\nRun: %d, status %s, started %s\n", job.Name, run.ID, run.Status, run.StartedAt.UTC().Format(time.RFC3339))
+
+	var summary *runner.Summary
+	if run.RunDir != "" {
+		if s, err := runner.LoadSummary(run.RunDir); err == nil {
+			summary = s
+		}
+	}
+	repo := ""
+	if summary != nil {
+		repo = summary.Repo
+		b.WriteString("\nSteps:\n")
+		for i, step := range summary.Steps {
+			if step.Skipped {
+				fmt.Fprintf(&b, "  %d. %s (skipped, inputs unchanged)\n", i+1, step.Cmd)
+				continue
+			}
+			fmt.Fprintf(&b, "  %d. %s (exit %d, %.1fs)\n", i+1, step.Cmd, step.ExitCode, step.DurationSec)
+		}
+		if tail := summary.FailureTail(); tail != "" {
+			b.WriteString("\nOutput tail of the failing step:\n" + tail + "\n")
+		}
+	} else {
+		b.WriteString("\nThe run left no summary; it failed before its steps ran or its process died.\n")
+		if run.WorkflowHash != "" {
+			if rev, err := st.FindRevision(ctx, job.Name, run.WorkflowHash); err == nil {
+				b.WriteString("\nWorkflow:\n" + rev.Content + "\n")
+			}
+		}
+	}
+	if repo == "" {
+		if wf, err := dsl.Load(job.YAMLPath()); err == nil {
+			repo, _ = wf.ExpandRepo()
+		}
+	}
+	if repo != "" {
+		fmt.Fprintf(&b, "\nRepository: %s\n", repo)
+		b.WriteString(gitMetadata(repo))
+	}
+	return b.String()
+}
+
+// gitMetadata summarises the branch, recent commits and uncommitted
+// changes of repo. Commands that fail are left out.
+func gitMetadata(repo string) string {
+	var b strings.Builder
+	sections := []struct {
+		label string
+		args  []string
+	}{
+		{"Branch", []string{"rev-parse", "--abbrev-ref", "HEAD"}},
+		{"Recent commits", []string{"log", "-5", "--format=%h %ad %an: %s", "--date=short"}},
+		{"Uncommitted changes", []string{"status", "--short"}},
+	}
+	for _, sec := range sections {
+		cmd := exec.Command("git", sec.args...)
+		cmd.Dir = repo
+		out, err := cmd.Output()
+		if err != nil {
+			continue
+		}
+		text := strings.TrimRight(string(out), "\n")
+		switch {
+		case text == "":
+			fmt.Fprintf(&b, "%s: none\n", sec.label)
+		case strings.Contains(text, "\n"):
+			fmt.Fprintf(&b, "%s:\n%s\n", sec.label, text)
+		default:
+			fmt.Fprintf(&b, "%s: %s\n", sec.label, text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,272 @@
+// Package digest summarizes every job's runs over a day or week into one
+// notification, configured in the global config.yml.
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"devagent/internal/notify"
+	"devagent/internal/paths"
+	"devagent/internal/store"
+	"devagent/internal/util"
+)
+
+// Config is the digest section of the global config file.
+type Config struct {
+	// Every is "daily" or "weekly".
+	Every string `yaml:"every"`
+	// At is the local time of day the digest is sent, e.g. "08:00".
+	At string `yaml:"at,omitempty"`
+	// Weekday is the day weekly digests are sent; defaults to Monday.
+	Weekday string `yaml:"weekday,omitempty"`
+	// Channel is a notify channel: "desktop", a push channel such as
+	// "ntfy:<topic>", or a shell command that receives the digest in
+	// DEVAGENT_TITLE and DEVAGENT_MESSAGE.
+	Channel string `yaml:"channel"`
+}
+
+// ConfigPath returns the location of the global config file:
+// $DEVAGENT_CONFIG (set by the global --config flag) or config.yml in the
+// config directory.
+func ConfigPath() (string, error) {
+	if path := os.Getenv("DEVAGENT_CONFIG"); path != "" {
+		return filepath.Abs(path)
+	}
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yml"), nil
+}
+
+// LoadConfig reads the digest section of the global config; nil means no
+// digest is configured.
+func LoadConfig() (*Config, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cfg struct {
+		Digest *Config `yaml:"digest"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.Digest == nil {
+		return nil, nil
+	}
+	if err := cfg.Digest.validate(); err != nil {
+		return nil, fmt.Errorf("%s: digest %w", path, err)
+	}
+	return cfg.Digest, nil
+}
+
+func (c *Config) validate() error {
+	if c.Every != "daily" && c.Every != "weekly" {
+		return fmt.Errorf("every must be daily or weekly, got %q", c.Every)
+	}
+	if strings.TrimSpace(c.Channel) == "" {
+		return errors.New("channel is required")
+	}
+	if _, _, err := c.timeOfDay(); err != nil {
+		return err
+	}
+	if _, err := c.weekday(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) timeOfDay() (int, int, error) {
+	if c.At == "" {
+		return 8, 0, nil
+	}
+	t, err := time.Parse("15:04", c.At)
+	if err != nil {
+		return 0, 0, fmt.Errorf("at must be HH:MM, got %q", c.At)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+func (c *Config) weekday() (time.Weekday, error) {
+	if c.Weekday == "" {
+		return time.Monday, nil
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(c.Weekday, day.String()) || strings.EqualFold(c.Weekday, day.String()[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", c.Weekday)
+}
+
+// Period returns the latest digest period that ended at or before now: the
+// day or week up to the most recent scheduled send time.
+func (c *Config) Period(now time.Time) (time.Time, time.Time) {
+	hour, minute, _ := c.timeOfDay()
+	end := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if end.After(now) {
+		end = end.AddDate(0, 0, -1)
+	}
+	if c.Every != "weekly" {
+		return end.AddDate(0, 0, -1), end
+	}
+	day, _ := c.weekday()
+	for end.Weekday() != day {
+		end = end.AddDate(0, 0, -1)
+	}
+	return end.AddDate(0, 0, -7), end
+}
+
+// Due returns the period to send when it ended after the last digest sent,
+// so a daemon that was down at the scheduled time catches up once.
+func Due(ctx context.Context, st *store.Store, cfg *Config, now time.Time) (time.Time, time.Time, bool, error) {
+	start, end := cfg.Period(now)
+	last, err := st.LastDigestEnd(ctx)
+	if err != nil {
+		return start, end, false, err
+	}
+	return start, end, last.Before(end), nil
+}
+
+// Collect builds the digest for [start, end) from the store, comparing
+// durations with the period before it.
+func Collect(ctx context.Context, st *store.Store, start, end time.Time) (notify.Message, error) {
+	jobs, err := st.ListJobs(ctx)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	runs, err := st.RunsBetween(ctx, start, end)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	previous, err := st.RunsBetween(ctx, start.Add(-end.Sub(start)), start)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	return Build(names, runs, previous, start, end), nil
+}
+
+// jobStats aggregates one job's runs in a period.
+type jobStats struct {
+	runs, failed, skipped int
+	failures              map[string]int
+	total                 time.Duration
+	timed                 int
+	last                  string
+}
+
+func (s jobStats) average() time.Duration {
+	if s.timed == 0 {
+		return 0
+	}
+	return s.total / time.Duration(s.timed)
+}
+
+func aggregate(runs []store.Run) map[string]*jobStats {
+	stats := make(map[string]*jobStats)
+	for _, run := range runs {
+		s := stats[run.Job]
+		if s == nil {
+			s = &jobStats{failures: make(map[string]int)}
+			stats[run.Job] = s
+		}
+		s.runs++
+		s.last = run.Status
+		switch run.Status {
+		case "success", store.RunStatusCancelled:
+		case store.RunStatusSkipped, store.RunStatusHoliday:
+			s.skipped++
+			continue
+		default:
+			s.failed++
+			s.failures[run.Status]++
+		}
+		if run.EndedAt.Valid {
+			s.total += run.EndedAt.Time.Sub(run.StartedAt)
+			s.timed++
+		}
+	}
+	return stats
+}
+
+// Build summarizes runs, the runs started in [start, end), per job: run and
+// failure counts, average duration against the previous period's runs, and
+// the last status. Jobs without runs are listed last.
+func Build(jobs []string, runs, previous []store.Run, start, end time.Time) notify.Message {
+	current, before := aggregate(runs), aggregate(previous)
+	seen := make(map[string]bool)
+	var active []string
+	for name := range current {
+		active = append(active, name)
+		seen[name] = true
+	}
+	sort.Strings(active)
+	var idle []string
+	for _, name := range jobs {
+		if !seen[name] {
+			idle = append(idle, name)
+		}
+	}
+	sort.Strings(idle)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "devagent digest for %s to %s\n", start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"))
+	totalRuns, totalFailed := 0, 0
+	for _, name := range active {
+		s := current[name]
+		totalRuns += s.runs
+		totalFailed += s.failed
+		line := fmt.Sprintf("%s: %d %s, %d failed", name, s.runs, util.Plural(s.runs, "run"), s.failed)
+		if s.failed > 0 {
+			var kinds []string
+			for status, n := range s.failures {
+				kinds = append(kinds, fmt.Sprintf("%s %d", status, n))
+			}
+			sort.Strings(kinds)
+			line += " (" + strings.Join(kinds, ", ") + ")"
+		}
+		if s.skipped > 0 {
+			line += fmt.Sprintf(", %d skipped", s.skipped)
+		}
+		if avg := s.average(); avg > 0 {
+			line += ", avg " + avg.Round(time.Second).String()
+			if prev := before[name]; prev != nil && prev.average() > 0 {
+				change := float64(avg-prev.average()) / float64(prev.average()) * 100
+				line += fmt.Sprintf(" (%+.0f%% vs previous)", change)
+			}
+		}
+		line += ", last " + s.last
+		body.WriteString(line + "\n")
+	}
+	if len(idle) > 0 {
+		fmt.Fprintf(&body, "no runs: %s\n", strings.Join(idle, ", "))
+	}
+
+	return notify.Message{
+		Status: "digest",
+		Title:  fmt.Sprintf("devagent digest: %d %s, %d failed", totalRuns, util.Plural(totalRuns, "run"), totalFailed),
+		Body:   strings.TrimRight(body.String(), "\n"),
+		Urgent: totalFailed > 0,
+	}
+}
@@ -0,0 +1,107 @@
+package digest
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/store"
+)
+
+func TestPeriod(t *testing.T) {
+	loc := time.UTC
+	daily := &Config{Every: "daily", At: "08:00"}
+	start, end := daily.Period(time.Date(2024, 6, 5, 7, 0, 0, 0, loc))
+	if !end.Equal(time.Date(2024, 6, 4, 8, 0, 0, 0, loc)) || !start.Equal(end.AddDate(0, 0, -1)) {
+		t.Fatalf("daily before the send time: got %s to %s", start, end)
+	}
+	_, end = daily.Period(time.Date(2024, 6, 5, 9, 0, 0, 0, loc))
+	if !end.Equal(time.Date(2024, 6, 5, 8, 0, 0, 0, loc)) {
+		t.Fatalf("daily after the send time: got end %s", end)
+	}
+	// 2024-06-05 is a Wednesday.
+	weekly := &Config{Every: "weekly", Weekday: "mon"}
+	start, end = weekly.Period(time.Date(2024, 6, 5, 9, 0, 0, 0, loc))
+	if !end.Equal(time.Date(2024, 6, 3, 8, 0, 0, 0, loc)) || !start.Equal(time.Date(2024, 5, 27, 8, 0, 0, 0, loc)) {
+		t.Fatalf("weekly: got %s to %s", start, end)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	start := time.Date(2024, 6, 4, 8, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	run := func(job, status string, at time.Time, minutes int) store.Run {
+		return store.Run{Job: job, Status: status, StartedAt: at, EndedAt: sql.NullTime{Time: at.Add(time.Duration(minutes) * time.Minute), Valid: true}}
+	}
+	runs := []store.Run{
+		run("build", "success", start.Add(time.Hour), 10),
+		run("build", "failed", start.Add(2*time.Hour), 14),
+		run("report", "success", start.Add(3*time.Hour), 1),
+		run("report", store.RunStatusSkipped, start.Add(4*time.Hour), 0),
+	}
+	previous := []store.Run{run("build", "success", start.Add(-time.Hour), 8)}
+	msg := Build([]string{"build", "report", "lint"}, runs, previous, start, end)
+
+	if msg.Title != "devagent digest: 4 runs, 1 failed" || !msg.Urgent {
+		t.Fatalf("unexpected title %q (urgent %v)", msg.Title, msg.Urgent)
+	}
+	for _, want := range []string{
+		"build: 2 runs, 1 failed (failed 1), avg 12m0s (+50% vs previous), last failed",
+		"report: 2 runs, 0 failed, 1 skipped, avg 1m0s, last skipped",
+		"no runs: lint",
+	} {
+		if !strings.Contains(msg.Body, want) {
+			t.Fatalf("expected %q in:\n%s", want, msg.Body)
+		}
+	}
+}
+
+func TestLoadConfigAndDue(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if cfg, err := LoadConfig(); err != nil || cfg != nil {
+		t.Fatalf("expected no digest without a config file, got %v %v", cfg, err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".devagent"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(home, ".devagent", "config.yml")
+	if err := os.WriteFile(path, []byte("digest:\n  every: hourly\n  channel: desktop\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected an invalid period to be rejected")
+	}
+	if err := os.WriteFile(path, []byte("digest:\n  every: daily\n  at: \"07:30\"\n  channel: desktop\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig()
+	if err != nil || cfg == nil {
+		t.Fatalf("load: %v %v", cfg, err)
+	}
+
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	now := time.Now()
+	start, end, due, err := Due(ctx, st, cfg, now)
+	if err != nil || !due {
+		t.Fatalf("expected the first digest to be due, got %v %v", due, err)
+	}
+	if err := st.RecordDigest(ctx, start, end); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, due, _ := Due(ctx, st, cfg, now); due {
+		t.Fatal("expected the digest not to be due again for the same period")
+	}
+	if _, _, due, _ := Due(ctx, st, cfg, now.AddDate(0, 0, 1)); !due {
+		t.Fatal("expected the next day's digest to be due")
+	}
+}
@@ -0,0 +1,160 @@
+package discover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Target is a named task exposed by a build tool in the repository.
+type Target struct {
+	Tool string // make, task, just, or npm
+	Name string
+}
+
+// Command returns the shell command that runs the target.
+func (t Target) Command() string {
+	if t.Tool == "npm" {
+		return "npm run " + t.Name
+	}
+	return t.Tool + " " + t.Name
+}
+
+// Targets lists the Makefile, Taskfile, justfile, and package.json targets
+// found at the top level of repo. Missing or unparsable files are skipped.
+func Targets(repo string) []Target {
+	var out []Target
+	for _, name := range []string{"GNUmakefile", "makefile", "Makefile"} {
+		if data, err := os.ReadFile(filepath.Join(repo, name)); err == nil {
+			out = append(out, named("make", makeTargets(data))...)
+			break
+		}
+	}
+	for _, name := range []string{"Taskfile.yml", "Taskfile.yaml", "taskfile.yml", "taskfile.yaml"} {
+		if data, err := os.ReadFile(filepath.Join(repo, name)); err == nil {
+			out = append(out, named("task", taskTargets(data))...)
+			break
+		}
+	}
+	for _, name := range []string{"justfile", "Justfile", ".justfile"} {
+		if data, err := os.ReadFile(filepath.Join(repo, name)); err == nil {
+			out = append(out, named("just", justTargets(data))...)
+			break
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(repo, "package.json")); err == nil {
+		out = append(out, named("npm", npmScripts(data))...)
+	}
+	return out
+}
+
+// Match returns the target whose command equals cmd, ignoring surrounding
+// whitespace. It is used to turn planned shell steps into typed steps.
+func Match(targets []Target, cmd string) (Target, bool) {
+	cmd = strings.Join(strings.Fields(cmd), " ")
+	for _, target := range targets {
+		if target.Command() == cmd {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+func named(tool string, names []string) []Target {
+	out := make([]Target, 0, len(names))
+	for _, name := range names {
+		out = append(out, Target{Tool: tool, Name: name})
+	}
+	return out
+}
+
+var makeRulePattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.\-/ ]*?)\s*::?([^=]|$)`)
+
+func makeTargets(data []byte) []string {
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '\t' || line[0] == '#' || line[0] == '.' {
+			continue
+		}
+		if strings.Contains(line, ":=") || strings.Contains(line, "?=") || strings.Contains(line, "+=") {
+			continue
+		}
+		matches := makeRulePattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		for _, name := range strings.Fields(matches[1]) {
+			if strings.ContainsAny(name, "%$") {
+				continue
+			}
+			seen[name] = struct{}{}
+		}
+	}
+	return sortedKeys(seen)
+}
+
+func taskTargets(data []byte) []string {
+	var file struct {
+		Tasks map[string]yaml.Node `yaml:"tasks"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(file.Tasks))
+	for name := range file.Tasks {
+		seen[name] = struct{}{}
+	}
+	return sortedKeys(seen)
+}
+
+var justRecipePattern = regexp.MustCompile(`^@?([A-Za-z_][A-Za-z0-9_-]*)(\s+[^:]*)?:([^=]|$)`)
+
+func justTargets(data []byte) []string {
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "set ") || strings.HasPrefix(line, "alias ") || strings.HasPrefix(line, "export ") {
+			continue
+		}
+		if matches := justRecipePattern.FindStringSubmatch(line); matches != nil {
+			seen[matches[1]] = struct{}{}
+		}
+	}
+	return sortedKeys(seen)
+}
+
+func npmScripts(data []byte) []string {
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(pkg.Scripts))
+	for name := range pkg.Scripts {
+		seen[name] = struct{}{}
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	out := make([]string, 0, len(m))
+	for key := range m {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,71 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTargets(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Makefile":     ".PHONY: test lint\nVERSION := 1.0\nCC = gcc\nbuild: deps\n\tgo build ./...\ntest lint:\n\tgo test ./...\n%.o: %.c\n\t$(CC) -c $<\n",
+		"Taskfile.yml": "version: '3'\ntasks:\n  fmt:\n    cmds: [gofmt -w .]\n  docs: {}\n",
+		"justfile":     "set shell := [\"bash\", \"-c\"]\nname := \"app\"\nrelease version:\n  echo {{version}}\n@check:\n  true\n",
+		"package.json": `{"scripts": {"lint": "eslint .", "test": "jest"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, target := range Targets(dir) {
+		got = append(got, target.Command())
+	}
+	want := []string{
+		"make build", "make lint", "make test",
+		"task docs", "task fmt",
+		"just check", "just release",
+		"npm run lint", "npm run test",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets mismatch:\ngot  %v\nwant %v", got, want)
+	}
+
+	if target, ok := Match(Targets(dir), "  make   test "); !ok || target.Name != "test" {
+		t.Fatalf("expected make test match, got %+v ok=%v", target, ok)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		kinds []string
+		steps []string
+	}{
+		{"go", map[string]string{"go.mod": "module x\n"}, []string{"go"}, []string{"go vet ./...", "go test ./..."}},
+		{"make wins", map[string]string{"go.mod": "module x\n", "Makefile": "lint:\n\tvet\ntest:\n\tgo test\ncheck: lint test\n"}, []string{"make", "go"}, []string{"make lint", "make test"}},
+		{"make check", map[string]string{"Makefile": "build:\n\tcc\ncheck:\n\ttrue\n"}, []string{"make"}, []string{"make check"}},
+		{"make without tests", map[string]string{"Makefile": "build:\n\tcc\n", "go.mod": "module x\n"}, []string{"go"}, []string{"go vet ./...", "go test ./..."}},
+		{"node", map[string]string{"package.json": `{"scripts": {"test": "jest", "build": "tsc", "start": "node ."}}`, "package-lock.json": "{}"}, []string{"node"}, []string{"npm ci", "npm run build", "npm run test"}},
+		{"node without scripts", map[string]string{"package.json": `{}`}, []string{"node"}, nil},
+		{"python", map[string]string{"pyproject.toml": "[tool.ruff]\nline-length = 100\n[tool.pytest.ini_options]\n"}, []string{"python"}, []string{"ruff check .", "python -m pytest -q"}},
+		{"empty", map[string]string{}, nil, nil},
+	}
+	for _, tc := range cases {
+		dir := t.TempDir()
+		for name, content := range tc.files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := Detect(dir)
+		if !reflect.DeepEqual(got.Kinds, tc.kinds) || !reflect.DeepEqual(got.Steps, tc.steps) {
+			t.Errorf("%s: got kinds %v steps %v, want %v %v", tc.name, got.Kinds, got.Steps, tc.kinds, tc.steps)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Project is what Detect found in a repository: the kinds of project it
+// is and the commands that check it, in the order they should run.
+type Project struct {
+	Kinds []string // go, node, python, make, task, or just
+	Steps []string
+}
+
+// checkTargets are the build-tool targets a default workflow runs, in
+// order; check stands in for test when there is no test target.
+var checkTargets = []string{"lint", "test", "check"}
+
+// Detect inspects the top level of repo for go.mod, package.json,
+// pyproject.toml (or requirements.txt, setup.py) and Makefile, Taskfile or
+// justfile, and proposes the commands a scheduled check should run. A
+// build tool with a test or check target is preferred over the language
+// defaults, since it is the project's own entry point.
+func Detect(repo string) Project {
+	var p Project
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(repo, name))
+		return err == nil
+	}
+
+	targets := Targets(repo)
+	for _, tool := range []string{"make", "task", "just"} {
+		names := make(map[string]bool)
+		for _, target := range targets {
+			if target.Tool == tool {
+				names[target.Name] = true
+			}
+		}
+		if !names["test"] && !names["check"] {
+			continue
+		}
+		p.Kinds = append(p.Kinds, tool)
+		for _, name := range checkTargets {
+			if names[name] && !(name == "check" && names["test"]) {
+				p.Steps = append(p.Steps, Target{Tool: tool, Name: name}.Command())
+			}
+		}
+		break
+	}
+	useDefaults := len(p.Steps) == 0
+
+	if exists("go.mod") {
+		p.Kinds = append(p.Kinds, "go")
+		if useDefaults {
+			p.Steps = append(p.Steps, "go vet ./...", "go test ./...")
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(repo, "package.json")); err == nil {
+		p.Kinds = append(p.Kinds, "node")
+		scripts := make(map[string]bool)
+		for _, name := range npmScripts(data) {
+			scripts[name] = true
+		}
+		if useDefaults && (scripts["lint"] || scripts["test"] || scripts["build"]) {
+			if exists("package-lock.json") {
+				p.Steps = append(p.Steps, "npm ci")
+			} else {
+				p.Steps = append(p.Steps, "npm install")
+			}
+			for _, name := range []string{"lint", "build", "test"} {
+				if scripts[name] {
+					p.Steps = append(p.Steps, Target{Tool: "npm", Name: name}.Command())
+				}
+			}
+		}
+	}
+
+	var pyproject []byte
+	python := false
+	for _, name := range []string{"pyproject.toml", "requirements.txt", "setup.py"} {
+		if data, err := os.ReadFile(filepath.Join(repo, name)); err == nil {
+			python = true
+			if name == "pyproject.toml" {
+				pyproject = data
+			}
+		}
+	}
+	if python {
+		p.Kinds = append(p.Kinds, "python")
+		if useDefaults {
+			if strings.Contains(string(pyproject), "[tool.ruff") {
+				p.Steps = append(p.Steps, "ruff check .")
+			}
+			if exists("tests") || exists("test") || strings.Contains(string(pyproject), "pytest") {
+				p.Steps = append(p.Steps, "python -m pytest -q")
+			}
+		}
+	}
+	return p
+}
@@ -1,13 +1,31 @@
 package dsl
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
+	// The drivers sql steps can use; Validate accepts the registered ones.
+	_ "modernc.org/sqlite"
+
+	"devagent/internal/paths"
+	"devagent/internal/plugin"
+	"devagent/internal/power"
+	"devagent/internal/script"
 )
 
 // Workflow represents the persisted YAML specification for a DevAgent job.
@@ -18,23 +36,1085 @@ type Workflow struct {
 	Schedule Schedule `yaml:"schedule"`
 	Steps    []Step   `yaml:"steps"`
 	Outputs  *Outputs `yaml:"outputs,omitempty"`
+	Notify   *Notify  `yaml:"notify,omitempty"`
+	// ReportTo lists URLs that receive every run's summary as signed JSON.
+	ReportTo Endpoints `yaml:"report_to,omitempty"`
+	// OnCancel steps run after the job is cancelled, e.g. to clean up.
+	OnCancel []Step `yaml:"on_cancel,omitempty"`
+	Logs     *Logs  `yaml:"logs,omitempty"`
+	// Archive compresses large logs and copied outputs once a run ends.
+	Archive *Archive `yaml:"archive,omitempty"`
+	Heal    *Heal    `yaml:"heal,omitempty"`
+	// Sandbox, when set, confines every step with an OS sandbox.
+	Sandbox *Sandbox `yaml:"sandbox,omitempty"`
+	// RunAs runs the steps as another OS user when the daemon runs as root.
+	RunAs *RunAs `yaml:"run_as,omitempty"`
+	// Cache lists directories restored before the steps and saved after a
+	// successful run.
+	Cache []Cache `yaml:"cache,omitempty"`
+	// Worktree, when set, runs the steps in a dedicated git worktree so
+	// they never touch uncommitted edits in the repo.
+	Worktree *Worktree `yaml:"worktree,omitempty"`
+	// Lock names a group of jobs, such as every job using the shared test
+	// database, of which only one runs at a time.
+	Lock string `yaml:"lock,omitempty"`
+	// EnvFiles lists dotenv files, relative to the repo, whose variables
+	// are added to every step's environment.
+	EnvFiles []string `yaml:"env_files,omitempty"`
+	// Vars are named values for `${{ vars.<name> }}` in step fields, and
+	// the vars table of script steps. `devagent run --var` overrides them.
+	Vars map[string]string `yaml:"vars,omitempty"`
+	// Credentials lets steps use the user's SSH agent and git credential
+	// helpers, which are withheld by default.
+	Credentials *Credentials `yaml:"credentials,omitempty"`
+	// Shell sets up the step shell like an interactive one.
+	Shell *Shell `yaml:"shell,omitempty"`
+	// Preconditions are checked before any step runs.
+	Preconditions *Preconditions `yaml:"preconditions,omitempty"`
+	// FailIf lists conditions on the run's test results, such as
+	// "coverage < 80", that fail a run whose steps all exited 0.
+	FailIf Conditions `yaml:"fail_if,omitempty"`
+	// Bench records benchmark results and flags regressions against the
+	// job's previous runs.
+	Bench *Bench `yaml:"bench,omitempty"`
+	Meta  *Meta  `yaml:"meta,omitempty"`
 }
 
 // Schedule describes when a job should run.
 type Schedule struct {
-	Natural  string `yaml:"natural,omitempty"`
-	Cron     string `yaml:"cron"`
-	Timezone string `yaml:"timezone,omitempty"`
+	Natural  string   `yaml:"natural,omitempty"`
+	Cron     string   `yaml:"cron"`
+	Timezone string   `yaml:"timezone,omitempty"`
+	Backoff  *Backoff `yaml:"backoff,omitempty"`
+	// Triggers lists git events ("commit", "merge") that also run the job
+	// once hooks are installed with `devagent hooks install`.
+	Triggers []string `yaml:"triggers,omitempty"`
+	// After names another job; this job runs whenever that job succeeds.
+	After string `yaml:"after,omitempty"`
+	// At fires the job exactly once at the given local time
+	// (e.g. 2024-07-01T09:00), after which it is disabled.
+	At string `yaml:"at,omitempty"`
+	// Every runs the job at a fixed interval such as 15m or 2h, as an
+	// alternative to cron.
+	Every string `yaml:"every,omitempty"`
+	// Seconds opts in to sub-minute schedules: a six-field cron whose first
+	// field is the second, and every intervals down to MinEverySeconds.
+	Seconds bool `yaml:"seconds,omitempty"`
+	// Calendar is an .ics file path or URL whose events define run times.
+	// The daemon refreshes it periodically.
+	Calendar string `yaml:"calendar,omitempty"`
+	// RequeueInterrupted re-runs the job when the daemon finds that a
+	// previous run was cut short by a crash.
+	RequeueInterrupted bool `yaml:"requeue_interrupted,omitempty"`
+	// Requires lists conditions ("ac_power", "idle") that must hold before
+	// the daemon starts the job.
+	Requires []string `yaml:"requires,omitempty"`
+	// MinBattery is the lowest battery charge, in percent, at which the
+	// job may start while on battery.
+	MinBattery int `yaml:"min_battery,omitempty"`
+	// MaxLoad is the highest one-minute load average at which the job may
+	// start.
+	MaxLoad float64 `yaml:"max_load,omitempty"`
+	// MinFreeDisk is the free space, e.g. 5GB, the repo's filesystem needs
+	// before the job may start.
+	MinFreeDisk string `yaml:"min_free_disk,omitempty"`
+	// MaxDefer is how long the daemon waits for the requirements above to
+	// hold before skipping the run, e.g. 2h. Empty skips right away.
+	MaxDefer string `yaml:"max_defer,omitempty"`
+	// OnlyIfChanged skips scheduled runs while the repo's HEAD is still
+	// the commit the previous run saw.
+	OnlyIfChanged bool `yaml:"only_if_changed,omitempty"`
+	// Priority orders jobs waiting for a free slot while the daemon runs
+	// its --max-runs limit of jobs: higher first, default 0.
+	Priority int `yaml:"priority,omitempty"`
+	// PreemptAfter lets the job, while it waits for a slot, cancel a
+	// lower-priority run that has been going at least this long, e.g. 30m.
+	// The cancelled job is requeued and resumes from its checkpoint.
+	PreemptAfter string `yaml:"preempt_after,omitempty"`
+	// Holidays names a holiday calendar, a country code such as US or the
+	// path of a YAML file, whose days scheduled runs skip.
+	Holidays string `yaml:"holidays,omitempty"`
+}
+
+// HasRequirements reports whether the schedule gates runs on the state of
+// the machine.
+func (s Schedule) HasRequirements() bool {
+	return len(s.Requires) > 0 || s.MinBattery > 0 || s.MaxLoad > 0 || s.MinFreeDisk != ""
+}
+
+// DeferLimit returns the parsed max_defer, or 0 when unset or invalid.
+func (s Schedule) DeferLimit() time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(s.MaxDefer))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// PreemptLimit returns the parsed preempt_after, or 0 when unset or
+// invalid.
+func (s Schedule) PreemptLimit() time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(s.PreemptAfter))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// MinEvery is the shortest interval accepted by schedule.every, and
+// MinEverySeconds the shortest once schedule.seconds is set.
+const (
+	MinEvery        = time.Minute
+	MinEverySeconds = 5 * time.Second
+)
+
+// cronParser accepts the standard five-field cron expressions used in
+// workflow files, and secondsParser also the six-field ones, led by the
+// second, allowed by schedule.seconds.
+var (
+	cronParser    = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	secondsParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+)
+
+// ParseCron parses a schedule.cron expression. A leading seconds field is
+// only accepted with seconds, the schedule.seconds opt-in.
+func ParseCron(expr string, seconds bool) (cron.Schedule, error) {
+	if seconds {
+		return secondsParser.Parse(expr)
+	}
+	if len(strings.Fields(expr)) == 6 {
+		return nil, errors.New("a seconds field needs schedule.seconds: true")
+	}
+	return cronParser.Parse(expr)
+}
+
+// ParseEvery parses a schedule.every interval. Intervals under a minute
+// are only accepted with seconds, the schedule.seconds opt-in.
+func ParseEvery(value string, seconds bool) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid every interval %q (expected e.g. 15m or 2h)", value)
+	}
+	if seconds && d < MinEverySeconds {
+		return 0, fmt.Errorf("every interval %s is shorter than %s", d, MinEverySeconds)
+	}
+	if !seconds && d < MinEvery {
+		return 0, fmt.Errorf("every interval %s is shorter than %s (set schedule.seconds: true for sub-minute intervals)", d, MinEvery)
+	}
+	return d, nil
+}
+
+// validLockKey reports whether key, the name of a lock group, is safe to
+// use in a file name.
+func validLockKey(key string) bool {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return key != "" && !strings.HasPrefix(key, ".")
 }
 
-// Step represents a shell command step.
+// validVarName reports whether name can be referenced as
+// `${{ vars.<name> }}`.
+func validVarName(name string) bool {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// atLayouts are the accepted formats for schedule.at.
+var atLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// ParseAt parses a schedule.at value. Values without an offset are
+// interpreted in loc.
+func ParseAt(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range atLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid at time %q (expected YYYY-MM-DDTHH:MM)", value)
+}
+
+// Backoff slows a job down after repeated failures. Once the failure streak
+// reaches After, the wait between runs doubles with each further failure up
+// to Max; at PauseAfter failures the job is paused until resumed.
+type Backoff struct {
+	After      int    `yaml:"after,omitempty"`
+	Max        string `yaml:"max,omitempty"`
+	PauseAfter int    `yaml:"pause_after,omitempty"`
+}
+
+// MaxDelay returns the configured backoff cap, defaulting to 24 hours.
+func (b *Backoff) MaxDelay() time.Duration {
+	if b == nil || strings.TrimSpace(b.Max) == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(b.Max))
+	if err != nil || d <= 0 {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+// Step represents a single workflow step. Exactly one field is set: a raw
+// shell command, a notebook, or a typed build-tool target.
 type Step struct {
-	Run string `yaml:"run"`
+	Run      string    `yaml:"run,omitempty"`
+	Notebook *Notebook `yaml:"notebook,omitempty"`
+	Make     string    `yaml:"make,omitempty"`
+	Task     string    `yaml:"task,omitempty"`
+	Just     string    `yaml:"just,omitempty"`
+	NPM      string    `yaml:"npm,omitempty"`
+	HTTP     *HTTP     `yaml:"http,omitempty"`
+	SQL      *SQL      `yaml:"sql,omitempty"`
+	Assert   *Assert   `yaml:"assert,omitempty"`
+	Deps     *Deps     `yaml:"deps,omitempty"`
+	// Plugin runs the installed step plugin devagent-step-<name> with the
+	// settings in With.
+	Plugin string            `yaml:"plugin,omitempty"`
+	With   map[string]string `yaml:"with,omitempty"`
+	// Script is a script in devagent's Lua dialect, run in a devagent
+	// process of its own with the run's outputs and metadata.
+	Script string `yaml:"script,omitempty"`
+	// Name identifies the step for `devagent run --step-filter`; steps
+	// without one are matched by their command.
+	Name string `yaml:"name,omitempty"`
+	// SkipUnlessChanged lists files, directories or globs relative to the
+	// repo; the step is skipped when their contents match the previous
+	// successful run.
+	SkipUnlessChanged []string `yaml:"skip_unless_changed,omitempty"`
 }
 
-// Outputs configures optional output copying.
+// kinds returns the step fields that are set, by YAML key.
+func (s Step) kinds() []string {
+	var kinds []string
+	if s.Run != "" {
+		kinds = append(kinds, "run")
+	}
+	if s.Notebook != nil {
+		kinds = append(kinds, "notebook")
+	}
+	if s.HTTP != nil {
+		kinds = append(kinds, "http")
+	}
+	if s.SQL != nil {
+		kinds = append(kinds, "sql")
+	}
+	if s.Assert != nil {
+		kinds = append(kinds, "assert")
+	}
+	if s.Deps != nil {
+		kinds = append(kinds, "deps")
+	}
+	for _, typed := range []struct{ key, value string }{{"make", s.Make}, {"task", s.Task}, {"just", s.Just}, {"npm", s.NPM}, {"plugin", s.Plugin}, {"script", s.Script}} {
+		if typed.value != "" {
+			kinds = append(kinds, typed.key)
+		}
+	}
+	return kinds
+}
+
+func (s Step) validatePlugin() error {
+	if s.Plugin == "" {
+		if len(s.With) > 0 {
+			return errors.New("with is only for plugin steps")
+		}
+		return nil
+	}
+	if !plugin.ValidName(s.Plugin) {
+		return fmt.Errorf("invalid plugin name %q (use lowercase letters, digits, '.', '_' and '-')", s.Plugin)
+	}
+	return nil
+}
+
+func (s Step) validateScript() error {
+	if s.Script == "" {
+		return nil
+	}
+	return script.Check("script", s.Script)
+}
+
+// TargetCommand returns the shell command for a typed build-tool step, or
+// an empty string for other step kinds.
+func (s Step) TargetCommand() string {
+	switch {
+	case s.Make != "":
+		return "make " + s.Make
+	case s.Task != "":
+		return "task " + s.Task
+	case s.Just != "":
+		return "just " + s.Just
+	case s.NPM != "":
+		return "npm run " + s.NPM
+	}
+	return ""
+}
+
+// HTTP sends a request without going through the shell, e.g. to a deploy
+// hook or health check. $VAR and ${VAR} in the URL, headers and body are
+// expanded from the step environment. The step fails when the request
+// cannot be made or the response status differs from ExpectStatus (any 2xx
+// when unset).
+type HTTP struct {
+	Method       string            `yaml:"method,omitempty"`
+	URL          string            `yaml:"url"`
+	Headers      map[string]string `yaml:"headers,omitempty"`
+	Body         string            `yaml:"body,omitempty"`
+	ExpectStatus int               `yaml:"expect_status,omitempty"`
+	// SaveTo is a file, relative to the repo, the response body is written
+	// to. It cannot leave the repo, even through a symlink.
+	SaveTo  string `yaml:"save_to,omitempty"`
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+func (h *HTTP) validate() error {
+	url := strings.TrimSpace(h.URL)
+	if url == "" {
+		return errors.New("http url is required")
+	}
+	if !strings.HasPrefix(url, "$") && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("http url %q must start with http:// or https://", h.URL)
+	}
+	if strings.Trim(h.Method, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz") != "" {
+		return fmt.Errorf("http method %q is invalid", h.Method)
+	}
+	if h.ExpectStatus != 0 && (h.ExpectStatus < 100 || h.ExpectStatus > 599) {
+		return fmt.Errorf("http expect_status %d is not a status code", h.ExpectStatus)
+	}
+	if h.Timeout != "" {
+		if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("http timeout %q is not a positive duration", h.Timeout)
+		}
+	}
+	if h.SaveTo != "" && (filepath.IsAbs(h.SaveTo) || hasParentRef(h.SaveTo)) {
+		return fmt.Errorf("http save_to %q must be a path inside the repo", h.SaveTo)
+	}
+	return nil
+}
+
+// hasParentRef reports whether path has a ".." element.
+func hasParentRef(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// SQL runs a query through a database driver built into devagent and
+// writes the result rows to a CSV or JSON file in the run directory.
+type SQL struct {
+	Driver string `yaml:"driver"`
+	// DSN is the data source name; DSNEnv names an environment variable,
+	// e.g. from env_files, holding it instead so credentials stay out of
+	// the workflow.
+	DSN    string `yaml:"dsn,omitempty"`
+	DSNEnv string `yaml:"dsn_env,omitempty"`
+	Query  string `yaml:"query"`
+	// Output is the result file name in the run directory; its extension,
+	// .csv or .json, picks the format. Defaults to step-<n>.csv.
+	Output string `yaml:"output,omitempty"`
+}
+
+func (q *SQL) validate() error {
+	if strings.TrimSpace(q.Driver) == "" {
+		return errors.New("sql driver is required")
+	}
+	drivers, known := sql.Drivers(), false
+	for _, driver := range drivers {
+		known = known || driver == q.Driver
+	}
+	if !known {
+		return fmt.Errorf("sql driver %q is not built into devagent (available: %s)", q.Driver, strings.Join(drivers, ", "))
+	}
+	if (q.DSN == "") == (q.DSNEnv == "") {
+		return errors.New("sql needs exactly one of dsn and dsn_env")
+	}
+	if strings.TrimSpace(q.Query) == "" {
+		return errors.New("sql query is required")
+	}
+	if q.Output != "" {
+		if filepath.Base(q.Output) != q.Output || strings.HasPrefix(q.Output, ".") {
+			return fmt.Errorf("sql output %q must be a plain file name", q.Output)
+		}
+		if ext := filepath.Ext(q.Output); ext != ".csv" && ext != ".json" {
+			return fmt.Errorf("sql output %q must end in .csv or .json", q.Output)
+		}
+	}
+	return nil
+}
+
+// Assert checks results explicitly and fails the step with a clear message
+// when a check does not hold. File checks take a path relative to the repo;
+// Command runs in the step shell and must exit 0, with output matching
+// Matches when set. Contains and Matches are regular expressions in which ^
+// and $ match at line boundaries.
+type Assert struct {
+	File string `yaml:"file,omitempty"`
+	// Exists defaults to true; false asserts the file is absent.
+	Exists   *bool  `yaml:"exists,omitempty"`
+	Contains string `yaml:"contains,omitempty"`
+	// JSONPath selects a value in File, e.g. .results[0].status, that
+	// must equal Equals. Non-string values compare as compact JSON.
+	JSONPath string `yaml:"json_path,omitempty"`
+	Equals   string `yaml:"equals,omitempty"`
+	Command  string `yaml:"command,omitempty"`
+	Matches  string `yaml:"matches,omitempty"`
+	// Message is reported in front of the failed check.
+	Message string `yaml:"message,omitempty"`
+}
+
+func (a *Assert) validate() error {
+	if strings.TrimSpace(a.File) == "" && strings.TrimSpace(a.Command) == "" {
+		return errors.New("assert needs a file or a command")
+	}
+	if a.File == "" && (a.Exists != nil || a.Contains != "" || a.JSONPath != "") {
+		return errors.New("assert exists, contains and json_path need a file")
+	}
+	if a.Exists != nil && !*a.Exists && (a.Contains != "" || a.JSONPath != "") {
+		return errors.New("assert cannot check the contents of a file that must not exist")
+	}
+	if (a.JSONPath == "") != (a.Equals == "") {
+		return errors.New("assert json_path and equals go together")
+	}
+	if a.Command == "" && a.Matches != "" {
+		return errors.New("assert matches needs a command")
+	}
+	for _, pattern := range []struct{ key, value string }{{"contains", a.Contains}, {"matches", a.Matches}} {
+		if _, err := regexp.Compile(pattern.value); err != nil {
+			return fmt.Errorf("assert %s: %w", pattern.key, err)
+		}
+	}
+	return nil
+}
+
+// DepsEcosystems lists the package managers deps steps can audit.
+var DepsEcosystems = []string{"go", "npm", "pip"}
+
+// Deps lists the repo's outdated dependencies with the package managers'
+// own tools and fails when one appears that the previous run did not
+// report, so each new update is alerted once.
+type Deps struct {
+	// Ecosystems lists "go", "npm" and "pip"; empty detects them from
+	// go.mod, package.json, and requirements.txt or pyproject.toml.
+	Ecosystems []string `yaml:"ecosystems,omitempty"`
+	// Indirect also reports indirect Go module dependencies.
+	Indirect bool `yaml:"indirect,omitempty"`
+}
+
+// UnmarshalYAML accepts "auto", one ecosystem, a list of ecosystems, or
+// the full mapping.
+func (d *Deps) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if value.Value != "auto" && value.Value != "" {
+			d.Ecosystems = []string{value.Value}
+		}
+		return nil
+	case yaml.SequenceNode:
+		return value.Decode(&d.Ecosystems)
+	}
+	type plain Deps
+	return value.Decode((*plain)(d))
+}
+
+// MarshalYAML writes the short forms when they say everything.
+func (d Deps) MarshalYAML() (interface{}, error) {
+	if d.Indirect {
+		type plain Deps
+		return plain(d), nil
+	}
+	if len(d.Ecosystems) == 0 {
+		return "auto", nil
+	}
+	return d.Ecosystems, nil
+}
+
+func (d *Deps) validate() error {
+	for _, eco := range d.Ecosystems {
+		known := false
+		for _, name := range DepsEcosystems {
+			known = known || eco == name
+		}
+		if !known {
+			return fmt.Errorf("deps ecosystem %q is unknown (expected %s)", eco, strings.Join(DepsEcosystems, ", "))
+		}
+	}
+	return nil
+}
+
+// Notebook executes a Jupyter notebook, papermill-style, with optional
+// parameters. The executed notebook and its extracted outputs are kept as
+// run artifacts.
+type Notebook struct {
+	Path       string            `yaml:"path"`
+	Parameters map[string]string `yaml:"parameters,omitempty"`
+	Kernel     string            `yaml:"kernel,omitempty"`
+}
+
+// UnmarshalYAML accepts either a bare notebook path or the full mapping.
+func (n *Notebook) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		n.Path = value.Value
+		return nil
+	}
+	type plain Notebook
+	return value.Decode((*plain)(n))
+}
+
+// Bench configures benchmark tracking.
+type Bench struct {
+	// Files lists benchmark output files relative to the repo, optionally
+	// globs: `go test -bench` output or benchmark JSON.
+	Files []string `yaml:"files"`
+	// Regression is how much worse than the baseline a result may get
+	// before it is flagged, e.g. "10%"; defaults to 10%.
+	Regression string `yaml:"regression,omitempty"`
+	// Baseline is the number of previous runs whose median is the
+	// baseline; defaults to 5.
+	Baseline int `yaml:"baseline,omitempty"`
+	// FailOnRegression fails the run when a regression is flagged.
+	FailOnRegression bool `yaml:"fail_on_regression,omitempty"`
+}
+
+// Threshold returns the regression threshold in percent.
+func (b *Bench) Threshold() float64 {
+	pct, err := parsePercent(b.Regression)
+	if err != nil || b.Regression == "" {
+		return 10
+	}
+	return pct
+}
+
+// BaselineRuns returns the number of previous runs in the baseline.
+func (b *Bench) BaselineRuns() int {
+	if b.Baseline < 1 {
+		return 5
+	}
+	return b.Baseline
+}
+
+func parsePercent(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || pct < 0 {
+		return 0, fmt.Errorf("%q is not a percentage", s)
+	}
+	return pct, nil
+}
+
+// Conditions is a list of fail_if expressions; a single expression may be
+// written as a plain string.
+type Conditions []string
+
+// UnmarshalYAML accepts either one expression or a list.
+func (c *Conditions) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = Conditions{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// Endpoints lists the URLs of report_to. A single URL may be written as a
+// plain string.
+type Endpoints []string
+
+// UnmarshalYAML accepts either one URL or a list.
+func (e *Endpoints) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = Endpoints{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*e = list
+	return nil
+}
+
+// ConditionMetrics lists the run metrics fail_if expressions can compare.
+var ConditionMetrics = []string{"coverage", "tests_failed", "tests_passed", "tests_skipped", "tests_total"}
+
+// Condition is a parsed fail_if expression: a metric, a comparison and a
+// number, e.g. "tests_failed > 0".
+type Condition struct {
+	Metric string
+	Op     string
+	Value  float64
+}
+
+// ParseCondition parses a fail_if expression.
+func ParseCondition(expr string) (Condition, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 3 {
+		return Condition{}, fmt.Errorf("fail_if %q must be <metric> <op> <number>", expr)
+	}
+	c := Condition{Metric: fields[0], Op: fields[1]}
+	known := false
+	for _, metric := range ConditionMetrics {
+		known = known || c.Metric == metric
+	}
+	if !known {
+		return Condition{}, fmt.Errorf("fail_if %q: unknown metric %q (expected %s)", expr, c.Metric, strings.Join(ConditionMetrics, ", "))
+	}
+	switch c.Op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return Condition{}, fmt.Errorf("fail_if %q: unknown comparison %q", expr, c.Op)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "%"), 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("fail_if %q: %q is not a number", expr, fields[2])
+	}
+	c.Value = value
+	return c, nil
+}
+
+// Holds reports whether the condition is true for the metric value v.
+func (c Condition) Holds(v float64) bool {
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "==":
+		return v == c.Value
+	case "!=":
+		return v != c.Value
+	}
+	return false
+}
+
+// Outputs configures optional output copying and the values published to
+// downstream jobs. Publish maps an output name to a file in the repo.
 type Outputs struct {
-	CopyIfExists []string `yaml:"copy_if_exists,omitempty"`
+	CopyIfExists []string          `yaml:"copy_if_exists,omitempty"`
+	Publish      map[string]string `yaml:"publish,omitempty"`
+	// Reports lists test reports and coverage files, relative to the repo
+	// and optionally globs, whose counts are recorded with each run.
+	Reports []string `yaml:"reports,omitempty"`
+}
+
+// HasTrigger reports whether the schedule lists the given git event.
+func (s Schedule) HasTrigger(event string) bool {
+	for _, trigger := range s.Triggers {
+		if trigger == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify configures failure notifications. Channels are "desktop", a push
+// channel ("ntfy:<topic>", "pushover", "telegram:<chat id>"), a notify plugin
+// ("plugin:<name>[:<target>]"), or a shell command that receives the
+// message through DEVAGENT_* variables.
+type Notify struct {
+	OnFailure  string `yaml:"on_failure,omitempty"`
+	Escalate   string `yaml:"escalate,omitempty"`
+	AlertAfter int    `yaml:"alert_after,omitempty"`
+	// Incident opens an incident with an on-call service while the job
+	// keeps failing and resolves it when the job recovers.
+	Incident *Incident `yaml:"incident,omitempty"`
+}
+
+// Incident configures incidents in PagerDuty (Events API v2) or Opsgenie.
+type Incident struct {
+	// Provider is "pagerduty" or "opsgenie".
+	Provider string `yaml:"provider"`
+	// After is the failure streak that opens the incident; defaults to 1.
+	After int `yaml:"after,omitempty"`
+	// Severity is critical, error, warning or info; defaults to error.
+	Severity string `yaml:"severity,omitempty"`
+	// CriticalAfter raises the severity to critical once the streak
+	// reaches it; 0 never raises it.
+	CriticalAfter int `yaml:"critical_after,omitempty"`
+	// KeyEnv names the environment variable holding the PagerDuty routing
+	// key or Opsgenie API key; defaults to DEVAGENT_PAGERDUTY_ROUTING_KEY or
+	// DEVAGENT_OPSGENIE_API_KEY.
+	KeyEnv string `yaml:"key_env,omitempty"`
+}
+
+// Threshold returns the failure streak at which the incident opens.
+func (i *Incident) Threshold() int {
+	if i.After < 1 {
+		return 1
+	}
+	return i.After
+}
+
+// SeverityFor returns the incident severity for a failure streak.
+func (i *Incident) SeverityFor(streak int) string {
+	if i.CriticalAfter > 0 && streak >= i.CriticalAfter {
+		return "critical"
+	}
+	if i.Severity == "" {
+		return "error"
+	}
+	return i.Severity
+}
+
+func (i *Incident) validate() error {
+	if i.Provider != "pagerduty" && i.Provider != "opsgenie" {
+		return fmt.Errorf("incident provider must be pagerduty or opsgenie, got %q", i.Provider)
+	}
+	switch i.Severity {
+	case "", "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("incident severity must be critical, error, warning or info, got %q", i.Severity)
+	}
+	if i.After < 0 || i.CriticalAfter < 0 {
+		return errors.New("incident after and critical_after must not be negative")
+	}
+	return nil
+}
+
+// Meta records where a generated workflow came from so it can be re-planned
+// from the original specification.
+type Meta struct {
+	Spec    string `yaml:"spec,omitempty"`
+	Planner string `yaml:"planner,omitempty"`
+	// Model is the LLM that planned the workflow, or "heuristic".
+	Model       string `yaml:"model,omitempty"`
+	GeneratedAt string `yaml:"generated_at,omitempty"`
+	// Instructions lists the changes requested by `devagent replan`.
+	Instructions []string `yaml:"instructions,omitempty"`
+}
+
+// Logs controls how run logs are written.
+type Logs struct {
+	// StripANSI removes terminal escape sequences from log files.
+	StripANSI bool `yaml:"strip_ansi,omitempty"`
+	// MaxSize caps each log file, e.g. "50MB". Empty means unlimited.
+	MaxSize string `yaml:"max_size,omitempty"`
+	// Rotate keeps this many older log segments (run.log.1, ...) once
+	// MaxSize is reached; zero truncates the log instead.
+	Rotate int `yaml:"rotate,omitempty"`
+	// Heartbeat is how often a line noting that a step is still running
+	// is logged, e.g. 30s; "0" turns it off. Defaults to DefaultHeartbeat.
+	Heartbeat string `yaml:"heartbeat,omitempty"`
+	// StalledAfter flags a step that produced no output for this long,
+	// e.g. 15m, as stalled. Empty never flags a step.
+	StalledAfter string `yaml:"stalled_after,omitempty"`
+}
+
+// DefaultHeartbeat is the heartbeat interval when logs.heartbeat is unset.
+const DefaultHeartbeat = time.Minute
+
+// Archive controls how a finished run's files are stored.
+type Archive struct {
+	// CompressOver gzips run logs and copied outputs larger than this size,
+	// e.g. "1MB".
+	CompressOver string `yaml:"compress_over"`
+}
+
+// CompressBytes returns the parsed CompressOver, or 0 when files are not
+// compressed.
+func (a *Archive) CompressBytes() int64 {
+	if a == nil {
+		return 0
+	}
+	n, err := ParseSize(a.CompressOver)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Heal opts a workflow into agent-proposed remediation: after a failed run
+// the planner's model suggests commands (e.g. clear a cache) to run before
+// retrying. Proposals run only once approved with `devagent heal`, unless
+// AutoHeal is set and every command matches Allow.
+type Heal struct {
+	AutoHeal bool `yaml:"auto_heal,omitempty"`
+	// Allow lists the commands the daemon may run unattended. A "*" matches
+	// part of one shell word; commands with shell operators such as ; | &
+	// $ and ` are never allowed.
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+// offline reports whether the workflow's steps are cut off from the network.
+func (wf *Workflow) offline() bool {
+	return wf.Sandbox != nil && wf.Sandbox.Offline
+}
+
+// Sandbox confines steps so they can only write to the repo, the run
+// directory, temporary files and the Writable paths.
+type Sandbox struct {
+	// Tool is bwrap or nsjail on Linux and sandbox-exec on macOS; empty
+	// picks the first one available.
+	Tool string `yaml:"tool,omitempty"`
+	// Offline cuts steps off from the network.
+	Offline bool `yaml:"offline,omitempty"`
+	// Writable lists extra paths steps may write to, such as build caches.
+	Writable []string `yaml:"writable,omitempty"`
+}
+
+// RunAs drops privileges for the steps of a job, for a daemon running as
+// root on a shared machine. Changing the user or groups needs root; the
+// umask applies either way.
+type RunAs struct {
+	// User is the user name or numeric ID steps run as, with that user's
+	// groups, HOME and USER.
+	User string `yaml:"user,omitempty"`
+	// Groups lists supplementary groups, by name or ID, added to the
+	// user's own.
+	Groups []string `yaml:"groups,omitempty"`
+	// Umask is the octal mask for files the steps create, e.g. "027".
+	Umask string `yaml:"umask,omitempty"`
+}
+
+// UmaskValue returns the parsed umask and whether one is set.
+func (r *RunAs) UmaskValue() (uint32, bool, error) {
+	if r == nil || strings.TrimSpace(r.Umask) == "" {
+		return 0, false, nil
+	}
+	mask, err := strconv.ParseUint(strings.TrimSpace(r.Umask), 8, 32)
+	if err != nil || mask > 0o777 {
+		return 0, false, fmt.Errorf("invalid run_as.umask %q (expected octal such as 022)", r.Umask)
+	}
+	return uint32(mask), true, nil
+}
+
+// Credentials opts a job's steps into the user's credentials. Every
+// passthrough is written to the run log.
+type Credentials struct {
+	// SSHAgent passes SSH_AUTH_SOCK to the steps so git and ssh can use
+	// the user's agent. When the daemon was started without it, the socket
+	// is asked of launchd on macOS.
+	SSHAgent bool `yaml:"ssh_agent,omitempty"`
+	// SSHAuthSock is the agent socket to use instead, e.g.
+	// ~/.1password/agent.sock; it implies SSHAgent.
+	SSHAuthSock string `yaml:"ssh_auth_sock,omitempty"`
+	// GitHelper replaces git's credential.helper for the steps, e.g.
+	// osxkeychain, store or "!gh auth git-credential".
+	GitHelper string `yaml:"git_helper,omitempty"`
+	// Pass lists variables withheld because their names look like secrets,
+	// such as GH_TOKEN, that steps may see anyway. Their values are still
+	// redacted from logs.
+	Pass []string `yaml:"pass,omitempty"`
+}
+
+// Worktree checks Ref out into a worktree in the state directory that is
+// reused, reset and cleaned by every run.
+type Worktree struct {
+	// Ref is the branch, tag or commit to run against; empty means the
+	// repo's HEAD.
+	Ref string `yaml:"ref,omitempty"`
+	// Fetch runs `git fetch` in the repo first, e.g. for origin/main.
+	Fetch bool `yaml:"fetch,omitempty"`
+}
+
+// Shell lists the setup that shell profiles normally do, for tools such as
+// nvm, pyenv or direnv that the daemon's non-interactive shell misses. It
+// runs before every step.
+type Shell struct {
+	// Source lists files to source, e.g. ~/.nvm/nvm.sh.
+	Source []string `yaml:"source,omitempty"`
+	// Init lists shell lines to run, e.g. eval "$(pyenv init -)".
+	Init []string `yaml:"init,omitempty"`
+	// Direnv loads the repo's .envrc with direnv.
+	Direnv bool `yaml:"direnv,omitempty"`
+}
+
+// Preconditions describe the repo state a run needs. When one does not hold
+// the run ends as precondition_failed without running any step.
+type Preconditions struct {
+	// Clean requires no uncommitted changes in the repo.
+	Clean bool `yaml:"clean,omitempty"`
+	// Branch is the branch the repo must have checked out.
+	Branch string `yaml:"branch,omitempty"`
+	// Remote names a git remote, e.g. origin, that must be reachable.
+	Remote string `yaml:"remote,omitempty"`
+	// MinFreeDisk is the free space, e.g. 2GB, the repo's filesystem needs.
+	MinFreeDisk string `yaml:"min_free_disk,omitempty"`
+}
+
+// SandboxTools are the accepted values of sandbox.tool.
+var SandboxTools = []string{"bwrap", "nsjail", "sandbox-exec"}
+
+// Cache keeps directories such as installed dependencies between runs.
+// Key may reference `${{ hashFiles('package-lock.json') }}` so the cache is
+// rebuilt when the lockfile changes. Paths are relative to the repo unless
+// absolute or starting with ~, and then must be in devagent's cache
+// directory.
+type Cache struct {
+	Key   string   `yaml:"key"`
+	Paths []string `yaml:"paths"`
+}
+
+// validCachePath checks that a cache path stays in the repo or, when
+// absolute or starting with ~, in devagent's cache directory, since
+// restoring a cache replaces what is there.
+func validCachePath(path string) error {
+	path = strings.TrimSpace(path)
+	if hasParentRef(path) {
+		return fmt.Errorf("path %q must not contain ..", path)
+	}
+	if !filepath.IsAbs(path) && !strings.HasPrefix(path, "~") {
+		return nil
+	}
+	root, err := paths.CacheDir()
+	if err != nil {
+		return err
+	}
+	expanded, err := paths.Expand(path)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, expanded); err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return fmt.Errorf("path %q must be relative to the repo or inside %s", path, root)
+	}
+	return nil
+}
+
+// shellOperators chain, redirect or substitute commands. A command
+// containing one is never allowed, whatever the patterns say.
+const shellOperators = ";|&$`<>()\n"
+
+// wordBreaks end the single shell word an Allow wildcard matches.
+const wordBreaks = " \t'\"\\"
+
+// Allows reports whether command matches one of the Allow patterns.
+func (h *Heal) Allows(command string) bool {
+	if h == nil {
+		return false
+	}
+	command = strings.TrimSpace(command)
+	if strings.ContainsAny(command, shellOperators) {
+		return false
+	}
+	for _, pattern := range h.Allow {
+		if matchAllow(strings.TrimSpace(pattern), command) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAllow matches command against pattern, in which each "*" stands for
+// part of a single shell word that does not climb out of a directory with
+// "..".
+func matchAllow(pattern, command string) bool {
+	if pattern == "" {
+		return false
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern == command
+	}
+	if !strings.HasPrefix(command, pattern[:star]) {
+		return false
+	}
+	rest := pattern[star+1:]
+	for i := star; i <= len(command); i++ {
+		if strings.Contains(command[star:i], "..") {
+			return false
+		}
+		if matchAllow(rest, command[i:]) || (rest == "" && i == len(command)) {
+			return true
+		}
+		if i < len(command) && strings.IndexByte(wordBreaks, command[i]) >= 0 {
+			return false
+		}
+	}
+	return false
+}
+
+// MaxBytes returns the parsed size cap, or 0 when logs are unlimited.
+func (l *Logs) MaxBytes() int64 {
+	if l == nil {
+		return 0
+	}
+	n, err := ParseSize(l.MaxSize)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// HeartbeatInterval returns the parsed heartbeat interval, or 0 when
+// heartbeat lines are turned off.
+func (l *Logs) HeartbeatInterval() time.Duration {
+	if l == nil || l.Heartbeat == "" {
+		return DefaultHeartbeat
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(l.Heartbeat))
+	if err != nil || d < 0 {
+		return DefaultHeartbeat
+	}
+	return d
+}
+
+// StallLimit returns the parsed stalled_after, or 0 when unset or invalid.
+func (l *Logs) StallLimit() time.Duration {
+	if l == nil {
+		return 0
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(l.StalledAfter))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// sizeUnits maps size suffixes to their multipliers, longest first.
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a byte size such as 500KB, 50MB or 1GB. An empty value
+// returns 0.
+func ParseSize(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	if value == "" {
+		return 0, nil
+	}
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			factor = unit.factor
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500KB or 50MB)", size)
+	}
+	return n * factor, nil
 }
 
 // Load reads a workflow from disk.
@@ -43,20 +1123,326 @@ func Load(path string) (*Workflow, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a workflow file's contents.
+func Parse(data []byte) (*Workflow, error) {
 	var wf Workflow
 	if err := yaml.Unmarshal(data, &wf); err != nil {
 		return nil, err
 	}
+	if err := wf.Validate(); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// Hash returns the content hash that identifies a revision of a workflow
+// file.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseStrict decodes and validates a workflow, rejecting unknown fields so
+// typos in hand-edited files are caught.
+func ParseStrict(data []byte) (*Workflow, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var wf Workflow
+	if err := dec.Decode(&wf); err != nil {
+		return nil, err
+	}
+	if err := wf.Validate(); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// Validate checks the workflow for missing or conflicting settings.
+func (wf *Workflow) Validate() error {
 	if wf.Name == "" {
-		return nil, errors.New("workflow name is required")
+		return errors.New("workflow name is required")
 	}
 	if wf.Repo == "" {
-		return nil, errors.New("workflow repo is required")
+		return errors.New("workflow repo is required")
 	}
-	if wf.Schedule.Cron == "" {
-		return nil, errors.New("workflow schedule cron is required")
+	var timing []string
+	for _, field := range []struct{ key, value string }{{"cron", wf.Schedule.Cron}, {"every", wf.Schedule.Every}, {"at", wf.Schedule.At}, {"calendar", wf.Schedule.Calendar}} {
+		if field.value != "" {
+			timing = append(timing, field.key)
+		}
 	}
-	return &wf, nil
+	if len(timing) == 0 && wf.Schedule.After == "" {
+		return errors.New("workflow schedule cron, every, at, calendar, or after is required")
+	}
+	if len(timing) > 1 {
+		return fmt.Errorf("workflow schedule sets more than one of %s", strings.Join(timing, ", "))
+	}
+	if wf.Schedule.At != "" {
+		if _, err := ParseAt(wf.Schedule.At, time.UTC); err != nil {
+			return err
+		}
+	}
+	if wf.Schedule.Every != "" {
+		if _, err := ParseEvery(wf.Schedule.Every, wf.Schedule.Seconds); err != nil {
+			return err
+		}
+	}
+	if wf.Schedule.After == wf.Name {
+		return errors.New("workflow cannot run after itself")
+	}
+	if wf.Notify != nil && wf.Notify.AlertAfter < 0 {
+		return errors.New("notify alert_after must not be negative")
+	}
+	if wf.Notify != nil && wf.Notify.Incident != nil {
+		if err := wf.Notify.Incident.validate(); err != nil {
+			return fmt.Errorf("notify %w", err)
+		}
+	}
+	for i, step := range wf.Steps {
+		if kinds := step.kinds(); len(kinds) > 1 {
+			return fmt.Errorf("step %d sets more than one of %s", i+1, strings.Join(kinds, ", "))
+		}
+		if step.Notebook != nil && strings.TrimSpace(step.Notebook.Path) == "" {
+			return fmt.Errorf("step %d notebook path is required", i+1)
+		}
+		if step.HTTP != nil {
+			if err := step.HTTP.validate(); err != nil {
+				return fmt.Errorf("step %d %w", i+1, err)
+			}
+			if wf.offline() {
+				return fmt.Errorf("step %d is an http step, which sandbox offline forbids", i+1)
+			}
+		}
+		if step.SQL != nil {
+			if err := step.SQL.validate(); err != nil {
+				return fmt.Errorf("step %d %w", i+1, err)
+			}
+			if wf.offline() {
+				return fmt.Errorf("step %d is a sql step, which sandbox offline forbids", i+1)
+			}
+		}
+		if step.Assert != nil {
+			if err := step.Assert.validate(); err != nil {
+				return fmt.Errorf("step %d %w", i+1, err)
+			}
+		}
+		if step.Deps != nil {
+			if err := step.Deps.validate(); err != nil {
+				return fmt.Errorf("step %d %w", i+1, err)
+			}
+		}
+		if err := step.validatePlugin(); err != nil {
+			return fmt.Errorf("step %d %w", i+1, err)
+		}
+		if err := step.validateScript(); err != nil {
+			return fmt.Errorf("step %d %w", i+1, err)
+		}
+		for _, path := range step.SkipUnlessChanged {
+			if strings.TrimSpace(path) == "" {
+				return fmt.Errorf("step %d skip_unless_changed has an empty path", i+1)
+			}
+		}
+	}
+	for i, step := range wf.OnCancel {
+		if kinds := step.kinds(); len(kinds) > 1 {
+			return fmt.Errorf("on_cancel step %d sets more than one of %s", i+1, strings.Join(kinds, ", "))
+		}
+		if step.HTTP != nil {
+			if err := step.HTTP.validate(); err != nil {
+				return fmt.Errorf("on_cancel step %d %w", i+1, err)
+			}
+			if wf.offline() {
+				return fmt.Errorf("on_cancel step %d is an http step, which sandbox offline forbids", i+1)
+			}
+		}
+		if step.SQL != nil {
+			if err := step.SQL.validate(); err != nil {
+				return fmt.Errorf("on_cancel step %d %w", i+1, err)
+			}
+			if wf.offline() {
+				return fmt.Errorf("on_cancel step %d is a sql step, which sandbox offline forbids", i+1)
+			}
+		}
+		if step.Assert != nil {
+			if err := step.Assert.validate(); err != nil {
+				return fmt.Errorf("on_cancel step %d %w", i+1, err)
+			}
+		}
+		if err := step.validatePlugin(); err != nil {
+			return fmt.Errorf("on_cancel step %d %w", i+1, err)
+		}
+		if err := step.validateScript(); err != nil {
+			return fmt.Errorf("on_cancel step %d %w", i+1, err)
+		}
+	}
+	if l := wf.Logs; l != nil {
+		if _, err := ParseSize(l.MaxSize); err != nil {
+			return fmt.Errorf("logs max_size: %w", err)
+		}
+		if l.Rotate < 0 {
+			return errors.New("logs rotate must not be negative")
+		}
+		if l.Heartbeat != "" && l.Heartbeat != "0" {
+			if d, err := time.ParseDuration(l.Heartbeat); err != nil || d < time.Second {
+				return fmt.Errorf("invalid logs heartbeat %q (expected e.g. 30s or 1m, or 0 to turn it off)", l.Heartbeat)
+			}
+		}
+		if l.StalledAfter != "" {
+			if d, err := time.ParseDuration(l.StalledAfter); err != nil || d <= 0 {
+				return fmt.Errorf("invalid logs stalled_after %q (expected e.g. 15m)", l.StalledAfter)
+			}
+		}
+	}
+	if a := wf.Archive; a != nil {
+		if n, err := ParseSize(a.CompressOver); err != nil {
+			return fmt.Errorf("archive compress_over: %w", err)
+		} else if n <= 0 {
+			return errors.New("archive compress_over is required")
+		}
+	}
+	if h := wf.Heal; h != nil && h.AutoHeal && len(h.Allow) == 0 {
+		return errors.New("heal auto_heal requires an allow list")
+	}
+	for i, c := range wf.Cache {
+		if strings.TrimSpace(c.Key) == "" {
+			return fmt.Errorf("cache %d key is required", i+1)
+		}
+		if len(c.Paths) == 0 {
+			return fmt.Errorf("cache %d paths are required", i+1)
+		}
+		for _, path := range c.Paths {
+			switch clean := filepath.Clean(strings.TrimSpace(path)); clean {
+			case ".", "/", "~", "..":
+				return fmt.Errorf("cache %d path %q would replace a whole tree", i+1, path)
+			}
+			if err := validCachePath(path); err != nil {
+				return fmt.Errorf("cache %d %w", i+1, err)
+			}
+		}
+	}
+	for i, file := range wf.EnvFiles {
+		if strings.TrimSpace(file) == "" {
+			return fmt.Errorf("env_files entry %d is empty", i+1)
+		}
+	}
+	for _, expr := range wf.FailIf {
+		if _, err := ParseCondition(expr); err != nil {
+			return err
+		}
+	}
+	if len(wf.FailIf) > 0 && (wf.Outputs == nil || len(wf.Outputs.Reports) == 0) {
+		return errors.New("fail_if needs report files listed under outputs.reports")
+	}
+	if b := wf.Bench; b != nil {
+		if len(b.Files) == 0 {
+			return errors.New("bench files is required")
+		}
+		if b.Regression != "" {
+			if _, err := parsePercent(b.Regression); err != nil {
+				return fmt.Errorf("bench regression: %w", err)
+			}
+		}
+		if b.Baseline < 0 {
+			return errors.New("bench baseline must not be negative")
+		}
+	}
+	if p := wf.Preconditions; p != nil {
+		if _, err := ParseSize(p.MinFreeDisk); err != nil {
+			return fmt.Errorf("preconditions min_free_disk: %w", err)
+		}
+		if strings.HasPrefix(p.Remote, "-") || strings.HasPrefix(p.Branch, "-") {
+			return errors.New("preconditions remote and branch must not start with -")
+		}
+	}
+	for _, endpoint := range wf.ReportTo {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("report_to %q is not an http(s) URL", endpoint)
+		}
+	}
+	if c := wf.Credentials; c != nil {
+		if strings.ContainsAny(c.GitHelper, "\n") {
+			return errors.New("credentials.git_helper must be a single line")
+		}
+		for i, name := range c.Pass {
+			if name == "" || strings.ContainsAny(name, "= \t\n") {
+				return fmt.Errorf("credentials.pass entry %d %q is not a variable name", i+1, name)
+			}
+		}
+	}
+	for name := range wf.Vars {
+		if !validVarName(name) {
+			return fmt.Errorf("invalid var name %q (use letters, digits, '_' and '-')", name)
+		}
+	}
+	if wf.Lock != "" && !validLockKey(wf.Lock) {
+		return fmt.Errorf("invalid lock %q (use letters, digits, '.', '_' and '-')", wf.Lock)
+	}
+	if wt := wf.Worktree; wt != nil && (strings.HasPrefix(wt.Ref, "-") || strings.ContainsAny(wt.Ref, " \t\n")) {
+		return fmt.Errorf("invalid worktree ref %q", wt.Ref)
+	}
+	if sb := wf.Sandbox; sb != nil && sb.Tool != "" {
+		known := false
+		for _, tool := range SandboxTools {
+			known = known || sb.Tool == tool
+		}
+		if !known {
+			return fmt.Errorf("unknown sandbox tool %q (expected %s)", sb.Tool, strings.Join(SandboxTools, ", "))
+		}
+	}
+	if _, _, err := wf.RunAs.UmaskValue(); err != nil {
+		return err
+	}
+	if ra := wf.RunAs; ra != nil && (strings.HasPrefix(ra.User, "-") || strings.ContainsAny(ra.User, " \t\n:")) {
+		return fmt.Errorf("invalid run_as.user %q", ra.User)
+	}
+	for _, req := range wf.Schedule.Requires {
+		known := false
+		for _, name := range power.Requirements {
+			known = known || req == name
+		}
+		if !known {
+			return fmt.Errorf("unknown schedule requirement %q (expected %s)", req, strings.Join(power.Requirements, " or "))
+		}
+	}
+	if wf.Schedule.MinBattery < 0 || wf.Schedule.MinBattery > 100 {
+		return errors.New("schedule min_battery must be between 0 and 100")
+	}
+	if wf.Schedule.MaxLoad < 0 {
+		return errors.New("schedule max_load must not be negative")
+	}
+	if _, err := ParseSize(wf.Schedule.MinFreeDisk); err != nil {
+		return fmt.Errorf("schedule min_free_disk: %w", err)
+	}
+	if wf.Schedule.MaxDefer != "" {
+		if d, err := time.ParseDuration(wf.Schedule.MaxDefer); err != nil || d < 0 {
+			return fmt.Errorf("invalid schedule max_defer %q (expected e.g. 30m or 2h)", wf.Schedule.MaxDefer)
+		}
+	}
+	if wf.Schedule.PreemptAfter != "" {
+		if d, err := time.ParseDuration(wf.Schedule.PreemptAfter); err != nil || d <= 0 {
+			return fmt.Errorf("invalid schedule preempt_after %q (expected e.g. 30m or 2h)", wf.Schedule.PreemptAfter)
+		}
+	}
+	for _, trigger := range wf.Schedule.Triggers {
+		if trigger != "commit" && trigger != "merge" {
+			return fmt.Errorf("unknown schedule trigger %q (expected commit or merge)", trigger)
+		}
+	}
+	if b := wf.Schedule.Backoff; b != nil {
+		if b.After < 0 || b.PauseAfter < 0 {
+			return errors.New("schedule backoff thresholds must not be negative")
+		}
+		if b.Max != "" {
+			if _, err := time.ParseDuration(b.Max); err != nil {
+				return fmt.Errorf("schedule backoff max: %w", err)
+			}
+		}
+	}
+	return nil
 }
 
 // Save writes the workflow to disk with standard permissions.
@@ -74,18 +1460,129 @@ func Save(path string, wf *Workflow) error {
 	return ioutil.WriteFile(path, data, 0o644)
 }
 
+// SetField returns the workflow file content data with the scalar at keys
+// (e.g. "schedule", "after") set to value, adding the keys when they are
+// missing. Comments and key order are kept; the file is re-indented with
+// the indentation it already uses.
+func SetField(data []byte, value string, keys ...string) ([]byte, error) {
+	return editMapping(data, func(root *yaml.Node) error {
+		node := root
+		for i, key := range keys {
+			var next *yaml.Node
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == key {
+					next = node.Content[j+1]
+					break
+				}
+			}
+			if next == nil {
+				next = &yaml.Node{Kind: yaml.MappingNode}
+				if i == len(keys)-1 {
+					next = &yaml.Node{Kind: yaml.ScalarNode}
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+			}
+			if i < len(keys)-1 && next.Kind != yaml.MappingNode {
+				return fmt.Errorf("%s is not a mapping", strings.Join(keys[:i+1], "."))
+			}
+			node = next
+		}
+		if node.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s is not a scalar", strings.Join(keys, "."))
+		}
+		node.Tag = "!!str"
+		node.Value = value
+		return nil
+	})
+}
+
+// RemoveField returns the workflow file content data without the key at
+// keys, e.g. "schedule", "every". Data without the key is returned as it
+// is; otherwise the file is re-encoded as by SetField.
+func RemoveField(data []byte, keys ...string) ([]byte, error) {
+	removed := false
+	edited, err := editMapping(data, func(root *yaml.Node) error {
+		node := root
+		for i, key := range keys {
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value != key {
+					continue
+				}
+				if i == len(keys)-1 {
+					node.Content = append(node.Content[:j], node.Content[j+2:]...)
+					removed = true
+					return nil
+				}
+				if node.Content[j+1].Kind != yaml.MappingNode {
+					return fmt.Errorf("%s is not a mapping", strings.Join(keys[:i+1], "."))
+				}
+				node = node.Content[j+1]
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil || !removed {
+		return data, err
+	}
+	return edited, nil
+}
+
+// editMapping decodes data, a workflow file, lets edit change its top-level
+// mapping, and encodes it again keeping comments and indentation.
+func editMapping(data []byte, edit func(root *yaml.Node) error) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("workflow is not a mapping")
+	}
+	if err := edit(doc.Content[0]); err != nil {
+		return nil, err
+	}
+
+	indent := 4
+	for _, line := range strings.Split(string(data), "\n") {
+		if trimmed := strings.TrimLeft(line, " "); trimmed != line && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			indent = len(line) - len(trimmed)
+			break
+		}
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SetVar overrides one of the workflow's vars for a run. Only declared vars
+// can be set, so that a misspelt name is not silently ignored.
+func (wf *Workflow) SetVar(name, value string) error {
+	if _, ok := wf.Vars[name]; !ok {
+		names := make([]string, 0, len(wf.Vars))
+		for declared := range wf.Vars {
+			names = append(names, declared)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown var %q: %s declares no vars", name, wf.Name)
+		}
+		return fmt.Errorf("unknown var %q (%s declares %s)", name, wf.Name, strings.Join(names, ", "))
+	}
+	wf.Vars[name] = value
+	return nil
+}
+
 // ExpandRepo resolves the workflow repo path, expanding the tilde when present.
 func (wf *Workflow) ExpandRepo() (string, error) {
 	if wf == nil {
 		return "", errors.New("workflow is nil")
 	}
-	repo := strings.TrimSpace(wf.Repo)
-	if strings.HasPrefix(repo, "~") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		repo = filepath.Join(home, strings.TrimPrefix(repo, "~"))
-	}
-	return filepath.Clean(os.ExpandEnv(repo)), nil
+	return paths.Expand(wf.Repo)
 }
@@ -0,0 +1,200 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestHealAllows(t *testing.T) {
+	h := &Heal{Allow: []string{"make clean", "rm -rf .cache/*", "go clean -*"}}
+	cases := map[string]bool{
+		"make clean":                   true,
+		" make clean ":                 true,
+		"make clean all":               false,
+		"rm -rf .cache/pip":            true,
+		"rm -rf .cache/pip; rm -rf ~":  false,
+		"rm -rf .cache/$(echo /)":      false,
+		"go clean -modcache":           true,
+		"go clean -cache && curl x|sh": false,
+		"rm -rf /":                     false,
+		"rm -rf .cache/pip /":          false,
+		"rm -rf .cache/pip\t/":         false,
+		"rm -rf '.cache/x' /":          false,
+		"rm -rf .cache/../..":          false,
+		"go clean -cache -testcache":   false,
+		"make clean > /dev/null":       false,
+	}
+	for cmd, want := range cases {
+		if got := h.Allows(cmd); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", cmd, got, want)
+		}
+	}
+	if (*Heal)(nil).Allows("make clean") {
+		t.Fatalf("nil heal config should allow nothing")
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	c, err := ParseCondition("coverage < 80%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Metric != "coverage" || !c.Holds(79.9) || c.Holds(80) {
+		t.Fatalf("unexpected condition %+v", c)
+	}
+	for _, bad := range []string{"coverage<80", "speed > 1", "tests_failed => 0", "tests_failed > none"} {
+		if _, err := ParseCondition(bad); err == nil {
+			t.Errorf("ParseCondition(%q) should fail", bad)
+		}
+	}
+}
+
+func TestDepsYAMLForms(t *testing.T) {
+	var wf struct {
+		Steps []Step `yaml:"steps"`
+	}
+	doc := "steps:\n  - deps: auto\n  - deps: go\n  - deps: [npm, pip]\n  - deps: {ecosystems: [go], indirect: true}\n"
+	if err := yaml.Unmarshal([]byte(doc), &wf); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, step := range wf.Steps {
+		got = append(got, strings.Join(step.Deps.Ecosystems, "+"))
+	}
+	if strings.Join(got, ",") != ",go,npm+pip,go" || !wf.Steps[3].Deps.Indirect {
+		t.Fatalf("unexpected deps steps %q", got)
+	}
+	out, err := yaml.Marshal(wf.Steps[0])
+	if err != nil || strings.TrimSpace(string(out)) != "deps: auto" {
+		t.Fatalf("expected the short form, got %q %v", out, err)
+	}
+}
+
+func TestSetFieldKeepsComments(t *testing.T) {
+	data := []byte(`# nightly checks
+name: api-tests
+repo: /src/api
+schedule:
+  cron: "0 2 * * *"  # 2am
+steps:
+  - run: go test ./...
+`)
+	out, err := SetField(data, "api-checks", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = SetField(out, "api-nightly", "schedule", "after")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# nightly checks
+name: api-checks
+repo: /src/api
+schedule:
+  cron: "0 2 * * *" # 2am
+  after: api-nightly
+steps:
+  - run: go test ./...
+`
+	if string(out) != want {
+		t.Fatalf("got\n%s\nwant\n%s", out, want)
+	}
+	if _, err := SetField(out, "x", "steps", "run"); err == nil {
+		t.Fatal("expected an error for a key under a list")
+	}
+}
+
+func TestRemoveField(t *testing.T) {
+	data := []byte(`name: api-tests
+repo: /src/api
+schedule:
+  every: 15m # often
+  timezone: UTC
+steps:
+  - run: go test ./...
+`)
+	out, err := RemoveField(data, "schedule", "every")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `name: api-tests
+repo: /src/api
+schedule:
+  timezone: UTC
+steps:
+  - run: go test ./...
+`
+	if string(out) != want {
+		t.Fatalf("got\n%s\nwant\n%s", out, want)
+	}
+	if again, err := RemoveField(out, "schedule", "at"); err != nil || string(again) != string(out) {
+		t.Fatalf("removing a missing key changed the file: %v\n%s", err, again)
+	}
+	if _, err := RemoveField(out, "name", "x"); err == nil {
+		t.Fatal("expected an error for a key under a scalar")
+	}
+}
+
+func TestValidateHTTP(t *testing.T) {
+	wf := &Workflow{Name: "deploy", Repo: "/repo", Schedule: Schedule{Every: "1h"},
+		Steps: []Step{{HTTP: &HTTP{URL: "https://example.com/hook", SaveTo: "out/response.json"}}},
+	}
+	if err := wf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, saveTo := range []string{"/etc/cron.d/x", "../response.json", "out/../../response.json"} {
+		wf.Steps[0].HTTP.SaveTo = saveTo
+		if err := wf.Validate(); err == nil || !strings.Contains(err.Error(), "save_to") {
+			t.Errorf("save_to %q: expected an error, got %v", saveTo, err)
+		}
+	}
+	wf.Steps[0].HTTP.SaveTo = ""
+	wf.Sandbox = &Sandbox{Offline: true}
+	if err := wf.Validate(); err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("expected an offline workflow to refuse http steps, got %v", err)
+	}
+}
+
+func TestValidateSQL(t *testing.T) {
+	wf := &Workflow{Name: "report", Repo: "/repo", Schedule: Schedule{Every: "1h"},
+		Steps: []Step{{SQL: &SQL{Driver: "sqlite", DSN: "metrics.db", Query: "SELECT 1"}}},
+	}
+	if err := wf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	wf.Steps[0].SQL.Driver = "postgres"
+	if err := wf.Validate(); err == nil || !strings.Contains(err.Error(), "available: sqlite") {
+		t.Fatalf("expected an unknown driver to be refused, got %v", err)
+	}
+	wf.Steps[0].SQL.Driver = "sqlite"
+	wf.Sandbox = &Sandbox{Offline: true}
+	if err := wf.Validate(); err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("expected an offline workflow to refuse sql steps, got %v", err)
+	}
+}
+
+func TestValidateCachePaths(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("DEVAGENT_HOME", "")
+	t.Setenv("DEVAGENT_PROFILE", "")
+	wf := &Workflow{Name: "deps", Repo: "/repo", Schedule: Schedule{Every: "1h"}}
+	for path, ok := range map[string]bool{
+		"node_modules":                true,
+		".cache/go":                   true,
+		"~/.cache/devagent/go-mod":    true,
+		"~/.cache/devagent":           false,
+		"~/go/pkg/mod":                false,
+		"/usr/local/lib":              false,
+		"../shared/node_modules":      false,
+		"vendor/../../node_modules":   false,
+		"~/.cache/devagent/../go-mod": false,
+	} {
+		wf.Cache = []Cache{{Key: "k", Paths: []string{path}}}
+		if err := wf.Validate(); (err == nil) != ok {
+			t.Errorf("cache path %q: got %v, want ok=%v", path, err, ok)
+		}
+	}
+}
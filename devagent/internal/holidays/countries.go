@@ -0,0 +1,171 @@
+package holidays
+
+import "time"
+
+// builtin holds the country calendars, by ISO 3166 code. They cover
+// nationwide public holidays only; regional ones and one-off days such as
+// royal events belong in a custom file.
+var builtin = map[string]func(year int) []Holiday{
+	"US": unitedStates,
+	"GB": unitedKingdom,
+	"DE": germany,
+	"FR": france,
+	"CA": canada,
+}
+
+// unitedStates lists the federal holidays. One falling on a Saturday is
+// observed on the Friday before, one on a Sunday on the Monday after.
+func unitedStates(year int) []Holiday {
+	days := []Holiday{
+		{date(year, time.January, 1), "New Year's Day"},
+		{nthWeekday(year, time.January, time.Monday, 3), "Martin Luther King Jr. Day"},
+		{nthWeekday(year, time.February, time.Monday, 3), "Washington's Birthday"},
+		{lastWeekday(year, time.May, time.Monday), "Memorial Day"},
+		{date(year, time.June, 19), "Juneteenth"},
+		{date(year, time.July, 4), "Independence Day"},
+		{nthWeekday(year, time.September, time.Monday, 1), "Labor Day"},
+		{nthWeekday(year, time.October, time.Monday, 2), "Columbus Day"},
+		{date(year, time.November, 11), "Veterans Day"},
+		{nthWeekday(year, time.November, time.Thursday, 4), "Thanksgiving Day"},
+		{date(year, time.December, 25), "Christmas Day"},
+	}
+	for i, h := range days {
+		switch h.Date.Weekday() {
+		case time.Saturday:
+			days[i].Date = h.Date.AddDate(0, 0, -1)
+		case time.Sunday:
+			days[i].Date = h.Date.AddDate(0, 0, 1)
+		}
+	}
+	return days
+}
+
+// unitedKingdom lists the bank holidays of England and Wales.
+func unitedKingdom(year int) []Holiday {
+	easter := easterSunday(year)
+	return substitute([]Holiday{
+		{date(year, time.January, 1), "New Year's Day"},
+		{easter.AddDate(0, 0, -2), "Good Friday"},
+		{easter.AddDate(0, 0, 1), "Easter Monday"},
+		{nthWeekday(year, time.May, time.Monday, 1), "Early May bank holiday"},
+		{lastWeekday(year, time.May, time.Monday), "Spring bank holiday"},
+		{lastWeekday(year, time.August, time.Monday), "Summer bank holiday"},
+		{date(year, time.December, 25), "Christmas Day"},
+		{date(year, time.December, 26), "Boxing Day"},
+	})
+}
+
+func germany(year int) []Holiday {
+	easter := easterSunday(year)
+	return []Holiday{
+		{date(year, time.January, 1), "New Year's Day"},
+		{easter.AddDate(0, 0, -2), "Good Friday"},
+		{easter.AddDate(0, 0, 1), "Easter Monday"},
+		{date(year, time.May, 1), "Labour Day"},
+		{easter.AddDate(0, 0, 39), "Ascension Day"},
+		{easter.AddDate(0, 0, 50), "Whit Monday"},
+		{date(year, time.October, 3), "German Unity Day"},
+		{date(year, time.December, 25), "Christmas Day"},
+		{date(year, time.December, 26), "Second Day of Christmas"},
+	}
+}
+
+func france(year int) []Holiday {
+	easter := easterSunday(year)
+	return []Holiday{
+		{date(year, time.January, 1), "New Year's Day"},
+		{easter.AddDate(0, 0, 1), "Easter Monday"},
+		{date(year, time.May, 1), "Labour Day"},
+		{date(year, time.May, 8), "Victory in Europe Day"},
+		{easter.AddDate(0, 0, 39), "Ascension Day"},
+		{easter.AddDate(0, 0, 50), "Whit Monday"},
+		{date(year, time.July, 14), "Bastille Day"},
+		{date(year, time.August, 15), "Assumption Day"},
+		{date(year, time.November, 1), "All Saints' Day"},
+		{date(year, time.November, 11), "Armistice Day"},
+		{date(year, time.December, 25), "Christmas Day"},
+	}
+}
+
+// canada lists the federal statutory holidays.
+func canada(year int) []Holiday {
+	days := []Holiday{
+		{date(year, time.January, 1), "New Year's Day"},
+		{easterSunday(year).AddDate(0, 0, -2), "Good Friday"},
+		{mondayOnOrBefore(date(year, time.May, 24)), "Victoria Day"},
+		{date(year, time.July, 1), "Canada Day"},
+		{nthWeekday(year, time.September, time.Monday, 1), "Labour Day"},
+	}
+	if year >= 2021 {
+		days = append(days, Holiday{date(year, time.September, 30), "National Day for Truth and Reconciliation"})
+	}
+	days = append(days,
+		Holiday{nthWeekday(year, time.October, time.Monday, 2), "Thanksgiving"},
+		Holiday{date(year, time.November, 11), "Remembrance Day"},
+		Holiday{date(year, time.December, 25), "Christmas Day"},
+		Holiday{date(year, time.December, 26), "Boxing Day"},
+	)
+	return substitute(days)
+}
+
+// substitute moves holidays falling on a weekend to the next weekday that
+// is not already a holiday, as with UK and Canadian substitute days.
+func substitute(days []Holiday) []Holiday {
+	taken := make(map[time.Time]bool, len(days))
+	for _, h := range days {
+		taken[h.Date] = true
+	}
+	for i, h := range days {
+		wd := h.Date.Weekday()
+		if wd != time.Saturday && wd != time.Sunday {
+			continue
+		}
+		d := h.Date
+		for d.Weekday() == time.Saturday || d.Weekday() == time.Sunday || taken[d] {
+			d = d.AddDate(0, 0, 1)
+		}
+		taken[d] = true
+		days[i].Date = d
+	}
+	return days
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// nthWeekday returns the nth (1-based) weekday wd of the month.
+func nthWeekday(year int, month time.Month, wd time.Weekday, n int) time.Time {
+	first := date(year, month, 1)
+	offset := (int(wd) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// lastWeekday returns the last weekday wd of the month.
+func lastWeekday(year int, month time.Month, wd time.Weekday) time.Time {
+	last := date(year, month+1, 0)
+	offset := (int(last.Weekday()) - int(wd) + 7) % 7
+	return last.AddDate(0, 0, -offset)
+}
+
+// easterSunday returns the date of Western Easter (anonymous Gregorian
+// algorithm).
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+// mondayOnOrBefore returns the Monday on or before t.
+func mondayOnOrBefore(t time.Time) time.Time {
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
@@ -0,0 +1,178 @@
+// Package holidays provides public holiday calendars, built in for a few
+// countries or read from YAML files, so business-day jobs can skip days off.
+package holidays
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Holiday is a day off.
+type Holiday struct {
+	// Date is midnight UTC of the day.
+	Date time.Time
+	Name string
+}
+
+// Calendar lists the holidays of every year.
+type Calendar struct {
+	Name  string
+	years func(year int) []Holiday
+}
+
+// Year returns the holidays observed in year, in date order.
+func (c *Calendar) Year(year int) []Holiday {
+	var days []Holiday
+	// Observed days can move across New Year, e.g. a Saturday 1 January
+	// observed on the Friday before.
+	for y := year - 1; y <= year+1; y++ {
+		for _, h := range c.years(y) {
+			if h.Date.Year() == year {
+				days = append(days, h)
+			}
+		}
+	}
+	sort.SliceStable(days, func(i, j int) bool { return days[i].Date.Before(days[j].Date) })
+	return days
+}
+
+// Holiday returns the holiday on the calendar day of t, in t's location.
+func (c *Calendar) Holiday(t time.Time) (string, bool) {
+	day := date(t.Year(), t.Month(), t.Day())
+	for _, h := range c.Year(t.Year()) {
+		if h.Date.Equal(day) {
+			return h.Name, true
+		}
+	}
+	return "", false
+}
+
+// Builtin returns the codes of the built-in country calendars.
+func Builtin() []string {
+	codes := make([]string, 0, len(builtin))
+	for code := range builtin {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// aliases maps other common names to built-in calendar codes.
+var aliases = map[string]string{"UK": "GB"}
+
+// Load returns the calendar named by source: a built-in country code such
+// as US or GB, or the path of a YAML file. Relative paths are resolved
+// against dir, normally the directory of the workflow file.
+func Load(source, dir string) (*Calendar, error) {
+	source = strings.TrimSpace(source)
+	code := strings.ToUpper(source)
+	if alias, ok := aliases[code]; ok {
+		code = alias
+	}
+	if years, ok := builtin[code]; ok {
+		return &Calendar{Name: code, years: years}, nil
+	}
+	if !IsFile(source) {
+		return nil, fmt.Errorf("unknown holiday calendar %q (built in: %s, or a YAML file)", source, strings.Join(Builtin(), ", "))
+	}
+	return loadFile(source, dir)
+}
+
+// IsFile reports whether source names a calendar file rather than a
+// built-in calendar.
+func IsFile(source string) bool {
+	return strings.ContainsAny(source, "/.") || strings.HasPrefix(source, "~")
+}
+
+// file is the format of a custom calendar:
+//
+//	extends: US
+//	holidays:
+//	  - date: 2026-12-24
+//	    name: Christmas Eve
+//	  - date: 12-31        # every year
+//	    name: New Year's Eve
+type file struct {
+	Extends  string `yaml:"extends"`
+	Holidays []struct {
+		Date string `yaml:"date"`
+		Name string `yaml:"name"`
+	} `yaml:"holidays"`
+}
+
+func loadFile(path, dir string) (*Calendar, error) {
+	if strings.HasPrefix(path, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	} else if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("holiday calendar: %w", err)
+	}
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("holiday calendar %s: %w", path, err)
+	}
+	var base *Calendar
+	if f.Extends != "" {
+		code := strings.ToUpper(strings.TrimSpace(f.Extends))
+		if alias, ok := aliases[code]; ok {
+			code = alias
+		}
+		years, ok := builtin[code]
+		if !ok {
+			return nil, fmt.Errorf("holiday calendar %s: unknown calendar %q to extend (built in: %s)", path, f.Extends, strings.Join(Builtin(), ", "))
+		}
+		base = &Calendar{years: years}
+	}
+	type entry struct {
+		once   time.Time
+		yearly bool
+		month  time.Month
+		day    int
+		name   string
+	}
+	var entries []entry
+	for i, h := range f.Holidays {
+		name := h.Name
+		if name == "" {
+			name = "holiday"
+		}
+		value := strings.TrimSpace(h.Date)
+		if t, err := time.Parse("2006-01-02", value); err == nil {
+			entries = append(entries, entry{once: t, name: name})
+			continue
+		}
+		if t, err := time.Parse("01-02", value); err == nil {
+			entries = append(entries, entry{yearly: true, month: t.Month(), day: t.Day(), name: name})
+			continue
+		}
+		return nil, fmt.Errorf("holiday calendar %s: holiday %d: invalid date %q (expected YYYY-MM-DD, or MM-DD for every year)", path, i+1, h.Date)
+	}
+	return &Calendar{Name: path, years: func(year int) []Holiday {
+		var days []Holiday
+		if base != nil {
+			days = base.years(year)
+		}
+		for _, e := range entries {
+			switch {
+			case e.yearly:
+				days = append(days, Holiday{Date: date(year, e.month, e.day), Name: e.name})
+			case e.once.Year() == year:
+				days = append(days, Holiday{Date: e.once, Name: e.name})
+			}
+		}
+		return days
+	}}, nil
+}
@@ -0,0 +1,73 @@
+package holidays
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuiltinCalendars(t *testing.T) {
+	for _, tc := range []struct {
+		calendar string
+		day      string
+		want     string
+	}{
+		{"US", "2026-11-26", "Thanksgiving Day"},
+		{"US", "2026-07-03", "Independence Day"}, // 4 July is a Saturday
+		{"US", "2021-12-31", "New Year's Day"},   // 1 January 2022 is a Saturday
+		{"GB", "2026-04-03", "Good Friday"},
+		{"GB", "2027-12-28", "Boxing Day"}, // Christmas on a Saturday takes the Monday
+		{"uk", "2026-05-25", "Spring bank holiday"},
+		{"DE", "2026-05-14", "Ascension Day"},
+		{"FR", "2026-07-14", "Bastille Day"},
+		{"CA", "2026-05-18", "Victoria Day"},
+	} {
+		cal, err := Load(tc.calendar, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		day, _ := time.Parse("2006-01-02", tc.day)
+		if got, ok := cal.Holiday(day); !ok || got != tc.want {
+			t.Errorf("%s %s = %q, %v; want %q", tc.calendar, tc.day, got, ok, tc.want)
+		}
+	}
+	us, _ := Load("US", "")
+	if name, ok := us.Holiday(time.Date(2026, 11, 25, 23, 0, 0, 0, time.UTC)); ok {
+		t.Fatalf("day before Thanksgiving is %q", name)
+	}
+	if n := len(us.Year(2026)); n != 11 {
+		t.Fatalf("US 2026 has %d holidays", n)
+	}
+	if _, err := Load("XX", ""); err == nil {
+		t.Fatal("expected unknown calendar to be rejected")
+	}
+}
+
+func TestCustomCalendar(t *testing.T) {
+	dir := t.TempDir()
+	content := "extends: US\nholidays:\n  - date: 2026-12-24\n    name: Christmas Eve\n  - date: 12-31\n    name: New Year's Eve\n"
+	if err := os.WriteFile(filepath.Join(dir, "holidays.yml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cal, err := Load("holidays.yml", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for day, want := range map[string]string{"2026-12-24": "Christmas Eve", "2030-12-31": "New Year's Eve", "2026-12-25": "Christmas Day"} {
+		d, _ := time.Parse("2006-01-02", day)
+		if got, ok := cal.Holiday(d); !ok || got != want {
+			t.Errorf("%s = %q, %v; want %q", day, got, ok, want)
+		}
+	}
+	if name, _ := cal.Holiday(time.Date(2028, 12, 24, 12, 0, 0, 0, time.UTC)); name != "" {
+		t.Fatal("one-off holiday repeated the next year")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.yml"), []byte("holidays:\n  - date: tomorrow\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(filepath.Join(dir, "bad.yml"), ""); err == nil {
+		t.Fatal("expected invalid date to be rejected")
+	}
+}
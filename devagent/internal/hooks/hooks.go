@@ -0,0 +1,163 @@
+package hooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Events maps workflow trigger names to the git hooks that fire them.
+var Events = map[string]string{
+	"commit": "post-commit",
+	"merge":  "post-merge",
+}
+
+var eventOrder = []string{"commit", "merge"}
+
+const (
+	beginMarker = "# >>> devagent hook >>>"
+	endMarker   = "# <<< devagent hook <<<"
+)
+
+// RepoRoot returns the top level of the git repository containing dir.
+func RepoRoot(dir string) (string, error) {
+	out, err := gitOutput(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("%s is not inside a git repository: %w", dir, err)
+	}
+	return out, nil
+}
+
+// Install writes (or updates) the devagent block in the post-commit and
+// post-merge hooks of repo. Existing hook content outside the block is kept.
+// A non-empty profile makes the hooks trigger that profile's jobs.
+func Install(repo, binary, profile string) ([]string, error) {
+	dir, err := hooksDir(repo)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, event := range eventOrder {
+		path := filepath.Join(dir, Events[event])
+		existing, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return written, err
+		}
+		content := stripBlock(string(existing))
+		if strings.TrimSpace(content) == "" {
+			content = "#!/bin/sh\n"
+		}
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += hookBlock(binary, profile, event)
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			return written, err
+		}
+		if err := os.Chmod(path, 0o755); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// Uninstall removes the devagent block from the repo hooks, deleting hook
+// files that contain nothing else.
+func Uninstall(repo string) ([]string, error) {
+	dir, err := hooksDir(repo)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, event := range eventOrder {
+		path := filepath.Join(dir, Events[event])
+		existing, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return removed, err
+		}
+		if !strings.Contains(string(existing), beginMarker) {
+			continue
+		}
+		rest := stripBlock(string(existing))
+		if strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), "#!/bin/sh")) == "" {
+			err = os.Remove(path)
+		} else {
+			err = os.WriteFile(path, []byte(rest), 0o755)
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+func hookBlock(binary, profile, event string) string {
+	command := shellQuote(binary)
+	if profile != "" {
+		command += " --profile " + shellQuote(profile)
+	}
+	return fmt.Sprintf(`%s
+# Triggers devagent workflows with "%s" in schedule.triggers. Runs in the
+# background so git is not blocked.
+( %s tick --event %s --repo "$(git rev-parse --show-toplevel)" >/dev/null 2>&1 & )
+%s
+`, beginMarker, event, command, event, endMarker)
+}
+
+func stripBlock(content string) string {
+	start := strings.Index(content, beginMarker)
+	if start < 0 {
+		return content
+	}
+	end := strings.Index(content[start:], endMarker)
+	if end < 0 {
+		return content[:start]
+	}
+	end += start + len(endMarker)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	return content[:start] + content[end:]
+}
+
+func hooksDir(repo string) (string, error) {
+	out, err := gitOutput(repo, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git repository: %w", repo, err)
+	}
+	if !filepath.IsAbs(out) {
+		out = filepath.Join(repo, out)
+	}
+	return out, nil
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,83 @@
+package hooks
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstallAndUninstallKeepUserHooks(t *testing.T) {
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	dir := filepath.Join(repo, ".git", "hooks")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	userHook := "#!/bin/sh\nmake lint\n"
+	postMerge := filepath.Join(dir, "post-merge")
+	if err := os.WriteFile(postMerge, []byte(userHook), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	otherHook := filepath.Join(dir, "pre-push")
+	if err := os.WriteFile(otherHook, []byte("#!/bin/sh\nmake test\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		written, err := Install(repo, "/usr/local/bin/devagent", "work's")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(written) != 2 {
+			t.Fatalf("expected both hooks to be written, got %v", written)
+		}
+	}
+	postCommit := filepath.Join(dir, "post-commit")
+	data, err := os.ReadFile(postCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "#!/bin/sh\n") || strings.Count(string(data), beginMarker) != 1 {
+		t.Fatalf("expected one devagent block after installing twice:\n%s", data)
+	}
+	if !strings.Contains(string(data), `'/usr/local/bin/devagent' --profile 'work'\''s' tick --event commit`) {
+		t.Fatalf("expected the quoted binary and profile in the hook:\n%s", data)
+	}
+	if info, err := os.Stat(postCommit); err != nil || info.Mode()&0o111 == 0 {
+		t.Fatalf("expected an executable hook, got %v %v", info, err)
+	}
+	data, _ = os.ReadFile(postMerge)
+	if !strings.HasPrefix(string(data), userHook) || !strings.Contains(string(data), "tick --event merge") {
+		t.Fatalf("expected the user's post-merge hook to be kept before the block:\n%s", data)
+	}
+
+	removed, err := Uninstall(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected both hooks to be cleaned, got %v", removed)
+	}
+	if _, err := os.Stat(postCommit); !os.IsNotExist(err) {
+		t.Fatalf("expected the devagent-only post-commit hook to be deleted, got %v", err)
+	}
+	if data, _ := os.ReadFile(postMerge); string(data) != userHook {
+		t.Fatalf("expected the user's post-merge hook to be restored, got %q", data)
+	}
+	if data, _ := os.ReadFile(otherHook); string(data) != "#!/bin/sh\nmake test\n" {
+		t.Fatalf("expected hooks devagent does not manage to be left alone, got %q", data)
+	}
+	if removed, err := Uninstall(repo); err != nil || len(removed) != 0 {
+		t.Fatalf("expected a second uninstall to do nothing, got %v %v", removed, err)
+	}
+}
+
+func TestInstallOutsideRepo(t *testing.T) {
+	if _, err := Install(t.TempDir(), "devagent", ""); err == nil || !strings.Contains(err.Error(), "is not a git repository") {
+		t.Fatalf("expected an error outside a git repository, got %v", err)
+	}
+}
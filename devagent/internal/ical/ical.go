@@ -0,0 +1,123 @@
+package ical
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Load reads event start times from an .ics file path or http(s) URL.
+// Times without a zone are interpreted in loc.
+func Load(ctx context.Context, source string, loc *time.Location) ([]time.Time, error) {
+	source = strings.TrimSpace(source)
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "webcal://") {
+		url := source
+		if strings.HasPrefix(url, "webcal://") {
+			url = "https://" + strings.TrimPrefix(url, "webcal://")
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("calendar %s returned status %d", source, resp.StatusCode)
+		}
+		return Parse(resp.Body, loc)
+	}
+	if strings.HasPrefix(source, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		source = filepath.Join(home, strings.TrimPrefix(source, "~"))
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, loc)
+}
+
+// Parse extracts the DTSTART of every VEVENT, sorted ascending. Recurrence
+// rules are not expanded; each event contributes its first occurrence only.
+func Parse(r io.Reader, loc *time.Location) ([]time.Time, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		times   []time.Time
+		inEvent bool
+	)
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent = true
+		case line == "END:VEVENT":
+			inEvent = false
+		case inEvent && strings.HasPrefix(line, "DTSTART"):
+			t, err := parseDateTime(line, loc)
+			if err != nil {
+				return nil, err
+			}
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+// unfold joins RFC 5545 continuation lines (those starting with a space or
+// tab) onto the previous line.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseDateTime handles DTSTART;TZID=Zone:20240701T090000,
+// DTSTART:20240701T090000Z, and DTSTART;VALUE=DATE:20240701.
+func parseDateTime(line string, loc *time.Location) (time.Time, error) {
+	idx := strings.Index(line, ":")
+	if idx < 0 {
+		return time.Time{}, fmt.Errorf("malformed line %q", line)
+	}
+	params, value := line[:idx], strings.TrimSpace(line[idx+1:])
+	for _, param := range strings.Split(params, ";")[1:] {
+		if tzid, ok := strings.CutPrefix(param, "TZID="); ok {
+			if zone, err := time.LoadLocation(strings.Trim(tzid, `"`)); err == nil {
+				loc = zone
+			}
+		}
+	}
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case len(value) == len("20060102"):
+		return time.ParseInLocation("20060102", value, loc)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
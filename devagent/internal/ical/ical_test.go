@@ -0,0 +1,41 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"DTSTART:19700101T000000",
+		"BEGIN:VEVENT",
+		"SUMMARY:Release 1.3 with a very long summary that is folded",
+		"  onto a second line",
+		"DTSTART;TZID=America/New_York:20240715T090000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART:20240701T120000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20240710",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	times, err := Parse(strings.NewReader(ics), time.UTC)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(times) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(times))
+	}
+	want := []string{"2024-07-01T12:00:00Z", "2024-07-10T00:00:00Z", "2024-07-15T13:00:00Z"}
+	for i, w := range want {
+		if got := times[i].UTC().Format(time.RFC3339); got != w {
+			t.Fatalf("event %d: got %s want %s", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package localstats keeps a record of how much devagent is used, day by
+// day, in a file in the state directory. It is never sent anywhere; it
+// exists so `devagent stats --global` can show people their own usage.
+package localstats
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"devagent/internal/paths"
+)
+
+// keepDays is how many days of stats the file keeps.
+const keepDays = 400
+
+// dayLayout names the days in the file, in local time.
+const dayLayout = "2006-01-02"
+
+// Day is the usage of one day.
+type Day struct {
+	Runs         int     `json:"runs"`
+	Failed       int     `json:"failed"`
+	RunSeconds   float64 `json:"run_seconds"`
+	PlannerCalls int     `json:"planner_calls"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+}
+
+// AverageRun is the mean duration of the day's runs.
+func (d Day) AverageRun() time.Duration {
+	if d.Runs == 0 {
+		return 0
+	}
+	return time.Duration(d.RunSeconds / float64(d.Runs) * float64(time.Second))
+}
+
+func (d *Day) add(o Day) {
+	d.Runs += o.Runs
+	d.Failed += o.Failed
+	d.RunSeconds += o.RunSeconds
+	d.PlannerCalls += o.PlannerCalls
+	d.InputTokens += o.InputTokens
+	d.OutputTokens += o.OutputTokens
+}
+
+// file is the content of stats.json.
+type file struct {
+	Days map[string]*Day `json:"days"`
+}
+
+// Path returns the stats file, stats.json in the state directory.
+func Path() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stats.json"), nil
+}
+
+// RecordRun counts a finished run with its final status and duration.
+func RecordRun(status string, d time.Duration) error {
+	return update(time.Now(), func(day *Day) {
+		day.Runs++
+		if status != "success" {
+			day.Failed++
+		}
+		day.RunSeconds += d.Seconds()
+	})
+}
+
+// RecordPlannerCall counts a request to the planner's LLM and the tokens
+// it used.
+func RecordPlannerCall(inputTokens, outputTokens int64) error {
+	return update(time.Now(), func(day *Day) {
+		day.PlannerCalls++
+		day.InputTokens += inputTokens
+		day.OutputTokens += outputTokens
+	})
+}
+
+// update applies fn to the stats of now's day, holding a lock on the file
+// so processes recording at once do not lose each other's counts. Days
+// older than keepDays are dropped.
+func update(now time.Time, fn func(*Day)) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	stats, err := decode(f)
+	if err != nil {
+		return err
+	}
+	key := now.Format(dayLayout)
+	if stats.Days[key] == nil {
+		stats.Days[key] = &Day{}
+	}
+	fn(stats.Days[key])
+	oldest := now.AddDate(0, 0, -keepDays).Format(dayLayout)
+	for day := range stats.Days {
+		if day < oldest {
+			delete(stats.Days, day)
+		}
+	}
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(append(data, '\n'), 0)
+	return err
+}
+
+// decode reads the stats in r; an empty file has none.
+func decode(r io.Reader) (*file, error) {
+	stats := &file{}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, stats); err != nil {
+			return nil, err
+		}
+	}
+	if stats.Days == nil {
+		stats.Days = make(map[string]*Day)
+	}
+	return stats, nil
+}
+
+// DayStats is the usage of the day Date, "2006-01-02".
+type DayStats struct {
+	Date string
+	Day
+}
+
+// Summary is the usage over a range of days.
+type Summary struct {
+	// Days lists the days with any usage, oldest first.
+	Days  []DayStats
+	Total Day
+}
+
+// Summarize returns the usage of the days days up to and including now's.
+// Without a stats file, it returns an empty summary.
+func Summarize(now time.Time, days int) (Summary, error) {
+	var summary Summary
+	path, err := Path()
+	if err != nil {
+		return summary, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return summary, nil
+	}
+	if err != nil {
+		return summary, err
+	}
+	defer f.Close()
+	stats, err := decode(f)
+	if err != nil {
+		return summary, err
+	}
+	first := now.AddDate(0, 0, 1-days).Format(dayLayout)
+	last := now.Format(dayLayout)
+	for date, day := range stats.Days {
+		if date < first || date > last || day == nil {
+			continue
+		}
+		summary.Days = append(summary.Days, DayStats{Date: date, Day: *day})
+		summary.Total.add(*day)
+	}
+	sort.Slice(summary.Days, func(i, j int) bool { return summary.Days[i].Date < summary.Days[j].Date })
+	return summary, nil
+}
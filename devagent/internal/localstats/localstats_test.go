@@ -0,0 +1,77 @@
+package localstats
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordAndSummarize(t *testing.T) {
+	t.Setenv("DEVAGENT_HOME", t.TempDir())
+	now := time.Now()
+	if summary, err := Summarize(now, 30); err != nil || len(summary.Days) != 0 {
+		t.Fatalf("without a stats file: %+v %v", summary, err)
+	}
+
+	// Processes record at once without losing counts.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status := "success"
+			if i%4 == 0 {
+				status = "failed"
+			}
+			if err := RecordRun(status, 10*time.Second); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := RecordPlannerCall(1200, 300); err != nil {
+		t.Fatal(err)
+	}
+
+	// An old day is summarized only when in range, and dropped from the
+	// file once it is older than keepDays.
+	old := now.AddDate(0, 0, -10)
+	if err := update(old, func(d *Day) { d.Runs, d.RunSeconds = 2, 60 }); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := Summarize(now, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Days) != 2 || summary.Days[0].Date != old.Format(dayLayout) {
+		t.Fatalf("days: %+v", summary.Days)
+	}
+	today := summary.Days[1]
+	if today.Runs != 8 || today.Failed != 2 || today.AverageRun() != 10*time.Second || today.PlannerCalls != 1 || today.InputTokens != 1200 || today.OutputTokens != 300 {
+		t.Fatalf("today: %+v", today)
+	}
+	if summary.Total.Runs != 10 || summary.Total.AverageRun() != 14*time.Second {
+		t.Fatalf("total: %+v", summary.Total)
+	}
+	if summary, _ := Summarize(now, 7); len(summary.Days) != 1 {
+		t.Fatalf("last 7 days: %+v", summary.Days)
+	}
+
+	if err := update(now.AddDate(0, 0, keepDays-5), func(*Day) {}); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats.Days[old.Format(dayLayout)]; ok || len(stats.Days) != 2 {
+		t.Fatalf("days kept: %v", stats.Days)
+	}
+}
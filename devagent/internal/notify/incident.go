@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/util"
+)
+
+// Incident endpoints, replaced in tests.
+var (
+	pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieBase = "https://api.opsgenie.com/v2/alerts"
+)
+
+// opsgeniePriority maps incident severities to Opsgenie priorities.
+var opsgeniePriority = map[string]string{
+	"critical": "P1",
+	"error":    "P2",
+	"warning":  "P3",
+	"info":     "P4",
+}
+
+// ForIncident builds the incident to open (or re-trigger) for a finished
+// run once the failure streak reaches the incident threshold, with its
+// severity. Repeated triggers for a job are deduplicated by the provider.
+func ForIncident(cfg *dsl.Notify, job, status string, streak int) (Message, string, bool) {
+	if cfg == nil || cfg.Incident == nil || status == "success" || status == "cancelled" {
+		return Message{}, "", false
+	}
+	if streak < cfg.Incident.Threshold() {
+		return Message{}, "", false
+	}
+	return Message{
+		Job:    job,
+		Status: status,
+		Streak: streak,
+		Title:  fmt.Sprintf("devagent: %s %s", job, status),
+		Body:   fmt.Sprintf("%s has failed %d %s in a row (last status %s)", job, streak, util.Plural(streak, "run"), status),
+		Urgent: true,
+	}, cfg.Incident.SeverityFor(streak), true
+}
+
+// IncidentOpen reports whether a job that had failures consecutive failed
+// runs has an open incident to resolve.
+func IncidentOpen(cfg *dsl.Notify, failures int) bool {
+	return cfg != nil && cfg.Incident != nil && failures >= cfg.Incident.Threshold()
+}
+
+// TriggerIncident opens or updates the incident for msg.Job.
+func TriggerIncident(ctx context.Context, cfg *dsl.Incident, msg Message, severity string) error {
+	key, err := incidentKey(cfg)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	switch cfg.Provider {
+	case "pagerduty":
+		return postJSON(ctx, pagerDutyURL, "", "pagerduty", map[string]any{
+			"routing_key":  key,
+			"event_action": "trigger",
+			"dedup_key":    incidentAlias(msg.Job),
+			"payload": map[string]any{
+				"summary":   msg.Body,
+				"source":    host,
+				"severity":  severity,
+				"component": msg.Job,
+				"custom_details": map[string]any{
+					"status":   msg.Status,
+					"streak":   msg.Streak,
+					"log_tail": msg.LogTail,
+				},
+			},
+		})
+	case "opsgenie":
+		return postJSON(ctx, opsgenieBase, "GenieKey "+key, "opsgenie", map[string]any{
+			"message":     truncate(msg.Title, 130),
+			"alias":       incidentAlias(msg.Job),
+			"description": pushText(msg, 15000),
+			"priority":    opsgeniePriority[severity],
+			"source":      host,
+			"entity":      msg.Job,
+			"tags":        []string{"devagent"},
+			"details":     map[string]string{"status": msg.Status, "streak": fmt.Sprint(msg.Streak)},
+		})
+	}
+	return fmt.Errorf("unknown incident provider %q", cfg.Provider)
+}
+
+// ResolveIncident closes the incident for job.
+func ResolveIncident(ctx context.Context, cfg *dsl.Incident, job string) error {
+	key, err := incidentKey(cfg)
+	if err != nil {
+		return err
+	}
+	switch cfg.Provider {
+	case "pagerduty":
+		return postJSON(ctx, pagerDutyURL, "", "pagerduty", map[string]any{
+			"routing_key":  key,
+			"event_action": "resolve",
+			"dedup_key":    incidentAlias(job),
+		})
+	case "opsgenie":
+		target := opsgenieBase + "/" + url.PathEscape(incidentAlias(job)) + "/close?identifierType=alias"
+		return postJSON(ctx, target, "GenieKey "+key, "opsgenie", map[string]any{
+			"source": "devagent",
+			"note":   job + " recovered",
+		})
+	}
+	return fmt.Errorf("unknown incident provider %q", cfg.Provider)
+}
+
+// incidentAlias identifies a job's incident across triggers and resolves.
+func incidentAlias(job string) string {
+	return "devagent/" + job
+}
+
+func incidentKey(cfg *dsl.Incident) (string, error) {
+	env := cfg.KeyEnv
+	if env == "" {
+		env = "DEVAGENT_PAGERDUTY_ROUTING_KEY"
+		if cfg.Provider == "opsgenie" {
+			env = "DEVAGENT_OPSGENIE_API_KEY"
+		}
+	}
+	key := os.Getenv(env)
+	if key == "" {
+		return "", fmt.Errorf("%s incident needs %s in the daemon's environment", cfg.Provider, env)
+	}
+	return key, nil
+}
+
+func postJSON(ctx context.Context, target, auth, provider string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return doPush(req, provider)
+}
+
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit-3] + "..."
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestForIncidentGatesOnStreak(t *testing.T) {
+	cfg := &dsl.Notify{Incident: &dsl.Incident{Provider: "pagerduty", After: 3, Severity: "warning", CriticalAfter: 5}}
+	if _, _, ok := ForIncident(cfg, "nightly", "failed", 2); ok {
+		t.Fatalf("incident opened below after")
+	}
+	if _, severity, ok := ForIncident(cfg, "nightly", "failed", 3); !ok || severity != "warning" {
+		t.Fatalf("expected warning incident, got %q ok=%v", severity, ok)
+	}
+	if _, severity, ok := ForIncident(cfg, "nightly", "timeout", 5); !ok || severity != "critical" {
+		t.Fatalf("expected critical incident, got %q ok=%v", severity, ok)
+	}
+	if _, _, ok := ForIncident(cfg, "nightly", "success", 0); ok {
+		t.Fatalf("success should not open an incident")
+	}
+	if IncidentOpen(cfg, 2) || !IncidentOpen(cfg, 3) {
+		t.Fatalf("only streaks that opened an incident should resolve one")
+	}
+}
+
+func TestPagerDutyTriggerAndResolve(t *testing.T) {
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	defer func(u string) { pagerDutyURL = u }(pagerDutyURL)
+	pagerDutyURL = srv.URL
+	t.Setenv("TEAM_PD_KEY", "R0UT1NG")
+
+	cfg := &dsl.Incident{Provider: "pagerduty", KeyEnv: "TEAM_PD_KEY"}
+	msg, severity, _ := ForIncident(&dsl.Notify{Incident: cfg}, "nightly", "failed", 1)
+	if err := TriggerIncident(context.Background(), cfg, msg, severity); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveIncident(context.Background(), cfg, "nightly"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	trigger, resolve := events[0], events[1]
+	if trigger["event_action"] != "trigger" || trigger["routing_key"] != "R0UT1NG" || trigger["payload"].(map[string]any)["severity"] != "error" {
+		t.Fatalf("unexpected trigger %v", trigger)
+	}
+	if resolve["event_action"] != "resolve" || resolve["dedup_key"] != trigger["dedup_key"] {
+		t.Fatalf("resolve should reuse the trigger's dedup key: %v", resolve)
+	}
+}
+
+func TestOpsgenieCloseByAlias(t *testing.T) {
+	var paths, auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	defer func(u string) { opsgenieBase = u }(opsgenieBase)
+	opsgenieBase = srv.URL + "/v2/alerts"
+	t.Setenv("DEVAGENT_OPSGENIE_API_KEY", "genie")
+
+	cfg := &dsl.Incident{Provider: "opsgenie"}
+	if err := TriggerIncident(context.Background(), cfg, Message{Job: "nightly", Title: "t"}, "critical"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveIncident(context.Background(), cfg, "nightly"); err != nil {
+		t.Fatal(err)
+	}
+	if paths[0] != "/v2/alerts" || !strings.HasPrefix(paths[1], "/v2/alerts/devagent%2Fnightly/close?identifierType=alias") {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+	if auth[0] != "GenieKey genie" {
+		t.Fatalf("unexpected auth %v", auth)
+	}
+}
+
+func TestIncidentNeedsKey(t *testing.T) {
+	t.Setenv("DEVAGENT_PAGERDUTY_ROUTING_KEY", "")
+	err := ResolveIncident(context.Background(), &dsl.Incident{Provider: "pagerduty"}, "nightly")
+	if err == nil || !strings.Contains(err.Error(), "DEVAGENT_PAGERDUTY_ROUTING_KEY") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/plugin"
+)
+
+// Message describes a notification about a job run.
+type Message struct {
+	Job    string
+	Status string
+	Streak int
+	Title  string
+	Body   string
+	Urgent bool
+	// LogTail is the end of the failed step's output, if known.
+	LogTail string
+}
+
+// ForRun selects the channel and message for a finished run; successful and
+// cancelled runs are not reported. When an escalation channel is configured,
+// failures below alert_after go to on_failure and later ones escalate;
+// otherwise alert_after gates on_failure.
+func ForRun(cfg *dsl.Notify, job, status string, streak int) (string, Message, bool) {
+	if cfg == nil || status == "success" || status == "cancelled" {
+		return "", Message{}, false
+	}
+	msg := Message{
+		Job:    job,
+		Status: status,
+		Streak: streak,
+		Title:  fmt.Sprintf("devagent: %s %s", job, status),
+		Body:   fmt.Sprintf("%s finished with status %s", job, status),
+	}
+	threshold := cfg.AlertAfter
+	if threshold < 1 {
+		threshold = 1
+	}
+	if cfg.Escalate != "" {
+		if streak >= threshold {
+			msg.Urgent = true
+			msg.Title = fmt.Sprintf("devagent: %s is failing repeatedly", job)
+			msg.Body = fmt.Sprintf("%s has failed %d runs in a row (last status %s)", job, streak, status)
+			return cfg.Escalate, msg, true
+		}
+		if cfg.OnFailure != "" {
+			return cfg.OnFailure, msg, true
+		}
+		return "", Message{}, false
+	}
+	if cfg.OnFailure == "" || streak < threshold {
+		return "", Message{}, false
+	}
+	return cfg.OnFailure, msg, true
+}
+
+// ForRecovery builds the message sent when a job succeeds after failures
+// consecutive failed runs. It goes to the channel that was alerted about the
+// last failure, so nothing is sent when those failures were not reported.
+func ForRecovery(cfg *dsl.Notify, job string, failures int) (string, Message, bool) {
+	if failures < 1 {
+		return "", Message{}, false
+	}
+	channel, _, ok := ForRun(cfg, job, "failed", failures)
+	if !ok {
+		return "", Message{}, false
+	}
+	return channel, Message{
+		Job:    job,
+		Status: "recovered",
+		Streak: failures,
+		Title:  fmt.Sprintf("devagent: %s recovered", job),
+		Body:   fmt.Sprintf("%s recovered after %d %s", job, failures, failureWord(failures)),
+	}, true
+}
+
+func failureWord(n int) string {
+	if n == 1 {
+		return "failure"
+	}
+	return "failures"
+}
+
+// ForPause builds the message sent when backoff pauses a job. It prefers the
+// escalation channel and falls back to on_failure.
+func ForPause(cfg *dsl.Notify, job string, streak int) (string, Message, bool) {
+	if cfg == nil {
+		return "", Message{}, false
+	}
+	channel := cfg.Escalate
+	if channel == "" {
+		channel = cfg.OnFailure
+	}
+	if channel == "" {
+		return "", Message{}, false
+	}
+	return channel, Message{
+		Job:    job,
+		Status: "paused",
+		Streak: streak,
+		Title:  fmt.Sprintf("devagent: %s paused", job),
+		Body:   fmt.Sprintf("%s was paused after %d consecutive failures; run `devagent schedule resume %s` to re-enable it", job, streak, job),
+		Urgent: true,
+	}, true
+}
+
+// Send delivers msg over channel. "desktop" posts a macOS notification;
+// "ntfy:<topic or URL>", "pushover" and "telegram:<chat id>" push to a phone
+// (see push.go); "plugin:<name>[:<target>]" runs a notify plugin; any other
+// value is run as a shell command with the message in its environment.
+func Send(ctx context.Context, channel string, msg Message) error {
+	channel = strings.TrimSpace(channel)
+	switch {
+	case channel == "":
+		return nil
+	case channel == "desktop":
+		return sendDesktop(ctx, msg)
+	case strings.HasPrefix(channel, "ntfy:"):
+		return sendNtfy(ctx, strings.TrimPrefix(channel, "ntfy:"), msg)
+	case channel == "pushover":
+		return sendPushover(ctx, msg)
+	case strings.HasPrefix(channel, "telegram:"):
+		return sendTelegram(ctx, strings.TrimPrefix(channel, "telegram:"), msg)
+	case strings.HasPrefix(channel, "plugin:"):
+		name, target, _ := strings.Cut(strings.TrimPrefix(channel, "plugin:"), ":")
+		return sendPlugin(ctx, name, target, msg)
+	default:
+		return sendCommand(ctx, channel, msg)
+	}
+}
+
+func sendDesktop(ctx context.Context, msg Message) error {
+	body := msg.Body
+	if lines := strings.Split(strings.TrimSpace(msg.LogTail), "\n"); lines[len(lines)-1] != "" {
+		body += ": " + lines[len(lines)-1]
+	}
+	script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(msg.Title))
+	if msg.Urgent {
+		script += ` sound name "Basso"`
+	}
+	out, err := exec.CommandContext(ctx, "osascript", "-e", script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("desktop notification failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func sendCommand(ctx context.Context, command string, msg Message) error {
+	cmd := exec.CommandContext(ctx, "bash", "-lc", command)
+	cmd.Env = append(os.Environ(),
+		"DEVAGENT_JOB="+msg.Job,
+		"DEVAGENT_STATUS="+msg.Status,
+		"DEVAGENT_FAILURE_STREAK="+strconv.Itoa(msg.Streak),
+		"DEVAGENT_TITLE="+msg.Title,
+		"DEVAGENT_MESSAGE="+msg.Body,
+		"DEVAGENT_URGENT="+strconv.FormatBool(msg.Urgent),
+		"DEVAGENT_LOG_TAIL="+msg.LogTail,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("notify command failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// sendPlugin passes msg as a plugin.NotifyRequest on the stdin of the
+// notify plugin called name.
+func sendPlugin(ctx context.Context, name, target string, msg Message) error {
+	path, err := plugin.Find(plugin.Notify, name)
+	if err != nil {
+		return err
+	}
+	req, err := json.Marshal(plugin.NotifyRequest{
+		Protocol: plugin.Protocol,
+		Target:   target,
+		Job:      msg.Job,
+		Status:   msg.Status,
+		Streak:   msg.Streak,
+		Title:    msg.Title,
+		Body:     msg.Body,
+		Urgent:   msg.Urgent,
+		LogTail:  msg.LogTail,
+	})
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), "DEVAGENT_PLUGIN_PROTOCOL="+strconv.Itoa(plugin.Protocol))
+	cmd.Stdin = bytes.NewReader(req)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s plugin failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/plugin"
+)
+
+func TestForRunEscalatesAfterStreak(t *testing.T) {
+	cfg := &dsl.Notify{OnFailure: "desktop", Escalate: "page-me", AlertAfter: 3}
+
+	if _, _, ok := ForRun(cfg, "nightly", "success", 0); ok {
+		t.Fatalf("success should not notify")
+	}
+	channel, msg, ok := ForRun(cfg, "nightly", "failed", 2)
+	if !ok || channel != "desktop" || msg.Urgent {
+		t.Fatalf("expected quiet failure notification, got %q urgent=%v ok=%v", channel, msg.Urgent, ok)
+	}
+	channel, msg, ok = ForRun(cfg, "nightly", "failed", 3)
+	if !ok || channel != "page-me" || !msg.Urgent {
+		t.Fatalf("expected escalation, got %q urgent=%v ok=%v", channel, msg.Urgent, ok)
+	}
+}
+
+func TestForRunAlertAfterGatesOnFailure(t *testing.T) {
+	cfg := &dsl.Notify{OnFailure: "desktop", AlertAfter: 2}
+	if _, _, ok := ForRun(cfg, "nightly", "failed", 1); ok {
+		t.Fatalf("single failure should be suppressed")
+	}
+	if channel, _, ok := ForRun(cfg, "nightly", "failed", 2); !ok || channel != "desktop" {
+		t.Fatalf("expected notification after streak, got %q ok=%v", channel, ok)
+	}
+}
+
+func TestForRecoveryFollowsAlertedChannel(t *testing.T) {
+	cfg := &dsl.Notify{OnFailure: "desktop", Escalate: "page-me", AlertAfter: 3}
+	if _, _, ok := ForRecovery(cfg, "nightly", 0); ok {
+		t.Fatalf("a job that was not failing has nothing to recover from")
+	}
+	channel, msg, ok := ForRecovery(cfg, "nightly", 4)
+	if !ok || channel != "page-me" || msg.Status != "recovered" || msg.Body != "nightly recovered after 4 failures" {
+		t.Fatalf("expected recovery on the escalation channel, got %q %+v ok=%v", channel, msg, ok)
+	}
+	if channel, _, ok := ForRecovery(cfg, "nightly", 1); !ok || channel != "desktop" {
+		t.Fatalf("expected recovery on on_failure, got %q ok=%v", channel, ok)
+	}
+
+	gated := &dsl.Notify{OnFailure: "desktop", AlertAfter: 2}
+	if _, _, ok := ForRecovery(gated, "nightly", 1); ok {
+		t.Fatalf("an unreported failure should not be followed by a recovery notification")
+	}
+}
+
+func TestSendRunsNotifyPlugin(t *testing.T) {
+	t.Setenv("DEVAGENT_HOME", t.TempDir())
+	dir, _ := plugin.Dir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	got := filepath.Join(t.TempDir(), "request.json")
+	script := "#!/bin/sh\ncat > " + got + "\ngrep -q '\"urgent\":true' " + got + " || { echo 'not urgent' >&2; exit 1; }\n"
+	if err := os.WriteFile(filepath.Join(dir, "devagent-notify-chat"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := Message{Job: "nightly", Status: "failed", Streak: 2, Title: "devagent: nightly failed", Urgent: true}
+	if err := Send(context.Background(), "plugin:chat:#builds", msg); err != nil {
+		t.Fatal(err)
+	}
+	var req plugin.NotifyRequest
+	data, _ := os.ReadFile(got)
+	if err := json.Unmarshal(data, &req); err != nil || req.Target != "#builds" || req.Job != "nightly" || req.Streak != 2 || req.Protocol != plugin.Protocol {
+		t.Fatalf("unexpected request %s (%v)", data, err)
+	}
+
+	msg.Urgent = false
+	if err := Send(context.Background(), "plugin:chat", msg); err == nil || !strings.Contains(err.Error(), "not urgent") {
+		t.Fatalf("expected the plugin's stderr in the error, got %v", err)
+	}
+	if err := Send(context.Background(), "plugin:missing", msg); err == nil || !strings.Contains(err.Error(), "devagent-notify-missing") {
+		t.Fatalf("expected a missing plugin to be named, got %v", err)
+	}
+}
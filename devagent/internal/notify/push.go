@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Push providers. Credentials come from the daemon's environment so they
+// stay out of workflow files; the base URLs are replaced in tests.
+var (
+	ntfyBase     = "https://ntfy.sh"
+	pushoverURL  = "https://api.pushover.net/1/messages.json"
+	telegramBase = "https://api.telegram.org"
+	pushClient   = &http.Client{Timeout: 30 * time.Second}
+)
+
+// pushTailLines is how many lines of the log tail push messages include.
+const pushTailLines = 5
+
+// pushText returns the message body with the end of the log tail, capped at
+// limit bytes.
+func pushText(msg Message, limit int) string {
+	text := msg.Body
+	if tail := strings.TrimSpace(msg.LogTail); tail != "" {
+		lines := strings.Split(tail, "\n")
+		if len(lines) > pushTailLines {
+			lines = lines[len(lines)-pushTailLines:]
+		}
+		text += "\n\n" + strings.Join(lines, "\n")
+	}
+	return truncate(text, limit)
+}
+
+// sendNtfy publishes to an ntfy topic: a bare topic name on ntfy.sh or the
+// full URL of a topic on another server. DEVAGENT_NTFY_TOKEN, when set, is
+// sent as a bearer token for protected topics.
+func sendNtfy(ctx context.Context, topic string, msg Message) error {
+	topic = strings.TrimSpace(topic)
+	if topic == "" {
+		return errors.New("ntfy channel needs a topic, e.g. ntfy:my-alerts")
+	}
+	target := topic
+	if !strings.HasPrefix(topic, "http://") && !strings.HasPrefix(topic, "https://") {
+		target = ntfyBase + "/" + url.PathEscape(topic)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(pushText(msg, 4096)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Title)
+	req.Header.Set("Tags", "devagent")
+	if msg.Urgent {
+		req.Header.Set("Priority", "high")
+	}
+	if token := os.Getenv("DEVAGENT_NTFY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return doPush(req, "ntfy")
+}
+
+// sendPushover sends through Pushover with the application token and user
+// key in DEVAGENT_PUSHOVER_TOKEN and DEVAGENT_PUSHOVER_USER.
+func sendPushover(ctx context.Context, msg Message) error {
+	token, user := os.Getenv("DEVAGENT_PUSHOVER_TOKEN"), os.Getenv("DEVAGENT_PUSHOVER_USER")
+	if token == "" || user == "" {
+		return errors.New("pushover needs DEVAGENT_PUSHOVER_TOKEN and DEVAGENT_PUSHOVER_USER in the daemon's environment")
+	}
+	form := url.Values{
+		"token":   {token},
+		"user":    {user},
+		"title":   {msg.Title},
+		"message": {pushText(msg, 1024)},
+	}
+	if msg.Urgent {
+		form.Set("priority", "1")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doPush(req, "pushover")
+}
+
+// sendTelegram sends to a chat through the bot whose token is in
+// DEVAGENT_TELEGRAM_TOKEN.
+func sendTelegram(ctx context.Context, chat string, msg Message) error {
+	token := os.Getenv("DEVAGENT_TELEGRAM_TOKEN")
+	if token == "" {
+		return errors.New("telegram needs DEVAGENT_TELEGRAM_TOKEN in the daemon's environment")
+	}
+	chat = strings.TrimSpace(chat)
+	if chat == "" {
+		return errors.New("telegram channel needs a chat ID, e.g. telegram:123456789")
+	}
+	return postJSON(ctx, telegramBase+"/bot"+token+"/sendMessage", "", "telegram", map[string]any{
+		"chat_id":              chat,
+		"text":                 msg.Title + "\n" + pushText(msg, 4000),
+		"disable_notification": !msg.Urgent && msg.Status != "failed",
+	})
+}
+
+// doPush sends req and turns a non-2xx response into an error. Errors never
+// include the request URL, which may carry a token.
+func doPush(req *http.Request, provider string) error {
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s notification failed: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s notification failed: %s: %s", provider, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSendNtfy(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, body = r, string(data)
+	}))
+	defer srv.Close()
+	defer func(base string) { ntfyBase = base }(ntfyBase)
+	ntfyBase = srv.URL
+	t.Setenv("DEVAGENT_NTFY_TOKEN", "tk_secret")
+
+	msg := Message{Title: "devagent: nightly failed", Body: "nightly failed 3 times in a row", Urgent: true, LogTail: "a\nb\nc\nd\ne\nf\nTraceback"}
+	if err := Send(context.Background(), "ntfy:my-alerts", msg); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/my-alerts" || got.Header.Get("Title") != msg.Title || got.Header.Get("Priority") != "high" {
+		t.Fatalf("unexpected request %s %v", got.URL.Path, got.Header)
+	}
+	if got.Header.Get("Authorization") != "Bearer tk_secret" {
+		t.Fatalf("token not sent: %v", got.Header)
+	}
+	if !strings.HasPrefix(body, msg.Body) || !strings.HasSuffix(body, "Traceback") || strings.Contains(body, "\na\n") {
+		t.Fatalf("body should end with the last log lines, got %q", body)
+	}
+}
+
+func TestSendPushoverNeedsCredentials(t *testing.T) {
+	t.Setenv("DEVAGENT_PUSHOVER_TOKEN", "")
+	t.Setenv("DEVAGENT_PUSHOVER_USER", "")
+	err := Send(context.Background(), "pushover", Message{Title: "t"})
+	if err == nil || !strings.Contains(err.Error(), "DEVAGENT_PUSHOVER_TOKEN") {
+		t.Fatalf("expected a missing credentials error, got %v", err)
+	}
+}
+
+func TestSendPushover(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+	}))
+	defer srv.Close()
+	defer func(u string) { pushoverURL = u }(pushoverURL)
+	pushoverURL = srv.URL
+	t.Setenv("DEVAGENT_PUSHOVER_TOKEN", "app")
+	t.Setenv("DEVAGENT_PUSHOVER_USER", "me")
+
+	msg := Message{Title: "devagent: nightly failed", Body: strings.Repeat("x", 2000)}
+	if err := Send(context.Background(), "pushover", msg); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("token") != "app" || form.Get("user") != "me" || form.Get("title") != msg.Title || form.Get("priority") != "" {
+		t.Fatalf("unexpected form %v", form)
+	}
+	if len(form.Get("message")) != 1024 {
+		t.Fatalf("message should be truncated to 1024 bytes, got %d", len(form.Get("message")))
+	}
+}
+
+func TestSendTelegramReportsRejection(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"ok":false,"description":"Bad Request: chat not found"}`)
+	}))
+	defer srv.Close()
+	defer func(base string) { telegramBase = base }(telegramBase)
+	telegramBase = srv.URL
+	t.Setenv("DEVAGENT_TELEGRAM_TOKEN", "123:abc")
+
+	err := Send(context.Background(), "telegram:42", Message{Title: "t", Body: "b"})
+	if path != "/bot123:abc/sendMessage" {
+		t.Fatalf("unexpected path %q", path)
+	}
+	if err == nil || !strings.Contains(err.Error(), "chat not found") || strings.Contains(err.Error(), "123:abc") {
+		t.Fatalf("expected the provider's error without the token, got %v", err)
+	}
+}
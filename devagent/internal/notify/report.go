@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ReportSecretEnv names the environment variable holding the key run
+// reports are signed with.
+const ReportSecretEnv = "DEVAGENT_REPORT_SECRET"
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of a report's
+// body under the report secret.
+const SignatureHeader = "X-Devagent-Signature"
+
+// Sign returns the SignatureHeader value for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendReport posts body, the JSON report of one of job's runs, to every
+// endpoint, signed with the key in ReportSecretEnv. Nothing is sent without
+// a key. An endpoint that fails does not keep the others from getting the
+// report; the failures are returned together.
+func SendReport(ctx context.Context, endpoints []string, job string, body []byte) error {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	secret := os.Getenv(ReportSecretEnv)
+	if secret == "" {
+		return fmt.Errorf("report_to needs %s in the environment to sign reports", ReportSecretEnv)
+	}
+	signature := Sign([]byte(secret), body)
+	var errs []error
+	for _, endpoint := range endpoints {
+		if err := postReport(ctx, endpoint, job, signature, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// postReport sends one report. Like doPush, its errors name only the
+// endpoint's host, since the URL may carry a token.
+func postReport(ctx context.Context, endpoint, job, signature string, body []byte) error {
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil {
+		host = u.Host
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("report to %s failed: invalid endpoint", host)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "devagent")
+	req.Header.Set("X-Devagent-Job", job)
+	req.Header.Set(SignatureHeader, signature)
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("report to %s failed: %w", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("report to %s failed: %s: %s", host, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendReportSignsEveryEndpoint(t *testing.T) {
+	body := []byte(`{"name":"nightly","status":"success"}`)
+	var got []string
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("s3cret"), data) || r.Header.Get("X-Devagent-Job") != "nightly" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		got = append(got, string(data))
+	}))
+	defer ok.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	t.Setenv(ReportSecretEnv, "")
+	if err := SendReport(context.Background(), []string{ok.URL}, "nightly", body); err == nil || len(got) != 0 {
+		t.Fatalf("expected no unsigned report, got %v", err)
+	}
+
+	t.Setenv(ReportSecretEnv, "s3cret")
+	err := SendReport(context.Background(), []string{broken.URL + "/hook?token=abc", ok.URL}, "nightly", body)
+	if err == nil || !strings.Contains(err.Error(), "503") || strings.Contains(err.Error(), "token=abc") {
+		t.Fatalf("expected the broken endpoint's error without its URL, got %v", err)
+	}
+	if len(got) != 1 || got[0] != string(body) {
+		t.Fatalf("working endpoint got %q", got)
+	}
+	if sig := Sign([]byte("s3cret"), body); !strings.HasPrefix(sig, "sha256=") || len(sig) != len("sha256=")+64 {
+		t.Fatalf("unexpected signature %q", sig)
+	}
+}
@@ -0,0 +1,141 @@
+// Package paths locates the directories devagent keeps its configuration,
+// state and caches in.
+//
+// $DEVAGENT_HOME (set by the global --state-dir flag) puts everything in
+// one directory. Otherwise an existing ~/.devagent is used as before, and
+// new installs follow the XDG base directories: configuration in
+// $XDG_CONFIG_HOME/devagent, the store, locks and worktrees in
+// $XDG_STATE_HOME/devagent, and caches in $XDG_CACHE_HOME/devagent.
+//
+// A profile ($DEVAGENT_PROFILE, set by the global --profile flag) gets its
+// own profiles/<name> directory inside each of them, so its jobs, store
+// and config are separate from those of other profiles.
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Profile returns the active profile; "" is the default profile.
+func Profile() string {
+	return os.Getenv("DEVAGENT_PROFILE")
+}
+
+// ValidateProfile checks that name can be used as a directory name.
+func ValidateProfile(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, digits, - and _)", name)
+	}
+	return nil
+}
+
+// Profiles lists the profiles that have a state directory, sorted.
+func Profiles() ([]string, error) {
+	root, err := base("XDG_STATE_HOME", filepath.Join(".local", "state"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidateProfile(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ConfigDir holds config.yml, policy.yml and remotes.yml.
+func ConfigDir() (string, error) {
+	return dir("XDG_CONFIG_HOME", ".config", "")
+}
+
+// StateDir holds the state database, lock files, worktrees and the daemon
+// environment snapshot.
+func StateDir() (string, error) {
+	return dir("XDG_STATE_HOME", filepath.Join(".local", "state"), "")
+}
+
+// CacheDir holds the step caches.
+func CacheDir() (string, error) {
+	return dir("XDG_CACHE_HOME", ".cache", "cache")
+}
+
+// Expand expands a leading ~ to the home directory and $VAR references in
+// path, and cleans it.
+func Expand(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+	return filepath.Clean(os.ExpandEnv(path)), nil
+}
+
+// Legacy reports whether the directories resolve to ~/.devagent because it
+// already exists.
+func Legacy() bool {
+	if os.Getenv("DEVAGENT_HOME") != "" {
+		return false
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(home, ".devagent"))
+	return err == nil && info.IsDir()
+}
+
+// dir resolves one of the directories for the active profile; xdgVar and
+// fallback (relative to the home directory) name its XDG location, and sub
+// its subdirectory when everything shares one directory.
+func dir(xdgVar, fallback, sub string) (string, error) {
+	root, err := base(xdgVar, fallback)
+	if err != nil {
+		return "", err
+	}
+	if profile := Profile(); profile != "" {
+		if err := ValidateProfile(profile); err != nil {
+			return "", err
+		}
+		root = filepath.Join(root, "profiles", profile)
+	}
+	if os.Getenv("DEVAGENT_HOME") != "" || Legacy() {
+		return filepath.Join(root, sub), nil
+	}
+	return root, nil
+}
+
+// base returns the directory dir resolves to for the default profile,
+// without sub.
+func base(xdgVar, fallback string) (string, error) {
+	if root := os.Getenv("DEVAGENT_HOME"); root != "" {
+		return filepath.Abs(root)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if Legacy() {
+		return filepath.Join(home, ".devagent"), nil
+	}
+	// The spec ignores relative values.
+	if base := os.Getenv(xdgVar); filepath.IsAbs(base) {
+		return filepath.Join(base, "devagent"), nil
+	}
+	return filepath.Join(home, fallback, "devagent"), nil
+}
@@ -0,0 +1,110 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DEVAGENT_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "xdg-cache"))
+
+	check := func(label string, want [3]string) {
+		t.Helper()
+		for i, fn := range []func() (string, error){ConfigDir, StateDir, CacheDir} {
+			got, err := fn()
+			if err != nil || got != want[i] {
+				t.Errorf("%s: dir %d = %q (%v), want %q", label, i, got, err, want[i])
+			}
+		}
+	}
+
+	check("xdg", [3]string{
+		filepath.Join(home, ".config", "devagent"),
+		filepath.Join(home, ".local", "state", "devagent"),
+		filepath.Join(home, "xdg-cache", "devagent"),
+	})
+
+	t.Setenv("XDG_STATE_HOME", "relative/state")
+	check("relative xdg value", [3]string{
+		filepath.Join(home, ".config", "devagent"),
+		filepath.Join(home, ".local", "state", "devagent"),
+		filepath.Join(home, "xdg-cache", "devagent"),
+	})
+
+	if err := os.Mkdir(filepath.Join(home, ".devagent"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if !Legacy() {
+		t.Fatal("expected ~/.devagent to be used")
+	}
+	legacy := filepath.Join(home, ".devagent")
+	check("legacy", [3]string{legacy, legacy, filepath.Join(legacy, "cache")})
+
+	profile := filepath.Join(home, "profile")
+	t.Setenv("DEVAGENT_HOME", profile)
+	if Legacy() {
+		t.Fatal("DEVAGENT_HOME should override ~/.devagent")
+	}
+	check("DEVAGENT_HOME", [3]string{profile, profile, filepath.Join(profile, "cache")})
+}
+
+func TestProfiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DEVAGENT_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("DEVAGENT_PROFILE", "work")
+
+	state, err := StateDir()
+	if err != nil || state != filepath.Join(home, ".local", "state", "devagent", "profiles", "work") {
+		t.Fatalf("state dir %q (%v)", state, err)
+	}
+	config, err := ConfigDir()
+	if err != nil || config != filepath.Join(home, ".config", "devagent", "profiles", "work") {
+		t.Fatalf("config dir %q (%v)", config, err)
+	}
+	if err := os.MkdirAll(state, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := Profiles(); err != nil || len(names) != 1 || names[0] != "work" {
+		t.Fatalf("profiles %v (%v)", names, err)
+	}
+
+	t.Setenv("DEVAGENT_HOME", filepath.Join(home, "one"))
+	if cache, err := CacheDir(); err != nil || cache != filepath.Join(home, "one", "profiles", "work", "cache") {
+		t.Fatalf("cache dir %q (%v)", cache, err)
+	}
+
+	for _, name := range []string{"../x", "a b", "-x", ""} {
+		if ValidateProfile(name) == nil {
+			t.Errorf("%q should be rejected", name)
+		}
+	}
+	t.Setenv("DEVAGENT_PROFILE", "../x")
+	if _, err := StateDir(); err == nil {
+		t.Fatal("expected an invalid profile to fail")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("KEYS", "/etc/keys")
+	for path, want := range map[string]string{
+		"~/.env":             filepath.Join(home, ".env"),
+		" ~ ":                home,
+		"$KEYS/deploy/../ci": "/etc/keys/ci",
+		"config/.env":        "config/.env",
+	} {
+		if got, err := Expand(path); err != nil || got != want {
+			t.Errorf("Expand(%q) = %q (%v), want %q", path, got, err, want)
+		}
+	}
+}
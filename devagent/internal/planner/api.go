@@ -0,0 +1,214 @@
+package planner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// The APIs of OpenAI-compatible servers the planner can use; see
+// Options.API.
+const (
+	APIAuto      = "auto"
+	APIResponses = "responses"
+	APIChat      = "chat"
+)
+
+// apiName validates an Options.API value, defaulting to APIAuto.
+func apiName(api string) (string, error) {
+	switch api {
+	case "":
+		return APIAuto, nil
+	case APIAuto, APIResponses, APIChat:
+		return api, nil
+	}
+	return "", fmt.Errorf("unknown planner API %q (use %s, %s or %s)", api, APIAuto, APIResponses, APIChat)
+}
+
+// chatOnlyURLs remembers the base URLs found to have no responses API, so
+// later requests in this process go straight to chat completions.
+var chatOnlyURLs sync.Map
+
+func chatOnly(baseURL string) bool {
+	_, ok := chatOnlyURLs.Load(baseURL)
+	return ok
+}
+
+// statusError is an unsuccessful HTTP status from the API.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("planner API returned status %d", e.code)
+}
+
+// apiReply is what the planner needs from a reply: the texts that may hold
+// the JSON answer, in order, and the tokens used.
+type apiReply struct {
+	content      []string
+	inputTokens  int64
+	outputTokens int64
+}
+
+// callAPI posts request to url and decodes the reply with decode.
+func callAPI(ctx context.Context, client *http.Client, url, apiKey string, request interface{}, decode func(io.Reader) (apiReply, error)) (apiReply, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return apiReply{}, err
+	}
+	resp, err := post(ctx, client, url, apiKey, body)
+	if err != nil {
+		return apiReply{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return apiReply{}, &statusError{code: resp.StatusCode}
+	}
+	return decode(resp.Body)
+}
+
+// responsesRequest is the body of a request to the responses API, which
+// enforces format, a JSON schema, on the reply.
+func responsesRequest(model, system, user string, format map[string]interface{}) interface{} {
+	return map[string]interface{}{
+		"model": model,
+		"input": []map[string]interface{}{
+			{
+				"role":    "system",
+				"content": []map[string]string{{"type": "text", "text": system}},
+			},
+			{
+				"role": "user",
+				"content": []map[string]string{{
+					"type": "text",
+					"text": user,
+				}},
+			},
+		},
+		"response_format": format,
+	}
+}
+
+func decodeResponses(r io.Reader) (apiReply, error) {
+	var payload struct {
+		Output []struct {
+			Content []struct {
+				Type string          `json:"type"`
+				Text string          `json:"text"`
+				JSON json.RawMessage `json:"json"`
+			} `json:"content"`
+		} `json:"output"`
+		Usage struct {
+			InputTokens  int64 `json:"input_tokens"`
+			OutputTokens int64 `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(r).Decode(&payload); err != nil {
+		return apiReply{}, err
+	}
+	reply := apiReply{inputTokens: payload.Usage.InputTokens, outputTokens: payload.Usage.OutputTokens}
+	for _, item := range payload.Output {
+		for _, content := range item.Content {
+			if content.JSON != nil {
+				reply.content = append(reply.content, string(content.JSON))
+			}
+			if content.Type == "output_text" && content.Text != "" {
+				reply.content = append(reply.content, content.Text)
+			}
+		}
+	}
+	return reply, nil
+}
+
+// chatRequest is the body of a request to the chat completions API. Not
+// every server enforces a schema there, so the system prompt spells out
+// the schema and JSON mode asks for a bare object.
+func chatRequest(model, system, user string, format map[string]interface{}) interface{} {
+	if spec, ok := format["json_schema"].(map[string]interface{}); ok {
+		if schema, err := json.Marshal(spec["schema"]); err == nil {
+			system += "\n\nReply with a single JSON object, and nothing else, that matches this JSON schema: " + string(schema)
+		}
+	}
+	return map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+}
+
+func decodeChat(r io.Reader) (apiReply, error) {
+	var payload struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int64 `json:"prompt_tokens"`
+			CompletionTokens int64 `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(r).Decode(&payload); err != nil {
+		return apiReply{}, err
+	}
+	reply := apiReply{inputTokens: payload.Usage.PromptTokens, outputTokens: payload.Usage.CompletionTokens}
+	for _, choice := range payload.Choices {
+		reply.content = append(reply.content, choice.Message.Content)
+	}
+	return reply, nil
+}
+
+// extractJSON finds the JSON object in a model's reply, which may wrap it
+// in a code fence or surround it with prose: the first balanced {...}
+// that is valid JSON.
+func extractJSON(text string) ([]byte, bool) {
+	data := []byte(text)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		return trimmed, true
+	}
+	for start := bytes.IndexByte(data, '{'); start >= 0; {
+		if end := matchingBrace(data, start); end > 0 && json.Valid(data[start:end+1]) {
+			return data[start : end+1], true
+		}
+		next := bytes.IndexByte(data[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return nil, false
+}
+
+// matchingBrace returns the index of the brace closing the one at start,
+// skipping braces inside strings, or -1.
+func matchingBrace(data []byte, start int) int {
+	depth, inString, escaped := 0, false, false
+	for i := start; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,98 @@
+package planner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatCompletionsFallback(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil || len(req.Messages) != 2 || !strings.Contains(req.Messages[0].Content, `"required":["cause","fix"]`) {
+			t.Errorf("expected the schema in the system prompt: %s", body)
+		}
+		reply := "Sure! Here is the diagnosis:\n```json\n{\"cause\": \"missing {brace} in config\", \"fix\": \"add it\"}\n```"
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": reply}}},
+			"usage":   map[string]int{"prompt_tokens": 40, "completion_tokens": 12},
+		})
+	}))
+	defer srv.Close()
+	var used []TokenUsage
+	SetUsageSink(func(u TokenUsage) { used = append(used, u) })
+	defer SetUsageSink(nil)
+
+	opts := Options{APIKey: "test", BaseURL: srv.URL + "/v1/"}
+	for i := 0; i < 2; i++ {
+		diag, err := Diagnose(context.Background(), "report", opts)
+		if err != nil || diag.Cause != "missing {brace} in config" || diag.Fix != "add it" {
+			t.Fatalf("diagnosis %d: %+v %v", i, diag, err)
+		}
+	}
+	// The responses API is tried once; then chat completions are used.
+	if strings.Join(paths, " ") != "/v1/responses /v1/chat/completions /v1/chat/completions" {
+		t.Fatalf("requested %v", paths)
+	}
+	if len(used) != 2 || used[0].InputTokens != 40 || used[0].OutputTokens != 12 {
+		t.Fatalf("usage: %+v", used)
+	}
+
+	opts.API = APIResponses
+	if _, err := Diagnose(context.Background(), "report", opts); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("--api responses should not fall back: %v", err)
+	}
+	opts.API = "grpc"
+	if _, err := Diagnose(context.Background(), "report", opts); err == nil || !strings.Contains(err.Error(), "unknown planner API") {
+		t.Fatalf("unknown API: %v", err)
+	}
+}
+
+func TestChatAPIPlans(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("--api chat requested %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"{\"name\":\"chat-plan\",\"repo\":\"/tmp\",\"cron\":\"0 4 * * *\",\"steps\":[\"make test\"]}"}}]}`))
+	}))
+	defer srv.Close()
+	plan, err := PlanFromSpec(context.Background(), "test the repo nightly", Options{APIKey: "test", BaseURL: srv.URL, API: APIChat})
+	if err != nil || plan.Name != "chat-plan" || plan.Cron != "0 4 * * *" || plan.Model != DefaultModel {
+		t.Fatalf("plan: %+v %v", plan, err)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	cases := map[string]string{
+		`{"a":1}`:                                `{"a":1}`,
+		"  \n{\"a\": \"}\"}\n":                   `{"a": "}"}`,
+		"```json\n{\"a\":{\"b\":2}}\n```":        `{"a":{"b":2}}`,
+		`Use {this} carefully: {"a":"x\"}"} ok.`: `{"a":"x\"}"}`,
+	}
+	for text, want := range cases {
+		if got, ok := extractJSON(text); !ok || string(got) != want {
+			t.Errorf("extractJSON(%q) = %q, %v; want %q", text, got, ok, want)
+		}
+	}
+	for _, text := range []string{"", "no json here", `{"a": 1`} {
+		if got, ok := extractJSON(text); ok {
+			t.Errorf("extractJSON(%q) = %q", text, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"devagent/internal/digest"
+)
+
+// Price is what a model charges, in US dollars per million tokens.
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// defaultPrices are the list prices of common OpenAI models, used to
+// estimate costs unless llm.prices in the global config overrides them.
+var defaultPrices = map[string]Price{
+	"gpt-4.1":      {Input: 2, Output: 8},
+	"gpt-4.1-mini": {Input: 0.4, Output: 1.6},
+	"gpt-4.1-nano": {Input: 0.1, Output: 0.4},
+	"gpt-4o":       {Input: 2.5, Output: 10},
+	"gpt-4o-mini":  {Input: 0.15, Output: 0.6},
+}
+
+// Budget is the llm section of the global config:
+//
+//	llm:
+//	  monthly_budget: 5        # US dollars per calendar month
+//	  on_budget: block         # or warn, the default
+//	  prices:
+//	    my-model: {input: 0.5, output: 1.5}
+type Budget struct {
+	// Monthly is the estimated spend allowed per calendar month; 0 means
+	// no budget.
+	Monthly float64 `yaml:"monthly_budget"`
+	// OnBudget is what happens once the month's spend reaches Monthly:
+	// "warn" makes the call anyway with a warning, "block" refuses it.
+	OnBudget string `yaml:"on_budget"`
+	// Prices add to or override the default prices per model.
+	Prices map[string]Price `yaml:"prices"`
+}
+
+// LoadBudget reads the llm section of the global config. Without one, it
+// returns a Budget with no limit and the default prices.
+func LoadBudget() (*Budget, error) {
+	budget := &Budget{}
+	path, err := digest.ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return budget, nil
+		}
+		return nil, err
+	}
+	var cfg struct {
+		LLM *Budget `yaml:"llm"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.LLM != nil {
+		budget = cfg.LLM
+	}
+	switch budget.OnBudget {
+	case "", "warn", "block":
+	default:
+		return nil, fmt.Errorf("%s: llm.on_budget must be warn or block, not %q", path, budget.OnBudget)
+	}
+	if budget.Monthly < 0 {
+		return nil, fmt.Errorf("%s: llm.monthly_budget must not be negative", path)
+	}
+	return budget, nil
+}
+
+// Cost estimates what a call to model using the given tokens cost, in US
+// dollars. ok is false when the model's price is unknown.
+func (b *Budget) Cost(model string, inputTokens, outputTokens int64) (cost float64, ok bool) {
+	price, ok := b.Prices[model]
+	if !ok {
+		price, ok = defaultPrices[strings.ToLower(model)]
+	}
+	if !ok {
+		return 0, false
+	}
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6, true
+}
+
+// ErrBudget is returned, wrapped, for calls refused because the monthly
+// budget is spent.
+var ErrBudget = errors.New("monthly LLM budget reached")
+
+// Check decides whether another call may be made after spent dollars this
+// month. Over the budget it returns ErrBudget when on_budget is block, and
+// otherwise a warning to show.
+func (b *Budget) Check(spent float64) (warning string, err error) {
+	if b.Monthly <= 0 || spent < b.Monthly {
+		return "", nil
+	}
+	if b.OnBudget == "block" {
+		return "", fmt.Errorf("%w: $%.2f of $%.2f spent this month (raise llm.monthly_budget in the global config)", ErrBudget, spent, b.Monthly)
+	}
+	return fmt.Sprintf("the monthly LLM budget of $%.2f is spent ($%.2f this month)", b.Monthly, spent), nil
+}
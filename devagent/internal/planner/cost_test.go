@@ -0,0 +1,73 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yml")
+	t.Setenv("DEVAGENT_CONFIG", config)
+	budget, err := LoadBudget()
+	if err != nil || budget.Monthly != 0 {
+		t.Fatalf("without a config: %+v %v", budget, err)
+	}
+	if cost, ok := budget.Cost("gpt-4.1-mini", 1_000_000, 500_000); !ok || math.Abs(cost-1.2) > 1e-9 {
+		t.Fatalf("default price: %v %v", cost, ok)
+	}
+	if _, ok := budget.Cost("local-llama", 1000, 1000); ok {
+		t.Fatal("priced an unknown model")
+	}
+
+	os.WriteFile(config, []byte("llm:\n  monthly_budget: 2\n  on_budget: block\n  prices:\n    local-llama: {input: 1, output: 2}\n"), 0o644)
+	if budget, err = LoadBudget(); err != nil {
+		t.Fatal(err)
+	}
+	if cost, ok := budget.Cost("local-llama", 1_000_000, 1_000_000); !ok || cost != 3 {
+		t.Fatalf("configured price: %v %v", cost, ok)
+	}
+	if warning, err := budget.Check(1.99); warning != "" || err != nil {
+		t.Fatalf("under the budget: %q %v", warning, err)
+	}
+	if _, err := budget.Check(2); !errors.Is(err, ErrBudget) {
+		t.Fatalf("block over the budget: %v", err)
+	}
+	budget.OnBudget = "warn"
+	if warning, err := budget.Check(2.5); err != nil || !strings.Contains(warning, "$2.00") {
+		t.Fatalf("warn over the budget: %q %v", warning, err)
+	}
+
+	os.WriteFile(config, []byte("llm:\n  on_budget: stop\n"), 0o644)
+	if _, err := LoadBudget(); err == nil {
+		t.Fatal("accepted an unknown on_budget")
+	}
+}
+
+func TestUsageGateBlocksRequests(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"output":[{"content":[{"type":"output_text","text":"{\"name\":\"llm-plan\",\"steps\":[\"make\"],\"cron\":\"0 3 * * *\"}"}]}]}`))
+	}))
+	defer srv.Close()
+	SetUsageGate(func(model string) error { return ErrBudget })
+	defer SetUsageGate(nil)
+
+	if _, err := Diagnose(context.Background(), "report", Options{APIKey: "test", BaseURL: srv.URL}); !errors.Is(err, ErrBudget) {
+		t.Fatalf("diagnose past the gate: %v", err)
+	}
+	plan, err := PlanFromSpec(context.Background(), "run make every day at 2am", Options{APIKey: "test", BaseURL: srv.URL, StepHints: []string{"make"}})
+	if err != nil || plan.Model != "" || plan.Cron != "0 2 * * *" {
+		t.Fatalf("planning should fall back to the heuristics: %+v %v", plan, err)
+	}
+	if requests != 0 {
+		t.Fatalf("%d requests sent past the gate", requests)
+	}
+}
@@ -0,0 +1,108 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Diagnosis is the LLM's explanation of a failed run.
+type Diagnosis struct {
+	Cause string `json:"cause"`
+	Fix   string `json:"fix"`
+}
+
+// Diagnose asks the LLM for the probable cause of a failed run and a
+// suggested fix. report holds the evidence gathered about the run: its
+// steps, the failing step's output tail and the repository state.
+func Diagnose(ctx context.Context, report string, opts Options) (*Diagnosis, error) {
+	report = strings.TrimSpace(report)
+	if report == "" {
+		return nil, errors.New("failure report is empty")
+	}
+	if opts.APIKey == "" {
+		return nil, errors.New("diagnosis requires OPENAI_API_KEY")
+	}
+	format := map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name": "devagent_diagnosis",
+			"schema": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"cause", "fix"},
+				"properties": map[string]interface{}{
+					"cause": map[string]string{"type": "string"},
+					"fix":   map[string]string{"type": "string"},
+				},
+			},
+		},
+	}
+	var out Diagnosis
+	if err := requestJSON(ctx, opts, "diagnose", diagnoseSystemPrompt(), report, format, &out); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(out.Cause) == "" {
+		return nil, errors.New("diagnosis response missing cause")
+	}
+	return &out, nil
+}
+
+func diagnoseSystemPrompt() string {
+	return "You debug failed scheduled repository automation runs. Given the workflow steps, the output tail of the failing step and recent git metadata, reply with JSON fields: cause (the most probable reason for the failure, one or two sentences citing the evidence) and fix (a concrete suggested fix, such as a command to run or a change to make). Say so when the evidence is inconclusive."
+}
+
+// Remediation is a fix proposed by the LLM for a failed run: commands to
+// run in the repository before the job is retried.
+type Remediation struct {
+	Cause string   `json:"cause"`
+	Steps []string `json:"steps"`
+}
+
+// ProposeFix asks the LLM for remediation commands for the failed run
+// described by report. The commands are suggestions; callers decide
+// whether they may run.
+func ProposeFix(ctx context.Context, report string, opts Options) (*Remediation, error) {
+	report = strings.TrimSpace(report)
+	if report == "" {
+		return nil, errors.New("failure report is empty")
+	}
+	if opts.APIKey == "" {
+		return nil, errors.New("proposing a fix requires OPENAI_API_KEY")
+	}
+	format := map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name": "devagent_remediation",
+			"schema": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"cause", "steps"},
+				"properties": map[string]interface{}{
+					"cause": map[string]string{"type": "string"},
+					"steps": map[string]interface{}{
+						"type":  "array",
+						"items": map[string]string{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+	var out Remediation
+	if err := requestJSON(ctx, opts, "heal", healSystemPrompt(), report, format, &out); err != nil {
+		return nil, err
+	}
+	steps := out.Steps[:0]
+	for _, step := range out.Steps {
+		if step = strings.TrimSpace(step); step != "" {
+			steps = append(steps, step)
+		}
+	}
+	out.Steps = steps
+	if len(out.Steps) == 0 {
+		return nil, errors.New("no remediation steps proposed")
+	}
+	return &out, nil
+}
+
+func healSystemPrompt() string {
+	return "You repair failed scheduled repository automation runs. Given the workflow steps, the output tail of the failing step and recent git metadata, reply with JSON fields: cause (the most probable reason, one sentence) and steps (a short list of shell commands run from the repository root that fix the environment so the workflow can simply be re-run, such as clearing a cache or reinstalling dependencies). Do not include the workflow's own steps, do not edit source files, and never touch paths outside the repository. Return an empty steps list when re-running cannot help."
+}
@@ -11,9 +11,18 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"devagent/internal/util"
 )
 
+// Version identifies the planner logic; it is recorded in workflow metadata.
+const Version = "devagent-planner/1"
+
+// DefaultModel is the LLM used when Options.Model is empty.
+const DefaultModel = "gpt-4.1-mini"
+
 // Result represents the normalized planning output.
 type Result struct {
 	Name     string
@@ -21,20 +30,90 @@ type Result struct {
 	Cron     string
 	Natural  string
 	Timezone string
+	After    string
+	At       string
+	Every    string
 	Steps    []string
+	// Model is the LLM that produced the plan; empty when the heuristic
+	// fallback was used.
+	Model string
+	// Fallback is why the LLM's plan was not used when an API key was
+	// given, e.g. the request failed or its plans stayed invalid.
+	Fallback error
+	// Warnings is what the safety review found in Steps; they are for a
+	// person to read before the plan is saved.
+	Warnings []Warning
 }
 
 // Options configure the planner behaviour.
 type Options struct {
-	Name       string
-	Timezone   string
-	CronHint   string
-	RepoHint   string
-	StepHints  []string
-	HTTPClient *http.Client
-	Model      string
-	BaseURL    string
-	APIKey     string
+	Name      string
+	Timezone  string
+	CronHint  string
+	RepoHint  string
+	StepHints []string
+	After     string
+	At        string
+	Every     string
+	// Targets lists commands discovered in the repo (e.g. "make test") that
+	// the planner should prefer when choosing steps.
+	Targets []string
+	// Current is the existing workflow YAML when re-planning, and
+	// Instructions the requested changes to it.
+	Current      string
+	Instructions string
+	HTTPClient   *http.Client
+	Model        string
+	BaseURL      string
+	APIKey       string
+	// Timeout bounds each request to the LLM, retries included; 0 means
+	// DefaultTimeout.
+	Timeout time.Duration
+	// API is the API the model is served through: APIResponses,
+	// APIChat, or APIAuto (the default), which uses the responses API
+	// unless the server does not have one.
+	API string
+	// Reprompts is how many times a plan that fails validation is sent
+	// back to the model with its problems before the heuristics are used;
+	// 0 means DefaultReprompts and a negative value none.
+	Reprompts int
+}
+
+// DefaultTimeout is how long a request to the LLM may take, retries
+// included, unless Options.Timeout is set.
+const DefaultTimeout = 60 * time.Second
+
+// TokenUsage is what one request to the LLM used, as the API reports it.
+type TokenUsage struct {
+	Model string
+	// Purpose is what the request was for: "plan", "schedule", "diagnose"
+	// or "heal".
+	Purpose      string
+	InputTokens  int64
+	OutputTokens int64
+}
+
+var (
+	usageMu   sync.Mutex
+	usageSink func(TokenUsage)
+	usageGate func(model string) error
+)
+
+// SetUsageSink has fn called with the usage of every request to the LLM
+// that gets a reply, e.g. to keep local usage stats.
+func SetUsageSink(fn func(TokenUsage)) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usageSink = fn
+}
+
+// SetUsageGate has fn called before every request to the LLM; a request fn
+// returns an error for is not sent, and fails with that error. Planning
+// falls back to its heuristics.
+func SetUsageGate(fn func(model string) error) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usageGate = fn
 }
 
 // PlanFromSpec resolves a plan from natural language using an OpenAI-compatible API when available.
@@ -43,6 +122,9 @@ func PlanFromSpec(ctx context.Context, spec string, opts Options) (*Result, erro
 	if spec == "" {
 		return nil, errors.New("spec is empty")
 	}
+	if _, err := apiName(opts.API); err != nil {
+		return nil, err
+	}
 
 	res := &Result{
 		Name:     opts.Name,
@@ -50,6 +132,9 @@ func PlanFromSpec(ctx context.Context, spec string, opts Options) (*Result, erro
 		Cron:     opts.CronHint,
 		Timezone: opts.Timezone,
 		Natural:  spec,
+		After:    opts.After,
+		At:       opts.At,
+		Every:    opts.Every,
 		Steps:    append([]string{}, opts.StepHints...),
 	}
 
@@ -60,13 +145,14 @@ func PlanFromSpec(ctx context.Context, spec string, opts Options) (*Result, erro
 	if opts.APIKey != "" {
 		plan, err := callLLM(ctx, spec, opts)
 		if err == nil {
+			res.Model = modelName(opts)
 			if plan.Name != "" {
 				res.Name = plan.Name
 			}
 			if plan.Repo != "" {
 				res.Repo = plan.Repo
 			}
-			if plan.Cron != "" {
+			if plan.Cron != "" && res.At == "" && res.Every == "" {
 				res.Cron = plan.Cron
 			}
 			if len(plan.Steps) > 0 {
@@ -75,27 +161,45 @@ func PlanFromSpec(ctx context.Context, spec string, opts Options) (*Result, erro
 			if plan.Timezone != "" {
 				res.Timezone = plan.Timezone
 			}
+			if opts.CronHint == "" && opts.At == "" && opts.Every == "" {
+				switch {
+				case plan.At != "":
+					res.At, res.Cron = plan.At, ""
+				case plan.Every != "":
+					res.Every, res.Cron = plan.Every, ""
+				}
+			}
+			res.Warnings = ReviewSteps(res.Steps)
 			return res, nil
 		}
+		res.Fallback = err
 	}
 
-	// fallback heuristics
-	if res.Cron == "" {
-		if cron, ok := parseCommonCron(spec); ok {
-			res.Cron = cron
-		} else {
+	// fallback heuristics; requested changes take precedence over the spec
+	text := spec
+	if opts.Instructions != "" {
+		text = opts.Instructions + "\n" + spec
+	}
+	if opts.Timezone == "" {
+		if tz, ok := parseTimezone(text); ok {
+			res.Timezone = tz
+		}
+	}
+	if res.Cron == "" && res.At == "" && res.Every == "" {
+		res.Cron, res.At, res.Every = parseTiming(text, time.Now().In(util.ResolveLocation(res.Timezone)))
+		if res.Cron == "" && res.At == "" && res.Every == "" && res.After == "" {
 			return nil, errors.New("unable to derive cron expression; provide --cron")
 		}
 	}
 
 	if res.Repo == "" {
-		if repo := extractRepoPath(spec); repo != "" {
+		if repo := extractRepoPath(text); repo != "" {
 			res.Repo = repo
 		}
 	}
 
 	if len(res.Steps) == 0 {
-		res.Steps = extractSteps(spec)
+		res.Steps = extractSteps(text)
 		if len(res.Steps) == 0 {
 			return nil, errors.New("no steps resolved; provide --step")
 		}
@@ -104,22 +208,117 @@ func PlanFromSpec(ctx context.Context, spec string, opts Options) (*Result, erro
 	if res.Name == "" {
 		res.Name = defaultNameFromSpec(spec)
 	}
+	res.Warnings = ReviewSteps(res.Steps)
 
 	return res, nil
 }
 
+func modelName(opts Options) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return DefaultModel
+}
+
 type llmResult struct {
 	Name     string   `json:"name"`
 	Repo     string   `json:"repo"`
 	Cron     string   `json:"cron"`
+	At       string   `json:"at"`
+	Every    string   `json:"every"`
 	Timezone string   `json:"timezone"`
 	Steps    []string `json:"steps"`
 }
 
 func callLLM(ctx context.Context, spec string, opts Options) (*llmResult, error) {
+	format := map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name": "devagent_plan",
+			"schema": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"repo", "cron", "steps"},
+				"properties": map[string]interface{}{
+					"name":     map[string]string{"type": "string"},
+					"repo":     map[string]string{"type": "string"},
+					"cron":     map[string]string{"type": "string"},
+					"at":       map[string]string{"type": "string"},
+					"every":    map[string]string{"type": "string"},
+					"timezone": map[string]string{"type": "string"},
+					"steps": map[string]interface{}{
+						"type":  "array",
+						"items": map[string]string{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+	return requestPlan(ctx, opts, "plan", plannerSystemPrompt(), userPrompt(spec, opts), format, func(plan *llmResult) []string {
+		return validatePlan(plan, opts)
+	})
+}
+
+// requestPlan sends a planning request and checks the reply with validate,
+// sending it back with the problems found up to opts.Reprompts times.
+func requestPlan(ctx context.Context, opts Options, purpose, system, user string, format map[string]interface{}, validate func(*llmResult) []string) (*llmResult, error) {
+	reprompts := opts.Reprompts
+	switch {
+	case reprompts == 0:
+		reprompts = DefaultReprompts
+	case reprompts < 0:
+		reprompts = 0
+	}
+	prompt := user
+	for attempt := 0; ; attempt++ {
+		var out llmResult
+		var previous *llmResult
+		var problems []string
+		err := requestJSON(ctx, opts, purpose, system, prompt, format, &out)
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.Is(err, errNoJSON) || errors.As(err, &typeErr):
+			problems = []string{err.Error()}
+		case err != nil:
+			return nil, err
+		default:
+			previous = &out
+			problems = validate(&out)
+		}
+		if len(problems) == 0 {
+			return &out, nil
+		}
+		if attempt == reprompts {
+			return nil, fmt.Errorf("invalid plan after %d %s: %s", attempt+1, util.Plural(attempt+1, "attempt"), strings.Join(problems, "; "))
+		}
+		prompt = user + repromptText(previous, problems)
+	}
+}
+
+// requestJSON sends one system/user exchange to the OpenAI-compatible API
+// and decodes the structured reply into out. purpose names the request in
+// its TokenUsage, e.g. "plan".
+func requestJSON(ctx context.Context, opts Options, purpose, system, user string, format map[string]interface{}, out interface{}) error {
+	usageMu.Lock()
+	gate, sink := usageGate, usageSink
+	usageMu.Unlock()
+	if gate != nil {
+		if err := gate(modelName(opts)); err != nil {
+			return err
+		}
+	}
+	api, err := apiName(opts.API)
+	if err != nil {
+		return err
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	client := opts.HTTPClient
 	if client == nil {
-		client = &http.Client{Timeout: 45 * time.Second}
+		client = &http.Client{}
 	}
 	baseURL := opts.BaseURL
 	if baseURL == "" {
@@ -128,106 +327,126 @@ func callLLM(ctx context.Context, spec string, opts Options) (*llmResult, error)
 			baseURL = "https://api.openai.com/v1"
 		}
 	}
-	model := opts.Model
-	if model == "" {
-		model = "gpt-4.1-mini"
-	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
 
-	requestBody := map[string]interface{}{
-		"model": model,
-		"input": []map[string]interface{}{
-			{
-				"role":    "system",
-				"content": []map[string]string{{"type": "text", "text": plannerSystemPrompt()}},
-			},
-			{
-				"role": "user",
-				"content": []map[string]string{{
-					"type": "text",
-					"text": spec,
-				}},
-			},
-		},
-		"response_format": map[string]interface{}{
-			"type": "json_schema",
-			"json_schema": map[string]interface{}{
-				"name": "devagent_plan",
-				"schema": map[string]interface{}{
-					"type":     "object",
-					"required": []string{"repo", "cron", "steps"},
-					"properties": map[string]interface{}{
-						"name":     map[string]string{"type": "string"},
-						"repo":     map[string]string{"type": "string"},
-						"cron":     map[string]string{"type": "string"},
-						"timezone": map[string]string{"type": "string"},
-						"steps": map[string]interface{}{
-							"type":  "array",
-							"items": map[string]string{"type": "string"},
-						},
-					},
-				},
-			},
-		},
+	var reply apiReply
+	if api == APIResponses || api == APIAuto && !chatOnly(baseURL) {
+		reply, err = callAPI(ctx, client, baseURL+"/responses", opts.APIKey, responsesRequest(modelName(opts), system, user, format), decodeResponses)
+		var status *statusError
+		if api == APIAuto && errors.As(err, &status) && (status.code == http.StatusNotFound || status.code == http.StatusMethodNotAllowed) {
+			// The server has no responses API; use chat completions from
+			// now on.
+			chatOnlyURLs.Store(baseURL, true)
+			api = APIChat
+		} else if err != nil {
+			return err
+		}
 	}
-
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
+	if api == APIChat || api == APIAuto && chatOnly(baseURL) {
+		reply, err = callAPI(ctx, client, baseURL+"/chat/completions", opts.APIKey, chatRequest(modelName(opts), system, user, format), decodeChat)
+		if err != nil {
+			return err
+		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/responses", bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, err
+	if sink != nil {
+		sink(TokenUsage{Model: modelName(opts), Purpose: purpose, InputTokens: reply.inputTokens, OutputTokens: reply.outputTokens})
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	for _, candidate := range reply.content {
+		if data, ok := extractJSON(candidate); ok {
+			return json.Unmarshal(data, out)
+		}
 	}
-	defer resp.Body.Close()
+	return errNoJSON
+}
 
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("planner API returned status %d", resp.StatusCode)
-	}
+var errNoJSON = errors.New("planner response missing JSON content")
 
-	var payload struct {
-		Output []struct {
-			Content []struct {
-				Type string          `json:"type"`
-				Text string          `json:"text"`
-				JSON json.RawMessage `json:"json"`
-			} `json:"content"`
-		} `json:"output"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return nil, err
+// maxAttempts is how many times a request is sent before a rate limit or
+// server error is returned.
+const maxAttempts = 4
+
+// retryBase is the wait before the first retry; each further retry waits
+// twice as long. Replaced in tests.
+var retryBase = time.Second
+
+// post sends body to url, retrying on 429 and 5xx responses after the
+// server's Retry-After or an exponential backoff. It stops retrying, and
+// returns the last response, when the wait would pass ctx's deadline.
+func post(ctx context.Context, client *http.Client, url, apiKey string, body []byte) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 || attempt == maxAttempts {
+			return resp, nil
+		}
+		wait := retryDelay(resp.Header.Get("Retry-After"), attempt, time.Now())
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
 	}
+}
 
-	for _, item := range payload.Output {
-		for _, content := range item.Content {
-			if content.JSON != nil {
-				var out llmResult
-				if err := json.Unmarshal(content.JSON, &out); err != nil {
-					return nil, err
-				}
-				return &out, nil
-			}
-			if content.Type == "output_text" && content.Text != "" {
-				var out llmResult
-				if err := json.Unmarshal([]byte(content.Text), &out); err == nil {
-					return &out, nil
-				}
-			}
+// retryDelay is how long to wait before retry number attempt: what the
+// Retry-After header asks for, in seconds or as a date, or else retryBase
+// doubled for each earlier retry.
+func retryDelay(retryAfter string, attempt int, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
 		}
+		return 0
 	}
+	return retryBase << (attempt - 1)
+}
 
-	return nil, errors.New("planner response missing JSON content")
+func userPrompt(spec string, opts Options) string {
+	prompt := spec
+	if len(opts.Targets) > 0 {
+		prompt += "\n\nCommands available in this repository (prefer these as steps when they fit): " + strings.Join(opts.Targets, ", ")
+	}
+	if opts.Current != "" {
+		prompt += "\n\nThe workflow currently generated from this spec is below. Keep what still fits and return the complete updated plan.\n" + opts.Current
+	}
+	if opts.Instructions != "" {
+		prompt += "\n\nRequested changes: " + opts.Instructions
+	}
+	return prompt
 }
 
 func plannerSystemPrompt() string {
-	return "You convert natural language repo automation specs into a strict JSON plan with fields: name, repo, cron, timezone, steps. Always output valid cron expressions with five fields."
+	return "You convert natural language repo automation specs into a strict JSON plan with fields: name, repo, cron, timezone, steps. Always output valid cron expressions with five fields. For tasks that should run only once, leave cron empty and set at to the local run time formatted as YYYY-MM-DDTHH:MM. For simple fixed intervals such as every 15 minutes, leave cron empty and set every to a Go duration like 15m or 2h."
+}
+
+// parseTiming derives one of a cron expression, a one-shot time or an
+// interval from text, trying them in that order of specificity: one-shot,
+// interval, then the common cron patterns. All are empty when none match.
+func parseTiming(text string, now time.Time) (cron, at, every string) {
+	if t, ok := parseOneShot(text, now); ok {
+		return "", t.Format(atLayout), ""
+	}
+	if every, ok := parseInterval(text); ok {
+		return "", "", every
+	}
+	cron, _ = parseCommonCron(text)
+	return cron, "", ""
 }
 
 type cronPattern struct {
@@ -284,6 +503,66 @@ func to24Hour(val string, meridiem string) string {
 	return fmt.Sprintf("%d", hour)
 }
 
+var intervalPattern = regexp.MustCompile(`(?i)\bevery\s+(\d+)\s*(m|mins?|minutes?|h|hrs?|hours?)\b`)
+
+// parseInterval recognises "every N minutes/hours" and returns the interval
+// as a Go duration string such as "15m".
+func parseInterval(spec string) (string, bool) {
+	matches := intervalPattern.FindStringSubmatch(spec)
+	if matches == nil {
+		return "", false
+	}
+	n, err := strconv.Atoi(matches[1])
+	if err != nil || n <= 0 {
+		return "", false
+	}
+	unit := "m"
+	if strings.HasPrefix(strings.ToLower(matches[2]), "h") {
+		unit = "h"
+	}
+	return fmt.Sprintf("%d%s", n, unit), true
+}
+
+// atLayout is the format used for planned one-shot run times.
+const atLayout = "2006-01-02T15:04"
+
+var (
+	relativeDayPattern = regexp.MustCompile(`(?i)\b(today|tonight|tomorrow)\s+at\s+(\d{1,2})(?::(\d{2}))?\s*(am|pm)?`)
+	absoluteDayPattern = regexp.MustCompile(`(?i)\b(?:on\s+)?(\d{4}-\d{2}-\d{2})\s+at\s+(\d{1,2})(?::(\d{2}))?\s*(am|pm)?`)
+)
+
+// parseOneShot recognises single-run phrases such as "tomorrow at 9am" or
+// "on 2024-07-01 at 14:30", resolved relative to now.
+func parseOneShot(spec string, now time.Time) (time.Time, bool) {
+	var (
+		day     time.Time
+		matches []string
+	)
+	if matches = relativeDayPattern.FindStringSubmatch(spec); matches != nil {
+		day = now
+		if strings.EqualFold(matches[1], "tomorrow") {
+			day = now.AddDate(0, 0, 1)
+		}
+	} else if matches = absoluteDayPattern.FindStringSubmatch(spec); matches != nil {
+		parsed, err := time.ParseInLocation("2006-01-02", matches[1], now.Location())
+		if err != nil {
+			return time.Time{}, false
+		}
+		day = parsed
+	} else {
+		return time.Time{}, false
+	}
+	hour, _ := strconv.Atoi(to24Hour(matches[2], matches[4]))
+	if strings.EqualFold(matches[1], "tonight") && matches[4] == "" && hour < 12 {
+		hour += 12
+	}
+	minute := 0
+	if matches[3] != "" {
+		minute, _ = strconv.Atoi(matches[3])
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location()), true
+}
+
 var repoPattern = regexp.MustCompile(`(?i)repo\s+([~\./\w\-_/]+)`)
 
 func extractRepoPath(spec string) string {
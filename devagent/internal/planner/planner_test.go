@@ -2,7 +2,12 @@ package planner
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseCommonCron(t *testing.T) {
@@ -14,3 +19,128 @@ func TestParseCommonCron(t *testing.T) {
 		t.Fatalf("cron mismatch: got %s want %s", got, want)
 	}
 }
+
+func TestParseOneShot(t *testing.T) {
+	now := time.Date(2024, 6, 30, 15, 0, 0, 0, time.UTC)
+	cases := map[string]string{
+		"deploy tomorrow at 9am":             "2024-07-01T09:00",
+		"cleanup tonight at 11":              "2024-06-30T23:00",
+		"release on 2024-07-04 at 14:30":     "2024-07-04T14:30",
+		"repo ~/app; run make; today at 5pm": "2024-06-30T17:00",
+	}
+	for spec, want := range cases {
+		at, ok := parseOneShot(spec, now)
+		if !ok {
+			t.Fatalf("%q: expected one-shot match", spec)
+		}
+		if got := at.Format(atLayout); got != want {
+			t.Fatalf("%q: got %s want %s", spec, got, want)
+		}
+	}
+	if _, ok := parseOneShot("every day at 9am", now); ok {
+		t.Fatalf("recurring spec must not be treated as one-shot")
+	}
+}
+
+func TestIntervalSpec(t *testing.T) {
+	plan, err := PlanFromSpec(context.Background(), "every 15 minutes sync the mirror", Options{StepHints: []string{"git pull"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Every != "15m" || plan.Cron != "" {
+		t.Fatalf("expected every=15m without cron, got every=%q cron=%q", plan.Every, plan.Cron)
+	}
+	if every, ok := parseInterval("check every 2 hours"); !ok || every != "2h" {
+		t.Fatalf("expected 2h, got %q ok=%v", every, ok)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "ModuleNotFoundError") {
+			t.Errorf("report not sent to the model: %s", body)
+		}
+		w.Write([]byte(`{"output":[{"content":[{"type":"output_text","text":"{\"cause\":\"missing dependency\",\"fix\":\"pip install requests\"}"}]}],"usage":{"input_tokens":120,"output_tokens":30}}`))
+	}))
+	defer srv.Close()
+	var used []TokenUsage
+	SetUsageSink(func(u TokenUsage) { used = append(used, u) })
+	defer SetUsageSink(nil)
+
+	diag, err := Diagnose(context.Background(), "Steps:\n  1. pytest (exit 1)\nModuleNotFoundError: requests", Options{APIKey: "test", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	}
+	if diag.Cause != "missing dependency" || diag.Fix != "pip install requests" {
+		t.Fatalf("unexpected diagnosis: %+v", diag)
+	}
+	if len(used) != 1 || used[0] != (TokenUsage{Model: DefaultModel, Purpose: "diagnose", InputTokens: 120, OutputTokens: 30}) {
+		t.Fatalf("usage passed to the sink: %+v", used)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	retryBase = time.Millisecond
+	defer func() { retryBase = time.Second }()
+	var statuses []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(statuses) > 0 {
+			status := statuses[0]
+			statuses = statuses[1:]
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"output":[{"content":[{"type":"output_text","text":"{\"cause\":\"flaky\",\"fix\":\"retry\"}"}]}]}`))
+	}))
+	defer srv.Close()
+	opts := Options{APIKey: "test", BaseURL: srv.URL}
+
+	statuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable}
+	if diag, err := Diagnose(context.Background(), "report", opts); err != nil || diag.Cause != "flaky" {
+		t.Fatalf("after three retries: %+v %v", diag, err)
+	}
+	statuses = []int{500, 500, 500, 500, 500}
+	if _, err := Diagnose(context.Background(), "report", opts); err == nil || !strings.Contains(err.Error(), "status 500") || len(statuses) != 1 {
+		t.Fatalf("expected the error after %d attempts: %v (%d left)", maxAttempts, err, len(statuses))
+	}
+	statuses = []int{http.StatusBadRequest}
+	if _, err := Diagnose(context.Background(), "report", opts); err == nil || !strings.Contains(err.Error(), "status 400") || len(statuses) != 0 {
+		t.Fatalf("a client error must not be retried: %v", err)
+	}
+
+	// A Retry-After past the timeout returns the error without waiting.
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	opts.Timeout = time.Second
+	start := time.Now()
+	if _, err := Diagnose(context.Background(), "report", opts); err == nil || !strings.Contains(err.Error(), "status 429") || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expected the rate limit error at once, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header  string
+		attempt int
+		want    time.Duration
+	}{
+		{"", 1, time.Second},
+		{"", 3, 4 * time.Second},
+		{"7", 1, 7 * time.Second},
+		{"Fri, 16 Oct 2026 12:00:30 GMT", 2, 30 * time.Second},
+		{"Fri, 16 Oct 2026 11:00:00 GMT", 2, 0},
+		{"soon", 2, 2 * time.Second},
+	}
+	for _, c := range cases {
+		if got := retryDelay(c.header, c.attempt, now); got != c.want {
+			t.Errorf("retryDelay(%q, %d) = %s, want %s", c.header, c.attempt, got, c.want)
+		}
+	}
+}
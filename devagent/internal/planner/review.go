@@ -0,0 +1,100 @@
+package planner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Warning is a concern the safety review found in a planned step.
+type Warning struct {
+	// Step is the 1-based index of the step.
+	Step    int
+	Command string
+	// Kind is "destructive", "secret" or "exfiltration".
+	Kind   string
+	Reason string
+}
+
+// String describes the warning with the command, its secrets masked so
+// printing the warning does not print them again.
+func (w Warning) String() string {
+	return fmt.Sprintf("step %d %s: %s (%s)", w.Step, w.Kind, w.Reason, maskSecrets(w.Command))
+}
+
+// maskSecrets replaces the text the secret review rules match in command
+// with <redacted>, keeping the separators around it.
+func maskSecrets(command string) string {
+	for _, rule := range reviewRules {
+		if rule.kind != "secret" {
+			continue
+		}
+		command = rule.re.ReplaceAllStringFunc(command, func(match string) string {
+			trimmed := strings.TrimLeft(match, " \t;&|:/")
+			prefix := match[:len(match)-len(trimmed)]
+			trimmed = strings.TrimRight(trimmed, "@")
+			return prefix + "<redacted>" + match[len(prefix)+len(trimmed):]
+		})
+	}
+	return command
+}
+
+// credentialFiles and netTools match the files that hold credentials and
+// the commands that send data to other machines.
+const (
+	credentialFiles = `\.env\b|\.ssh/|\bid_(rsa|ed25519|ecdsa)\b|\.aws/credentials|\.netrc\b|\.npmrc\b|\.pypirc\b|\.kube/config|\.docker/config\.json|/etc/(passwd|shadow)\b`
+	netTools        = `curl|wget|nc|ncat|netcat|scp|rsync|sftp|ftp`
+)
+
+type reviewRule struct {
+	kind   string
+	re     *regexp.Regexp
+	reason string
+}
+
+// reviewRules are checked against every planned step. They complement the
+// command policy, which refuses or asks about steps when they are saved and
+// run: these catch what is legitimate often enough that only a person
+// reading the plan can tell.
+var reviewRules = []reviewRule{
+	{"destructive", regexp.MustCompile(`\brm\s+(-\S+\s+)*-[a-zA-Z]*[rR][a-zA-Z]*\s+(-\S+\s+)*(\.|\*|\./\*|\$\w+|\$\{\w+\})(\s|$|[;&|])`), "recursively deletes the working directory or a path from a variable"},
+	{"destructive", regexp.MustCompile(`\bgit\s+(reset\s+(\S+\s+)*--hard|clean\s+(\S+\s+)*-[a-zA-Z]*f)`), "discards uncommitted changes"},
+	{"destructive", regexp.MustCompile(`(?i)\b(drop\s+(table|database|schema)|truncate\s+table)\b`), "drops or empties database tables"},
+	{"destructive", regexp.MustCompile(`(?i)\bdelete\s+from\s+[\w."]+\s*(;|$|["'])`), "deletes every row of a table"},
+	{"destructive", regexp.MustCompile(`\b(terraform\s+destroy|kubectl\s+delete|docker\s+(system|volume|image)\s+prune|helm\s+uninstall)\b`), "deletes infrastructure or containers"},
+	{"destructive", regexp.MustCompile(`\bfind\b.*\s(-delete|-exec\s+rm)\b`), "deletes the files find matches"},
+	{"destructive", regexp.MustCompile(`\bshred\b`), "overwrites files beyond recovery"},
+
+	{"secret", regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`), "has an AWS access key in plain text"},
+	{"secret", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_\w{20,})`), "has a GitHub token in plain text"},
+	{"secret", regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`), "has an API key in plain text"},
+	{"secret", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), "has a Slack token in plain text"},
+	{"secret", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`), "has a private key in plain text"},
+	{"secret", regexp.MustCompile(`(?i)authorization:\s*(bearer|basic|token)\s+[^\s"'$]{8,}`), "has an Authorization header in plain text"},
+	{"secret", regexp.MustCompile(`://[^/\s:@$]+:[^/\s@$]+@`), "has a password in a URL"},
+	{"secret", regexp.MustCompile(`(?i)(^|[\s;&|])(--[\w-]*(password|passwd|secret|token|api[_-]?key)[\w-]*(=|\s+)|[\w-]*(password|passwd|secret|token|api[_-]?key)[\w-]*=)["']?[^\s"'$-][^\s"'$]{5,}`), "has a password or token in plain text"},
+
+	{"exfiltration", regexp.MustCompile(`(?i)(` + credentialFiles + `).*\b(` + netTools + `)\b|\b(` + netTools + `)\b.*(` + credentialFiles + `)`), "sends credential files over the network"},
+	{"exfiltration", regexp.MustCompile(`\b(env|printenv|set)\s*\|.*\b(curl|wget|nc|ncat|netcat)\b`), "sends the environment over the network"},
+	{"exfiltration", regexp.MustCompile(`\bcurl\b[^|;&]*\s(-T|--upload-file|(-d|--data\S*|-F|--form)\s*['"]?[^\s'"]*@)`), "uploads local files"},
+	{"exfiltration", regexp.MustCompile(`\|\s*(nc|ncat|netcat)\b|/dev/(tcp|udp)/`), "streams data over a raw network connection"},
+}
+
+// ReviewSteps is the planner's safety review: it checks each step for
+// destructive commands, secrets in plain text and patterns that send data
+// off the machine, returning at most one warning per kind and step.
+func ReviewSteps(steps []string) []Warning {
+	var warnings []Warning
+	for i, step := range steps {
+		step = strings.TrimSpace(step)
+		seen := map[string]bool{}
+		for _, rule := range reviewRules {
+			if seen[rule.kind] || !rule.re.MatchString(step) {
+				continue
+			}
+			seen[rule.kind] = true
+			warnings = append(warnings, Warning{Step: i + 1, Command: step, Kind: rule.kind, Reason: rule.reason})
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,177 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/util"
+)
+
+// Schedule is when a job runs, resolved from a description by
+// PlanSchedule. Exactly one of Cron, At and Every is set.
+type Schedule struct {
+	// Natural is the description, or empty when it was a cron expression.
+	Natural string
+	Cron    string
+	At      string
+	Every   string
+	// Timezone is Options.Timezone, else the time zone the description
+	// names, else empty.
+	Timezone string
+	// Model is the LLM that resolved the schedule; empty when the
+	// heuristics did.
+	Model string
+	// Fallback is why the LLM's answer was not used when an API key was
+	// given.
+	Fallback error
+}
+
+// PlanSchedule resolves a description of when a job runs, e.g. "every
+// weekday at 7:30am Berlin time", with the LLM when opts.APIKey is set and
+// the heuristics otherwise. A five-field cron expression is used as it is.
+// Only the model, API and timezone fields of opts are used.
+func PlanSchedule(ctx context.Context, text string, opts Options) (*Schedule, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("schedule is empty")
+	}
+	if _, err := apiName(opts.API); err != nil {
+		return nil, err
+	}
+	if len(strings.Fields(text)) == 5 {
+		if _, err := dsl.ParseCron(text, false); err == nil {
+			return &Schedule{Cron: text, Timezone: opts.Timezone}, nil
+		}
+	}
+
+	sched := &Schedule{Natural: text, Timezone: opts.Timezone}
+	if opts.APIKey != "" {
+		format := map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name": "devagent_schedule",
+				"schema": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"cron", "at", "every", "timezone"},
+					"properties": map[string]interface{}{
+						"cron":     map[string]string{"type": "string"},
+						"at":       map[string]string{"type": "string"},
+						"every":    map[string]string{"type": "string"},
+						"timezone": map[string]string{"type": "string"},
+					},
+				},
+			},
+		}
+		user := fmt.Sprintf("%s\n\nThe current local time is %s.", text, time.Now().Format(atLayout))
+		plan, err := requestPlan(ctx, opts, "schedule", scheduleSystemPrompt(), user, format, func(plan *llmResult) []string {
+			return validateSchedule(plan, false)
+		})
+		if err == nil {
+			sched.Cron, sched.At, sched.Every = plan.Cron, plan.At, plan.Every
+			if sched.Timezone == "" {
+				sched.Timezone = plan.Timezone
+			}
+			sched.Model = modelName(opts)
+			return sched, nil
+		}
+		sched.Fallback = err
+	}
+
+	if sched.Timezone == "" {
+		sched.Timezone, _ = parseTimezone(text)
+	}
+	sched.Cron, sched.At, sched.Every = parseTiming(text, time.Now().In(util.ResolveLocation(sched.Timezone)))
+	if sched.Cron == "" && sched.At == "" && sched.Every == "" {
+		err := fmt.Errorf("unable to derive a schedule from %q; use a cron expression such as \"30 7 * * 1-5\"", text)
+		if sched.Fallback != nil {
+			err = fmt.Errorf("planner model: %v; %w", sched.Fallback, err)
+		}
+		return nil, err
+	}
+	return sched, nil
+}
+
+func scheduleSystemPrompt() string {
+	return "You convert a description of when a job should run into JSON with the fields cron, at, every and timezone. Set exactly one of cron, at and every, and leave the others empty. cron is a cron expression with five fields. For a single run, set at to the local run time formatted as YYYY-MM-DDTHH:MM. For a fixed interval such as every 15 minutes, set every to a Go duration like 15m or 2h. Set timezone to the IANA name of the time zone the description names, e.g. Europe/Berlin for Berlin time, and leave it empty when it names none."
+}
+
+// zoneAbbreviations maps the time zone names people write to IANA names.
+// Abbreviations such as CET that are IANA names themselves are not listed.
+var zoneAbbreviations = map[string]string{
+	"utc": "UTC", "gmt": "UTC",
+	"pst": "America/Los_Angeles", "pdt": "America/Los_Angeles", "pt": "America/Los_Angeles", "pacific": "America/Los_Angeles",
+	"mst": "America/Denver", "mdt": "America/Denver", "mt": "America/Denver", "mountain": "America/Denver",
+	"cst": "America/Chicago", "cdt": "America/Chicago", "ct": "America/Chicago", "central": "America/Chicago",
+	"est": "America/New_York", "edt": "America/New_York", "et": "America/New_York", "eastern": "America/New_York",
+	"cest": "CET", "eest": "EET",
+	"bst": "Europe/London", "ist": "Asia/Kolkata", "jst": "Asia/Tokyo", "aest": "Australia/Sydney",
+}
+
+// zoneRegions are the tz database areas a city name is looked up in.
+var zoneRegions = []string{"Europe", "America", "Asia", "Africa", "Australia", "Pacific", "Atlantic", "Indian"}
+
+var (
+	ianaZonePattern = regexp.MustCompile(`\b[A-Z][A-Za-z]+(?:/[A-Za-z_-]+)+\b`)
+	// zonePhrases capture up to two words before "time" or after "in", as
+	// in "Berlin time", "New York time" or "in Tokyo".
+	zonePhrases = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b([a-z]+(?: [a-z]+)?) time\b`),
+		regexp.MustCompile(`(?i)\bin ([a-z]+(?: [a-z]+)?)\b`),
+		regexp.MustCompile(`(?i)\b(utc|gmt|[pmce][sd]?t|bst|ist|jst|aest|cest|cet|eest|eet|wet)\b`),
+	}
+)
+
+// parseTimezone finds a time zone named in text, as an IANA name such as
+// Europe/Berlin, a city ("Berlin time", "in New York") or an abbreviation
+// (CET, PST, UTC), and returns its IANA name.
+func parseTimezone(text string) (string, bool) {
+	for _, name := range ianaZonePattern.FindAllString(text, -1) {
+		if _, err := time.LoadLocation(name); err == nil {
+			return name, true
+		}
+	}
+	for _, re := range zonePhrases {
+		for _, m := range re.FindAllStringSubmatch(text, -1) {
+			words := strings.Fields(strings.ToLower(m[1]))
+			// "New York" and then "York".
+			for len(words) > 0 {
+				if tz, ok := lookupZone(strings.Join(words, " ")); ok {
+					return tz, true
+				}
+				words = words[1:]
+			}
+		}
+	}
+	return "", false
+}
+
+// lookupZone returns the IANA name for an abbreviation or a city, given in
+// lower case.
+func lookupZone(name string) (string, bool) {
+	if tz, ok := zoneAbbreviations[name]; ok {
+		return tz, true
+	}
+	if upper := strings.ToUpper(name); len(name) <= 4 {
+		// CET, EET, WET and the like are zones of their own.
+		if _, err := time.LoadLocation(upper); err == nil {
+			return upper, true
+		}
+		return "", false
+	}
+	words := strings.Fields(name)
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	city := strings.Join(words, "_")
+	for _, region := range zoneRegions {
+		if _, err := time.LoadLocation(region + "/" + city); err == nil {
+			return region + "/" + city, true
+		}
+	}
+	return "", false
+}
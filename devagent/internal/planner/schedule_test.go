@@ -0,0 +1,84 @@
+package planner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTimezone(t *testing.T) {
+	cases := map[string]string{
+		"every weekday at 7:30am Berlin time":         "Europe/Berlin",
+		"every day at 9am New York time":              "America/New_York",
+		"every day at 6pm in Tokyo":                   "Asia/Tokyo",
+		"every day at 2am Europe/Lisbon":              "Europe/Lisbon",
+		"every weekday at 9am PST":                    "America/Los_Angeles",
+		"every day at noon UTC":                       "UTC",
+		"every day at 8am CET":                        "CET",
+		"every day at 3am":                            "",
+		"run the tests in parallel every day at 3am":  "",
+		"every day at 3am, in the morning local time": "",
+	}
+	for text, want := range cases {
+		got, ok := parseTimezone(text)
+		if got != want || ok != (want != "") {
+			t.Errorf("parseTimezone(%q) = %q, %v; want %q", text, got, ok, want)
+		}
+	}
+}
+
+func TestPlanScheduleHeuristics(t *testing.T) {
+	sched, err := PlanSchedule(context.Background(), "every weekday at 7:30am Berlin time", Options{})
+	if err != nil || sched.Cron != "30 7 * * 1-5" || sched.Timezone != "Europe/Berlin" || sched.Natural == "" || sched.Model != "" {
+		t.Fatalf("schedule: %+v %v", sched, err)
+	}
+	sched, err = PlanSchedule(context.Background(), "every 2 hours", Options{Timezone: "UTC"})
+	if err != nil || sched.Every != "2h" || sched.Cron != "" || sched.Timezone != "UTC" {
+		t.Fatalf("interval: %+v %v", sched, err)
+	}
+	sched, err = PlanSchedule(context.Background(), "15 4 * * 0", Options{})
+	if err != nil || sched.Cron != "15 4 * * 0" || sched.Natural != "" {
+		t.Fatalf("cron expression: %+v %v", sched, err)
+	}
+	if _, err := PlanSchedule(context.Background(), "whenever it suits", Options{}); err == nil {
+		t.Fatal("expected an error for a description without a schedule")
+	}
+}
+
+func TestPlanScheduleWithModel(t *testing.T) {
+	replies := []string{
+		`{"cron":"30 7 * * MON-FRI 2026","at":"","every":"","timezone":"Berlin"}`,
+		`{"cron":"30 7 * * 1-5","at":"","every":"","timezone":"Europe/Berlin"}`,
+	}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := replies[min(requests, len(replies)-1)]
+		requests++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"output": []map[string]interface{}{{"content": []map[string]string{{"type": "output_text", "text": reply}}}},
+		})
+	}))
+	defer srv.Close()
+	var used []TokenUsage
+	SetUsageSink(func(u TokenUsage) { used = append(used, u) })
+	defer SetUsageSink(nil)
+
+	sched, err := PlanSchedule(context.Background(), "weekdays at half past seven, Berlin time", Options{APIKey: "test", BaseURL: srv.URL})
+	if err != nil || sched.Cron != "30 7 * * 1-5" || sched.Timezone != "Europe/Berlin" || sched.Model != DefaultModel {
+		t.Fatalf("schedule: %+v %v", sched, err)
+	}
+	if requests != 2 || len(used) != 2 || used[0].Purpose != "schedule" {
+		t.Fatalf("expected one reprompt, got %d requests and usage %+v", requests, used)
+	}
+
+	// An answer that stays invalid falls back to the heuristics, which find
+	// nothing here.
+	replies = replies[:1]
+	requests = 0
+	if _, err := PlanSchedule(context.Background(), "weekdays at half past seven", Options{APIKey: "test", BaseURL: srv.URL}); err == nil || !strings.Contains(err.Error(), "unable to derive a schedule") {
+		t.Fatalf("expected the heuristics' error, got %v", err)
+	}
+}
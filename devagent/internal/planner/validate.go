@@ -0,0 +1,122 @@
+package planner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+// DefaultReprompts is how many times an invalid plan is sent back to the
+// model with its problems unless Options.Reprompts is set.
+const DefaultReprompts = 2
+
+// validatePlan checks a plan from the model against what a workflow needs,
+// returning one sentence per problem. Fields the options already fix, such
+// as the schedule with --cron, are not required of the model.
+func validatePlan(plan *llmResult, opts Options) []string {
+	var problems []string
+	if len(plan.Steps) == 0 && len(opts.StepHints) == 0 {
+		problems = append(problems, "steps is empty; list at least one shell command")
+	}
+	for i, step := range plan.Steps {
+		if strings.TrimSpace(step) == "" {
+			problems = append(problems, fmt.Sprintf("step %d is empty", i+1))
+		}
+	}
+
+	scheduled := opts.CronHint != "" || opts.At != "" || opts.Every != "" || opts.After != ""
+	problems = append(problems, validateSchedule(plan, scheduled)...)
+	if problem := checkRepo(plan.Repo); problem != "" {
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// validateSchedule checks the timing and timezone of a plan; scheduled
+// means the schedule is already set, so the plan need not have one.
+func validateSchedule(plan *llmResult, scheduled bool) []string {
+	var problems, timing []string
+	if plan.Cron != "" {
+		timing = append(timing, "cron")
+		if fields := strings.Fields(plan.Cron); len(fields) != 5 {
+			problems = append(problems, fmt.Sprintf("cron %q has %d fields; use five: minute hour day-of-month month day-of-week", plan.Cron, len(fields)))
+		} else if _, err := dsl.ParseCron(plan.Cron, false); err != nil {
+			problems = append(problems, fmt.Sprintf("cron %q is invalid: %v", plan.Cron, err))
+		}
+	}
+	if plan.At != "" {
+		timing = append(timing, "at")
+		if _, err := dsl.ParseAt(plan.At, time.UTC); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if plan.Every != "" {
+		timing = append(timing, "every")
+		if _, err := dsl.ParseEvery(plan.Every, false); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(timing) == 0 && !scheduled {
+		problems = append(problems, "no schedule; set one of cron, at or every")
+	}
+	if len(timing) > 1 {
+		problems = append(problems, fmt.Sprintf("set only one of %s", strings.Join(timing, ", ")))
+	}
+
+	if tz := strings.TrimSpace(plan.Timezone); tz != "" && !strings.EqualFold(tz, "local") && !strings.EqualFold(tz, "utc") {
+		if _, err := time.LoadLocation(tz); err != nil {
+			problems = append(problems, fmt.Sprintf("timezone %q is not an IANA time zone such as Europe/Berlin", plan.Timezone))
+		}
+	}
+	return problems
+}
+
+// checkRepo describes what is wrong with repo as a local repository path,
+// or returns "". Relative paths cannot be checked without knowing where the
+// job runs, so only their form is.
+func checkRepo(repo string) string {
+	if repo == "" {
+		return ""
+	}
+	if strings.Contains(repo, "://") || strings.HasPrefix(repo, "git@") {
+		return fmt.Sprintf("repo %q is a URL; use the path of a local checkout", repo)
+	}
+	if strings.TrimSpace(repo) != repo || strings.ContainsAny(repo, "\n\r\t\x00") {
+		return fmt.Sprintf("repo %q is not a plain path", repo)
+	}
+	path := repo
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	if !filepath.IsAbs(path) {
+		return ""
+	}
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Sprintf("repo %s does not exist; use a path from the spec or leave repo empty", repo)
+	case err == nil && !info.IsDir():
+		return fmt.Sprintf("repo %s is a file, not a directory", repo)
+	}
+	return ""
+}
+
+// repromptText asks the model to correct its last reply, which is quoted
+// when it could be decoded.
+func repromptText(previous *llmResult, problems []string) string {
+	text := "\n\nYour previous reply was rejected."
+	if previous != nil {
+		data, _ := json.Marshal(previous)
+		text = "\n\nYour previous reply was rejected:\n" + string(data)
+	}
+	return text + "\nFix these problems:\n- " + strings.Join(problems, "\n- ") + "\nReply with the complete corrected plan as JSON."
+}
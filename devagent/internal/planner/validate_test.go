@@ -0,0 +1,92 @@
+package planner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidatePlan(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, nil, 0o644)
+	cases := []struct {
+		plan llmResult
+		opts Options
+		want string // a problem expected, or "" for none
+	}{
+		{llmResult{Repo: dir, Cron: "0 2 * * *", Timezone: "Europe/Berlin", Steps: []string{"make test"}}, Options{}, ""},
+		{llmResult{Cron: "0 2 * * *", Timezone: "UTC", Steps: []string{"make test"}}, Options{}, ""},
+		{llmResult{Steps: []string{"make test"}}, Options{After: "build"}, ""},
+		{llmResult{Cron: "0 2 * * *"}, Options{StepHints: []string{"make"}}, ""},
+		{llmResult{Cron: "0 2 * * *"}, Options{}, "steps is empty"},
+		{llmResult{Cron: "0 2 * * *", Steps: []string{"make", " "}}, Options{}, "step 2 is empty"},
+		{llmResult{Steps: []string{"make"}}, Options{}, "no schedule"},
+		{llmResult{Cron: "0 0 2 * * *", Steps: []string{"make"}}, Options{}, "has 6 fields"},
+		{llmResult{Cron: "0 25 * * *", Steps: []string{"make"}}, Options{}, "is invalid"},
+		{llmResult{Cron: "0 2 * * *", Every: "1h", Steps: []string{"make"}}, Options{}, "set only one of cron, every"},
+		{llmResult{Every: "10s", Steps: []string{"make"}}, Options{}, "shorter than"},
+		{llmResult{At: "tomorrow", Steps: []string{"make"}}, Options{}, "invalid at time"},
+		{llmResult{Cron: "0 2 * * *", Timezone: "Berlin Time", Steps: []string{"make"}}, Options{}, "not an IANA time zone"},
+		{llmResult{Repo: "https://github.com/acme/app", Cron: "0 2 * * *", Steps: []string{"make"}}, Options{}, "is a URL"},
+		{llmResult{Repo: filepath.Join(dir, "missing"), Cron: "0 2 * * *", Steps: []string{"make"}}, Options{}, "does not exist"},
+		{llmResult{Repo: file, Cron: "0 2 * * *", Steps: []string{"make"}}, Options{}, "is a file"},
+	}
+	for _, c := range cases {
+		problems := strings.Join(validatePlan(&c.plan, c.opts), "; ")
+		if c.want == "" && problems != "" || !strings.Contains(problems, c.want) {
+			t.Errorf("%+v: got %q, want %q", c.plan, problems, c.want)
+		}
+	}
+}
+
+func TestReprompt(t *testing.T) {
+	var prompts []string
+	replies := []string{
+		`{"repo":"","cron":"every night","steps":["make test"]}`,
+		`{"repo":"","cron":"0 2 * * *","steps":"make test"}`,
+		`{"repo":"","cron":"0 2 * * *","steps":["make test"]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"input"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		prompts = append(prompts, req.Input[len(req.Input)-1].Content[0].Text)
+		reply := replies[min(len(prompts), len(replies))-1]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"output": []map[string]interface{}{{"content": []map[string]string{{"type": "output_text", "text": reply}}}},
+		})
+	}))
+	defer srv.Close()
+
+	plan, err := PlanFromSpec(context.Background(), "run make test nightly", Options{APIKey: "test", BaseURL: srv.URL})
+	if err != nil || plan.Cron != "0 2 * * *" || plan.Model == "" || plan.Fallback != nil {
+		t.Fatalf("plan after two reprompts: %+v %v", plan, err)
+	}
+	if len(prompts) != 3 || !strings.Contains(prompts[1], `"cron":"every night"`) || !strings.Contains(prompts[1], "has 2 fields") || !strings.Contains(prompts[2], "cannot unmarshal") {
+		t.Fatalf("prompts: %q", prompts)
+	}
+
+	// With one reprompt the model's plans stay invalid, and the heuristics
+	// plan instead.
+	prompts = nil
+	plan, err = PlanFromSpec(context.Background(), "run make test every day at 2am", Options{APIKey: "test", BaseURL: srv.URL, Reprompts: 1, StepHints: []string{"make test"}})
+	if err != nil || plan.Model != "" || plan.Cron != "0 2 * * *" || len(prompts) != 2 {
+		t.Fatalf("heuristic plan: %+v %v after %d requests", plan, err, len(prompts))
+	}
+	if plan.Fallback == nil || !strings.Contains(plan.Fallback.Error(), "invalid plan after 2 attempts") {
+		t.Fatalf("fallback: %v", plan.Fallback)
+	}
+}
@@ -0,0 +1,156 @@
+// Package plugin finds the executables that add step kinds and notification
+// channels to devagent. A plugin is any program named devagent-step-<name>
+// or devagent-notify-<name> in the plugins directory or on PATH; devagent
+// runs it with a JSON request and reads nothing back but its exit code.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"devagent/internal/paths"
+)
+
+// Protocol is the version of the requests devagent sends, passed in their
+// "protocol" field and in DEVAGENT_PLUGIN_PROTOCOL.
+const Protocol = 1
+
+// Kinds of plugins.
+const (
+	Step   = "step"
+	Notify = "notify"
+)
+
+// ErrNotFound is returned by Find for a plugin that is not installed.
+var ErrNotFound = errors.New("plugin not found")
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// ValidName reports whether name can name a plugin: lowercase letters,
+// digits, '.', '_' and '-'.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Dir is the plugins directory in the config directory, searched before
+// PATH.
+func Dir() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// Executable returns the program name of a plugin of kind.
+func Executable(kind, name string) string {
+	return "devagent-" + kind + "-" + name
+}
+
+// Find returns the path of the kind plugin called name.
+func Find(kind, name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("invalid %s plugin name %q", kind, name)
+	}
+	program := Executable(kind, name)
+	if dir, err := Dir(); err == nil {
+		path := filepath.Join(dir, program)
+		if executable(path) {
+			return path, nil
+		}
+	}
+	if path, err := exec.LookPath(program); err == nil {
+		return path, nil
+	}
+	dir, _ := Dir()
+	return "", fmt.Errorf("%w: %s plugin %s (install %s in %s or on PATH)", ErrNotFound, kind, name, program, dir)
+}
+
+// Plugin is an installed plugin.
+type Plugin struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// List returns the installed plugins by kind and name. A plugin in the
+// plugins directory hides one of the same name on PATH.
+func List() ([]Plugin, error) {
+	var dirs []string
+	if dir, err := Dir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+	seen := map[string]bool{}
+	var plugins []Plugin
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			for _, kind := range []string{Step, Notify} {
+				name, ok := strings.CutPrefix(entry.Name(), "devagent-"+kind+"-")
+				path := filepath.Join(dir, entry.Name())
+				if !ok || !ValidName(name) || seen[kind+"/"+name] || !executable(path) {
+					continue
+				}
+				seen[kind+"/"+name] = true
+				plugins = append(plugins, Plugin{Kind: kind, Name: name, Path: path})
+			}
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		if plugins[i].Kind != plugins[j].Kind {
+			return plugins[i].Kind < plugins[j].Kind
+		}
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins, nil
+}
+
+func executable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0o111 != 0
+}
+
+// StepRequest is written to a file whose path is a step plugin's only
+// argument. The plugin runs in the step's working directory and
+// environment; its output goes to the step log and its exit code is the
+// step's.
+type StepRequest struct {
+	Protocol int    `json:"protocol"`
+	Job      string `json:"job"`
+	// Step is the 1-based index of the step in the workflow.
+	Step    int    `json:"step"`
+	Repo    string `json:"repo"`
+	Workdir string `json:"workdir"`
+	RunDir  string `json:"run_dir"`
+	// Outputs is the file the plugin may append key=value outputs to, as
+	// DEVAGENT_OUTPUT.
+	Outputs string `json:"outputs"`
+	// With holds the step's settings from the workflow.
+	With map[string]string `json:"with,omitempty"`
+}
+
+// NotifyRequest is written to a notify plugin's stdin. A non-zero exit
+// fails the notification, with the plugin's stderr as the reason.
+type NotifyRequest struct {
+	Protocol int `json:"protocol"`
+	// Target is what follows the plugin name in the channel, e.g. "#builds"
+	// for plugin:slack:#builds.
+	Target  string `json:"target,omitempty"`
+	Job     string `json:"job"`
+	Status  string `json:"status"`
+	Streak  int    `json:"streak"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Urgent  bool   `json:"urgent"`
+	LogTail string `json:"log_tail,omitempty"`
+}
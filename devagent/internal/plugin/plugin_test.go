@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListPrefersPluginsDirectory(t *testing.T) {
+	t.Setenv("DEVAGENT_HOME", t.TempDir())
+	dir, err := Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	install := func(dir, name string, mode os.FileMode) {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	install(dir, "devagent-step-terraform", 0o755)
+	install(bin, "devagent-step-terraform", 0o755)
+	install(bin, "devagent-notify-slack", 0o755)
+	install(bin, "devagent-notify-readme", 0o644)
+	install(bin, "devagent-step-Bad", 0o755)
+
+	plugins, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 2 || plugins[0].Name != "slack" || plugins[1].Path != filepath.Join(dir, "devagent-step-terraform") {
+		t.Fatalf("unexpected plugins %+v", plugins)
+	}
+	if path, err := Find(Notify, "slack"); err != nil || path != filepath.Join(bin, "devagent-notify-slack") {
+		t.Fatalf("Find returned %q, %v", path, err)
+	}
+	if _, err := Find(Step, "missing"); err == nil {
+		t.Fatal("expected a missing plugin to be reported")
+	}
+}
@@ -0,0 +1,300 @@
+// Package policy decides whether a shell command may run as a workflow step.
+// It guards both planner output, which is written by an LLM, and step
+// execution against destructive commands.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"devagent/internal/paths"
+)
+
+// Action is the outcome of checking a command.
+type Action int
+
+const (
+	// Allow lets the command run.
+	Allow Action = iota
+	// Confirm requires a person to approve the command before it is saved
+	// into a workflow or run unattended.
+	Confirm
+	// Deny refuses the command.
+	Deny
+)
+
+func (a Action) String() string {
+	switch a {
+	case Confirm:
+		return "confirm"
+	case Deny:
+		return "deny"
+	}
+	return "allow"
+}
+
+// Rule matches commands with a regular expression.
+type Rule struct {
+	Pattern string `yaml:"pattern"`
+	Reason  string `yaml:"reason,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Policy holds the rules applied to step commands. A single command whose
+// whole text matches an Allow pattern skips every other check.
+type Policy struct {
+	Allow   []string `yaml:"allow,omitempty"`
+	Deny    []Rule   `yaml:"deny,omitempty"`
+	Confirm []Rule   `yaml:"confirm,omitempty"`
+
+	allow []*regexp.Regexp
+}
+
+// Decision is the result of checking one command.
+type Decision struct {
+	Command string
+	Action  Action
+	Reason  string
+}
+
+// builtinDeny and builtinConfirm apply to every policy, in addition to the
+// recursive-delete and write checks done by Check.
+var (
+	builtinDeny = []Rule{
+		{Pattern: `\b(curl|wget)\b[^|;&]*\|\s*(sudo\s+)?(ba|z|da)?sh\b`, Reason: "pipes a download into a shell"},
+		{Pattern: `\bmkfs(\.\w+)?\b`, Reason: "formats a filesystem"},
+		{Pattern: `\bdd\b.*\bof=/dev/`, Reason: "writes to a raw device"},
+		{Pattern: `:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}`, Reason: "fork bomb"},
+		{Pattern: `\bch(mod|own)\s+(-\S+\s+)*-[a-zA-Z]*R[a-zA-Z]*\s+(\S+\s+)?/(\s|$)`, Reason: "recursively changes permissions of /"},
+	}
+	builtinConfirm = []Rule{
+		{Pattern: `(^|[;&|]\s*)sudo\b`, Reason: "runs as root"},
+		{Pattern: `\bgit\s+push\b.*(\s-f\b|--force)`, Reason: "force-pushes"},
+	}
+)
+
+// Default returns the built-in policy.
+func Default() *Policy {
+	p := &Policy{}
+	_ = p.compile()
+	return p
+}
+
+// Path returns the location of the user policy file.
+func Path() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "policy.yml"), nil
+}
+
+// Load reads policy.yml in the config directory on top of the built-in rules; a missing
+// file means the built-in policy.
+func Load() (*Policy, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Default(), nil
+		}
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) compile() error {
+	p.Deny = append(append([]Rule{}, builtinDeny...), p.Deny...)
+	p.Confirm = append(append([]Rule{}, builtinConfirm...), p.Confirm...)
+	for _, rules := range [][]Rule{p.Deny, p.Confirm} {
+		for i := range rules {
+			re, err := regexp.Compile(rules[i].Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", rules[i].Pattern, err)
+			}
+			rules[i].re = re
+		}
+	}
+	p.allow = p.allow[:0]
+	for _, pattern := range p.Allow {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid allow pattern %q: %w", pattern, err)
+		}
+		p.allow = append(p.allow, re)
+	}
+	return nil
+}
+
+// Check decides whether command may run in repo. Deny rules win over
+// confirm rules; an empty repo skips the checks for relative paths. Allow
+// patterns must match the whole command and never apply to one that
+// chains or substitutes others, so `make && curl ... | sh` is checked even
+// when make is allowed.
+func (p *Policy) Check(command, repo string) Decision {
+	if p == nil {
+		p = Default()
+	}
+	command = strings.TrimSpace(command)
+	d := Decision{Command: command}
+	if !chained.MatchString(command) {
+		for _, re := range p.allow {
+			if re.MatchString(command) {
+				return d
+			}
+		}
+	}
+	if reason := checkPaths(command, repo); reason != "" {
+		d.Action, d.Reason = Deny, reason
+		return d
+	}
+	for _, rule := range p.Deny {
+		if rule.re.MatchString(command) {
+			d.Action, d.Reason = Deny, rule.describe()
+			return d
+		}
+	}
+	for _, rule := range p.Confirm {
+		if rule.re.MatchString(command) {
+			d.Action, d.Reason = Confirm, rule.describe()
+			return d
+		}
+	}
+	return d
+}
+
+// Review checks every command and returns the decisions that are not Allow.
+func (p *Policy) Review(commands []string, repo string) []Decision {
+	var flagged []Decision
+	for _, command := range commands {
+		if d := p.Check(command, repo); d.Action != Allow {
+			flagged = append(flagged, d)
+		}
+	}
+	return flagged
+}
+
+func (r Rule) describe() string {
+	if r.Reason != "" {
+		return r.Reason
+	}
+	return "matches " + r.Pattern
+}
+
+var (
+	// chained matches shell syntax that runs more than one command.
+	chained      = regexp.MustCompile("\\|\\||&&|[;|&\n`]|\\$\\(")
+	segmentSplit = regexp.MustCompile(`\|\||&&|[;|&\n]`)
+	redirect     = regexp.MustCompile(`(?:^|[^<>&0-9])[0-9]?>>?\s*([^\s;|&<>()]+)`)
+)
+
+// checkPaths flags recursive deletes and writes (redirections and tee)
+// that reach outside the repo.
+func checkPaths(command, repo string) string {
+	for _, m := range redirect.FindAllStringSubmatch(command, -1) {
+		if outside(m[1], repo) {
+			return fmt.Sprintf("writes outside the repo (%s)", m[1])
+		}
+	}
+	for _, segment := range segmentSplit.Split(command, -1) {
+		fields := strings.Fields(segment)
+		for len(fields) > 0 && (fields[0] == "sudo" || fields[0] == "command" || strings.Contains(fields[0], "=")) {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "rm":
+			recursive := false
+			for _, arg := range fields[1:] {
+				if arg == "--recursive" || (strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsAny(arg, "rR")) {
+					recursive = true
+				}
+			}
+			if !recursive {
+				continue
+			}
+			for _, arg := range fields[1:] {
+				if !strings.HasPrefix(arg, "-") && (outside(arg, repo) || isRoot(arg)) {
+					return fmt.Sprintf("recursively deletes outside the repo (%s)", arg)
+				}
+			}
+		case "tee":
+			for _, arg := range fields[1:] {
+				if !strings.HasPrefix(arg, "-") && outside(arg, repo) {
+					return fmt.Sprintf("writes outside the repo (%s)", arg)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// outside reports whether path, as written in a command run from repo,
+// leaves the repo. Device files and the temp directory are allowed; paths
+// built from other variables cannot be judged and are allowed too.
+func outside(path, repo string) bool {
+	path = strings.Trim(path, `"'`)
+	switch {
+	case path == "":
+		return false
+	case path == "~" || strings.HasPrefix(path, "~/") || path == "$HOME" || strings.HasPrefix(path, "$HOME/") || strings.HasPrefix(path, "${HOME}"):
+		return !within(expandHome(path), repo)
+	case strings.HasPrefix(path, "$"):
+		return false
+	case filepath.IsAbs(path):
+		clean := filepath.Clean(path)
+		if strings.HasPrefix(clean, "/dev/") || within(clean, "/tmp") || within(clean, os.TempDir()) {
+			return false
+		}
+		return !within(clean, repo)
+	case repo == "":
+		return strings.HasPrefix(filepath.Clean(path), "..")
+	default:
+		return !within(filepath.Join(repo, path), repo)
+	}
+}
+
+func isRoot(path string) bool {
+	path = strings.Trim(path, `"'`)
+	return path == "/" || path == "/*" || path == "~" || path == "$HOME"
+}
+
+func within(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func expandHome(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	for _, prefix := range []string{"${HOME}", "$HOME", "~"} {
+		if strings.HasPrefix(path, prefix) {
+			return home + strings.TrimPrefix(path, prefix)
+		}
+	}
+	return path
+}
@@ -0,0 +1,160 @@
+// Package power reports whether the machine is on AC power, its battery
+// charge and how long the user has been idle, so the daemon can hold heavy
+// jobs back on laptops.
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requirement names accepted in schedule.requires.
+const (
+	ACPower = "ac_power"
+	Idle    = "idle"
+)
+
+// Requirements lists the accepted schedule.requires values.
+var Requirements = []string{ACPower, Idle}
+
+// State is a snapshot of the machine's power and activity.
+type State struct {
+	// OnAC is true when running from mains power or on a machine without a
+	// battery.
+	OnAC bool
+	// Battery is the charge in percent, or -1 when there is no battery.
+	Battery int
+	// IdleFor is the time since the last keyboard or mouse input; -1 when
+	// it cannot be determined.
+	IdleFor time.Duration
+}
+
+// Conditions are what a job needs before it may start.
+type Conditions struct {
+	Requires []string
+	// MinBattery is the lowest charge, in percent, at which the job may run
+	// on battery.
+	MinBattery int
+	// IdleAfter is how long without input counts as idle.
+	IdleAfter time.Duration
+}
+
+// Unmet returns a reason for every condition s does not satisfy. Idleness
+// that cannot be measured is not held against the job.
+func (s State) Unmet(c Conditions) []string {
+	var reasons []string
+	for _, req := range c.Requires {
+		switch req {
+		case ACPower:
+			if !s.OnAC {
+				reasons = append(reasons, "on battery power")
+			}
+		case Idle:
+			if s.IdleFor >= 0 && s.IdleFor < c.IdleAfter {
+				reasons = append(reasons, fmt.Sprintf("user active %s ago", s.IdleFor.Round(time.Second)))
+			}
+		}
+	}
+	if c.MinBattery > 0 && !s.OnAC && s.Battery >= 0 && s.Battery < c.MinBattery {
+		reasons = append(reasons, fmt.Sprintf("battery at %d%% (needs %d%%)", s.Battery, c.MinBattery))
+	}
+	return reasons
+}
+
+// Read probes the current power state with pmset and ioreg on macOS, and
+// /sys/class/power_supply and xprintidle on Linux.
+func Read(ctx context.Context) (State, error) {
+	if runtime.GOOS == "darwin" {
+		return readDarwin(ctx)
+	}
+	return readLinux(ctx, "/sys/class/power_supply")
+}
+
+func readDarwin(ctx context.Context) (State, error) {
+	out, err := exec.CommandContext(ctx, "pmset", "-g", "batt").Output()
+	if err != nil {
+		return State{}, fmt.Errorf("pmset: %w", err)
+	}
+	state := parsePmset(string(out))
+	state.IdleFor = -1
+	if out, err := exec.CommandContext(ctx, "ioreg", "-c", "IOHIDSystem", "-d", "4").Output(); err == nil {
+		if idle, ok := parseIoregIdle(string(out)); ok {
+			state.IdleFor = idle
+		}
+	}
+	return state, nil
+}
+
+var pmsetPercent = regexp.MustCompile(`(\d+)%`)
+
+// parsePmset reads the output of `pmset -g batt`.
+func parsePmset(out string) State {
+	state := State{OnAC: !strings.Contains(out, "'Battery Power'"), Battery: -1}
+	if m := pmsetPercent.FindStringSubmatch(out); m != nil {
+		state.Battery, _ = strconv.Atoi(m[1])
+	}
+	return state
+}
+
+var ioregIdle = regexp.MustCompile(`"HIDIdleTime"\s*=\s*(\d+)`)
+
+// parseIoregIdle extracts HIDIdleTime, in nanoseconds, from ioreg output.
+func parseIoregIdle(out string) (time.Duration, bool) {
+	m := ioregIdle.FindStringSubmatch(out)
+	if m == nil {
+		return 0, false
+	}
+	ns, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(ns), true
+}
+
+func readLinux(ctx context.Context, sysDir string) (State, error) {
+	state := State{OnAC: true, Battery: -1, IdleFor: -1}
+	supplies, err := os.ReadDir(sysDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return State{}, err
+	}
+	sawMains := false
+	for _, supply := range supplies {
+		dir := filepath.Join(sysDir, supply.Name())
+		switch readTrimmed(filepath.Join(dir, "type")) {
+		case "Mains":
+			online := readTrimmed(filepath.Join(dir, "online")) == "1"
+			state.OnAC = (sawMains && state.OnAC) || online
+			sawMains = true
+		case "Battery":
+			if n, err := strconv.Atoi(readTrimmed(filepath.Join(dir, "capacity"))); err == nil && (state.Battery < 0 || n < state.Battery) {
+				state.Battery = n
+			}
+			if !sawMains && readTrimmed(filepath.Join(dir, "status")) == "Discharging" {
+				state.OnAC = false
+			}
+		}
+	}
+	if out, err := exec.CommandContext(ctx, "xprintidle").Output(); err == nil {
+		if ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err == nil {
+			state.IdleFor = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return state, nil
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
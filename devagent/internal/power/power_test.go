@@ -0,0 +1,64 @@
+package power
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParsePmset(t *testing.T) {
+	battery := "Now drawing from 'Battery Power'\n -InternalBattery-0 (id=4653155)\t42%; discharging; 3:10 remaining present: true\n"
+	if s := parsePmset(battery); s.OnAC || s.Battery != 42 {
+		t.Fatalf("unexpected state %+v", s)
+	}
+	desktop := "Now drawing from 'AC Power'\n"
+	if s := parsePmset(desktop); !s.OnAC || s.Battery != -1 {
+		t.Fatalf("unexpected state %+v", s)
+	}
+	if idle, ok := parseIoregIdle(`    |   "HIDIdleTime" = 125000000000`); !ok || idle != 125*time.Second {
+		t.Fatalf("unexpected idle time %s", idle)
+	}
+}
+
+func TestReadLinuxPowerSupply(t *testing.T) {
+	dir := t.TempDir()
+	write := func(supply, file, value string) {
+		if err := os.MkdirAll(filepath.Join(dir, supply), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, supply, file), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("AC", "type", "Mains")
+	write("AC", "online", "0")
+	write("BAT0", "type", "Battery")
+	write("BAT0", "capacity", "35")
+
+	s, err := readLinux(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.OnAC || s.Battery != 35 {
+		t.Fatalf("unexpected state %+v", s)
+	}
+	reasons := s.Unmet(Conditions{Requires: []string{ACPower}, MinBattery: 50})
+	if len(reasons) != 2 {
+		t.Fatalf("expected battery and charge to be reported, got %v", reasons)
+	}
+}
+
+func TestUnmetIdle(t *testing.T) {
+	c := Conditions{Requires: []string{Idle}, IdleAfter: 5 * time.Minute}
+	if r := (State{OnAC: true, IdleFor: time.Minute}).Unmet(c); len(r) != 1 {
+		t.Fatalf("active user should block the job, got %v", r)
+	}
+	if r := (State{OnAC: true, IdleFor: 10 * time.Minute}).Unmet(c); len(r) != 0 {
+		t.Fatalf("idle user should not block the job, got %v", r)
+	}
+	if r := (State{OnAC: true, IdleFor: -1}).Unmet(c); len(r) != 0 {
+		t.Fatalf("unknown idle time should not block the job, got %v", r)
+	}
+}
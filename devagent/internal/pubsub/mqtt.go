@@ -0,0 +1,136 @@
+package pubsub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// mqttSink publishes to <topic>/<job>/<kind> with MQTT 3.1.1 at QoS 0, the
+// subset devagent needs: connect, publish and disconnect.
+type mqttSink struct {
+	url   *url.URL
+	topic string
+	conn  net.Conn
+}
+
+func (m *mqttSink) name() string { return "mqtt " + m.url.Host }
+
+func (m *mqttSink) publish(job, kind string, payload []byte) error {
+	if m.conn == nil {
+		if err := m.connect(); err != nil {
+			return err
+		}
+	}
+	var body bytes.Buffer
+	writeString(&body, m.topic+"/"+job+"/"+kind)
+	body.Write(payload)
+	return m.write(0x30, body.Bytes())
+}
+
+// connect opens a clean session without keep-alive, so an idle daemon
+// needs no pings; a connection the broker dropped is noticed on the next
+// publish.
+func (m *mqttSink) connect() error {
+	conn, err := dial(m.url, "1883")
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+
+	var body bytes.Buffer
+	writeString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+	flags := byte(0x02)
+	user := m.url.User.Username()
+	password, hasPassword := m.url.User.Password()
+	if user != "" {
+		flags |= 0x80
+	}
+	if hasPassword {
+		flags |= 0x40
+	}
+	body.WriteByte(flags)
+	body.Write([]byte{0, 0}) // keep-alive off
+	host, _ := os.Hostname()
+	writeString(&body, fmt.Sprintf("devagent-%s-%d", host, os.Getpid()))
+	if user != "" {
+		writeString(&body, user)
+	}
+	if hasPassword {
+		writeString(&body, password)
+	}
+	if err := m.write(0x10, body.Bytes()); err != nil {
+		m.close()
+		return err
+	}
+
+	ack := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		m.close()
+		return fmt.Errorf("read CONNACK: %w", err)
+	}
+	if ack[0] != 0x20 {
+		m.close()
+		return fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", ack[0])
+	}
+	if ack[3] != 0 {
+		m.close()
+		return fmt.Errorf("broker refused the connection (%s)", connackReason(ack[3]))
+	}
+	return nil
+}
+
+// write sends one packet of the given type and flags.
+func (m *mqttSink) write(header byte, body []byte) error {
+	packet := []byte{header}
+	for n := len(body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_ = m.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := m.conn.Write(append(packet, body...))
+	return err
+}
+
+func (m *mqttSink) close() {
+	if m.conn == nil {
+		return
+	}
+	_ = m.write(0xE0, nil)
+	m.conn.Close()
+	m.conn = nil
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	b.Write([]byte{byte(len(s) >> 8), byte(len(s))})
+	b.WriteString(s)
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
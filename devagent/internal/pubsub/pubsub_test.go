@@ -0,0 +1,156 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/runner"
+)
+
+// fakeBroker accepts MQTT connections and sends the topic and payload of
+// every PUBLISH to the returned channel.
+func fakeBroker(t *testing.T) (string, <-chan [2]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan [2]string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					header, err := r.ReadByte()
+					if err != nil {
+						return
+					}
+					n, mult := 0, 1
+					for {
+						b, _ := r.ReadByte()
+						n += int(b&0x7f) * mult
+						mult *= 128
+						if b&0x80 == 0 {
+							break
+						}
+					}
+					body := make([]byte, n)
+					if _, err := io.ReadFull(r, body); err != nil {
+						return
+					}
+					switch header & 0xf0 {
+					case 0x10:
+						conn.Write([]byte{0x20, 2, 0, 0})
+					case 0x30:
+						size := int(body[0])<<8 | int(body[1])
+						got <- [2]string{string(body[2 : 2+size]), string(body[2+size:])}
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+// fakeRedis answers every command with :1 and sends the commands to the
+// returned channel.
+func fakeRedis(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			args := make([]string, count)
+			for i := range args {
+				sizeLine, _ := r.ReadString('\n')
+				size, _ := strconv.Atoi(strings.TrimSpace(sizeLine[1:]))
+				arg := make([]byte, size+2)
+				io.ReadFull(r, arg)
+				args[i] = string(arg[:size])
+			}
+			got <- args
+			conn.Write([]byte(":1\r\n"))
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestPublisherSendsRunEvents(t *testing.T) {
+	broker, published := fakeBroker(t)
+	redis, commands := fakeRedis(t)
+	pub, err := New(&Config{MQTT: "mqtt://" + broker, Redis: "redis://:pw@" + redis + "/2", Topic: "home/devagent"}, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pub.Run(ctx)
+
+	pub.Publish("nightly", runner.StepEvent{Kind: runner.EventOutput, Output: "noise"})
+	pub.Publish("nightly", runner.StepEvent{Kind: runner.EventRunFinish, RunID: 7, Status: "success"})
+
+	select {
+	case msg := <-published:
+		if msg[0] != "home/devagent/nightly/run_finish" {
+			t.Fatalf("published to %q", msg[0])
+		}
+		var m Message
+		if err := json.Unmarshal([]byte(msg[1]), &m); err != nil || m.Job != "nightly" || m.RunID != 7 || m.Status != "success" {
+			t.Fatalf("unexpected payload %s (%v)", msg[1], err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing published over MQTT")
+	}
+
+	var got [][]string
+	for len(got) < 3 {
+		select {
+		case args := <-commands:
+			got = append(got, args)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("redis got only %q", got)
+		}
+	}
+	if strings.Join(got[0], " ") != "AUTH pw" || strings.Join(got[1], " ") != "SELECT 2" {
+		t.Fatalf("unexpected setup %q", got[:2])
+	}
+	if got[2][0] != "PUBLISH" || got[2][1] != "home/devagent" || !strings.Contains(got[2][2], `"kind":"run_finish"`) {
+		t.Fatalf("unexpected publish %q", got[2])
+	}
+}
+
+func TestConfigNeedsAServer(t *testing.T) {
+	for _, cfg := range []Config{{}, {MQTT: "http://broker"}, {Redis: "localhost:6379"}} {
+		if _, err := New(&cfg, t.Logf); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+}
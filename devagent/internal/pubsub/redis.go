@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisSink publishes every event to one channel with PUBLISH; the job and
+// kind are in the payload.
+type redisSink struct {
+	url     *url.URL
+	channel string
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func (r *redisSink) name() string { return "redis " + r.url.Host }
+
+func (r *redisSink) publish(job, kind string, payload []byte) error {
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return err
+		}
+	}
+	return r.command("PUBLISH", r.channel, string(payload))
+}
+
+// connect authenticates with the URL's user and password and selects the
+// database named by its path, if any.
+func (r *redisSink) connect() error {
+	conn, err := dial(r.url, "6379")
+	if err != nil {
+		return err
+	}
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+	if password, ok := r.url.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if user := r.url.User.Username(); user != "" {
+			args = []string{"AUTH", user, password}
+		}
+		if err := r.command(args...); err != nil {
+			r.close()
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if db := strings.Trim(r.url.Path, "/"); db != "" {
+		if err := r.command("SELECT", db); err != nil {
+			r.close()
+			return fmt.Errorf("select %s: %w", db, err)
+		}
+	}
+	return nil
+}
+
+// command sends args as a RESP array and reads the reply, which must not be
+// an error.
+func (r *redisSink) command(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_ = r.conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := r.conn.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case strings.HasPrefix(line, "-"):
+		return errors.New(strings.TrimPrefix(line, "-"))
+	case strings.HasPrefix(line, "$"):
+		// A bulk string reply; skip its body.
+		if n, err := strconv.Atoi(line[1:]); err == nil && n >= 0 {
+			_, err = r.reader.Discard(n + 2)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *redisSink) close() {
+	if r.conn == nil {
+		return
+	}
+	r.conn.Close()
+	r.conn, r.reader = nil, nil
+}
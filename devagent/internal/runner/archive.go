@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// logName matches run, step and heal logs, including rotated segments.
+var logName = regexp.MustCompile(`\.log(\.\d+)?$`)
+
+// archiveRun gzips the logs and copied outputs in the run directory that
+// are larger than the workflow's archive.compress_over, pointing the
+// summary's step logs at the compressed files, and records the SHA-256
+// checksum of every file in the run directory except summary.json and
+// checkpoint.json. Problems are reported to w and never fail the run.
+func archiveRun(w io.Writer, wf *dsl.Workflow, summary *Summary) {
+	runDir := summary.RunDir
+	if limit := wf.Archive.CompressBytes(); limit > 0 {
+		copied := make(map[string]bool)
+		if wf.Outputs != nil {
+			for _, candidate := range wf.Outputs.CopyIfExists {
+				copied[filepath.Base(strings.TrimSpace(candidate))] = true
+			}
+		}
+		entries, err := os.ReadDir(runDir)
+		if err != nil {
+			fmt.Fprintf(w, "archive failed: %v\n", err)
+			return
+		}
+		renamed := make(map[string]string)
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || (!logName.MatchString(name) && !copied[name]) {
+				continue
+			}
+			if info, err := entry.Info(); err != nil || info.Size() <= limit {
+				continue
+			}
+			if err := compressFile(filepath.Join(runDir, name)); err != nil {
+				fmt.Fprintf(w, "compress %s failed: %v\n", name, err)
+				continue
+			}
+			renamed[name] = name + ".gz"
+		}
+		for _, steps := range [][]StepSummary{summary.Steps, summary.OnCancel, summary.Heal} {
+			for i := range steps {
+				if gz, ok := renamed[steps[i].Log]; ok {
+					steps[i].Log = gz
+				}
+			}
+		}
+	}
+
+	checksums := make(map[string]string)
+	err := filepath.WalkDir(runDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "summary.json" || rel == checkpointFile {
+			return nil
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		checksums[rel] = sum
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(w, "checksums failed: %v\n", err)
+		return
+	}
+	summary.Checksums = checksums
+}
+
+// compressFile replaces path with path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readRunFile reads a file from a run directory, decompressing it when
+// its name ends in .gz.
+func readRunFile(path string) ([]byte, error) {
+	f, err := openRunFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// OpenRunLog opens the log of the run in runDir, decompressing it when the
+// run was archived with the log compressed.
+func OpenRunLog(runDir string) (io.ReadCloser, error) {
+	path := filepath.Join(runDir, "run.log")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		path += ".gz"
+	}
+	return openRunFile(path)
+}
+
+// openRunFile opens a file from a run directory, decompressing it when its
+// name ends in .gz.
+func openRunFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{zr, f}, nil
+}
+
+// gzipFile reads a compressed file, closing the file with the reader.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunCompressesAndChecksumsFiles(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "report", Repo: repo,
+		Steps: []dsl.Step{
+			{Run: "head -c 4096 /dev/zero | tr '\\0' x > big.txt; echo small > small.txt; head -c 3000 /dev/zero | tr '\\0' y"},
+		},
+		Outputs: &dsl.Outputs{CopyIfExists: []string{"big.txt", "small.txt"}},
+		Archive: &dsl.Archive{CompressOver: "2KB"},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Steps[0].Log != "step-1.log.gz" {
+		t.Fatalf("expected the step log to point at the compressed file, got %q", summary.Steps[0].Log)
+	}
+	for _, name := range []string{"big.txt.gz", "step-1.log.gz", "run.log.gz", "small.txt"} {
+		if _, err := os.Stat(filepath.Join(summary.RunDir, name)); err != nil {
+			t.Fatalf("expected %s in the run directory: %v", name, err)
+		}
+	}
+	data, err := readRunFile(filepath.Join(summary.RunDir, "big.txt.gz"))
+	if err != nil || string(data) != strings.Repeat("x", 4096) {
+		t.Fatalf("compressed output does not round-trip: %v", err)
+	}
+	log, err := OpenRunLog(summary.RunDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(log)
+	log.Close()
+	if err != nil || !strings.Contains(string(data), strings.Repeat("y", 3000)) {
+		t.Fatalf("expected OpenRunLog to read the compressed run log: %v", err)
+	}
+
+	want, _ := fileSHA256(filepath.Join(summary.RunDir, "big.txt.gz"))
+	if summary.Checksums["big.txt.gz"] != want || summary.Checksums["workflow.yml"] == "" {
+		t.Fatalf("unexpected checksums %v", summary.Checksums)
+	}
+	if _, ok := summary.Checksums["summary.json"]; ok {
+		t.Fatal("summary.json cannot checksum itself")
+	}
+	loaded, err := LoadSummary(summary.RunDir)
+	if err != nil || loaded.Checksums["small.txt"] == "" {
+		t.Fatalf("expected checksums in summary.json, got %v %v", loaded, err)
+	}
+}
@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// assertLabel describes an assert step's checks for the summary and log.
+func assertLabel(a *dsl.Assert) string {
+	var checks []string
+	if a.File != "" {
+		switch {
+		case a.Exists != nil && !*a.Exists:
+			checks = append(checks, a.File+" is absent")
+		case a.Contains != "":
+			checks = append(checks, fmt.Sprintf("%s contains /%s/", a.File, a.Contains))
+		default:
+			checks = append(checks, a.File+" exists")
+		}
+		if a.JSONPath != "" {
+			checks = append(checks, fmt.Sprintf("%s %s == %s", a.File, a.JSONPath, a.Equals))
+		}
+	}
+	if a.Command != "" {
+		check := "`" + a.Command + "` succeeds"
+		if a.Matches != "" {
+			check = fmt.Sprintf("`%s` matches /%s/", a.Command, a.Matches)
+		}
+		checks = append(checks, check)
+	}
+	return "assert " + strings.Join(checks, ", ")
+}
+
+// runAssert evaluates an assert step in workdir, logging each check to w and
+// logPath. command runs an assertion command in the step shell, writing its
+// output to the given writer. A check that does not hold gives exit code 1.
+func runAssert(ctx context.Context, a *dsl.Assert, workdir string, extra extraEnv, w io.Writer, logPath string, logs *dsl.Logs, command func(context.Context, string, io.Writer) (int, Usage, error)) (int, Usage, error) {
+	stepLog, err := openLog(logPath, logs)
+	if err != nil {
+		return 0, Usage{}, err
+	}
+	defer stepLog.Close()
+	out := newRedactingWriter(io.MultiWriter(w, stepLog), extra.secrets...)
+	defer out.Flush()
+
+	// fail logs a check that does not hold and returns exit code 1.
+	fail := func(format string, args ...any) int {
+		msg := fmt.Sprintf(format, args...)
+		if a.Message != "" {
+			msg = a.Message + ": " + msg
+		}
+		fmt.Fprintf(out, "assertion failed: %s\n", msg)
+		return 1
+	}
+
+	if a.File != "" {
+		path := a.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workdir, path)
+		}
+		data, err := os.ReadFile(path)
+		exists := !errors.Is(err, os.ErrNotExist)
+		if a.Exists != nil && !*a.Exists {
+			if exists {
+				return fail("%s exists", a.File), Usage{}, nil
+			}
+			fmt.Fprintf(out, "ok: %s is absent\n", a.File)
+		} else {
+			if err != nil {
+				return fail("cannot read %s: %v", a.File, err), Usage{}, nil
+			}
+			fmt.Fprintf(out, "ok: %s exists\n", a.File)
+		}
+		if a.Contains != "" {
+			re, err := regexp.Compile("(?m)" + a.Contains)
+			if err != nil {
+				return fail("contains: %v", err), Usage{}, nil
+			}
+			if !re.Match(data) {
+				return fail("%s does not contain /%s/", a.File, a.Contains), Usage{}, nil
+			}
+			fmt.Fprintf(out, "ok: %s contains /%s/\n", a.File, a.Contains)
+		}
+		if a.JSONPath != "" {
+			var doc any
+			if err := json.Unmarshal(data, &doc); err != nil {
+				return fail("%s is not JSON: %v", a.File, err), Usage{}, nil
+			}
+			value, err := lookupJSONPath(doc, a.JSONPath)
+			if err != nil {
+				return fail("%s: %v", a.File, err), Usage{}, nil
+			}
+			got, ok := value.(string)
+			if !ok {
+				encoded, _ := json.Marshal(value)
+				got = string(encoded)
+			}
+			if got != a.Equals {
+				return fail("%s %s is %s, expected %s", a.File, a.JSONPath, got, a.Equals), Usage{}, nil
+			}
+			fmt.Fprintf(out, "ok: %s %s == %s\n", a.File, a.JSONPath, a.Equals)
+		}
+	}
+
+	if a.Command != "" {
+		fmt.Fprintf(out, "$ %s\n", a.Command)
+		var output bytes.Buffer
+		exitCode, usage, err := command(ctx, a.Command, io.MultiWriter(out, &output))
+		if err != nil {
+			return 0, usage, err
+		}
+		if exitCode != 0 {
+			return fail("`%s` exited with %d", a.Command, exitCode), usage, nil
+		}
+		if a.Matches != "" {
+			re, err := regexp.Compile("(?m)" + a.Matches)
+			if err != nil {
+				return fail("matches: %v", err), usage, nil
+			}
+			if !re.Match(output.Bytes()) {
+				return fail("output of `%s` does not match /%s/", a.Command, a.Matches), usage, nil
+			}
+		}
+		fmt.Fprintf(out, "ok: %s\n", strings.TrimPrefix(assertLabel(&dsl.Assert{Command: a.Command, Matches: a.Matches}), "assert "))
+		return 0, usage, nil
+	}
+	return 0, Usage{}, nil
+}
+
+// lookupJSONPath returns the value at path in doc. Paths are dot-separated
+// keys with optional [n] indexes, e.g. .results[0].status; a leading dot is
+// optional and a bare . selects the whole document.
+func lookupJSONPath(doc any, path string) (any, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), ".")
+	value := doc
+	for rest != "" {
+		key, index := "", -1
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("json_path %s: unterminated [", path)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("json_path %s: bad index %q", path, rest[1:end])
+			}
+			index, rest = n, strings.TrimPrefix(rest[end+1:], ".")
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], strings.TrimPrefix(rest[end:], ".")
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			if index >= 0 {
+				return nil, fmt.Errorf("json_path %s: [%d] applied to an object", path, index)
+			}
+			next, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("json_path %s: no key %q", path, key)
+			}
+			value = next
+		case []any:
+			if index < 0 {
+				// Numeric keys index arrays too, e.g. results.0.status.
+				n, err := strconv.Atoi(key)
+				if err != nil {
+					return nil, fmt.Errorf("json_path %s: key %q applied to an array", path, key)
+				}
+				index = n
+			}
+			if index >= len(v) {
+				return nil, fmt.Errorf("json_path %s: index %d out of range (%d items)", path, index, len(v))
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("json_path %s: cannot descend into %v", path, value)
+		}
+	}
+	return value, nil
+}
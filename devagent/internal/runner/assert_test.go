@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	doc := map[string]any{"results": []any{map[string]any{"status": "passed", "count": 3.0}}}
+	for path, want := range map[string]any{".results[0].status": "passed", "results.0.count": 3.0} {
+		got, err := lookupJSONPath(doc, path)
+		if err != nil || got != want {
+			t.Fatalf("%s: got %v, %v; want %v", path, got, err, want)
+		}
+	}
+	for _, path := range []string{".results[1]", ".missing", ".results[0].status.x", ".results[x]"} {
+		if _, err := lookupJSONPath(doc, path); err == nil {
+			t.Fatalf("expected %s to fail", path)
+		}
+	}
+}
+
+func TestRunAssertStep(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "report.json"), []byte(`{"summary": {"failed": 0, "status": "green"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	absent := false
+	wf := &dsl.Workflow{Name: "verify", Repo: repo,
+		Steps: []dsl.Step{
+			{Assert: &dsl.Assert{File: "report.json", Contains: `"status":\s*"green"`, JSONPath: ".summary.failed", Equals: "0"}},
+			{Assert: &dsl.Assert{File: "core.dump", Exists: &absent}},
+			{Assert: &dsl.Assert{Command: "echo version 1.4.2", Matches: `^version 1\.4`}},
+		},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" {
+		t.Fatalf("expected the assertions to hold, got %s %+v", summary.Status, summary.Steps)
+	}
+
+	wf.Steps = []dsl.Step{{Assert: &dsl.Assert{File: "report.json", JSONPath: ".summary.status", Equals: "red", Message: "build is not red"}}}
+	summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "failed" || summary.Steps[0].ExitCode != 1 {
+		t.Fatalf("expected the assertion to fail, got %s %+v", summary.Status, summary.Steps)
+	}
+	if tail := summary.Steps[0].Tail; !strings.Contains(strings.Join(tail, "\n"), "assertion failed: build is not red: report.json .summary.status is green, expected red") {
+		t.Fatalf("expected a clear failure message, got %q", tail)
+	}
+
+	wf.Steps = []dsl.Step{{Assert: &dsl.Assert{Command: "sudo true"}}}
+	var policyErr *PolicyError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); !errors.As(err, &policyErr) {
+		t.Fatalf("expected assert commands to be checked against the policy, got %v", err)
+	}
+}
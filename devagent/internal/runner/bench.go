@@ -0,0 +1,246 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// BenchResult is one measurement of a benchmark, e.g. BenchmarkParse in
+// ns/op. Repeated measurements in a run (go test -count) are averaged.
+type BenchResult struct {
+	Name  string  `json:"name"`
+	Unit  string  `json:"unit,omitempty"`
+	Value float64 `json:"value"`
+}
+
+// BenchChange compares a benchmark result with its baseline.
+type BenchChange struct {
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit,omitempty"`
+	Value    float64 `json:"value"`
+	Baseline float64 `json:"baseline"`
+	// ChangePct is the change from the baseline in percent.
+	ChangePct float64 `json:"change_pct"`
+	// Regression is set when the change is worse than the threshold.
+	Regression bool `json:"regression,omitempty"`
+}
+
+// Key identifies a benchmark measurement across runs.
+func (r BenchResult) Key() string {
+	if r.Unit == "" {
+		return r.Name
+	}
+	return r.Name + " " + r.Unit
+}
+
+// higherIsBetter reports whether larger values of unit are improvements,
+// as for throughput units such as MB/s.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+// benchLine matches a `go test -bench` result line; the -N GOMAXPROCS
+// suffix is dropped so names stay stable across machines.
+var benchLine = regexp.MustCompile(`^(Benchmark\S+?)(?:-\d+)?\s+\d+\s+(.+)$`)
+
+// collectBenchmarks parses the files matched by patterns, relative to
+// workdir: `go test -bench` output (plain or -json), a JSON list of
+// {"name", "unit", "value"} objects, or a JSON object mapping names to
+// numbers. Problems are reported to w and never fail the run.
+func collectBenchmarks(w io.Writer, workdir string, patterns []string) []BenchResult {
+	sums := make(map[string]*BenchResult)
+	counts := make(map[string]int)
+	var order []string
+	add := func(r BenchResult) {
+		key := r.Key()
+		if sums[key] == nil {
+			sums[key] = &BenchResult{Name: r.Name, Unit: r.Unit}
+			order = append(order, key)
+		}
+		sums[key].Value += r.Value
+		counts[key]++
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(workdir, pattern))
+		if err != nil {
+			fmt.Fprintf(w, "bench %s: %v\n", pattern, err)
+			continue
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			data, err := readRunFile(path)
+			var results []BenchResult
+			if err == nil {
+				results, err = parseBenchmarks(data)
+			}
+			if err != nil {
+				fmt.Fprintf(w, "bench %s: %v\n", relOrBase(workdir, path), err)
+				continue
+			}
+			for _, r := range results {
+				add(r)
+			}
+		}
+	}
+	var out []BenchResult
+	for _, key := range order {
+		r := *sums[key]
+		r.Value /= float64(counts[key])
+		out = append(out, r)
+	}
+	return out
+}
+
+func parseBenchmarks(data []byte) ([]BenchResult, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var list []BenchResult
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	case bytes.HasPrefix(trimmed, []byte("{")) && !bytes.Contains(trimmed, []byte(`"Action"`)):
+		var values map[string]float64
+		if err := json.Unmarshal(trimmed, &values); err != nil {
+			return nil, err
+		}
+		var out []BenchResult
+		for name, value := range values {
+			out = append(out, BenchResult{Name: name, Value: value})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		return out, nil
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		// go test -json: the benchmark lines are in the output events.
+		var text strings.Builder
+		for _, line := range bytes.Split(trimmed, []byte("\n")) {
+			var event struct {
+				Action string
+				Output string
+			}
+			if json.Unmarshal(line, &event) == nil && event.Action == "output" {
+				text.WriteString(event.Output)
+			}
+		}
+		trimmed = []byte(text.String())
+	}
+	var out []BenchResult
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		m := benchLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		fields := strings.Fields(m[2])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			out = append(out, BenchResult{Name: m[1], Unit: fields[i+1], Value: value})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no benchmark results found")
+	}
+	return out, nil
+}
+
+// CompareBench compares current with the median of each benchmark's values
+// in previous, a list of earlier runs' results. Changes worse than
+// thresholdPct percent are marked as regressions; benchmarks without a
+// baseline are left out.
+func CompareBench(current []BenchResult, previous [][]BenchResult, thresholdPct float64) []BenchChange {
+	history := make(map[string][]float64)
+	for _, run := range previous {
+		for _, r := range run {
+			history[r.Key()] = append(history[r.Key()], r.Value)
+		}
+	}
+	var changes []BenchChange
+	for _, r := range current {
+		values := history[r.Key()]
+		if len(values) == 0 {
+			continue
+		}
+		baseline := median(values)
+		change := BenchChange{Name: r.Name, Unit: r.Unit, Value: r.Value, Baseline: baseline}
+		if baseline != 0 {
+			change.ChangePct = (r.Value - baseline) / baseline * 100
+		}
+		worse := change.ChangePct
+		if higherIsBetter(r.Unit) {
+			worse = -worse
+		}
+		change.Regression = worse > thresholdPct
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// checkBenchmarks records the run's benchmark results in the summary and
+// compares them with the rolling baseline from baseline, if set. It returns
+// whether a regression was found.
+func checkBenchmarks(w io.Writer, bench *dsl.Bench, workdir string, baseline func(n int) ([][]BenchResult, error), summary *Summary) bool {
+	summary.Benchmarks = collectBenchmarks(w, workdir, bench.Files)
+	if len(summary.Benchmarks) == 0 || baseline == nil {
+		return false
+	}
+	previous, err := baseline(bench.BaselineRuns())
+	if err != nil {
+		fmt.Fprintf(w, "bench baseline: %v\n", err)
+		return false
+	}
+	regressed := false
+	for _, change := range CompareBench(summary.Benchmarks, previous, bench.Threshold()) {
+		if change.Regression {
+			summary.BenchRegressions = append(summary.BenchRegressions, change)
+			fmt.Fprintf(w, "bench regression: %s\n", change)
+			regressed = true
+		}
+	}
+	return regressed
+}
+
+// String describes the change, e.g. "BenchmarkParse ns/op 1200 -> 1500
+// (+25.0%)".
+func (c BenchChange) String() string {
+	name := c.Name
+	if c.Unit != "" {
+		name += " " + c.Unit
+	}
+	return fmt.Sprintf("%s %s -> %s (%+.1f%%)", name, formatBenchValue(c.Baseline), formatBenchValue(c.Value), c.ChangePct)
+}
+
+func formatBenchValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
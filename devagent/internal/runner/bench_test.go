@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestParseBenchmarks(t *testing.T) {
+	goBench := `goos: linux
+BenchmarkParse-8     	  100000	      1200 ns/op	     256 B/op	       4 allocs/op
+BenchmarkParse-8     	  100000	      1000 ns/op	     256 B/op	       4 allocs/op
+BenchmarkCopy-8      	    5000	    300000 ns/op	 400.50 MB/s
+PASS`
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "bench.txt"), []byte(goBench), 0o644)
+	os.WriteFile(filepath.Join(dir, "custom.json"), []byte(`[{"name": "startup", "unit": "ms", "value": 42}]`), 0o644)
+	os.WriteFile(filepath.Join(dir, "map.json"), []byte(`{"p99_latency": 7.5}`), 0o644)
+
+	var log strings.Builder
+	results := collectBenchmarks(&log, dir, []string{"bench.txt", "*.json"})
+	got := make(map[string]float64)
+	for _, r := range results {
+		got[r.Key()] = r.Value
+	}
+	want := map[string]float64{
+		"BenchmarkParse ns/op":     1100,
+		"BenchmarkParse B/op":      256,
+		"BenchmarkParse allocs/op": 4,
+		"BenchmarkCopy ns/op":      300000,
+		"BenchmarkCopy MB/s":       400.5,
+		"startup ms":               42,
+		"p99_latency":              7.5,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected results %v (log %q)", got, log.String())
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestCompareBench(t *testing.T) {
+	previous := [][]BenchResult{
+		{{Name: "BenchmarkParse", Unit: "ns/op", Value: 1000}, {Name: "BenchmarkCopy", Unit: "MB/s", Value: 400}},
+		{{Name: "BenchmarkParse", Unit: "ns/op", Value: 5000}, {Name: "BenchmarkCopy", Unit: "MB/s", Value: 400}},
+		{{Name: "BenchmarkParse", Unit: "ns/op", Value: 1000}},
+	}
+	current := []BenchResult{
+		{Name: "BenchmarkParse", Unit: "ns/op", Value: 1150},
+		{Name: "BenchmarkCopy", Unit: "MB/s", Value: 300},
+		{Name: "BenchmarkNew", Unit: "ns/op", Value: 1},
+	}
+	changes := CompareBench(current, previous, 10)
+	if len(changes) != 2 {
+		t.Fatalf("benchmarks without a baseline should be left out, got %+v", changes)
+	}
+	// The median ignores the 5000ns outlier.
+	if changes[0].Baseline != 1000 || !changes[0].Regression {
+		t.Fatalf("expected a 15%% ns/op regression, got %+v", changes[0])
+	}
+	if !changes[1].Regression || changes[1].ChangePct != -25 {
+		t.Fatalf("lower throughput should be a regression, got %+v", changes[1])
+	}
+}
+
+func TestRunFlagsBenchRegression(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "bench", Repo: repo,
+		Steps: []dsl.Step{{Run: `echo "{\"parse\": $(cat value)}" > bench.json`}},
+		Bench: &dsl.Bench{Files: []string{"bench.json"}, FailOnRegression: true},
+	}
+	var history [][]BenchResult
+	baseline := func(n int) ([][]BenchResult, error) {
+		if n < len(history) {
+			return history[:n], nil
+		}
+		return history, nil
+	}
+	for i, value := range []string{"100", "104", "150"} {
+		if err := os.WriteFile(filepath.Join(repo, "value"), []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default(), BenchBaseline: baseline})
+		if err != nil {
+			t.Fatal(err)
+		}
+		history = append([][]BenchResult{summary.Benchmarks}, history...)
+		if i < 2 {
+			if summary.Status != "success" || len(summary.BenchRegressions) != 0 {
+				t.Fatalf("run %d: expected success, got %s %+v", i+1, summary.Status, summary.BenchRegressions)
+			}
+			continue
+		}
+		if summary.Status != "failed" || len(summary.BenchRegressions) != 1 {
+			t.Fatalf("expected the regression to fail the run, got %s %+v", summary.Status, summary.BenchRegressions)
+		}
+		if tail := summary.FailureTail(); !strings.Contains(tail, "bench regression: parse 102 -> 150") {
+			t.Fatalf("unexpected failure tail %q", tail)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+)
+
+// CacheResult records what happened to one cache entry during a run.
+type CacheResult struct {
+	Key   string `json:"key"`
+	Hit   bool   `json:"hit"`
+	Saved bool   `json:"saved,omitempty"`
+	// Error explains why the entry was skipped.
+	Error string `json:"error,omitempty"`
+}
+
+var hashFilesPattern = regexp.MustCompile(`\$\{\{\s*hashFiles\(([^)]*)\)\s*\}\}`)
+
+// cacheKey expands `${{ hashFiles('pattern', ...) }}` in key to the SHA-256
+// of the matching files under repo.
+func cacheKey(key, repo string) (string, error) {
+	var err error
+	expanded := hashFilesPattern.ReplaceAllStringFunc(key, func(ref string) string {
+		args := hashFilesPattern.FindStringSubmatch(ref)[1]
+		var patterns []string
+		for _, arg := range strings.Split(args, ",") {
+			if pattern := strings.Trim(strings.TrimSpace(arg), `'"`); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+		sum, matched, hashErr := hashFiles(repo, patterns)
+		if hashErr == nil && matched == 0 {
+			hashErr = fmt.Errorf("hashFiles(%s) matched no files", args)
+		}
+		if hashErr != nil {
+			err = hashErr
+			return ref
+		}
+		return sum
+	})
+	return strings.TrimSpace(expanded), err
+}
+
+// cacheRoot returns the step cache directory.
+func cacheRoot() (string, error) {
+	return paths.CacheDir()
+}
+
+// RenameCache moves a job's step caches to its new name.
+func RenameCache(oldName, newName string) error {
+	root, err := cacheRoot()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filepath.Join(root, filepath.Base(oldName)), filepath.Join(root, filepath.Base(newName)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// cacheArchive is the archive holding entry index of job under key. Only
+// the archive for the latest key of each entry is kept.
+func cacheArchive(root, job string, index int, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(root, filepath.Base(job), fmt.Sprintf("%d-%s.tar.gz", index, hex.EncodeToString(sum[:8])))
+}
+
+// cachePaths resolves an entry's paths against repo, following symlinks.
+// A path must stay in repo or, when absolute or starting with ~, in the
+// cache directory, as Workflow.Validate requires.
+func cachePaths(c dsl.Cache, repo string) ([]string, error) {
+	out := make([]string, 0, len(c.Paths))
+	for _, path := range c.Paths {
+		path = strings.TrimSpace(path)
+		dir := repo
+		if strings.HasPrefix(path, "~") || filepath.IsAbs(path) {
+			expanded, err := paths.Expand(path)
+			if err != nil {
+				return nil, err
+			}
+			if dir, err = cacheRoot(); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, err
+			}
+			path = expanded
+		}
+		resolved, err := confine(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		if real, err := filepath.EvalSymlinks(dir); err != nil || resolved == real {
+			return nil, fmt.Errorf("cache path %s would replace a whole tree", path)
+		}
+		out = append(out, resolved)
+	}
+	return out, nil
+}
+
+// restoreCaches replaces each cached path with its saved copy when an
+// archive exists for the entry's current key. Problems are reported to w
+// and in the results; they never fail the run.
+func restoreCaches(w io.Writer, wf *dsl.Workflow, repo string) []CacheResult {
+	if len(wf.Cache) == 0 {
+		return nil
+	}
+	root, err := cacheRoot()
+	results := make([]CacheResult, len(wf.Cache))
+	for i, c := range wf.Cache {
+		result := &results[i]
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		key, keyErr := cacheKey(c.Key, repo)
+		result.Key = key
+		if keyErr != nil {
+			result.Error = keyErr.Error()
+			fmt.Fprintf(w, "cache %s skipped: %v\n", key, keyErr)
+			continue
+		}
+		paths, pathErr := cachePaths(c, repo)
+		if pathErr != nil {
+			result.Error = pathErr.Error()
+			continue
+		}
+		archive := cacheArchive(root, wf.Name, i, key)
+		if _, statErr := os.Stat(archive); statErr != nil {
+			fmt.Fprintf(w, "cache miss for %s\n", key)
+			continue
+		}
+		if restoreErr := extractArchive(archive, paths); restoreErr != nil {
+			result.Error = restoreErr.Error()
+			fmt.Fprintf(w, "cache restore for %s failed: %v\n", key, restoreErr)
+			continue
+		}
+		result.Hit = true
+		fmt.Fprintf(w, "cache hit for %s: restored %s\n", key, strings.Join(c.Paths, ", "))
+	}
+	return results
+}
+
+// saveCaches archives the paths of every entry that missed, replacing the
+// entry's archive for any previous key.
+func saveCaches(w io.Writer, wf *dsl.Workflow, repo string, results []CacheResult) {
+	root, err := cacheRoot()
+	if err != nil {
+		return
+	}
+	for i, c := range wf.Cache {
+		if i >= len(results) || results[i].Hit || results[i].Error != "" {
+			continue
+		}
+		paths, err := cachePaths(c, repo)
+		if err != nil {
+			continue
+		}
+		archive := cacheArchive(root, wf.Name, i, results[i].Key)
+		if err := writeArchive(archive, paths); err != nil {
+			results[i].Error = err.Error()
+			fmt.Fprintf(w, "cache save for %s failed: %v\n", results[i].Key, err)
+			continue
+		}
+		results[i].Saved = true
+		fmt.Fprintf(w, "cache saved for %s\n", results[i].Key)
+		stale, _ := filepath.Glob(filepath.Join(filepath.Dir(archive), fmt.Sprintf("%d-*.tar.gz", i)))
+		for _, old := range stale {
+			if old != archive {
+				_ = os.Remove(old)
+			}
+		}
+	}
+}
+
+// writeArchive stores paths in a gzipped tar at dst, each under its index
+// so it can be restored to the same place. Missing paths are skipped.
+func writeArchive(dst string, paths []string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".cache-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	for i, root := range paths {
+		if _, err := os.Lstat(root); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			link := ""
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			} else if !info.Mode().IsRegular() && !info.IsDir() {
+				return nil
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			hdr.Name = strconv.Itoa(i) + "/" + filepath.ToSlash(rel)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// extractArchive restores an archive written by writeArchive, replacing
+// whatever is at each path.
+func extractArchive(src string, paths []string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	cleared := make(map[int]bool)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		prefix, rel, _ := strings.Cut(hdr.Name, "/")
+		index, err := strconv.Atoi(prefix)
+		if err != nil || index < 0 || index >= len(paths) {
+			return fmt.Errorf("unexpected cache entry %q", hdr.Name)
+		}
+		rel = filepath.Clean(filepath.FromSlash(rel))
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+			return fmt.Errorf("unsafe cache entry %q", hdr.Name)
+		}
+		if !cleared[index] {
+			if err := os.RemoveAll(paths[index]); err != nil {
+				return err
+			}
+			cleared[index] = true
+		}
+		target := filepath.Join(paths[index], rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode.Perm()|0o700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// within reports whether path is dir or inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
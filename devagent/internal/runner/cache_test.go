@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestCacheKeyHashesFiles(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "package-lock.json"), []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := cacheKey("npm-${{ hashFiles('package-lock.json') }}", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first, "npm-") || len(first) != len("npm-")+64 {
+		t.Fatalf("unexpected key %q", first)
+	}
+	again, _ := cacheKey("npm-${{ hashFiles('package-lock.json') }}", repo)
+	if again != first {
+		t.Fatalf("key should be stable, got %q and %q", first, again)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "package-lock.json"), []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := cacheKey("npm-${{ hashFiles('package-lock.json') }}", repo); changed == first {
+		t.Fatalf("key should change with the lockfile")
+	}
+	if _, err := cacheKey("${{ hashFiles('missing.lock') }}", repo); err == nil {
+		t.Fatalf("expected an error when no file matches")
+	}
+}
+
+func TestRunRestoresAndSavesCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "deps.lock"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{
+		Name:  "nightly",
+		Repo:  repo,
+		Cache: []dsl.Cache{{Key: "deps-${{ hashFiles('deps.lock') }}", Paths: []string{"vendor"}}},
+		Steps: []dsl.Step{{Run: "test -f vendor/pkg/installed || { mkdir -p vendor/pkg && echo fresh > vendor/pkg/installed; echo installed >> installs; }"}},
+	}
+
+	summary, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Cache) != 1 || summary.Cache[0].Hit || !summary.Cache[0].Saved {
+		t.Fatalf("first run should miss and save, got %+v", summary.Cache)
+	}
+
+	if err := os.RemoveAll(filepath.Join(repo, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Cache[0].Hit || summary.Cache[0].Saved {
+		t.Fatalf("second run should hit, got %+v", summary.Cache)
+	}
+	installs, _ := os.ReadFile(filepath.Join(repo, "installs"))
+	if strings.Count(string(installs), "installed") != 1 {
+		t.Fatalf("restored cache should skip the install, got %q", installs)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "deps.lock"), []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Cache[0].Hit || !summary.Cache[0].Saved {
+		t.Fatalf("a new lockfile should miss and save, got %+v", summary.Cache)
+	}
+	root, err := cacheRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archives, _ := filepath.Glob(filepath.Join(root, "nightly", "0-*.tar.gz"))
+	if len(archives) != 1 {
+		t.Fatalf("expected the stale archive to be pruned, got %v", archives)
+	}
+}
+
+func TestCachePathsStayInRepoOrCacheDir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(repo, "link")); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cacheRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := cachePaths(dsl.Cache{Paths: []string{"vendor", filepath.Join(root, "go-mod")}}, repo)
+	if err != nil || len(paths) != 2 || paths[0] != filepath.Join(repo, "vendor") || paths[1] != filepath.Join(root, "go-mod") {
+		t.Fatalf("unexpected paths %v (%v)", paths, err)
+	}
+	for _, path := range []string{"link/deps", "../deps", outside, root} {
+		if paths, err := cachePaths(dsl.Cache{Paths: []string{path}}, repo); err == nil {
+			t.Errorf("cache path %s: expected an error, got %v", path, paths)
+		}
+	}
+}
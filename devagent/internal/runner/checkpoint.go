@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// checkpointFile records how far a run got, so a later run can resume
+// after the steps that already succeeded.
+const checkpointFile = "checkpoint.json"
+
+// checkpoint is the content of checkpointFile. Completed counts the leading
+// steps that finished successfully (or were skipped); Outputs is how much
+// of the outputs file they wrote.
+type checkpoint struct {
+	Name         string `json:"name"`
+	WorkflowHash string `json:"workflow_hash"`
+	Completed    int    `json:"completed"`
+	Outputs      int64  `json:"outputs,omitempty"`
+}
+
+func writeCheckpoint(runDir string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(runDir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(runDir, checkpointFile))
+}
+
+func loadCheckpoint(runDir string) (checkpoint, error) {
+	var cp checkpoint
+	data, err := os.ReadFile(filepath.Join(runDir, checkpointFile))
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("parse checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// restoreOutputs copies the outputs the completed steps of cp wrote in
+// runDir to outputsPath, so the steps after them read the same outputs as
+// in the run being resumed.
+func restoreOutputs(runDir string, cp checkpoint, outputsPath string) error {
+	if cp.Outputs == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(runDir, outputsFileName))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < cp.Outputs {
+		return fmt.Errorf("%s is shorter than its checkpoint", outputsFileName)
+	}
+	return os.WriteFile(outputsPath, data[:cp.Outputs], 0o644)
+}
+
+// ErrNothingToResume is returned by ResumePoint when the job's latest run
+// succeeded or no run left a checkpoint.
+var ErrNothingToResume = errors.New("nothing to resume")
+
+// ResumePoint returns the run directory of job's latest run under repo when
+// that run failed, was cancelled or was interrupted, so it can be passed as
+// Options.ResumeFrom.
+func ResumePoint(repo, job string) (string, error) {
+	runsDir := filepath.Join(repo, "devagent_runs")
+	entries, err := os.ReadDir(runsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(runsDir, entry.Name())
+		cp, err := loadCheckpoint(dir)
+		if err != nil || cp.Name != job {
+			continue
+		}
+		if summary, err := LoadSummary(dir); err == nil && summary.Status == "success" {
+			return "", fmt.Errorf("%w: the last run of %s succeeded", ErrNothingToResume, job)
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("%w: no checkpointed run of %s", ErrNothingToResume, job)
+}
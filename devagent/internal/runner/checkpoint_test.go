@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestRunResumesFromFirstIncompleteStep(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "pipeline", Repo: repo, Steps: []dsl.Step{
+		{Run: "echo one >> ran"},
+		{Run: "echo two >> ran; test -f fixed"},
+		{Run: "echo three >> ran"},
+	}}
+	source := []byte("name: pipeline\n")
+
+	failed, err := Run(context.Background(), Options{Workflow: wf, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != "failed" {
+		t.Fatalf("expected the first run to fail, got %s", failed.Status)
+	}
+	dir, err := ResumePoint(repo, "pipeline")
+	if err != nil || dir != failed.RunDir {
+		t.Fatalf("expected to resume from %s, got %q (%v)", failed.RunDir, dir, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "fixed"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := Run(context.Background(), Options{Workflow: wf, Source: source, ResumeFrom: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Status != "success" || !resumed.Steps[0].Resumed || resumed.Steps[1].Resumed {
+		t.Fatalf("expected only the first step to be resumed, got %+v", resumed.Steps)
+	}
+	ran, _ := os.ReadFile(filepath.Join(repo, "ran"))
+	if got := strings.Fields(string(ran)); strings.Join(got, " ") != "one two two three" {
+		t.Fatalf("unexpected step executions %v", got)
+	}
+	if _, err := ResumePoint(repo, "pipeline"); !errors.Is(err, ErrNothingToResume) {
+		t.Fatalf("a successful last run leaves nothing to resume, got %v", err)
+	}
+
+	var configErr *ConfigError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Source: []byte("name: changed\n"), ResumeFrom: dir}); !errors.As(err, &configErr) {
+		t.Fatalf("expected a changed workflow to refuse resuming, got %v", err)
+	}
+}
+
+func TestRunResumeRestoresOutputs(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "release", Repo: repo, Steps: []dsl.Step{
+		{Run: `echo version=1.2.3 >> "$DEVAGENT_OUTPUT"`},
+		{Run: `echo partial=yes >> "$DEVAGENT_OUTPUT"; test -f fixed`},
+		{Run: `. "$DEVAGENT_OUTPUT"; echo "$version" > released`},
+	}}
+	source := []byte("name: release\n")
+
+	failed, err := Run(context.Background(), Options{Workflow: wf, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Status != "failed" {
+		t.Fatalf("expected the first run to fail, got %s", failed.Status)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "fixed"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := Run(context.Background(), Options{Workflow: wf, Source: source, ResumeFrom: failed.RunDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Status != "success" || !resumed.Steps[0].Resumed {
+		t.Fatalf("expected the first step to be resumed, got %s %+v", resumed.Status, resumed.Steps)
+	}
+	released, _ := os.ReadFile(filepath.Join(repo, "released"))
+	if strings.TrimSpace(string(released)) != "1.2.3" {
+		t.Fatalf("expected a later step to read the resumed step's output, got %q", released)
+	}
+	if resumed.Outputs["version"] != "1.2.3" || resumed.Outputs["partial"] != "yes" {
+		t.Fatalf("expected the run to publish the resumed step's outputs once, got %v", resumed.Outputs)
+	}
+	data, _ := os.ReadFile(filepath.Join(resumed.RunDir, outputsFileName))
+	if strings.Count(string(data), "partial=yes") != 1 {
+		t.Fatalf("expected only the completed steps' outputs to be restored, got %q", data)
+	}
+}
@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+)
+
+// launchdAgentSocket asks launchd for the SSH agent socket of the login
+// session, for a daemon started without SSH_AUTH_SOCK. It is a variable so
+// tests can stub it.
+var launchdAgentSocket = func() string {
+	if runtime.GOOS != "darwin" {
+		return ""
+	}
+	out, err := exec.Command("launchctl", "getenv", "SSH_AUTH_SOCK").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// credentialEnv returns the variables c adds to every step's environment,
+// taken from base, the values to redact from logs and one line per
+// passthrough for the run log. Only an unusable ssh_auth_sock is an error;
+// an agent that cannot be found is logged so the run goes on without it.
+func credentialEnv(c *dsl.Credentials, base []string) (vars, secrets, notes []string, err error) {
+	if c == nil {
+		return nil, nil, nil, nil
+	}
+	lookup := func(name string) (string, bool) {
+		for _, kv := range base {
+			if key, value, _ := strings.Cut(kv, "="); key == name {
+				return value, true
+			}
+		}
+		return "", false
+	}
+
+	if c.SSHAgent || c.SSHAuthSock != "" {
+		var sock, from string
+		if c.SSHAuthSock != "" {
+			if sock, err = paths.Expand(c.SSHAuthSock); err != nil {
+				return nil, nil, nil, err
+			}
+			if info, err := os.Stat(sock); err != nil || info.Mode()&os.ModeSocket == 0 {
+				return nil, nil, nil, fmt.Errorf("credentials.ssh_auth_sock %s is not a socket", sock)
+			}
+			from = "credentials.ssh_auth_sock"
+		} else if value, _ := lookup("SSH_AUTH_SOCK"); value != "" {
+			sock, from = value, "the environment"
+		} else if sock = launchdAgentSocket(); sock != "" {
+			from = "launchd"
+		}
+		if sock == "" {
+			notes = append(notes, "credentials: no SSH agent found; steps run without SSH_AUTH_SOCK")
+		} else {
+			vars = append(vars, "SSH_AUTH_SOCK="+sock)
+			notes = append(notes, fmt.Sprintf("credentials: passing SSH agent %s from %s", sock, from))
+		}
+	}
+
+	if c.GitHelper != "" {
+		// An empty credential.helper clears the helpers from the user's git
+		// config, so only this one is asked. Entries already set through
+		// GIT_CONFIG_COUNT are kept.
+		n := 0
+		if value, ok := lookup("GIT_CONFIG_COUNT"); ok {
+			n, _ = strconv.Atoi(value)
+		}
+		vars = append(vars,
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+2),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=credential.helper", n),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=", n),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=credential.helper", n+1),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n+1, c.GitHelper),
+		)
+		notes = append(notes, fmt.Sprintf("credentials: git credential helper %q", c.GitHelper))
+	}
+
+	for _, name := range c.Pass {
+		value, ok := lookup(name)
+		if !ok {
+			notes = append(notes, fmt.Sprintf("credentials: %s is not set, not passed", name))
+			continue
+		}
+		vars = append(vars, name+"="+value)
+		if value != "" {
+			secrets = append(secrets, value)
+		}
+		notes = append(notes, fmt.Sprintf("credentials: passing %s", name))
+	}
+	return vars, secrets, notes, nil
+}
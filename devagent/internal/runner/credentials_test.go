@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunPassesCredentialsOnlyWhenOptedIn(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir.
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	t.Setenv("GH_TOKEN", "ghp_hunter22")
+
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "pull", Repo: repo,
+		Steps: []dsl.Step{{Run: `printf '%s|%s' "$SSH_AUTH_SOCK" "$GH_TOKEN" > seen; git config --get-all credential.helper > helper; echo "token $GH_TOKEN"`}},
+	}
+	run := func() (string, string, string) {
+		t.Helper()
+		summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen, _ := os.ReadFile(filepath.Join(repo, "seen"))
+		helper, _ := os.ReadFile(filepath.Join(repo, "helper"))
+		log, _ := os.ReadFile(filepath.Join(summary.RunDir, "run.log"))
+		return string(seen), strings.TrimSpace(string(helper)), string(log)
+	}
+
+	if seen, _, _ := run(); seen != "|" {
+		t.Fatalf("steps saw %q without opting in", seen)
+	}
+
+	wf.Credentials = &dsl.Credentials{SSHAgent: true, GitHelper: "store", Pass: []string{"GH_TOKEN"}}
+	seen, helper, log := run()
+	if seen != sock+"|ghp_hunter22" {
+		t.Fatalf("steps saw %q", seen)
+	}
+	if helper != "store" {
+		t.Fatalf("credential.helper is %q, want only store", helper)
+	}
+	if !strings.Contains(log, "passing SSH agent "+sock) || !strings.Contains(log, "passing GH_TOKEN") {
+		t.Fatalf("passthrough not logged:\n%s", log)
+	}
+	if strings.Contains(log, "ghp_hunter22") {
+		t.Fatalf("passed token leaked into the log:\n%s", log)
+	}
+
+	wf.Credentials = &dsl.Credentials{SSHAuthSock: filepath.Join(dir, "missing.sock")}
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("expected a missing ssh_auth_sock to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// OutdatedDep is a dependency with a newer version available, normalized
+// across package managers.
+type OutdatedDep struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	Current   string `json:"current"`
+	Latest    string `json:"latest"`
+}
+
+// key identifies an update: the same dependency with a newer latest
+// version counts as a new update.
+func (d OutdatedDep) key() string {
+	return d.Ecosystem + " " + d.Name + " " + d.Latest
+}
+
+// depsTools are the commands that list outdated dependencies as JSON on
+// stdout.
+var depsTools = map[string]string{
+	"go":  "go list -u -m -json all",
+	"npm": "npm outdated --json",
+	"pip": "pip list --outdated --format=json",
+}
+
+// depsLabel describes a deps step for the summary and log.
+func depsLabel(d *dsl.Deps) string {
+	if len(d.Ecosystems) == 0 {
+		return "deps auto"
+	}
+	return "deps " + strings.Join(d.Ecosystems, ", ")
+}
+
+// depsOutput returns the path of the deps step's report in the run
+// directory.
+func depsOutput(runDir string, index int) string {
+	out := filepath.Join(runDir, fmt.Sprintf("step-%d-deps.json", index+1))
+	if abs, err := filepath.Abs(out); err == nil {
+		return abs
+	}
+	return out
+}
+
+// detectEcosystems returns the ecosystems whose manifests are in workdir.
+func detectEcosystems(workdir string) []string {
+	manifests := map[string][]string{
+		"go":  {"go.mod"},
+		"npm": {"package.json"},
+		"pip": {"requirements.txt", "pyproject.toml", "setup.py"},
+	}
+	var found []string
+	for _, eco := range dsl.DepsEcosystems {
+		for _, name := range manifests[eco] {
+			if _, err := os.Stat(filepath.Join(workdir, name)); err == nil {
+				found = append(found, eco)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// runDeps lists the outdated dependencies of each ecosystem with command,
+// which runs a command in the step shell, writes them to output as JSON and
+// compares them with previous, the list the last run reported. It returns
+// exit code 1 when there are updates previous did not list or a tool
+// failed; an ecosystem whose tool failed keeps its previous entries so
+// they are not reported as new once it works again.
+func runDeps(ctx context.Context, d *dsl.Deps, output, workdir string, previous []OutdatedDep, extra extraEnv, w io.Writer, logPath string, logs *dsl.Logs, command func(context.Context, string, io.Writer) (int, Usage, error)) (int, Usage, error) {
+	stepLog, err := openLog(logPath, logs)
+	if err != nil {
+		return 0, Usage{}, err
+	}
+	defer stepLog.Close()
+	out := newRedactingWriter(io.MultiWriter(w, stepLog), extra.secrets...)
+	defer out.Flush()
+
+	ecosystems := d.Ecosystems
+	if len(ecosystems) == 0 {
+		ecosystems = detectEcosystems(workdir)
+		if len(ecosystems) == 0 {
+			fmt.Fprintln(out, "no go.mod, package.json, requirements.txt or pyproject.toml found")
+			return 1, Usage{}, nil
+		}
+	}
+
+	var (
+		current []OutdatedDep
+		total   Usage
+		failed  []string
+	)
+	for _, eco := range ecosystems {
+		raw := output + "." + eco + ".out"
+		tool := depsTools[eco]
+		fmt.Fprintf(out, "$ %s\n", tool)
+		exitCode, usage, err := command(ctx, tool+" > "+shellQuote(raw), out)
+		total.Add(usage)
+		data, readErr := os.ReadFile(raw)
+		os.Remove(raw)
+		if err != nil {
+			return 0, total, err
+		}
+		var deps []OutdatedDep
+		if readErr == nil {
+			deps, err = parseOutdated(eco, data, d.Indirect)
+		}
+		// npm outdated exits 1 when it finds anything, so a tool counts as
+		// failed only when its output does not parse.
+		if readErr != nil || err != nil || (exitCode != 0 && eco != "npm") {
+			reason := fmt.Sprintf("exit %d", exitCode)
+			if err != nil {
+				reason = err.Error()
+			}
+			fmt.Fprintf(out, "%s: listing outdated dependencies failed: %s\n", eco, reason)
+			failed = append(failed, eco)
+			for _, dep := range previous {
+				if dep.Ecosystem == eco {
+					current = append(current, dep)
+				}
+			}
+			continue
+		}
+		current = append(current, deps...)
+	}
+	sort.Slice(current, func(i, j int) bool { return current[i].key() < current[j].key() })
+
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return 0, total, err
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0o644); err != nil {
+		return 0, total, err
+	}
+
+	known := make(map[string]bool, len(previous))
+	for _, dep := range previous {
+		known[dep.key()] = true
+	}
+	fresh := 0
+	for _, dep := range current {
+		marker := " "
+		if !known[dep.key()] {
+			marker = "+"
+			fresh++
+		}
+		fmt.Fprintf(out, "%s %s %s %s -> %s\n", marker, dep.Ecosystem, dep.Name, dep.Current, dep.Latest)
+	}
+	fmt.Fprintf(out, "%d outdated, %d new since the last run\n", len(current), fresh)
+	if fresh > 0 || len(failed) > 0 {
+		return 1, total, nil
+	}
+	return 0, total, nil
+}
+
+// parseOutdated normalizes a tool's JSON output.
+func parseOutdated(eco string, data []byte, indirect bool) ([]OutdatedDep, error) {
+	var deps []OutdatedDep
+	switch eco {
+	case "go":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var mod struct {
+				Path     string
+				Version  string
+				Main     bool
+				Indirect bool
+				Update   *struct{ Version string }
+			}
+			if err := dec.Decode(&mod); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if mod.Main || mod.Update == nil || (mod.Indirect && !indirect) {
+				continue
+			}
+			deps = append(deps, OutdatedDep{Ecosystem: eco, Name: mod.Path, Current: mod.Version, Latest: mod.Update.Version})
+		}
+	case "npm":
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil, nil
+		}
+		var pkgs map[string]json.RawMessage
+		if err := json.Unmarshal(data, &pkgs); err != nil {
+			return nil, err
+		}
+		type npmPkg struct {
+			Current string `json:"current"`
+			Latest  string `json:"latest"`
+		}
+		for name, raw := range pkgs {
+			var pkg npmPkg
+			// Workspaces report a list with one entry per location.
+			if err := json.Unmarshal(raw, &pkg); err != nil {
+				var list []npmPkg
+				if err := json.Unmarshal(raw, &list); err != nil || len(list) == 0 {
+					return nil, fmt.Errorf("unexpected npm entry for %s", name)
+				}
+				pkg = list[0]
+			}
+			deps = append(deps, OutdatedDep{Ecosystem: eco, Name: name, Current: pkg.Current, Latest: pkg.Latest})
+		}
+	case "pip":
+		var pkgs []struct {
+			Name          string `json:"name"`
+			Version       string `json:"version"`
+			LatestVersion string `json:"latest_version"`
+		}
+		if err := json.Unmarshal(data, &pkgs); err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			deps = append(deps, OutdatedDep{Ecosystem: eco, Name: pkg.Name, Current: pkg.Version, Latest: pkg.LatestVersion})
+		}
+	default:
+		return nil, errors.New("unknown ecosystem")
+	}
+	return deps, nil
+}
+
+// previousDeps returns the outdated dependencies recorded in the report
+// named base by the most recent earlier run of job that has one.
+func previousDeps(repo, job, base string) []OutdatedDep {
+	for _, summary := range RecentRuns(repo, job, 20) {
+		for _, name := range []string{base, base + ".gz"} {
+			data, err := readRunFile(filepath.Join(summary.RunDir, name))
+			if err != nil {
+				continue
+			}
+			var deps []OutdatedDep
+			if json.Unmarshal(data, &deps) == nil {
+				return deps
+			}
+		}
+	}
+	return nil
+}
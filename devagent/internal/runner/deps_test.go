@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+// fakeDepsTools answers deps commands with canned output, writing it to the
+// file the command redirects to.
+func fakeDepsTools(outputs map[string]string) func(context.Context, string, io.Writer) (int, Usage, error) {
+	return func(_ context.Context, command string, _ io.Writer) (int, Usage, error) {
+		tool, target, _ := strings.Cut(command, " > ")
+		out, ok := outputs[strings.Fields(tool)[0]]
+		if !ok {
+			return 127, Usage{}, nil
+		}
+		path := strings.Trim(target, "'")
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			return 0, Usage{}, err
+		}
+		if strings.HasPrefix(tool, "npm") && out != "{}" {
+			return 1, Usage{}, nil
+		}
+		return 0, Usage{}, nil
+	}
+}
+
+func TestRunDepsReportsOnlyNewUpdates(t *testing.T) {
+	dir := t.TempDir()
+	tools := fakeDepsTools(map[string]string{
+		"go": `{"Path": "example.com/app", "Main": true}
+{"Path": "golang.org/x/text", "Version": "v0.3.0", "Update": {"Version": "v0.14.0"}}
+{"Path": "golang.org/x/sys", "Version": "v0.1.0", "Indirect": true, "Update": {"Version": "v0.15.0"}}
+{"Path": "gopkg.in/yaml.v3", "Version": "v3.0.1"}`,
+		"npm": `{"left-pad": {"current": "1.0.0", "wanted": "1.0.0", "latest": "1.3.0"}}`,
+	})
+	deps := &dsl.Deps{Ecosystems: []string{"go", "npm"}}
+	output := filepath.Join(dir, "step-1-deps.json")
+
+	var log strings.Builder
+	exitCode, _, err := runDeps(context.Background(), deps, output, dir, nil, extraEnv{}, &log, filepath.Join(dir, "step-1.log"), nil, tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 1 || !strings.Contains(log.String(), "2 outdated, 2 new since the last run") {
+		t.Fatalf("expected two new updates, got exit %d:\n%s", exitCode, log.String())
+	}
+	var recorded []OutdatedDep
+	data, _ := os.ReadFile(output)
+	if err := json.Unmarshal(data, &recorded); err != nil || len(recorded) != 2 {
+		t.Fatalf("unexpected report %s", data)
+	}
+
+	// The same updates again are not news.
+	log.Reset()
+	exitCode, _, err = runDeps(context.Background(), deps, output, dir, recorded, extraEnv{}, &log, filepath.Join(dir, "step-1.log"), nil, tools)
+	if err != nil || exitCode != 0 {
+		t.Fatalf("expected no new updates, got exit %d %v:\n%s", exitCode, err, log.String())
+	}
+
+	// A newer release of a known dependency is.
+	previous := []OutdatedDep{{Ecosystem: "go", Name: "golang.org/x/text", Current: "v0.3.0", Latest: "v0.13.0"}, recorded[1]}
+	log.Reset()
+	exitCode, _, _ = runDeps(context.Background(), deps, output, dir, previous, extraEnv{}, &log, filepath.Join(dir, "step-1.log"), nil, tools)
+	if exitCode != 1 || !strings.Contains(log.String(), "+ go golang.org/x/text v0.3.0 -> v0.14.0") {
+		t.Fatalf("expected the newer release to be reported, got exit %d:\n%s", exitCode, log.String())
+	}
+}
+
+func TestRunDepsKeepsEntriesOfFailedTool(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "requirements.txt"), nil, 0o644)
+	previous := []OutdatedDep{{Ecosystem: "pip", Name: "requests", Current: "2.0", Latest: "2.31"}}
+	output := filepath.Join(dir, "step-1-deps.json")
+
+	var log strings.Builder
+	exitCode, _, err := runDeps(context.Background(), &dsl.Deps{}, output, dir, previous, extraEnv{}, &log, filepath.Join(dir, "step-1.log"), nil, fakeDepsTools(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 1 || !strings.Contains(log.String(), "pip: listing outdated dependencies failed") {
+		t.Fatalf("expected the missing pip to fail the step, got exit %d:\n%s", exitCode, log.String())
+	}
+	var recorded []OutdatedDep
+	data, _ := os.ReadFile(output)
+	if json.Unmarshal(data, &recorded); len(recorded) != 1 || recorded[0] != previous[0] {
+		t.Fatalf("expected pip's previous entries to be kept, got %s", data)
+	}
+}
+
+func TestParseOutdatedPip(t *testing.T) {
+	deps, err := parseOutdated("pip", []byte(`[{"name": "requests", "version": "2.0.0", "latest_version": "2.31.0", "latest_filetype": "wheel"}]`), false)
+	if err != nil || len(deps) != 1 || deps[0] != (OutdatedDep{Ecosystem: "pip", Name: "requests", Current: "2.0.0", Latest: "2.31.0"}) {
+		t.Fatalf("unexpected result %+v %v", deps, err)
+	}
+}
@@ -0,0 +1,237 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+)
+
+// EnvReport describes the environment steps of a workflow run in, as
+// reported by the same login shell and sandbox the runner uses.
+type EnvReport struct {
+	Repo  string `json:"repo"`
+	Shell string `json:"shell"`
+	// Sandbox is the sandbox tool steps run under, if any.
+	Sandbox string `json:"sandbox,omitempty"`
+	// User is the run_as user steps run as, if not the daemon's own.
+	User string `json:"user,omitempty"`
+	// Removed lists the variables withheld from steps because their names
+	// look like secrets, or because they are credentials the workflow did
+	// not opt into.
+	Removed []string `json:"removed,omitempty"`
+	// Credentials describes what the workflow's credentials pass to steps.
+	Credentials []string `json:"credentials,omitempty"`
+	// Env is the environment inside the login shell, after profiles ran.
+	Env   []string  `json:"env"`
+	Path  []string  `json:"path"`
+	Steps []StepEnv `json:"steps"`
+}
+
+// StepEnv is how a step's command resolves in the step environment.
+type StepEnv struct {
+	Cmd     string `json:"cmd"`
+	Program string `json:"program,omitempty"`
+	// Resolved is what `command -v` finds for Program; empty when it is
+	// not found.
+	Resolved string `json:"resolved"`
+}
+
+// ProbeOptions controls Probe.
+type ProbeOptions struct {
+	Workflow *dsl.Workflow
+	Needs    map[string]map[string]string
+	// Base replaces the current process environment, e.g. with the one
+	// recorded by the daemon.
+	Base []string
+}
+
+// Probe reports the environment the workflow's steps would see, with
+// upstream outputs interpolated into the commands. Nothing is run besides
+// `env` and `command -v` in the step shell.
+func Probe(ctx context.Context, opts ProbeOptions) (*EnvReport, error) {
+	repo, err := opts.Workflow.ExpandRepo()
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	if _, err := os.Stat(repo); err != nil {
+		return nil, &ConfigError{Err: fmt.Errorf("repo path %s not accessible: %w", repo, err)}
+	}
+	steps, err := expandSteps(opts.Workflow.Steps, opts.Needs, newTemplateFuncs(opts.Workflow, repo, 0))
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	tmp, err := os.MkdirTemp("", "devagent-env-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	sb, err := newSandbox(opts.Workflow.Sandbox, repo, tmp)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	su, err := newStepUser(opts.Workflow.RunAs)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	base := opts.Base
+	if base == nil {
+		base = os.Environ()
+	}
+	fileEnv, secrets, err := loadEnvFiles(repo, opts.Workflow.EnvFiles)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	credVars, credSecrets, credNotes, err := credentialEnv(opts.Workflow.Credentials, base)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	env, withheld := filterEnv(base)
+	var removed []string
+	for _, name := range withheld {
+		passed := false
+		for _, kv := range credVars {
+			passed = passed || strings.HasPrefix(kv, name+"=")
+		}
+		if !passed {
+			removed = append(removed, name)
+		}
+	}
+	env = append(append(append(env, fileEnv...), credVars...), "DEVAGENT_OUTPUT="+filepath.Join(tmp, outputsFileName))
+	rw := newRedactingWriter(io.Discard, append(secrets, credSecrets...)...)
+	report := &EnvReport{Repo: repo, Removed: removed, Credentials: credNotes}
+	if sb != nil {
+		report.Sandbox = sb.tool
+	}
+	if su != nil && su.cred != nil {
+		report.User = su.name
+	}
+	if report.Shell, err = exec.LookPath("bash"); err != nil {
+		return nil, err
+	}
+
+	out, err := probeShell(ctx, sb, su, withShell(opts.Workflow.Shell, "env -0"), repo, env)
+	if err != nil {
+		return nil, fmt.Errorf("start step shell: %w", err)
+	}
+	for _, kv := range strings.Split(strings.TrimRight(out, "\x00"), "\x00") {
+		if kv == "" {
+			continue
+		}
+		if key, value, _ := strings.Cut(kv, "="); secretName(key) {
+			kv = key + "=<redacted>"
+		} else if key == "PATH" {
+			report.Path = filepath.SplitList(value)
+		}
+		report.Env = append(report.Env, rw.redact(kv))
+	}
+	sort.Strings(report.Env)
+
+	for i, step := range steps {
+		resolved := resolveStep(step, tmp, i)
+		if resolved.command == "" {
+			continue
+		}
+		stepEnv := StepEnv{Cmd: rw.redact(resolved.label), Program: programOf(resolved.command)}
+		if stepEnv.Program != "" {
+			found, _ := probeShell(ctx, sb, su, withShell(opts.Workflow.Shell, "command -v -- "+shellQuote(stepEnv.Program)), repo, env)
+			// Only the last line counts; shell setup may print first.
+			lines := strings.Split(strings.TrimSpace(found), "\n")
+			stepEnv.Resolved = strings.TrimSpace(lines[len(lines)-1])
+		}
+		report.Steps = append(report.Steps, stepEnv)
+	}
+	return report, nil
+}
+
+// probeShell runs command the way a step runs and returns its stdout.
+func probeShell(ctx context.Context, sb *sandbox, su *stepUser, command, dir string, env []string) (string, error) {
+	cmd := sb.command(ctx, su.wrap(command), dir)
+	cmd.Dir = dir
+	cmd.Env = env
+	su.apply(cmd)
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A non-zero exit (e.g. command -v not finding the program) still
+		// answers the question.
+		err = nil
+	}
+	return string(out), err
+}
+
+// programOf returns the program a shell command starts with, skipping
+// leading variable assignments.
+func programOf(command string) string {
+	scanner := bufio.NewScanner(strings.NewReader(command))
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		word := scanner.Text()
+		if name, _, ok := strings.Cut(word, "="); ok && name != "" && !strings.ContainsAny(name, "/$'\"") {
+			continue
+		}
+		return strings.Trim(word, "'\"")
+	}
+	return ""
+}
+
+// EnvSnapshotPath is where the daemon records the environment it was
+// started with, so `devagent env --daemon` can reproduce it.
+func EnvSnapshotPath() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.env"), nil
+}
+
+// WriteEnvSnapshot records the current process environment at
+// EnvSnapshotPath, one variable per line. Values of secret-looking
+// variables are left out since steps never see them.
+func WriteEnvSnapshot() error {
+	path, err := EnvSnapshotPath()
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if secretName(key) || strings.Contains(kv, "\n") {
+			kv = key + "="
+		}
+		b.WriteString(kv + "\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+// LoadEnvSnapshot reads the environment recorded by WriteEnvSnapshot.
+func LoadEnvSnapshot() ([]string, error) {
+	path, err := EnvSnapshotPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	env := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "=") {
+			env = append(env, line)
+		}
+	}
+	return env, nil
+}
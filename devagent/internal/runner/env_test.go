@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestProbeReportsStepEnvironment(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Steps: []dsl.Step{
+		{Run: "CI=1 ls -la"},
+		{Run: "definitely-not-installed-tool --flag"},
+		{Run: "echo ${{ needs.build.outputs.version }}"},
+	}}
+	base := []string{"PATH=/usr/bin:/bin", "HOME=" + repo, "DEPLOY_TOKEN=s3cret", "GREETING=hello"}
+	report, err := Probe(context.Background(), ProbeOptions{
+		Workflow: wf,
+		Needs:    map[string]map[string]string{"build": {"version": "1.2.3"}},
+		Base:     base,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != "DEPLOY_TOKEN" {
+		t.Fatalf("expected DEPLOY_TOKEN to be withheld, got %v", report.Removed)
+	}
+	env := strings.Join(report.Env, "\n")
+	if !strings.Contains(env, "GREETING=hello") || strings.Contains(env, "s3cret") || !strings.Contains(env, "DEVAGENT_OUTPUT=") {
+		t.Fatalf("unexpected step environment:\n%s", env)
+	}
+	if len(report.Steps) != 3 || report.Steps[0].Program != "ls" || report.Steps[0].Resolved == "" {
+		t.Fatalf("expected ls to resolve, got %+v", report.Steps)
+	}
+	if report.Steps[1].Resolved != "" {
+		t.Fatalf("missing tools should not resolve, got %+v", report.Steps[1])
+	}
+	if report.Steps[2].Cmd != "echo 1.2.3" {
+		t.Fatalf("upstream outputs should be interpolated, got %q", report.Steps[2].Cmd)
+	}
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"devagent/internal/paths"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// minRedactedValue is the shortest env_files value redacted from logs;
+// shorter values such as 1 or true would mangle unrelated output.
+const minRedactedValue = 4
+
+// loadEnvFiles reads the workflow's env_files, relative to repo, in order
+// so later files override earlier ones. It returns KEY=VALUE pairs and the
+// values to redact from step output.
+func loadEnvFiles(repo string, files []string) (env, secrets []string, err error) {
+	for _, file := range files {
+		path, err := paths.Expand(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(repo, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("env file: %w", err)
+		}
+		vars, err := parseEnvFile(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("env file %s: %w", file, err)
+		}
+		for _, kv := range vars {
+			_, value, _ := strings.Cut(kv, "=")
+			// Output is redacted a line at a time, so each line of a
+			// multi-line value, such as a PEM key, is a secret of its own.
+			for _, line := range strings.Split(value, "\n") {
+				if line = strings.TrimSuffix(line, "\r"); len(line) >= minRedactedValue {
+					secrets = append(secrets, line)
+				}
+			}
+		}
+		env = append(env, vars...)
+	}
+	return env, secrets, nil
+}
+
+// parseEnvFile parses dotenv syntax: KEY=VALUE lines, optionally prefixed
+// with export, # comments, and single-quoted (literal) or double-quoted
+// (with \n, \t, \" and \\ escapes) values. Variables are not expanded.
+func parseEnvFile(data []byte) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			var b strings.Builder
+			closed := false
+			for i := 1; i < len(value); i++ {
+				c := value[i]
+				if c == '"' {
+					closed = true
+					break
+				}
+				if c == '\\' && i+1 < len(value) {
+					i++
+					switch value[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(value[i])
+					}
+					continue
+				}
+				b.WriteByte(c)
+			}
+			if !closed {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+			value = b.String()
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	data := []byte(`# comment
+export TOKEN=abc123
+PLAIN = value # trailing comment
+SINGLE='literal $HOME # kept'
+DOUBLE="line\nbreak \"quoted\""
+EMPTY=
+`)
+	env, err := parseEnvFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"TOKEN=abc123", "PLAIN=value", "SINGLE=literal $HOME # kept", "DOUBLE=line\nbreak \"quoted\"", "EMPTY="}
+	if strings.Join(env, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", env, want)
+	}
+	for _, bad := range []string{"no equals sign", "1KEY=x", `KEY="unterminated`} {
+		if _, err := parseEnvFile([]byte(bad)); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRunLoadsEnvFilesAndRedactsValues(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, ".env"), []byte("API_URL=http://localhost\nDEPLOY_KEY=hunter22\nTLS_KEY=\"-----BEGIN KEY-----\\nMIIBOgIBAAJBAKj34\\n-----END KEY-----\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, ".env.ci"), []byte("API_URL=http://ci.internal\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, EnvFiles: []string{".env", ".env.ci"},
+		Steps: []dsl.Step{{Run: `printf %s "$API_URL" > seen; echo "key is $DEPLOY_KEY"; echo "$TLS_KEY"`}},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen, _ := os.ReadFile(filepath.Join(repo, "seen"))
+	if summary.Status != "success" || string(seen) != "http://ci.internal" {
+		t.Fatalf("expected later env files to override earlier ones, got %s %q", summary.Status, seen)
+	}
+	log, err := os.ReadFile(filepath.Join(summary.RunDir, "run.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(log), "hunter22") || !strings.Contains(string(log), "key is <redacted>") {
+		t.Fatalf("expected env file values to be redacted from the log:\n%s", log)
+	}
+	if strings.Contains(string(log), "MIIBOgIBAAJBAKj34") || strings.Contains(string(log), "BEGIN KEY") {
+		t.Fatalf("expected every line of a multi-line value to be redacted from the log:\n%s", log)
+	}
+
+	wf.EnvFiles = []string{".env.missing"}
+	var configErr *ConfigError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); !errors.As(err, &configErr) {
+		t.Fatalf("expected a missing env file to be a config error, got %v", err)
+	}
+}
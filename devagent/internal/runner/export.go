@@ -0,0 +1,384 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+	"devagent/internal/plugin"
+)
+
+// exportRunDir stands in for the run directory in exported step commands;
+// it is replaced by a reference to $RUN_DIR.
+const exportRunDir = "/__devagent_run_dir__"
+
+// ExportShell renders wf as a standalone bash script that runs its steps as
+// devagent would, for machines without devagent. Upstream outputs from needs
+// and template functions are expanded when exporting. The notes list what
+// the script does not reproduce.
+func ExportShell(wf *dsl.Workflow, needs map[string]map[string]string) (script string, notes []string, err error) {
+	repo, err := wf.ExpandRepo()
+	if err != nil {
+		return "", nil, &ConfigError{Err: err}
+	}
+	funcs := newTemplateFuncs(wf, repo, 0)
+	steps, err := expandSteps(wf.Steps, needs, funcs)
+	if err != nil {
+		return "", nil, &ConfigError{Err: err}
+	}
+	cleanup, err := expandSteps(wf.OnCancel, needs, funcs)
+	if err != nil {
+		return "", nil, &ConfigError{Err: err}
+	}
+
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+
+	line("#!/usr/bin/env bash")
+	line("# %s, exported by `devagent export` on %s.", wf.Name, time.Now().UTC().Format(time.RFC3339))
+	line("#")
+	line("# Runs the workflow's steps in order in $REPO, stopping at the first one that")
+	line("# fails, and keeps each step's log, outputs.env and the copied outputs in a")
+	line("# new run directory under $REPO/devagent_runs, as `devagent run` does.")
+	line("# Upstream outputs and ${{ ... }} functions were expanded when exporting.")
+	notes = exportNotes(wf)
+	if len(notes) > 0 {
+		line("#")
+		line("# Not reproduced:")
+		for _, note := range notes {
+			line("#   - %s", note)
+		}
+	}
+	line("set -o pipefail")
+	line("")
+	line("export REPO=\"${REPO:-%s}\"", strings.TrimSuffix(shellDoubleQuoteBody(repo), "/"))
+	line("cd \"$REPO\" || exit 1")
+	line("export RUN_DIR=\"$REPO/devagent_runs/$(date -u +%%Y-%%m-%%dT%%H-%%M-%%SZ)\"")
+	line("mkdir -p \"$RUN_DIR\" || exit 1")
+	line("export DEVAGENT_OUTPUT=\"$RUN_DIR/%s\"", outputsFileName)
+	line(": >>\"$DEVAGENT_OUTPUT\"")
+
+	if len(wf.EnvFiles) > 0 {
+		line("")
+		line("# env_files: their variables are added to every step's environment.")
+		line("set -a")
+		for _, file := range wf.EnvFiles {
+			path, err := paths.Expand(file)
+			if err != nil {
+				return "", nil, &ConfigError{Err: err}
+			}
+			if filepath.IsAbs(path) {
+				line(". %s || exit 1", shellQuote(path))
+			} else {
+				line(". \"$REPO\"/%s || exit 1", shellQuote(path))
+			}
+		}
+		line("set +a")
+	}
+
+	line("")
+	line("# step N LABEL COMMAND runs COMMAND in a login shell like a devagent step,")
+	line("# logging its output to the run directory.")
+	line("step() {")
+	line("  echo \"\\$ $2\"")
+	line("  bash -lc \"$3\" 2>&1 | tee \"$RUN_DIR/step-$1.log\"")
+	line("}")
+	line("")
+	line("fail() {")
+	line("  echo \"step $1 failed with exit code $2\" >&2")
+	line("  exit \"$2\"")
+	line("}")
+
+	if len(cleanup) > 0 {
+		line("")
+		line("# on_cancel: run when the script is interrupted.")
+		line("on_cancel() {")
+		line("  trap - INT TERM")
+		line("  echo \"run cancelled\"")
+		for i, step := range cleanup {
+			command, label, note := exportStep(wf, step, i)
+			if note != "" {
+				notes = append(notes, fmt.Sprintf("on_cancel step %d: %s", i+1, note))
+			}
+			if command == "" {
+				continue
+			}
+			line("  step cancel-%d %s %s", i+1, shellQuote(label), shellQuote(command))
+		}
+		line("  exit 130")
+		line("}")
+		line("trap on_cancel INT TERM")
+	}
+
+	for i, step := range steps {
+		command, label, note := exportStep(wf, step, i)
+		if note != "" {
+			notes = append(notes, fmt.Sprintf("step %d: %s", i+1, note))
+		}
+		if command == "" {
+			continue
+		}
+		line("")
+		line("# Step %d: %s", i+1, strings.ReplaceAll(label, "\n", " "))
+		if note != "" {
+			line("# %s", note)
+		}
+		line("step %d %s %s || fail %d $?", i+1, shellQuote(label), shellQuote(command), i+1)
+	}
+
+	if out := wf.Outputs; out != nil && (len(out.CopyIfExists) > 0 || len(out.Publish) > 0) {
+		line("")
+		line("# outputs: copied into the run directory.")
+		for _, candidate := range out.CopyIfExists {
+			if candidate = strings.TrimSpace(candidate); candidate != "" {
+				src := "\"$REPO\"/" + shellQuote(candidate)
+				line("if [ -e %s ]; then cp -R %s \"$RUN_DIR\"/; fi", src, src)
+			}
+		}
+		keys := make([]string, 0, len(out.Publish))
+		for key := range out.Publish {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			line("mkdir -p \"$RUN_DIR/outputs\"")
+		}
+		for _, key := range keys {
+			rel := out.Publish[key]
+			src := "\"$REPO\"/" + shellQuote(rel)
+			dst := "\"$RUN_DIR/outputs\"/" + shellQuote(filepath.Base(rel))
+			line("if [ -e %s ]; then cp %s %s && echo %s\"=$RUN_DIR/outputs/\"%s >>\"$DEVAGENT_OUTPUT\"; fi", src, src, dst, shellQuote(key), shellQuote(filepath.Base(rel)))
+		}
+	}
+
+	line("")
+	line("echo \"run finished: success ($RUN_DIR)\"")
+	return b.String(), notes, nil
+}
+
+// exportNotes lists the workflow settings an exported script ignores.
+func exportNotes(wf *dsl.Workflow) []string {
+	var notes []string
+	if wf.Sandbox != nil {
+		notes = append(notes, "sandbox: steps are not confined")
+	}
+	if wf.RunAs != nil {
+		notes = append(notes, "run_as: steps run as the invoking user")
+	}
+	if wf.Worktree != nil {
+		notes = append(notes, "worktree: steps run in the repo itself")
+	}
+	if len(wf.Cache) > 0 {
+		notes = append(notes, "cache: directories are not restored or saved")
+	}
+	if wf.Credentials != nil {
+		notes = append(notes, "credentials: steps see the whole environment, including the SSH agent")
+	}
+	if wf.Preconditions != nil {
+		notes = append(notes, "preconditions are not checked")
+	}
+	if wf.Notify != nil || len(wf.ReportTo) > 0 {
+		notes = append(notes, "notifications and report_to")
+	}
+	if len(wf.FailIf) > 0 || wf.Bench != nil || (wf.Outputs != nil && len(wf.Outputs.Reports) > 0) {
+		notes = append(notes, "test reports, fail_if and benchmarks")
+	}
+	if wf.Lock != "" {
+		notes = append(notes, "lock: runs of the lock group may overlap")
+	}
+	return notes
+}
+
+// exportStep returns the shell command and label of a step in an exported
+// script, or a note when the step cannot run without devagent.
+func exportStep(wf *dsl.Workflow, step dsl.Step, index int) (command, label, note string) {
+	resolved := resolveStep(step, exportRunDir, index)
+	label = resolved.label
+	switch {
+	case resolved.http != nil:
+		return exportHTTP(resolved.http), label, ""
+	case resolved.sql != nil:
+		q := resolved.sql
+		if q.Driver != "sqlite" {
+			return skipCommand(label), label, fmt.Sprintf("sql driver %s is built into devagent; the step is skipped", q.Driver)
+		}
+		dsn := shellQuote(q.DSN)
+		if q.DSNEnv != "" {
+			dsn = "\"$" + q.DSNEnv + "\""
+		}
+		mode := "-csv -header"
+		if strings.EqualFold(filepath.Ext(resolved.output), ".json") {
+			mode = "-json"
+		}
+		command = fmt.Sprintf("sqlite3 %s %s %s > %s", mode, dsn, shellQuote(q.Query), shellQuote(resolved.output))
+		return withShell(wf.Shell, exportPaths(command)), label, "needs the sqlite3 command"
+	case resolved.assert != nil:
+		return withShell(wf.Shell, exportAssert(resolved.assert)), label, ""
+	case resolved.deps != nil:
+		return skipCommand(label), label, "deps steps compare with devagent's previous runs; the step is skipped"
+	case resolved.script != "":
+		return skipCommand(label), label, "script steps run inside devagent; the step is skipped"
+	case resolved.plugin != "":
+		req := plugin.StepRequest{
+			Protocol: plugin.Protocol, Job: wf.Name, Step: resolved.index,
+			Repo: "__REPO__", Workdir: "__REPO__", RunDir: "__RUN_DIR__", Outputs: "__OUTPUTS__", With: resolved.with,
+		}
+		data, _ := json.MarshalIndent(req, "", "  ")
+		body := strings.NewReplacer("\\", "\\\\", "$", "\\$", "`", "\\`").Replace(string(data))
+		body = strings.NewReplacer("__REPO__", "$REPO", "__RUN_DIR__", "$RUN_DIR", "__OUTPUTS__", "$DEVAGENT_OUTPUT").Replace(body)
+		request := fmt.Sprintf("\"$RUN_DIR\"/step-%d-plugin.json", resolved.index)
+		command = fmt.Sprintf("cat > %s <<EOF\n%s\nEOF\nDEVAGENT_PLUGIN_PROTOCOL=%d %s %s", request, body, plugin.Protocol, plugin.Executable(plugin.Step, resolved.plugin), request)
+		return withShell(wf.Shell, command), label, fmt.Sprintf("needs %s on PATH", plugin.Executable(plugin.Step, resolved.plugin))
+	}
+	return withShell(wf.Shell, exportPaths(resolved.command)), label, ""
+}
+
+// exportPaths points the run directory paths in command at $RUN_DIR.
+func exportPaths(command string) string {
+	return strings.ReplaceAll(command, "'"+exportRunDir+"/", "\"$RUN_DIR\"'/")
+}
+
+func skipCommand(label string) string {
+	return "echo " + shellQuote("skipped: "+label+" needs devagent") + " >&2"
+}
+
+// exportHTTP renders an http step as a curl command.
+func exportHTTP(h *dsl.HTTP) string {
+	timeout := 30 * time.Second
+	if d, err := time.ParseDuration(h.Timeout); err == nil {
+		timeout = d
+	}
+	args := []string{"curl", "-sS", "--max-time", strconv.Itoa(int(timeout.Seconds())), "-X", httpMethod(h)}
+	keys := make([]string, 0, len(h.Headers))
+	for key := range h.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-H", shellDoubleQuote(key+": "+h.Headers[key]))
+	}
+	if h.Body != "" {
+		args = append(args, "--data-binary", shellDoubleQuote(h.Body))
+	}
+	args = append(args, "-o", "\"$body\"", "-w", "'%{http_code}'", shellDoubleQuote(strings.TrimSpace(h.URL)))
+
+	var b strings.Builder
+	b.WriteString("body=$(mktemp) || exit 1\n")
+	b.WriteString("status=$(" + strings.Join(args, " ") + ") || exit 1\n")
+	b.WriteString("echo \"HTTP $status\"\n")
+	b.WriteString("head -c 16384 \"$body\"; echo\n")
+	if h.SaveTo != "" {
+		dst := "\"$REPO\"/" + shellQuote(h.SaveTo)
+		if filepath.IsAbs(h.SaveTo) {
+			dst = shellQuote(h.SaveTo)
+		}
+		fmt.Fprintf(&b, "mkdir -p \"$(dirname %s)\" && cp \"$body\" %s\n", dst, dst)
+	}
+	b.WriteString("rm -f \"$body\"\n")
+	if h.ExpectStatus != 0 {
+		fmt.Fprintf(&b, "[ \"$status\" = %d ]", h.ExpectStatus)
+	} else {
+		b.WriteString("case \"$status\" in 2??) ;; *) exit 1 ;; esac")
+	}
+	return b.String()
+}
+
+// exportAssert renders an assert step's checks, with grep -E for regular
+// expressions and jq for json_path.
+func exportAssert(a *dsl.Assert) string {
+	prefix := ""
+	if a.Message != "" {
+		prefix = a.Message + ": "
+	}
+	fail := func(msg string) string {
+		return "{ echo " + shellQuote("assertion failed: "+prefix+msg) + "; exit 1; }"
+	}
+	ok := func(msg string) string {
+		return "echo " + shellQuote("ok: "+msg)
+	}
+	var lines []string
+	if a.File != "" {
+		file := shellQuote(a.File)
+		if !filepath.IsAbs(a.File) {
+			file = "\"$REPO\"/" + file
+		}
+		if a.Exists != nil && !*a.Exists {
+			lines = append(lines, "[ ! -e "+file+" ] || "+fail(a.File+" exists"), ok(a.File+" is absent"))
+		} else {
+			lines = append(lines, "[ -e "+file+" ] || "+fail("cannot read "+a.File), ok(a.File+" exists"))
+		}
+		if a.Contains != "" {
+			lines = append(lines, "grep -Eq -- "+shellQuote(a.Contains)+" "+file+" || "+fail(fmt.Sprintf("%s does not contain /%s/", a.File, a.Contains)), ok(fmt.Sprintf("%s contains /%s/", a.File, a.Contains)))
+		}
+		if a.JSONPath != "" {
+			// The mismatch message leaves $got unquoted so that it expands.
+			mismatch := fmt.Sprintf("assertion failed: %s%s %s is ", prefix, a.File, a.JSONPath)
+			lines = append(lines,
+				"got=$(jq -cr "+shellQuote(jqPath(a.JSONPath))+" "+file+") || "+fail(a.File+" is not JSON"),
+				"[ \"$got\" = "+shellQuote(a.Equals)+" ] || { echo "+shellQuote(mismatch)+"\"$got\""+shellQuote(", expected "+a.Equals)+"; exit 1; }",
+				ok(fmt.Sprintf("%s %s == %s", a.File, a.JSONPath, a.Equals)))
+		}
+	}
+	if a.Command != "" {
+		lines = append(lines, "echo "+shellQuote("$ "+a.Command))
+		check := strings.TrimPrefix(assertLabel(&dsl.Assert{Command: a.Command, Matches: a.Matches}), "assert ")
+		if a.Matches == "" {
+			lines = append(lines, "( "+a.Command+" ) || "+fail(fmt.Sprintf("`%s` failed", a.Command)))
+		} else {
+			lines = append(lines,
+				"out=$( "+a.Command+" ) || "+fail(fmt.Sprintf("`%s` failed", a.Command)),
+				"printf '%s\\n' \"$out\"",
+				"printf '%s\\n' \"$out\" | grep -Eq -- "+shellQuote(a.Matches)+" || "+fail(fmt.Sprintf("output of `%s` does not match /%s/", a.Command, a.Matches)))
+		}
+		lines = append(lines, ok(check))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// jqPath converts an assert json_path to a jq filter.
+func jqPath(path string) string {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), ".")
+	if rest == "" {
+		return "."
+	}
+	var b strings.Builder
+	b.WriteString(".")
+	for rest != "" {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			b.WriteString(rest[:end+1])
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+			continue
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		key, _ := json.Marshal(rest[:end])
+		b.WriteString("[" + string(key) + "]")
+		rest = strings.TrimPrefix(rest[end:], ".")
+	}
+	return b.String()
+}
+
+// shellDoubleQuote quotes s for bash so that $VAR and ${VAR} still expand,
+// as they do in http steps, but nothing else does.
+func shellDoubleQuote(s string) string {
+	return "\"" + shellDoubleQuoteBody(s) + "\""
+}
+
+func shellDoubleQuoteBody(s string) string {
+	s = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "`", "\\`").Replace(s)
+	return strings.ReplaceAll(s, "$(", "\\$(")
+}
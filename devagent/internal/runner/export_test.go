@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestExportShell(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, ".env"), []byte("GREETING='hello there'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{
+		Name:     "nightly",
+		Repo:     repo,
+		EnvFiles: []string{".env"},
+		Steps: []dsl.Step{
+			{Run: `echo "$GREETING from ${{ needs.build.outputs.version }}" > report.txt`},
+			{Run: `echo "lines=$(wc -l < report.txt | tr -d ' ')" >> "$DEVAGENT_OUTPUT"`},
+			{Assert: &dsl.Assert{File: "report.txt", Contains: "^hello there from 1\\.2\\.3$"}},
+			{Script: `print("skipped")`},
+		},
+		Outputs: &dsl.Outputs{Publish: map[string]string{"report": "report.txt"}},
+	}
+	needs := map[string]map[string]string{"build": {"version": "1.2.3"}}
+	script, notes, err := ExportShell(wf, needs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "step 4: script steps run inside devagent") {
+		t.Fatalf("unexpected notes %q", notes)
+	}
+	path := filepath.Join(t.TempDir(), "nightly.sh")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("bash", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("script failed: %v\n%s\n--- script ---\n%s", err, out, script)
+	}
+	for _, want := range []string{"ok: report.txt contains", "skipped: script print(\"skipped\") needs devagent", "run finished: success"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %q in the output:\n%s", want, out)
+		}
+	}
+
+	runs, _ := filepath.Glob(filepath.Join(repo, "devagent_runs", "*"))
+	if len(runs) != 1 {
+		t.Fatalf("expected one run directory, got %v", runs)
+	}
+	env, _ := os.ReadFile(filepath.Join(runs[0], outputsFileName))
+	published := filepath.Join(runs[0], "outputs", "report.txt")
+	if !strings.Contains(string(env), "lines=1\n") || !strings.Contains(string(env), "report="+published+"\n") {
+		t.Fatalf("unexpected outputs.env:\n%s", env)
+	}
+	if log, _ := os.ReadFile(filepath.Join(runs[0], "step-3.log")); !strings.Contains(string(log), "ok: report.txt exists") {
+		t.Fatalf("expected the assert step's log, got %q", log)
+	}
+
+	// A failing step stops the script with its exit code.
+	wf.Steps = []dsl.Step{{Run: "exit 3"}, {Run: "touch never"}}
+	wf.Outputs = nil
+	script, _, err = ExportShell(wf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out, err = exec.Command("bash", path).CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 || !strings.Contains(string(out), "step 1 failed with exit code 3") {
+		t.Fatalf("expected exit code 3, got %v:\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "never")); err == nil {
+		t.Fatal("the step after the failure ran")
+	}
+}
+
+func TestExportShellSteps(t *testing.T) {
+	wf := &dsl.Workflow{Name: "api", Repo: t.TempDir(), Steps: []dsl.Step{
+		{HTTP: &dsl.HTTP{URL: "https://api.example.com/v1/$ENDPOINT", Method: "POST", Headers: map[string]string{"Authorization": "Bearer ${TOKEN}"}, Body: `{"a": 1}`, ExpectStatus: 201}},
+		{Assert: &dsl.Assert{File: "out/summary.json", JSONPath: "summary.failed-tests", Equals: "0"}},
+	}}
+	script, _, err := ExportShell(wf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`-H "Authorization: Bearer ${TOKEN}"`,
+		`"https://api.example.com/v1/$ENDPOINT"`,
+		`[ "$status" = 201 ]`,
+		`jq -cr '\''.["summary"]["failed-tests"]'\''`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected %s in the script:\n%s", want, script)
+		}
+	}
+}
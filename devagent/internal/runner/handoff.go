@@ -0,0 +1,254 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// outputsFileName is the file steps append `key=value` lines to, exposed to
+// them through DEVAGENT_OUTPUT.
+const outputsFileName = "outputs.env"
+
+var needsPattern = regexp.MustCompile(`\$\{\{\s*needs\.([A-Za-z0-9_\-]+)\.outputs\.([A-Za-z0-9_\-]+)\s*\}\}`)
+
+// scriptNeedsPattern finds the upstream jobs a script step reads through
+// needs.<job> or needs["<job>"].
+var scriptNeedsPattern = regexp.MustCompile(`\bneeds\s*(?:\.\s*([A-Za-z_][A-Za-z0-9_]*)|\[\s*["']([A-Za-z0-9_\-]+)["']\s*\])`)
+
+// NeededJobs returns the upstream jobs referenced through
+// `${{ needs.<job>.outputs.<key> }}` in the workflow steps, and through the
+// needs table in script steps.
+func NeededJobs(wf *dsl.Workflow) []string {
+	if wf == nil {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	collect := func(text string) {
+		for _, match := range needsPattern.FindAllStringSubmatch(text, -1) {
+			seen[match[1]] = struct{}{}
+		}
+	}
+	for _, step := range wf.Steps {
+		collect(step.Run)
+		if step.Notebook != nil {
+			for _, value := range step.Notebook.Parameters {
+				collect(value)
+			}
+		}
+		if h := step.HTTP; h != nil {
+			collect(h.URL)
+			collect(h.Body)
+			for _, value := range h.Headers {
+				collect(value)
+			}
+		}
+		if q := step.SQL; q != nil {
+			collect(q.DSN)
+			collect(q.Query)
+		}
+		if a := step.Assert; a != nil {
+			for _, text := range []string{a.File, a.Contains, a.Equals, a.Command, a.Matches} {
+				collect(text)
+			}
+		}
+		for _, value := range step.With {
+			collect(value)
+		}
+		for _, match := range scriptNeedsPattern.FindAllStringSubmatch(step.Script, -1) {
+			seen[match[1]+match[2]] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// expandSteps substitutes upstream outputs, and the values of template
+// functions when tf is set, into step commands and notebook parameters.
+// Outputs substituted into shell commands are quoted, so an upstream job
+// cannot inject shell code. Unknown references are reported together as
+// one error.
+func expandSteps(steps []dsl.Step, needs map[string]map[string]string, tf *templateFuncs) ([]dsl.Step, error) {
+	var missing, failed []string
+	substitute := func(text string, shell bool) string {
+		var b strings.Builder
+		last := 0
+		for _, m := range needsPattern.FindAllStringSubmatchIndex(text, -1) {
+			b.WriteString(text[last:m[0]])
+			last = m[1]
+			job, key := text[m[2]:m[3]], text[m[4]:m[5]]
+			value, ok := needs[job][key]
+			switch {
+			case !ok:
+				missing = append(missing, job+"."+key)
+				b.WriteString(text[m[0]:m[1]])
+			case shell:
+				b.WriteString(shellValue(b.String(), value))
+			default:
+				b.WriteString(value)
+			}
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+		if tf != nil {
+			text = tf.expand(text, &failed)
+		}
+		return text
+	}
+	expand := func(text string) string { return substitute(text, false) }
+	expandShell := func(text string) string { return substitute(text, true) }
+
+	out := make([]dsl.Step, 0, len(steps))
+	for _, step := range steps {
+		step.Run = expandShell(step.Run)
+		if step.Notebook != nil {
+			nb := *step.Notebook
+			if len(nb.Parameters) > 0 {
+				params := make(map[string]string, len(nb.Parameters))
+				for key, value := range nb.Parameters {
+					params[key] = expand(value)
+				}
+				nb.Parameters = params
+			}
+			step.Notebook = &nb
+		}
+		if step.HTTP != nil {
+			h := *step.HTTP
+			h.URL = expand(h.URL)
+			h.Body = expand(h.Body)
+			if len(h.Headers) > 0 {
+				headers := make(map[string]string, len(h.Headers))
+				for key, value := range h.Headers {
+					headers[key] = expand(value)
+				}
+				h.Headers = headers
+			}
+			step.HTTP = &h
+		}
+		if len(step.With) > 0 {
+			with := make(map[string]string, len(step.With))
+			for key, value := range step.With {
+				with[key] = expand(value)
+			}
+			step.With = with
+		}
+		if step.SQL != nil {
+			q := *step.SQL
+			q.DSN = expand(q.DSN)
+			q.Query = expand(q.Query)
+			step.SQL = &q
+		}
+		if step.Assert != nil {
+			a := *step.Assert
+			a.File = expand(a.File)
+			a.Contains = expand(a.Contains)
+			a.Equals = expand(a.Equals)
+			a.Command = expandShell(a.Command)
+			a.Matches = expand(a.Matches)
+			step.Assert = &a
+		}
+		out = append(out, step)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unresolved upstream outputs: %s", strings.Join(missing, ", "))
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("template: %s", strings.Join(failed, "; "))
+	}
+	return out, nil
+}
+
+// plainValue matches values that mean the same to the shell unquoted.
+var plainValue = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+,-]+$`)
+
+// shellValue quotes value to be inserted into a shell command after
+// before, taking into account the quotes open at that point: inside single
+// quotes only ' needs escaping, inside double quotes \, ", ` and $ do, and
+// elsewhere the value is single-quoted unless it is plain.
+func shellValue(before, value string) string {
+	if plainValue.MatchString(value) {
+		return value
+	}
+	var quote byte
+	for i := 0; i < len(before); i++ {
+		switch c := before[i]; {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		}
+	}
+	switch quote {
+	case '\'':
+		return strings.ReplaceAll(value, "'", `'\''`)
+	case '"':
+		return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "`", "\\`", "$", "\\$").Replace(value)
+	}
+	return shellQuote(value)
+}
+
+// collectOutputs gathers the key/values steps wrote to the outputs file and
+// copies published files into runDir/outputs, recording their paths.
+func collectOutputs(repo, runDir string, publish map[string]string) (map[string]string, error) {
+	outputs := make(map[string]string)
+
+	data, err := os.ReadFile(filepath.Join(runDir, outputsFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		outputs[strings.TrimSpace(key)] = value
+	}
+
+	if len(publish) == 0 {
+		return outputs, nil
+	}
+	dir := filepath.Join(runDir, "outputs")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return outputs, err
+	}
+	for key, rel := range publish {
+		src := filepath.Join(repo, rel)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.Base(rel))
+		if err := copyFile(src, dst); err != nil {
+			return outputs, err
+		}
+		if abs, err := filepath.Abs(dst); err == nil {
+			dst = abs
+		}
+		outputs[key] = dst
+	}
+	return outputs, nil
+}
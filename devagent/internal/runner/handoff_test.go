@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestExpandStepsResolvesNeeds(t *testing.T) {
+	wf := &dsl.Workflow{Steps: []dsl.Step{
+		{Run: "deploy --build ${{ needs.build.outputs.version }}"},
+		{Run: "cat ${{needs.build.outputs.report}} ${{ needs.lint.outputs.count }}"},
+		{Plugin: "publish", With: map[string]string{"tag": "${{ needs.release.outputs.tag }}"}},
+	}}
+	if got, want := NeededJobs(wf), []string{"build", "lint", "release"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("needed jobs: got %v want %v", got, want)
+	}
+
+	needs := map[string]map[string]string{"build": {"version": "1.2.3", "report": "/tmp/report.xml"}, "release": {"tag": "v1"}}
+	if _, err := expandSteps(wf.Steps, needs, nil); err == nil {
+		t.Fatalf("expected error for missing lint output")
+	}
+
+	needs["lint"] = map[string]string{"count": "0"}
+	steps, err := expandSteps(wf.Steps, needs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if steps[0].Run != "deploy --build 1.2.3" || steps[1].Run != "cat /tmp/report.xml 0" || steps[2].With["tag"] != "v1" {
+		t.Fatalf("unexpected expansion: %q, %q, %v", steps[0].Run, steps[1].Run, steps[2].With)
+	}
+	if wf.Steps[0].Run != "deploy --build ${{ needs.build.outputs.version }}" {
+		t.Fatalf("expansion must not modify the workflow")
+	}
+}
+
+func TestExpandStepsQuotesOutputsInCommands(t *testing.T) {
+	evil := `x$(touch pwned); rm -rf ~ "it's" ` + "`id`"
+	needs := map[string]map[string]string{"build": {"note": evil, "version": "1.2.3"}}
+	steps, err := expandSteps([]dsl.Step{
+		{Run: `printf '%s\n' ${{ needs.build.outputs.note }}`},
+		{Run: `printf '%s\n' "note: ${{ needs.build.outputs.note }}"`},
+		{Run: `printf '%s\n' 'note: ${{ needs.build.outputs.note }}'`},
+		{Run: `printf '%s\n' \"${{ needs.build.outputs.version }}`},
+		{Assert: &dsl.Assert{Command: "echo ${{ needs.build.outputs.note }}"}},
+		{HTTP: &dsl.HTTP{URL: "https://example.com", Body: "${{ needs.build.outputs.note }}"}},
+	}, needs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for i, want := range []string{evil, "note: " + evil, "note: " + evil} {
+		out, err := exec.Command("bash", "-c", "cd "+shellQuote(dir)+" && "+steps[i].Run).Output()
+		if err != nil || string(out) != want+"\n" {
+			t.Errorf("step %d: %q printed %q (%v), want %q", i+1, steps[i].Run, out, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Fatal("an upstream output ran as shell code")
+	}
+	if steps[3].Run != `printf '%s\n' \"1.2.3` {
+		t.Errorf("plain values stay unquoted, got %q", steps[3].Run)
+	}
+	if steps[4].Assert.Command != "echo "+shellQuote(evil) || steps[5].HTTP.Body != evil {
+		t.Errorf("got assert %q and body %q", steps[4].Assert.Command, steps[5].HTTP.Body)
+	}
+}
+
+func TestCollectOutputs(t *testing.T) {
+	repo := t.TempDir()
+	runDir := filepath.Join(repo, "run")
+	if err := os.MkdirAll(runDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(runDir, outputsFileName), []byte("version=1.2.3\n# comment\nurl=http://x?a=b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "report.xml"), []byte("<ok/>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := collectOutputs(repo, runDir, map[string]string{"report": "report.xml", "missing": "nope.txt"})
+	if err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	if outputs["version"] != "1.2.3" || outputs["url"] != "http://x?a=b" {
+		t.Fatalf("unexpected key/values: %v", outputs)
+	}
+	if outputs["report"] != filepath.Join(runDir, "outputs", "report.xml") {
+		t.Fatalf("unexpected report path: %q", outputs["report"])
+	}
+	if _, ok := outputs["missing"]; ok {
+		t.Fatalf("missing files must not be published")
+	}
+}
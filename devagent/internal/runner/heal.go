@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/util"
+)
+
+// Heal runs remediation commands in the workflow's repo (or the worktree the
+// run used) after a failed run.
+// Each command is logged to heal-<n>.log in the failed run's directory and
+// recorded in its summary.json; a new run directory is created when the run
+// left none. Heal stops at the first failing command and reports whether
+// all of them succeeded.
+func Heal(ctx context.Context, wf *dsl.Workflow, failed *Summary, commands []string, w io.Writer) ([]StepSummary, bool, error) {
+	repo, err := wf.ExpandRepo()
+	if err != nil {
+		return nil, false, &ConfigError{Err: err}
+	}
+	runDir := ""
+	if failed != nil {
+		runDir = failed.RunDir
+	}
+	if runDir == "" {
+		if runDir, err = newRunDir(filepath.Join(repo, "devagent_runs"), util.Timestamp()+"-heal"); err != nil {
+			return nil, false, err
+		}
+	}
+	if w == nil {
+		w = io.Discard
+	}
+	outputsPath, err := filepath.Abs(filepath.Join(runDir, outputsFileName))
+	if err != nil {
+		return nil, false, err
+	}
+	var extra extraEnv
+	if extra.vars, extra.secrets, err = loadEnvFiles(repo, wf.EnvFiles); err != nil {
+		return nil, false, &ConfigError{Err: err}
+	}
+	credVars, credSecrets, _, err := credentialEnv(wf.Credentials, os.Environ())
+	if err != nil {
+		return nil, false, &ConfigError{Err: err}
+	}
+	extra.vars = append(extra.vars, credVars...)
+	extra.secrets = append(extra.secrets, credSecrets...)
+	// Heal where the failed steps ran.
+	workdir := repo
+	if failed != nil && failed.Worktree != "" {
+		workdir = failed.Worktree
+	}
+	sb, err := newSandbox(wf.Sandbox, workdir, runDir)
+	if err != nil {
+		return nil, false, &ConfigError{Err: err}
+	}
+	su, err := newStepUser(wf.RunAs)
+	if err != nil {
+		return nil, false, &ConfigError{Err: err}
+	}
+	if err := su.writable(outputsPath); err != nil {
+		return nil, false, err
+	}
+
+	var steps []StepSummary
+	ok := true
+	for i, command := range commands {
+		fmt.Fprintf(w, "$ %s\n", redact(command))
+		stepLog := fmt.Sprintf("heal-%d.log", i+1)
+		start := time.Now()
+		exitCode, usage, err := runLogged(ctx, sb, su, withShell(wf.Shell, command), workdir, outputsPath, extra, w, filepath.Join(runDir, stepLog), wf.Logs)
+		if err != nil {
+			return steps, false, err
+		}
+		step := StepSummary{
+			Cmd:         command,
+			ExitCode:    exitCode,
+			DurationSec: time.Since(start).Seconds(),
+			Log:         stepLog,
+			Usage:       usage,
+		}
+		if exitCode != 0 {
+			step.Tail, _ = logTail(filepath.Join(runDir, stepLog), tailLineCount, tailMaxBytes)
+		}
+		steps = append(steps, step)
+		if exitCode != 0 || ctx.Err() != nil {
+			ok = false
+			break
+		}
+	}
+
+	if failed != nil && failed.RunDir != "" {
+		failed.Heal = append(failed.Heal, steps...)
+		archiveRun(w, wf, failed)
+		if err := writeSummary(filepath.Join(runDir, "summary.json"), failed); err != nil {
+			return steps, ok, err
+		}
+	}
+	return steps, ok, nil
+}
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestHealStopsAtFirstFailureAndRecordsSteps(t *testing.T) {
+	repo := t.TempDir()
+	runDir := filepath.Join(repo, "devagent_runs", "failed")
+	if err := os.MkdirAll(runDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo}
+	failed := &Summary{Name: "nightly", Status: "failed", RunDir: runDir}
+
+	steps, ok, err := Heal(context.Background(), wf, failed, []string{"touch cleaned", "false", "touch unreachable"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || len(steps) != 2 || steps[1].ExitCode == 0 {
+		t.Fatalf("expected to stop after the failing command, got ok=%v %+v", ok, steps)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "cleaned")); err != nil {
+		t.Fatalf("first command did not run in the repo: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "unreachable")); err == nil {
+		t.Fatalf("commands after a failure should not run")
+	}
+	saved, err := LoadSummary(runDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Heal) != 2 || saved.Heal[0].Log != "heal-1.log" {
+		t.Fatalf("heal steps not recorded in summary: %+v", saved.Heal)
+	}
+}
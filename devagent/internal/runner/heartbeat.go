@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Kinds of StepEvent. The run events are sent by the daemon around a run's
+// steps: when the run is due and queued, once it starts and once it ends.
+const (
+	EventStepStart    = "step_start"
+	EventOutput       = "output"
+	EventStepFinish   = "step_finish"
+	EventRunScheduled = "run_scheduled"
+	EventRunStart     = "run_start"
+	EventRunFinish    = "run_finish"
+)
+
+// StepEvent is a step starting, writing output or finishing, as passed to
+// Options.Events, or a run event.
+type StepEvent struct {
+	Kind  string    `json:"kind"`
+	At    time.Time `json:"at"`
+	Step  int       `json:"step"`
+	Total int       `json:"total"`
+	Cmd   string    `json:"cmd"`
+	// Output is a chunk of the step's output, already redacted.
+	Output string `json:"output,omitempty"`
+	// ExitCode and DurationSec are set when the step finishes.
+	ExitCode    int     `json:"exit_code"`
+	DurationSec float64 `json:"duration_sec,omitempty"`
+	// RunID is the store's ID of the run, once it started, and Status its
+	// result in a run_finish event.
+	RunID  int64  `json:"run_id,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// StepProgress describes the step a run is executing, as passed to
+// Options.Heartbeat.
+type StepProgress struct {
+	// Step is the 1-based index of the step out of Total, and Cmd its
+	// label.
+	Step      int
+	Total     int
+	Cmd       string
+	StartedAt time.Time
+	// LastOutput is when the step last wrote to its log, or StartedAt if
+	// it has written nothing yet.
+	LastOutput time.Time
+	// Stalled is set while the step has gone logs.stalled_after without
+	// output.
+	Stalled bool
+}
+
+// stepMonitor passes a step's output on to the run log, noting when the
+// step last wrote, and logs a heartbeat line every interval while the step
+// runs. A step silent for longer than stallAfter is flagged as stalled:
+// slow steps keep writing, hung ones do not.
+type stepMonitor struct {
+	w          io.Writer
+	interval   time.Duration
+	stallAfter time.Duration
+	report     func(StepProgress)
+	events     func(StepEvent)
+
+	mu       sync.Mutex
+	progress StepProgress
+	// stalled records that the step stalled at some point, even if it
+	// wrote again later.
+	stalled bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startMonitor starts watching step n of total and reports it as started.
+// Output must be written through the monitor, and finish called once the
+// step exits.
+func startMonitor(w io.Writer, interval, stallAfter time.Duration, report func(StepProgress), events func(StepEvent), n, total int, label string) *stepMonitor {
+	now := time.Now()
+	m := &stepMonitor{
+		w:          w,
+		interval:   interval,
+		stallAfter: stallAfter,
+		report:     report,
+		events:     events,
+		progress:   StepProgress{Step: n, Total: total, Cmd: label, StartedAt: now, LastOutput: now},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if report != nil {
+		report(m.progress)
+	}
+	if events != nil {
+		events(StepEvent{Kind: EventStepStart, At: now, Step: n, Total: total, Cmd: label})
+	}
+	tick := interval
+	if tick <= 0 || (stallAfter > 0 && stallAfter < tick) {
+		tick = stallAfter
+	}
+	if tick <= 0 {
+		close(m.done)
+		return m
+	}
+	go m.watch(tick)
+	return m
+}
+
+func (m *stepMonitor) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(p) > 0 {
+		m.progress.LastOutput = time.Now()
+		m.progress.Stalled = false
+		if m.events != nil {
+			m.events(StepEvent{Kind: EventOutput, At: m.progress.LastOutput, Step: m.progress.Step, Total: m.progress.Total, Cmd: m.progress.Cmd, Output: string(p)})
+		}
+	}
+	return m.w.Write(p)
+}
+
+func (m *stepMonitor) watch(tick time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	lastBeat := time.Now()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			silent := now.Sub(m.progress.LastOutput)
+			stalledNow := m.stallAfter > 0 && !m.progress.Stalled && silent >= m.stallAfter
+			beat := m.interval > 0 && now.Sub(lastBeat) >= m.interval
+			if stalledNow {
+				m.progress.Stalled = true
+				m.stalled = true
+				fmt.Fprintf(m.w, "[heartbeat] step %d stalled: no output for %s\n", m.progress.Step, silent.Round(time.Second))
+			} else if beat {
+				fmt.Fprintf(m.w, "[heartbeat] step %d running for %s, last output %s ago\n", m.progress.Step, now.Sub(m.progress.StartedAt).Round(time.Second), silent.Round(time.Second))
+			}
+			progress := m.progress
+			m.mu.Unlock()
+			if beat || stalledNow {
+				lastBeat = now
+				if m.report != nil {
+					m.report(progress)
+				}
+			}
+		}
+	}
+}
+
+// finish stops the heartbeat, reports the step as finished with exitCode
+// and returns whether it stalled at any point.
+func (m *stepMonitor) finish(exitCode int) bool {
+	select {
+	case <-m.done:
+	default:
+		close(m.stop)
+		<-m.done
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.events != nil {
+		now := time.Now()
+		m.events(StepEvent{Kind: EventStepFinish, At: now, Step: m.progress.Step, Total: m.progress.Total, Cmd: m.progress.Cmd, ExitCode: exitCode, DurationSec: now.Sub(m.progress.StartedAt).Seconds()})
+	}
+	return m.stalled
+}
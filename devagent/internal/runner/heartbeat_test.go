@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+func TestStepMonitorTellsSlowFromHung(t *testing.T) {
+	var (
+		log     bytes.Buffer
+		mu      sync.Mutex
+		reports []StepProgress
+	)
+	m := startMonitor(&log, 20*time.Millisecond, 150*time.Millisecond, func(p StepProgress) {
+		mu.Lock()
+		reports = append(reports, p)
+		mu.Unlock()
+	}, nil, 3, 7, "make test")
+	// Slow: output keeps coming, so the step never stalls.
+	for i := 0; i < 10; i++ {
+		m.Write([]byte("ok\n"))
+		time.Sleep(30 * time.Millisecond)
+	}
+	mu.Lock()
+	for _, p := range reports {
+		if p.Stalled {
+			t.Fatalf("step writing output was reported stalled: %+v", p)
+		}
+	}
+	mu.Unlock()
+	// Hung: no output at all.
+	time.Sleep(300 * time.Millisecond)
+	if !m.finish(0) {
+		t.Fatal("expected the silent step to be flagged as stalled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) == 0 || reports[0].Step != 3 || reports[0].Total != 7 || reports[0].Cmd != "make test" {
+		t.Fatalf("expected the step to be reported when it starts, got %+v", reports)
+	}
+	if last := reports[len(reports)-1]; !last.Stalled {
+		t.Fatalf("expected the stall to be reported, got %+v", last)
+	}
+	out := log.String()
+	if !strings.Contains(out, "[heartbeat] step 3 running for") || strings.Count(out, "[heartbeat] step 3 stalled") != 1 {
+		t.Fatalf("unexpected heartbeat lines:\n%s", out)
+	}
+}
+
+func TestRunFlagsStalledSteps(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{
+		Name:  "nightly",
+		Repo:  repo,
+		Logs:  &dsl.Logs{Heartbeat: "200ms", StalledAfter: "500ms"},
+		Steps: []dsl.Step{{Run: "sleep 2"}},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Steps[0].Stalled {
+		t.Fatalf("expected the silent step to be flagged, got %+v", summary.Steps[0])
+	}
+	runLog, err := os.ReadFile(filepath.Join(summary.RunDir, "run.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(runLog), "[heartbeat] step 1 stalled: no output for") {
+		t.Fatalf("missing heartbeat lines in run.log:\n%s", runLog)
+	}
+	stepLog, err := os.ReadFile(filepath.Join(summary.RunDir, "step-1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stepLog), "[heartbeat]") {
+		t.Fatalf("heartbeat lines belong in run.log only:\n%s", stepLog)
+	}
+}
+
+func TestRunStreamsStepEvents(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Steps: []dsl.Step{
+		{Run: "echo hello"},
+		{Run: "exit 3"},
+	}}
+	var events []StepEvent
+	if _, err := Run(context.Background(), Options{Workflow: wf, Events: func(ev StepEvent) {
+		events = append(events, ev)
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var output string
+	for _, ev := range events {
+		if ev.Total != 2 {
+			t.Fatalf("event without the step count: %+v", ev)
+		}
+		if ev.Kind == EventOutput {
+			output += ev.Output
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s %d %d", ev.Kind, ev.Step, ev.ExitCode))
+	}
+	want := []string{"step_start 1 0", "step_finish 1 0", "step_start 2 0", "step_finish 2 3"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") || !strings.Contains(output, "hello\n") {
+		t.Fatalf("events %q with output %q, want %q", got, output, want)
+	}
+}
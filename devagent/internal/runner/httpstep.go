@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+// defaultHTTPTimeout bounds http steps that set no timeout.
+const defaultHTTPTimeout = 30 * time.Second
+
+// maxLoggedBody is how much of a response body an http step logs; save_to
+// receives all of it.
+const maxLoggedBody = 16 << 10
+
+// httpMethod returns the method of an http step: GET, or POST when the step
+// has a body.
+func httpMethod(h *dsl.HTTP) string {
+	switch {
+	case h.Method != "":
+		return strings.ToUpper(h.Method)
+	case h.Body != "":
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+// sensitiveHeader reports whether a header carries credentials and must not
+// be logged.
+func sensitiveHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie":
+		return true
+	}
+	return secretName(name)
+}
+
+// runHTTP performs an http step, logging the request and response to w and
+// logPath like a shell step's output. A request that fails or gets an
+// unexpected status gives exit code 1; errors are reserved for local
+// problems such as an unwritable log.
+func runHTTP(ctx context.Context, h *dsl.HTTP, workdir string, extra extraEnv, w io.Writer, logPath string, logs *dsl.Logs) (int, error) {
+	stepLog, err := openLog(logPath, logs)
+	if err != nil {
+		return 0, err
+	}
+	defer stepLog.Close()
+
+	vars := make(map[string]string)
+	for _, kv := range append(sanitizedEnv(), extra.vars...) {
+		key, value, _ := strings.Cut(kv, "=")
+		vars[key] = value
+	}
+	expand := func(text string) string {
+		return os.Expand(text, func(key string) string { return vars[key] })
+	}
+
+	secrets := extra.secrets
+	headers := make(http.Header)
+	for name, value := range h.Headers {
+		value = expand(value)
+		headers.Set(name, value)
+		if sensitiveHeader(name) {
+			secrets = append(secrets, value)
+			// Also catch the credential alone, e.g. echoed back without
+			// its "Bearer" scheme.
+			if fields := strings.Fields(value); len(fields) > 1 && len(fields[len(fields)-1]) >= minRedactedValue {
+				secrets = append(secrets, fields[len(fields)-1])
+			}
+		}
+	}
+	out := newRedactingWriter(io.MultiWriter(w, stepLog), secrets...)
+	defer out.Flush()
+
+	timeout := defaultHTTPTimeout
+	if d, err := time.ParseDuration(h.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	method := httpMethod(h)
+	req, err := http.NewRequestWithContext(reqCtx, method, expand(strings.TrimSpace(h.URL)), strings.NewReader(expand(h.Body)))
+	if err != nil {
+		fmt.Fprintf(out, "invalid request: %v\n", err)
+		return 1, nil
+	}
+	req.Header = headers
+	req.Header.Set("User-Agent", "devagent")
+
+	fmt.Fprintf(out, "> %s %s\n", method, req.URL.Redacted())
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := headers.Get(name)
+		if sensitiveHeader(name) {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(out, "> %s: %s\n", name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(out, "request failed: %v\n", err)
+		return 1, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	fmt.Fprintf(out, "< %s\n", resp.Status)
+	logged := body
+	if len(logged) > maxLoggedBody {
+		logged = logged[:maxLoggedBody]
+	}
+	out.Write(logged)
+	if len(logged) > 0 && !bytes.HasSuffix(logged, []byte("\n")) {
+		fmt.Fprintln(out)
+	}
+	if len(body) > len(logged) {
+		fmt.Fprintf(out, "(%d more bytes not logged)\n", len(body)-len(logged))
+	}
+	if err != nil {
+		fmt.Fprintf(out, "reading response failed: %v\n", err)
+		return 1, nil
+	}
+
+	if h.SaveTo != "" {
+		// confine resolves the symlinks validation cannot see.
+		path, err := confine(workdir, h.SaveTo)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0o755)
+		}
+		if err == nil {
+			err = os.WriteFile(path, body, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "saving response failed: %v\n", err)
+			return 1, nil
+		}
+	}
+
+	switch {
+	case h.ExpectStatus != 0 && resp.StatusCode != h.ExpectStatus:
+		fmt.Fprintf(out, "expected status %d, got %d\n", h.ExpectStatus, resp.StatusCode)
+		return 1, nil
+	case h.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299):
+		fmt.Fprintf(out, "expected a 2xx status, got %d\n", resp.StatusCode)
+		return 1, nil
+	}
+	return 0, nil
+}
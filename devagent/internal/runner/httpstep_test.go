@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunHTTPStep(t *testing.T) {
+	var gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"deployed":true,"echo":"`+gotAuth+`"}`)
+	}))
+	defer server.Close()
+
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, ".env"), []byte("HOOK_TOKEN=s3cr3t-token\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "deploy", Repo: repo, EnvFiles: []string{".env"},
+		Steps: []dsl.Step{{HTTP: &dsl.HTTP{
+			URL:     server.URL + "/hook",
+			Headers: map[string]string{"Authorization": "Bearer ${HOOK_TOKEN}"},
+			Body:    `{"ref":"main"}`,
+			SaveTo:  "out/response.json",
+		}}},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" || summary.Steps[0].Cmd != "http POST "+server.URL+"/hook" {
+		t.Fatalf("unexpected summary: %s %+v", summary.Status, summary.Steps)
+	}
+	if gotAuth != "Bearer s3cr3t-token" || gotBody != `{"ref":"main"}` {
+		t.Fatalf("expected the expanded header and body to be sent, got %q %q", gotAuth, gotBody)
+	}
+	saved, _ := os.ReadFile(filepath.Join(repo, "out", "response.json"))
+	if !strings.Contains(string(saved), `"deployed":true`) {
+		t.Fatalf("expected the response to be saved, got %q", saved)
+	}
+	log, err := os.ReadFile(filepath.Join(summary.RunDir, "step-1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(log), "s3cr3t-token") || !strings.Contains(string(log), "> Authorization: <redacted>") || !strings.Contains(string(log), "< 200 OK") {
+		t.Fatalf("expected the request and response to be logged with credentials redacted:\n%s", log)
+	}
+
+	wf.Steps = []dsl.Step{{HTTP: &dsl.HTTP{URL: server.URL + "/missing"}}, {Run: "touch ran"}}
+	summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "failed" || summary.Steps[0].ExitCode != 1 || len(summary.Steps) != 1 {
+		t.Fatalf("expected a 404 to fail the step, got %s %+v", summary.Status, summary.Steps)
+	}
+
+	wf.Steps = []dsl.Step{{HTTP: &dsl.HTTP{URL: server.URL + "/missing", ExpectStatus: 404}}}
+	if summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); err != nil || summary.Status != "success" {
+		t.Fatalf("expected expect_status to accept a 404, got %v %v", summary, err)
+	}
+}
+
+func TestRunHTTPStepConfined(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "response")
+	}))
+	defer server.Close()
+
+	repo, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(repo, "out")); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "deploy", Repo: repo,
+		Steps: []dsl.Step{{HTTP: &dsl.HTTP{URL: server.URL, SaveTo: "out/response"}}},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "failed" {
+		t.Fatalf("expected save_to through a symlink out of the repo to fail the step, got %s", summary.Status)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "response")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written outside the repo, got %v", err)
+	}
+
+	wf.Steps = []dsl.Step{{HTTP: &dsl.HTTP{URL: server.URL}}}
+	var policyErr *PolicyError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: denyPolicy(t, `127\.0\.0\.1`)}); !errors.As(err, &policyErr) {
+		t.Fatalf("expected the policy to deny the url, got %v", err)
+	}
+}
+
+// denyPolicy loads a user policy that denies commands matching pattern.
+func denyPolicy(t *testing.T, pattern string) *policy.Policy {
+	t.Helper()
+	t.Setenv("DEVAGENT_HOME", t.TempDir())
+	path, err := policy.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("deny:\n  - pattern: '"+pattern+"'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := policy.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
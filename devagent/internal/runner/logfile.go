@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"devagent/internal/dsl"
+)
+
+// ansiPattern matches CSI and OSC terminal escape sequences as well as the
+// remaining two-byte escapes.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+func stripANSI(p []byte) []byte {
+	return ansiPattern.ReplaceAll(p, nil)
+}
+
+// logFile writes a run or step log. Depending on the workflow's logs
+// settings it strips escape sequences and, once max_size is reached, either
+// rotates to numbered segments or drops further output behind a marker.
+type logFile struct {
+	path    string
+	f       *os.File
+	strip   bool
+	max     int64
+	rotate  int
+	size    int64
+	last    byte // the last byte written, to end a cut line
+	dropped int64
+	closed  bool
+}
+
+func openLog(path string, cfg *dsl.Logs) (*logFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &logFile{path: path, f: f, max: cfg.MaxBytes()}
+	if cfg != nil {
+		l.strip = cfg.StripANSI
+		l.rotate = cfg.Rotate
+	}
+	return l, nil
+}
+
+// Write always reports the full length of p so callers such as
+// io.MultiWriter keep going after output starts being dropped. Output that
+// would take the file past max_size is cut at max_size: the rest is
+// dropped or, with rotation, continued in a fresh segment.
+func (l *logFile) Write(p []byte) (int, error) {
+	n := len(p)
+	if l.strip {
+		p = stripANSI(p)
+	}
+	for l.max > 0 && l.size+int64(len(p)) > l.max {
+		if budget := l.max - l.size; budget > 0 {
+			if written, err := l.put(p[:budget]); err != nil {
+				return written, err
+			}
+			p = p[budget:]
+		}
+		if l.rotate == 0 {
+			if l.dropped == 0 {
+				note := fmt.Sprintf("[devagent: log reached max_size of %d bytes; further output is truncated]\n", l.max)
+				if l.size > 0 && l.last != '\n' {
+					note = "\n" + note
+				}
+				if _, err := l.put([]byte(note)); err != nil {
+					return 0, err
+				}
+			}
+			l.dropped += int64(len(p))
+			return n, nil
+		}
+		if err := l.rotateSegments(); err != nil {
+			return 0, err
+		}
+	}
+	if written, err := l.put(p); err != nil {
+		return written, err
+	}
+	return n, nil
+}
+
+// put writes p to the current file.
+func (l *logFile) put(p []byte) (int, error) {
+	written, err := l.f.Write(p)
+	l.size += int64(written)
+	if written > 0 {
+		l.last = p[written-1]
+	}
+	return written, err
+}
+
+// rotateSegments shifts path.N-1 to path.N, ..., path to path.1 and starts
+// a fresh file that points at the previous segment.
+func (l *logFile) rotateSegments() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	for i := l.rotate; i > 1; i-- {
+		older := fmt.Sprintf("%s.%d", l.path, i-1)
+		if _, err := os.Stat(older); err == nil {
+			if err := os.Rename(older, fmt.Sprintf("%s.%d", l.path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	f, err := os.Create(l.path)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.size = 0
+	note := fmt.Sprintf("[devagent: log rotated at %d bytes; earlier output is in %s.1]\n", l.max, filepath.Base(l.path))
+	if _, err := l.put([]byte(note)); err != nil {
+		return err
+	}
+	// The note does not count towards max_size, so every segment has room
+	// for output however small max_size is.
+	l.size = 0
+	return nil
+}
+
+// Close records how much output was dropped, if any, and closes the file.
+func (l *logFile) Close() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.dropped > 0 {
+		_, _ = fmt.Fprintf(l.f, "[devagent: %d bytes of output were dropped]\n", l.dropped)
+	}
+	return l.f.Close()
+}
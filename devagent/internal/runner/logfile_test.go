@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestLogFileStripsANSIAndTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.log")
+	l, err := openLog(path, &dsl.Logs{StripANSI: true, MaxSize: "32B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"\x1b[32mok\x1b[0m line one\n", "line two is long enough\n", "dropped\n"} {
+		if n, err := l.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("write %q: n=%d err=%v", line, n, err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	got := string(data)
+	if strings.Contains(got, "\x1b") || !strings.HasPrefix(got, "ok line one\n") {
+		t.Fatalf("escape sequences not stripped: %q", got)
+	}
+	if !strings.HasPrefix(got, "ok line one\nline two is long eno\n[devagent: log reached max_size") {
+		t.Fatalf("expected output cut at max_size, got %q", got)
+	}
+	if !strings.Contains(got, "further output is truncated") || !strings.HasSuffix(got, "[devagent: 12 bytes of output were dropped]\n") {
+		t.Fatalf("missing truncation markers: %q", got)
+	}
+
+	l, err = openLog(path, &dsl.Logs{MaxSize: "16B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte(strings.Repeat("x", 100)))
+	l.Close()
+	data, _ = os.ReadFile(path)
+	if !strings.HasPrefix(string(data), strings.Repeat("x", 16)+"\n[devagent:") || !strings.Contains(string(data), "84 bytes of output were dropped") {
+		t.Fatalf("expected a single large write to be cut at max_size, got %q", data)
+	}
+}
+
+func TestLogFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.log")
+	l, err := openLog(path, &dsl.Logs{MaxSize: "16B", Rotate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first segment\n", "second segment\n", "third segment\n"} {
+		if _, err := l.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	current, _ := os.ReadFile(path)
+	previous, _ := os.ReadFile(path + ".1")
+	// output drops the notes devagent adds at the top of each segment.
+	output := func(segment []byte) string {
+		text := string(segment)
+		if strings.HasPrefix(text, "[devagent: log rotated") {
+			text = text[strings.Index(text, "\n")+1:]
+		}
+		if len(text) > 16 {
+			t.Fatalf("segment holds more than max_size: %q", segment)
+		}
+		return text
+	}
+	if !strings.Contains(string(current), "earlier output is in run.log.1") {
+		t.Fatalf("unexpected current segment: %q", current)
+	}
+	if got := output(previous) + output(current); got != "cond segment\nthird segment\n" {
+		t.Fatalf("expected the segments to hold the latest output in order, got %q", got)
+	}
+	if _, err := os.Stat(path + ".2"); err == nil {
+		t.Fatalf("rotate: 1 should keep a single older segment")
+	}
+}
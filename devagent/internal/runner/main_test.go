@@ -0,0 +1,15 @@
+package runner
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain runs script steps: they run in a process started from
+// os.Executable, which in tests is the test binary.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == ScriptStepCommand {
+		os.Exit(ScriptStepMain(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
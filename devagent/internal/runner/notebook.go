@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// notebookCommand builds the shell command executing nb into outPath. It
+// prefers papermill, which supports parameters, and falls back to
+// `jupyter nbconvert --execute` when papermill is not installed.
+func notebookCommand(nb *dsl.Notebook, outPath string) string {
+	in := shellQuote(nb.Path)
+	out := shellQuote(outPath)
+
+	papermill := []string{"papermill", in, out}
+	if nb.Kernel != "" {
+		papermill = append(papermill, "-k", shellQuote(nb.Kernel))
+	}
+	keys := make([]string, 0, len(nb.Parameters))
+	for key := range nb.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		papermill = append(papermill, "-p", shellQuote(key), shellQuote(nb.Parameters[key]))
+	}
+
+	nbconvert := []string{"jupyter", "nbconvert", "--to", "notebook", "--execute", "--output", out}
+	if nb.Kernel != "" {
+		nbconvert = append(nbconvert, shellQuote("--ExecutePreprocessor.kernel_name="+nb.Kernel))
+	}
+	nbconvert = append(nbconvert, in)
+	fallback := strings.Join(nbconvert, " ")
+	if len(keys) > 0 {
+		fallback = "echo 'papermill not found; running notebook without parameters' >&2; " + fallback
+	}
+
+	return fmt.Sprintf("if command -v papermill >/dev/null 2>&1; then %s; else %s; fi", strings.Join(papermill, " "), fallback)
+}
+
+type notebookFile struct {
+	Cells []struct {
+		CellType string `json:"cell_type"`
+		Outputs  []struct {
+			OutputType string                     `json:"output_type"`
+			Text       json.RawMessage            `json:"text"`
+			Data       map[string]json.RawMessage `json:"data"`
+			Ename      string                     `json:"ename"`
+			Evalue     string                     `json:"evalue"`
+		} `json:"outputs"`
+	} `json:"cells"`
+}
+
+// extractNotebookOutputs writes the text outputs of an executed notebook to
+// <base>.outputs.txt and any PNG images to separate files in runDir. It
+// returns the artifact file names relative to runDir.
+func extractNotebookOutputs(executed, runDir, base string) ([]string, error) {
+	data, err := os.ReadFile(executed)
+	if err != nil {
+		return nil, err
+	}
+	var nb notebookFile
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, fmt.Errorf("parse executed notebook: %w", err)
+	}
+
+	var (
+		text      strings.Builder
+		artifacts []string
+	)
+	for i, cell := range nb.Cells {
+		if cell.CellType != "code" || len(cell.Outputs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&text, "## cell %d\n", i+1)
+		for j, output := range cell.Outputs {
+			switch output.OutputType {
+			case "stream":
+				text.WriteString(notebookText(output.Text))
+			case "error":
+				fmt.Fprintf(&text, "%s: %s\n", output.Ename, output.Evalue)
+			default:
+				if plain, ok := output.Data["text/plain"]; ok {
+					text.WriteString(notebookText(plain))
+					text.WriteString("\n")
+				}
+				if png, ok := output.Data["image/png"]; ok {
+					name := fmt.Sprintf("%s-cell%d-%d.png", base, i+1, j+1)
+					if err := writeNotebookImage(filepath.Join(runDir, name), png); err == nil {
+						artifacts = append(artifacts, name)
+					}
+				}
+			}
+		}
+	}
+
+	name := base + ".outputs.txt"
+	if err := os.WriteFile(filepath.Join(runDir, name), []byte(redact(text.String())), 0o644); err != nil {
+		return artifacts, err
+	}
+	return append([]string{name}, artifacts...), nil
+}
+
+// notebookText decodes nbformat multiline strings, which may be stored as a
+// single string or a list of lines.
+func notebookText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return single
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err == nil {
+		return strings.Join(lines, "")
+	}
+	return ""
+}
+
+func writeNotebookImage(path string, raw json.RawMessage) error {
+	encoded := strings.Join(strings.Fields(notebookText(raw)), "")
+	img, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, img, 0o644)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestExtractNotebookOutputs(t *testing.T) {
+	dir := t.TempDir()
+	executed := filepath.Join(dir, "step-1-report.executed.ipynb")
+	nb := `{"cells": [
+  {"cell_type": "markdown", "source": ["# Title"]},
+  {"cell_type": "code", "outputs": [
+    {"output_type": "stream", "name": "stdout", "text": ["rows: 42\n"]},
+    {"output_type": "execute_result", "data": {"text/plain": "0.97", "image/png": "iVBORw0KGgo="}}
+  ]}
+]}`
+	if err := os.WriteFile(executed, []byte(nb), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts, err := extractNotebookOutputs(executed, dir, "step-1-report")
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if len(artifacts) != 2 || artifacts[0] != "step-1-report.outputs.txt" || artifacts[1] != "step-1-report-cell2-2.png" {
+		t.Fatalf("unexpected artifacts: %v", artifacts)
+	}
+	text, err := os.ReadFile(filepath.Join(dir, artifacts[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(text), "rows: 42") || !strings.Contains(string(text), "0.97") {
+		t.Fatalf("outputs missing expected text: %s", text)
+	}
+}
+
+func TestNotebookCommandParameters(t *testing.T) {
+	cmd := notebookCommand(&dsl.Notebook{Path: "report.ipynb", Parameters: map[string]string{"date": "today", "limit": "10"}}, "/tmp/out.ipynb")
+	if !strings.Contains(cmd, "papermill 'report.ipynb' '/tmp/out.ipynb' -p 'date' 'today' -p 'limit' '10'") {
+		t.Fatalf("unexpected papermill invocation: %s", cmd)
+	}
+	if !strings.Contains(cmd, "jupyter nbconvert") {
+		t.Fatalf("missing nbconvert fallback: %s", cmd)
+	}
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/plugin"
+)
+
+// checkPlugins makes sure the plugin of every plugin step is installed, so
+// a missing one fails the run before any step starts.
+func checkPlugins(steps ...[]dsl.Step) error {
+	for _, list := range steps {
+		for _, step := range list {
+			if step.Plugin == "" {
+				continue
+			}
+			if _, err := plugin.Find(plugin.Step, step.Plugin); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pluginLabel names a plugin step with the keys of its settings, sorted so
+// the label identifies the step across runs. The values are left out since
+// they can hold secrets and the label is logged and stored.
+func pluginLabel(name string, with map[string]string) string {
+	keys := make([]string, 0, len(with))
+	for key := range with {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	label := "plugin " + name
+	if len(keys) > 0 {
+		label += " with " + strings.Join(keys, ", ")
+	}
+	return label
+}
+
+// settingsHash folds a plugin step's settings into hash, the hash of its
+// skip_unless_changed inputs, since its label leaves their values out.
+func settingsHash(hash string, with map[string]string) string {
+	keys := make([]string, 0, len(with))
+	for key := range with {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sum := sha256.New()
+	sum.Write([]byte(hash))
+	for _, key := range keys {
+		fmt.Fprintf(sum, "\x00%s=%s", key, with[key])
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// pluginCommand writes the request of a plugin step to requestPath and
+// returns the shell command that runs the plugin with it.
+func pluginCommand(name string, req plugin.StepRequest, requestPath string) (string, error) {
+	path, err := plugin.Find(plugin.Step, name)
+	if err != nil {
+		return "", err
+	}
+	req.Protocol = plugin.Protocol
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(requestPath, data, 0o644); err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("DEVAGENT_PLUGIN_PROTOCOL=%d", plugin.Protocol),
+		shellQuote(path),
+		shellQuote(requestPath),
+	}, " "), nil
+}
@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/plugin"
+	"devagent/internal/policy"
+)
+
+func TestRunPluginStep(t *testing.T) {
+	t.Setenv("DEVAGENT_HOME", t.TempDir())
+	dir, err := plugin.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// The plugin records its request and protocol, publishes an output and
+	// exits with the code it is given.
+	script := `#!/bin/sh
+cp "$1" request.json
+echo "protocol $DEVAGENT_PLUGIN_PROTOCOL"
+echo "planned=3" >> "$DEVAGENT_OUTPUT"
+exit $(sed -n 's/.*"exit": "\([0-9]*\)".*/\1/p' "$1")
+`
+	if err := os.WriteFile(filepath.Join(dir, "devagent-step-plan"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "infra", Repo: repo, Steps: []dsl.Step{{Plugin: "plan", With: map[string]string{"exit": "0", "dir": "infra"}}}}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" || summary.Outputs["planned"] != "3" {
+		t.Fatalf("got %s with outputs %v", summary.Status, summary.Outputs)
+	}
+	if label := summary.Steps[0].Cmd; label != "plugin plan with dir, exit" {
+		t.Fatalf("step recorded as %q", label)
+	}
+	var req plugin.StepRequest
+	data, _ := os.ReadFile(filepath.Join(repo, "request.json"))
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Protocol != plugin.Protocol || req.Job != "infra" || req.Step != 1 || req.Workdir != repo || req.With["dir"] != "infra" {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	log, _ := os.ReadFile(filepath.Join(summary.RunDir, "step-1.log"))
+	if !strings.Contains(string(log), "protocol 1") {
+		t.Fatalf("plugin output not logged:\n%s", log)
+	}
+
+	wf.Steps[0].With["exit"] = "3"
+	if summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); err != nil || summary.Status != "failed" || summary.Steps[0].ExitCode != 3 {
+		t.Fatalf("expected the plugin's exit code to fail the step, got %v %+v", err, summary)
+	}
+
+	wf.Steps[0].Plugin = "missing"
+	var configErr *ConfigError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); !errors.As(err, &configErr) || !strings.Contains(err.Error(), "devagent-step-missing") {
+		t.Fatalf("expected a config error naming the missing plugin, got %v", err)
+	}
+}
+
+func TestSettingsHash(t *testing.T) {
+	a := settingsHash("inputs", map[string]string{"dir": "infra", "token": "one"})
+	if a != settingsHash("inputs", map[string]string{"token": "one", "dir": "infra"}) {
+		t.Fatal("the hash depends on the order of the settings")
+	}
+	if a == settingsHash("inputs", map[string]string{"dir": "infra", "token": "two"}) || a == settingsHash("other", map[string]string{"dir": "infra", "token": "one"}) {
+		t.Fatal("a changed setting or input should change the hash")
+	}
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/sysload"
+)
+
+// remoteTimeout bounds the reachability check of preconditions.remote.
+const remoteTimeout = 30 * time.Second
+
+// checkPreconditions returns a description of every precondition that does
+// not hold in repo.
+func checkPreconditions(ctx context.Context, p *dsl.Preconditions, repo string) []string {
+	if p == nil {
+		return nil
+	}
+	var unmet []string
+	if p.Clean {
+		// Run directories live in the repo and must not count as edits.
+		status, err := gitIn(ctx, repo, "status", "--porcelain", "--", ".", ":(exclude)devagent_runs")
+		switch {
+		case err != nil:
+			unmet = append(unmet, fmt.Sprintf("cannot check for uncommitted changes: %v", err))
+		case status != "":
+			unmet = append(unmet, fmt.Sprintf("working tree has uncommitted changes (%d files)", len(strings.Split(status, "\n"))))
+		}
+	}
+	if p.Branch != "" {
+		branch, err := gitIn(ctx, repo, "rev-parse", "--abbrev-ref", "HEAD")
+		switch {
+		case err != nil:
+			unmet = append(unmet, fmt.Sprintf("cannot read the current branch: %v", err))
+		case branch != p.Branch:
+			unmet = append(unmet, fmt.Sprintf("on branch %s, expected %s", branch, p.Branch))
+		}
+	}
+	if p.Remote != "" {
+		remoteCtx, cancel := context.WithTimeout(ctx, remoteTimeout)
+		_, err := gitIn(remoteCtx, repo, "ls-remote", "--quiet", p.Remote, "HEAD")
+		cancel()
+		if err != nil {
+			unmet = append(unmet, fmt.Sprintf("remote %s unreachable: %v", p.Remote, err))
+		}
+	}
+	if min, _ := dsl.ParseSize(p.MinFreeDisk); min > 0 {
+		free, err := sysload.FreeDisk(repo)
+		switch {
+		case err != nil:
+			unmet = append(unmet, fmt.Sprintf("cannot read free disk space: %v", err))
+		case free < min:
+			unmet = append(unmet, fmt.Sprintf("only %d MB free on %s (needs %s)", free>>20, repo, p.MinFreeDisk))
+		}
+	}
+	return unmet
+}
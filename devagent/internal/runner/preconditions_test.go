@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestRunStopsWhenPreconditionsFail(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+
+	wf := &dsl.Workflow{Name: "release", Repo: repo,
+		Preconditions: &dsl.Preconditions{Clean: true, Branch: "main"},
+		Steps:         []dsl.Step{{Run: "touch ran"}},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" {
+		t.Fatalf("preconditions hold (run directories do not count as edits), got %s %v", summary.Status, summary.Unmet)
+	}
+	git("add", "ran")
+	git("commit", "-q", "-m", "ran")
+
+	if err := os.WriteFile(filepath.Join(repo, "wip.txt"), []byte("draft"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf.Preconditions.Branch = "release"
+	wf.Preconditions.Remote = "nowhere"
+	wf.Steps = []dsl.Step{{Run: "touch second"}}
+	summary, err = Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != StatusPreconditionFailed || len(summary.Unmet) != 3 {
+		t.Fatalf("expected dirty tree, branch and remote to be reported, got %s %v", summary.Status, summary.Unmet)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "second")); err == nil {
+		t.Fatal("no step should run when a precondition fails")
+	}
+	if summary.FailureTail() == "" {
+		t.Fatal("the unmet preconditions should be reported as the failure tail")
+	}
+}
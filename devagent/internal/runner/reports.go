@@ -0,0 +1,349 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// maxFailedTests caps the failed test names recorded in the summary.
+const maxFailedTests = 20
+
+// TestResults totals the test reports and coverage files listed in the
+// workflow's outputs.reports.
+type TestResults struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	// Coverage is the covered share of statements or lines in percent,
+	// when a coverage report was found.
+	Coverage *float64 `json:"coverage,omitempty"`
+}
+
+// metric returns the value of a fail_if metric, if the reports provided it.
+func (t *TestResults) metric(name string) (float64, bool) {
+	if t == nil {
+		return 0, false
+	}
+	switch name {
+	case "coverage":
+		if t.Coverage == nil {
+			return 0, false
+		}
+		return *t.Coverage, true
+	case "tests_failed":
+		return float64(t.Failed), true
+	case "tests_passed":
+		return float64(t.Passed), true
+	case "tests_skipped":
+		return float64(t.Skipped), true
+	case "tests_total":
+		return float64(t.Passed + t.Failed + t.Skipped), true
+	}
+	return 0, false
+}
+
+// failIf returns the fail_if conditions that hold for tests, each with the
+// value it saw. A metric no report provided counts as holding, so a tool
+// that died before writing its report cannot pass the gate.
+func failIf(conditions []string, tests *TestResults) []string {
+	var held []string
+	for _, expr := range conditions {
+		cond, err := dsl.ParseCondition(expr)
+		if err != nil {
+			held = append(held, err.Error())
+			continue
+		}
+		value, ok := tests.metric(cond.Metric)
+		switch {
+		case !ok:
+			held = append(held, fmt.Sprintf("%s (no report provided %s)", strings.TrimSpace(expr), cond.Metric))
+		case cond.Holds(value):
+			seen := strconv.FormatFloat(value, 'f', -1, 64)
+			if cond.Metric == "coverage" {
+				seen = fmt.Sprintf("%.1f%%", value)
+			}
+			held = append(held, fmt.Sprintf("%s (%s is %s)", strings.TrimSpace(expr), cond.Metric, seen))
+		}
+	}
+	return held
+}
+
+// reportTotals accumulates the parsed reports of a run.
+type reportTotals struct {
+	TestResults
+	failures           []string
+	covered, coverable float64
+	found              bool
+}
+
+// collectReports parses the files matched by patterns, relative to
+// workdir. Each file's format is detected from its content: JUnit XML,
+// `go test -json` output, a Go coverage profile, Cobertura XML or LCOV.
+// Problems are reported to w and never fail the run; nil means no report
+// was found.
+func collectReports(w io.Writer, workdir string, patterns []string) (*TestResults, []string) {
+	var totals reportTotals
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(workdir, pattern))
+		if err != nil {
+			fmt.Fprintf(w, "report %s: %v\n", pattern, err)
+			continue
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			data, err := readRunFile(path)
+			if err == nil {
+				err = totals.parse(data)
+			}
+			if err != nil {
+				fmt.Fprintf(w, "report %s: %v\n", relOrBase(workdir, path), err)
+			}
+		}
+	}
+	if !totals.found {
+		return nil, nil
+	}
+	if totals.coverable > 0 {
+		pct := totals.covered / totals.coverable * 100
+		totals.Coverage = &pct
+	}
+	return &totals.TestResults, totals.failures
+}
+
+func relOrBase(dir, path string) string {
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		return rel
+	}
+	return filepath.Base(path)
+}
+
+// parse detects the format of data and adds it to the totals.
+func (t *reportTotals) parse(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return t.parseGoCover(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return t.parseGoTestJSON(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return t.parseXML(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")):
+		return t.parseLCOV(trimmed)
+	}
+	return errors.New("unrecognized format; expected JUnit XML, go test -json, a Go coverage profile, Cobertura XML or LCOV")
+}
+
+func (t *reportTotals) failed(name string) {
+	t.Failed++
+	if len(t.failures) < maxFailedTests {
+		t.failures = append(t.failures, name)
+	}
+}
+
+// parseXML handles JUnit (<testsuites> or <testsuite>) and Cobertura
+// (<coverage>) reports.
+func (t *reportTotals) parseXML(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var (
+		root     string
+		inCase   bool
+		caseName string
+		outcome  string
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			if root == "" {
+				root = el.Name.Local
+				switch root {
+				case "coverage":
+					return t.addCobertura(el)
+				case "testsuites", "testsuite":
+				default:
+					return fmt.Errorf("unrecognized XML report <%s>", root)
+				}
+			}
+			switch el.Name.Local {
+			case "testcase":
+				inCase, outcome = true, "passed"
+				caseName = xmlAttr(el, "name")
+				if class := xmlAttr(el, "classname"); class != "" {
+					caseName = class + "." + caseName
+				}
+			case "failure", "error":
+				if inCase {
+					outcome = "failed"
+				}
+			case "skipped":
+				if inCase && outcome != "failed" {
+					outcome = "skipped"
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Local != "testcase" || !inCase {
+				continue
+			}
+			inCase = false
+			t.found = true
+			switch outcome {
+			case "failed":
+				t.failed(caseName)
+			case "skipped":
+				t.Skipped++
+			default:
+				t.Passed++
+			}
+		}
+	}
+	if root == "" {
+		return errors.New("empty XML report")
+	}
+	t.found = true
+	return nil
+}
+
+// addCobertura adds the line coverage from a Cobertura root element.
+func (t *reportTotals) addCobertura(el xml.StartElement) error {
+	valid, errValid := strconv.ParseFloat(xmlAttr(el, "lines-valid"), 64)
+	covered, errCovered := strconv.ParseFloat(xmlAttr(el, "lines-covered"), 64)
+	if errValid == nil && errCovered == nil {
+		t.coverable += valid
+		t.covered += covered
+		t.found = true
+		return nil
+	}
+	rate, err := strconv.ParseFloat(xmlAttr(el, "line-rate"), 64)
+	if err != nil {
+		return errors.New("cobertura report has no line-rate")
+	}
+	// Without line counts, weigh the report as if it had 100 lines.
+	t.coverable += 100
+	t.covered += rate * 100
+	t.found = true
+	return nil
+}
+
+func xmlAttr(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// parseGoTestJSON counts the pass, fail and skip events of `go test -json`
+// output, subtests included.
+func (t *reportTotals) parseGoTestJSON(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var event struct {
+			Action  string
+			Package string
+			Test    string
+		}
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+		if event.Test == "" {
+			continue
+		}
+		switch event.Action {
+		case "pass":
+			t.Passed++
+		case "fail":
+			t.failed(event.Package + "." + event.Test)
+		case "skip":
+			t.Skipped++
+		default:
+			continue
+		}
+		t.found = true
+	}
+	return scanner.Err()
+}
+
+// parseGoCover adds the statement coverage of a Go coverage profile. Blocks
+// listed more than once, as in merged profiles, count once.
+func (t *reportTotals) parseGoCover(data []byte) error {
+	type block struct {
+		stmts   int
+		covered bool
+	}
+	blocks := make(map[string]block)
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		stmts, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("bad coverage line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("bad coverage line %q", line)
+		}
+		b := blocks[fields[0]]
+		b.stmts = stmts
+		b.covered = b.covered || count > 0
+		blocks[fields[0]] = b
+	}
+	for _, b := range blocks {
+		t.coverable += float64(b.stmts)
+		if b.covered {
+			t.covered += float64(b.stmts)
+		}
+	}
+	t.found = true
+	return nil
+}
+
+// parseLCOV adds the line coverage of an LCOV tracefile.
+func (t *reportTotals) parseLCOV(data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || (key != "LF" && key != "LH") {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("bad lcov line %q", line)
+		}
+		if key == "LF" {
+			t.coverable += n
+		} else {
+			t.covered += n
+		}
+	}
+	t.found = true
+	return nil
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestCollectReports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"reports/junit-a.xml": `<?xml version="1.0"?>
+<testsuites>
+  <testsuite name="api">
+    <testcase classname="api" name="test_ok"/>
+    <testcase classname="api" name="test_broken"><failure message="boom"/></testcase>
+    <testcase classname="api" name="test_later"><skipped/></testcase>
+  </testsuite>
+</testsuites>`,
+		"reports/junit-b.xml": `<testsuite name="cli"><testcase name="test_cli"/></testsuite>`,
+		"go-test.json": `{"Action":"run","Package":"example/pkg","Test":"TestA"}
+{"Action":"pass","Package":"example/pkg","Test":"TestA"}
+{"Action":"fail","Package":"example/pkg","Test":"TestB"}
+{"Action":"pass","Package":"example/pkg"}`,
+		"cover.out": `mode: set
+example/pkg/a.go:1.1,3.2 3 1
+example/pkg/a.go:4.1,6.2 1 0
+example/pkg/a.go:1.1,3.2 3 0`,
+		"lcov.info": "TN:\nSF:src/index.js\nLF:4\nLH:1\nend_of_record\n",
+		"notes.txt": "not a report",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var log strings.Builder
+	tests, failed := collectReports(&log, dir, []string{"reports/*.xml", "go-test.json", "cover.out", "lcov.info", "notes.txt", "missing.xml"})
+	if tests == nil {
+		t.Fatal("expected test results")
+	}
+	if tests.Passed != 3 || tests.Failed != 2 || tests.Skipped != 1 {
+		t.Fatalf("unexpected counts %+v", tests)
+	}
+	if strings.Join(failed, ",") != "api.test_broken,example/pkg.TestB" {
+		t.Fatalf("unexpected failed tests %q", failed)
+	}
+	// 3 of 4 statements plus 1 of 4 lines.
+	if tests.Coverage == nil || *tests.Coverage != 50 {
+		t.Fatalf("expected 50%% coverage, got %v", tests.Coverage)
+	}
+	if !strings.Contains(log.String(), "report notes.txt: unrecognized format") {
+		t.Fatalf("expected a warning for the unrecognized file, got %q", log.String())
+	}
+}
+
+func TestCollectReportsCobertura(t *testing.T) {
+	dir := t.TempDir()
+	report := `<?xml version="1.0" ?><coverage line-rate="0.8" lines-valid="200" lines-covered="150"><packages/></coverage>`
+	if err := os.WriteFile(filepath.Join(dir, "coverage.xml"), []byte(report), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests, _ := collectReports(io.Discard, dir, []string{"coverage.xml"})
+	if tests == nil || tests.Coverage == nil || *tests.Coverage != 75 {
+		t.Fatalf("expected 75%% coverage, got %+v", tests)
+	}
+	if tests, _ := collectReports(io.Discard, dir, []string{"none-*.xml"}); tests != nil {
+		t.Fatalf("expected no results without reports, got %+v", tests)
+	}
+}
+
+func TestFailIfFlipsSuccessfulRun(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "gated", Repo: repo,
+		Steps: []dsl.Step{
+			// A tool that swallows failures: it exits 0 with a failed test.
+			{Run: `printf '{"Action":"pass","Test":"TestA"}\n{"Action":"fail","Test":"TestB"}\n' > go-test.json; printf 'mode: set\na.go:1.1,2.2 1 1\n' > cover.out`},
+		},
+		Outputs: &dsl.Outputs{Reports: []string{"go-test.json", "cover.out"}},
+		FailIf:  dsl.Conditions{"coverage < 80", "tests_failed > 0"},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "failed" || len(summary.FailedIf) != 1 || summary.FailedIf[0] != "tests_failed > 0 (tests_failed is 1)" {
+		t.Fatalf("expected the tests_failed gate to fail the run, got %s %q", summary.Status, summary.FailedIf)
+	}
+	if tail := summary.FailureTail(); tail != "fail_if: tests_failed > 0 (tests_failed is 1)" {
+		t.Fatalf("unexpected failure tail %q", tail)
+	}
+}
+
+func TestFailIfMissingMetricHolds(t *testing.T) {
+	held := failIf([]string{"coverage < 80", "tests_failed > 0"}, &TestResults{Passed: 3})
+	if len(held) != 1 || held[0] != "coverage < 80 (no report provided coverage)" {
+		t.Fatalf("expected missing coverage to fail the gate, got %q", held)
+	}
+	if held := failIf([]string{"tests_total == 0"}, nil); len(held) != 1 {
+		t.Fatalf("expected no reports to fail the gate, got %q", held)
+	}
+}
@@ -0,0 +1,196 @@
+package runner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"devagent/internal/dsl"
+)
+
+// stepUser is the OS user, groups and umask steps run with. A nil stepUser
+// runs steps as the daemon's own user.
+type stepUser struct {
+	// cred is nil when only the umask changes.
+	cred  *syscall.Credential
+	name  string
+	home  string
+	umask string
+}
+
+// newStepUser resolves cfg. It fails rather than running the steps with
+// the daemon's privileges when the user or groups cannot be switched to.
+func newStepUser(cfg *dsl.RunAs) (*stepUser, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	mask, hasMask, err := cfg.UmaskValue()
+	if err != nil {
+		return nil, err
+	}
+	su := &stepUser{}
+	if hasMask {
+		su.umask = fmt.Sprintf("%03o", mask)
+	}
+	if cfg.User == "" && len(cfg.Groups) == 0 {
+		return su, nil
+	}
+
+	var u *user.User
+	if cfg.User != "" {
+		u, err = lookupUser(cfg.User)
+	} else {
+		u, err = user.Current()
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run_as: user %s has a non-numeric uid %s", u.Username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run_as: user %s has a non-numeric gid %s", u.Username, u.Gid)
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("run_as: groups of %s: %w", u.Username, err)
+	}
+	for _, name := range cfg.Groups {
+		g, err := lookupGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		groupIDs = append(groupIDs, g.Gid)
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	seen := make(map[uint32]bool)
+	for _, id := range groupIDs {
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil || seen[uint32(n)] {
+			continue
+		}
+		seen[uint32(n)] = true
+		cred.Groups = append(cred.Groups, uint32(n))
+	}
+
+	if os.Geteuid() != 0 {
+		if cred.Uid == uint32(os.Geteuid()) && len(cfg.Groups) == 0 {
+			// Already the requested user: nothing to drop.
+			return su, nil
+		}
+		return nil, fmt.Errorf("run_as: switching to user %s or adding groups needs devagent to run as root", u.Username)
+	}
+	su.cred = cred
+	su.name = u.Username
+	su.home = u.HomeDir
+	return su, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("run_as: %w", err)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("run_as: %w", err)
+	}
+	return g, nil
+}
+
+// wrap prefixes command with the umask.
+func (su *stepUser) wrap(command string) string {
+	if su == nil || su.umask == "" {
+		return command
+	}
+	return "umask " + su.umask + "\n" + command
+}
+
+// apply makes cmd start as the step user, with its HOME, USER and LOGNAME.
+// It must run after cmd.Env and cmd.SysProcAttr are set.
+func (su *stepUser) apply(cmd *exec.Cmd) {
+	if su == nil || su.cred == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = su.cred
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	env := cmd.Env[:0:0]
+	for _, kv := range cmd.Env {
+		switch key, _, _ := strings.Cut(kv, "="); key {
+		case "HOME", "USER", "LOGNAME":
+			continue
+		}
+		env = append(env, kv)
+	}
+	cmd.Env = append(env, "HOME="+su.home, "USER="+su.name, "LOGNAME="+su.name)
+}
+
+// own hands paths, recursively, to the step user so steps can write to
+// directories devagent created, such as the worktree.
+func (su *stepUser) own(paths ...string) error {
+	if su == nil || su.cred == nil {
+		return nil
+	}
+	uid, gid := int(su.cred.Uid), int(su.cred.Gid)
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, uid, gid)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("run_as: %w", err)
+		}
+	}
+	return nil
+}
+
+// writable creates files in the run directory that steps write, such as
+// DEVAGENT_OUTPUT, and hands them to the step user. The run directory
+// itself stays the daemon's, so a step cannot swap a file devagent writes
+// there for a symlink to one it must not touch.
+func (su *stepUser) writable(paths ...string) error {
+	if su == nil || su.cred == nil {
+		return nil
+	}
+	for _, path := range paths {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|syscall.O_NOFOLLOW, 0o644)
+		if err != nil {
+			return fmt.Errorf("run_as: %w", err)
+		}
+		err = f.Chown(int(su.cred.Uid), int(su.cred.Gid))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("run_as: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestStepUserUmask(t *testing.T) {
+	su, err := newStepUser(&dsl.RunAs{Umask: "077"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if code, _, err := runCommand(context.Background(), nil, su, "touch made", dir, filepath.Join(dir, "outputs"), extraEnv{}, io.Discard); err != nil || code != 0 {
+		t.Fatalf("exit %d err=%v", code, err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "made"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("file created with %o, want 600", perm)
+	}
+	if _, err := newStepUser(&dsl.RunAs{Umask: "999"}); err == nil {
+		t.Fatal("expected an invalid umask to be refused")
+	}
+}
+
+func TestStepUserSwitchesUser(t *testing.T) {
+	if _, err := newStepUser(&dsl.RunAs{User: "no-such-user-devagent"}); err == nil {
+		t.Fatal("expected an unknown user to be refused")
+	}
+	if os.Geteuid() != 0 {
+		if _, err := newStepUser(&dsl.RunAs{User: "root"}); err == nil || !strings.Contains(err.Error(), "needs devagent to run as root") {
+			t.Fatalf("switching users without root: %v", err)
+		}
+		t.Skip("dropping privileges needs root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+	su, err := newStepUser(&dsl.RunAs{User: "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// t.TempDir's parent is private to root.
+	dir, err := os.MkdirTemp("", "devagent-runas-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	runDir := filepath.Join(dir, "run")
+	if err := os.Mkdir(runDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	outputs := filepath.Join(runDir, "outputs")
+	if err := su.writable(outputs); err != nil {
+		t.Fatal(err)
+	}
+	command := `printf '%s %s' "$(id -u)" "$USER" > "$DEVAGENT_OUTPUT"`
+	if code, _, err := runCommand(context.Background(), nil, su, command, runDir, outputs, extraEnv{}, io.Discard); err != nil || code != 0 {
+		t.Fatalf("exit %d err=%v", code, err)
+	}
+	got, err := os.ReadFile(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := nobody.Uid + " nobody"; string(got) != want {
+		t.Fatalf("step ran as %q, want %q", got, want)
+	}
+
+	// The run directory stays root's: the step can write the outputs file
+	// but not replace it, or plant a log, with a symlink.
+	command = `ln -sf /etc/passwd step-1.log || ln -sf /etc/passwd outputs`
+	if code, _, err := runCommand(context.Background(), nil, su, command, runDir, outputs, extraEnv{}, io.Discard); err != nil || code == 0 {
+		t.Fatalf("step wrote to the run directory: exit %d err=%v", code, err)
+	}
+	if info, err := os.Lstat(outputs); err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("outputs file replaced: %v %v", info, err)
+	}
+}
@@ -0,0 +1,215 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"devagent/internal/textdiff"
+)
+
+// maxDiffBytes is the largest output file DiffRuns shows a content diff for.
+const maxDiffBytes = 1 << 20
+
+// bookkeepingFile matches run directory files that are not outputs: logs
+// (including rotated segments) and the files the runner maintains itself.
+var bookkeepingFile = regexp.MustCompile(`(^|/)([^/]+\.log(\.\d+)?(\.gz)?|summary\.json|checkpoint\.json|` + regexp.QuoteMeta(outputsFileName) + `)$`)
+
+// RecentRuns returns up to n runs of job under repo, newest first.
+func RecentRuns(repo, job string, n int) []*Summary {
+	runsDir := filepath.Join(repo, "devagent_runs")
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() > entries[j].Name() })
+	var runs []*Summary
+	for _, entry := range entries {
+		if len(runs) == n {
+			break
+		}
+		if !entry.IsDir() {
+			continue
+		}
+		summary, err := LoadSummary(filepath.Join(runsDir, entry.Name()))
+		if err != nil || summary.Name != job {
+			continue
+		}
+		runs = append(runs, summary)
+	}
+	return runs
+}
+
+// DiffRuns describes what changed from run a to run b: status, duration,
+// each step's exit code and duration, published outputs, and the files the
+// runs left in their directories, with unified diffs of changed text files.
+func DiffRuns(a, b *Summary) string {
+	var out strings.Builder
+	nameA, nameB := filepath.Base(a.RunDir), filepath.Base(b.RunDir)
+	fmt.Fprintf(&out, "%s: %s (%s) -> %s (%s)\n", b.Name, nameA, a.Status, nameB, b.Status)
+	durA, durB := a.EndedAt.Sub(a.StartedAt), b.EndedAt.Sub(b.StartedAt)
+	fmt.Fprintf(&out, "duration: %s -> %s (%s)\n", roundDuration(durA), roundDuration(durB), durationDelta(durA, durB))
+	if a.WorkflowHash != b.WorkflowHash {
+		fmt.Fprintln(&out, "workflow changed (see workflow.yml below)")
+	}
+	if a.Commit != b.Commit && a.Commit != "" && b.Commit != "" {
+		fmt.Fprintf(&out, "commit: %.12s -> %.12s\n", a.Commit, b.Commit)
+	}
+
+	fmt.Fprintln(&out, "\nsteps:")
+	used := make([]bool, len(a.Steps))
+	for i, step := range b.Steps {
+		match := -1
+		if i < len(a.Steps) && !used[i] && a.Steps[i].Cmd == step.Cmd {
+			match = i
+		} else {
+			for j, prev := range a.Steps {
+				if !used[j] && prev.Cmd == step.Cmd {
+					match = j
+					break
+				}
+			}
+		}
+		if match < 0 {
+			fmt.Fprintf(&out, "  + %s\t%s\n", redact(step.Cmd), stepState(step))
+			continue
+		}
+		used[match] = true
+		prev := a.Steps[match]
+		fmt.Fprintf(&out, "    %s\t%s -> %s\n", redact(step.Cmd), stepState(prev), stepState(step))
+	}
+	for j, prev := range a.Steps {
+		if !used[j] {
+			fmt.Fprintf(&out, "  - %s\t%s\n", redact(prev.Cmd), stepState(prev))
+		}
+	}
+
+	if changes := diffOutputs(a.Outputs, b.Outputs); len(changes) > 0 {
+		fmt.Fprintln(&out, "\noutputs:")
+		for _, change := range changes {
+			fmt.Fprintf(&out, "  %s\n", change)
+		}
+	}
+
+	filesA, filesB := runFiles(a.RunDir), runFiles(b.RunDir)
+	var names []string
+	for name := range filesA {
+		names = append(names, name)
+	}
+	for name := range filesB {
+		if _, ok := filesA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var fileNotes, diffs strings.Builder
+	for _, name := range names {
+		pathA, inA := filesA[name]
+		pathB, inB := filesB[name]
+		switch {
+		case !inA:
+			fmt.Fprintf(&fileNotes, "  + %s\n", name)
+		case !inB:
+			fmt.Fprintf(&fileNotes, "  - %s\n", name)
+		default:
+			dataA, errA := readRunFile(pathA)
+			dataB, errB := readRunFile(pathB)
+			if errA != nil || errB != nil || bytes.Equal(dataA, dataB) {
+				continue
+			}
+			if !isText(dataA) || !isText(dataB) {
+				fmt.Fprintf(&fileNotes, "  ~ %s (binary, %d -> %d bytes)\n", name, len(dataA), len(dataB))
+				continue
+			}
+			fmt.Fprintf(&fileNotes, "  ~ %s\n", name)
+			diffs.WriteString(redact(textdiff.Unified(string(dataA), string(dataB), nameA+"/"+name, nameB+"/"+name)))
+		}
+	}
+	if fileNotes.Len() > 0 {
+		fmt.Fprintln(&out, "\nfiles:")
+		out.WriteString(fileNotes.String())
+	}
+	if diffs.Len() > 0 {
+		out.WriteString("\n" + diffs.String())
+	}
+	return out.String()
+}
+
+// stepState describes how a step ended, e.g. "exit 0 in 12.3s".
+func stepState(step StepSummary) string {
+	switch {
+	case step.Skipped:
+		return "skipped (inputs unchanged)"
+	case step.Resumed:
+		return "skipped (resumed)"
+	}
+	return fmt.Sprintf("exit %d in %s", step.ExitCode, roundDuration(time.Duration(step.DurationSec*float64(time.Second))))
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// durationDelta formats b-a with an explicit sign.
+func durationDelta(a, b time.Duration) string {
+	delta := roundDuration(b - a)
+	if delta >= 0 {
+		return "+" + delta.String()
+	}
+	return delta.String()
+}
+
+// diffOutputs lists the added, removed and changed published outputs.
+func diffOutputs(a, b map[string]string) []string {
+	var changes []string
+	for key, value := range b {
+		prev, ok := a[key]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s=%s", key, redact(value)))
+		case prev != value:
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", key, redact(prev), redact(value)))
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s=%s", key, redact(value)))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i][2:] < changes[j][2:] })
+	return changes
+}
+
+// runFiles maps the relative paths of the output files in runDir, such as
+// copied files, published outputs and artifacts, to their full paths.
+// Compressed files are listed under their uncompressed names.
+func runFiles(runDir string) map[string]string {
+	files := make(map[string]string)
+	_ = filepath.WalkDir(runDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil || bookkeepingFile.MatchString(filepath.ToSlash(rel)) {
+			return nil
+		}
+		files[strings.TrimSuffix(filepath.ToSlash(rel), ".gz")] = path
+		return nil
+	})
+	return files
+}
+
+// isText reports whether data is small UTF-8 text worth diffing.
+func isText(data []byte) bool {
+	return len(data) <= maxDiffBytes && utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestDiffRuns(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "report", Repo: repo,
+		Steps: []dsl.Step{
+			{Run: `printf 'day,builds\nmon,3\n' > report.csv; echo "count=3" >> "$DEVAGENT_OUTPUT"`},
+			{Run: "true"},
+		},
+		Outputs: &dsl.Outputs{CopyIfExists: []string{"report.csv"}},
+	}
+	first, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.Steps = []dsl.Step{
+		{Run: `printf 'day,builds\nmon,3\ntue,5\n' > report.csv; echo "count=5" >> "$DEVAGENT_OUTPUT"`},
+		{Run: "true"},
+		{Run: "exit 4"},
+	}
+	second, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recent := RecentRuns(repo, "report", 2)
+	if len(recent) != 2 || recent[0].RunDir != second.RunDir || recent[1].RunDir != first.RunDir {
+		t.Fatalf("expected the two runs newest first, got %v", recent)
+	}
+
+	diff := DiffRuns(first, second)
+	for _, want := range []string{
+		"(success) -> ",
+		"(failed)",
+		"workflow changed",
+		"    true\texit 0 in ",
+		"  + exit 4\texit 4 in ",
+		"~ count: 3 -> 5",
+		"  ~ report.csv\n",
+		"+tue,5",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Fatalf("expected %q in the diff:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "step-1.log") || strings.Contains(diff, "summary.json") {
+		t.Fatalf("logs and bookkeeping files should not be compared:\n%s", diff)
+	}
+}
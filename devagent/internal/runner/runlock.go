@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"devagent/internal/paths"
+)
+
+// lockPoll is how often a run waiting for its repo or lock group
+// checks the lock.
+var lockPoll = time.Second
+
+// lockPath returns the path of the lock file name in the locks directory,
+// next to the job locks.
+func lockPath(name string) (string, error) {
+	state, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(state, "locks")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".lock"), nil
+}
+
+// repoLockPath returns the lock file of repo, named after a hash of the
+// repo's resolved path.
+func repoLockPath(repo string) (string, error) {
+	if abs, err := filepath.Abs(repo); err == nil {
+		repo = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(repo); err == nil {
+		repo = resolved
+	}
+	sum := sha256.Sum256([]byte(repo))
+	return lockPath("repo-" + hex.EncodeToString(sum[:6]))
+}
+
+// lockRepo takes the lock that keeps runs sharing repo's working tree from
+// running at the same time, whichever job or process they belong to. While
+// another run holds it, lockRepo says so on w and waits; it returns
+// ctx.Err() if ctx ends first. The returned function releases the lock.
+func lockRepo(ctx context.Context, repo, job string, w io.Writer) (func(), error) {
+	path, err := repoLockPath(repo)
+	if err != nil {
+		return nil, err
+	}
+	return takeLock(ctx, path, job, "repo="+repo, "to finish in "+repo, w)
+}
+
+// lockGroup takes the lock of the workflow's lock group key like lockRepo
+// takes the repo's.
+func lockGroup(ctx context.Context, key, job string, w io.Writer) (func(), error) {
+	path, err := lockPath("group-" + strings.ToLower(key))
+	if err != nil {
+		return nil, err
+	}
+	return takeLock(ctx, path, job, "group="+key, "to release lock "+key, w)
+}
+
+// takeLock waits for the lock file at path and records job and detail, a
+// key=value line, as its holder. waitingFor completes the message written
+// to w while another run holds it.
+func takeLock(ctx context.Context, path, job, detail, waitingFor string, w io.Writer) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	waiting := false
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+		if !waiting {
+			fmt.Fprintf(w, "waiting for %s %s\n", lockHolder(path), waitingFor)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+	_ = f.Truncate(0)
+	_, _ = fmt.Fprintf(f, "pid=%d\nstarted=%s\njob=%s\n%s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339), job, detail)
+	return func() {
+		_ = f.Truncate(0)
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// lockHolder describes the run holding the lock at path, as far as its
+// lock file tells.
+func lockHolder(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "another run"
+	}
+	defer f.Close()
+	var job, pid string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "job":
+			job = value
+		case "pid":
+			pid = value
+		}
+	}
+	if job == "" {
+		return "another run"
+	}
+	return fmt.Sprintf("job %s (pid %s)", job, pid)
+}
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+func TestRunWaitsForOtherRunInRepo(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	lockPoll = 10 * time.Millisecond
+	defer func() { lockPoll = time.Second }()
+	repo := t.TempDir()
+
+	unlock, err := lockRepo(context.Background(), repo, "lint", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *Summary, 1)
+	go func() {
+		summary, err := Run(context.Background(), Options{Workflow: &dsl.Workflow{Name: "tests", Repo: repo, Steps: []dsl.Step{{Run: "true"}}}})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- summary
+	}()
+	select {
+	case <-done:
+		t.Fatal("run started while another run held the repo")
+	case <-time.After(300 * time.Millisecond):
+	}
+	unlock()
+	summary := <-done
+	if summary == nil || summary.Status != "success" {
+		t.Fatalf("summary %+v", summary)
+	}
+	log, _ := os.ReadFile(filepath.Join(summary.RunDir, "run.log"))
+	if !strings.Contains(string(log), "waiting for job lint (pid") {
+		t.Fatalf("run.log does not say what the run waited for:\n%s", log)
+	}
+
+	// A cancelled wait ends the run without running any step.
+	unlock, err = lockRepo(context.Background(), repo, "lint", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	summary, err = Run(ctx, Options{Workflow: &dsl.Workflow{Name: "tests", Repo: repo, Steps: []dsl.Step{{Run: "true"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != StatusCancelled || len(summary.Steps) != 0 {
+		t.Fatalf("cancelled wait: status %q with %d steps", summary.Status, len(summary.Steps))
+	}
+}
+
+func TestRunWaitsForLockGroup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	lockPoll = 10 * time.Millisecond
+	defer func() { lockPoll = time.Second }()
+
+	unlock, err := lockGroup(context.Background(), "test-db", "migrations", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Runs in other repos share the group.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	wf := &dsl.Workflow{Name: "integration", Repo: t.TempDir(), Lock: "test-db", Steps: []dsl.Step{{Run: "true"}}}
+	summary, err := Run(ctx, Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != StatusCancelled {
+		t.Fatalf("run in a held lock group finished with %q", summary.Status)
+	}
+	log, _ := os.ReadFile(filepath.Join(summary.RunDir, "run.log"))
+	if !strings.Contains(string(log), "waiting for job migrations (pid") || !strings.Contains(string(log), "to release lock test-db") {
+		t.Fatalf("run.log:\n%s", log)
+	}
+
+	unlock()
+	wf.Repo = t.TempDir()
+	summary, err = Run(context.Background(), Options{Workflow: wf})
+	if err != nil || summary.Status != "success" {
+		t.Fatalf("run after the group was released: %v, %+v", err, summary)
+	}
+}
@@ -13,9 +13,14 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"devagent/internal/dsl"
+	"devagent/internal/plugin"
+	"devagent/internal/policy"
 	"devagent/internal/util"
 )
 
@@ -27,107 +32,597 @@ type Summary struct {
 	Status    string        `json:"status"`
 	Steps     []StepSummary `json:"steps"`
 	Repo      string        `json:"repo"`
+	// Outputs are the values published for downstream jobs.
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// WorkflowHash identifies the workflow revision snapshotted into the run
+	// directory as workflow.yml.
+	WorkflowHash string `json:"workflow_hash,omitempty"`
+	// OnCancel records the cleanup steps run after a cancellation.
+	OnCancel []StepSummary `json:"on_cancel,omitempty"`
+	// Heal records remediation commands run after the failure.
+	Heal []StepSummary `json:"heal,omitempty"`
+	// Usage totals the resources used by all steps of the run.
+	Usage Usage `json:"usage"`
+	// Unmet lists the preconditions that did not hold.
+	Unmet []string `json:"unmet,omitempty"`
+	// Worktree and Commit are set when the steps ran in a git worktree, and
+	// Ref when that was a temporary one for an ad hoc run at a ref.
+	Worktree string `json:"worktree,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Ref      string `json:"ref,omitempty"`
+	// ResumedFrom names the run directory whose completed steps this run
+	// skipped.
+	ResumedFrom string `json:"resumed_from,omitempty"`
+	// Cache records the restore and save of each cache entry.
+	Cache []CacheResult `json:"cache,omitempty"`
+	// Tests totals the test reports and coverage files listed in
+	// outputs.reports; FailedTests names the first failed tests.
+	Tests       *TestResults `json:"tests,omitempty"`
+	FailedTests []string     `json:"failed_tests,omitempty"`
+	// FailedIf lists the fail_if conditions that failed the run.
+	FailedIf []string `json:"failed_if,omitempty"`
+	// Benchmarks are the results parsed from bench.files, and
+	// BenchRegressions those worse than the rolling baseline.
+	Benchmarks       []BenchResult `json:"benchmarks,omitempty"`
+	BenchRegressions []BenchChange `json:"bench_regressions,omitempty"`
+	// Checksums maps each file in the run directory, other than
+	// summary.json and checkpoint.json, to its SHA-256 in hex.
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// RunDir is the directory holding this run's logs and artifacts.
+	RunDir string `json:"-"`
+}
+
+// ConfigError reports a run that could not start because the workflow is
+// misconfigured, as opposed to an environment failure.
+type ConfigError struct {
+	Err error
 }
 
+func (e *ConfigError) Error() string { return e.Err.Error() }
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// StatusRunning is the status in the summary.json of a run that has not
+// finished. A summary left in this state belongs to a run whose process
+// died; its steps show how far it got.
+const StatusRunning = "running"
+
+// StatusCancelled is the run status recorded when ctx is cancelled mid-run.
+const StatusCancelled = "cancelled"
+
+// StatusPreconditionFailed is the run status recorded when the workflow's
+// preconditions do not hold; no step runs.
+const StatusPreconditionFailed = "precondition_failed"
+
+// cancelGrace is how long a step may take to exit after SIGTERM before it
+// is killed.
+const cancelGrace = 10 * time.Second
+
+// onCancelTimeout bounds the on_cancel cleanup steps.
+const onCancelTimeout = 5 * time.Minute
+
 // StepSummary captures details about an executed step.
 type StepSummary struct {
-	Cmd         string  `json:"cmd"`
-	ExitCode    int     `json:"exit_code"`
-	DurationSec float64 `json:"duration_sec"`
+	Cmd         string   `json:"cmd"`
+	ExitCode    int      `json:"exit_code"`
+	DurationSec float64  `json:"duration_sec"`
+	Artifacts   []string `json:"artifacts,omitempty"`
+	// Log is the step's own log file, relative to the run directory.
+	Log string `json:"log,omitempty"`
+	// Tail holds the last lines of output of a failed step.
+	Tail []string `json:"tail,omitempty"`
+	// InputHash is the hash of the step's skip_unless_changed inputs, and
+	// Skipped is set when it matched the previous successful run.
+	InputHash string `json:"input_hash,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	// Resumed marks a step not run again because it completed in the run
+	// being resumed.
+	Resumed bool `json:"resumed,omitempty"`
+	// Deselected marks a step left out of the run by Options.Select.
+	Deselected bool `json:"deselected,omitempty"`
+	// Stalled marks a step that went logs.stalled_after without output.
+	Stalled bool `json:"stalled,omitempty"`
+	Usage
 }
 
 // Options controls run behaviour.
 type Options struct {
 	Workflow *dsl.Workflow
 	Stdout   io.Writer
+	// Needs holds upstream job outputs for `${{ needs.<job>.outputs.<key> }}`.
+	Needs map[string]map[string]string
+	// RunID is the run's ID in the run history, for `${{ run_id }}`.
+	RunID int64
+	// Source is the raw workflow file. It is copied into the run directory;
+	// when empty the parsed workflow is written instead.
+	Source []byte
+	// Policy, when set, is checked against every step before the run
+	// starts. Denied steps refuse the run, and steps needing confirmation
+	// run only if Approve accepts them; a nil Approve refuses them.
+	Policy  *policy.Policy
+	Approve func(policy.Decision) bool
+	// Ref, when set, runs the steps in a temporary worktree of the repo
+	// checked out at this branch, tag or commit instead of the workflow's
+	// worktree or the repo itself. The worktree is removed when the run ends.
+	Ref string
+	// Select, when set, leaves the steps it does not pick out of the run.
+	Select *StepSelection
+	// ResumeFrom is the directory of an earlier run of the same workflow;
+	// the steps it completed are skipped.
+	ResumeFrom string
+	// Heartbeat, when set, is called with the running step when it starts,
+	// on every heartbeat and when it stalls, e.g. to record it in the store.
+	Heartbeat func(StepProgress)
+	// Events, when set, is called as each step starts, writes output and
+	// finishes, e.g. to stream the run to API clients. It must not block.
+	Events func(StepEvent)
+	// BenchBaseline, when set, returns the benchmark results of up to n
+	// earlier runs of the workflow, newest first, e.g. from the store.
+	// Without it benchmark results are recorded but not compared.
+	BenchBaseline func(n int) ([][]BenchResult, error)
+}
+
+// PolicyError reports a step refused by the command policy.
+type PolicyError struct {
+	Step     string
+	Decision policy.Decision
+}
+
+func (e *PolicyError) Error() string {
+	if e.Decision.Action == policy.Confirm {
+		return fmt.Sprintf("%s %q needs confirmation: %s", e.Step, e.Decision.Command, e.Decision.Reason)
+	}
+	return fmt.Sprintf("%s %q refused by policy: %s", e.Step, e.Decision.Command, e.Decision.Reason)
 }
 
 // Run executes the workflow steps sequentially and records output files.
+// Cancelling ctx stops the current step, skips the rest and runs the
+// workflow's on_cancel steps; the summary then has StatusCancelled.
 func Run(ctx context.Context, opts Options) (*Summary, error) {
 	if opts.Workflow == nil {
 		return nil, errors.New("workflow is required")
 	}
 	repo, err := opts.Workflow.ExpandRepo()
 	if err != nil {
-		return nil, err
+		return nil, &ConfigError{Err: err}
 	}
 	if _, err := os.Stat(repo); err != nil {
-		return nil, fmt.Errorf("repo path %s not accessible: %w", repo, err)
+		return nil, &ConfigError{Err: fmt.Errorf("repo path %s not accessible: %w", repo, err)}
 	}
 
-	runDir := filepath.Join(repo, "devagent_runs", util.Timestamp())
-	if err := os.MkdirAll(runDir, 0o755); err != nil {
-		return nil, err
+	funcs := newTemplateFuncs(opts.Workflow, repo, opts.RunID)
+	steps, err := expandSteps(opts.Workflow.Steps, opts.Needs, funcs)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	cleanup, err := expandSteps(opts.Workflow.OnCancel, opts.Needs, funcs)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	var selected []bool
+	if opts.Select != nil {
+		if selected, err = selectSteps(steps, opts.Select); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkPlugins(steps, cleanup); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	if opts.Policy != nil {
+		if err := checkPolicy(opts.Policy, opts.Approve, repo, "step", steps); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+		if err := checkPolicy(opts.Policy, opts.Approve, repo, "on_cancel step", cleanup); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+		if err := checkPolicy(opts.Policy, opts.Approve, repo, "shell init line", initSteps(opts.Workflow.Shell)); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+	}
+
+	var extra extraEnv
+	if extra.vars, extra.secrets, err = loadEnvFiles(repo, opts.Workflow.EnvFiles); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	credVars, credSecrets, credNotes, err := credentialEnv(opts.Workflow.Credentials, os.Environ())
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	extra.vars = append(extra.vars, credVars...)
+	extra.secrets = append(extra.secrets, credSecrets...)
+
+	source := opts.Source
+	if len(source) == 0 {
+		if source, err = yaml.Marshal(opts.Workflow); err != nil {
+			return nil, err
+		}
 	}
+	workflowHash := dsl.Hash(source)
+	var resumeCP checkpoint
+	if opts.ResumeFrom != "" {
+		if resumeCP, err = loadCheckpoint(opts.ResumeFrom); err != nil {
+			return nil, &ConfigError{Err: fmt.Errorf("cannot resume from %s: %w", opts.ResumeFrom, err)}
+		}
+		if resumeCP.WorkflowHash != workflowHash {
+			return nil, &ConfigError{Err: fmt.Errorf("cannot resume from %s: the workflow changed since that run", opts.ResumeFrom)}
+		}
+	}
+	resumed := resumeCP.Completed
 
-	logPath := filepath.Join(runDir, "run.log")
-	logFile, err := os.Create(logPath)
+	runDir, err := newRunDir(filepath.Join(repo, "devagent_runs"), util.Timestamp())
+	if err != nil {
+		return nil, err
+	}
+	outputsPath, err := filepath.Abs(filepath.Join(runDir, outputsFileName))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(runDir, "workflow.yml"), source, 0o644); err != nil {
+		return nil, err
+	}
+	if err := restoreOutputs(opts.ResumeFrom, resumeCP, outputsPath); err != nil {
+		return nil, &ConfigError{Err: fmt.Errorf("cannot resume from %s: %w", opts.ResumeFrom, err)}
+	}
+	logs := opts.Workflow.Logs
+	runLog, err := openLog(filepath.Join(runDir, "run.log"), logs)
 	if err != nil {
 		return nil, err
 	}
-	defer logFile.Close()
+	defer runLog.Close()
 
-	var outputWriter io.Writer = logFile
+	var outputWriter io.Writer = runLog
 	if opts.Stdout != nil {
-		outputWriter = io.MultiWriter(logFile, opts.Stdout)
+		outputWriter = io.MultiWriter(runLog, opts.Stdout)
+	}
+	for _, note := range credNotes {
+		fmt.Fprintln(outputWriter, note)
 	}
 
 	summary := &Summary{
-		Name:  opts.Workflow.Name,
-		Repo:  repo,
-		Steps: make([]StepSummary, 0, len(opts.Workflow.Steps)),
+		Name:         opts.Workflow.Name,
+		Repo:         repo,
+		Steps:        make([]StepSummary, 0, len(opts.Workflow.Steps)),
+		WorkflowHash: workflowHash,
+		RunDir:       runDir,
+	}
+	if opts.ResumeFrom != "" {
+		summary.ResumedFrom = filepath.Base(opts.ResumeFrom)
 	}
 	summary.StartedAt = time.Now().UTC()
+	summary.Status = StatusRunning
 
-	status := "success"
+	// The summary is rewritten after every step so a run whose process dies
+	// still leaves one behind.
+	summaryPath := filepath.Join(runDir, "summary.json")
+	progress := func() {
+		if err := writeSummary(summaryPath, summary); err != nil {
+			fmt.Fprintf(outputWriter, "summary update failed: %v\n", err)
+		}
+	}
+	progress()
+
+	// Jobs of one lock group, and jobs sharing a working tree, take turns;
+	// a job with its own worktree does not touch the repo's. The group is
+	// always locked first so two runs cannot wait for each other.
+	var locks []func(context.Context) (func(), error)
+	if key := opts.Workflow.Lock; key != "" {
+		locks = append(locks, func(ctx context.Context) (func(), error) {
+			return lockGroup(ctx, key, opts.Workflow.Name, outputWriter)
+		})
+	}
+	if opts.Workflow.Worktree == nil && opts.Ref == "" {
+		locks = append(locks, func(ctx context.Context) (func(), error) {
+			return lockRepo(ctx, repo, opts.Workflow.Name, outputWriter)
+		})
+	}
+	for _, lock := range locks {
+		unlock, err := lock(ctx)
+		if err != nil && ctx.Err() != nil {
+			fmt.Fprintln(outputWriter, "run cancelled")
+			summary.Status = StatusCancelled
+			summary.EndedAt = time.Now().UTC()
+			if err := writeSummary(summaryPath, summary); err != nil {
+				return nil, err
+			}
+			return summary, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lock: %w", err)
+		}
+		defer unlock()
+	}
+
+	if unmet := checkPreconditions(ctx, opts.Workflow.Preconditions, repo); len(unmet) > 0 {
+		for _, reason := range unmet {
+			fmt.Fprintf(outputWriter, "precondition failed: %s\n", reason)
+		}
+		summary.Unmet = unmet
+		summary.Status = StatusPreconditionFailed
+		summary.EndedAt = time.Now().UTC()
+		if err := writeSummary(summaryPath, summary); err != nil {
+			return nil, err
+		}
+		return summary, nil
+	}
 
-	for _, step := range opts.Workflow.Steps {
-		cmdText := strings.TrimSpace(step.Run)
-		if cmdText == "" {
+	// Steps run in workdir: the repo itself, the job's worktree or a
+	// temporary one at opts.Ref. The run directory always stays in the repo.
+	workdir := repo
+	if opts.Ref != "" {
+		var remove func()
+		if workdir, summary.Commit, remove, err = prepareTempWorktree(ctx, repo, opts.Ref, opts.Workflow.Name, outputWriter); err != nil {
+			return nil, err
+		}
+		defer remove()
+		summary.Worktree, summary.Ref = workdir, opts.Ref
+	} else if opts.Workflow.Worktree != nil {
+		if workdir, summary.Commit, err = prepareWorktree(ctx, opts.Workflow.Worktree, repo, opts.Workflow.Name, outputWriter); err != nil {
+			return nil, err
+		}
+		summary.Worktree = workdir
+	}
+	sb, err := newSandbox(opts.Workflow.Sandbox, workdir, runDir)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	su, err := newStepUser(opts.Workflow.RunAs)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	summary.Cache = restoreCaches(outputWriter, opts.Workflow, workdir)
+	// The worktree and the caches restored into it were created by
+	// devagent; the steps must be able to write to them. Of the run
+	// directory they only get the outputs file.
+	var owned []string
+	if workdir != repo {
+		owned = append(owned, workdir)
+	}
+	realWorkdir, _ := filepath.EvalSymlinks(workdir)
+	for _, c := range opts.Workflow.Cache {
+		paths, err := cachePaths(c, workdir)
+		if err != nil {
 			continue
 		}
-		fmt.Fprintf(outputWriter, "$ %s\n", redact(cmdText))
+		for _, path := range paths {
+			if within(realWorkdir, path) {
+				owned = append(owned, path)
+			}
+		}
+	}
+	if err := su.own(owned...); err != nil {
+		return nil, err
+	}
+	if err := su.writable(outputsPath); err != nil {
+		return nil, err
+	}
 
-		cmd := exec.CommandContext(ctx, "bash", "-lc", cmdText)
-		cmd.Dir = repo
-		cmd.Env = sanitizedEnv()
+	// The checkpoint records the leading steps that completed so a failed
+	// or interrupted run can be resumed with ResumeFrom.
+	checkpointStep := func(completed int) {
+		cp := checkpoint{Name: opts.Workflow.Name, WorkflowHash: workflowHash, Completed: completed}
+		if info, err := os.Stat(outputsPath); err == nil {
+			cp.Outputs = info.Size()
+		}
+		if err := writeCheckpoint(runDir, cp); err != nil {
+			fmt.Fprintf(outputWriter, "checkpoint failed: %v\n", err)
+		}
+	}
+	checkpointStep(resumed)
+	// The checkpoint counts the leading steps that completed, so it stops
+	// advancing once a step is left out.
+	gap := false
+
+	// execute runs a resolved step, natively for http, sql, assert, deps and
+	// script steps and otherwise in the step shell.
+	execute := func(ctx context.Context, resolved resolvedStep, logPath string, w io.Writer) (int, Usage, error) {
+		shell := func(ctx context.Context, command string, w io.Writer) (int, Usage, error) {
+			return runCommand(ctx, sb, su, withShell(opts.Workflow.Shell, command), workdir, outputsPath, extra, w)
+		}
+		if resolved.notebook != "" {
+			if err := su.writable(resolved.notebook); err != nil {
+				return 0, Usage{}, err
+			}
+		}
+		if resolved.plugin != "" {
+			req := plugin.StepRequest{Job: opts.Workflow.Name, Step: resolved.index, Repo: repo, Workdir: workdir, RunDir: filepath.Dir(outputsPath), Outputs: outputsPath, With: resolved.with}
+			command, err := pluginCommand(resolved.plugin, req, resolved.request)
+			if err != nil {
+				return 0, Usage{}, err
+			}
+			resolved.command = command
+		}
+		switch {
+		case resolved.assert != nil:
+			return runAssert(ctx, resolved.assert, workdir, extra, w, logPath, logs, shell)
+		case resolved.deps != nil:
+			previous := previousDeps(repo, opts.Workflow.Name, filepath.Base(resolved.output))
+			return runDeps(ctx, resolved.deps, resolved.output, workdir, previous, extra, w, logPath, logs, shell)
+		case resolved.http != nil:
+			exitCode, err := runHTTP(ctx, resolved.http, workdir, extra, w, logPath, logs)
+			return exitCode, Usage{}, err
+		case resolved.sql != nil:
+			exitCode, err := runSQL(ctx, resolved.sql, resolved.output, extra, w, logPath, logs)
+			return exitCode, Usage{}, err
+		case resolved.script != "":
+			r := scriptRun{job: opts.Workflow.Name, repo: repo, workdir: workdir, outputsPath: outputsPath, step: resolved.index, steps: summary.Steps, needs: opts.Needs, vars: opts.Workflow.Vars}
+			command, err := scriptCommand(resolved.script, r, su, resolved.request)
+			if err != nil {
+				return 0, Usage{}, err
+			}
+			return runLogged(ctx, sb, su, command, workdir, outputsPath, extra, w, logPath, logs)
+		}
+		return runLogged(ctx, sb, su, withShell(opts.Workflow.Shell, resolved.command), workdir, outputsPath, extra, w, logPath, logs)
+	}
 
-		logOut := newRedactingWriter(outputWriter)
-		cmd.Stdout = logOut
-		cmd.Stderr = logOut
+	status := "success"
+	// previous is the last successful run, loaded for the first step with
+	// skip_unless_changed inputs.
+	var previous *Summary
+	loadedPrevious := false
 
-		stepStart := time.Now()
-		err := cmd.Run()
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
+	for i, step := range steps {
+		if ctx.Err() != nil {
+			status = StatusCancelled
+			break
+		}
+		resolved := resolveStep(step, runDir, i)
+		if resolved.empty() {
+			continue
+		}
+		if i < resumed {
+			fmt.Fprintf(outputWriter, "skipping %s: completed in %s\n", redact(resolved.label), summary.ResumedFrom)
+			summary.Steps = append(summary.Steps, StepSummary{Cmd: resolved.label, Resumed: true})
+			progress()
+			continue
+		}
+		if selected != nil && !selected[i] {
+			fmt.Fprintf(outputWriter, "skipping %s: not selected\n", redact(resolved.label))
+			summary.Steps = append(summary.Steps, StepSummary{Cmd: resolved.label, Deselected: true})
+			progress()
+			gap = true
+			continue
+		}
+		var inputHash string
+		if len(step.SkipUnlessChanged) > 0 {
+			hash, _, hashErr := hashFiles(workdir, step.SkipUnlessChanged)
+			if resolved.plugin != "" {
+				hash = settingsHash(hash, resolved.with)
+			}
+			if hashErr != nil {
+				fmt.Fprintf(outputWriter, "cannot hash inputs of %q, running it: %v\n", redact(resolved.label), hashErr)
 			} else {
-				return nil, err
+				if !loadedPrevious {
+					previous, loadedPrevious = lastSuccess(filepath.Join(repo, "devagent_runs"), opts.Workflow.Name), true
+				}
+				if unchangedSince(previous, resolved.label, hash) {
+					fmt.Fprintf(outputWriter, "skipping %s: inputs unchanged since %s\n", redact(resolved.label), filepath.Base(previous.RunDir))
+					summary.Steps = append(summary.Steps, StepSummary{Cmd: resolved.label, InputHash: hash, Skipped: true})
+					progress()
+					if !gap {
+						checkpointStep(i + 1)
+					}
+					continue
+				}
+				inputHash = hash
 			}
 		}
-		if flushErr := logOut.Flush(); flushErr != nil {
-			return nil, flushErr
+		fmt.Fprintf(outputWriter, "$ %s\n", redact(resolved.label))
+
+		stepLog := fmt.Sprintf("step-%d.log", i+1)
+		stepStart := time.Now()
+		monitor := startMonitor(outputWriter, logs.HeartbeatInterval(), logs.StallLimit(), opts.Heartbeat, opts.Events, i+1, len(steps), redact(resolved.label))
+		exitCode, usage, err := execute(ctx, resolved, filepath.Join(runDir, stepLog), monitor)
+		stalled := monitor.finish(exitCode)
+		if err != nil {
+			return nil, err
 		}
+		summary.Usage.Add(usage)
 
-		summary.Steps = append(summary.Steps, StepSummary{
-			Cmd:         cmdText,
+		stepSummary := StepSummary{
+			Cmd:         resolved.label,
 			ExitCode:    exitCode,
 			DurationSec: time.Since(stepStart).Seconds(),
-		})
+			Log:         stepLog,
+			InputHash:   inputHash,
+			Stalled:     stalled,
+			Usage:       usage,
+		}
+		if exitCode != 0 {
+			stepSummary.Tail, _ = logTail(filepath.Join(runDir, stepLog), tailLineCount, tailMaxBytes)
+		}
+		if resolved.notebook != "" {
+			if _, statErr := os.Stat(resolved.notebook); statErr == nil {
+				stepSummary.Artifacts = append(stepSummary.Artifacts, filepath.Base(resolved.notebook))
+				extracted, extractErr := extractNotebookOutputs(resolved.notebook, runDir, strings.TrimSuffix(filepath.Base(resolved.notebook), ".executed.ipynb"))
+				if extractErr != nil {
+					fmt.Fprintf(outputWriter, "notebook output extraction failed: %v\n", extractErr)
+				}
+				stepSummary.Artifacts = append(stepSummary.Artifacts, extracted...)
+			}
+		}
+		// A deps step that found new updates still wrote its report.
+		if resolved.output != "" && (exitCode == 0 || (resolved.deps != nil && exitCode == 1)) {
+			stepSummary.Artifacts = append(stepSummary.Artifacts, filepath.Base(resolved.output))
+		}
+		summary.Steps = append(summary.Steps, stepSummary)
+		progress()
 
-		if err != nil {
+		if ctx.Err() != nil {
+			status = StatusCancelled
+			break
+		}
+		if exitCode != 0 {
 			status = "failed"
 			break
 		}
+		if !gap {
+			checkpointStep(i + 1)
+		}
+	}
+
+	if status == StatusCancelled {
+		fmt.Fprintln(outputWriter, "run cancelled")
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), onCancelTimeout)
+		for i, step := range cleanup {
+			resolved := resolveStep(step, runDir, len(steps)+i)
+			if resolved.empty() {
+				continue
+			}
+			fmt.Fprintf(outputWriter, "$ %s\n", redact(resolved.label))
+			stepLog := fmt.Sprintf("on-cancel-%d.log", i+1)
+			stepStart := time.Now()
+			exitCode, usage, err := execute(cleanupCtx, resolved, filepath.Join(runDir, stepLog), outputWriter)
+			if err != nil {
+				fmt.Fprintf(outputWriter, "on_cancel step failed: %v\n", err)
+				exitCode = -1
+			}
+			summary.Usage.Add(usage)
+			summary.OnCancel = append(summary.OnCancel, StepSummary{
+				Cmd:         resolved.label,
+				ExitCode:    exitCode,
+				DurationSec: time.Since(stepStart).Seconds(),
+				Log:         stepLog,
+				Usage:       usage,
+			})
+			progress()
+		}
+		cancel()
+	}
+
+	if opts.Workflow.Outputs != nil {
+		summary.Tests, summary.FailedTests = collectReports(outputWriter, workdir, opts.Workflow.Outputs.Reports)
+	}
+	if status == "success" {
+		if summary.FailedIf = failIf(opts.Workflow.FailIf, summary.Tests); len(summary.FailedIf) > 0 {
+			for _, reason := range summary.FailedIf {
+				fmt.Fprintf(outputWriter, "fail_if: %s\n", reason)
+			}
+			status = "failed"
+		}
+	}
+	if bench := opts.Workflow.Bench; bench != nil {
+		if checkBenchmarks(outputWriter, bench, workdir, opts.BenchBaseline, summary) && bench.FailOnRegression && status == "success" {
+			status = "failed"
+		}
+	}
+
+	if status == "success" {
+		saveCaches(outputWriter, opts.Workflow, workdir, summary.Cache)
 	}
 
 	summary.EndedAt = time.Now().UTC()
 	summary.Status = status
 
-	summaryPath := filepath.Join(runDir, "summary.json")
-	if err := writeSummary(summaryPath, summary); err != nil {
-		return nil, err
+	var publish map[string]string
+	if opts.Workflow.Outputs != nil {
+		publish = opts.Workflow.Outputs.Publish
+	}
+	outputs, err := collectOutputs(workdir, runDir, publish)
+	if err != nil {
+		fmt.Fprintf(outputWriter, "output collection failed: %v\n", err)
+	}
+	if len(outputs) > 0 {
+		summary.Outputs = outputs
 	}
 
 	if opts.Workflow.Outputs != nil {
@@ -136,7 +631,7 @@ func Run(ctx context.Context, opts Options) (*Summary, error) {
 			if candidate == "" {
 				continue
 			}
-			src := filepath.Join(repo, candidate)
+			src := filepath.Join(workdir, candidate)
 			if _, err := os.Stat(src); err == nil {
 				dst := filepath.Join(runDir, filepath.Base(candidate))
 				_ = copyFile(src, dst)
@@ -144,15 +639,288 @@ func Run(ctx context.Context, opts Options) (*Summary, error) {
 		}
 	}
 
+	// The run log is complete; close it so it can be compressed and
+	// checksummed with the rest of the run directory.
+	runLog.Close()
+	var archiveOut io.Writer = io.Discard
+	if opts.Stdout != nil {
+		archiveOut = opts.Stdout
+	}
+	archiveRun(archiveOut, opts.Workflow, summary)
+
+	if err := writeSummary(summaryPath, summary); err != nil {
+		return nil, err
+	}
+
 	return summary, nil
 }
 
+// runLogged runs a step with its output going to both w (the combined run
+// log) and its own log file at logPath.
+func runLogged(ctx context.Context, sb *sandbox, su *stepUser, command, repo, outputsPath string, extra extraEnv, w io.Writer, logPath string, logs *dsl.Logs) (int, Usage, error) {
+	stepLog, err := openLog(logPath, logs)
+	if err != nil {
+		return 0, Usage{}, err
+	}
+	defer stepLog.Close()
+	return runCommand(ctx, sb, su, command, repo, outputsPath, extra, io.MultiWriter(w, stepLog))
+}
+
+// extraEnv holds variables added to every step's environment, e.g. from
+// env_files, and the values to redact from the step output.
+type extraEnv struct {
+	vars    []string
+	secrets []string
+}
+
+// runCommand runs one step, inside sb and as su when set, in its own
+// process group so cancelling ctx can send SIGTERM to the whole tree and
+// give it cancelGrace to exit. It returns the exit code and resource usage; errors are reserved
+// for steps that could not be run.
+func runCommand(ctx context.Context, sb *sandbox, su *stepUser, command, repo, outputsPath string, extra extraEnv, w io.Writer) (int, Usage, error) {
+	cmd := sb.command(ctx, su.wrap(command), repo)
+	cmd.Dir = repo
+	cmd.Env = append(append(sanitizedEnv(), extra.vars...), "DEVAGENT_OUTPUT="+outputsPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	su.apply(cmd)
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = cancelGrace
+
+	logOut := newRedactingWriter(w, extra.secrets...)
+	cmd.Stdout = logOut
+	cmd.Stderr = logOut
+
+	err := cmd.Run()
+	usage := usageOf(cmd.ProcessState)
+	if flushErr := logOut.Flush(); flushErr != nil {
+		return 0, usage, flushErr
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), usage, nil
+		}
+		if ctx.Err() != nil {
+			return -1, usage, nil
+		}
+		return 0, usage, err
+	}
+	return 0, usage, nil
+}
+
+// checkPolicy checks the shell commands of steps, including assert
+// commands, against p, the source of script steps, so the policy's rules
+// apply to scripts too, http steps as "http METHOD URL", so rules can deny
+// hosts, and sql steps as "sql DRIVER: QUERY". Notebook and deps steps run
+// commands built by devagent and plugin steps run a plugin the user
+// installed; none of them is checked.
+func checkPolicy(p *policy.Policy, approve func(policy.Decision) bool, repo, kind string, steps []dsl.Step) error {
+	for i, step := range steps {
+		if step.Notebook != nil {
+			continue
+		}
+		command := resolveStep(step, "", i).command
+		switch {
+		case step.Assert != nil:
+			command = step.Assert.Command
+		case step.Script != "":
+			command = step.Script
+		case step.HTTP != nil, step.SQL != nil:
+			command = resolveStep(step, "", i).label
+		}
+		if command == "" {
+			continue
+		}
+		d := p.Check(command, repo)
+		if d.Action == policy.Allow || (d.Action == policy.Confirm && approve != nil && approve(d)) {
+			continue
+		}
+		return &PolicyError{Step: fmt.Sprintf("%s %d", kind, i+1), Decision: d}
+	}
+	return nil
+}
+
+// resolvedStep is a workflow step translated into the shell command to run.
+type resolvedStep struct {
+	label    string // recorded in the summary and echoed to the log
+	command  string // passed to bash -lc
+	notebook string // absolute path of the executed notebook, if any
+	http     *dsl.HTTP
+	sql      *dsl.SQL
+	output   string // absolute path of the sql or deps step's result file
+	assert   *dsl.Assert
+	deps     *dsl.Deps
+	plugin   string            // name of the step plugin, if any
+	with     map[string]string // the plugin's settings
+	request  string            // absolute path of the plugin's or script's request file
+	script   string            // source of the script step, if any
+	index    int               // 1-based, as passed to the plugin or script
+}
+
+// empty reports whether there is nothing to run for the step.
+func (r resolvedStep) empty() bool {
+	return r.command == "" && r.http == nil && r.sql == nil && r.assert == nil && r.deps == nil && r.plugin == "" && r.script == ""
+}
+
+func resolveStep(step dsl.Step, runDir string, index int) resolvedStep {
+	if h := step.HTTP; h != nil {
+		return resolvedStep{label: "http " + httpMethod(h) + " " + strings.TrimSpace(h.URL), http: h}
+	}
+	if a := step.Assert; a != nil {
+		return resolvedStep{label: assertLabel(a), assert: a}
+	}
+	if d := step.Deps; d != nil {
+		return resolvedStep{label: depsLabel(d), deps: d, output: depsOutput(runDir, index)}
+	}
+	if q := step.SQL; q != nil {
+		return resolvedStep{
+			label:  "sql " + q.Driver + ": " + strings.Join(strings.Fields(q.Query), " "),
+			sql:    q,
+			output: sqlOutput(q, runDir, index),
+		}
+	}
+	if step.Plugin != "" {
+		request := filepath.Join(runDir, fmt.Sprintf("step-%d-plugin.json", index+1))
+		if abs, err := filepath.Abs(request); err == nil {
+			request = abs
+		}
+		return resolvedStep{label: pluginLabel(step.Plugin, step.With), plugin: step.Plugin, with: step.With, request: request, index: index + 1}
+	}
+	if step.Script != "" {
+		request := filepath.Join(runDir, fmt.Sprintf("step-%d-script.json", index+1))
+		if abs, err := filepath.Abs(request); err == nil {
+			request = abs
+		}
+		return resolvedStep{label: scriptLabel(step.Script), script: step.Script, request: request, index: index + 1}
+	}
+	if nb := step.Notebook; nb != nil {
+		base := strings.TrimSuffix(filepath.Base(nb.Path), filepath.Ext(nb.Path))
+		out := filepath.Join(runDir, fmt.Sprintf("step-%d-%s.executed.ipynb", index+1, base))
+		if abs, err := filepath.Abs(out); err == nil {
+			out = abs
+		}
+		return resolvedStep{
+			label:    "notebook " + nb.Path,
+			command:  notebookCommand(nb, out),
+			notebook: out,
+		}
+	}
+	if target := step.TargetCommand(); target != "" {
+		return resolvedStep{label: target, command: target}
+	}
+	cmdText := strings.TrimSpace(step.Run)
+	return resolvedStep{label: cmdText, command: cmdText}
+}
+
 func writeSummary(path string, summary *Summary) error {
 	data, err := json.MarshalIndent(summary, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0o644)
+	// Written aside and renamed into place, so readers and crashes never
+	// see a partly written summary.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Report is what a workflow's report_to endpoints receive after a run: its
+// summary, plus the store's run ID and the run directory.
+type Report struct {
+	*Summary
+	RunID  int64  `json:"run_id,omitempty"`
+	RunDir string `json:"run_dir,omitempty"`
+	Host   string `json:"host,omitempty"`
+	// Error is why a run that could not start failed; its summary then has
+	// only the name and status.
+	Error string `json:"error,omitempty"`
+}
+
+// NewReport builds the report of a run. summary is nil, and runErr set,
+// for a run that could not start.
+func NewReport(name string, summary *Summary, runID int64, runErr error) Report {
+	report := Report{Summary: summary, RunID: runID}
+	if summary == nil {
+		report.Summary = &Summary{Name: name, Status: "failed", EndedAt: time.Now().UTC()}
+	} else {
+		report.RunDir = summary.RunDir
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	report.Host, _ = os.Hostname()
+	return report
+}
+
+// newRunDir creates a run directory named after stamp under parent, adding
+// a numeric suffix when a run (e.g. a retry after self-healing) already
+// claimed that second.
+func newRunDir(parent, stamp string) (string, error) {
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	for i := 1; ; i++ {
+		dir := filepath.Join(parent, stamp)
+		if i > 1 {
+			dir = fmt.Sprintf("%s-%d", dir, i)
+		}
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			return dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// LoadSummary reads the summary.json written into runDir.
+func LoadSummary(runDir string) (*Summary, error) {
+	data, err := os.ReadFile(filepath.Join(runDir, "summary.json"))
+	if err != nil {
+		return nil, err
+	}
+	var summary Summary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("parse summary: %w", err)
+	}
+	summary.RunDir = runDir
+	return &summary, nil
+}
+
+// RenameRuns changes the job name recorded in the summaries under repo's
+// devagent_runs, so diff-runs, resume and unchanged-step skipping keep
+// finding a renamed job's runs. It returns how many summaries changed.
+func RenameRuns(repo, oldName, newName string) (int, error) {
+	runsDir := filepath.Join(repo, "devagent_runs")
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	renamed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(runsDir, entry.Name())
+		summary, err := LoadSummary(dir)
+		if err != nil || summary.Name != oldName {
+			continue
+		}
+		summary.Name = newName
+		if err := writeSummary(filepath.Join(dir, "summary.json"), summary); err != nil {
+			return renamed, err
+		}
+		renamed++
+	}
+	return renamed, nil
 }
 
 func copyFile(src, dst string) error {
@@ -170,26 +938,49 @@ func redact(s string) string {
 }
 
 func sanitizedEnv() []string {
-	var env []string
-	for _, kv := range os.Environ() {
-		parts := strings.SplitN(kv, "=", 2)
-		key := strings.ToUpper(parts[0])
-		if strings.Contains(key, "SECRET") || strings.Contains(key, "TOKEN") || strings.Contains(key, "KEY") {
+	env, _ := filterEnv(os.Environ())
+	return env
+}
+
+// filterEnv drops the variables whose names look like secrets, and the SSH
+// agent socket, from base and returns the remaining environment and the
+// names it dropped. A workflow's credentials add them back.
+func filterEnv(base []string) (env, removed []string) {
+	for _, kv := range base {
+		key, _, _ := strings.Cut(kv, "=")
+		if secretName(key) || key == "SSH_AUTH_SOCK" {
+			removed = append(removed, key)
 			continue
 		}
 		env = append(env, kv)
 	}
-	return env
+	return env, removed
+}
+
+// secretName reports whether an environment variable is withheld from steps.
+func secretName(key string) bool {
+	key = strings.ToUpper(key)
+	return strings.Contains(key, "SECRET") || strings.Contains(key, "TOKEN") || strings.Contains(key, "KEY")
 }
 
 type redactingWriter struct {
 	mu  sync.Mutex
 	w   io.Writer
 	buf bytes.Buffer
+	// secrets are literal values, e.g. from env_files, also redacted.
+	secrets []string
 }
 
-func newRedactingWriter(w io.Writer) *redactingWriter {
-	return &redactingWriter{w: w}
+func newRedactingWriter(w io.Writer, secrets ...string) *redactingWriter {
+	return &redactingWriter{w: w, secrets: secrets}
+}
+
+func (rw *redactingWriter) redact(line string) string {
+	line = redact(line)
+	for _, secret := range rw.secrets {
+		line = strings.ReplaceAll(line, secret, "<redacted>")
+	}
+	return line
 }
 
 func (rw *redactingWriter) Write(p []byte) (int, error) {
@@ -210,7 +1001,7 @@ func (rw *redactingWriter) flushLines() error {
 			return nil
 		}
 		line := string(data[:idx])
-		if _, err := fmt.Fprintf(rw.w, "%s\n", redact(line)); err != nil {
+		if _, err := fmt.Fprintf(rw.w, "%s\n", rw.redact(line)); err != nil {
 			return err
 		}
 		rw.buf.Next(idx + 1)
@@ -223,7 +1014,7 @@ func (rw *redactingWriter) Flush() error {
 	if rw.buf.Len() == 0 {
 		return nil
 	}
-	if _, err := fmt.Fprintf(rw.w, "%s\n", redact(strings.TrimRight(rw.buf.String(), "\n"))); err != nil {
+	if _, err := fmt.Fprintf(rw.w, "%s\n", rw.redact(strings.TrimRight(rw.buf.String(), "\n"))); err != nil {
 		return err
 	}
 	rw.buf.Reset()
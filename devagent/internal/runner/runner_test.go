@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunChecksStepsAgainstPolicy(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Steps: []dsl.Step{
+		{Run: "touch ran"},
+		{Run: "sudo make install"},
+	}}
+
+	_, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Decision.Action != policy.Confirm {
+		t.Fatalf("expected a confirmation refusal, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "ran")); err == nil {
+		t.Fatalf("no step should run when the policy refuses the workflow")
+	}
+
+	wf.Steps[1].Run = "true"
+	wf.OnCancel = []dsl.Step{{Run: "rm -rf ~/"}}
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); !errors.As(err, &policyErr) || policyErr.Decision.Action != policy.Deny {
+		t.Fatalf("expected on_cancel steps to be checked, got %v", err)
+	}
+
+	wf.OnCancel = nil
+	wf.Steps[1].Run = "sudo true"
+	var asked policy.Decision
+	_, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default(), Approve: func(d policy.Decision) bool {
+		asked = d
+		return false
+	}})
+	if !errors.As(err, &policyErr) || asked.Command != "sudo true" {
+		t.Fatalf("expected Approve to be asked and to refuse, got %v (asked %+v)", err, asked)
+	}
+}
+
+func TestRunRecordsStepUsage(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Steps: []dsl.Step{
+		{Run: "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done"},
+		{Run: "true"},
+	}}
+	summary, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := summary.Steps[0].Usage
+	if first.CPUSec <= 0 || first.MaxRSSBytes <= 0 {
+		t.Fatalf("expected CPU time and peak RSS for the step, got %+v", first)
+	}
+	if summary.Usage.CPUSec < first.CPUSec || summary.Usage.MaxRSSBytes < first.MaxRSSBytes {
+		t.Fatalf("run usage %+v should cover the step usage %+v", summary.Usage, first)
+	}
+}
+
+func TestRunWritesSummaryAfterEachStep(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Steps: []dsl.Step{
+		{Run: "true"},
+		{Run: `mkdir partial && cp "$(dirname "$DEVAGENT_OUTPUT")/summary.json" partial/`},
+	}}
+	summary, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := LoadSummary(filepath.Join(repo, "partial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial.Status != StatusRunning || len(partial.Steps) != 1 {
+		t.Fatalf("mid-run summary: status %q with %d steps, want running with 1", partial.Status, len(partial.Steps))
+	}
+	final, err := LoadSummary(summary.RunDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.Status == StatusRunning || len(final.Steps) != 2 {
+		t.Fatalf("final summary: status %q with %d steps", final.Status, len(final.Steps))
+	}
+	if _, err := os.Stat(filepath.Join(summary.RunDir, "summary.json.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected no leftover temporary summary, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+)
+
+// sandbox wraps step commands in an OS sandbox that only lets them write to
+// the repo, the run directory, the temp directories and configured extra
+// paths. A nil sandbox runs steps directly.
+type sandbox struct {
+	tool     string
+	offline  bool
+	writable []string
+}
+
+// newSandbox resolves the sandbox tool for cfg. It fails rather than
+// falling back to unconfined execution when no tool is available.
+func newSandbox(cfg *dsl.Sandbox, repo, runDir string) (*sandbox, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	tool := cfg.Tool
+	if tool == "" {
+		candidates := []string{"bwrap", "nsjail"}
+		if runtime.GOOS == "darwin" {
+			candidates = []string{"sandbox-exec"}
+		}
+		for _, candidate := range candidates {
+			if _, err := exec.LookPath(candidate); err == nil {
+				tool = candidate
+				break
+			}
+		}
+		if tool == "" {
+			return nil, fmt.Errorf("sandbox requested but none of %s is installed", strings.Join(candidates, ", "))
+		}
+	} else if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("sandbox tool %s not found: %w", tool, err)
+	}
+
+	sb := &sandbox{tool: tool, offline: cfg.Offline}
+	binds := []string{repo, runDir, os.TempDir(), "/tmp"}
+	for _, path := range cfg.Writable {
+		expanded, err := paths.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(repo, expanded)
+		}
+		binds = append(binds, expanded)
+	}
+	seen := make(map[string]bool)
+	for _, path := range binds {
+		// Sandbox tools match resolved paths (e.g. /tmp is /private/tmp on
+		// macOS) and refuse to bind paths that do not exist.
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		if abs, err := filepath.Abs(resolved); err == nil {
+			resolved = abs
+		}
+		seen[resolved] = true
+		sb.writable = append(sb.writable, resolved)
+	}
+	return sb, nil
+}
+
+// command builds the process running the shell command in dir.
+func (sb *sandbox) command(ctx context.Context, command, dir string) *exec.Cmd {
+	if sb == nil {
+		return exec.CommandContext(ctx, "bash", "-lc", command)
+	}
+	var args []string
+	switch sb.tool {
+	case "bwrap":
+		args = []string{"--ro-bind", "/", "/", "--dev-bind", "/dev", "/dev", "--proc", "/proc", "--die-with-parent"}
+		for _, path := range sb.writable {
+			args = append(args, "--bind", path, path)
+		}
+		if sb.offline {
+			args = append(args, "--unshare-net")
+		}
+		args = append(args, "--chdir", dir, "--")
+	case "nsjail":
+		args = []string{"--mode", "o", "--quiet", "--keep_env", "--disable_rlimits", "--time_limit", "0", "--chroot", "/", "--cwd", dir}
+		for _, path := range sb.writable {
+			args = append(args, "--bindmount", path)
+		}
+		if !sb.offline {
+			args = append(args, "--disable_clone_newnet")
+		}
+		args = append(args, "--")
+	case "sandbox-exec":
+		args = []string{"-p", sb.profile()}
+	}
+	args = append(args, "bash", "-lc", command)
+	return exec.CommandContext(ctx, sb.tool, args...)
+}
+
+// profile renders the sandbox-exec (Seatbelt) profile.
+func (sb *sandbox) profile() string {
+	var b strings.Builder
+	b.WriteString("(version 1)\n(allow default)\n(deny file-write*)\n")
+	b.WriteString("(allow file-write* (subpath \"/dev\")")
+	for _, path := range sb.writable {
+		fmt.Fprintf(&b, " (subpath %q)", path)
+	}
+	b.WriteString(")\n")
+	if sb.offline {
+		b.WriteString("(deny network*)\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestSandboxCommandConfinesWrites(t *testing.T) {
+	sb := &sandbox{tool: "bwrap", offline: true, writable: []string{"/work/app", "/tmp"}}
+	args := strings.Join(sb.command(context.Background(), "make test", "/work/app").Args, " ")
+	for _, want := range []string{"--ro-bind / /", "--bind /work/app /work/app", "--bind /tmp /tmp", "--unshare-net", "--chdir /work/app -- bash -lc make test"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("bwrap args %q missing %q", args, want)
+		}
+	}
+
+	sb = &sandbox{tool: "sandbox-exec", writable: []string{"/Users/me/app"}}
+	profile := sb.profile()
+	if !strings.Contains(profile, "(deny file-write*)") || !strings.Contains(profile, `(subpath "/Users/me/app")`) || strings.Contains(profile, "network") {
+		t.Errorf("unexpected profile:\n%s", profile)
+	}
+}
+
+func TestNewSandboxRequiresTool(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if _, err := newSandbox(&dsl.Sandbox{}, t.TempDir(), t.TempDir()); err == nil {
+		t.Fatal("expected an error when no sandbox tool is installed")
+	}
+	if sb, err := newSandbox(nil, t.TempDir(), t.TempDir()); sb != nil || err != nil {
+		t.Fatalf("no sandbox block should run steps directly, got %+v, %v", sb, err)
+	}
+}
@@ -0,0 +1,283 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"devagent/internal/script"
+)
+
+// ScriptStepCommand is the hidden devagent command that runs a script
+// step; the devagent binary hands it to ScriptStepMain.
+const ScriptStepCommand = "__script-step"
+
+// scriptMaxHeap stops a script step whose process heap grows past it.
+const scriptMaxHeap = 512 << 20
+
+// scriptLabel names a script step by its source, whitespace collapsed.
+func scriptLabel(source string) string {
+	return "script " + strings.Join(strings.Fields(source), " ")
+}
+
+// scriptRun is what a script step sees of its run.
+type scriptRun struct {
+	job, repo, workdir string
+	outputsPath        string
+	step               int // 1-based
+	steps              []StepSummary
+	needs              map[string]map[string]string
+	vars               map[string]string
+}
+
+// scriptRequest is what a script step process is given, in a file in the
+// run directory.
+type scriptRequest struct {
+	Name    string                       `json:"name"`
+	Source  string                       `json:"source"`
+	Job     string                       `json:"job"`
+	Repo    string                       `json:"repo"`
+	Workdir string                       `json:"workdir"`
+	RunDir  string                       `json:"run_dir"`
+	Outputs string                       `json:"outputs"`
+	Step    int                          `json:"step"`
+	Steps   []scriptStep                 `json:"steps"`
+	Current map[string]string            `json:"current"`
+	Needs   map[string]map[string]string `json:"needs,omitempty"`
+	Vars    map[string]string            `json:"vars,omitempty"`
+}
+
+// scriptStep is an earlier step as the run.steps table shows it.
+type scriptStep struct {
+	Cmd         string  `json:"cmd"`
+	ExitCode    int     `json:"exit_code"`
+	DurationSec float64 `json:"duration_sec"`
+	Skipped     bool    `json:"skipped"`
+}
+
+// scriptCommand writes the request of a script step to requestPath, readable
+// only by the step user, and returns the shell command that runs the script
+// in a devagent process of its own. Running it like any other step puts
+// the script in the sandbox, as the run_as user, and keeps a script that
+// exhausts memory from taking the daemon down.
+func scriptCommand(source string, r scriptRun, su *stepUser, requestPath string) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("script step: %w", err)
+	}
+	current, err := collectOutputs(r.repo, filepath.Dir(r.outputsPath), nil)
+	if err != nil {
+		return "", err
+	}
+	req := scriptRequest{
+		Name:    fmt.Sprintf("step %d", r.step),
+		Source:  source,
+		Job:     r.job,
+		Repo:    r.repo,
+		Workdir: r.workdir,
+		RunDir:  filepath.Dir(r.outputsPath),
+		Outputs: r.outputsPath,
+		Step:    r.step,
+		Current: current,
+		Needs:   r.needs,
+		Vars:    r.vars,
+	}
+	for _, s := range r.steps {
+		req.Steps = append(req.Steps, scriptStep{Cmd: s.Cmd, ExitCode: s.ExitCode, DurationSec: s.DurationSec, Skipped: s.Skipped || s.Resumed})
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(requestPath, data, 0o600); err != nil {
+		return "", err
+	}
+	if err := su.own(requestPath); err != nil {
+		return "", err
+	}
+	return "exec " + shellQuote(executable) + " " + ScriptStepCommand + " " + shellQuote(requestPath), nil
+}
+
+// ScriptStepMain runs the script step described by the request file in
+// args, printing to stdout, and returns the process's exit code: 1 for a
+// script error, 2 when the request cannot be read. SIGTERM interrupts the
+// script, as cancelling the run does.
+func ScriptStepMain(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: devagent %s <request>\n", ScriptStepCommand)
+		return 2
+	}
+	var req scriptRequest
+	data, err := os.ReadFile(args[0])
+	if err == nil {
+		err = json.Unmarshal(data, &req)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "script step: %v\n", err)
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if _, err := script.Run(ctx, req.Name, req.Source, scriptGlobals(req), os.Stdout, script.Limits{MaxHeap: scriptMaxHeap}); err != nil {
+		fmt.Printf("script error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// scriptGlobals builds the devagent globals of a script: outputs, needs,
+// vars, env and run, and the output, read and write functions. env is the
+// environment of the script's process, which is the step environment.
+func scriptGlobals(req scriptRequest) map[string]script.Value {
+	outputs := script.FromGo(req.Current).(*script.Table)
+
+	env := script.NewTable()
+	for _, kv := range os.Environ() {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env.Set(key, value)
+		}
+	}
+
+	steps := script.NewTable()
+	for _, s := range req.Steps {
+		step := script.NewTable()
+		step.Set("cmd", s.Cmd)
+		step.Set("exit_code", float64(s.ExitCode))
+		step.Set("duration_sec", s.DurationSec)
+		step.Set("skipped", s.Skipped)
+		steps.Append(step)
+	}
+	run := script.NewTable()
+	for key, value := range map[string]string{"job": req.Job, "repo": req.Repo, "workdir": req.Workdir, "run_dir": req.RunDir} {
+		run.Set(key, value)
+	}
+	run.Set("step", float64(req.Step))
+	run.Set("steps", steps)
+
+	stringArg := func(args []script.Value, n int, what string) (string, error) {
+		if n < len(args) {
+			switch v := args[n].(type) {
+			case string:
+				return v, nil
+			case float64:
+				return script.ToString(v), nil
+			}
+		}
+		return "", fmt.Errorf("%s must be a string", what)
+	}
+
+	path := func(args []script.Value) (string, error) {
+		p, err := stringArg(args, 0, "path")
+		if err != nil {
+			return "", err
+		}
+		return confine(req.Workdir, p)
+	}
+
+	return map[string]script.Value{
+		"outputs": outputs,
+		"needs":   script.FromGo(req.Needs),
+		"vars":    script.FromGo(req.Vars),
+		"env":     env,
+		"run":     run,
+		// output(key, value) records an output as a step's
+		// `echo key=value >> "$DEVAGENT_OUTPUT"` does.
+		"output": script.Func("output", func(args []script.Value) ([]script.Value, error) {
+			key, err := stringArg(args, 0, "key")
+			if err != nil {
+				return nil, err
+			}
+			value, err := stringArg(args, 1, "value")
+			if err != nil {
+				return nil, err
+			}
+			if key == "" || strings.ContainsAny(key, "=\n") || strings.Contains(value, "\n") {
+				return nil, fmt.Errorf("invalid output %q: keys cannot contain '=' and neither can span lines", key)
+			}
+			f, err := os.OpenFile(req.Outputs, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			_, err = fmt.Fprintf(f, "%s=%s\n", key, value)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+			outputs.Set(key, value)
+			return nil, nil
+		}),
+		// read(path) returns a file's contents, nil when it does not exist.
+		"read": script.Func("read", func(args []script.Value) ([]script.Value, error) {
+			p, err := path(args)
+			if err != nil {
+				return nil, err
+			}
+			data, err := os.ReadFile(p)
+			if errors.Is(err, os.ErrNotExist) {
+				return []script.Value{nil}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > script.MaxString {
+				return nil, fmt.Errorf("%s is larger than %d MB", p, script.MaxString>>20)
+			}
+			return []script.Value{string(data)}, nil
+		}),
+		// write(path, data) creates or replaces a file, and its directory.
+		"write": script.Func("write", func(args []script.Value) ([]script.Value, error) {
+			p, err := path(args)
+			if err != nil {
+				return nil, err
+			}
+			data, err := stringArg(args, 1, "data")
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(p, []byte(data), 0o644)
+		}),
+	}
+}
+
+// confine resolves path against dir, following symlinks, and fails when
+// the result leaves dir. Of a path that does not exist yet, the part that
+// does is resolved.
+func confine(dir, path string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	resolved, rest := filepath.Clean(path), ""
+	for {
+		real, err := filepath.EvalSymlinks(resolved)
+		if err == nil {
+			resolved = filepath.Join(real, rest)
+			break
+		}
+		parent := filepath.Dir(resolved)
+		if !errors.Is(err, fs.ErrNotExist) || parent == resolved {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(resolved), rest)
+		resolved = parent
+	}
+	if !within(root, resolved) {
+		return "", fmt.Errorf("%s is outside the working directory", path)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunScriptStep(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, "report.json"), []byte(`{"tests": [{"ok": true}, {"ok": false}, {"ok": true}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "munge", Repo: repo, Steps: []dsl.Step{
+		{Script: `output("version", "1.2.3")`},
+		{Script: `
+local report = json.decode(read("report.json"))
+local passed = 0
+for _, t in ipairs(report.tests) do
+  if t.ok then passed = passed + 1 end
+end
+print(("%s step %d: %d/%d passed, v%s from %s"):format(run.job, run.step, passed, #report.tests, outputs.version, needs.build.commit))
+output("passed", passed)
+write("out/major.txt", outputs.version:split(".")[1])
+`},
+	}}
+	if jobs := NeededJobs(wf); len(jobs) != 1 || jobs[0] != "build" {
+		t.Fatalf("expected the script's needs to be found, got %v", jobs)
+	}
+	needs := map[string]map[string]string{"build": {"commit": "abc123"}}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Needs: needs, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" || summary.Outputs["passed"] != "2" || summary.Outputs["version"] != "1.2.3" {
+		t.Fatalf("got %s with outputs %v", summary.Status, summary.Outputs)
+	}
+	if label := summary.Steps[0].Cmd; label != `script output("version", "1.2.3")` {
+		t.Fatalf("step recorded as %q", label)
+	}
+	log, _ := os.ReadFile(filepath.Join(summary.RunDir, "step-2.log"))
+	if !strings.Contains(string(log), "munge step 2: 2/3 passed, v1.2.3 from abc123") {
+		t.Fatalf("script output not logged:\n%s", log)
+	}
+	if major, _ := os.ReadFile(filepath.Join(repo, "out", "major.txt")); string(major) != "1" {
+		t.Fatalf("expected the script to write out/major.txt, got %q", major)
+	}
+
+	wf.Steps = []dsl.Step{{Script: "local n = 1\nerror('threshold ' .. n)"}}
+	summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil || summary.Status != "failed" || summary.Steps[0].ExitCode != 1 {
+		t.Fatalf("expected the script error to fail the step, got %v %+v", err, summary)
+	}
+	if !strings.Contains(strings.Join(summary.Steps[0].Tail, "\n"), "script error: step 1:2: threshold 1") {
+		t.Fatalf("expected the error in the step tail, got %q", summary.Steps[0].Tail)
+	}
+
+	wf.Steps = []dsl.Step{{Script: `write("../escape.txt", "x")`}}
+	summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil || summary.Status != "failed" || !strings.Contains(strings.Join(summary.Steps[0].Tail, "\n"), "outside the working directory") {
+		t.Fatalf("expected writes outside the working directory to fail, got %v %+v", err, summary)
+	}
+
+	// A symlink in the working directory does not lead read or write out
+	// of it.
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("s3cret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(repo, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{`print(read("link/secret"))`, `write("link/new/file", "x")`} {
+		wf.Steps = []dsl.Step{{Script: source}}
+		summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+		if err != nil || summary.Status != "failed" || !strings.Contains(strings.Join(summary.Steps[0].Tail, "\n"), "outside the working directory") {
+			t.Fatalf("%s: expected the symlink to be refused, got %v %+v", source, err, summary)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); err == nil {
+		t.Fatal("the script wrote through the symlink")
+	}
+}
+
+func TestScriptStepLimits(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "hog", Repo: repo, Steps: []dsl.Step{
+		{Script: `local s = "x" for i = 1, 40 do s = s .. s end`},
+	}}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil || summary.Status != "failed" || !strings.Contains(strings.Join(summary.Steps[0].Tail, "\n"), "resulting string too large") {
+		t.Fatalf("expected the script to be stopped, got %v %+v", err, summary)
+	}
+
+	// The script runs in a process of its own, so even a heap limit hit
+	// leaves this one alone.
+	wf.Steps = []dsl.Step{{Script: `local t = {} while true do t[#t + 1] = ("x"):rep(2^20) .. #t end`}}
+	summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil || summary.Status != "failed" || !strings.Contains(strings.Join(summary.Steps[0].Tail, "\n"), "out of memory") {
+		t.Fatalf("expected the heap limit to stop the script, got %v %+v", err, summary)
+	}
+
+	// The policy applies to the source.
+	wf.Steps = []dsl.Step{{Script: `print("mkfs.ext4 /dev/sda1")`}}
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); err == nil || !strings.Contains(err.Error(), "formats a filesystem") {
+		t.Fatalf("expected the policy to refuse the script, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+)
+
+// withShell prefixes command with the workflow's shell setup: sourcing the
+// listed files, running the init lines and loading direnv, in that order,
+// so steps see the same tooling as an interactive shell.
+func withShell(cfg *dsl.Shell, command string) string {
+	if cfg == nil {
+		return command
+	}
+	var b strings.Builder
+	for _, path := range cfg.Source {
+		if expanded, err := paths.Expand(path); err == nil {
+			path = expanded
+		}
+		b.WriteString(". " + shellQuote(path) + "\n")
+	}
+	for _, line := range cfg.Init {
+		b.WriteString(line + "\n")
+	}
+	if cfg.Direnv {
+		b.WriteString("eval \"$(direnv export bash)\"\n")
+	}
+	if b.Len() == 0 {
+		return command
+	}
+	return b.String() + command
+}
+
+// initSteps returns the shell init lines as steps for the policy check.
+func initSteps(cfg *dsl.Shell) []dsl.Step {
+	if cfg == nil {
+		return nil
+	}
+	steps := make([]dsl.Step, 0, len(cfg.Init))
+	for _, line := range cfg.Init {
+		steps = append(steps, dsl.Step{Run: line})
+	}
+	return steps
+}
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunAppliesShellSetup(t *testing.T) {
+	repo := t.TempDir()
+	rc := filepath.Join(t.TempDir(), "tools.sh")
+	if err := os.WriteFile(rc, []byte("export TOOL_HOME=/opt/tool\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo,
+		Shell: &dsl.Shell{Source: []string{rc}, Init: []string{`export TOOL_VERSION="$TOOL_HOME/v2"`}},
+		Steps: []dsl.Step{{Run: `printf %s "$TOOL_VERSION" > seen`}},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen, _ := os.ReadFile(filepath.Join(repo, "seen"))
+	if summary.Status != "success" || string(seen) != "/opt/tool/v2" {
+		t.Fatalf("expected the sourced file and init line to apply, got %s %q", summary.Status, seen)
+	}
+	if summary.Steps[0].Cmd != `printf %s "$TOOL_VERSION" > seen` {
+		t.Fatalf("the summary should record the step without the shell setup, got %q", summary.Steps[0].Cmd)
+	}
+
+	wf.Shell.Init = []string{"sudo true"}
+	var policyErr *PolicyError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()}); !errors.As(err, &policyErr) {
+		t.Fatalf("expected init lines to be checked against the policy, got %v", err)
+	}
+}
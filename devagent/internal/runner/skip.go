@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hashFiles returns the SHA-256 of the files matching patterns, globs
+// relative to repo. Matching directories are hashed recursively, leaving
+// out .git and devagent_runs. It also reports how many files matched.
+func hashFiles(repo string, patterns []string) (string, int, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(repo, strings.TrimSpace(pattern)))
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if path != match && (d.Name() == ".git" || d.Name() == "devagent_runs") {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if d.Type().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	sort.Strings(files)
+	sum := sha256.New()
+	last := ""
+	for _, file := range files {
+		if file == last {
+			continue
+		}
+		last = file
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", 0, err
+		}
+		rel, _ := filepath.Rel(repo, file)
+		fmt.Fprintf(sum, "%s\x00%d\x00", filepath.ToSlash(rel), len(data))
+		sum.Write(data)
+	}
+	return hex.EncodeToString(sum.Sum(nil)), len(files), nil
+}
+
+// lastSuccess returns the summary of the most recent successful run of job
+// under runsDir, or nil when there is none.
+func lastSuccess(runsDir, job string) *Summary {
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		return nil
+	}
+	var best *Summary
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		summary, err := LoadSummary(filepath.Join(runsDir, entry.Name()))
+		if err != nil || summary.Name != job || summary.Status != "success" {
+			continue
+		}
+		if best == nil || summary.StartedAt.After(best.StartedAt) {
+			best = summary
+		}
+	}
+	return best
+}
+
+// unchangedSince reports whether the previous run ran or skipped the step
+// cmd with the same input hash.
+func unchangedSince(previous *Summary, cmd, hash string) bool {
+	if previous == nil {
+		return false
+	}
+	for _, step := range previous.Steps {
+		if step.Cmd == cmd && step.InputHash == hash && step.ExitCode == 0 {
+			return true
+		}
+	}
+	return false
+}
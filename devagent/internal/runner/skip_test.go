@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestRunSkipsStepsWithUnchangedInputs(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repo, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "docs", "index.md"), []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "docs", Repo: repo, Steps: []dsl.Step{
+		{Run: "echo built >> builds", SkipUnlessChanged: []string{"docs"}},
+		{Run: "true"},
+	}}
+	builds := func() int {
+		data, _ := os.ReadFile(filepath.Join(repo, "builds"))
+		return strings.Count(string(data), "built")
+	}
+
+	first, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Steps[0].Skipped || first.Steps[0].InputHash == "" || builds() != 1 {
+		t.Fatalf("first run should build and record the input hash, got %+v", first.Steps[0])
+	}
+
+	second, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Steps[0].Skipped || second.Steps[0].InputHash != first.Steps[0].InputHash || builds() != 1 {
+		t.Fatalf("unchanged inputs should skip the step, got %+v", second.Steps[0])
+	}
+	if second.Steps[1].Skipped {
+		t.Fatalf("steps without inputs always run")
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "docs", "index.md"), []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	third, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Steps[0].Skipped || builds() != 2 {
+		t.Fatalf("changed inputs should run the step, got %+v", third.Steps[0])
+	}
+}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+// sqlOutput returns the result file of a sql step in runDir.
+func sqlOutput(q *dsl.SQL, runDir string, index int) string {
+	name := q.Output
+	if name == "" {
+		name = fmt.Sprintf("step-%d.csv", index+1)
+	}
+	out := filepath.Join(runDir, name)
+	if abs, err := filepath.Abs(out); err == nil {
+		out = abs
+	}
+	return out
+}
+
+// runSQL runs a sql step's query and writes the rows to outPath, logging
+// the row count to w and logPath. A query that fails gives exit code 1;
+// errors are reserved for local problems such as an unwritable log.
+func runSQL(ctx context.Context, q *dsl.SQL, outPath string, extra extraEnv, w io.Writer, logPath string, logs *dsl.Logs) (int, error) {
+	stepLog, err := openLog(logPath, logs)
+	if err != nil {
+		return 0, err
+	}
+	defer stepLog.Close()
+
+	dsn := q.DSN
+	if q.DSNEnv != "" {
+		// The variable is looked up in env_files first, then in devagent's
+		// own environment, where secret-named variables are still present.
+		dsn = os.Getenv(q.DSNEnv)
+		for _, kv := range extra.vars {
+			if key, value, _ := strings.Cut(kv, "="); key == q.DSNEnv {
+				dsn = value
+			}
+		}
+	}
+	secrets := extra.secrets
+	if len(dsn) >= minRedactedValue {
+		secrets = append(secrets, dsn)
+	}
+	out := newRedactingWriter(io.MultiWriter(w, stepLog), secrets...)
+	defer out.Flush()
+
+	if dsn == "" {
+		fmt.Fprintf(out, "sql dsn_env %s is not set\n", q.DSNEnv)
+		return 1, nil
+	}
+	if !driverAvailable(q.Driver) {
+		fmt.Fprintf(out, "sql driver %q is not built into devagent (available: %s)\n", q.Driver, strings.Join(sql.Drivers(), ", "))
+		return 1, nil
+	}
+	db, err := sql.Open(q.Driver, dsn)
+	if err != nil {
+		fmt.Fprintf(out, "open database: %v\n", err)
+		return 1, nil
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, q.Query)
+	if err != nil {
+		fmt.Fprintf(out, "query failed: %v\n", err)
+		return 1, nil
+	}
+	defer rows.Close()
+	count, err := writeRows(rows, outPath)
+	if err != nil {
+		fmt.Fprintf(out, "query failed: %v\n", err)
+		return 1, nil
+	}
+	fmt.Fprintf(out, "%d rows written to %s\n", count, filepath.Base(outPath))
+	return 0, nil
+}
+
+func driverAvailable(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRows writes rows to path as CSV with a header line, or as a JSON
+// array of objects when path ends in .json, and returns the row count.
+func writeRows(rows *sql.Rows, path string) (int, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	asJSON := filepath.Ext(path) == ".json"
+	var csvOut *csv.Writer
+	var records []map[string]any
+	if asJSON {
+		records = []map[string]any{}
+	} else {
+		csvOut = csv.NewWriter(f)
+		if err := csvOut.Write(columns); err != nil {
+			return 0, err
+		}
+	}
+
+	values := make([]any, len(columns))
+	scan := make([]any, len(columns))
+	for i := range values {
+		scan[i] = &values[i]
+	}
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(scan...); err != nil {
+			return count, err
+		}
+		count++
+		if asJSON {
+			record := make(map[string]any, len(columns))
+			for i, column := range columns {
+				record[column] = jsonValue(values[i])
+			}
+			records = append(records, record)
+			continue
+		}
+		fields := make([]string, len(columns))
+		for i, value := range values {
+			fields[i] = csvValue(value)
+		}
+		if err := csvOut.Write(fields); err != nil {
+			return count, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if asJSON {
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return count, err
+		}
+		_, err = f.Write(append(data, '\n'))
+		return count, err
+	}
+	csvOut.Flush()
+	return count, csvOut.Error()
+}
+
+func csvValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+func jsonValue(value any) any {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestRunSQLStep(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "metrics.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE builds (name TEXT, minutes REAL, note TEXT);
+		INSERT INTO builds VALUES ('api', 4.5, NULL), ('web', 12, 'slow, flaky');`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, ".env"), []byte("METRICS_DSN="+dbPath+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wf := &dsl.Workflow{Name: "report", Repo: repo, EnvFiles: []string{".env"},
+		Steps: []dsl.Step{
+			{SQL: &dsl.SQL{Driver: "sqlite", DSNEnv: "METRICS_DSN", Query: "SELECT name, minutes, note FROM builds ORDER BY name"}},
+			{SQL: &dsl.SQL{Driver: "sqlite", DSN: dbPath, Query: "SELECT name FROM builds WHERE minutes > 10", Output: "slow.json"}},
+		},
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" {
+		t.Fatalf("expected success, got %s %+v", summary.Status, summary.Steps)
+	}
+	if got := summary.Steps[0].Artifacts; len(got) != 1 || got[0] != "step-1.csv" {
+		t.Fatalf("expected the CSV to be recorded as an artifact, got %v", got)
+	}
+	csvData, _ := os.ReadFile(filepath.Join(summary.RunDir, "step-1.csv"))
+	if want := "name,minutes,note\napi,4.5,\nweb,12,\"slow, flaky\"\n"; string(csvData) != want {
+		t.Fatalf("got CSV %q, want %q", csvData, want)
+	}
+	var rows []map[string]any
+	jsonData, _ := os.ReadFile(filepath.Join(summary.RunDir, "slow.json"))
+	if err := json.Unmarshal(jsonData, &rows); err != nil || len(rows) != 1 || rows[0]["name"] != "web" {
+		t.Fatalf("unexpected JSON output %s: %v", jsonData, err)
+	}
+	log, _ := os.ReadFile(filepath.Join(summary.RunDir, "run.log"))
+	if strings.Contains(string(log), dbPath) || !strings.Contains(string(log), "2 rows written to step-1.csv") {
+		t.Fatalf("expected row counts logged and the DSN redacted:\n%s", log)
+	}
+
+	wf.Steps = []dsl.Step{{SQL: &dsl.SQL{Driver: "sqlite", DSN: dbPath, Query: "SELECT * FROM missing"}}}
+	summary, err = Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "failed" || summary.Steps[0].ExitCode != 1 || len(summary.Steps[0].Artifacts) != 0 {
+		t.Fatalf("expected a failing query to fail the step, got %s %+v", summary.Status, summary.Steps)
+	}
+
+	wf.Steps = []dsl.Step{{SQL: &dsl.SQL{Driver: "sqlite", DSN: dbPath, Query: "DROP TABLE builds"}}}
+	var policyErr *PolicyError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Policy: denyPolicy(t, `(?i)\bdrop\s+table\b`)}); !errors.As(err, &policyErr) {
+		t.Fatalf("expected the policy to deny the query, got %v", err)
+	}
+}
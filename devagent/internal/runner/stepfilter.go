@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// StepSelection picks the steps of a manual run while keeping their
+// numbers. Only and Skip hold comma-separated lists of glob patterns, in
+// which * matches any text, including slashes, and ? any one character. A
+// step matches by its 1-based number, its name or, without one, its
+// command. From is the first step to run. Filter is the --step-filter
+// list, which selects steps as Only does.
+type StepSelection struct {
+	Only   []string
+	Skip   []string
+	From   string
+	Filter string
+}
+
+// selectSteps reports which of steps sel leaves in. Every pattern must
+// match a step, so that a misspelt name is not silently ignored.
+func selectSteps(steps []dsl.Step, sel *StepSelection) ([]bool, error) {
+	selected := make([]bool, len(steps))
+	for i := range selected {
+		selected[i] = len(sel.Only) == 0 && sel.Filter == ""
+	}
+	// mark sets selected[i] to value for the steps matching filter.
+	mark := func(flag, filter string, value bool) error {
+		patterns := stepPatterns(filter)
+		matched := false
+		for i, step := range steps {
+			if stepMatches(patterns, step, i) {
+				selected[i], matched = value, true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("--%s %s matches no step", flag, filter)
+		}
+		return nil
+	}
+	for _, filter := range sel.Only {
+		if err := mark("only", filter, true); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+	}
+	if sel.Filter != "" {
+		if err := mark("step-filter", sel.Filter, true); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+	}
+	for _, filter := range sel.Skip {
+		if err := mark("skip", filter, false); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+	}
+	if sel.From != "" {
+		patterns := stepPatterns(sel.From)
+		from := -1
+		for i, step := range steps {
+			if stepMatches(patterns, step, i) {
+				from = i
+				break
+			}
+		}
+		if from < 0 {
+			return nil, &ConfigError{Err: fmt.Errorf("--from %s matches no step", sel.From)}
+		}
+		for i := 0; i < from; i++ {
+			selected[i] = false
+		}
+	}
+	for _, keep := range selected {
+		if keep {
+			return selected, nil
+		}
+	}
+	return nil, &ConfigError{Err: errors.New("the step selection leaves no step to run")}
+}
+
+// stepPatterns compiles a comma-separated list of step patterns.
+func stepPatterns(filter string) []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, glob := range strings.Split(filter, ",") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			patterns = append(patterns, stepGlob(glob))
+		}
+	}
+	return patterns
+}
+
+// stepMatches reports whether the step at index matches one of patterns by
+// its 1-based number, its name or, without one, its command.
+func stepMatches(patterns []*regexp.Regexp, step dsl.Step, index int) bool {
+	name := step.Name
+	if name == "" {
+		name = resolveStep(step, "", index).label
+	}
+	number := strconv.Itoa(index + 1)
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) || pattern.MatchString(number) {
+			return true
+		}
+	}
+	return false
+}
+
+// stepGlob compiles a step filter pattern.
+func stepGlob(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+	"devagent/internal/policy"
+)
+
+func TestSelectStepsFilter(t *testing.T) {
+	steps := []dsl.Step{
+		{Run: "make build"},
+		{Run: "./scripts/deploy.sh web", Name: "deploy-web"},
+		{Run: "./scripts/deploy.sh worker"},
+		{HTTP: &dsl.HTTP{URL: "https://example.com/hooks/deployed"}},
+	}
+	cases := map[string][]bool{
+		"deploy*":            {false, true, false, false},
+		"./scripts/deploy*":  {false, false, true, false},
+		"make build, http *": {true, false, false, true},
+		"*deploy*":           {false, true, true, true},
+		"2":                  {false, true, false, false},
+	}
+	for filter, want := range cases {
+		got, err := selectSteps(steps, &StepSelection{Filter: filter})
+		if err != nil {
+			t.Fatalf("%q: %v", filter, err)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%q: selected %v, want %v", filter, got, want)
+				break
+			}
+		}
+	}
+	if _, err := selectSteps(steps, &StepSelection{Filter: "lint"}); err == nil || !strings.Contains(err.Error(), "--step-filter lint matches no step") {
+		t.Fatalf("expected no match, got %v", err)
+	}
+}
+
+func TestRunWithVars(t *testing.T) {
+	wf := &dsl.Workflow{Name: "deploy", Repo: t.TempDir(), Vars: map[string]string{"env": "production", "replicas": "3"}, Steps: []dsl.Step{
+		{Run: `echo "target=${{ vars.env }}x${{ vars.replicas }}" >> "$DEVAGENT_OUTPUT"`},
+		{Script: `output("upper", vars.env:upper())`},
+	}}
+	if err := wf.SetVar("env", "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.SetVar("region", "eu"); err == nil || !strings.Contains(err.Error(), "deploy declares env, replicas") {
+		t.Fatalf("expected undeclared vars to be refused, got %v", err)
+	}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Policy: policy.Default()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Outputs["target"] != "stagingx3" || summary.Outputs["upper"] != "STAGING" {
+		t.Fatalf("unexpected outputs %v", summary.Outputs)
+	}
+
+	wf.Steps = []dsl.Step{{Run: "echo ${{ vars.envv }}"}}
+	var configErr *ConfigError
+	if _, err := Run(context.Background(), Options{Workflow: wf}); !errors.As(err, &configErr) || !strings.Contains(err.Error(), "unknown var envv") {
+		t.Fatalf("expected an unknown var error, got %v", err)
+	}
+}
+
+func TestRunStepSelection(t *testing.T) {
+	repo := t.TempDir()
+	wf := &dsl.Workflow{Name: "pipeline", Repo: repo, Steps: []dsl.Step{
+		{Run: "echo fetched > data", Name: "fetch"},
+		{Run: "echo built >> data", Name: "build"},
+		{Run: "echo slow-test >> data", Name: "test"},
+		{Run: "echo published >> data", Name: "publish"},
+	}}
+	run := func(sel *StepSelection) *Summary {
+		t.Helper()
+		summary, err := Run(context.Background(), Options{Workflow: wf, Select: sel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Status != "success" {
+			t.Fatalf("unexpected status %s", summary.Status)
+		}
+		return summary
+	}
+	deselected := func(summary *Summary) string {
+		var out []string
+		for i, step := range summary.Steps {
+			if step.Deselected {
+				out = append(out, strconv.Itoa(i+1))
+			}
+		}
+		return strings.Join(out, ",")
+	}
+
+	summary := run(&StepSelection{From: "build", Skip: []string{"test"}})
+	if got := deselected(summary); got != "1,3" {
+		t.Fatalf("expected steps 1 and 3 left out, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(summary.RunDir, "step-4.log")); err != nil {
+		t.Fatalf("the selected steps keep their numbers: %v", err)
+	}
+	// A run that left steps out cannot be resumed past them.
+	if cp, err := loadCheckpoint(summary.RunDir); err != nil || cp.Completed != 0 {
+		t.Fatalf("expected the checkpoint to stop at the gap, got %+v (%v)", cp, err)
+	}
+
+	if got := deselected(run(&StepSelection{Only: []string{"publish", "1"}})); got != "2,3" {
+		t.Fatalf("expected steps 2 and 3 left out, got %q", got)
+	}
+	if got := deselected(run(&StepSelection{Only: []string{"te*"}})); got != "1,2,4" {
+		t.Fatalf("expected only the test step, got %q", got)
+	}
+
+	for _, sel := range []*StepSelection{{Skip: []string{"tset"}}, {From: "deploy"}, {Only: []string{"build"}, Skip: []string{"build"}}} {
+		var configErr *ConfigError
+		if _, err := Run(context.Background(), Options{Workflow: wf, Select: sel}); !errors.As(err, &configErr) {
+			t.Errorf("%+v: expected a config error, got %v", sel, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// tailLineCount is how many trailing lines of a failed step are kept.
+	tailLineCount = 20
+	// tailMaxBytes caps the tail so one long line cannot bloat the summary.
+	tailMaxBytes = 4096
+)
+
+// logTail returns up to n redacted lines from the end of the file at path,
+// reading at most maxBytes. A line cut by the byte limit is dropped.
+func logTail(path string, n int, maxBytes int64) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - maxBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+	for i, line := range lines {
+		lines[i] = redact(line)
+	}
+	return lines, nil
+}
+
+// FailureTail returns the captured output tail of the first failed step,
+// the unmet preconditions, the fail_if conditions that held or the
+// benchmark regressions, or "" when nothing failed.
+func (s *Summary) FailureTail() string {
+	if len(s.Unmet) > 0 {
+		return "precondition failed: " + strings.Join(s.Unmet, "\nprecondition failed: ")
+	}
+	if len(s.FailedIf) > 0 {
+		return "fail_if: " + strings.Join(s.FailedIf, "\nfail_if: ")
+	}
+	for _, step := range s.Steps {
+		if step.ExitCode != 0 {
+			return strings.Join(step.Tail, "\n")
+		}
+	}
+	if len(s.BenchRegressions) > 0 {
+		var lines []string
+		for _, change := range s.BenchRegressions {
+			lines = append(lines, "bench regression: "+change.String())
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogTailKeepsLastLinesRedacted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "step-1.log")
+	var b strings.Builder
+	for i := 0; i < 50; i++ {
+		b.WriteString("progress line\n")
+	}
+	b.WriteString("token=abc123\nerror: build failed\n")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := logTail(path, 3, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"progress line", "token=<redacted>", "error: build failed"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+
+	lines, err = logTail(path, 20, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0] != "error: build failed" {
+		t.Fatalf("byte cap should drop the partial line, got %q", lines)
+	}
+}
@@ -0,0 +1,227 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/util"
+)
+
+// functionPattern matches `${{ name }}` and `${{ name('arg', ...) }}`.
+var functionPattern = regexp.MustCompile(`\$\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*(?:\(((?:[^)'"]|'[^']*'|"[^"]*")*)\))?\s*\}\}`)
+
+// varsPattern matches `${{ vars.<name> }}`.
+var varsPattern = regexp.MustCompile(`\$\{\{\s*vars\.([A-Za-z0-9_\-]+)\s*\}\}`)
+
+// templateFuncs evaluates the functions available in step fields next to
+// `${{ needs.… }}`: now, run_id, repo_basename and last_success_date, and
+// the workflow's vars.
+type templateFuncs struct {
+	now   time.Time // when the run started, in the schedule's timezone
+	runID int64
+	repo  string
+	job   string
+	vars  map[string]string
+	// lastSuccess is the start of the job's last successful run, loaded on
+	// first use; ok is false when there is none.
+	lastSuccess func() (t time.Time, ok bool)
+}
+
+// newTemplateFuncs returns the functions for a run of wf in repo starting
+// now.
+func newTemplateFuncs(wf *dsl.Workflow, repo string, runID int64) *templateFuncs {
+	loc := util.ResolveLocation(wf.Schedule.Timezone)
+	tf := &templateFuncs{now: time.Now().In(loc), runID: runID, repo: repo, job: wf.Name, vars: wf.Vars}
+	var (
+		loaded bool
+		last   time.Time
+		found  bool
+	)
+	tf.lastSuccess = func() (time.Time, bool) {
+		if !loaded {
+			loaded = true
+			if previous := lastSuccess(filepath.Join(repo, "devagent_runs"), wf.Name); previous != nil {
+				last, found = previous.StartedAt.In(loc), true
+			}
+		}
+		return last, found
+	}
+	return tf
+}
+
+// call evaluates the function name with args.
+func (tf *templateFuncs) call(name string, args []string) (string, error) {
+	// format and offset are the optional date arguments of now and
+	// last_success_date.
+	dateArgs := func() (format string, offset time.Duration, err error) {
+		if len(args) > 2 {
+			return "", 0, fmt.Errorf("%s takes a format and an offset", name)
+		}
+		if len(args) > 1 {
+			if offset, err = time.ParseDuration(args[1]); err != nil {
+				return "", 0, fmt.Errorf("%s offset %q: use a duration such as -24h", name, args[1])
+			}
+		}
+		if len(args) > 0 {
+			format = args[0]
+		}
+		return format, offset, nil
+	}
+	noArgs := func() error {
+		if len(args) > 0 {
+			return fmt.Errorf("%s takes no arguments", name)
+		}
+		return nil
+	}
+
+	switch name {
+	case "now":
+		format, offset, err := dateArgs()
+		if err != nil {
+			return "", err
+		}
+		return strftime(tf.now.Add(offset), format), nil
+	case "last_success_date":
+		format, offset, err := dateArgs()
+		if err != nil {
+			return "", err
+		}
+		last, ok := tf.lastSuccess()
+		if !ok {
+			if len(args) < 2 {
+				return "", fmt.Errorf("last_success_date: %s has no successful run yet (pass a fallback offset such as last_success_date('%%F', '-168h'))", tf.job)
+			}
+			last = tf.now.Add(offset)
+		}
+		return strftime(last, format), nil
+	case "run_id":
+		if err := noArgs(); err != nil {
+			return "", err
+		}
+		if tf.runID == 0 {
+			return "", nil
+		}
+		return strconv.FormatInt(tf.runID, 10), nil
+	case "repo_basename":
+		if err := noArgs(); err != nil {
+			return "", err
+		}
+		return filepath.Base(tf.repo), nil
+	}
+	return "", fmt.Errorf("unknown function %s (use now, run_id, repo_basename or last_success_date)", name)
+}
+
+// expand replaces the var and function references in text, collecting
+// errors.
+func (tf *templateFuncs) expand(text string, errs *[]string) string {
+	text = varsPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		name := varsPattern.FindStringSubmatch(ref)[1]
+		if value, ok := tf.vars[name]; ok {
+			return value
+		}
+		*errs = append(*errs, fmt.Sprintf("unknown var %s (declare it under vars)", name))
+		return ref
+	})
+	return functionPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		match := functionPattern.FindStringSubmatch(ref)
+		args, err := templateArgs(match[2])
+		if err == nil {
+			var value string
+			if value, err = tf.call(match[1], args); err == nil {
+				return value
+			}
+		}
+		*errs = append(*errs, err.Error())
+		return ref
+	})
+}
+
+// templateArgs splits a function's comma-separated, quoted arguments.
+func templateArgs(text string) ([]string, error) {
+	var args []string
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		quote := rest[0]
+		if quote != '\'' && quote != '"' {
+			return nil, fmt.Errorf("arguments must be quoted: (%s)", text)
+		}
+		end := strings.IndexByte(rest[1:], quote)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated argument: (%s)", text)
+		}
+		args = append(args, rest[1:end+1])
+		rest = strings.TrimSpace(rest[end+2:])
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("arguments must be separated by commas: (%s)", text)
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+	return args, nil
+}
+
+// strftime formats t with the strftime conversions %Y, %m, %d, %H, %M, %S,
+// %y, %b, %B, %a, %A, %j, %z, %Z, %s, %F, %T and %%; an empty format gives
+// RFC 3339.
+func strftime(t time.Time, format string) string {
+	if format == "" {
+		return t.Format(time.RFC3339)
+	}
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			b.WriteString(t.Format("15:04:05"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+func TestExpandStepsTemplateFunctions(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "webapp")
+	wf := &dsl.Workflow{Name: "report", Repo: repo, Schedule: dsl.Schedule{Timezone: "UTC"}}
+	tf := newTemplateFuncs(wf, repo, 42)
+	tf.now = time.Date(2026, 3, 9, 14, 5, 6, 0, time.UTC)
+
+	steps := []dsl.Step{
+		{Run: "report --id ${{ run_id }} --repo ${{repo_basename}} --at ${{ now }}"},
+		{Run: "archive-${{ now('%Y%m%d-%H%M') }}.tgz ${{ now(\"%b %d, %Y\", '-24h') }} 100%"},
+		{Run: "git log --since=${{ last_success_date('%F', '-168h') }}"},
+		{Run: "echo ${{ needs.build.outputs.version }}"},
+	}
+	needs := map[string]map[string]string{"build": {"version": "1.2.3"}}
+	got, err := expandSteps(steps, needs, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"report --id 42 --repo webapp --at 2026-03-09T14:05:06Z",
+		"archive-20260309-1405.tgz Mar 08, 2026 100%",
+		"git log --since=2026-03-02",
+		"echo 1.2.3",
+	}
+	for i := range want {
+		if got[i].Run != want[i] {
+			t.Errorf("step %d: got %q want %q", i+1, got[i].Run, want[i])
+		}
+	}
+
+	// Once the job has succeeded, last_success_date is when that run started.
+	runDir := filepath.Join(repo, "devagent_runs", "2026-03-08T02-00-00Z")
+	if err := os.MkdirAll(runDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	previous := &Summary{Name: "report", Status: "success", StartedAt: time.Date(2026, 3, 8, 2, 0, 0, 0, time.UTC)}
+	if err := writeSummary(filepath.Join(runDir, "summary.json"), previous); err != nil {
+		t.Fatal(err)
+	}
+	tf = newTemplateFuncs(wf, repo, 0)
+	got, err = expandSteps([]dsl.Step{{Run: "since ${{ last_success_date('%F %T') }} run=${{ run_id }}"}}, nil, tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Run != "since 2026-03-08 02:00:00 run=" {
+		t.Fatalf("got %q", got[0].Run)
+	}
+}
+
+func TestExpandStepsTemplateErrors(t *testing.T) {
+	repo := t.TempDir()
+	tf := newTemplateFuncs(&dsl.Workflow{Name: "report"}, repo, 0)
+	_, err := expandSteps([]dsl.Step{
+		{Run: "echo ${{ today }}"},
+		{Run: "git log --since=${{ last_success_date('%F') }}"},
+		{Run: "echo ${{ now('%F', 'yesterday') }} ${{ now(%F) }}"},
+	}, nil, tf)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"unknown function today", "report has no successful run yet", `now offset "yesterday"`, "arguments must be quoted"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
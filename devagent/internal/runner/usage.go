@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"os"
+	"syscall"
+)
+
+// Usage is the resources consumed by a step's process tree, taken from the
+// rusage reported when the step exits.
+type Usage struct {
+	// CPUSec is user plus system CPU time.
+	CPUSec float64 `json:"cpu_sec,omitempty"`
+	// MaxRSSBytes is the peak resident set size of the largest process.
+	MaxRSSBytes int64 `json:"max_rss_bytes,omitempty"`
+	// WrittenBytes estimates the bytes written to disk from the number of
+	// block output operations.
+	WrittenBytes int64 `json:"written_bytes,omitempty"`
+}
+
+// Add accumulates u into the run total: CPU time and bytes written add up
+// while the peak RSS is the largest seen.
+func (t *Usage) Add(u Usage) {
+	t.CPUSec += u.CPUSec
+	t.WrittenBytes += u.WrittenBytes
+	if u.MaxRSSBytes > t.MaxRSSBytes {
+		t.MaxRSSBytes = u.MaxRSSBytes
+	}
+}
+
+func usageOf(state *os.ProcessState) Usage {
+	if state == nil {
+		return Usage{}
+	}
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return Usage{}
+	}
+	return Usage{
+		CPUSec:       (state.UserTime() + state.SystemTime()).Seconds(),
+		MaxRSSBytes:  maxRSSBytes(ru),
+		WrittenBytes: int64(ru.Oublock) * outputBlockSize,
+	}
+}
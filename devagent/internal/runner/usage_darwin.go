@@ -0,0 +1,12 @@
+package runner
+
+import "syscall"
+
+// outputBlockSize approximates the bytes per block output operation counted
+// in ru_oublock, using the default APFS block size.
+const outputBlockSize = 4096
+
+// maxRSSBytes converts ru_maxrss, reported in bytes on macOS.
+func maxRSSBytes(ru *syscall.Rusage) int64 {
+	return int64(ru.Maxrss)
+}
@@ -0,0 +1,11 @@
+package runner
+
+import "syscall"
+
+// outputBlockSize is the unit of ru_oublock on Linux.
+const outputBlockSize = 512
+
+// maxRSSBytes converts ru_maxrss, reported in kilobytes on Linux.
+func maxRSSBytes(ru *syscall.Rusage) int64 {
+	return int64(ru.Maxrss) * 1024
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package runner
+
+import "syscall"
+
+// outputBlockSize is unknown here, so written bytes are reported as 0.
+const outputBlockSize = 0
+
+// maxRSSBytes reports 0: the unit of ru_maxrss is not known here.
+func maxRSSBytes(ru *syscall.Rusage) int64 {
+	return 0
+}
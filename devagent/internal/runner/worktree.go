@@ -0,0 +1,150 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
+)
+
+// worktreeRoot returns the worktrees directory in the state directory.
+func worktreeRoot() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "worktrees"), nil
+}
+
+// prepareWorktree checks cfg.Ref out into the job's worktree, creating it on
+// first use and otherwise resetting it and removing untracked files, so the
+// steps see exactly the committed ref. Ignored files such as dependency
+// directories are kept between runs. It returns the worktree and the commit
+// checked out.
+func prepareWorktree(ctx context.Context, cfg *dsl.Worktree, repo, job string, w io.Writer) (string, string, error) {
+	if cfg.Fetch {
+		if _, err := gitIn(ctx, repo, "fetch", "--quiet", "--all", "--prune"); err != nil {
+			fmt.Fprintf(w, "git fetch failed, using local refs: %v\n", err)
+		}
+	}
+	ref := cfg.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := gitIn(ctx, repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", "", &ConfigError{Err: fmt.Errorf("worktree ref %q not found in %s", ref, repo)}
+	}
+	root, err := worktreeRoot()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(root, filepath.Base(job))
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		_, err := gitIn(ctx, dir, "checkout", "--quiet", "--force", "--detach", commit)
+		if err == nil {
+			_, err = gitIn(ctx, dir, "clean", "-ffdq")
+		}
+		if err == nil {
+			fmt.Fprintf(w, "worktree %s at %s (%.12s)\n", dir, ref, commit)
+			return dir, commit, nil
+		}
+		// The worktree is broken or belongs to another repo; recreate it.
+		fmt.Fprintf(w, "recreating worktree %s: %v\n", dir, err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", "", err
+	}
+	_, _ = gitIn(ctx, repo, "worktree", "prune")
+	if _, err := gitIn(ctx, repo, "worktree", "add", "--quiet", "--force", "--detach", dir, commit); err != nil {
+		return "", "", fmt.Errorf("create worktree: %w", err)
+	}
+	fmt.Fprintf(w, "worktree %s at %s (%.12s)\n", dir, ref, commit)
+	return dir, commit, nil
+}
+
+// prepareTempWorktree checks ref out into a new worktree of repo for one
+// ad hoc run, fetching first when ref is not known locally. It returns the
+// worktree, the commit checked out and a function that removes the worktree.
+func prepareTempWorktree(ctx context.Context, repo, ref, job string, w io.Writer) (string, string, func(), error) {
+	if strings.HasPrefix(ref, "-") || strings.ContainsAny(ref, " \t\n") {
+		return "", "", nil, &ConfigError{Err: fmt.Errorf("invalid ref %q", ref)}
+	}
+	commit, err := gitIn(ctx, repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		fmt.Fprintf(w, "%s not found locally, fetching\n", ref)
+		if _, err := gitIn(ctx, repo, "fetch", "--quiet", "--all", "--tags"); err != nil {
+			fmt.Fprintf(w, "git fetch failed: %v\n", err)
+		}
+		if commit, err = gitIn(ctx, repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+			return "", "", nil, &ConfigError{Err: fmt.Errorf("ref %q not found in %s", ref, repo)}
+		}
+	}
+	root, err := worktreeRoot()
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", "", nil, err
+	}
+	dir, err := os.MkdirTemp(root, filepath.Base(job)+"-ref-")
+	if err != nil {
+		return "", "", nil, err
+	}
+	if _, err := gitIn(ctx, repo, "worktree", "add", "--quiet", "--force", "--detach", dir, commit); err != nil {
+		os.RemoveAll(dir)
+		return "", "", nil, fmt.Errorf("create worktree: %w", err)
+	}
+	fmt.Fprintf(w, "temporary worktree %s at %s (%.12s)\n", dir, ref, commit)
+	remove := func() {
+		// The run may have been cancelled; removal must still happen.
+		if _, err := gitIn(context.Background(), repo, "worktree", "remove", "--force", dir); err != nil {
+			os.RemoveAll(dir)
+			_, _ = gitIn(context.Background(), repo, "worktree", "prune")
+		}
+	}
+	return dir, commit, remove, nil
+}
+
+// MoveWorktree moves a job's worktree to its new name. A worktree git can
+// no longer move is left for the next run to recreate.
+func MoveWorktree(ctx context.Context, repo, oldName, newName string) error {
+	root, err := worktreeRoot()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, filepath.Base(oldName))
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	_, err = gitIn(ctx, repo, "worktree", "move", dir, filepath.Join(root, filepath.Base(newName)))
+	return err
+}
+
+// gitIn runs git in dir and returns its trimmed output.
+func gitIn(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
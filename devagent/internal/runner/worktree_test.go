@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestRunInWorktreeIgnoresLocalEdits(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	if err := os.WriteFile(filepath.Join(repo, "version"), []byte("committed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "version")
+	git("commit", "-q", "-m", "first")
+	if err := os.WriteFile(filepath.Join(repo, "version"), []byte("local edit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Worktree: &dsl.Worktree{}, Steps: []dsl.Step{
+		{Run: "cp version seen && echo scratch > untracked"},
+	}}
+	summary, err := Run(context.Background(), Options{Workflow: wf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" || summary.Worktree == "" || summary.Commit == "" {
+		t.Fatalf("expected a successful worktree run, got %+v", summary)
+	}
+	seen, err := os.ReadFile(filepath.Join(summary.Worktree, "seen"))
+	if err != nil || string(seen) != "committed\n" {
+		t.Fatalf("steps should see the committed file, got %q (%v)", seen, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "seen")); err == nil {
+		t.Fatal("steps must not write to the repo")
+	}
+	if local, _ := os.ReadFile(filepath.Join(repo, "version")); string(local) != "local edit\n" {
+		t.Fatalf("local edits were touched: %q", local)
+	}
+
+	wf.Steps = []dsl.Step{{Run: "test ! -e untracked"}}
+	if summary, err := Run(context.Background(), Options{Workflow: wf}); err != nil || summary.Status != "success" {
+		t.Fatalf("a reused worktree should be cleaned, got %+v (%v)", summary, err)
+	}
+
+	wf.Worktree.Ref = "no-such-branch"
+	var configErr *ConfigError
+	if _, err := Run(context.Background(), Options{Workflow: wf}); !errors.As(err, &configErr) {
+		t.Fatalf("expected a config error for an unknown ref, got %v", err)
+	}
+}
+
+func TestRunAtRefInTemporaryWorktree(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	for _, version := range []string{"1.2.3", "1.3.0"} {
+		if err := os.WriteFile(filepath.Join(repo, "version"), []byte(version+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "version")
+		git("commit", "-q", "-m", version)
+		git("tag", "v"+version)
+	}
+
+	wf := &dsl.Workflow{Name: "nightly", Repo: repo, Worktree: &dsl.Worktree{Ref: "v1.3.0"}, Steps: []dsl.Step{
+		{Run: `echo "version=$(cat version)" >> "$DEVAGENT_OUTPUT"`},
+	}}
+	summary, err := Run(context.Background(), Options{Workflow: wf, Ref: "v1.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != "success" || summary.Outputs["version"] != "1.2.3" || summary.Ref != "v1.2.3" {
+		t.Fatalf("expected the steps to run at v1.2.3, got %+v", summary)
+	}
+	if filepath.Dir(summary.RunDir) != filepath.Join(repo, "devagent_runs") {
+		t.Fatalf("the run directory should stay in the repo, got %s", summary.RunDir)
+	}
+	if _, err := os.Stat(summary.Worktree); !os.IsNotExist(err) {
+		t.Fatalf("the temporary worktree should be removed, got %v", err)
+	}
+
+	var configErr *ConfigError
+	if _, err := Run(context.Background(), Options{Workflow: wf, Ref: "v9.9.9"}); !errors.As(err, &configErr) {
+		t.Fatalf("expected a config error for an unknown ref, got %v", err)
+	}
+}
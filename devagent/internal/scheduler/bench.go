@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"context"
+
+	"devagent/internal/runner"
+	"devagent/internal/store"
+)
+
+// BenchBaseline reads job's earlier benchmark results from st for
+// runner.Options.BenchBaseline, so runs flag regressions against the same
+// history `devagent bench` reports on.
+func BenchBaseline(ctx context.Context, st *store.Store, job string) func(n int) ([][]runner.BenchResult, error) {
+	return func(n int) ([][]runner.BenchResult, error) {
+		history, err := st.BenchHistory(ctx, job, n)
+		if err != nil {
+			return nil, err
+		}
+		previous := make([][]runner.BenchResult, len(history))
+		for i, run := range history {
+			previous[i] = make([]runner.BenchResult, len(run.Results))
+			for j, r := range run.Results {
+				previous[i][j] = runner.BenchResult(r)
+			}
+		}
+		return previous, nil
+	}
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"devagent/internal/dsl"
+	"devagent/internal/util"
+)
+
+// allHours is the Hour field of a cron spec that fires every hour.
+const allHours = 1<<24 - 1
+
+// zonedSchedule returns spec evaluated in loc rather than the daemon's
+// local time. Schedules that fire every hour follow real time, so they run
+// in both passes of a repeated hour and skip none. Schedules at set hours
+// follow the wall clock instead: a time repeated when clocks go back fires
+// once, and a time skipped when they go forward fires that much later,
+// e.g. 02:30 at 03:30.
+func zonedSchedule(spec cron.Schedule, loc *time.Location) cron.Schedule {
+	s, ok := spec.(*cron.SpecSchedule)
+	if !ok {
+		return spec
+	}
+	zoned := *s
+	zoned.Location = loc
+	if zoned.Hour&allHours == allHours {
+		return &zoned
+	}
+	// The spec runs on a clock without DST; wallSchedule maps its times
+	// to loc.
+	zoned.Location = time.UTC
+	return wallSchedule{spec: &zoned, loc: loc}
+}
+
+// wallSchedule fires when the wall clock in loc shows a time of spec.
+type wallSchedule struct {
+	spec *cron.SpecSchedule
+	loc  *time.Location
+}
+
+func (s wallSchedule) Next(t time.Time) time.Time {
+	local := t.In(s.loc)
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	for {
+		wall = s.spec.Next(wall)
+		if wall.IsZero() {
+			return wall
+		}
+		// A wall time shown twice maps to its second pass, which may lie
+		// after t while the first one already fired: skip both then.
+		if next := firstInstant(wall, s.loc); next.After(t) {
+			return next.In(t.Location())
+		}
+	}
+}
+
+// firstInstant returns the earliest instant at which the wall clock in loc
+// shows wall. A wall time skipped when clocks go forward maps to the time
+// as much later as the clocks moved.
+func firstInstant(wall time.Time, loc *time.Location) time.Time {
+	at := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	for _, back := range []time.Duration{2 * time.Hour, time.Hour, 30 * time.Minute} {
+		earlier := at.Add(-back)
+		if l := earlier.In(loc); l.Hour() == wall.Hour() && l.Minute() == wall.Minute() && l.Day() == wall.Day() {
+			return earlier
+		}
+	}
+	return at
+}
+
+// clockJumpThreshold is how far the wall clock may drift from the elapsed
+// time between two daemon ticks before the daemon treats it as a jump.
+const clockJumpThreshold = time.Minute
+
+// clockJump returns how far the wall clock moved beyond the time that
+// elapsed between last and now, both read with time.Now: positive when the
+// clock was set forward or the machine slept, negative when it was set
+// back, and 0 below clockJumpThreshold.
+func clockJump(last, now time.Time) time.Duration {
+	jump := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+	if jump > -clockJumpThreshold && jump < clockJumpThreshold {
+		return 0
+	}
+	return jump
+}
+
+// rescheduleAfterJump re-registers every job after the wall clock jumped
+// between the daemon ticks at last and now. Cron timers count elapsed
+// time, so without this jobs would fire at the wrong wall time. After a
+// forward jump, cron jobs that were due in between and have not run since
+// run once right away.
+func (d *Daemon) rescheduleAfterJump(ctx context.Context, last, now time.Time, jump time.Duration) {
+	d.logger.Printf("system clock jumped %s (machine asleep or clock changed); rescheduling jobs", jump.Round(time.Second))
+	d.mu.Lock()
+	for name, entryID := range d.jobs {
+		d.cron.Remove(entryID)
+		delete(d.jobs, name)
+	}
+	d.mu.Unlock()
+	if err := d.reload(ctx); err != nil {
+		d.logger.Printf("reload error: %v", err)
+	}
+	if jump < 0 {
+		return
+	}
+	jobs, err := d.store.JobsForSchedule(ctx)
+	if err != nil {
+		d.logger.Printf("check missed runs: %v", err)
+		return
+	}
+	from := last.Round(0)
+	for _, job := range jobs {
+		if job.Paused || job.Cron() == "" || job.At() != "" || job.Calendar() != "" || job.Every() != "" {
+			continue
+		}
+		spec, err := dsl.ParseCron(job.Cron(), registeredSeconds(job))
+		if err != nil {
+			continue
+		}
+		loc := util.ResolveLocation(job.Timezone())
+		sched := zonedSchedule(spec, loc)
+		due := sched.Next(from)
+		if due.IsZero() || due.After(now) || (job.LastRun.Valid && !job.LastRun.Time.Before(due)) {
+			continue
+		}
+		d.logger.Printf("job %s missed its run at %s; running now", job.Name, due.In(loc).Format(time.RFC3339))
+		go d.execute(job, sched, loc)
+	}
+}
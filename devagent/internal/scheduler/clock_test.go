@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata for Europe/Berlin")
+	}
+	return loc
+}
+
+func zoned(t *testing.T, expr string, loc *time.Location) interface{ Next(time.Time) time.Time } {
+	t.Helper()
+	spec, err := dsl.ParseCron(expr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zonedSchedule(spec, loc)
+}
+
+func TestZonedScheduleKeepsLocalHourAcrossDST(t *testing.T) {
+	loc := berlin(t)
+	sched := zoned(t, "0 9 * * *", loc)
+	for _, start := range []time.Time{
+		time.Date(2026, 3, 27, 12, 0, 0, 0, loc),  // clocks go forward on the 29th
+		time.Date(2026, 10, 23, 12, 0, 0, 0, loc), // and back on the 25th
+	} {
+		next := start
+		for i := 0; i < 4; i++ {
+			next = sched.Next(next)
+			local := next.In(loc)
+			if local.Hour() != 9 || local.Minute() != 0 || local.Day() != start.Day()+1+i {
+				t.Fatalf("run %d after %s at %s, want 09:00 Berlin every day", i+1, start, local)
+			}
+		}
+	}
+}
+
+func TestZonedScheduleSkippedAndRepeatedTimes(t *testing.T) {
+	loc := berlin(t)
+	sched := zoned(t, "30 2 * * *", loc)
+
+	// 02:30 does not exist on 29 March: it runs at 03:30, once.
+	next := sched.Next(time.Date(2026, 3, 28, 12, 0, 0, 0, loc))
+	if want := time.Date(2026, 3, 29, 3, 30, 0, 0, loc); !next.Equal(want) {
+		t.Fatalf("spring forward: got %s, want %s", next.In(loc), want)
+	}
+	if after := sched.Next(next); !after.Equal(time.Date(2026, 3, 30, 2, 30, 0, 0, loc)) {
+		t.Fatalf("spring forward: following run at %s", after.In(loc))
+	}
+
+	// 02:30 happens twice on 25 October: only the first one runs.
+	first := time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC) // 02:30 CEST
+	if next := sched.Next(time.Date(2026, 10, 24, 12, 0, 0, 0, loc)); !next.Equal(first) {
+		t.Fatalf("fall back: got %s, want %s", next.In(loc), first.In(loc))
+	}
+	tomorrow := time.Date(2026, 10, 26, 2, 30, 0, 0, loc)
+	for _, from := range []time.Time{first, time.Date(2026, 10, 25, 1, 10, 0, 0, time.UTC)} {
+		if next := sched.Next(from); !next.Equal(tomorrow) {
+			t.Fatalf("fall back: after %s got %s, want %s", from.In(loc), next.In(loc), tomorrow)
+		}
+	}
+}
+
+func TestZonedScheduleHourlyFollowsRealTime(t *testing.T) {
+	loc := berlin(t)
+	sched := zoned(t, "0 * * * *", loc)
+	// Clocks go back from 03:00 CEST to 02:00 CET: 02:00 comes twice and
+	// hourly jobs run in both.
+	next := sched.Next(time.Date(2026, 10, 24, 23, 30, 0, 0, time.UTC))
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, next.In(loc).Format("15:04 MST"))
+		next = sched.Next(next)
+	}
+	if want := "02:00 CEST, 02:00 CET, 03:00 CET"; joined(got) != want {
+		t.Fatalf("hourly runs %s, want %s", joined(got), want)
+	}
+}
+
+func TestZonedScheduleIgnoresDaemonTimezone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no tzdata for Asia/Tokyo")
+	}
+	sched := zoned(t, "0 9 * * *", berlin(t))
+	next := sched.Next(time.Date(2026, 7, 1, 12, 0, 0, 0, tokyo))
+	if local := next.In(berlin(t)); local.Hour() != 9 {
+		t.Fatalf("ran at %s Berlin time", local)
+	}
+}
+
+func joined(parts []string) string {
+	out := ""
+	for i, p := range parts {
+		if i > 0 {
+			out += ", "
+		}
+		out += p
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"sort"
+
+	"devagent/internal/store"
+)
+
+// Dependents returns the jobs that declare `after: name`, sorted by name.
+func Dependents(jobs []store.Job, name string) []store.Job {
+	var out []store.Job
+	for _, job := range jobs {
+		if job.After() == name {
+			out = append(out, job)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// FindCycle returns the job names forming a dependency cycle, starting and
+// ending with the same job, or nil when the graph is acyclic. Each job has at
+// most one upstream, so following `after` links is enough to find a loop.
+func FindCycle(jobs []store.Job) []string {
+	cycles := findCycles(jobs)
+	if len(cycles) == 0 {
+		return nil
+	}
+	return cycles[0]
+}
+
+func findCycles(jobs []store.Job) [][]string {
+	upstream := make(map[string]string, len(jobs))
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		upstream[job.Name] = job.After()
+		names = append(names, job.Name)
+	}
+	sort.Strings(names)
+
+	var cycles [][]string
+	done := make(map[string]bool, len(jobs))
+	for _, start := range names {
+		if done[start] {
+			continue
+		}
+		index := make(map[string]int)
+		var path []string
+		for name := start; name != ""; name = upstream[name] {
+			if i, ok := index[name]; ok {
+				cycles = append(cycles, append(append([]string{}, path[i:]...), name))
+				break
+			}
+			if done[name] {
+				break
+			}
+			index[name] = len(path)
+			path = append(path, name)
+			if _, ok := upstream[name]; !ok {
+				break
+			}
+		}
+		for _, name := range path {
+			done[name] = true
+		}
+	}
+	return cycles
+}
+
+// inCycle reports whether name is part of any dependency cycle.
+func inCycle(jobs []store.Job, name string) bool {
+	for _, cycle := range findCycles(jobs) {
+		for _, member := range cycle {
+			if member == name {
+				return true
+			}
+		}
+	}
+	return false
+}
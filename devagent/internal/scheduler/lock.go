@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"devagent/internal/store"
+)
+
+var errAlreadyRunning = errors.New("job already running")
+
+// LockInfo describes a job lock file and the process recorded as its holder.
+type LockInfo struct {
+	Name      string
+	Path      string
+	PID       int
+	StartedAt time.Time
+	// Repo is set for the lock that keeps runs in one repo from running
+	// at the same time, and Group for the lock of a workflow lock group;
+	// both are held by the job named Job.
+	Repo  string
+	Group string
+	Job   string
+	// Held reports whether a live process currently holds the lock.
+	Held bool
+}
+
+// Stale reports whether the lock file still names a holder even though
+// nobody holds it, i.e. its owner crashed before releasing it.
+func (l LockInfo) Stale() bool {
+	return !l.Held && l.PID != 0
+}
+
+// acquireLock takes the per-job lock and records this process as the holder.
+// When the previous holder died without releasing the lock, its details are
+// returned so the caller can report the recovery.
+func acquireLock(name string) (*os.File, *LockInfo, error) {
+	dir, err := store.LocksDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(dir, sanitizeName(name)+".lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			if holder, readErr := readLockFile(path); readErr == nil && holder.PID != 0 {
+				return nil, nil, fmt.Errorf("%w (pid %d since %s)", errAlreadyRunning, holder.PID, holder.StartedAt.Format(time.RFC3339))
+			}
+			return nil, nil, errAlreadyRunning
+		}
+		return nil, nil, err
+	}
+
+	var stale *LockInfo
+	if previous, err := readLock(f); err == nil && previous.PID != 0 && previous.PID != os.Getpid() {
+		previous.Path = path
+		stale = &previous
+	}
+	if err := writeLock(f); err != nil {
+		releaseLock(f)
+		return nil, nil, err
+	}
+	return f, stale, nil
+}
+
+// releaseLock clears the holder details and unlocks, so an unheld lock file
+// with holder details can only be left behind by a crash.
+func releaseLock(f *os.File) {
+	if f == nil {
+		return
+	}
+	_ = f.Truncate(0)
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+func writeLock(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(f, "pid=%d\nstarted=%s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func readLockFile(path string) (LockInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	defer f.Close()
+	return readLock(f)
+}
+
+func readLock(r io.ReadSeeker) (LockInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return LockInfo{}, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	var info LockInfo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "pid":
+			info.PID, _ = strconv.Atoi(value)
+		case "started":
+			info.StartedAt, _ = time.Parse(time.RFC3339, value)
+		case "repo":
+			info.Repo = value
+		case "group":
+			info.Group = value
+		case "job":
+			info.Job = value
+		}
+	}
+	return info, nil
+}
+
+// InspectLocks lists the lock files under the locks directory.
+func InspectLocks() ([]LockInfo, error) {
+	dir, err := store.LocksDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var out []LockInfo
+	for _, path := range paths {
+		info, err := readLockFile(path)
+		if err != nil {
+			continue
+		}
+		info.Name = strings.TrimSuffix(filepath.Base(path), ".lock")
+		info.Path = path
+		info.Held = lockHeld(path)
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// RemoveStaleLocks deletes lock files nobody holds and returns the stale
+// ones it removed. Held locks are never touched.
+func RemoveStaleLocks() ([]LockInfo, error) {
+	locks, err := InspectLocks()
+	if err != nil {
+		return nil, err
+	}
+	var removed []LockInfo
+	for _, lock := range locks {
+		if lock.Held {
+			continue
+		}
+		f, err := os.OpenFile(lock.Path, os.O_RDWR, 0o644)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			continue
+		}
+		removeErr := os.Remove(lock.Path)
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+		if removeErr != nil {
+			return removed, removeErr
+		}
+		if lock.Stale() {
+			removed = append(removed, lock)
+		}
+	}
+	return removed, nil
+}
+
+// RenameLock moves a job's lock file to its new name. It fails while the
+// job is running, so a renamed job can never run twice at once.
+func RenameLock(oldName, newName string) error {
+	dir, err := store.LocksDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, sanitizeName(oldName)+".lock")
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return fmt.Errorf("%s is running; wait for it or cancel it first", oldName)
+		}
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return os.Rename(path, filepath.Join(dir, sanitizeName(newName)+".lock"))
+}
+
+func lockHeld(path string) bool {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return false
+}
+
+func sanitizeName(name string) string {
+	name = strings.ToLower(name)
+	replacer := strings.NewReplacer(" ", "-", "/", "-", "\\", "-", ":", "-", "..", "-")
+	return replacer.Replace(name)
+}
@@ -0,0 +1,157 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// preemptPoll is how often a job waiting for a slot looks for a run it may
+// preempt.
+var preemptPoll = 10 * time.Second
+
+// runQueue limits how many jobs run at once. Jobs waiting for a slot start
+// in order of priority, then of arrival.
+type runQueue struct {
+	mu sync.Mutex
+	// limit is the number of slots; 0 means no limit.
+	limit   int
+	seq     uint64
+	running map[string]*slot
+	waiting []*slot
+}
+
+// slot is a job's place in the queue, waiting or running.
+type slot struct {
+	job      string
+	priority int
+	seq      uint64
+	started  time.Time
+	ready    chan struct{}
+	// cancel stops the job's current run, if one is in progress.
+	// preemptedBy names the job that cancelled it to take its slot.
+	cancel      context.CancelFunc
+	preemptedBy string
+}
+
+func newRunQueue() *runQueue {
+	return &runQueue{running: make(map[string]*slot)}
+}
+
+// acquire waits for a slot for job. While it waits, a positive
+// preemptAfter lets it cancel a lower-priority run that has been going at
+// least that long.
+func (q *runQueue) acquire(job string, priority int, preemptAfter time.Duration, logf func(string, ...interface{})) *slot {
+	q.mu.Lock()
+	q.seq++
+	s := &slot{job: job, priority: priority, seq: q.seq, ready: make(chan struct{})}
+	if q.limit <= 0 || len(q.running) < q.limit {
+		q.start(s)
+		q.mu.Unlock()
+		return s
+	}
+	q.waiting = append(q.waiting, s)
+	running := len(q.running)
+	q.mu.Unlock()
+	logf("job %s waiting for a free slot (%d jobs running)", job, running)
+	if preemptAfter <= 0 {
+		<-s.ready
+		return s
+	}
+	ticker := time.NewTicker(preemptPoll)
+	defer ticker.Stop()
+	for {
+		q.preempt(s, preemptAfter, logf)
+		select {
+		case <-s.ready:
+			return s
+		case <-ticker.C:
+		}
+	}
+}
+
+// start marks s running; q.mu must be held.
+func (q *runQueue) start(s *slot) {
+	s.started = time.Now()
+	q.running[s.job] = s
+	close(s.ready)
+}
+
+// release frees s and starts the next waiting job, if any.
+func (q *runQueue) release(s *slot) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running[s.job] == s {
+		delete(q.running, s.job)
+	}
+	for len(q.waiting) > 0 && (q.limit <= 0 || len(q.running) < q.limit) {
+		i := q.next()
+		next := q.waiting[i]
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.start(next)
+	}
+}
+
+// next returns the index of the waiting job to start first; q.mu must be
+// held and q.waiting not empty.
+func (q *runQueue) next() int {
+	best := 0
+	for i, s := range q.waiting {
+		b := q.waiting[best]
+		if s.priority > b.priority || (s.priority == b.priority && s.seq < b.seq) {
+			best = i
+		}
+	}
+	return best
+}
+
+// preempt cancels a run of lower priority than the waiting s that has been
+// going for at least after, preferring the lowest priority and then the
+// longest run. Only the job next in line preempts, so the slot it frees
+// goes to that job.
+func (q *runQueue) preempt(s *slot, after time.Duration, logf func(string, ...interface{})) {
+	q.mu.Lock()
+	if len(q.waiting) == 0 || q.waiting[q.next()] != s {
+		q.mu.Unlock()
+		return
+	}
+	var victim *slot
+	for _, r := range q.running {
+		if r.priority >= s.priority || r.cancel == nil || r.preemptedBy != "" || time.Since(r.started) < after {
+			continue
+		}
+		if victim == nil || r.priority < victim.priority || (r.priority == victim.priority && r.started.Before(victim.started)) {
+			victim = r
+		}
+	}
+	if victim == nil {
+		q.mu.Unlock()
+		return
+	}
+	victim.preemptedBy = s.job
+	cancel := victim.cancel
+	q.mu.Unlock()
+	logf("job %s (priority %d) preempting %s (priority %d), running for %s", s.job, s.priority, victim.job, victim.priority, time.Since(victim.started).Round(time.Second))
+	cancel()
+}
+
+// attach records cancel as the way to stop job's current run, until
+// detach.
+func (q *runQueue) attach(job string, cancel context.CancelFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if s := q.running[job]; s != nil {
+		s.cancel = cancel
+	}
+}
+
+func (q *runQueue) detach(job string) {
+	q.attach(job, nil)
+}
+
+// preempted returns the job that preempted the run in s, or "".
+func (q *runQueue) preempted(s *slot) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return s.preemptedBy
+}
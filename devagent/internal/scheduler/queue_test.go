@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func discardf(string, ...interface{}) {}
+
+func TestRunQueueStartsHighPriorityFirst(t *testing.T) {
+	q := newRunQueue()
+	q.limit = 1
+	running := q.acquire("nightly", 0, 0, discardf)
+
+	started := make(chan string, 2)
+	wait := func(job string, priority int) {
+		s := q.acquire(job, priority, 0, discardf)
+		started <- job
+		q.release(s)
+	}
+	go wait("low", 0)
+	waitFor(t, func() bool { return queued(q) == 1 })
+	go wait("urgent", 10)
+	waitFor(t, func() bool { return queued(q) == 2 })
+
+	q.release(running)
+	if first, second := <-started, <-started; first != "urgent" || second != "low" {
+		t.Fatalf("started %s then %s, want urgent first", first, second)
+	}
+}
+
+func TestRunQueuePreemptsLongLowPriorityRun(t *testing.T) {
+	preemptPoll = 10 * time.Millisecond
+	defer func() { preemptPoll = 10 * time.Second }()
+	q := newRunQueue()
+	q.limit = 1
+
+	long := q.acquire("reindex", -1, 0, discardf)
+	ctx, cancel := context.WithCancel(context.Background())
+	q.attach("reindex", cancel)
+
+	got := make(chan *slot)
+	go func() { got <- q.acquire("deploy-check", 5, 20*time.Millisecond, discardf) }()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("long low-priority run was not preempted")
+	}
+	if by := q.preempted(long); by != "deploy-check" {
+		t.Fatalf("preempted by %q", by)
+	}
+	q.detach("reindex")
+	q.release(long)
+	if s := <-got; s.job != "deploy-check" {
+		t.Fatalf("slot went to %s", s.job)
+	}
+
+	// A job of the same priority is never preempted.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	q.attach("deploy-check", cancel)
+	go q.acquire("other-check", 5, time.Millisecond, discardf)
+	waitFor(t, func() bool { return queued(q) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("run of equal priority was preempted")
+	}
+}
+
+func queued(q *runQueue) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
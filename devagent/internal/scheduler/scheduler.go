@@ -2,10 +2,14 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -13,9 +17,19 @@ import (
 
 	"github.com/robfig/cron/v3"
 
+	"devagent/internal/diagnose"
+	"devagent/internal/digest"
 	"devagent/internal/dsl"
+	"devagent/internal/holidays"
+	"devagent/internal/ical"
+	"devagent/internal/localstats"
+	"devagent/internal/notify"
+	"devagent/internal/planner"
+	"devagent/internal/policy"
+	"devagent/internal/power"
 	"devagent/internal/runner"
 	"devagent/internal/store"
+	"devagent/internal/sysload"
 	"devagent/internal/util"
 )
 
@@ -26,7 +40,79 @@ type Daemon struct {
 	logger *log.Logger
 	jobs   map[string]cron.EntryID
 	mu     sync.Mutex
-	parser cron.Parser
+	// calendars records when each calendar-scheduled job was last loaded.
+	calendars map[string]time.Time
+	// resume holds jobs re-queued after an interruption; their next run
+	// resumes the interrupted run's checkpoint.
+	resume map[string]bool
+	// lastCycle remembers the last reported dependency cycle so reloads do
+	// not repeat the same warning every tick.
+	lastCycle string
+	// lastDigestErr likewise remembers the last digest error reported.
+	lastDigestErr string
+	// lastBackupErr likewise remembers the last backup error reported.
+	lastBackupErr string
+	// WatchDirs are directories of workflow files the registry follows;
+	// see syncWatched. watchErrs remembers the last error reported for
+	// each file.
+	WatchDirs []string
+	watchErrs map[string]string
+	// BackupEvery is how often the store is backed up; zero disables
+	// backups. BackupKeep is how many backups are kept.
+	BackupEvery time.Duration
+	BackupKeep  int
+	// AllowUnapproved runs workflow files that changed since they were last
+	// approved, with a warning, instead of refusing them.
+	AllowUnapproved bool
+	// IdleAfter is how long without keyboard or mouse input counts as idle
+	// for jobs that require it.
+	IdleAfter time.Duration
+	// MaxRuns limits how many jobs run at once; 0 means no limit. Jobs
+	// waiting for a slot start in order of schedule.priority.
+	MaxRuns int
+	queue   *runQueue
+	// ReadOnly starts the daemon without taking the daemon lock or
+	// scheduling any job, e.g. to serve the status API next to the daemon
+	// that does.
+	ReadOnly bool
+	// Events, when set, receives the step events of every run, e.g. to
+	// stream them over the status API. It must not block.
+	Events func(job string, ev runner.StepEvent)
+	// Verbose also logs the scheduling decisions behind each run: when a
+	// job next fires and why a due job starts.
+	Verbose bool
+	// probe, loadProbe and diskProbe read the machine's power state, load
+	// average and free disk space; replaced in tests.
+	probe     func(context.Context) (power.State, error)
+	loadProbe func(context.Context) (float64, error)
+	diskProbe func(path string) (int64, error)
+}
+
+// DefaultIdleAfter is the idle threshold used unless the daemon is started
+// with --idle-after.
+const DefaultIdleAfter = 10 * time.Minute
+
+// DefaultBackupEvery and DefaultBackupKeep are the backup interval and
+// rotation used unless the daemon is started with --backup-every and
+// --backup-keep.
+const (
+	DefaultBackupEvery = 24 * time.Hour
+	DefaultBackupKeep  = 7
+)
+
+// conditionPoll is how often a deferred job re-checks its requirements.
+var conditionPoll = time.Minute
+
+// registeredSeconds reports whether a registered job's schedule needs
+// second precision. Workflows are validated, including the
+// schedule.seconds opt-in, before they are registered, so a six-field
+// cron or a sub-minute interval in the registry was opted in.
+func registeredSeconds(job store.Job) bool {
+	if len(strings.Fields(job.Cron())) == 6 {
+		return true
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(job.Every()))
+	return err == nil && d < dsl.MinEvery
 }
 
 // New creates a new daemon instance.
@@ -34,31 +120,86 @@ func New(st *store.Store, logger *log.Logger) *Daemon {
 	if logger == nil {
 		logger = log.New(os.Stdout, "devagent ", log.LstdFlags)
 	}
-	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
 	return &Daemon{
-		store:  st,
-		cron:   cron.New(),
-		logger: logger,
-		jobs:   make(map[string]cron.EntryID),
-		parser: parser,
+		store:       st,
+		cron:        cron.New(),
+		logger:      logger,
+		jobs:        make(map[string]cron.EntryID),
+		calendars:   make(map[string]time.Time),
+		resume:      make(map[string]bool),
+		watchErrs:   make(map[string]string),
+		queue:       newRunQueue(),
+		IdleAfter:   DefaultIdleAfter,
+		BackupEvery: DefaultBackupEvery,
+		BackupKeep:  DefaultBackupKeep,
+		probe:       power.Read,
+		loadProbe:   sysload.Average,
+		diskProbe:   sysload.FreeDisk,
 	}
 }
 
+// ValidateSchedule checks what the daemon would otherwise only discover when
+// scheduling the job: that the cron expression parses and the timezone
+// exists.
+func ValidateSchedule(s dsl.Schedule) error {
+	if s.Cron != "" {
+		if _, err := dsl.ParseCron(s.Cron, s.Seconds); err != nil {
+			return fmt.Errorf("invalid cron %q: %w", s.Cron, err)
+		}
+	}
+	if s.Holidays != "" && !holidays.IsFile(s.Holidays) {
+		// Calendar files are checked when the daemon loads them, since a
+		// relative path depends on where the workflow is saved.
+		if _, err := holidays.Load(s.Holidays, ""); err != nil {
+			return err
+		}
+	}
+	switch tz := strings.ToLower(strings.TrimSpace(s.Timezone)); tz {
+	case "", "local", "utc":
+	default:
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", s.Timezone)
+		}
+	}
+	return nil
+}
+
 // Run starts the daemon loop until the context is cancelled.
 func (d *Daemon) Run(ctx context.Context) error {
 	if d.store == nil {
 		return errors.New("scheduler store is nil")
 	}
+	if d.ReadOnly {
+		return d.runReadOnly(ctx)
+	}
+	if err := d.store.AcquireDaemonLock(ctx, processAlive); err != nil {
+		var conflict *store.DaemonConflictError
+		if errors.As(err, &conflict) {
+			_ = d.store.RecordDaemonContender(ctx, false)
+			return fmt.Errorf("%w; stop it first, or start this one with --read-only", err)
+		}
+		return fmt.Errorf("daemon lock: %w", err)
+	}
+	defer d.store.ReleaseDaemonLock(context.Background())
 	d.logger.Println("daemon starting")
+	d.queue.limit = d.MaxRuns
+	d.checkStore(ctx)
+	d.syncWatched(ctx)
+	d.checkWorkflowFiles(ctx)
+	requeue := d.recoverRuns(ctx)
 	d.cron.Start()
 	defer d.cron.Stop()
 
 	if err := d.reload(ctx); err != nil {
 		d.logger.Printf("initial load error: %v", err)
 	}
+	for _, job := range requeue {
+		go d.Trigger(job)
+	}
 
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
+	lastTick := time.Now()
 
 	for {
 		select {
@@ -66,11 +207,105 @@ func (d *Daemon) Run(ctx context.Context) error {
 			d.logger.Println("daemon stopping")
 			return nil
 		case <-ticker.C:
+			now := time.Now()
+			if jump := clockJump(lastTick, now); jump != 0 {
+				d.rescheduleAfterJump(ctx, lastTick, now, jump)
+			}
+			lastTick = now
+			if err := d.store.RefreshDaemonLock(ctx); errors.Is(err, store.ErrDaemonLockLost) {
+				// Another daemon took over while this one was suspended;
+				// carrying on would fire jobs twice.
+				return err
+			} else if err != nil {
+				d.logger.Printf("refresh daemon lock: %v", err)
+			}
+			d.syncWatched(ctx)
 			if err := d.reload(ctx); err != nil {
 				d.logger.Printf("reload error: %v", err)
 			}
+			d.sendDigest(ctx, time.Now())
+			d.backup(ctx, time.Now())
+		}
+	}
+}
+
+// runReadOnly waits for ctx without scheduling anything. A daemon holding
+// the lock is told about this one so `devagent status` can show both.
+func (d *Daemon) runReadOnly(ctx context.Context) error {
+	if lock, err := d.store.DaemonLockState(ctx); err == nil && lock.Held(processAlive) {
+		_ = d.store.RecordDaemonContender(ctx, true)
+		d.logger.Printf("daemon starting read-only next to pid %d; no jobs will be scheduled", lock.PID)
+	} else {
+		d.logger.Println("daemon starting read-only; no jobs will be scheduled")
+	}
+	<-ctx.Done()
+	d.logger.Println("daemon stopping")
+	return nil
+}
+
+// DaemonConflict describes a second daemon that found the lock held by the
+// running one since it started, or returns "" when there is none.
+func DaemonConflict(ctx context.Context, st *store.Store) (string, error) {
+	lock, err := st.DaemonLockState(ctx)
+	if err != nil || !lock.Held(processAlive) || !lock.ContendedAt.Valid || lock.ContendedAt.Time.Before(lock.StartedAt) {
+		return "", err
+	}
+	at := lock.ContendedAt.Time.Local().Format(time.RFC3339)
+	if lock.ContenderReadOnly {
+		if processAlive(lock.ContenderPID) {
+			return fmt.Sprintf("a second daemon (pid %d) is running read-only next to pid %d", lock.ContenderPID, lock.PID), nil
 		}
+		return "", nil
 	}
+	return fmt.Sprintf("a second daemon (pid %d) was refused at %s because pid %d holds the daemon lock", lock.ContenderPID, at, lock.PID), nil
+}
+
+// recoverRuns marks runs left in the running state by a crashed process as
+// interrupted and returns the jobs that asked to be re-queued. Runs owned by
+// a live process with a fresh heartbeat (e.g. a manual `devagent run`) are
+// left alone.
+func (d *Daemon) recoverRuns(ctx context.Context) []store.Job {
+	runs, err := d.store.RunningRuns(ctx)
+	if err != nil {
+		d.logger.Printf("check interrupted runs: %v", err)
+		return nil
+	}
+	var requeue []store.Job
+	queued := make(map[string]bool)
+	for _, run := range runs {
+		if run.PID != os.Getpid() && processAlive(run.PID) && time.Since(run.HeartbeatAt) < 3*store.HeartbeatInterval {
+			continue
+		}
+		if err := d.store.MarkRunInterrupted(ctx, run.ID); err != nil {
+			d.logger.Printf("mark run %d interrupted: %v", run.ID, err)
+			continue
+		}
+		_ = d.store.UpdateRunResult(ctx, run.Job, store.RunStatusInterrupted, time.Now())
+		d.logger.Printf("run %d of %s (pid %d) was interrupted", run.ID, run.Job, run.PID)
+
+		job, err := d.store.GetJob(ctx, run.Job)
+		if err != nil || job == nil || job.Paused || queued[job.Name] {
+			continue
+		}
+		if wf, err := dsl.Load(job.YAMLPath()); err == nil && wf.Schedule.RequeueInterrupted {
+			d.logger.Printf("re-queueing interrupted job %s", job.Name)
+			d.mu.Lock()
+			d.resume[job.Name] = true
+			d.mu.Unlock()
+			requeue = append(requeue, *job)
+			queued[job.Name] = true
+		}
+	}
+	return requeue
+}
+
+// processAlive reports whether a process with the given pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
 }
 
 func (d *Daemon) reload(ctx context.Context) error {
@@ -78,17 +313,49 @@ func (d *Daemon) reload(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// Calendar files and URLs can take a while to fetch, so they are loaded
+	// without holding d.mu, which every run takes when it starts and ends.
+	calendars := d.loadCalendars(ctx, d.calendarsDue(jobs))
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	if cycle := FindCycle(jobs); len(cycle) > 0 {
+		if desc := strings.Join(cycle, " -> "); desc != d.lastCycle {
+			d.logger.Printf("dependency cycle detected, dependents will not be triggered: %s", desc)
+			d.lastCycle = desc
+		}
+	} else {
+		d.lastCycle = ""
+	}
+
 	existing := make(map[string]struct{}, len(d.jobs))
 	for name := range d.jobs {
 		existing[name] = struct{}{}
 	}
 
 	for _, job := range jobs {
+		if !schedulable(job) {
+			continue
+		}
 		delete(existing, job.Name)
-		if _, ok := d.jobs[job.Name]; ok {
+		_, scheduled := d.jobs[job.Name]
+		if job.Calendar() != "" {
+			// A job unscheduled since its calendar was due waits for the
+			// next reload.
+			loaded, ok := calendars[job.Name]
+			switch {
+			case !ok:
+			case loaded.err != nil && scheduled:
+				d.logger.Printf("refresh calendar for %s: %v", job.Name, loaded.err)
+			case loaded.err != nil:
+				d.logger.Printf("schedule job %s: %v", job.Name, loaded.err)
+			default:
+				d.scheduleCalendar(job, loaded.times)
+			}
+			continue
+		}
+		if scheduled {
 			continue
 		}
 		if err := d.scheduleJob(job); err != nil {
@@ -100,88 +367,873 @@ func (d *Daemon) reload(ctx context.Context) error {
 		if entryID, ok := d.jobs[name]; ok {
 			d.cron.Remove(entryID)
 			delete(d.jobs, name)
+			delete(d.calendars, name)
 		}
 	}
 
 	return nil
 }
 
+// schedulable reports whether the daemon schedules job itself, rather than
+// leaving it paused or to the job it runs after.
+func schedulable(job store.Job) bool {
+	return !job.Paused && (job.Cron() != "" || job.At() != "" || job.Every() != "" || job.Calendar() != "")
+}
+
+// scheduleJob registers a cron, interval or one-shot job; calendar jobs are
+// registered by reload once their calendar is loaded.
 func (d *Daemon) scheduleJob(job store.Job) error {
-	sched, err := d.parser.Parse(job.Cron())
+	loc := util.ResolveLocation(job.Timezone())
+	if job.At() != "" {
+		return d.scheduleOnce(job, loc)
+	}
+	if job.Every() != "" {
+		interval, err := dsl.ParseEvery(job.Every(), registeredSeconds(job))
+		if err != nil {
+			return err
+		}
+		sched := cron.Every(interval)
+		entryID := d.cron.Schedule(sched, cron.FuncJob(func() { d.execute(job, sched, loc) }))
+		d.jobs[job.Name] = entryID
+		d.logger.Printf("scheduled %s every %s", job.Name, interval)
+		d.debugf("%s next runs at %s", job.Name, sched.Next(time.Now()).In(loc).Format(time.RFC3339))
+		return nil
+	}
+	spec, err := dsl.ParseCron(job.Cron(), registeredSeconds(job))
 	if err != nil {
 		return err
 	}
-	loc := util.ResolveLocation(job.Timezone())
-	entryID := d.cron.Schedule(sched, cron.FuncJob(func() { d.execute(job, loc) }))
+	sched := zonedSchedule(spec, loc)
+	entryID := d.cron.Schedule(sched, cron.FuncJob(func() { d.execute(job, sched, loc) }))
 	d.jobs[job.Name] = entryID
 	d.logger.Printf("scheduled %s (%s)", job.Name, job.Cron())
+	d.debugf("%s next runs at %s", job.Name, sched.Next(time.Now()).In(loc).Format(time.RFC3339))
 	return nil
 }
 
-func (d *Daemon) execute(job store.Job, loc *time.Location) {
-	lock, err := acquireLock(job.Name)
+// NextFire estimates when the daemon next runs job after now. Cron and
+// one-shot times are exact; intervals, which the daemon counts from its own
+// start, are estimated from the job's last run. It returns the zero time for
+// jobs run after another job, calendar jobs and one-shot jobs that already
+// ran.
+func NextFire(job store.Job, now time.Time) (time.Time, error) {
+	loc := util.ResolveLocation(job.Timezone())
+	switch {
+	case job.At() != "":
+		at, err := dsl.ParseAt(job.At(), loc)
+		if err != nil || (job.LastRun.Valid && !job.LastRun.Time.Before(at)) {
+			return time.Time{}, err
+		}
+		if at.Before(now) {
+			return now, nil
+		}
+		return at, nil
+	case job.Calendar() != "" || job.After() != "":
+		return time.Time{}, nil
+	case job.Every() != "":
+		interval, err := dsl.ParseEvery(job.Every(), registeredSeconds(job))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !job.LastRun.Valid {
+			return now, nil
+		}
+		next := job.LastRun.Time.Add(interval)
+		if next.Before(now) {
+			return now, nil
+		}
+		return next, nil
+	}
+	spec, err := dsl.ParseCron(job.Cron(), registeredSeconds(job))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return zonedSchedule(spec, loc).Next(now), nil
+}
+
+// scheduleOnce registers a one-shot job. A run time that passed while the
+// daemon was down fires right away unless the job already ran since then.
+func (d *Daemon) scheduleOnce(job store.Job, loc *time.Location) error {
+	at, err := dsl.ParseAt(job.At(), loc)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !at.After(now) {
+		if job.LastRun.Valid && !job.LastRun.Time.Before(at) {
+			return nil
+		}
+		d.logger.Printf("one-shot job %s missed its run time %s; running now", job.Name, at.Format(time.RFC3339))
+		at = now.Add(time.Second)
+	}
+	sched := onceSchedule{at: at}
+	entryID := d.cron.Schedule(sched, cron.FuncJob(func() { d.execute(job, sched, loc) }))
+	d.jobs[job.Name] = entryID
+	d.logger.Printf("scheduled %s once at %s", job.Name, at.Format(time.RFC3339))
+	return nil
+}
+
+// calendarRefresh is how often calendar files and URLs are re-read.
+const calendarRefresh = 15 * time.Minute
+
+// loadedCalendar is the outcome of loading a job's calendar.
+type loadedCalendar struct {
+	times []time.Time
+	err   error
+}
+
+// calendarsDue returns the calendar jobs not scheduled yet and those whose
+// calendar was last loaded calendarRefresh ago.
+func (d *Daemon) calendarsDue(jobs []store.Job) []store.Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var due []store.Job
+	for _, job := range jobs {
+		if !schedulable(job) || job.Calendar() == "" {
+			continue
+		}
+		if _, ok := d.jobs[job.Name]; !ok || time.Since(d.calendars[job.Name]) >= calendarRefresh {
+			due = append(due, job)
+		}
+	}
+	return due
+}
+
+// loadCalendars loads the calendars of jobs, by job name.
+func (d *Daemon) loadCalendars(ctx context.Context, jobs []store.Job) map[string]loadedCalendar {
+	loaded := make(map[string]loadedCalendar, len(jobs))
+	for _, job := range jobs {
+		loadCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		times, err := ical.Load(loadCtx, job.Calendar(), util.ResolveLocation(job.Timezone()))
+		cancel()
+		loaded[job.Name] = loadedCalendar{times: times, err: err}
+	}
+	return loaded
+}
+
+// scheduleCalendar (re)registers a calendar job to fire at times. A refresh
+// that fails never gets here, so the previously loaded events stay
+// scheduled. The caller holds d.mu.
+func (d *Daemon) scheduleCalendar(job store.Job, times []time.Time) {
+	loc := util.ResolveLocation(job.Timezone())
+	if entryID, ok := d.jobs[job.Name]; ok {
+		d.cron.Remove(entryID)
+	}
+	sched := calendarSchedule{times: times}
+	entryID := d.cron.Schedule(sched, cron.FuncJob(func() { d.execute(job, sched, loc) }))
+	d.jobs[job.Name] = entryID
+	d.calendars[job.Name] = time.Now()
+	if next := sched.Next(time.Now()); !next.IsZero() {
+		d.logger.Printf("scheduled %s from calendar %s (next %s)", job.Name, job.Calendar(), next.Format(time.RFC3339))
+	} else {
+		d.logger.Printf("calendar %s for %s has no upcoming events", job.Calendar(), job.Name)
+	}
+}
+
+// calendarSchedule fires at each event start time, in ascending order.
+type calendarSchedule struct {
+	times []time.Time
+}
+
+func (s calendarSchedule) Next(t time.Time) time.Time {
+	i := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(t) })
+	if i == len(s.times) {
+		return time.Time{}
+	}
+	return s.times[i]
+}
+
+// onceSchedule fires a single time; cron skips entries whose next activation
+// is the zero time.
+type onceSchedule struct {
+	at time.Time
+}
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.at) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// Trigger runs a job immediately outside its schedule, e.g. from a git hook.
+// It shares locking, result recording, and notifications with scheduled runs.
+func (d *Daemon) Trigger(job store.Job) {
+	d.execute(job, nil, util.ResolveLocation(job.Timezone()))
+}
+
+func (d *Daemon) execute(job store.Job, sched cron.Schedule, loc *time.Location) {
+	// A preempted job runs again once its lock is released.
+	requeue := false
+	defer func() {
+		if requeue {
+			go d.Trigger(job)
+		}
+	}()
+	lock, stale, err := acquireLock(job.Name)
 	if err != nil {
 		if errors.Is(err, errAlreadyRunning) {
-			d.logger.Printf("job %s already running", job.Name)
+			d.logger.Printf("job %s %v", job.Name, err)
 			return
 		}
 		d.logger.Printf("lock error for %s: %v", job.Name, err)
 		return
 	}
 	defer releaseLock(lock)
+	if stale != nil {
+		d.logger.Printf("recovered stale lock for %s left by pid %d (started %s)", job.Name, stale.PID, stale.StartedAt.Format(time.RFC3339))
+	}
 
 	ctx := context.Background()
-	wf, err := dsl.Load(job.YAMLPath())
+	content, err := os.ReadFile(job.YAMLPath())
+	if err != nil {
+		d.logger.Printf("read workflow %s: %v", job.Name, err)
+		return
+	}
+	wf, err := dsl.Parse(content)
 	if err != nil {
 		d.logger.Printf("load workflow %s: %v", job.Name, err)
 		return
 	}
+	if !d.approved(ctx, job.Name, content, loc) {
+		return
+	}
+	d.recordLoaded(ctx, job.Name, content)
+	if sched != nil && wf.Schedule.Holidays != "" && d.skipHoliday(ctx, job, wf.Schedule.Holidays, content, time.Now().In(loc)) {
+		return
+	}
+
+	if current, err := d.store.GetJob(ctx, job.Name); sched != nil && err == nil && current != nil && current.LastRun.Valid {
+		interval := scheduleInterval(sched, current.LastRun.Time)
+		delay := backoffDelay(wf.Schedule.Backoff, interval, current.FailureStreak)
+		slack := backoffSlack
+		if interval/2 < slack {
+			// Sub-minute schedules would otherwise never back off.
+			slack = interval / 2
+		}
+		if delay > 0 && time.Since(current.LastRun.Time) < delay-slack {
+			d.logger.Printf("job %s backing off after %d failures; next attempt after %s", job.Name, current.FailureStreak, current.LastRun.Time.Add(delay).In(loc).Format(time.RFC3339))
+			return
+		}
+		if delay > 0 {
+			d.debugf("%s backoff of %s after %d failures has passed", job.Name, delay, current.FailureStreak)
+		}
+	}
+
+	repo, _ := wf.ExpandRepo()
+	if wf.Schedule.OnlyIfChanged && d.skipUnchanged(ctx, job.Name, repo, content, loc) {
+		return
+	}
+	if !d.waitForConditions(ctx, job.Name, repo, wf.Schedule) {
+		return
+	}
 
-	summary, err := runner.Run(ctx, runner.Options{Workflow: wf})
+	needs, err := d.store.OutputsFor(ctx, runner.NeededJobs(wf))
 	if err != nil {
-		d.logger.Printf("run %s error: %v", job.Name, err)
-		_ = d.store.UpdateRunResult(context.Background(), job.Name, "failed", time.Now().In(loc))
+		d.logger.Printf("load upstream outputs for %s: %v", job.Name, err)
+		return
+	}
+
+	resumeFrom := ""
+	d.mu.Lock()
+	resume := d.resume[job.Name]
+	delete(d.resume, job.Name)
+	d.mu.Unlock()
+	if resume {
+		if dir, err := runner.ResumePoint(repo, job.Name); err == nil {
+			d.logger.Printf("resuming %s from %s", job.Name, dir)
+			resumeFrom = dir
+		}
+	}
+
+	if sched == nil {
+		d.debugf("%s triggered outside its schedule; its requirements are met", job.Name)
+	} else {
+		d.debugf("%s is due and its requirements are met", job.Name)
+	}
+	d.emit(job.Name, runner.StepEvent{Kind: runner.EventRunScheduled, At: time.Now()})
+	slot := d.queue.acquire(job.Name, wf.Schedule.Priority, wf.Schedule.PreemptLimit(), d.logger.Printf)
+	defer d.queue.release(slot)
+
+	failures := d.failureStreak(ctx, job.Name)
+	summary, status := d.runOnce(ctx, job.Name, wf, content, needs, loc, resumeFrom)
+	if by := d.queue.preempted(slot); by != "" {
+		d.logger.Printf("job %s preempted by %s; requeued to resume from its checkpoint", job.Name, by)
+		d.mu.Lock()
+		d.resume[job.Name] = true
+		d.mu.Unlock()
+		requeue = true
 		return
 	}
+	if status == "failed" && wf.Heal != nil && wf.Heal.AutoHeal && d.autoHeal(ctx, job, wf, summary) {
+		retryFrom := ""
+		if summary != nil {
+			retryFrom = summary.RunDir
+		}
+		d.logger.Printf("re-running %s after self-heal", job.Name)
+		failures = d.failureStreak(ctx, job.Name)
+		summary, status = d.runOnce(ctx, job.Name, wf, content, needs, loc, retryFrom)
+	}
+
+	logTail := ""
+	if summary != nil {
+		logTail = summary.FailureTail()
+	}
+	d.afterRun(ctx, wf, job.Name, status, logTail, failures)
+	if _, once := sched.(onceSchedule); once {
+		d.disableOnce(ctx, job.Name)
+	}
+	if status == "success" {
+		d.triggerDependents(ctx, job.Name)
+	}
+}
+
+// skipUnchanged records a skipped run and reports true when repo's HEAD is
+// the commit the job's previous run saw. A repo whose HEAD cannot be read
+// always runs.
+func (d *Daemon) skipUnchanged(ctx context.Context, name, repo string, content []byte, loc *time.Location) bool {
+	head, err := headCommit(repo)
+	if err != nil {
+		d.logger.Printf("cannot read HEAD of %s for %s, running anyway: %v", repo, name, err)
+		return false
+	}
+	last, err := d.store.LastRunCommit(ctx, name)
+	if err != nil || last != head {
+		return false
+	}
+	if err := d.store.RecordSkippedRun(ctx, name, dsl.Hash(content), head); err != nil {
+		d.logger.Printf("record skipped run for %s: %v", name, err)
+	}
+	_ = d.store.UpdateRunResult(ctx, name, store.RunStatusSkipped, time.Now().In(loc))
+	d.logger.Printf("skipping %s: %s has not changed since the last run (%.12s)", name, repo, head)
+	return true
+}
+
+// skipHoliday records a skipped(holiday) run and reports true when the day
+// of now, in the job's timezone, is a holiday of the calendar. A calendar
+// that cannot be loaded is logged and the job runs.
+func (d *Daemon) skipHoliday(ctx context.Context, job store.Job, calendar string, content []byte, now time.Time) bool {
+	cal, err := holidays.Load(calendar, filepath.Dir(job.YAMLPath()))
+	if err != nil {
+		d.logger.Printf("cannot load holidays for %s, running anyway: %v", job.Name, err)
+		return false
+	}
+	holiday, ok := cal.Holiday(now)
+	if !ok {
+		return false
+	}
+	if err := d.store.RecordHolidayRun(ctx, job.Name, dsl.Hash(content), holiday); err != nil {
+		d.logger.Printf("record skipped run for %s: %v", job.Name, err)
+	}
+	_ = d.store.UpdateRunResult(ctx, job.Name, store.RunStatusHoliday, now)
+	d.logger.Printf("skipping %s: %s is a holiday (%s)", job.Name, now.Format("2006-01-02"), holiday)
+	return true
+}
+
+// approved reports whether the workflow content may run: it must be the
+// content last approved through devagent, so steps cannot be slipped into
+// a scheduled job by editing its file behind the user's back. A job that
+// was never approved, e.g. one synced from a watched directory, is an
+// unreviewed registration and is refused too.
+func (d *Daemon) approved(ctx context.Context, name string, content []byte, loc *time.Location) bool {
+	job, err := d.store.GetJob(ctx, name)
+	if err != nil || job == nil {
+		return true
+	}
+	switch {
+	case job.ApprovedHash == dsl.Hash(content):
+		return true
+	case d.AllowUnapproved && job.ApprovedHash == "":
+		d.logger.Printf("warning: workflow %s is an unreviewed registration; running it anyway (--allow-unapproved)", name)
+		return true
+	case d.AllowUnapproved:
+		d.logger.Printf("warning: workflow %s changed since it was approved; running it anyway (--allow-unapproved)", name)
+		return true
+	}
+	_ = d.store.UpdateRunResult(ctx, name, store.RunStatusUnapproved, time.Now().In(loc))
+	if job.ApprovedHash == "" {
+		d.logger.Printf("refusing to run %s: it is an unreviewed registration of %s; review it with `devagent approve %s`", name, job.YAMLPath(), name)
+	} else {
+		d.logger.Printf("refusing to run %s: %s changed since it was approved; review it with `devagent approve %s`", name, job.YAMLPath(), name)
+	}
+	return false
+}
+
+// headCommit returns the commit checked out in repo.
+func headCommit(repo string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = repo
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// waitForConditions holds a run back until the machine meets the schedule's
+// power, idle, load and disk requirements, re-checking until max_defer has
+// passed. It reports whether the job may start.
+func (d *Daemon) waitForConditions(ctx context.Context, name, repo string, s dsl.Schedule) bool {
+	if !s.HasRequirements() {
+		return true
+	}
+	deadline := time.Now().Add(s.DeferLimit())
+	deferred := false
+	for {
+		reasons := d.unmetConditions(ctx, name, repo, s)
+		if len(reasons) == 0 {
+			if deferred {
+				d.logger.Printf("requirements for %s met; starting", name)
+			}
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			d.logger.Printf("skipping %s: %s", name, strings.Join(reasons, ", "))
+			return false
+		}
+		if !deferred {
+			d.logger.Printf("deferring %s for up to %s: %s", name, s.DeferLimit(), strings.Join(reasons, ", "))
+			deferred = true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(conditionPoll):
+		}
+	}
+}
+
+// unmetConditions returns why the machine does not meet the schedule's
+// requirements. A check whose probe fails is logged and treated as met, so
+// a missing tool does not silently stop the job.
+func (d *Daemon) unmetConditions(ctx context.Context, name, repo string, s dsl.Schedule) []string {
+	var reasons []string
+	if len(s.Requires) > 0 || s.MinBattery > 0 {
+		state, err := d.probe(ctx)
+		if err != nil {
+			d.logger.Printf("power state unavailable for %s, ignoring power requirements: %v", name, err)
+		} else {
+			reasons = append(reasons, state.Unmet(power.Conditions{Requires: s.Requires, MinBattery: s.MinBattery, IdleAfter: d.IdleAfter})...)
+		}
+	}
+	if s.MaxLoad > 0 {
+		load, err := d.loadProbe(ctx)
+		if err != nil {
+			d.logger.Printf("load average unavailable for %s, ignoring max_load: %v", name, err)
+		} else if load > s.MaxLoad {
+			reasons = append(reasons, fmt.Sprintf("load average %.2f above %.2f", load, s.MaxLoad))
+		}
+	}
+	if min, _ := dsl.ParseSize(s.MinFreeDisk); min > 0 {
+		free, err := d.diskProbe(repo)
+		if err != nil {
+			d.logger.Printf("free disk unavailable for %s, ignoring min_free_disk: %v", name, err)
+		} else if free < min {
+			reasons = append(reasons, fmt.Sprintf("only %d MB free on %s (needs %s)", free>>20, repo, s.MinFreeDisk))
+		}
+	}
+	return reasons
+}
+
+// runOnce executes the workflow as a tracked run and records its result.
+// A non-empty resumeFrom skips the steps that run completed. The summary is
+// nil when the run could not start.
+func (d *Daemon) runOnce(ctx context.Context, name string, wf *dsl.Workflow, content []byte, needs map[string]map[string]string, loc *time.Location, resumeFrom string) (*runner.Summary, string) {
+	tracker, err := d.store.BeginRun(ctx, name, dsl.Hash(content))
+	if err != nil {
+		d.logger.Printf("record run start for %s: %v", name, err)
+	}
+	started := time.Now()
+	d.emit(name, runner.StepEvent{Kind: runner.EventRunStart, At: started, RunID: tracker.ID()})
+	finished := func(status string) {
+		now := time.Now()
+		d.emit(name, runner.StepEvent{Kind: runner.EventRunFinish, At: now, RunID: tracker.ID(), Status: status, DurationSec: now.Sub(started).Seconds()})
+		if err := localstats.RecordRun(status, now.Sub(started)); err != nil {
+			d.logger.Printf("record usage stats for %s: %v", name, err)
+		}
+	}
+	if repo, err := wf.ExpandRepo(); err == nil {
+		if head, err := headCommit(repo); err == nil {
+			_ = tracker.RecordCommit(ctx, head)
+		}
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.queue.attach(name, cancel)
+	defer d.queue.detach(name)
+	tracker.OnCancel(func() {
+		d.logger.Printf("cancelling job %s", name)
+		cancel()
+	})
+
+	// Unattended runs cannot ask for confirmation, so steps that need it
+	// are refused unless the user policy allows them.
+	pol, err := policy.Load()
+	if err != nil {
+		d.logger.Printf("run %s error: %v", name, err)
+		_ = tracker.Finish(ctx, "failed", "")
+		_ = d.store.UpdateRunResult(context.Background(), name, "failed", time.Now().In(loc))
+		d.report(ctx, wf, name, tracker.ID(), nil, err)
+		finished("failed")
+		return nil, "failed"
+	}
+	// Heartbeats come from one step at a time, so stalledStep needs no lock.
+	stalledStep := 0
+	heartbeat := func(p runner.StepProgress) {
+		if p.Stalled && p.Step != stalledStep {
+			d.logger.Printf("job %s stalled: step %d has produced no output for %s", name, p.Step, time.Since(p.LastOutput).Round(time.Second))
+		}
+		if p.Stalled {
+			stalledStep = p.Step
+		} else {
+			stalledStep = 0
+		}
+		_ = tracker.RecordProgress(ctx, store.StepProgress(p))
+	}
+	opts := runner.Options{Workflow: wf, Needs: needs, RunID: tracker.ID(), Source: content, Policy: pol, ResumeFrom: resumeFrom, Heartbeat: heartbeat, BenchBaseline: BenchBaseline(ctx, d.store, name)}
+	if d.Events != nil {
+		opts.Events = func(ev runner.StepEvent) {
+			ev.RunID = tracker.ID()
+			d.Events(name, ev)
+		}
+	}
+	summary, err := runner.Run(runCtx, opts)
+	if err != nil {
+		d.logger.Printf("run %s error: %v", name, err)
+		_ = tracker.Finish(ctx, "failed", "")
+		_ = d.store.UpdateRunResult(context.Background(), name, "failed", time.Now().In(loc))
+		d.report(ctx, wf, name, tracker.ID(), nil, err)
+		finished("failed")
+		return nil, "failed"
+	}
 
 	status := summary.Status
-	_ = d.store.UpdateRunResult(context.Background(), job.Name, status, time.Now().In(loc))
-	d.logger.Printf("job %s finished with %s", job.Name, status)
+	_ = tracker.RecordUsage(ctx, store.RunUsage(summary.Usage))
+	if summary.Tests != nil {
+		_ = tracker.RecordTests(ctx, store.RunTests(*summary.Tests))
+	}
+	if len(summary.Benchmarks) > 0 {
+		results := make([]store.BenchResult, len(summary.Benchmarks))
+		for i, r := range summary.Benchmarks {
+			results[i] = store.BenchResult(r)
+		}
+		_ = tracker.RecordBenchmarks(ctx, name, results)
+	}
+	_ = tracker.Finish(ctx, status, summary.RunDir)
+	_ = d.store.UpdateRunResult(context.Background(), name, status, time.Now().In(loc))
+	if status == "success" {
+		if err := d.store.SaveOutputs(ctx, name, summary.Outputs); err != nil {
+			d.logger.Printf("save outputs for %s: %v", name, err)
+		}
+	}
+	d.logger.Printf("job %s finished with %s", name, status)
+	d.report(ctx, wf, name, tracker.ID(), summary, nil)
+	finished(status)
+	return summary, status
+}
+
+// debugf logs a scheduling decision when the daemon is verbose.
+func (d *Daemon) debugf(format string, args ...interface{}) {
+	if d.Verbose {
+		d.logger.Printf(format, args...)
+	}
+}
+
+// emit passes a run event to Events, if set.
+func (d *Daemon) emit(job string, ev runner.StepEvent) {
+	if d.Events != nil {
+		d.Events(job, ev)
+	}
+}
+
+// report posts a run's summary to the workflow's report_to endpoints.
+// summary is nil for a run that could not start.
+func (d *Daemon) report(ctx context.Context, wf *dsl.Workflow, name string, runID int64, summary *runner.Summary, runErr error) {
+	if len(wf.ReportTo) == 0 {
+		return
+	}
+	body, err := json.Marshal(runner.NewReport(name, summary, runID, runErr))
+	if err == nil {
+		err = notify.SendReport(ctx, wf.ReportTo, name, body)
+	}
+	if err != nil {
+		d.logger.Printf("report %s: %v", name, err)
+	}
 }
 
-var errAlreadyRunning = errors.New("job already running")
+// autoHeal asks the planner's model for remediation commands after a failed
+// run and runs them when every one is on the workflow's heal allow list.
+// It reports whether the fix was applied and the job should be retried;
+// rejected proposals are logged for review with `devagent heal`.
+func (d *Daemon) autoHeal(ctx context.Context, job store.Job, wf *dsl.Workflow, failed *runner.Summary) bool {
+	apiKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+	if apiKey == "" {
+		d.logger.Printf("self-heal for %s skipped: OPENAI_API_KEY not set", job.Name)
+		return false
+	}
+	run, err := d.store.LastFailedRun(ctx, job.Name)
+	if err != nil || run == nil {
+		d.logger.Printf("self-heal for %s skipped: failed run not found: %v", job.Name, err)
+		return false
+	}
+	fix, err := planner.ProposeFix(ctx, diagnose.Report(ctx, d.store, job, run), planner.Options{APIKey: apiKey})
+	if err != nil {
+		d.logger.Printf("self-heal for %s: %v", job.Name, err)
+		return false
+	}
+	pol, err := policy.Load()
+	if err != nil {
+		d.logger.Printf("self-heal for %s: %v", job.Name, err)
+		return false
+	}
+	repo, _ := wf.ExpandRepo()
+	for _, command := range fix.Steps {
+		if !wf.Heal.Allows(command) {
+			d.logger.Printf("self-heal for %s needs approval: %q is not on the allow list; review with `devagent heal %s`", job.Name, command, job.Name)
+			return false
+		}
+		if decision := pol.Check(command, repo); decision.Action != policy.Allow {
+			d.logger.Printf("self-heal for %s refused: %q %s by policy (%s)", job.Name, command, decision.Action, decision.Reason)
+			return false
+		}
+	}
+	d.logger.Printf("self-heal for %s (%s): running %s", job.Name, fix.Cause, strings.Join(fix.Steps, "; "))
+	_, ok, err := runner.Heal(ctx, wf, failed, fix.Steps, nil)
+	if err != nil {
+		d.logger.Printf("self-heal for %s: %v", job.Name, err)
+		return false
+	}
+	if !ok {
+		d.logger.Printf("self-heal for %s failed; not retrying", job.Name)
+	}
+	return ok
+}
 
-func acquireLock(name string) (*os.File, error) {
-	dir, err := store.LocksDir()
+// recordLoaded snapshots the workflow file the daemon is about to run so
+// `devagent diff` can compare it with later edits.
+func (d *Daemon) recordLoaded(ctx context.Context, name string, content []byte) {
+	rev, err := d.store.RecordRevision(ctx, name, content, "daemon")
+	if err == nil {
+		err = d.store.MarkRevisionLoaded(ctx, rev.ID)
+	}
 	if err != nil {
-		return nil, err
+		d.logger.Printf("record workflow revision for %s: %v", name, err)
+	}
+}
+
+// disableOnce pauses a one-shot job after it fired so it is not rescheduled.
+func (d *Daemon) disableOnce(ctx context.Context, name string) {
+	if err := d.store.SetPaused(ctx, name, true); err != nil {
+		d.logger.Printf("disable one-shot %s: %v", name, err)
+		return
 	}
-	fileName := sanitizeName(name) + ".lock"
-	path := filepath.Join(dir, fileName)
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	d.logger.Printf("one-shot job %s completed and was disabled", name)
+}
+
+// triggerDependents runs the jobs declaring `after: name`. Jobs caught in a
+// dependency cycle are skipped so a loop cannot run forever.
+func (d *Daemon) triggerDependents(ctx context.Context, name string) {
+	jobs, err := d.store.ListJobs(ctx)
 	if err != nil {
-		return nil, err
+		d.logger.Printf("list dependents of %s: %v", name, err)
+		return
+	}
+	for _, dep := range Dependents(jobs, name) {
+		if dep.Paused {
+			d.logger.Printf("skipping paused dependent %s of %s", dep.Name, name)
+			continue
+		}
+		if inCycle(jobs, dep.Name) {
+			d.logger.Printf("skipping dependent %s of %s: dependency cycle", dep.Name, name)
+			continue
+		}
+		d.logger.Printf("triggering %s after %s succeeded", dep.Name, name)
+		d.execute(dep, nil, util.ResolveLocation(dep.Timezone()))
+	}
+}
+
+// afterRun sends notifications and applies the pause policy once the run
+// result has been recorded. logTail is included in failure notifications;
+// failuresBefore, the failure streak before the run, is reported when a
+// successful run ends it.
+func (d *Daemon) afterRun(ctx context.Context, wf *dsl.Workflow, name, status, logTail string, failuresBefore int) {
+	streak := d.failureStreak(ctx, name)
+	if channel, msg, ok := notify.ForRun(wf.Notify, name, status, streak); ok {
+		msg.LogTail = logTail
+		d.send(ctx, channel, msg)
+	}
+	if msg, severity, ok := notify.ForIncident(wf.Notify, name, status, streak); ok {
+		msg.LogTail = logTail
+		if err := notify.TriggerIncident(ctx, wf.Notify.Incident, msg, severity); err != nil {
+			d.logger.Printf("incident %s: %v", name, err)
+		}
 	}
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
-		f.Close()
-		if errors.Is(err, syscall.EWOULDBLOCK) {
-			return nil, errAlreadyRunning
+	if status == "success" {
+		if channel, msg, ok := notify.ForRecovery(wf.Notify, name, failuresBefore); ok {
+			d.logger.Printf("job %s recovered after %d failures", name, failuresBefore)
+			d.send(ctx, channel, msg)
 		}
-		return nil, err
+		if notify.IncidentOpen(wf.Notify, failuresBefore) {
+			if err := notify.ResolveIncident(ctx, wf.Notify.Incident, name); err != nil {
+				d.logger.Printf("resolve incident %s: %v", name, err)
+			}
+		}
+	}
+
+	backoff := wf.Schedule.Backoff
+	if backoff == nil || backoff.PauseAfter <= 0 || streak < backoff.PauseAfter {
+		return
+	}
+	if err := d.store.SetPaused(ctx, name, true); err != nil {
+		d.logger.Printf("pause %s: %v", name, err)
+		return
+	}
+	d.logger.Printf("job %s paused after %d consecutive failures", name, streak)
+	if channel, msg, ok := notify.ForPause(wf.Notify, name, streak); ok {
+		d.send(ctx, channel, msg)
+	}
+}
+
+// failureStreak returns the job's current count of consecutive failed runs.
+func (d *Daemon) failureStreak(ctx context.Context, name string) int {
+	if current, err := d.store.GetJob(ctx, name); err == nil && current != nil {
+		return current.FailureStreak
+	}
+	return 0
+}
+
+// sendDigest sends the digest configured in the global config once its
+// period has ended. A digest is recorded as sent even when the channel
+// fails, so a broken channel is not retried every tick.
+func (d *Daemon) sendDigest(ctx context.Context, now time.Time) {
+	cfg, err := digest.LoadConfig()
+	var start, end time.Time
+	due := false
+	if err == nil && cfg != nil {
+		start, end, due, err = digest.Due(ctx, d.store, cfg, now)
+	}
+	if err != nil {
+		if msg := err.Error(); msg != d.lastDigestErr {
+			d.logger.Printf("digest: %v", err)
+			d.lastDigestErr = msg
+		}
+		return
+	}
+	d.lastDigestErr = ""
+	if !due {
+		return
+	}
+	msg, err := digest.Collect(ctx, d.store, start, end)
+	if err != nil {
+		d.logger.Printf("digest: %v", err)
+		return
+	}
+	if err := d.store.RecordDigest(ctx, start, end); err != nil {
+		d.logger.Printf("digest: %v", err)
+		return
+	}
+	d.logger.Printf("sending %s digest for %s to %s", cfg.Every, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if err := notify.Send(ctx, cfg.Channel, msg); err != nil {
+		d.logger.Printf("digest: %v", err)
+	}
+}
+
+// checkStore reports a corrupt store at startup, which would otherwise
+// only show as jobs failing to load or record their runs.
+func (d *Daemon) checkStore(ctx context.Context) {
+	path, err := store.StatePath()
+	if err != nil {
+		return
+	}
+	problems, err := store.CheckIntegrity(ctx, path)
+	if err != nil {
+		d.logger.Printf("check store: %v", err)
+		return
+	}
+	if len(problems) > 0 {
+		d.logger.Printf("store %s is corrupt (%s); stop the daemon and run `devagent doctor --check-db --repair`", path, problems[0])
+	}
+}
+
+// checkWorkflowFiles reports jobs whose workflow file is gone or no
+// longer matches the store; the daemon keeps scheduling them from the
+// store until they are reconciled.
+func (d *Daemon) checkWorkflowFiles(ctx context.Context) {
+	drifts, err := d.store.CheckWorkflowFiles(ctx)
+	if err != nil {
+		d.logger.Printf("check workflow files: %v", err)
+		return
+	}
+	for _, drift := range drifts {
+		d.logger.Printf("%s", drift)
+	}
+	if len(drifts) > 0 {
+		d.logger.Printf("run `devagent doctor --reconcile` to resync the store from the workflow files")
 	}
-	return f, nil
 }
 
-func releaseLock(f *os.File) {
-	if f == nil {
+// backup backs up the store once BackupEvery has passed since the newest
+// backup.
+func (d *Daemon) backup(ctx context.Context, now time.Time) {
+	if d.BackupEvery <= 0 {
+		return
+	}
+	backups, err := store.Backups()
+	if err == nil && len(backups) > 0 && now.Sub(backups[0].TakenAt) < d.BackupEvery {
+		return
+	}
+	var backup store.Backup
+	if err == nil {
+		backup, err = d.store.BackupNow(ctx, d.BackupKeep)
+	}
+	if err != nil {
+		if msg := err.Error(); msg != d.lastBackupErr {
+			d.logger.Printf("backup: %v", err)
+			d.lastBackupErr = msg
+		}
 		return
 	}
-	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
-	f.Close()
+	d.lastBackupErr = ""
+	d.logger.Printf("backed up the store to %s", backup.Path)
+}
+
+func (d *Daemon) send(ctx context.Context, channel string, msg notify.Message) {
+	if err := notify.Send(ctx, channel, msg); err != nil {
+		d.logger.Printf("notify %s: %v", msg.Job, err)
+	}
+}
+
+// backoffSlack tolerates cron firing slightly early relative to the recorded
+// last run time. Schedules under two minutes apart use half their interval.
+const backoffSlack = time.Minute
+
+// backoffDelay returns the minimum time since the last run before a failing
+// job may run again. The delay doubles for each failure at or beyond the
+// backoff threshold and is capped at the configured maximum.
+func backoffDelay(cfg *dsl.Backoff, interval time.Duration, streak int) time.Duration {
+	if cfg == nil || cfg.After <= 0 || streak < cfg.After || interval <= 0 {
+		return 0
+	}
+	max := cfg.MaxDelay()
+	delay := interval
+	for i := cfg.After; i <= streak && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
 }
 
-func sanitizeName(name string) string {
-	name = strings.ToLower(name)
-	replacer := strings.NewReplacer(" ", "-", "/", "-", "\\", "-", ":", "-", "..", "-")
-	return replacer.Replace(name)
+// scheduleInterval estimates the normal gap between runs starting from t.
+func scheduleInterval(sched cron.Schedule, t time.Time) time.Duration {
+	next := sched.Next(t)
+	if next.IsZero() {
+		return 0
+	}
+	after := sched.Next(next)
+	if after.IsZero() {
+		return 0
+	}
+	return after.Sub(next)
 }
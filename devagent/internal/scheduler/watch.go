@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"devagent/internal/dsl"
+	"devagent/internal/store"
+)
+
+// syncWatched makes the registry follow the workflow files in WatchDirs:
+// a new file registers its job, a changed file updates it, and a deleted
+// file removes it. Jobs whose workflow file lives elsewhere are left
+// alone. A file that does not parse keeps its last good registration.
+func (d *Daemon) syncWatched(ctx context.Context) {
+	if len(d.WatchDirs) == 0 {
+		return
+	}
+	// A directory that cannot be read, e.g. an unmounted volume, must not
+	// look like every file was deleted.
+	files, err := watchedFiles(d.WatchDirs)
+	if err == nil {
+		var jobs []store.Job
+		if jobs, err = d.store.ListJobs(ctx); err == nil {
+			d.reportWatch("", "")
+			d.syncFiles(ctx, files, jobs)
+			return
+		}
+	}
+	d.reportWatch("", err.Error())
+}
+
+// syncFiles applies the watched files to the registry of jobs.
+func (d *Daemon) syncFiles(ctx context.Context, files map[string][]byte, jobs []store.Job) {
+	managed := make(map[string]store.Job)
+	for _, job := range jobs {
+		if d.watched(job.YAMLPath()) {
+			managed[job.YAMLPath()] = job
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	declared := make(map[string]string)
+	for _, path := range paths {
+		content := files[path]
+		wf, err := dsl.Parse(content)
+		if err == nil {
+			err = ValidateSchedule(wf.Schedule)
+		}
+		if err != nil {
+			d.reportWatch(path, err.Error())
+			continue
+		}
+		if other, ok := declared[wf.Name]; ok {
+			d.reportWatch(path, "job "+wf.Name+" is already declared by "+other)
+			continue
+		}
+		declared[wf.Name] = path
+		d.reportWatch(path, "")
+
+		job := store.JobFromWorkflow(wf, path)
+		if previous, ok := managed[path]; ok && previous.Name != wf.Name {
+			if existing, _ := d.store.GetJob(ctx, wf.Name); existing == nil {
+				if err := d.store.RenameJob(ctx, previous.Name, wf.Name); err != nil {
+					d.reportWatch(path, err.Error())
+					continue
+				}
+				d.unschedule(previous.Name)
+				d.logger.Printf("renamed %s to %s (%s)", previous.Name, wf.Name, path)
+			}
+		}
+		existing, err := d.store.GetJob(ctx, wf.Name)
+		if err != nil {
+			d.reportWatch(path, err.Error())
+			continue
+		}
+		approvedHash := ""
+		if existing != nil {
+			approvedHash = existing.ApprovedHash
+		}
+		if existing != nil && len(store.Differences(*existing, job)) == 0 {
+			d.awaitApproval(ctx, wf.Name, path, content, approvedHash)
+			continue
+		}
+		if err := d.store.UpsertJob(ctx, job); err != nil {
+			d.reportWatch(path, err.Error())
+			continue
+		}
+		d.unschedule(wf.Name)
+		if existing == nil {
+			d.logger.Printf("registered %s from %s", wf.Name, path)
+		} else {
+			d.logger.Printf("updated %s from %s", wf.Name, path)
+		}
+		d.awaitApproval(ctx, wf.Name, path, content, approvedHash)
+	}
+
+	for path, job := range managed {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		if current, err := d.store.GetJob(ctx, job.Name); err != nil || current == nil || current.YAMLPath() != path {
+			continue
+		}
+		if err := d.store.RemoveJob(ctx, job.Name); err != nil {
+			d.reportWatch(path, err.Error())
+			continue
+		}
+		d.unschedule(job.Name)
+		d.logger.Printf("removed %s: %s was deleted", job.Name, path)
+	}
+}
+
+// awaitApproval records content, the workflow file at path, as a revision
+// of job when it is not the approved one. Anyone who can write to a
+// watched directory could otherwise add steps, so the daemon refuses to
+// run the change until `devagent approve` accepts it.
+func (d *Daemon) awaitApproval(ctx context.Context, job, path string, content []byte, approvedHash string) {
+	hash := dsl.Hash(content)
+	if hash == approvedHash {
+		return
+	}
+	if _, err := d.store.FindRevision(ctx, job, hash); err == nil {
+		// Already recorded and reported on an earlier sync.
+		return
+	}
+	if _, err := d.store.RecordRevision(ctx, job, content, "watch"); err != nil {
+		d.logger.Printf("record revision of %s: %v", job, err)
+		return
+	}
+	d.logger.Printf("%s changed in %s; waiting for `devagent approve %s` before running it", job, path, job)
+}
+
+// watched reports whether path is inside one of WatchDirs.
+func (d *Daemon) watched(path string) bool {
+	for _, dir := range d.WatchDirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// unschedule drops a job's cron entry so the next reload schedules it
+// from its updated registration.
+func (d *Daemon) unschedule(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entryID, ok := d.jobs[name]; ok {
+		d.cron.Remove(entryID)
+		delete(d.jobs, name)
+		delete(d.calendars, name)
+	}
+}
+
+// reportWatch logs a problem with a watched file once, until it changes;
+// an empty msg clears it.
+func (d *Daemon) reportWatch(path, msg string) {
+	if msg == "" {
+		delete(d.watchErrs, path)
+		return
+	}
+	if d.watchErrs[path] == msg {
+		return
+	}
+	d.watchErrs[path] = msg
+	if path == "" {
+		d.logger.Printf("watch: %s", msg)
+	} else {
+		d.logger.Printf("watch %s: %s", path, msg)
+	}
+}
+
+// watchedFiles reads the .yml and .yaml files under dirs, skipping hidden
+// directories such as .git.
+func watchedFiles(dirs []string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if path != dir && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if ext := filepath.Ext(path); ext != ".yml" && ext != ".yaml" {
+				return nil
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[path] = content
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,903 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value is a script value: nil, bool, float64, string, *Table or *Function.
+type Value interface{}
+
+// Function is a function callable from a script: a Go function, or one
+// defined by the script.
+type Function struct {
+	name string
+	gofn func(args []Value) ([]Value, error)
+	fn   *funcExpr
+	env  *scope
+}
+
+// Func wraps a Go function for use in a script. An error it returns is
+// raised in the script.
+func Func(name string, fn func(args []Value) ([]Value, error)) *Function {
+	return &Function{name: name, gofn: fn}
+}
+
+// Table is a script table, with its 1..n sequence kept apart from its other
+// keys.
+type Table struct {
+	arr  []Value
+	hash map[Value]Value
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Get returns the value at key, nil if there is none.
+func (t *Table) Get(key Value) Value {
+	if n, ok := key.(float64); ok {
+		if i := int(n); float64(i) == n && i >= 1 && i <= len(t.arr) {
+			return t.arr[i-1]
+		}
+	}
+	return t.hash[key]
+}
+
+// Set stores value at key; a nil value removes the key.
+func (t *Table) Set(key, value Value) error {
+	switch k := key.(type) {
+	case nil:
+		return fmt.Errorf("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return fmt.Errorf("table index is NaN")
+		}
+		if i := int(k); float64(i) == k && i >= 1 && i <= len(t.arr)+1 {
+			t.setIndex(i, value)
+			return nil
+		}
+	}
+	if value == nil {
+		delete(t.hash, key)
+		return nil
+	}
+	if t.hash == nil {
+		t.hash = map[Value]Value{}
+	}
+	t.hash[key] = value
+	return nil
+}
+
+func (t *Table) setIndex(i int, value Value) {
+	if i <= len(t.arr) {
+		t.arr[i-1] = value
+		for len(t.arr) > 0 && t.arr[len(t.arr)-1] == nil {
+			t.arr = t.arr[:len(t.arr)-1]
+		}
+		return
+	}
+	if value == nil {
+		delete(t.hash, float64(i))
+		return
+	}
+	t.arr = append(t.arr, value)
+	delete(t.hash, float64(i))
+	// Keys that continue the sequence move over from the hash.
+	for {
+		next, ok := t.hash[float64(len(t.arr)+1)]
+		if !ok {
+			return
+		}
+		t.arr = append(t.arr, next)
+		delete(t.hash, float64(len(t.arr)))
+	}
+}
+
+// Len returns the length of the table's sequence.
+func (t *Table) Len() int {
+	return len(t.arr)
+}
+
+// Append adds value at the end of the sequence.
+func (t *Table) Append(value Value) {
+	t.setIndex(len(t.arr)+1, value)
+}
+
+// Keys returns the table's keys: the sequence in order, then numbers and
+// strings sorted, then any others.
+func (t *Table) Keys() []Value {
+	keys := make([]Value, 0, len(t.arr)+len(t.hash))
+	for i, v := range t.arr {
+		if v != nil {
+			keys = append(keys, float64(i+1))
+		}
+	}
+	rest := make([]Value, 0, len(t.hash))
+	for k := range t.hash {
+		rest = append(rest, k)
+	}
+	rank := func(v Value) int {
+		switch v.(type) {
+		case float64:
+			return 0
+		case string:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		a, b := rest[i], rest[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		switch a := a.(type) {
+		case float64:
+			return a < b.(float64)
+		case string:
+			return a < b.(string)
+		}
+		// Booleans, tables and functions: false before true, and by
+		// address, so every call returns the same order.
+		return keyOrder(a) < keyOrder(b)
+	})
+	return append(keys, rest...)
+}
+
+func keyOrder(v Value) string {
+	if b, ok := v.(bool); ok {
+		return strconv.FormatBool(b)
+	}
+	return fmt.Sprintf("%p", v)
+}
+
+// scope holds the local variables of a block.
+type scope struct {
+	vars   map[string]*Value
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent}
+}
+
+func (s *scope) declare(name string, value Value) {
+	if s.vars == nil {
+		s.vars = map[string]*Value{}
+	}
+	s.vars[name] = &value
+}
+
+func (s *scope) lookup(name string) *Value {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// Error is a script error: a syntax error, an error raised by the script
+// or a runtime error, with the chunk name and line where it occurred.
+type Error struct {
+	// Value is the value passed to error(); a message for other errors.
+	Value Value
+}
+
+func (e *Error) Error() string {
+	if s, ok := e.Value.(string); ok {
+		return s
+	}
+	return "(error object is a " + typeName(e.Value) + " value)"
+}
+
+// maxDepth bounds the nesting of function calls.
+const maxDepth = 200
+
+type interp struct {
+	ctx     context.Context
+	name    string
+	globals *Table
+	strings *Table // the string library, for methods on strings
+	depth   int
+	ticks   int
+	maxHeap uint64
+	// outOfMemory is set once the heap limit is reached, which, like
+	// cancellation, pcall does not catch.
+	outOfMemory bool
+	// The keys of the table next last traversed, and the position of the
+	// key it returned.
+	nextTable *Table
+	nextKeys  []Value
+	nextPos   int
+}
+
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowReturn
+)
+
+// errorf returns a runtime error located at line.
+func (in *interp) errorf(line int, format string, args ...interface{}) error {
+	return &Error{Value: fmt.Sprintf("%s:%d: %s", in.name, line, fmt.Sprintf(format, args...))}
+}
+
+// tick checks for cancellation and the heap limit every so often, so loops
+// and recursion stop with the step or before they exhaust memory.
+func (in *interp) tick(line int) error {
+	in.ticks++
+	if in.ticks%1024 != 0 {
+		return nil
+	}
+	if in.ctx.Err() != nil {
+		return in.errorf(line, "interrupted: %v", in.ctx.Err())
+	}
+	if in.maxHeap > 0 {
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		metrics.Read(sample)
+		if heap := sample[0].Value.Uint64(); heap > in.maxHeap {
+			in.outOfMemory = true
+			return in.errorf(line, "out of memory: the heap reached %d MB, over the limit of %d MB", heap>>20, in.maxHeap>>20)
+		}
+	}
+	return nil
+}
+
+func (in *interp) execBlock(b *block, sc *scope) (flow, []Value, error) {
+	inner := newScope(sc)
+	return in.execStmts(b.stmts, inner)
+}
+
+func (in *interp) execStmts(stmts []stmt, sc *scope) (flow, []Value, error) {
+	for _, s := range stmts {
+		f, vals, err := in.exec(s, sc)
+		if err != nil || f != flowNormal {
+			return f, vals, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *interp) exec(s stmt, sc *scope) (flow, []Value, error) {
+	switch s := s.(type) {
+	case localStmt:
+		values, err := in.evalList(s.exprs, sc)
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, name := range s.names {
+			var v Value
+			if i < len(values) {
+				v = values[i]
+			}
+			sc.declare(name, v)
+		}
+	case localFuncStmt:
+		// Declared first so the function can call itself.
+		sc.declare(s.name, nil)
+		*sc.lookup(s.name) = &Function{name: s.name, fn: s.fn, env: sc}
+	case assignStmt:
+		values, err := in.evalList(s.exprs, sc)
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, target := range s.targets {
+			var v Value
+			if i < len(values) {
+				v = values[i]
+			}
+			if err := in.assign(target, v, sc); err != nil {
+				return 0, nil, err
+			}
+		}
+	case callStmt:
+		if _, err := in.evalMulti(s.call, sc); err != nil {
+			return 0, nil, err
+		}
+	case doStmt:
+		return in.execBlock(s.body, sc)
+	case whileStmt:
+		for {
+			cond, err := in.eval(s.cond, sc)
+			if err != nil {
+				return 0, nil, err
+			}
+			if !truthy(cond) {
+				break
+			}
+			f, vals, err := in.execBlock(s.body, sc)
+			if err != nil || f == flowReturn {
+				return f, vals, err
+			}
+			if f == flowBreak {
+				break
+			}
+			if err := in.tick(0); err != nil {
+				return 0, nil, err
+			}
+		}
+	case repeatStmt:
+		for {
+			// The condition sees the body's locals.
+			inner := newScope(sc)
+			f, vals, err := in.execStmts(s.body.stmts, inner)
+			if err != nil || f == flowReturn {
+				return f, vals, err
+			}
+			if f == flowBreak {
+				break
+			}
+			cond, err := in.eval(s.cond, inner)
+			if err != nil {
+				return 0, nil, err
+			}
+			if truthy(cond) {
+				break
+			}
+			if err := in.tick(0); err != nil {
+				return 0, nil, err
+			}
+		}
+	case ifStmt:
+		for i, c := range s.conds {
+			cond, err := in.eval(c, sc)
+			if err != nil {
+				return 0, nil, err
+			}
+			if truthy(cond) {
+				return in.execBlock(s.blocks[i], sc)
+			}
+		}
+		if s.els != nil {
+			return in.execBlock(s.els, sc)
+		}
+	case numForStmt:
+		return in.numFor(s, sc)
+	case genForStmt:
+		return in.genFor(s, sc)
+	case returnStmt:
+		values, err := in.evalList(s.exprs, sc)
+		return flowReturn, values, err
+	case breakStmt:
+		return flowBreak, nil, nil
+	default:
+		panic(fmt.Sprintf("script: unknown statement %T", s))
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *interp) numFor(s numForStmt, sc *scope) (flow, []Value, error) {
+	bound := func(e expr, what string) (float64, error) {
+		v, err := in.eval(e, sc)
+		if err != nil {
+			return 0, err
+		}
+		n, ok := toNumber(v)
+		if !ok {
+			return 0, in.errorf(s.line, "'for' %s must be a number", what)
+		}
+		return n, nil
+	}
+	start, err := bound(s.start, "initial value")
+	if err != nil {
+		return 0, nil, err
+	}
+	stop, err := bound(s.stop, "limit")
+	if err != nil {
+		return 0, nil, err
+	}
+	step := 1.0
+	if s.step != nil {
+		if step, err = bound(s.step, "step"); err != nil {
+			return 0, nil, err
+		}
+		if step == 0 {
+			return 0, nil, in.errorf(s.line, "'for' step is zero")
+		}
+	}
+	for i := start; (step > 0 && i <= stop) || (step < 0 && i >= stop); i += step {
+		inner := newScope(sc)
+		inner.declare(s.name, i)
+		f, vals, err := in.execStmts(s.body.stmts, inner)
+		if err != nil || f == flowReturn {
+			return f, vals, err
+		}
+		if f == flowBreak {
+			break
+		}
+		if err := in.tick(s.line); err != nil {
+			return 0, nil, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *interp) genFor(s genForStmt, sc *scope) (flow, []Value, error) {
+	values, err := in.evalList(s.exprs, sc)
+	if err != nil {
+		return 0, nil, err
+	}
+	values = append(values, nil, nil, nil)
+	iter, state, control := values[0], values[1], values[2]
+	for {
+		results, err := in.call(iter, []Value{state, control}, s.line)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(results) == 0 || results[0] == nil {
+			break
+		}
+		control = results[0]
+		inner := newScope(sc)
+		for i, name := range s.names {
+			var v Value
+			if i < len(results) {
+				v = results[i]
+			}
+			inner.declare(name, v)
+		}
+		f, vals, err := in.execStmts(s.body.stmts, inner)
+		if err != nil || f == flowReturn {
+			return f, vals, err
+		}
+		if f == flowBreak {
+			break
+		}
+		if err := in.tick(s.line); err != nil {
+			return 0, nil, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (in *interp) assign(target expr, v Value, sc *scope) error {
+	switch t := target.(type) {
+	case nameExpr:
+		if ref := sc.lookup(t.name); ref != nil {
+			*ref = v
+			return nil
+		}
+		return in.globals.Set(t.name, v)
+	case indexExpr:
+		obj, err := in.eval(t.obj, sc)
+		if err != nil {
+			return err
+		}
+		key, err := in.eval(t.key, sc)
+		if err != nil {
+			return err
+		}
+		table, ok := obj.(*Table)
+		if !ok {
+			return in.errorf(t.line, "attempt to index a %s value%s", typeName(obj), describeExpr(t.obj))
+		}
+		if err := table.Set(key, v); err != nil {
+			return in.errorf(t.line, "%v", err)
+		}
+		return nil
+	}
+	panic(fmt.Sprintf("script: cannot assign to %T", target))
+}
+
+// evalList evaluates exprs; the last one may contribute several values.
+func (in *interp) evalList(exprs []expr, sc *scope) ([]Value, error) {
+	var values []Value
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			rest, err := in.evalMulti(e, sc)
+			if err != nil {
+				return nil, err
+			}
+			return append(values, rest...), nil
+		}
+		v, err := in.eval(e, sc)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// evalMulti evaluates e, keeping all the values of a call.
+func (in *interp) evalMulti(e expr, sc *scope) ([]Value, error) {
+	switch e := e.(type) {
+	case callExpr:
+		fn, err := in.eval(e.fn, sc)
+		if err != nil {
+			return nil, err
+		}
+		args, err := in.evalList(e.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fn.(*Function); !ok {
+			return nil, in.errorf(e.line, "attempt to call a %s value%s", typeName(fn), describeExpr(e.fn))
+		}
+		return in.call(fn, args, e.line)
+	case methodExpr:
+		obj, err := in.eval(e.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		fn, err := in.index(obj, e.name, e.line, e.obj)
+		if err != nil {
+			return nil, err
+		}
+		args, err := in.evalList(e.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fn.(*Function); !ok {
+			return nil, in.errorf(e.line, "attempt to call a %s value (method '%s')", typeName(fn), e.name)
+		}
+		return in.call(fn, append([]Value{obj}, args...), e.line)
+	}
+	v, err := in.eval(e, sc)
+	return []Value{v}, err
+}
+
+func (in *interp) call(fn Value, args []Value, line int) ([]Value, error) {
+	f, ok := fn.(*Function)
+	if !ok {
+		return nil, in.errorf(line, "attempt to call a %s value", typeName(fn))
+	}
+	if err := in.tick(line); err != nil {
+		return nil, err
+	}
+	if in.depth >= maxDepth {
+		return nil, in.errorf(line, "stack overflow")
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+	if f.gofn != nil {
+		results, err := f.gofn(args)
+		if err != nil {
+			if se, ok := err.(*Error); ok {
+				// Like Lua, error("message") reports where it was called.
+				if msg, isString := se.Value.(string); isString && f.name == "error" && line > 0 {
+					return nil, &Error{Value: fmt.Sprintf("%s:%d: %s", in.name, line, msg)}
+				}
+				return nil, err
+			}
+			return nil, in.errorf(line, "%s: %v", f.name, err)
+		}
+		return results, nil
+	}
+	sc := newScope(f.env)
+	for i, name := range f.fn.params {
+		var v Value
+		if i < len(args) {
+			v = args[i]
+		}
+		sc.declare(name, v)
+	}
+	_, results, err := in.execStmts(f.fn.body.stmts, sc)
+	return results, err
+}
+
+func (in *interp) eval(e expr, sc *scope) (Value, error) {
+	switch e := e.(type) {
+	case constExpr:
+		return e.value, nil
+	case nameExpr:
+		if ref := sc.lookup(e.name); ref != nil {
+			return *ref, nil
+		}
+		return in.globals.Get(e.name), nil
+	case indexExpr:
+		obj, err := in.eval(e.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		key, err := in.eval(e.key, sc)
+		if err != nil {
+			return nil, err
+		}
+		return in.index(obj, key, e.line, e.obj)
+	case callExpr, methodExpr:
+		values, err := in.evalMulti(e, sc)
+		if err != nil || len(values) == 0 {
+			return nil, err
+		}
+		return values[0], nil
+	case parenExpr:
+		return in.eval(e.x, sc)
+	case *funcExpr:
+		return &Function{name: e.name, fn: e, env: sc}, nil
+	case tableExpr:
+		t := NewTable()
+		n := 0 // positional items keep their index even when nil
+		for i, v := range e.values {
+			if e.keys[i] == nil {
+				values := []Value{nil}
+				var err error
+				if i == len(e.values)-1 {
+					values, err = in.evalMulti(v, sc)
+				} else {
+					values[0], err = in.eval(v, sc)
+				}
+				if err != nil {
+					return nil, err
+				}
+				for _, value := range values {
+					n++
+					t.Set(float64(n), value)
+				}
+				continue
+			}
+			key, err := in.eval(e.keys[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			value, err := in.eval(v, sc)
+			if err != nil {
+				return nil, err
+			}
+			if err := t.Set(key, value); err != nil {
+				return nil, in.errorf(e.line, "%v", err)
+			}
+		}
+		return t, nil
+	case unExpr:
+		x, err := in.eval(e.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "not":
+			return !truthy(x), nil
+		case "-":
+			n, ok := toNumber(x)
+			if !ok {
+				return nil, in.errorf(e.line, "attempt to perform arithmetic on a %s value%s", typeName(x), describeExpr(e.x))
+			}
+			return -n, nil
+		case "#":
+			switch x := x.(type) {
+			case string:
+				return float64(len(x)), nil
+			case *Table:
+				return float64(x.Len()), nil
+			}
+			return nil, in.errorf(e.line, "attempt to get length of a %s value%s", typeName(x), describeExpr(e.x))
+		}
+	case binExpr:
+		return in.binary(e, sc)
+	}
+	panic(fmt.Sprintf("script: unknown expression %T", e))
+}
+
+func (in *interp) index(obj, key Value, line int, from expr) (Value, error) {
+	switch o := obj.(type) {
+	case *Table:
+		return o.Get(key), nil
+	case string:
+		// Strings index the string library, for s:upper() and the like.
+		return in.strings.Get(key), nil
+	}
+	return nil, in.errorf(line, "attempt to index a %s value%s", typeName(obj), describeExpr(from))
+}
+
+func (in *interp) binary(e binExpr, sc *scope) (Value, error) {
+	l, err := in.eval(e.l, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "and":
+		if !truthy(l) {
+			return l, nil
+		}
+		return in.eval(e.r, sc)
+	case "or":
+		if truthy(l) {
+			return l, nil
+		}
+		return in.eval(e.r, sc)
+	}
+	r, err := in.eval(e.r, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==":
+		return equal(l, r), nil
+	case "~=":
+		return !equal(l, r), nil
+	case "<", "<=", ">", ">=":
+		return in.compare(e, l, r)
+	case "..":
+		ls, lok := toConcat(l)
+		rs, rok := toConcat(r)
+		if !lok {
+			return nil, in.errorf(e.line, "attempt to concatenate a %s value%s", typeName(l), describeExpr(e.l))
+		}
+		if !rok {
+			return nil, in.errorf(e.line, "attempt to concatenate a %s value%s", typeName(r), describeExpr(e.r))
+		}
+		if len(ls)+len(rs) > MaxString {
+			return nil, in.errorf(e.line, "%s", errTooLarge)
+		}
+		return ls + rs, nil
+	}
+	a, ok := toNumber(l)
+	if !ok {
+		return nil, in.errorf(e.line, "attempt to perform arithmetic on a %s value%s", typeName(l), describeExpr(e.l))
+	}
+	b, ok := toNumber(r)
+	if !ok {
+		return nil, in.errorf(e.line, "attempt to perform arithmetic on a %s value%s", typeName(r), describeExpr(e.r))
+	}
+	switch e.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return a - math.Floor(a/b)*b, nil
+	case "^":
+		return math.Pow(a, b), nil
+	}
+	panic("script: unknown operator " + e.op)
+}
+
+func (in *interp) compare(e binExpr, l, r Value) (Value, error) {
+	var less, eq bool
+	switch a := l.(type) {
+	case float64:
+		b, ok := r.(float64)
+		if !ok {
+			return nil, in.errorf(e.line, "attempt to compare number with %s", typeName(r))
+		}
+		less, eq = a < b, a == b
+	case string:
+		b, ok := r.(string)
+		if !ok {
+			return nil, in.errorf(e.line, "attempt to compare string with %s", typeName(r))
+		}
+		less, eq = a < b, a == b
+	default:
+		return nil, in.errorf(e.line, "attempt to compare two %s values", typeName(l))
+	}
+	switch e.op {
+	case "<":
+		return less, nil
+	case "<=":
+		return less || eq, nil
+	case ">":
+		return !less && !eq, nil
+	}
+	return !less, nil
+}
+
+// describeExpr names the variable or field an error is about.
+func describeExpr(e expr) string {
+	switch e := e.(type) {
+	case nameExpr:
+		return fmt.Sprintf(" (variable '%s')", e.name)
+	case indexExpr:
+		if key, ok := e.key.(constExpr); ok {
+			if s, ok := key.value.(string); ok {
+				return fmt.Sprintf(" (field '%s')", s)
+			}
+		}
+	}
+	return ""
+}
+
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+func equal(a, b Value) bool {
+	return a == b
+}
+
+// toNumber converts numbers and numeric strings, as arithmetic does.
+func toNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+			n, err := strconv.ParseUint(s[2:], 16, 64)
+			return float64(n), err == nil
+		}
+		// ParseFloat also accepts "inf", "nan" and underscores, which are
+		// not numbers here.
+		if s == "" || strings.Trim(s, "0123456789.+-eE") != "" {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toConcat(v Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return formatNumber(v), true
+	}
+	return "", false
+}
+
+// formatNumber prints integers without a fraction.
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	if math.IsInf(n, 1) {
+		return "inf"
+	}
+	if math.IsInf(n, -1) {
+		return "-inf"
+	}
+	if math.IsNaN(n) {
+		return "nan"
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// ToString converts v as tostring does.
+func ToString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case *Table:
+		return fmt.Sprintf("table: %p", v)
+	case *Function:
+		if v.gofn != nil {
+			return fmt.Sprintf("builtin: %s", v.name)
+		}
+		return fmt.Sprintf("function: %p", v)
+	}
+	return fmt.Sprint(v)
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function:
+		return "function"
+	}
+	return "userdata"
+}
@@ -0,0 +1,220 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokString
+	tokKeyword
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // name, keyword, operator or string contents
+	num  float64
+	line int
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+	"nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+	"true": true, "until": true, "while": true,
+}
+
+// operators are matched longest first.
+var operators = []string{
+	"...", "..", "==", "~=", "<=", ">=",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+// lex splits source into tokens.
+func lex(name, source string) ([]token, error) {
+	var tokens []token
+	line := 1
+	i := 0
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, args...))
+	}
+	for i < len(source) {
+		c := source[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(source[i:], "--"):
+			i += 2
+			if level, ok := longBracket(source[i:]); ok {
+				end := strings.Index(source[i:], "]"+strings.Repeat("=", level)+"]")
+				if end < 0 {
+					return nil, fail("unfinished long comment")
+				}
+				line += strings.Count(source[i:i+end], "\n")
+				i += end + level + 2
+				continue
+			}
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case isLetter(c):
+			start := i
+			for i < len(source) && (isLetter(source[i]) || isDigit(source[i])) {
+				i++
+			}
+			word := source[start:i]
+			kind := tokName
+			if keywords[word] {
+				kind = tokKeyword
+			}
+			tokens = append(tokens, token{kind: kind, text: word, line: line})
+		case isDigit(c) || (c == '.' && i+1 < len(source) && isDigit(source[i+1])):
+			start := i
+			if strings.HasPrefix(source[i:], "0x") || strings.HasPrefix(source[i:], "0X") {
+				i += 2
+				for i < len(source) && strings.ContainsRune("0123456789abcdefABCDEF", rune(source[i])) {
+					i++
+				}
+				n, err := strconv.ParseUint(source[start+2:i], 16, 64)
+				if err != nil {
+					return nil, fail("malformed number near %q", source[start:i])
+				}
+				tokens = append(tokens, token{kind: tokNumber, num: float64(n), line: line})
+				continue
+			}
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				i++
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+			n, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fail("malformed number near %q", source[start:i])
+			}
+			tokens = append(tokens, token{kind: tokNumber, num: n, line: line})
+		case c == '"' || c == '\'':
+			s, n, err := quotedString(source[i:])
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, line: line})
+			i += n
+		case c == '[':
+			if level, ok := longBracket(source[i:]); ok {
+				open := level + 2
+				end := strings.Index(source[i+open:], "]"+strings.Repeat("=", level)+"]")
+				if end < 0 {
+					return nil, fail("unfinished long string")
+				}
+				s := source[i+open : i+open+end]
+				// A newline right after the opening bracket is skipped.
+				s = strings.TrimPrefix(strings.TrimPrefix(s, "\r"), "\n")
+				tokens = append(tokens, token{kind: tokString, text: s, line: line})
+				line += strings.Count(source[i:i+open+end], "\n")
+				i += open + end + level + 2
+				continue
+			}
+			fallthrough
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, line: line})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fail("unexpected character %q", c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, line: line}), nil
+}
+
+// longBracket reports whether s starts with [[, [=[, [==[ and so on, and
+// its level.
+func longBracket(s string) (int, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return 0, false
+	}
+	level := 0
+	for level+1 < len(s) && s[level+1] == '=' {
+		level++
+	}
+	return level, level+1 < len(s) && s[level+1] == '['
+}
+
+// quotedString reads the string literal at the start of s and returns its
+// value and length.
+func quotedString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("unfinished string")
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			case '\n':
+				b.WriteByte('\n')
+			default:
+				if !isDigit(e) {
+					return "", 0, fmt.Errorf("invalid escape sequence \\%c", e)
+				}
+				j := i
+				for j < len(s) && j < i+3 && isDigit(s[j]) {
+					j++
+				}
+				n, _ := strconv.Atoi(s[i:j])
+				if n > 255 {
+					return "", 0, fmt.Errorf("escape sequence too large")
+				}
+				b.WriteByte(byte(n))
+				i = j - 1
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unfinished string")
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,996 @@
+package script
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// errTooLarge refuses to build a string longer than MaxString.
+var errTooLarge = fmt.Errorf("resulting string too large (over %d MB)", MaxString>>20)
+
+// argError reports a bad argument to a library function.
+func argError(n int, format string, args ...interface{}) error {
+	return fmt.Errorf("bad argument #%d (%s)", n, fmt.Sprintf(format, args...))
+}
+
+func arg(args []Value, n int) Value {
+	if n < len(args) {
+		return args[n]
+	}
+	return nil
+}
+
+func checkString(args []Value, n int) (string, error) {
+	switch v := arg(args, n).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return formatNumber(v), nil
+	}
+	return "", argError(n+1, "string expected, got %s", typeName(arg(args, n)))
+}
+
+func checkNumber(args []Value, n int) (float64, error) {
+	if f, ok := toNumber(arg(args, n)); ok {
+		return f, nil
+	}
+	return 0, argError(n+1, "number expected, got %s", typeName(arg(args, n)))
+}
+
+func checkTable(args []Value, n int) (*Table, error) {
+	if t, ok := arg(args, n).(*Table); ok {
+		return t, nil
+	}
+	return nil, argError(n+1, "table expected, got %s", typeName(arg(args, n)))
+}
+
+// optNumber returns argument n, or def when it is nil.
+func optNumber(args []Value, n int, def float64) (float64, error) {
+	if arg(args, n) == nil {
+		return def, nil
+	}
+	return checkNumber(args, n)
+}
+
+func one(v Value) []Value { return []Value{v} }
+
+// setFuncs adds Go functions to t.
+func setFuncs(t *Table, prefix string, funcs map[string]func([]Value) ([]Value, error)) {
+	for name, fn := range funcs {
+		t.Set(name, Func(prefix+name, fn))
+	}
+}
+
+// openLibs installs the built-in functions and libraries in in.globals.
+// print writes to out.
+func (in *interp) openLibs(out io.Writer) {
+	g := in.globals
+	setFuncs(g, "", map[string]func([]Value) ([]Value, error){
+		"print": func(args []Value) ([]Value, error) {
+			parts := make([]string, len(args))
+			for i, v := range args {
+				parts[i] = ToString(v)
+			}
+			_, err := fmt.Fprintln(out, strings.Join(parts, "\t"))
+			return nil, err
+		},
+		"tostring": func(args []Value) ([]Value, error) {
+			return one(ToString(arg(args, 0))), nil
+		},
+		"tonumber": func(args []Value) ([]Value, error) {
+			if n, ok := toNumber(arg(args, 0)); ok {
+				return one(n), nil
+			}
+			return one(nil), nil
+		},
+		"type": func(args []Value) ([]Value, error) {
+			if len(args) == 0 {
+				return nil, argError(1, "value expected")
+			}
+			return one(typeName(args[0])), nil
+		},
+		"error": func(args []Value) ([]Value, error) {
+			return nil, &Error{Value: arg(args, 0)}
+		},
+		"assert": func(args []Value) ([]Value, error) {
+			if truthy(arg(args, 0)) {
+				return args, nil
+			}
+			if len(args) > 1 {
+				return nil, &Error{Value: args[1]}
+			}
+			return nil, errors.New("assertion failed!")
+		},
+		"pcall": func(args []Value) ([]Value, error) {
+			results, err := in.call(arg(args, 0), args[min(1, len(args)):], 0)
+			if err != nil {
+				var se *Error
+				if !errors.As(err, &se) {
+					return nil, err
+				}
+				if in.ctx.Err() != nil || in.outOfMemory {
+					// Cancellation and running out of memory are not
+					// something a script can catch.
+					return nil, err
+				}
+				return []Value{false, se.Value}, nil
+			}
+			return append([]Value{true}, results...), nil
+		},
+		"ipairs": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			next := Func("ipairs iterator", func(args []Value) ([]Value, error) {
+				i, _ := arg(args, 1).(float64)
+				v := t.Get(i + 1)
+				if v == nil {
+					return one(nil), nil
+				}
+				return []Value{i + 1, v}, nil
+			})
+			return []Value{next, t, 0.0}, nil
+		},
+		"pairs": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			keys := t.Keys()
+			i := 0
+			next := Func("pairs iterator", func([]Value) ([]Value, error) {
+				for i < len(keys) {
+					k := keys[i]
+					i++
+					if v := t.Get(k); v != nil {
+						return []Value{k, v}, nil
+					}
+				}
+				return one(nil), nil
+			})
+			return []Value{next, t, nil}, nil
+		},
+		"next": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return in.next(t, arg(args, 1))
+		},
+		"select": func(args []Value) ([]Value, error) {
+			if s, ok := arg(args, 0).(string); ok && s == "#" {
+				return one(float64(len(args) - 1)), nil
+			}
+			n, err := checkNumber(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			if n < 1 {
+				return nil, argError(1, "index out of range")
+			}
+			if int(n) >= len(args) {
+				return nil, nil
+			}
+			return args[int(n):], nil
+		},
+	})
+
+	in.strings = NewTable()
+	g.Set("string", in.strings)
+	setFuncs(in.strings, "string.", in.stringLib())
+
+	table := NewTable()
+	g.Set("table", table)
+	setFuncs(table, "table.", in.tableLib())
+
+	m := NewTable()
+	g.Set("math", m)
+	setFuncs(m, "math.", mathLib)
+	m.Set("huge", math.Inf(1))
+	m.Set("pi", math.Pi)
+
+	j := NewTable()
+	g.Set("json", j)
+	setFuncs(j, "json.", jsonLib)
+
+	re := NewTable()
+	g.Set("re", re)
+	setFuncs(re, "re.", reLib)
+}
+
+func (in *interp) stringLib() map[string]func([]Value) ([]Value, error) {
+	return map[string]func([]Value) ([]Value, error){
+		"len": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			return one(float64(len(s))), err
+		},
+		"upper": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			return one(strings.ToUpper(s)), err
+		},
+		"lower": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			return one(strings.ToLower(s)), err
+		},
+		"trim": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			return one(strings.TrimSpace(s)), err
+		},
+		"rep": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			n, err := checkNumber(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			sep := ""
+			if arg(args, 2) != nil {
+				if sep, err = checkString(args, 2); err != nil {
+					return nil, err
+				}
+			}
+			if n < 1 {
+				return one(""), nil
+			}
+			if float64(len(s))*n+float64(len(sep))*(n-1) > MaxString {
+				return nil, errTooLarge
+			}
+			if sep == "" {
+				return one(strings.Repeat(s, int(n))), nil
+			}
+			return one(strings.Repeat(s+sep, int(n)-1) + s), nil
+		},
+		"sub": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			i, err := optNumber(args, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			j, err := optNumber(args, 2, -1)
+			if err != nil {
+				return nil, err
+			}
+			start, end := strIndex(int(i), len(s)), strIndex(int(j), len(s))
+			if start < 1 {
+				start = 1
+			}
+			if end > len(s) {
+				end = len(s)
+			}
+			if start > end {
+				return one(""), nil
+			}
+			return one(s[start-1 : end]), nil
+		},
+		"find": func(args []Value) ([]Value, error) {
+			return in.strFind(args, true)
+		},
+		"match": func(args []Value) ([]Value, error) {
+			return in.strFind(args, false)
+		},
+		"gmatch": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			pat, err := checkString(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			ms := &matchState{ctx: in.ctx, src: s, pat: pat}
+			pos := 0
+			return one(Func("gmatch iterator", func([]Value) ([]Value, error) {
+				for ; pos <= len(s); pos++ {
+					ms.level = 0
+					e, err := ms.match(pos, 0)
+					if err != nil {
+						return nil, err
+					}
+					if e == -1 {
+						continue
+					}
+					start := pos
+					pos = e
+					if e == start {
+						// An empty match moves on by one character.
+						pos++
+					}
+					return ms.captureValues(start, e, true)
+				}
+				return one(nil), nil
+			})), nil
+		},
+		"gsub": func(args []Value) ([]Value, error) {
+			return in.strGsub(args)
+		},
+		"split": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			t := NewTable()
+			if arg(args, 1) == nil {
+				for _, field := range strings.Fields(s) {
+					t.Append(field)
+				}
+				return one(t), nil
+			}
+			sep, err := checkString(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			for _, part := range strings.Split(s, sep) {
+				t.Append(part)
+			}
+			return one(t), nil
+		},
+		"startswith": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			prefix, err := checkString(args, 1)
+			return one(strings.HasPrefix(s, prefix)), err
+		},
+		"endswith": func(args []Value) ([]Value, error) {
+			s, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			suffix, err := checkString(args, 1)
+			return one(strings.HasSuffix(s, suffix)), err
+		},
+		"format": func(args []Value) ([]Value, error) {
+			f, err := checkString(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			return stringFormat(f, args[1:])
+		},
+	}
+}
+
+// strFind implements string.find, which returns the positions of the
+// match and its captures, and string.match, which returns the captures or
+// the whole match. find searches plain text when its fourth argument is
+// true or the pattern has no special characters.
+func (in *interp) strFind(args []Value, find bool) ([]Value, error) {
+	s, err := checkString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := checkString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	init, err := optNumber(args, 2, 1)
+	if err != nil {
+		return nil, err
+	}
+	start := strIndex(int(init), len(s))
+	if start < 1 {
+		start = 1
+	}
+	if start > len(s)+1 {
+		return one(nil), nil
+	}
+	if find && (truthy(arg(args, 3)) || !strings.ContainsAny(pat, patternSpecials)) {
+		i := strings.Index(s[start-1:], pat)
+		if i < 0 {
+			return one(nil), nil
+		}
+		first := start + i
+		return []Value{float64(first), float64(first + len(pat) - 1)}, nil
+	}
+	anchor := strings.HasPrefix(pat, "^")
+	if anchor {
+		pat = pat[1:]
+	}
+	ms := &matchState{ctx: in.ctx, src: s, pat: pat}
+	for pos := start - 1; pos <= len(s); pos++ {
+		ms.level = 0
+		e, err := ms.match(pos, 0)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			if !find {
+				return ms.captureValues(pos, e, true)
+			}
+			captures, err := ms.captureValues(pos, e, false)
+			return append([]Value{float64(pos + 1), float64(e)}, captures...), err
+		}
+		if anchor {
+			break
+		}
+	}
+	return one(nil), nil
+}
+
+// strGsub implements string.gsub(s, pattern, repl [, n]): repl is a string
+// with %0 to %9 standing for the captures, a table looked up with the
+// first capture, or a function called with the captures. A nil or false
+// lookup or result keeps the match.
+func (in *interp) strGsub(args []Value) ([]Value, error) {
+	s, err := checkString(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	pat, err := checkString(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case string, float64, *Table, *Function:
+	default:
+		return nil, argError(3, "string/function/table expected, got %s", typeName(repl))
+	}
+	max, err := optNumber(args, 3, float64(len(s)+1))
+	if err != nil {
+		return nil, err
+	}
+	anchor := strings.HasPrefix(pat, "^")
+	if anchor {
+		pat = pat[1:]
+	}
+	ms := &matchState{ctx: in.ctx, src: s, pat: pat}
+	var b strings.Builder
+	pos, n := 0, 0
+	for float64(n) < max {
+		ms.level = 0
+		e, err := ms.match(pos, 0)
+		if err != nil {
+			return nil, err
+		}
+		if e != -1 {
+			n++
+			if err := in.gsubValue(&b, ms, pos, e, repl); err != nil {
+				return nil, err
+			}
+			if b.Len() > MaxString {
+				return nil, errTooLarge
+			}
+		}
+		if e != -1 && e > pos {
+			pos = e
+		} else if pos < len(s) {
+			b.WriteByte(s[pos])
+			pos++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	if b.Len()+len(s)-pos > MaxString {
+		return nil, errTooLarge
+	}
+	b.WriteString(s[pos:])
+	return []Value{b.String(), float64(n)}, nil
+}
+
+// gsubValue writes the replacement of the match src[s:e] to b.
+func (in *interp) gsubValue(b *strings.Builder, ms *matchState, s, e int, repl Value) error {
+	var value Value
+	switch r := repl.(type) {
+	case *Table:
+		first, err := ms.captureValue(0, s, e)
+		if err != nil {
+			return err
+		}
+		value = r.Get(first)
+	case *Function:
+		captures, err := ms.captureValues(s, e, true)
+		if err != nil {
+			return err
+		}
+		results, err := in.call(r, captures, 0)
+		if err != nil {
+			return err
+		}
+		if len(results) > 0 {
+			value = results[0]
+		}
+	default:
+		text, _ := toConcat(r)
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c != '%' || i+1 == len(text) {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			switch c = text[i]; {
+			case c == '0':
+				b.WriteString(ms.src[s:e])
+			case '1' <= c && c <= '9':
+				v, err := ms.captureValue(int(c-'1'), s, e)
+				if err != nil {
+					return err
+				}
+				b.WriteString(ToString(v))
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil
+	}
+	if !truthy(value) {
+		b.WriteString(ms.src[s:e])
+		return nil
+	}
+	text, ok := toConcat(value)
+	if !ok {
+		return fmt.Errorf("invalid replacement value (a %s)", typeName(value))
+	}
+	b.WriteString(text)
+	return nil
+}
+
+// strIndex converts a 1-based, possibly negative string position.
+func strIndex(i, n int) int {
+	if i < 0 {
+		return n + i + 1
+	}
+	return i
+}
+
+// stringFormat implements string.format with Go's verbs for %d, %i, %f,
+// %g, %e, %x, %X, %o, %c, %s and %q.
+func stringFormat(format string, args []Value) ([]Value, error) {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0123456789.", format[j]) >= 0 {
+			j++
+		}
+		if j >= len(format) {
+			return nil, errors.New("invalid conversion at end of format")
+		}
+		if b.Len() > MaxString {
+			return nil, errTooLarge
+		}
+		spec, verb := format[i+1:j], format[j]
+		i = j
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		n++
+		switch verb {
+		case 'd', 'i', 'x', 'X', 'o', 'c':
+			num, err := checkNumber(args, n-1)
+			if err != nil {
+				return nil, argError(n+1, "number expected, got %s", typeName(arg(args, n-1)))
+			}
+			if num != math.Trunc(num) {
+				return nil, argError(n+1, "number has no integer representation")
+			}
+			if verb == 'i' {
+				verb = 'd'
+			}
+			fmt.Fprintf(&b, "%"+spec+string(verb), int64(num))
+		case 'f', 'F', 'g', 'G', 'e', 'E':
+			num, err := checkNumber(args, n-1)
+			if err != nil {
+				return nil, argError(n+1, "number expected, got %s", typeName(arg(args, n-1)))
+			}
+			fmt.Fprintf(&b, "%"+spec+string(verb), num)
+		case 's':
+			fmt.Fprintf(&b, "%"+spec+"s", ToString(arg(args, n-1)))
+		case 'q':
+			fmt.Fprintf(&b, "%q", ToString(arg(args, n-1)))
+		default:
+			return nil, fmt.Errorf("invalid conversion '%%%s%c' to 'format'", spec, verb)
+		}
+	}
+	if b.Len() > MaxString {
+		return nil, errTooLarge
+	}
+	return one(b.String()), nil
+}
+
+// next returns the key after key in t, in the order pairs uses, and its
+// value; nil after the last key. The keys of the last table traversed are
+// kept so that a loop over next does not sort them on every call.
+func (in *interp) next(t *Table, key Value) ([]Value, error) {
+	if in.nextTable != t || key == nil {
+		in.nextTable, in.nextKeys, in.nextPos = t, t.Keys(), 0
+	}
+	i := 0
+	if key != nil {
+		i = in.nextPos
+		if i >= len(in.nextKeys) || in.nextKeys[i] != key {
+			for i = 0; i < len(in.nextKeys) && in.nextKeys[i] != key; i++ {
+			}
+			if i == len(in.nextKeys) {
+				return nil, errors.New("invalid key to 'next'")
+			}
+		}
+		i++
+	}
+	for ; i < len(in.nextKeys); i++ {
+		// A key set to nil during the traversal is skipped.
+		if v := t.Get(in.nextKeys[i]); v != nil {
+			in.nextPos = i
+			return []Value{in.nextKeys[i], v}, nil
+		}
+	}
+	in.nextTable, in.nextKeys = nil, nil
+	return one(nil), nil
+}
+
+func (in *interp) tableLib() map[string]func([]Value) ([]Value, error) {
+	return map[string]func([]Value) ([]Value, error){
+		"insert": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			switch len(args) {
+			case 2:
+				t.Append(args[1])
+			case 3:
+				pos, err := checkNumber(args, 1)
+				if err != nil {
+					return nil, err
+				}
+				i := int(pos)
+				if i < 1 || i > len(t.arr)+1 {
+					return nil, argError(2, "position out of bounds")
+				}
+				t.arr = append(t.arr, nil)
+				copy(t.arr[i:], t.arr[i-1:])
+				t.arr[i-1] = args[2]
+				if args[2] == nil {
+					t.setIndex(i, nil)
+				}
+			default:
+				return nil, errors.New("wrong number of arguments to 'insert'")
+			}
+			return nil, nil
+		},
+		"remove": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(t.arr) == 0 {
+				return one(nil), nil
+			}
+			pos, err := optNumber(args, 1, float64(len(t.arr)))
+			if err != nil {
+				return nil, err
+			}
+			i := int(pos)
+			if i < 1 || i > len(t.arr) {
+				return nil, argError(2, "position out of bounds")
+			}
+			v := t.arr[i-1]
+			t.arr = append(t.arr[:i-1], t.arr[i:]...)
+			return one(v), nil
+		},
+		"concat": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			sep := ""
+			if arg(args, 1) != nil {
+				if sep, err = checkString(args, 1); err != nil {
+					return nil, err
+				}
+			}
+			parts := make([]string, len(t.arr))
+			size := len(sep) * len(t.arr)
+			for i, v := range t.arr {
+				s, ok := toConcat(v)
+				if !ok {
+					return nil, fmt.Errorf("invalid value (at index %d) in table for 'concat'", i+1)
+				}
+				parts[i] = s
+				if size += len(s); size > MaxString {
+					return nil, errTooLarge
+				}
+			}
+			return one(strings.Join(parts, sep)), nil
+		},
+		"sort": func(args []Value) ([]Value, error) {
+			t, err := checkTable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			less := arg(args, 1)
+			var sortErr error
+			sort.SliceStable(t.arr, func(i, j int) bool {
+				if sortErr != nil {
+					return false
+				}
+				a, b := t.arr[i], t.arr[j]
+				if less != nil {
+					results, err := in.call(less, []Value{a, b}, 0)
+					if err != nil {
+						sortErr = err
+						return false
+					}
+					return len(results) > 0 && truthy(results[0])
+				}
+				switch a := a.(type) {
+				case float64:
+					if b, ok := b.(float64); ok {
+						return a < b
+					}
+				case string:
+					if b, ok := b.(string); ok {
+						return a < b
+					}
+				}
+				sortErr = fmt.Errorf("attempt to compare %s with %s", typeName(a), typeName(b))
+				return false
+			})
+			return nil, sortErr
+		},
+	}
+}
+
+var mathLib = map[string]func([]Value) ([]Value, error){
+	"floor": mathFunc(math.Floor),
+	"ceil":  mathFunc(math.Ceil),
+	"abs":   mathFunc(math.Abs),
+	"sqrt":  mathFunc(math.Sqrt),
+	"max": func(args []Value) ([]Value, error) {
+		return minMax(args, func(a, b float64) bool { return a > b })
+	},
+	"min": func(args []Value) ([]Value, error) {
+		return minMax(args, func(a, b float64) bool { return a < b })
+	},
+}
+
+func mathFunc(f func(float64) float64) func([]Value) ([]Value, error) {
+	return func(args []Value) ([]Value, error) {
+		n, err := checkNumber(args, 0)
+		return one(f(n)), err
+	}
+}
+
+func minMax(args []Value, better func(a, b float64) bool) ([]Value, error) {
+	best, err := checkNumber(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		n, err := checkNumber(args, i)
+		if err != nil {
+			return nil, err
+		}
+		if better(n, best) {
+			best = n
+		}
+	}
+	return one(best), nil
+}
+
+var jsonLib = map[string]func([]Value) ([]Value, error){
+	"decode": func(args []Value) ([]Value, error) {
+		s, err := checkString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return one(FromGo(v)), nil
+	},
+	"encode": func(args []Value) ([]Value, error) {
+		v, err := ToGo(arg(args, 0))
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxString {
+			return nil, errTooLarge
+		}
+		return one(string(data)), nil
+	},
+}
+
+var reLib = map[string]func([]Value) ([]Value, error){
+	// re.find(s, pattern) returns the first match, or its groups when the
+	// pattern has any; nil when there is no match.
+	"find": func(args []Value) ([]Value, error) {
+		s, re, err := reArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		m := re.FindStringSubmatch(s)
+		if m == nil {
+			return one(nil), nil
+		}
+		if len(m) == 1 {
+			return one(m[0]), nil
+		}
+		groups := make([]Value, len(m)-1)
+		for i, g := range m[1:] {
+			groups[i] = g
+		}
+		return groups, nil
+	},
+	// re.findall(s, pattern) returns a table of all matches.
+	"findall": func(args []Value) ([]Value, error) {
+		s, re, err := reArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		t := NewTable()
+		for _, m := range re.FindAllString(s, -1) {
+			t.Append(m)
+		}
+		return one(t), nil
+	},
+	// re.gsub(s, pattern, replacement) replaces every match, expanding $1
+	// and ${name} in replacement.
+	"gsub": func(args []Value) ([]Value, error) {
+		s, re, err := reArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		repl, err := checkString(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if len(repl) > 0 && len(s)+(len(s)+1)*len(repl) > MaxString {
+			// Count the matches, up to as many as could fit, before the
+			// replacement is built.
+			if n := len(re.FindAllStringIndex(s, MaxString/len(repl)+1)); len(s)+n*len(repl) > MaxString {
+				return nil, errTooLarge
+			}
+		}
+		return one(re.ReplaceAllString(s, repl)), nil
+	},
+	"split": func(args []Value) ([]Value, error) {
+		s, re, err := reArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		t := NewTable()
+		for _, part := range re.Split(s, -1) {
+			t.Append(part)
+		}
+		return one(t), nil
+	},
+}
+
+func reArgs(args []Value) (string, *regexp.Regexp, error) {
+	s, err := checkString(args, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	pattern, err := checkString(args, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	return s, re, nil
+}
+
+// FromGo converts JSON-like Go values, and string maps, to script values.
+func FromGo(v interface{}) Value {
+	switch v := v.(type) {
+	case nil, bool, float64, string, *Table, *Function:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case []interface{}:
+		t := NewTable()
+		for _, item := range v {
+			t.Append(FromGo(item))
+		}
+		return t
+	case []string:
+		t := NewTable()
+		for _, item := range v {
+			t.Append(item)
+		}
+		return t
+	case map[string]interface{}:
+		t := NewTable()
+		for key, item := range v {
+			t.Set(key, FromGo(item))
+		}
+		return t
+	case map[string]string:
+		t := NewTable()
+		for key, item := range v {
+			t.Set(key, item)
+		}
+		return t
+	case map[string]map[string]string:
+		t := NewTable()
+		for key, item := range v {
+			t.Set(key, FromGo(item))
+		}
+		return t
+	}
+	panic(fmt.Sprintf("script: cannot convert %T", v))
+}
+
+// ToGo converts a script value to a JSON-like Go value. A table with only a
+// sequence becomes a slice, and an empty table an empty object.
+func ToGo(v Value) (interface{}, error) {
+	return toGo(v, 0)
+}
+
+func toGo(v Value, depth int) (interface{}, error) {
+	if depth > 100 {
+		return nil, errors.New("table nested too deeply (cycle?)")
+	}
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v, nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("cannot encode %s", formatNumber(v))
+		}
+		return v, nil
+	case *Table:
+		if len(v.hash) == 0 && len(v.arr) > 0 {
+			list := make([]interface{}, len(v.arr))
+			for i, item := range v.arr {
+				value, err := toGo(item, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = value
+			}
+			return list, nil
+		}
+		obj := make(map[string]interface{}, len(v.arr)+len(v.hash))
+		for _, key := range v.Keys() {
+			var name string
+			switch k := key.(type) {
+			case string:
+				name = k
+			case float64:
+				name = formatNumber(k)
+			default:
+				return nil, fmt.Errorf("cannot encode a table key of type %s", typeName(key))
+			}
+			value, err := toGo(v.Get(key), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj[name] = value
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("cannot encode a %s value", typeName(v))
+}
@@ -0,0 +1,536 @@
+package script
+
+import (
+	"fmt"
+)
+
+type (
+	expr interface{}
+	stmt interface{}
+)
+
+type block struct {
+	stmts []stmt
+}
+
+// Expressions.
+type (
+	constExpr struct{ value Value }
+	nameExpr  struct {
+		name string
+		line int
+	}
+	indexExpr struct {
+		obj, key expr
+		line     int
+	}
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+	methodExpr struct {
+		obj  expr
+		name string
+		args []expr
+		line int
+	}
+	funcExpr struct {
+		name   string
+		params []string
+		body   *block
+	}
+	binExpr struct {
+		op   string
+		l, r expr
+		line int
+	}
+	unExpr struct {
+		op   string
+		x    expr
+		line int
+	}
+	tableExpr struct {
+		keys   []expr // nil for positional items
+		values []expr
+		line   int
+	}
+	parenExpr struct{ x expr }
+)
+
+// Statements.
+type (
+	localStmt struct {
+		names []string
+		exprs []expr
+	}
+	localFuncStmt struct {
+		name string
+		fn   *funcExpr
+	}
+	assignStmt struct {
+		targets []expr
+		exprs   []expr
+	}
+	callStmt  struct{ call expr }
+	doStmt    struct{ body *block }
+	whileStmt struct {
+		cond expr
+		body *block
+	}
+	repeatStmt struct {
+		body *block
+		cond expr
+	}
+	ifStmt struct {
+		conds  []expr
+		blocks []*block
+		els    *block
+	}
+	numForStmt struct {
+		name              string
+		start, stop, step expr
+		body              *block
+		line              int
+	}
+	genForStmt struct {
+		names []string
+		exprs []expr
+		body  *block
+		line  int
+	}
+	returnStmt struct{ exprs []expr }
+	breakStmt  struct{}
+)
+
+type parser struct {
+	name   string
+	tokens []token
+	pos    int
+}
+
+// parse parses a chunk of source.
+func parse(name, source string) (*block, error) {
+	tokens, err := lex(name, source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{name: name, tokens: tokens}
+	var body *block
+	err = p.catch(func() {
+		body = p.block()
+		if p.peek().kind != tokEOF {
+			p.fail("'<eof>' expected near %s", p.describe(p.peek()))
+		}
+	})
+	return body, err
+}
+
+// syntaxError carries a parse error up through panic, recovered by catch.
+type syntaxError struct{ err error }
+
+func (p *parser) catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se.err
+		}
+	}()
+	f()
+	return nil
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(syntaxError{fmt.Errorf("%s:%d: %s", p.name, p.peek().line, fmt.Sprintf(format, args...))})
+}
+
+func (p *parser) describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "<eof>"
+	case tokString:
+		return fmt.Sprintf("%q", t.text)
+	case tokNumber:
+		return formatNumber(t.num)
+	}
+	return "'" + t.text + "'"
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the keyword or operator text.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokKeyword || t.kind == tokOp) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) {
+	if !p.accept(text) {
+		p.fail("'%s' expected near %s", text, p.describe(p.peek()))
+	}
+}
+
+func (p *parser) ident() string {
+	t := p.peek()
+	if t.kind != tokName {
+		p.fail("name expected near %s", p.describe(t))
+	}
+	p.pos++
+	return t.text
+}
+
+// blockEnd reports whether the next token ends a block.
+func (p *parser) blockEnd() bool {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return true
+	}
+	if t.kind != tokKeyword {
+		return false
+	}
+	switch t.text {
+	case "end", "else", "elseif", "until":
+		return true
+	}
+	return false
+}
+
+func (p *parser) block() *block {
+	b := &block{}
+	for !p.blockEnd() {
+		if p.accept(";") {
+			continue
+		}
+		if p.is("return") {
+			p.next()
+			ret := returnStmt{}
+			if !p.blockEnd() && !p.is(";") {
+				ret.exprs = p.exprList()
+			}
+			p.accept(";")
+			b.stmts = append(b.stmts, ret)
+			if !p.blockEnd() {
+				p.fail("'end' expected near %s", p.describe(p.peek()))
+			}
+			break
+		}
+		b.stmts = append(b.stmts, p.statement())
+	}
+	return b
+}
+
+func (p *parser) statement() stmt {
+	line := p.peek().line
+	switch {
+	case p.accept("break"):
+		return breakStmt{}
+	case p.accept("do"):
+		body := p.block()
+		p.expect("end")
+		return doStmt{body}
+	case p.accept("while"):
+		cond := p.expr()
+		p.expect("do")
+		body := p.block()
+		p.expect("end")
+		return whileStmt{cond, body}
+	case p.accept("repeat"):
+		body := p.block()
+		p.expect("until")
+		return repeatStmt{body, p.expr()}
+	case p.accept("if"):
+		s := ifStmt{}
+		for {
+			s.conds = append(s.conds, p.expr())
+			p.expect("then")
+			s.blocks = append(s.blocks, p.block())
+			if !p.accept("elseif") {
+				break
+			}
+		}
+		if p.accept("else") {
+			s.els = p.block()
+		}
+		p.expect("end")
+		return s
+	case p.accept("for"):
+		first := p.ident()
+		if p.accept("=") {
+			s := numForStmt{name: first, line: line}
+			s.start = p.expr()
+			p.expect(",")
+			s.stop = p.expr()
+			if p.accept(",") {
+				s.step = p.expr()
+			}
+			p.expect("do")
+			s.body = p.block()
+			p.expect("end")
+			return s
+		}
+		s := genForStmt{names: []string{first}, line: line}
+		for p.accept(",") {
+			s.names = append(s.names, p.ident())
+		}
+		p.expect("in")
+		s.exprs = p.exprList()
+		p.expect("do")
+		s.body = p.block()
+		p.expect("end")
+		return s
+	case p.accept("function"):
+		// function a.b.c:m() assigns to a.b.c.m, with self for methods.
+		name := p.ident()
+		var target expr = nameExpr{name, line}
+		full := name
+		method := false
+		for p.is(".") || p.is(":") {
+			method = p.is(":")
+			p.next()
+			key := p.ident()
+			full += "." + key
+			target = indexExpr{target, constExpr{key}, line}
+			if method {
+				break
+			}
+		}
+		fn := p.funcBody(full, method)
+		return assignStmt{[]expr{target}, []expr{fn}}
+	case p.accept("local"):
+		if p.accept("function") {
+			name := p.ident()
+			return localFuncStmt{name, p.funcBody(name, false)}
+		}
+		s := localStmt{names: []string{p.ident()}}
+		for p.accept(",") {
+			s.names = append(s.names, p.ident())
+		}
+		if p.accept("=") {
+			s.exprs = p.exprList()
+		}
+		return s
+	}
+
+	first := p.suffixedExpr()
+	if p.is("=") || p.is(",") {
+		targets := []expr{first}
+		for p.accept(",") {
+			targets = append(targets, p.suffixedExpr())
+		}
+		p.expect("=")
+		for _, t := range targets {
+			switch t.(type) {
+			case nameExpr, indexExpr:
+			default:
+				p.fail("cannot assign to this expression")
+			}
+		}
+		return assignStmt{targets, p.exprList()}
+	}
+	switch first.(type) {
+	case callExpr, methodExpr:
+		return callStmt{first}
+	}
+	p.fail("syntax error near %s", p.describe(p.peek()))
+	return nil
+}
+
+func (p *parser) funcBody(name string, method bool) *funcExpr {
+	fn := &funcExpr{name: name}
+	if method {
+		fn.params = append(fn.params, "self")
+	}
+	p.expect("(")
+	if !p.is(")") {
+		for {
+			if p.is("...") {
+				p.fail("varargs are not supported")
+			}
+			fn.params = append(fn.params, p.ident())
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	p.expect(")")
+	fn.body = p.block()
+	p.expect("end")
+	return fn
+}
+
+func (p *parser) exprList() []expr {
+	list := []expr{p.expr()}
+	for p.accept(",") {
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+// Binary operator precedences, lowest first; .. and ^ are right
+// associative.
+var precedence = map[string]int{
+	"or": 1, "and": 2,
+	"<": 3, ">": 3, "<=": 3, ">=": 3, "~=": 3, "==": 3,
+	"..": 4,
+	"+":  5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+	"^": 8,
+}
+
+const unaryPrecedence = 7
+
+func (p *parser) expr() expr {
+	return p.binary(0)
+}
+
+func (p *parser) binary(limit int) expr {
+	var left expr
+	if t := p.peek(); (t.kind == tokKeyword && t.text == "not") || (t.kind == tokOp && (t.text == "-" || t.text == "#")) {
+		p.next()
+		left = unExpr{t.text, p.binary(unaryPrecedence), t.line}
+	} else {
+		left = p.simpleExpr()
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if !ok || (t.kind != tokOp && t.kind != tokKeyword) || prec <= limit {
+			return left
+		}
+		p.next()
+		next := prec
+		if t.text == ".." || t.text == "^" {
+			next--
+		}
+		left = binExpr{t.text, left, p.binary(next), t.line}
+	}
+}
+
+func (p *parser) simpleExpr() expr {
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		return constExpr{t.num}
+	case t.kind == tokString:
+		p.next()
+		return constExpr{t.text}
+	case p.accept("nil"):
+		return constExpr{nil}
+	case p.accept("true"):
+		return constExpr{true}
+	case p.accept("false"):
+		return constExpr{false}
+	case p.accept("function"):
+		return p.funcBody("anonymous function", false)
+	case p.is("{"):
+		return p.table()
+	case p.is("..."):
+		p.fail("varargs are not supported")
+	}
+	return p.suffixedExpr()
+}
+
+func (p *parser) primaryExpr() expr {
+	t := p.peek()
+	if t.kind == tokName {
+		p.next()
+		return nameExpr{t.text, t.line}
+	}
+	if p.accept("(") {
+		x := p.expr()
+		p.expect(")")
+		return parenExpr{x}
+	}
+	p.fail("unexpected symbol near %s", p.describe(t))
+	return nil
+}
+
+func (p *parser) suffixedExpr() expr {
+	x := p.primaryExpr()
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			x = indexExpr{x, constExpr{p.ident()}, t.line}
+		case p.accept("["):
+			key := p.expr()
+			p.expect("]")
+			x = indexExpr{x, key, t.line}
+		case p.accept(":"):
+			name := p.ident()
+			x = methodExpr{x, name, p.args(), t.line}
+		case p.is("(") || p.is("{") || t.kind == tokString:
+			x = callExpr{x, p.args(), t.line}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *parser) args() []expr {
+	t := p.peek()
+	switch {
+	case t.kind == tokString:
+		p.next()
+		return []expr{constExpr{t.text}}
+	case p.is("{"):
+		return []expr{p.table()}
+	}
+	p.expect("(")
+	if p.accept(")") {
+		return nil
+	}
+	args := p.exprList()
+	p.expect(")")
+	return args
+}
+
+func (p *parser) table() expr {
+	line := p.peek().line
+	p.expect("{")
+	t := tableExpr{line: line}
+	for !p.is("}") {
+		switch {
+		case p.is("["):
+			p.next()
+			key := p.expr()
+			p.expect("]")
+			p.expect("=")
+			t.keys = append(t.keys, key)
+			t.values = append(t.values, p.expr())
+		case p.peek().kind == tokName && p.tokens[p.pos+1].kind == tokOp && p.tokens[p.pos+1].text == "=":
+			key := p.ident()
+			p.next()
+			t.keys = append(t.keys, constExpr{key})
+			t.values = append(t.values, p.expr())
+		default:
+			t.keys = append(t.keys, nil)
+			t.values = append(t.values, p.expr())
+		}
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	p.expect("}")
+	return t
+}
@@ -0,0 +1,381 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Lua patterns, as string.find, match, gmatch and gsub use them. This is
+// Lua 5.1's matcher: single byte classes, captures, %b, %f and back
+// references, backtracking through a recursive match.
+
+// patternSpecials are the characters that make find search for a pattern
+// rather than plain text.
+const patternSpecials = "^$*+?.([%-"
+
+const (
+	maxCaptures     = 32
+	maxMatchDepth   = 200
+	captureOpen     = -1 // a capture whose ')' has not been matched yet
+	capturePosition = -2 // a () capture, which yields a position
+)
+
+type matchState struct {
+	ctx   context.Context
+	src   string
+	pat   string
+	level int
+	caps  [maxCaptures]struct{ init, len int }
+	depth int
+	steps int
+}
+
+// match matches the pattern from pat[p] against the source from src[s]
+// and returns the end of the match, or -1 when there is none.
+func (ms *matchState) match(s, p int) (int, error) {
+	if ms.depth++; ms.depth > maxMatchDepth {
+		return -1, errors.New("pattern too complex")
+	}
+	defer func() { ms.depth-- }()
+	for {
+		// Backtracking can take long on a large source; let
+		// cancellation interrupt it.
+		if ms.steps++; ms.steps%4096 == 0 && ms.ctx.Err() != nil {
+			return -1, fmt.Errorf("interrupted: %v", ms.ctx.Err())
+		}
+		if p == len(ms.pat) {
+			return s, nil
+		}
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capturePosition)
+			}
+			return ms.startCapture(s, p+1, captureOpen)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s, nil
+				}
+				return -1, nil
+			}
+		case '%':
+			if p+1 == len(ms.pat) {
+				break
+			}
+			switch c := ms.pat[p+1]; {
+			case c == 'b':
+				e, err := ms.matchBalance(s, p+2)
+				if err != nil || e == -1 {
+					return -1, err
+				}
+				s, p = e, p+4
+				continue
+			case c == 'f':
+				p += 2
+				if p == len(ms.pat) || ms.pat[p] != '[' {
+					return -1, errors.New("missing '[' after '%f' in pattern")
+				}
+				ep, err := ms.classEnd(p)
+				if err != nil {
+					return -1, err
+				}
+				var prev, cur byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if matchBracketClass(prev, ms.pat, p, ep-1) || !matchBracketClass(cur, ms.pat, p, ep-1) {
+					return -1, nil
+				}
+				p = ep
+				continue
+			case c >= '0' && c <= '9':
+				e, err := ms.matchCapture(s, int(c-'1'))
+				if err != nil || e == -1 {
+					return -1, err
+				}
+				s, p = e, p+2
+				continue
+			}
+		}
+		ep, err := ms.classEnd(p)
+		if err != nil {
+			return -1, err
+		}
+		m := s < len(ms.src) && singleMatch(ms.src[s], ms.pat, p, ep)
+		if ep < len(ms.pat) {
+			switch ms.pat[ep] {
+			case '?':
+				if m {
+					if e, err := ms.match(s+1, ep+1); err != nil || e != -1 {
+						return e, err
+					}
+				}
+				p = ep + 1
+				continue
+			case '*':
+				return ms.maxExpand(s, p, ep)
+			case '+':
+				if !m {
+					return -1, nil
+				}
+				return ms.maxExpand(s+1, p, ep)
+			case '-':
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		if !m {
+			return -1, nil
+		}
+		s, p = s+1, ep
+	}
+}
+
+// maxExpand matches as many repetitions of the class pat[p:ep] as the rest
+// of the pattern allows.
+func (ms *matchState) maxExpand(s, p, ep int) (int, error) {
+	i := 0
+	for s+i < len(ms.src) && singleMatch(ms.src[s+i], ms.pat, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if e, err := ms.match(s+i, ep+1); err != nil || e != -1 {
+			return e, err
+		}
+	}
+	return -1, nil
+}
+
+// minExpand matches as few repetitions of the class pat[p:ep] as the rest
+// of the pattern allows.
+func (ms *matchState) minExpand(s, p, ep int) (int, error) {
+	for {
+		if e, err := ms.match(s, ep+1); err != nil || e != -1 {
+			return e, err
+		}
+		if s == len(ms.src) || !singleMatch(ms.src[s], ms.pat, p, ep) {
+			return -1, nil
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) (int, error) {
+	if ms.level >= maxCaptures {
+		return -1, errors.New("too many captures")
+	}
+	ms.caps[ms.level].init = s
+	ms.caps[ms.level].len = what
+	ms.level++
+	e, err := ms.match(s, p)
+	if e == -1 {
+		ms.level--
+	}
+	return e, err
+}
+
+func (ms *matchState) endCapture(s, p int) (int, error) {
+	l := ms.level - 1
+	for l >= 0 && ms.caps[l].len != captureOpen {
+		l--
+	}
+	if l < 0 {
+		return -1, errors.New("invalid pattern capture")
+	}
+	ms.caps[l].len = s - ms.caps[l].init
+	e, err := ms.match(s, p)
+	if e == -1 {
+		ms.caps[l].len = captureOpen
+	}
+	return e, err
+}
+
+// matchBalance matches %bxy, whose x and y are at pat[p:p+2].
+func (ms *matchState) matchBalance(s, p int) (int, error) {
+	if p+1 >= len(ms.pat) {
+		return -1, errors.New("missing arguments to '%b'")
+	}
+	if s == len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1, nil
+	}
+	open, close := ms.pat[p], ms.pat[p+1]
+	depth := 1
+	for i := s + 1; i < len(ms.src); i++ {
+		switch ms.src[i] {
+		case close:
+			if depth--; depth == 0 {
+				return i + 1, nil
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1, nil
+}
+
+// matchCapture matches the text of capture l again, for %1 to %9.
+func (ms *matchState) matchCapture(s, l int) (int, error) {
+	if l < 0 || l >= ms.level || ms.caps[l].len < 0 {
+		return -1, fmt.Errorf("invalid capture index %%%d", l+1)
+	}
+	text := ms.src[ms.caps[l].init : ms.caps[l].init+ms.caps[l].len]
+	if !strings.HasPrefix(ms.src[s:], text) {
+		return -1, nil
+	}
+	return s + len(text), nil
+}
+
+// classEnd returns the end of the single character class at pat[p].
+func (ms *matchState) classEnd(p int) (int, error) {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p == len(ms.pat) {
+			return 0, errors.New("malformed pattern (ends with '%')")
+		}
+		return p + 1, nil
+	case '[':
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		// The first character may be a ']' that belongs to the set.
+		for {
+			if p == len(ms.pat) {
+				return 0, errors.New("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' && p < len(ms.pat) {
+				p++
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1, nil
+			}
+		}
+	}
+	return p, nil
+}
+
+// singleMatch reports whether c matches the class pat[p:ep].
+func singleMatch(c byte, pat string, p, ep int) bool {
+	switch pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, pat[p+1])
+	case '[':
+		return matchBracketClass(c, pat, p, ep-1)
+	}
+	return pat[p] == c
+}
+
+// matchBracketClass reports whether c is in the set pat[p:ec+1], which
+// starts with '[' and ends with ']'.
+func matchBracketClass(c byte, pat string, p, ec int) bool {
+	in := true
+	if pat[p+1] == '^' {
+		in = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case pat[p] == '%':
+			p++
+			if matchClass(c, pat[p]) {
+				return in
+			}
+		case pat[p+1] == '-' && p+2 < ec:
+			if pat[p] <= c && c <= pat[p+2] {
+				return in
+			}
+			p += 2
+		case pat[p] == c:
+			return in
+		}
+	}
+	return !in
+}
+
+// matchClass reports whether c is in the class %cl; an upper case class
+// is the complement of its lower case one, and other characters match
+// themselves.
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = '0' <= c && c <= '9'
+	case 'l':
+		res = 'a' <= c && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !isAlpha(c) && !('0' <= c && c <= '9')
+	case 's':
+		res = c == ' ' || '\t' <= c && c <= '\r'
+	case 'u':
+		res = 'A' <= c && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || '0' <= c && c <= '9'
+	case 'x':
+		res = '0' <= c && c <= '9' || 'a' <= c|0x20 && c|0x20 <= 'f'
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if 'A' <= cl && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c|0x20 && c|0x20 <= 'z'
+}
+
+// captureValue returns capture i of a match of src[s:e]: the whole match
+// when the pattern has no captures and i is 0.
+func (ms *matchState) captureValue(i, s, e int) (Value, error) {
+	if i >= ms.level {
+		if i == 0 {
+			return ms.src[s:e], nil
+		}
+		return nil, fmt.Errorf("invalid capture index %%%d", i+1)
+	}
+	switch c := ms.caps[i]; c.len {
+	case captureOpen:
+		return nil, errors.New("unfinished capture")
+	case capturePosition:
+		return float64(c.init + 1), nil
+	default:
+		return ms.src[c.init : c.init+c.len], nil
+	}
+}
+
+// captureValues returns the captures of a match of src[s:e], or the whole
+// match when the pattern has none and whole is set.
+func (ms *matchState) captureValues(s, e int, whole bool) ([]Value, error) {
+	n := ms.level
+	if n == 0 && whole {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := range values {
+		v, err := ms.captureValue(i, s, e)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,52 @@
+// Package script runs the small Lua dialect of script steps: Lua 5.1's
+// syntax and semantics without varargs, metatables, coroutines or goto,
+// and a standard library trimmed to what munging step outputs needs, plus
+// json and re (Go regular expressions) libraries. Scripts reach nothing
+// outside the globals they are given.
+package script
+
+import (
+	"context"
+	"io"
+)
+
+// MaxString is the longest string a script may build, in bytes.
+const MaxString = 1 << 26
+
+// Limits bound what a script may use.
+type Limits struct {
+	// MaxHeap stops the script once the process's heap grows past it, in
+	// bytes; 0 means no limit. The heap is the whole process's, so the
+	// limit suits a process that runs only the script, as script steps do.
+	MaxHeap uint64
+}
+
+// Run runs source, named name in error messages, with the built-in
+// libraries and globals, print writing to out. It returns the values the
+// chunk returns; a syntax or runtime error, including a string longer than
+// MaxString or a heap over limits.MaxHeap, is an *Error. Cancelling ctx
+// interrupts the script.
+func Run(ctx context.Context, name, source string, globals map[string]Value, out io.Writer, limits Limits) ([]Value, error) {
+	body, err := parse(name, source)
+	if err != nil {
+		return nil, &Error{Value: err.Error()}
+	}
+	in := &interp{ctx: ctx, name: name, globals: NewTable(), maxHeap: limits.MaxHeap}
+	in.openLibs(out)
+	for key, value := range globals {
+		in.globals.Set(key, value)
+	}
+	_, results, err := in.execBlock(body, nil)
+	if err == nil && ctx.Err() != nil {
+		err = &Error{Value: name + ": interrupted: " + ctx.Err().Error()}
+	}
+	return results, err
+}
+
+// Check reports a syntax error in source.
+func Check(name, source string) error {
+	if _, err := parse(name, source); err != nil {
+		return &Error{Value: err.Error()}
+	}
+	return nil
+}
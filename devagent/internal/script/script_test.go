@@ -0,0 +1,199 @@
+package script
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, source string, globals map[string]Value) ([]Value, string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	results, err := Run(context.Background(), "test", source, globals, &out, Limits{})
+	return results, out.String(), err
+}
+
+func TestRunLanguage(t *testing.T) {
+	cases := []struct {
+		source string
+		want   string
+	}{
+		{`return 1 + 2 * 3 ^ 2 / 2`, "10"},
+		{`return 2 ^ 3 ^ 2`, "512"},
+		{`return -2 ^ 2`, "-4"},
+		{`return 7 % 3, -7 % 3, 7 / 2`, "1 2 3.5"},
+		{`return "a" .. 1 .. "b" .. 2.5`, "a1b2.5"},
+		{`return "10" + 5, #"hello", not nil, 1 == 1.0, "1" == 1`, "15 5 true true false"},
+		{`return nil or "x", false and 1, 1 and 2, 1 < 2 and "a" < "b"`, "x false 2 true"},
+		{`local s = 0 for i = 1, 10 do s = s + i end return s`, "55"},
+		{`local s = 0 for i = 10, 1, -3 do s = s + i end return s`, "22"},
+		{`local i = 0 while true do i = i + 1 if i > 4 then break end end return i`, "5"},
+		{`local i = 0 repeat local j = i i = i + 1 until j >= 2 return i`, "3"},
+		{`local x = 5 if x < 3 then return "small" elseif x < 10 then return "medium" else return "large" end`, "medium"},
+		{`local function fib(n) if n < 2 then return n end return fib(n-1) + fib(n-2) end return fib(15)`, "610"},
+		{`local function pair() return 1, 2 end local a, b, c = pair() return a, b, c`, "1 2 nil"},
+		{`local function pair() return 1, 2 end local t = {pair(), pair()} return #t`, "3"},
+		{`local a, b = 1, 2 a, b = b, a return a, b`, "2 1"},
+		{`local function counter() local n = 0 return function() n = n + 1 return n end end
+		  local c = counter() c() c() return c()`, "3"},
+		{`local fs = {} for i = 1, 3 do fs[i] = function() return i end end return fs[1]() + fs[3]()`, "4"},
+		{`local t = {x = 1, ["y z"] = 2, 10, 20; 30} return t.x, t["y z"], t[3], #t`, "1 2 30 3"},
+		{`local t = {} t[1] = "a" t[3] = "c" t[2] = "b" return #t, table.concat(t, ",")`, "3 a,b,c"},
+		{`local t = {} t[1] = "a" t[2] = "b" t[2] = nil return #t`, "1"},
+		{`local obj = {n = 2} function obj:double() return self.n * 2 end return obj:double()`, "4"},
+		{`local m = {a = {}} function m.a.f(x) return x + 1 end return m.a.f(1)`, "2"},
+		{`local keys = {} for k, v in pairs({b = 1, a = 2, 5}) do keys[#keys + 1] = tostring(k) end return table.concat(keys, " ")`, "1 a b"},
+		{`local s = "" for i, v in ipairs({"x", "y", nil, "z"}) do s = s .. i .. v end return s`, "1x2y"},
+		{`x = 1 do local x = 2 end return x`, "1"},
+		{"return [[\nlong\nstring]], [==[a]]b]==]", "long\nstring a]]b"},
+		{`-- comment
+		  --[[ long
+		  comment ]] return 0x1F, 1e2, .5`, "31 100 0.5"},
+		{`return ("%s=%d %.2f %5s|%-3s|%x %q"):format("n", 42, 3.14159, "r", "l", 255, "q")`, `n=42 3.14     r|l  |ff "q"`},
+		{`return ("Hello"):upper(), ("abc"):sub(2), ("abc"):sub(-2, -2), ("a,b"):find(","), ("  x "):trim()`, "HELLO bc b 2 x"},
+		{`return #("a b  c"):split(), ("a,,b"):split(",")[2] == "", ("v1.2"):startswith("v")`, "3 true true"},
+		{`local t = {3, 1, 2} table.sort(t) local u = {"b", "c", "a"} table.sort(u, function(a, b) return a > b end)
+		  return table.concat(t), table.concat(u)`, "123 cba"},
+		{`local t = {1, 3} table.insert(t, 2, 2) table.insert(t, 4) local last = table.remove(t) return table.concat(t, ","), last, table.remove(t, 1)`, "1,2,3 4 1"},
+		{`return math.floor(2.7), math.ceil(2.1), math.max(1, 5, 3), math.min(4, 2), math.abs(-3), math.huge > 1e308`, "2 3 5 2 3 true"},
+		{`return tonumber("0x10"), tonumber(" 12 "), tonumber("nan"), tonumber("abc"), type(tonumber)`, "16 12 nil nil function"},
+		{`local v = json.decode('{"a":[1,2,{"b":true}],"n":null}') return v.a[3].b, #v.a, v.n`, "true 3 nil"},
+		{`return json.encode({1, "two", {k = false}}), json.encode({}), json.encode({b = 1, a = {2}})`, `[1,"two",{"k":false}] {} {"a":[2],"b":1}`},
+		{`return re.find("version 1.2.3", [[(\d+)\.(\d+)]]), re.find("abc", "b"), re.find("abc", "x")`, "1 b nil"},
+		{`return re.gsub("a-b-c", "-", "+"), #re.findall("a1b22c333", [[\d+]]), re.split("a1b2c", [[\d]])[3]`, "a+b+c 3 c"},
+		{`local ok, err = pcall(error, {code = 7}) return ok, err.code`, "false 7"},
+		{`local ok, err = pcall(function() local x = nil return x.y end) return ok, err`, "false test:1: attempt to index a nil value (variable 'x')"},
+		{`return pcall(function(a, b) return a + b end, 1, 2)`, "true 3"},
+		{`return ("a,b"):find(","), re.find("v1.2", [[(\d+)\.(\d+)]])`, "2 1 2"},
+		{`return select("#", 1, 2, 3), select(2, "a", "b", "c")`, "3 b c"},
+		{`return ("a1b"):find("%d"), ("a.b"):find(".", 1, true), ("abc"):find("b", -1), ("key = value"):find("(%w+)%s*=%s*(%w+)")`, "2 2 nil 1 11 key value"},
+		{`return ("v1.2.3"):match("^v(%d+)%.(%d+)"), ("  x  "):match("^%s*(.-)%s*$"), ("abc"):match("^b"), ("hello"):match("()ll()")`, "1 x nil 3 5"},
+		{`return ("f(a(b)c) d"):match("%b()"), ("THE (quick) fox"):find("%f[%a]%a+%f[%A]", 5), ("xyyx"):match("(.)(.)%2%1")`, "(a(b)c) 6 x y"},
+		{`return ("[a-c]"):match("[%[]([^%]]+)"), ("a-b"):match("[a%-]+"), ("A1_b"):match("[%u%d_]+"), ("x]"):match("[]x]+")`, "a-c a- A1_ x]"},
+		{`local words = {} for k, v in ("a=1, b=2"):gmatch("(%w+)=(%w+)") do words[#words + 1] = k .. v end return table.concat(words, " ")`, "a1 b2"},
+		{`local n = 0 for _ in ("abc"):gmatch("") do n = n + 1 end return n`, "4"},
+		{`return ("hello world"):gsub("o", "0"), ("hello world"):gsub("(%w+)", "<%1>", 1), ("abc"):gsub("", "-")`, "hell0 w0rld <hello> world -a-b-c- 4"},
+		{`return ("$a $b $c"):gsub("%$(%w+)", {a = 1, b = false}), ("x y"):gsub("%w", function(c) return c:upper() end), ("50%"):gsub("%%", "%%%%")`, "1 $b $c X Y 50%% 1"},
+		{`local t = {10, 20, x = 1} local k, v = next(t) local k2 = next(t, k) local k3 = next(t, k2) return k, v, k2, k3, next(t, k3), next({})`, "1 10 2 x nil nil"},
+		{`local t = {a = 1, b = 2, c = 3} local n = 0 for k, v in next, t do n = n + v t[k] = nil end return n, next(t)`, "6 nil"},
+	}
+	for _, c := range cases {
+		results, _, err := run(t, c.source, nil)
+		if err != nil {
+			t.Errorf("%s: %v", c.source, err)
+			continue
+		}
+		parts := make([]string, len(results))
+		for i, v := range results {
+			parts[i] = ToString(v)
+		}
+		if got := strings.Join(parts, " "); got != c.want {
+			t.Errorf("%s:\n got %q\nwant %q", c.source, got, c.want)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	cases := []struct {
+		source string
+		want   string
+	}{
+		{"local x = 1\nreturn x +", "test:2: unexpected symbol near <eof>"},
+		{"if true then", "test:1: 'end' expected near <eof>"},
+		{"x = 'unfinished", "test:1: unfinished string"},
+		{"local t = nil\n\nt.x = 1", "test:3: attempt to index a nil value (variable 't')"},
+		{"return {} .. 'x'", "test:1: attempt to concatenate a table value"},
+		{"return 1 < 'x'", "test:1: attempt to compare number with string"},
+		{"missing()", "test:1: attempt to call a nil value (variable 'missing')"},
+		{"\nerror('boom')", "test:2: boom"},
+		{"assert(false, 'checked')", "checked"},
+		{"return ('x'):rep(-1) .. string.rep()", "test:1: string.rep: bad argument #1 (string expected, got nil)"},
+		{"local function f() return f() end f()", "stack overflow"},
+		{"return json.decode('{')", "test:1: json.decode: unexpected end of JSON input"},
+		{"return function(...) end", "varargs are not supported"},
+		{"return ('x'):find('%')", "string.find: malformed pattern (ends with '%')"},
+		{"return ('x'):match('[a')", "string.match: malformed pattern (missing ']')"},
+		{"return ('x'):match('(x')", "unfinished capture"},
+		{"return ('x'):gsub('x', '%2')", "invalid capture index %2"},
+		{"return ('x'):gsub('x', true)", "bad argument #3 (string/function/table expected, got boolean)"},
+		{"return ('x'):gsub('x', {x = {}})", "invalid replacement value (a table)"},
+		{"return next({}, 'missing')", "invalid key to 'next'"},
+	}
+	for _, c := range cases {
+		_, _, err := run(t, c.source, nil)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: expected error containing %q, got %v", c.source, c.want, err)
+		}
+		if _, ok := err.(*Error); err != nil && !ok {
+			t.Errorf("%q: expected an *Error, got %T", c.source, err)
+		}
+	}
+}
+
+func TestRunGlobalsAndPrint(t *testing.T) {
+	var saved []string
+	globals := map[string]Value{
+		"outputs": FromGo(map[string]string{"version": "1.2.3"}),
+		"save": Func("save", func(args []Value) ([]Value, error) {
+			saved = append(saved, ToString(args[0]))
+			return nil, nil
+		}),
+	}
+	_, out, err := run(t, `
+		local major = outputs.version:split(".")[1]
+		print("major", major, nil, true)
+		save(major + 1)
+	`, globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "major\t1\tnil\ttrue\n" {
+		t.Fatalf("unexpected print output %q", out)
+	}
+	if len(saved) != 1 || saved[0] != "2" {
+		t.Fatalf("expected save(2), got %v", saved)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := Run(ctx, "loop", "while true do end", nil, &bytes.Buffer{}, Limits{})
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected the loop to be interrupted, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Run(ctx, "loop", "while true do pcall(function() end) end", nil, &bytes.Buffer{}, Limits{})
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected pcall not to catch the interruption, got %v", err)
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	cases := map[string]string{
+		`local s = "x" for i = 1, 40 do s = s .. s end`:                                   "resulting string too large",
+		`return ("x"):rep(2^20, ("y"):rep(100))`:                                          "resulting string too large",
+		`local t = {} for i = 1, 80 do t[i] = ("x"):rep(2^20) end return table.concat(t)`: "resulting string too large",
+		`return re.gsub(("x"):rep(2^16), "", ("y"):rep(2^12))`:                            "resulting string too large",
+		`local t = {} for i = 1, 64 do t[i] = ("x"):rep(2^20) end return json.encode(t)`:  "resulting string too large",
+	}
+	for source, want := range cases {
+		if _, _, err := run(t, source, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", source, err, want)
+		}
+	}
+	if results, _, err := run(t, `return ("ab"):rep(3, ", ")`, nil); err != nil || results[0] != "ab, ab, ab" {
+		t.Fatalf("rep with a separator: %v %v", results, err)
+	}
+
+	// The heap limit stops a script that keeps many strings, and pcall
+	// cannot catch it.
+	source := `local t = {} while true do pcall(function() t[#t + 1] = ("x"):rep(2^16) .. #t end) end`
+	_, err := Run(context.Background(), "hog", source, nil, &bytes.Buffer{}, Limits{MaxHeap: 64 << 20})
+	if err == nil || !strings.Contains(err.Error(), "out of memory") {
+		t.Fatalf("expected the heap limit to stop the script, got %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEvent is one entry of the audit log: who changed or ran what, and
+// when. The log is append-only; removing or renaming a job leaves its
+// entries as they were.
+type AuditEvent struct {
+	ID int64     `json:"id"`
+	At time.Time `json:"at"`
+	// Actor is the OS user that made the change, with the user who ran
+	// sudo when there is one.
+	Actor string `json:"actor"`
+	// Source is the part of devagent that made it: cli, daemon or hook.
+	Source string `json:"source"`
+	// Action is e.g. job.create, job.pause, run.start or run.finish.
+	Action string `json:"action"`
+	Job    string `json:"job,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// AuditQuery selects audit log entries; zero fields match everything.
+type AuditQuery struct {
+	Job string
+	// Action matches an action exactly or, without a dot, every action of
+	// that kind: "job" matches job.create, job.remove and so on.
+	Action string
+	Since  time.Time
+	// Limit keeps the newest entries only.
+	Limit int
+}
+
+var (
+	auditMu     sync.Mutex
+	auditSource = "cli"
+	auditSink   func(AuditEvent)
+)
+
+// SetAuditSource names the part of devagent this process records audit
+// entries as, e.g. "daemon".
+func SetAuditSource(source string) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSource = source
+}
+
+// SetAuditSink has every audit entry this process records also passed to
+// fn, e.g. to copy it to syslog. Entries of a transaction are passed on
+// once it commits.
+func SetAuditSink(fn func(AuditEvent)) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSink = fn
+}
+
+// auditActor describes the OS user running this process.
+func auditActor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if sudo := os.Getenv("SUDO_USER"); sudo != "" && sudo != name {
+		name += " (sudo " + sudo + ")"
+	}
+	return name
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// audit appends an entry through x, the store or a transaction, and
+// returns it for forwardAudit.
+func audit(ctx context.Context, x execer, action, job, detail string) (AuditEvent, error) {
+	auditMu.Lock()
+	source := auditSource
+	auditMu.Unlock()
+	event := AuditEvent{
+		At:     time.Now().UTC(),
+		Actor:  auditActor(),
+		Source: source,
+		Action: action,
+		Job:    job,
+		Detail: detail,
+	}
+	res, err := x.ExecContext(ctx, `
+INSERT INTO audit_log(at, actor, source, action, job, detail) VALUES(?, ?, ?, ?, ?, ?)
+`, event.At, event.Actor, event.Source, event.Action, event.Job, event.Detail)
+	if err != nil {
+		return event, err
+	}
+	event.ID, _ = res.LastInsertId()
+	return event, nil
+}
+
+// forwardAudit passes recorded entries to the audit sink, if any.
+func forwardAudit(events ...AuditEvent) {
+	auditMu.Lock()
+	sink := auditSink
+	auditMu.Unlock()
+	if sink == nil {
+		return
+	}
+	for _, event := range events {
+		sink(event)
+	}
+}
+
+// record appends a single entry outside a transaction.
+func (s *Store) record(ctx context.Context, action, job, detail string) error {
+	event, err := audit(ctx, s.db, action, job, detail)
+	if err != nil {
+		return err
+	}
+	forwardAudit(event)
+	return nil
+}
+
+// AuditLog returns the audit entries matching q, oldest first.
+func (s *Store) AuditLog(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if q.Job != "" {
+		where = append(where, "job = ?")
+		args = append(args, q.Job)
+	}
+	if q.Action != "" {
+		if strings.Contains(q.Action, ".") {
+			where = append(where, "action = ?")
+			args = append(args, q.Action)
+		} else {
+			where = append(where, "action LIKE ?")
+			args = append(args, q.Action+".%")
+		}
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "at >= ?")
+		args = append(args, q.Since.UTC())
+	}
+	query := `SELECT id, at, actor, source, action, job, detail FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.Scan(&event.ID, &event.At, &event.Actor, &event.Source, &event.Action, &event.Job, &event.Detail); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAuditLogRecordsChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	var forwarded []string
+	SetAuditSink(func(e AuditEvent) { forwarded = append(forwarded, e.Action) })
+	defer SetAuditSink(nil)
+
+	job := Job{Name: "nightly", Repo: "/src", yamlPath: "/src/nightly.yml", cron: "0 2 * * *"}
+	if err := st.UpsertJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	job.cron = "0 3 * * *"
+	if err := st.UpsertJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetPaused(ctx, "nightly", true); err != nil {
+		t.Fatal(err)
+	}
+	// A change that fails is not recorded.
+	if err := st.SetPausedJobs(ctx, []string{"nightly", "missing"}, false); err == nil {
+		t.Fatal("expected an unknown job to fail the change")
+	}
+	tracker, err := st.BeginRun(ctx, "nightly", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Finish(ctx, "success", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RenameJob(ctx, "nightly", "nightly-build"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RemoveJob(ctx, "nightly-build"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := st.AuditLog(ctx, AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"job.create", "job.update", "job.pause", "run.start", "run.finish", "job.rename", "job.remove"}
+	if len(events) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if e.Action != want[i] || e.Actor == "" || e.Source != "cli" {
+			t.Errorf("entry %d: %+v, want action %s", i, e, want[i])
+		}
+	}
+	if events[1].Detail != `cron: "0 2 * * *" -> "0 3 * * *"` || events[4].Detail != "run 1: success" {
+		t.Errorf("unexpected details %q and %q", events[1].Detail, events[4].Detail)
+	}
+	if len(forwarded) != len(want) {
+		t.Errorf("forwarded %v", forwarded)
+	}
+
+	// Entries stay under the name the job had at the time.
+	if got, _ := st.AuditLog(ctx, AuditQuery{Job: "nightly"}); len(got) != 5 {
+		t.Errorf("entries for nightly: %+v", got)
+	}
+	if got, _ := st.AuditLog(ctx, AuditQuery{Action: "run"}); len(got) != 2 {
+		t.Errorf("run entries: %+v", got)
+	}
+	if got, _ := st.AuditLog(ctx, AuditQuery{Limit: 2}); len(got) != 2 || got[1].Action != "job.remove" {
+		t.Errorf("newest two entries: %+v", got)
+	}
+}
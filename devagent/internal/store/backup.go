@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupLayout is the timestamp in backup file names, which sort in the
+// order they were taken.
+const backupLayout = "20060102-150405"
+
+// Backup is a copy of the store taken by BackupNow.
+type Backup struct {
+	Path    string
+	TakenAt time.Time
+}
+
+// BackupsDir returns the directory backups are kept in: backups/ next to
+// the database.
+func BackupsDir() (string, error) {
+	dbPath, err := StatePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dbPath), "backups"), nil
+}
+
+// Backups lists the backups of the store, newest first.
+func Backups() ([]Backup, error) {
+	dir, err := BackupsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []Backup
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), "state-")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		takenAt, err := time.ParseInLocation(backupLayout, strings.TrimSuffix(stamp, ".db"), time.UTC)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, entry.Name()), TakenAt: takenAt})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].TakenAt.After(backups[j].TakenAt) })
+	return backups, nil
+}
+
+// BackupNow writes a consistent copy of the store to the backups directory
+// and removes all but the newest keep backups. A store that fails its
+// integrity check is not backed up, so corruption never rotates out the
+// last good copy.
+func (s *Store) BackupNow(ctx context.Context, keep int) (Backup, error) {
+	problems, err := checkDB(ctx, s.db, "quick_check")
+	if err != nil {
+		return Backup{}, err
+	}
+	if len(problems) > 0 {
+		return Backup{}, fmt.Errorf("store failed its integrity check, not backing up: %s", problems[0])
+	}
+	dir, err := BackupsDir()
+	if err != nil {
+		return Backup{}, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Backup{}, err
+	}
+	backup := Backup{TakenAt: time.Now().UTC().Truncate(time.Second)}
+	backup.Path = filepath.Join(dir, "state-"+backup.TakenAt.Format(backupLayout)+".db")
+	// VACUUM INTO refuses to overwrite, e.g. a backup taken the same second.
+	os.Remove(backup.Path)
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, backup.Path); err != nil {
+		return Backup{}, fmt.Errorf("back up store: %w", err)
+	}
+	if keep > 0 {
+		backups, err := Backups()
+		if err != nil {
+			return backup, err
+		}
+		for _, old := range backups[min(keep, len(backups)):] {
+			if err := os.Remove(old.Path); err != nil {
+				return backup, err
+			}
+		}
+	}
+	return backup, nil
+}
+
+// CheckIntegrity runs SQLite's integrity_check on the database at path and
+// returns the problems it reports; none means the database is sound. It
+// does not go through Open, whose schema migration may fail on a corrupt
+// file.
+func CheckIntegrity(ctx context.Context, path string) ([]string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn(path))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return checkDB(ctx, db, "integrity_check")
+}
+
+// checkDB runs an integrity pragma. A file too damaged to read is reported
+// as a problem rather than an error.
+func checkDB(ctx context.Context, db *sql.DB, pragma string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `PRAGMA `+pragma)
+	if err != nil {
+		if isCorrupt(err) {
+			return []string{err.Error()}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		if isCorrupt(err) {
+			return append(problems, err.Error()), nil
+		}
+		return nil, err
+	}
+	return problems, nil
+}
+
+func isCorrupt(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "malformed") || strings.Contains(msg, "not a database") || strings.Contains(msg, "corrupt")
+}
+
+// Repair replaces a corrupt database at path. It first rebuilds the
+// database from what SQLite can still read, which recovers damaged
+// indexes and free pages; if the rebuilt copy is not sound either, it
+// restores the newest backup that is. The corrupt file is kept next to the
+// database with a .corrupt suffix. Repair returns a description of what it
+// did. Nothing may have the store open while it runs.
+func Repair(ctx context.Context, path string) (string, error) {
+	aside := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format(backupLayout))
+
+	rebuilt := path + ".rebuild"
+	os.Remove(rebuilt)
+	if err := rebuild(ctx, path, rebuilt); err == nil {
+		if problems, err := CheckIntegrity(ctx, rebuilt); err == nil && len(problems) == 0 {
+			if err := swap(path, aside, rebuilt); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("rebuilt the store; the corrupt copy is %s", aside), nil
+		}
+	}
+	os.Remove(rebuilt)
+
+	backups, err := Backups()
+	if err != nil {
+		return "", err
+	}
+	for _, backup := range backups {
+		if problems, err := CheckIntegrity(ctx, backup.Path); err != nil || len(problems) > 0 {
+			continue
+		}
+		data, err := os.ReadFile(backup.Path)
+		if err != nil {
+			return "", err
+		}
+		restored := path + ".restore"
+		if err := os.WriteFile(restored, data, 0o644); err != nil {
+			return "", err
+		}
+		if err := swap(path, aside, restored); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("restored the backup from %s; changes since then are lost and the corrupt copy is %s", backup.TakenAt.Local().Format(time.RFC3339), aside), nil
+	}
+	return "", errors.New("the store could not be rebuilt and there is no sound backup; move it aside and re-register the jobs, e.g. with import-state")
+}
+
+// rebuild copies what SQLite can read of the database at path into dest.
+func rebuild(ctx context.Context, path, dest string) error {
+	db, err := sql.Open("sqlite", dsn(path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, `VACUUM INTO ?`, dest)
+	return err
+}
+
+// swap moves the database at path to aside and replacement into its place.
+func swap(path, aside, replacement string) error {
+	if err := os.Rename(path, aside); err != nil {
+		return err
+	}
+	// The write-ahead log belongs to the corrupt file.
+	os.Rename(path+"-wal", aside+"-wal")
+	os.Remove(path + "-shm")
+	os.Remove(path + "-journal")
+	return os.Rename(replacement, path)
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupRotationAndRepair(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := st.UpsertJob(ctx, NewJob("nightly", "/repo", "0 2 * * *", "", "", "/repo/.devagent.yml")); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := st.BackupNow(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := CheckIntegrity(ctx, backup.Path); err != nil || len(problems) > 0 {
+		t.Fatalf("backup is not sound: %v %v", problems, err)
+	}
+
+	// Older backups beyond keep are removed.
+	dir, _ := BackupsDir()
+	for _, name := range []string{"state-20200101-000000.db", "state-20210101-000000.db"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backup, err = st.BackupNow(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := Backups()
+	if err != nil || len(backups) != 2 || backups[0].Path != backup.Path || !strings.HasSuffix(backups[1].Path, "state-20210101-000000.db") {
+		t.Fatalf("expected the two newest backups, got %+v %v", backups, err)
+	}
+	st.Close()
+
+	path, _ := StatePath()
+	if problems, err := CheckIntegrity(ctx, path); err != nil || len(problems) > 0 {
+		t.Fatalf("fresh store reported %v %v", problems, err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Repeat("garbage ", 1024)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := CheckIntegrity(ctx, path); err != nil || len(problems) == 0 {
+		t.Fatalf("corruption not reported: %v %v", problems, err)
+	}
+	done, err := Repair(ctx, path)
+	if err != nil || !strings.HasPrefix(done, "restored the backup") {
+		t.Fatalf("repair: %q %v", done, err)
+	}
+	st, err = Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if job, err := st.GetJob(ctx, "nightly"); err != nil || job == nil {
+		t.Fatalf("job not restored: %v", err)
+	}
+}
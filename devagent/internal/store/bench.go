@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// BenchResult is one benchmark measurement recorded with a run.
+type BenchResult struct {
+	Name  string  `json:"name" yaml:"name"`
+	Unit  string  `json:"unit,omitempty" yaml:"unit,omitempty"`
+	Value float64 `json:"value" yaml:"value"`
+}
+
+// BenchRun is a run that recorded benchmark results.
+type BenchRun struct {
+	ID        int64
+	Status    string
+	StartedAt time.Time
+	Results   []BenchResult
+}
+
+// RecordBenchmarks stores the run's benchmark results. It is a no-op on a
+// nil tracker.
+func (t *RunTracker) RecordBenchmarks(ctx context.Context, job string, results []BenchResult) error {
+	if t == nil || len(results) == 0 {
+		return nil
+	}
+	tx, err := t.store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range results {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO bench_results (run_id, job, name, unit, value) VALUES (?, ?, ?, ?, ?)`, t.id, job, r.Name, r.Unit, r.Value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// BenchHistory returns up to limit of job's runs that recorded benchmark
+// results, newest first.
+func (s *Store) BenchHistory(ctx context.Context, job string, limit int) ([]BenchRun, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT r.id, r.status, r.started_at, b.name, b.unit, b.value
+FROM bench_results b JOIN runs r ON r.id = b.run_id
+WHERE b.run_id IN (SELECT DISTINCT run_id FROM bench_results WHERE job = ? ORDER BY run_id DESC LIMIT ?)
+ORDER BY r.started_at DESC, r.id DESC, b.rowid
+`, job, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []BenchRun
+	for rows.Next() {
+		var (
+			run    BenchRun
+			result BenchResult
+		)
+		if err := rows.Scan(&run.ID, &run.Status, &run.StartedAt, &result.Name, &result.Unit, &result.Value); err != nil {
+			return nil, err
+		}
+		if n := len(out); n == 0 || out[n-1].ID != run.ID {
+			out = append(out, run)
+		}
+		out[len(out)-1].Results = append(out[len(out)-1].Results, result)
+	}
+	return out, rows.Err()
+}
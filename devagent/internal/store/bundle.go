@@ -0,0 +1,383 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+// BundleVersion is the format version written by Export. Import accepts
+// bundles up to this version.
+const BundleVersion = 1
+
+// Bundle is a portable copy of the job registry, independent of the
+// database schema, written by `devagent export-state`.
+type Bundle struct {
+	Version    int          `json:"version" yaml:"version"`
+	ExportedAt time.Time    `json:"exported_at" yaml:"exported_at"`
+	Jobs       []BundledJob `json:"jobs" yaml:"jobs"`
+}
+
+// BundledJob is one job with its workflow file, state and, optionally, its
+// run history.
+type BundledJob struct {
+	Name     string `json:"name" yaml:"name"`
+	Repo     string `json:"repo" yaml:"repo"`
+	YAMLPath string `json:"yaml_path" yaml:"yaml_path"`
+	Cron     string `json:"cron,omitempty" yaml:"cron,omitempty"`
+	Natural  string `json:"natural,omitempty" yaml:"natural,omitempty"`
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	After    string `json:"after,omitempty" yaml:"after,omitempty"`
+	At       string `json:"at,omitempty" yaml:"at,omitempty"`
+	Every    string `json:"every,omitempty" yaml:"every,omitempty"`
+	Calendar string `json:"calendar,omitempty" yaml:"calendar,omitempty"`
+	// Workflow is the content of the workflow file when it was exported,
+	// empty if it could not be read.
+	Workflow      string            `json:"workflow,omitempty" yaml:"workflow,omitempty"`
+	Paused        bool              `json:"paused,omitempty" yaml:"paused,omitempty"`
+	FailureStreak int               `json:"failure_streak,omitempty" yaml:"failure_streak,omitempty"`
+	LastStatus    string            `json:"last_status,omitempty" yaml:"last_status,omitempty"`
+	LastRun       *time.Time        `json:"last_run,omitempty" yaml:"last_run,omitempty"`
+	ApprovedHash  string            `json:"approved_hash,omitempty" yaml:"approved_hash,omitempty"`
+	Outputs       map[string]string `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	Revisions     []BundledRevision `json:"revisions,omitempty" yaml:"revisions,omitempty"`
+	Runs          []BundledRun      `json:"runs,omitempty" yaml:"runs,omitempty"`
+}
+
+// BundledRevision is a recorded version of a job's workflow file.
+type BundledRevision struct {
+	Content   string    `json:"content" yaml:"content"`
+	Source    string    `json:"source" yaml:"source"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// BundledRun is a finished run.
+type BundledRun struct {
+	Status       string        `json:"status" yaml:"status"`
+	RunDir       string        `json:"run_dir,omitempty" yaml:"run_dir,omitempty"`
+	WorkflowHash string        `json:"workflow_hash,omitempty" yaml:"workflow_hash,omitempty"`
+	Commit       string        `json:"commit,omitempty" yaml:"commit,omitempty"`
+	StartedAt    time.Time     `json:"started_at" yaml:"started_at"`
+	EndedAt      *time.Time    `json:"ended_at,omitempty" yaml:"ended_at,omitempty"`
+	Usage        RunUsage      `json:"usage" yaml:"usage"`
+	Tests        *RunTests     `json:"tests,omitempty" yaml:"tests,omitempty"`
+	Benchmarks   []BenchResult `json:"benchmarks,omitempty" yaml:"benchmarks,omitempty"`
+}
+
+// Export copies the registry into a bundle: every job with its workflow
+// file, outputs and workflow history, plus its finished runs when
+// withRuns is set.
+func (s *Store) Export(ctx context.Context, withRuns bool) (*Bundle, error) {
+	jobs, err := s.ListJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{Version: BundleVersion, ExportedAt: time.Now().UTC()}
+	for _, job := range jobs {
+		b := BundledJob{
+			Name:          job.Name,
+			Repo:          job.Repo,
+			YAMLPath:      job.yamlPath,
+			Cron:          job.cron,
+			Natural:       job.natural,
+			Timezone:      job.timezone,
+			After:         job.after,
+			At:            job.at,
+			Every:         job.every,
+			Calendar:      job.calendar,
+			Paused:        job.Paused,
+			FailureStreak: job.FailureStreak,
+			LastStatus:    job.LastStatus.String,
+			ApprovedHash:  job.ApprovedHash,
+		}
+		if job.LastRun.Valid {
+			t := job.LastRun.Time.UTC()
+			b.LastRun = &t
+		}
+		if content, err := os.ReadFile(job.yamlPath); err == nil {
+			b.Workflow = string(content)
+		}
+		outputs, err := s.OutputsFor(ctx, []string{job.Name})
+		if err != nil {
+			return nil, err
+		}
+		if len(outputs[job.Name]) > 0 {
+			b.Outputs = outputs[job.Name]
+		}
+		revisions, err := s.Revisions(ctx, job.Name)
+		if err != nil {
+			return nil, err
+		}
+		// Revisions lists newest first; the bundle keeps them in the order
+		// they were recorded so Import can replay them.
+		for i := len(revisions) - 1; i >= 0; i-- {
+			rev := revisions[i]
+			b.Revisions = append(b.Revisions, BundledRevision{Content: rev.Content, Source: rev.Source, CreatedAt: rev.CreatedAt.UTC()})
+		}
+		if withRuns {
+			if b.Runs, err = s.exportRuns(ctx, job.Name); err != nil {
+				return nil, err
+			}
+		}
+		bundle.Jobs = append(bundle.Jobs, b)
+	}
+	return bundle, nil
+}
+
+func (s *Store) exportRuns(ctx context.Context, job string) ([]BundledRun, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+runSelectColumns+`, tests_passed, tests_failed, tests_skipped, coverage
+FROM runs
+WHERE job = ? AND status != ?
+ORDER BY id
+`, job, RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		runs []BundledRun
+		ids  []int64
+	)
+	for rows.Next() {
+		var (
+			run                     Run
+			passed, failed, skipped sql.NullInt64
+			coverage                sql.NullFloat64
+		)
+		err := rows.Scan(&run.ID, &run.Job, &run.Status, &run.PID, &run.RunDir, &run.WorkflowHash, &run.StartedAt, &run.HeartbeatAt, &run.EndedAt, &run.Usage.CPUSec, &run.Usage.MaxRSSBytes, &run.Usage.WrittenBytes, &run.Commit, &run.Step, &run.StepTotal, &run.StepCmd, &run.StepStartedAt, &run.OutputAt, &run.Stalled, &passed, &failed, &skipped, &coverage)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b := BundledRun{
+			Status:       run.Status,
+			RunDir:       run.RunDir,
+			WorkflowHash: run.WorkflowHash,
+			Commit:       run.Commit,
+			StartedAt:    run.StartedAt.UTC(),
+			Usage:        run.Usage,
+		}
+		if run.EndedAt.Valid {
+			t := run.EndedAt.Time.UTC()
+			b.EndedAt = &t
+		}
+		if passed.Valid {
+			b.Tests = &RunTests{Passed: int(passed.Int64), Failed: int(failed.Int64), Skipped: int(skipped.Int64)}
+			if coverage.Valid {
+				b.Tests.Coverage = &coverage.Float64
+			}
+		}
+		runs = append(runs, b)
+		ids = append(ids, run.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		results, err := s.benchResults(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		runs[i].Benchmarks = results
+	}
+	return runs, nil
+}
+
+func (s *Store) benchResults(ctx context.Context, runID int64) ([]BenchResult, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, unit, value FROM bench_results WHERE run_id = ? ORDER BY rowid`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []BenchResult
+	for rows.Next() {
+		var r BenchResult
+		if err := rows.Scan(&r.Name, &r.Unit, &r.Value); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// ImportOptions controls how Import treats a bundle.
+type ImportOptions struct {
+	// Replace overwrites jobs that are already registered, including their
+	// workflow history, and their runs when the bundle has runs; otherwise
+	// they are skipped.
+	Replace bool
+	// PathMap rewrites path prefixes (old -> new) in repos, workflow paths
+	// and run directories, e.g. when the home directory changed.
+	PathMap map[string]string
+}
+
+// ImportResult reports what Import did with each job.
+type ImportResult struct {
+	Imported []string
+	Skipped  []string
+	// WrittenWorkflows lists the workflow files restored from the bundle
+	// because they were missing.
+	WrittenWorkflows []string
+}
+
+// Validate checks that this devagent can import the bundle.
+func (b *Bundle) Validate() error {
+	if b.Version < 1 || b.Version > BundleVersion {
+		return fmt.Errorf("unsupported bundle version %d (this devagent reads up to %d)", b.Version, BundleVersion)
+	}
+	for _, job := range b.Jobs {
+		if job.Name == "" || job.YAMLPath == "" {
+			return fmt.Errorf("bundle has a job without a name or workflow path")
+		}
+	}
+	return nil
+}
+
+// Import registers the jobs in bundle. A missing workflow file is restored
+// from the bundled copy; existing files are never overwritten.
+func (s *Store) Import(ctx context.Context, bundle *Bundle, opts ImportOptions) (ImportResult, error) {
+	var result ImportResult
+	if err := bundle.Validate(); err != nil {
+		return result, err
+	}
+	for _, b := range bundle.Jobs {
+		existing, err := s.GetJob(ctx, b.Name)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil && !opts.Replace {
+			result.Skipped = append(result.Skipped, b.Name)
+			continue
+		}
+		b.Repo = mapPath(b.Repo, opts.PathMap)
+		b.YAMLPath = mapPath(b.YAMLPath, opts.PathMap)
+		if b.Workflow != "" {
+			if _, err := os.Stat(b.YAMLPath); os.IsNotExist(err) {
+				if err := os.MkdirAll(filepath.Dir(b.YAMLPath), 0o755); err != nil {
+					return result, err
+				}
+				if err := os.WriteFile(b.YAMLPath, []byte(b.Workflow), 0o644); err != nil {
+					return result, err
+				}
+				result.WrittenWorkflows = append(result.WrittenWorkflows, b.YAMLPath)
+			}
+		}
+		if err := s.importJob(ctx, b, opts.PathMap); err != nil {
+			return result, fmt.Errorf("import %s: %w", b.Name, err)
+		}
+		result.Imported = append(result.Imported, b.Name)
+	}
+	return result, nil
+}
+
+func (s *Store) importJob(ctx context.Context, b BundledJob, pathMap map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries := []string{
+		`DELETE FROM job_outputs WHERE job = ?`,
+		`DELETE FROM workflow_revisions WHERE job = ?`,
+		`DELETE FROM jobs WHERE name = ?`,
+	}
+	if len(b.Runs) > 0 {
+		queries = append(queries, `DELETE FROM bench_results WHERE job = ?`, `DELETE FROM runs WHERE job = ?`)
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, b.Name); err != nil {
+			return err
+		}
+	}
+	var lastStatus sql.NullString
+	if b.LastStatus != "" {
+		lastStatus = sql.NullString{String: b.LastStatus, Valid: true}
+	}
+	var lastRun sql.NullTime
+	if b.LastRun != nil {
+		lastRun = sql.NullTime{Time: b.LastRun.UTC(), Valid: true}
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO jobs(name, repo, cron, natural, timezone, yaml_path, after_job, run_at, every_interval, calendar, last_status, last_run, failure_streak, paused, approved_hash, updated_at)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+`, b.Name, b.Repo, b.Cron, b.Natural, b.Timezone, b.YAMLPath, b.After, b.At, b.Every, b.Calendar, lastStatus, lastRun, b.FailureStreak, b.Paused, b.ApprovedHash); err != nil {
+		return err
+	}
+	for key, value := range b.Outputs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO job_outputs(job, key, value) VALUES(?, ?, ?)`, b.Name, key, value); err != nil {
+			return err
+		}
+	}
+	for _, rev := range b.Revisions {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO workflow_revisions(job, hash, content, source, created_at) VALUES(?, ?, ?, ?, ?)
+`, b.Name, dsl.Hash([]byte(rev.Content)), rev.Content, rev.Source, rev.CreatedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	for _, run := range b.Runs {
+		var ended sql.NullTime
+		if run.EndedAt != nil {
+			ended = sql.NullTime{Time: run.EndedAt.UTC(), Valid: true}
+		}
+		var passed, failed, skipped sql.NullInt64
+		var coverage sql.NullFloat64
+		if run.Tests != nil {
+			passed = sql.NullInt64{Int64: int64(run.Tests.Passed), Valid: true}
+			failed = sql.NullInt64{Int64: int64(run.Tests.Failed), Valid: true}
+			skipped = sql.NullInt64{Int64: int64(run.Tests.Skipped), Valid: true}
+			if run.Tests.Coverage != nil {
+				coverage = sql.NullFloat64{Float64: *run.Tests.Coverage, Valid: true}
+			}
+		}
+		// Imported runs belong to no process; pid 0 keeps crash recovery
+		// from treating them as live.
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO runs(job, status, pid, run_dir, workflow_hash, commit_sha, started_at, heartbeat_at, ended_at, cpu_sec, max_rss_bytes, written_bytes, tests_passed, tests_failed, tests_skipped, coverage)
+VALUES(?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, b.Name, run.Status, mapPath(run.RunDir, pathMap), run.WorkflowHash, run.Commit, run.StartedAt.UTC(), run.StartedAt.UTC(), ended, run.Usage.CPUSec, run.Usage.MaxRSSBytes, run.Usage.WrittenBytes, passed, failed, skipped, coverage)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, r := range run.Benchmarks {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO bench_results (run_id, job, name, unit, value) VALUES (?, ?, ?, ?, ?)`, id, b.Name, r.Name, r.Unit, r.Value); err != nil {
+				return err
+			}
+		}
+	}
+	event, err := audit(ctx, tx, "job.import", b.Name, b.YAMLPath)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forwardAudit(event)
+	return nil
+}
+
+// mapPath rewrites the longest matching prefix of path in pathMap.
+func mapPath(path string, pathMap map[string]string) string {
+	var from, to string
+	for old, replacement := range pathMap {
+		old = strings.TrimSuffix(old, "/")
+		if (path == old || strings.HasPrefix(path, old+"/")) && len(old) > len(from) {
+			from, to = old, strings.TrimSuffix(replacement, "/")
+		}
+	}
+	if from == "" {
+		return path
+	}
+	return to + strings.TrimPrefix(path, from)
+}
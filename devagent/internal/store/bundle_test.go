@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	old := filepath.Join(home, "old")
+	workflow := filepath.Join(old, "api", ".devagent.yml")
+	if err := os.MkdirAll(filepath.Dir(workflow), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(workflow, []byte("name: api-tests\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := st.UpsertJob(ctx, NewJob("api-tests", filepath.Join(old, "api"), "0 2 * * *", "", "UTC", workflow)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveOutputs(ctx, "api-tests", map[string]string{"version": "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.RecordRevision(ctx, "api-tests", []byte("name: api-tests\n"), "new"); err != nil {
+		t.Fatal(err)
+	}
+	run, err := st.BeginRun(ctx, "api-tests", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run.RecordTests(ctx, RunTests{Passed: 3, Failed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.RecordBenchmarks(ctx, "api-tests", []BenchResult{{Name: "BenchmarkParse", Unit: "ns/op", Value: 120}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Finish(ctx, "failed", filepath.Join(old, "runs", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateRunResult(ctx, "api-tests", "failed", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.BeginRun(ctx, "api-tests", "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := st.Export(ctx, true)
+	st.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Jobs) != 1 || len(bundle.Jobs[0].Runs) != 1 {
+		t.Fatalf("expected one job with its finished run, got %+v", bundle.Jobs)
+	}
+	if bundle.Jobs[0].Workflow != "name: api-tests\n" {
+		t.Fatalf("workflow not bundled: %q", bundle.Jobs[0].Workflow)
+	}
+
+	// A new machine: a fresh store and the repos under another directory.
+	t.Setenv("DEVAGENT_STORE", filepath.Join(home, "new.db"))
+	fresh, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	moved := filepath.Join(home, "new")
+	result, err := fresh.Import(ctx, bundle, ImportOptions{PathMap: map[string]string{old: moved}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newWorkflow := filepath.Join(moved, "api", ".devagent.yml")
+	if len(result.Imported) != 1 || len(result.WrittenWorkflows) != 1 || result.WrittenWorkflows[0] != newWorkflow {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if data, err := os.ReadFile(newWorkflow); err != nil || string(data) != "name: api-tests\n" {
+		t.Fatalf("workflow not restored: %q %v", data, err)
+	}
+
+	job, err := fresh.GetJob(ctx, "api-tests")
+	if err != nil || job == nil {
+		t.Fatalf("job not imported: %v", err)
+	}
+	if job.Repo != filepath.Join(moved, "api") || job.YAMLPath() != newWorkflow || job.Cron() != "0 2 * * *" || job.Timezone() != "UTC" {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if job.LastStatus.String != "failed" || job.FailureStreak != 1 {
+		t.Fatalf("job state not imported: %+v", job)
+	}
+	outputs, err := fresh.OutputsFor(ctx, []string{"api-tests"})
+	if err != nil || outputs["api-tests"]["version"] != "1.2" {
+		t.Fatalf("outputs not imported: %v %v", outputs, err)
+	}
+	if revs, err := fresh.Revisions(ctx, "api-tests"); err != nil || len(revs) != 1 {
+		t.Fatalf("revisions not imported: %+v %v", revs, err)
+	}
+	tests, err := fresh.TestHistory(ctx, "api-tests", 10)
+	if err != nil || len(tests) != 1 || tests[0].Tests.Failed != 1 {
+		t.Fatalf("test history not imported: %+v %v", tests, err)
+	}
+	bench, err := fresh.BenchHistory(ctx, "api-tests", 10)
+	if err != nil || len(bench) != 1 || len(bench[0].Results) != 1 {
+		t.Fatalf("benchmarks not imported: %+v %v", bench, err)
+	}
+
+	// Importing again skips the job unless Replace is set.
+	again, err := fresh.Import(ctx, bundle, ImportOptions{})
+	if err != nil || len(again.Skipped) != 1 || len(again.Imported) != 0 {
+		t.Fatalf("expected the job to be skipped, got %+v %v", again, err)
+	}
+	again, err = fresh.Import(ctx, bundle, ImportOptions{Replace: true})
+	if err != nil || len(again.Imported) != 1 {
+		t.Fatalf("expected the job to be replaced, got %+v %v", again, err)
+	}
+	if bench, _ := fresh.BenchHistory(ctx, "api-tests", 10); len(bench) != 1 {
+		t.Fatalf("replacing duplicated the run history: %+v", bench)
+	}
+
+	if _, err := fresh.Import(ctx, &Bundle{Version: BundleVersion + 1}, ImportOptions{}); err == nil {
+		t.Fatal("expected a newer bundle version to be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DaemonLock records the daemon that schedules the store's jobs. Only one
+// daemon holds it at a time so jobs never fire twice.
+type DaemonLock struct {
+	PID         int
+	Host        string
+	StartedAt   time.Time
+	HeartbeatAt time.Time
+	// Released is set when the holder stopped cleanly.
+	Released bool
+	// ContenderPID is the last daemon that found the lock held, at
+	// ContendedAt, and ContenderReadOnly whether it then ran read-only
+	// instead of refusing to start.
+	ContenderPID      int
+	ContenderReadOnly bool
+	ContendedAt       sql.NullTime
+}
+
+// Held reports whether the lock belongs to a daemon that is still running:
+// it was not released, its heartbeat is fresh and alive says its process
+// exists.
+func (l *DaemonLock) Held(alive func(pid int) bool) bool {
+	if l == nil || l.Released || time.Since(l.HeartbeatAt) >= 3*HeartbeatInterval {
+		return false
+	}
+	if host, _ := os.Hostname(); l.Host != host {
+		// The process cannot be checked from here; trust the heartbeat.
+		return true
+	}
+	return alive(l.PID)
+}
+
+// DaemonConflictError reports that another daemon holds the lock.
+type DaemonConflictError struct {
+	Holder DaemonLock
+}
+
+func (e *DaemonConflictError) Error() string {
+	return fmt.Sprintf("another daemon (pid %d on %s) has been running since %s", e.Holder.PID, e.Holder.Host, e.Holder.StartedAt.Local().Format(time.RFC3339))
+}
+
+// ErrDaemonLockLost is returned by RefreshDaemonLock once another daemon
+// has taken the lock over, e.g. after this one was suspended long enough
+// for its heartbeat to go stale.
+var ErrDaemonLockLost = errors.New("daemon lock was taken over by another daemon")
+
+// DaemonLockState returns the daemon lock, or nil if no daemon ever took
+// it.
+func (s *Store) DaemonLockState(ctx context.Context) (*DaemonLock, error) {
+	var l DaemonLock
+	err := s.db.QueryRowContext(ctx, `
+SELECT pid, host, started_at, heartbeat_at, released, contender_pid, contender_read_only, contended_at FROM daemon_lock WHERE id = 1
+`).Scan(&l.PID, &l.Host, &l.StartedAt, &l.HeartbeatAt, &l.Released, &l.ContenderPID, &l.ContenderReadOnly, &l.ContendedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// AcquireDaemonLock takes the daemon lock for the current process. It
+// fails with a *DaemonConflictError while another daemon holds it (see
+// DaemonLock.Held); a lock left by a daemon that died is taken over.
+func (s *Store) AcquireDaemonLock(ctx context.Context, alive func(pid int) bool) error {
+	current, err := s.DaemonLockState(ctx)
+	if err != nil {
+		return err
+	}
+	pid := os.Getpid()
+	if current != nil && current.PID != pid && current.Held(alive) {
+		return &DaemonConflictError{Holder: *current}
+	}
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	var res sql.Result
+	if current == nil {
+		res, err = s.db.ExecContext(ctx, `
+INSERT INTO daemon_lock(id, pid, host, started_at, heartbeat_at) VALUES(1, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING
+`, pid, host, now, now)
+	} else {
+		// Only replace the holder seen above, so two daemons starting at
+		// once cannot both take over a stale lock.
+		res, err = s.db.ExecContext(ctx, `
+UPDATE daemon_lock SET pid = ?, host = ?, started_at = ?, heartbeat_at = ?, released = 0
+WHERE id = 1 AND pid = ? AND heartbeat_at = ?
+`, pid, host, now, now, current.PID, current.HeartbeatAt)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		winner, err := s.DaemonLockState(ctx)
+		if err != nil {
+			return err
+		}
+		if winner == nil {
+			return errors.New("daemon lock vanished while acquiring it")
+		}
+		return &DaemonConflictError{Holder: *winner}
+	}
+	return nil
+}
+
+// RefreshDaemonLock renews the heartbeat of the lock held by the current
+// process.
+func (s *Store) RefreshDaemonLock(ctx context.Context) error {
+	res, err := s.db.ExecContext(ctx, `
+UPDATE daemon_lock SET heartbeat_at = ? WHERE id = 1 AND pid = ? AND released = 0
+`, time.Now().UTC(), os.Getpid())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrDaemonLockLost
+	}
+	return nil
+}
+
+// ReleaseDaemonLock gives up the lock held by the current process so the
+// next daemon can start right away.
+func (s *Store) ReleaseDaemonLock(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE daemon_lock SET released = 1, heartbeat_at = ? WHERE id = 1 AND pid = ?
+`, time.Now().UTC(), os.Getpid())
+	return err
+}
+
+// RecordDaemonContender notes that the current process found the lock
+// held, and whether it carries on read-only, so `devagent status` can
+// point out the second daemon.
+func (s *Store) RecordDaemonContender(ctx context.Context, readOnly bool) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE daemon_lock SET contender_pid = ?, contender_read_only = ?, contended_at = ? WHERE id = 1
+`, os.Getpid(), readOnly, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDaemonLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	alive := func(int) bool { return true }
+
+	if err := st.AcquireDaemonLock(ctx, alive); err != nil {
+		t.Fatal(err)
+	}
+	// Another live daemon holds the lock.
+	host, _ := os.Hostname()
+	if _, err := st.db.Exec(`UPDATE daemon_lock SET pid = ?, host = ?`, os.Getpid()+1, host); err != nil {
+		t.Fatal(err)
+	}
+	var conflict *DaemonConflictError
+	if err := st.AcquireDaemonLock(ctx, alive); !errors.As(err, &conflict) || conflict.Holder.PID != os.Getpid()+1 {
+		t.Fatalf("expected a conflict with the other daemon, got %v", err)
+	}
+	if err := st.RefreshDaemonLock(ctx); !errors.Is(err, ErrDaemonLockLost) {
+		t.Fatalf("refreshing a lock held by another daemon: %v", err)
+	}
+	if err := st.RecordDaemonContender(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := st.DaemonLockState(ctx)
+	if err != nil || lock.ContenderPID != os.Getpid() || !lock.ContenderReadOnly || !lock.ContendedAt.Valid {
+		t.Fatalf("contender not recorded: %+v %v", lock, err)
+	}
+
+	// Its process died: the lock is taken over.
+	if err := st.AcquireDaemonLock(ctx, func(int) bool { return false }); err != nil {
+		t.Fatalf("taking over a dead daemon's lock: %v", err)
+	}
+	if err := st.RefreshDaemonLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale heartbeat counts as dead even if the pid was reused.
+	stale := time.Now().Add(-time.Hour).UTC()
+	if _, err := st.db.Exec(`UPDATE daemon_lock SET pid = ?, heartbeat_at = ?`, os.Getpid()+1, stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AcquireDaemonLock(ctx, alive); err != nil {
+		t.Fatalf("taking over a stale lock: %v", err)
+	}
+
+	if err := st.ReleaseDaemonLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lock, _ := st.DaemonLockState(ctx); lock == nil || !lock.Released || lock.Held(alive) {
+		t.Fatalf("released lock still held: %+v", lock)
+	}
+}
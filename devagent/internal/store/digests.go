@@ -0,0 +1,25 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LastDigestEnd returns the end of the period covered by the most recently
+// sent digest, or the zero time when none was sent.
+func (s *Store) LastDigestEnd(ctx context.Context) (time.Time, error) {
+	var end time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT period_end FROM digests ORDER BY period_end DESC LIMIT 1`).Scan(&end)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return end, err
+}
+
+// RecordDigest records that the digest for [start, end) was sent.
+func (s *Store) RecordDigest(ctx context.Context, start, end time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO digests (period_start, period_end, sent_at) VALUES (?, ?, ?)`, start.UTC(), end.UTC(), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LLMCall is one request devagent made to an LLM, e.g. to plan a workflow.
+type LLMCall struct {
+	At           time.Time
+	Model        string
+	Purpose      string
+	InputTokens  int64
+	OutputTokens int64
+	// CostUSD is the estimated cost in US dollars; nil when the model's
+	// price is unknown.
+	CostUSD *float64
+}
+
+// RecordLLMCall stores call.
+func (s *Store) RecordLLMCall(ctx context.Context, call LLMCall) error {
+	var cost sql.NullFloat64
+	if call.CostUSD != nil {
+		cost = sql.NullFloat64{Float64: *call.CostUSD, Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO llm_calls (at, model, purpose, input_tokens, output_tokens, cost_usd) VALUES (?, ?, ?, ?, ?, ?)
+`, call.At.UTC(), call.Model, call.Purpose, call.InputTokens, call.OutputTokens, cost)
+	return err
+}
+
+// LLMCalls returns the calls made since the given time, oldest first.
+func (s *Store) LLMCalls(ctx context.Context, since time.Time) ([]LLMCall, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT at, model, purpose, input_tokens, output_tokens, cost_usd FROM llm_calls WHERE at >= ? ORDER BY at, id
+`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var calls []LLMCall
+	for rows.Next() {
+		var (
+			call LLMCall
+			cost sql.NullFloat64
+		)
+		if err := rows.Scan(&call.At, &call.Model, &call.Purpose, &call.InputTokens, &call.OutputTokens, &cost); err != nil {
+			return nil, err
+		}
+		if cost.Valid {
+			call.CostUSD = &cost.Float64
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+// LLMSpend returns the estimated cost of the calls made since the given
+// time, in US dollars. Calls to models without a known price count as
+// free.
+func (s *Store) LLMSpend(ctx context.Context, since time.Time) (float64, error) {
+	var spent float64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(cost_usd), 0) FROM llm_calls WHERE at >= ?`, since.UTC()).Scan(&spent)
+	return spent, err
+}
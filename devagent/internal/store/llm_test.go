@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLLMCalls(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	cost := func(c float64) *float64 { return &c }
+	for _, call := range []LLMCall{
+		{At: now.AddDate(0, -2, 0), Model: "gpt-4.1-mini", Purpose: "plan", InputTokens: 900, OutputTokens: 100, CostUSD: cost(0.5)},
+		{At: now.Add(-time.Hour), Model: "gpt-4.1-mini", Purpose: "plan", InputTokens: 1200, OutputTokens: 300, CostUSD: cost(0.25)},
+		{At: now, Model: "local-llama", Purpose: "diagnose", InputTokens: 50, OutputTokens: 20},
+	} {
+		if err := st.RecordLLMCall(ctx, call); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	since := now.AddDate(0, -1, 0)
+	calls, err := st.LLMCalls(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0].InputTokens != 1200 || *calls[0].CostUSD != 0.25 || calls[1].Model != "local-llama" || calls[1].CostUSD != nil {
+		t.Fatalf("calls: %+v", calls)
+	}
+	if spent, err := st.LLMSpend(ctx, since); err != nil || spent != 0.25 {
+		t.Fatalf("spend: %v %v", spent, err)
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"devagent/internal/dsl"
+)
+
+// Drift is a job whose registry entry no longer agrees with its workflow
+// file, e.g. because the file was deleted, moved or edited by hand.
+type Drift struct {
+	Job Job
+	// Missing is set when the workflow file does not exist.
+	Missing bool
+	// Err is set when the file exists but cannot be read or parsed.
+	Err error
+	// Changes lists the fields that differ, as "field: store -> file".
+	Changes []string
+	// Workflow is the parsed file, when it loaded.
+	Workflow *dsl.Workflow
+}
+
+func (d Drift) String() string {
+	switch {
+	case d.Missing:
+		return fmt.Sprintf("%s: workflow file %s is missing", d.Job.Name, d.Job.YAMLPath())
+	case d.Err != nil:
+		return fmt.Sprintf("%s: workflow file %s: %v", d.Job.Name, d.Job.YAMLPath(), d.Err)
+	}
+	return fmt.Sprintf("%s: %s differs from the store: %s", d.Job.Name, d.Job.YAMLPath(), strings.Join(d.Changes, ", "))
+}
+
+// CheckWorkflowFiles compares every registered job with its workflow file
+// and returns the jobs that disagree.
+func (s *Store) CheckWorkflowFiles(ctx context.Context) ([]Drift, error) {
+	jobs, err := s.ListJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var drifts []Drift
+	for _, job := range jobs {
+		if drift, ok := checkWorkflowFile(job); ok {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts, nil
+}
+
+func checkWorkflowFile(job Job) (Drift, bool) {
+	drift := Drift{Job: job}
+	wf, err := dsl.Load(job.YAMLPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			drift.Missing = true
+		} else {
+			drift.Err = err
+		}
+		return drift, true
+	}
+	drift.Workflow = wf
+	drift.Changes = Differences(job, JobFromWorkflow(wf, job.YAMLPath()))
+	return drift, len(drift.Changes) > 0
+}
+
+// Differences lists the registration fields that differ between the
+// stored job and the job its workflow file describes, as
+// "field: stored -> file".
+func Differences(stored, file Job) []string {
+	var changes []string
+	for _, field := range []struct{ name, stored, file string }{
+		{"name", stored.Name, file.Name},
+		{"repo", stored.Repo, file.Repo},
+		{"yaml_path", stored.yamlPath, file.yamlPath},
+		{"cron", stored.cron, file.cron},
+		{"timezone", stored.timezone, file.timezone},
+		{"after", stored.after, file.after},
+		{"at", stored.at, file.at},
+		{"every", stored.every, file.every},
+		{"calendar", stored.calendar, file.calendar},
+	} {
+		if field.stored != field.file {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", field.name, field.stored, field.file))
+		}
+	}
+	return changes
+}
+
+// Reconcile resyncs the registry entry of a drifted job from its workflow
+// file and returns a description of what it did. A job whose file is
+// missing is removed; its runs stay in the store. A file that does not
+// parse is left for the user to fix. A changed name renames the job, so
+// its history is kept.
+func (s *Store) Reconcile(ctx context.Context, drift Drift) (string, error) {
+	switch {
+	case drift.Missing:
+		if err := s.RemoveJob(ctx, drift.Job.Name); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("removed %s, whose workflow file is gone", drift.Job.Name), nil
+	case drift.Err != nil:
+		return "", fmt.Errorf("%s: fix %s first: %w", drift.Job.Name, drift.Job.YAMLPath(), drift.Err)
+	}
+	job := JobFromWorkflow(drift.Workflow, drift.Job.YAMLPath())
+	if job.Name != drift.Job.Name {
+		if err := s.RenameJob(ctx, drift.Job.Name, job.Name); err != nil {
+			return "", err
+		}
+	}
+	if err := s.UpsertJob(ctx, job); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("updated %s from %s (%s)", job.Name, job.YAMLPath(), strings.Join(drift.Changes, ", ")), nil
+}
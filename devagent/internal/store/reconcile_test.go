@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestCheckAndReconcileWorkflowFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	register := func(content string) string {
+		path := filepath.Join(home, strings.Fields(content)[1]+".yml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		wf, err := dsl.Parse([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.UpsertJob(ctx, JobFromWorkflow(wf, path)); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	register("name: unchanged\nrepo: /src/api\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n")
+	edited := register("name: edited\nrepo: /src/api\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n")
+	renamed := register("name: renamed\nrepo: /src/api\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n")
+	gone := register("name: gone\nrepo: /src/api\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n")
+
+	if err := os.WriteFile(edited, []byte("name: edited\nrepo: /src/api\nschedule:\n  cron: \"0 3 * * *\"\nsteps:\n  - run: make\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(renamed, []byte("name: checks\nrepo: /src/api\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.BeginRun(ctx, "renamed", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err := st.CheckWorkflowFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Drift)
+	for _, drift := range drifts {
+		got[drift.Job.Name] = drift
+	}
+	if len(drifts) != 3 || !got["gone"].Missing || len(got["edited"].Changes) != 1 || !strings.HasPrefix(got["renamed"].Changes[0], "name:") {
+		t.Fatalf("unexpected drifts %+v", drifts)
+	}
+	if s := got["edited"].String(); !strings.Contains(s, `cron: "0 2 * * *" -> "0 3 * * *"`) {
+		t.Fatalf("unexpected description %q", s)
+	}
+
+	for _, drift := range drifts {
+		if _, err := st.Reconcile(ctx, drift); err != nil {
+			t.Fatalf("reconcile %s: %v", drift.Job.Name, err)
+		}
+	}
+	if drifts, _ := st.CheckWorkflowFiles(ctx); len(drifts) != 0 {
+		t.Fatalf("still drifted after reconciling: %+v", drifts)
+	}
+	if job, _ := st.GetJob(ctx, "edited"); job.Cron() != "0 3 * * *" {
+		t.Fatalf("cron not resynced: %q", job.Cron())
+	}
+	if job, _ := st.GetJob(ctx, "gone"); job != nil {
+		t.Fatal("job with a missing file was not removed")
+	}
+	if running, _ := st.RunningRuns(ctx); len(running) != 1 || running[0].Job != "checks" {
+		t.Fatalf("renamed job lost its runs: %+v", running)
+	}
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"devagent/internal/dsl"
+)
+
+// Revision is a recorded version of a job's workflow file.
+type Revision struct {
+	ID        int64
+	Job       string
+	Hash      string
+	Content   string
+	Source    string
+	CreatedAt time.Time
+	// LoadedAt is when the daemon last ran the job from this revision.
+	LoadedAt sql.NullTime
+}
+
+// ShortHash returns the abbreviated hash shown to users.
+func (r Revision) ShortHash() string {
+	if len(r.Hash) > 12 {
+		return r.Hash[:12]
+	}
+	return r.Hash
+}
+
+const revisionSelectColumns = `id, job, hash, content, source, created_at, loaded_at`
+
+func scanRevision(row rowScanner) (Revision, error) {
+	var rev Revision
+	err := row.Scan(&rev.ID, &rev.Job, &rev.Hash, &rev.Content, &rev.Source, &rev.CreatedAt, &rev.LoadedAt)
+	return rev, err
+}
+
+// RecordRevision stores content as the job's newest revision unless it is
+// identical to the current newest one, and returns the matching revision.
+// source says what produced it, e.g. "new", "edit" or "daemon".
+func (s *Store) RecordRevision(ctx context.Context, job string, content []byte, source string) (Revision, error) {
+	hash := dsl.Hash(content)
+	latest, err := scanRevision(s.db.QueryRowContext(ctx, `
+SELECT `+revisionSelectColumns+` FROM workflow_revisions WHERE job = ? ORDER BY id DESC LIMIT 1
+`, job))
+	if err == nil && latest.Hash == hash {
+		return latest, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Revision{}, err
+	}
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO workflow_revisions(job, hash, content, source, created_at) VALUES(?, ?, ?, ?, ?)
+`, job, hash, string(content), source, now)
+	if err != nil {
+		return Revision{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{ID: id, Job: job, Hash: hash, Content: string(content), Source: source, CreatedAt: now}, nil
+}
+
+// ApproveWorkflow records content as the job's newest revision and as the
+// workflow the daemon may run.
+func (s *Store) ApproveWorkflow(ctx context.Context, job string, content []byte, source string) error {
+	rev, err := s.RecordRevision(ctx, job, content, source)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE jobs SET approved_hash = ? WHERE name = ?`, rev.Hash, job)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, job)
+	}
+	return s.record(ctx, "job.approve", job, fmt.Sprintf("%s via %s", rev.ShortHash(), source))
+}
+
+// MarkRevisionLoaded records that the daemon ran the job from revision id.
+func (s *Store) MarkRevisionLoaded(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE workflow_revisions SET loaded_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+// Revisions returns a job's workflow history, newest first.
+func (s *Store) Revisions(ctx context.Context, job string) ([]Revision, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+revisionSelectColumns+` FROM workflow_revisions WHERE job = ? ORDER BY id DESC
+`, job)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revs []Revision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+// LoadedRevision returns the revision the daemon most recently ran the job
+// from, or nil if it never has.
+func (s *Store) LoadedRevision(ctx context.Context, job string) (*Revision, error) {
+	rev, err := scanRevision(s.db.QueryRowContext(ctx, `
+SELECT `+revisionSelectColumns+` FROM workflow_revisions
+WHERE job = ? AND loaded_at IS NOT NULL
+ORDER BY loaded_at DESC LIMIT 1
+`, job))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// FindRevision resolves a hash prefix to one of the job's revisions.
+func (s *Store) FindRevision(ctx context.Context, job, prefix string) (*Revision, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if prefix == "" {
+		return nil, errors.New("revision hash is empty")
+	}
+	revs, err := s.Revisions(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var match *Revision
+	for i := range revs {
+		if !strings.HasPrefix(revs[i].Hash, prefix) {
+			continue
+		}
+		if match != nil && match.Hash != revs[i].Hash {
+			return nil, fmt.Errorf("revision %s is ambiguous", prefix)
+		}
+		if match == nil {
+			match = &revs[i]
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no revision %s for job %s", prefix, job)
+	}
+	return match, nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"devagent/internal/dsl"
+)
+
+func TestRecordRevisionDedupesAndTracksLoaded(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	first, err := st.RecordRevision(ctx, "nightly", []byte("steps: [a]\n"), "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := st.RecordRevision(ctx, "nightly", []byte("steps: [a]\n"), "daemon")
+	if err != nil || again.ID != first.ID {
+		t.Fatalf("identical content should reuse revision %d, got %d err=%v", first.ID, again.ID, err)
+	}
+	if err := st.MarkRevisionLoaded(ctx, again.ID); err != nil {
+		t.Fatal(err)
+	}
+	second, err := st.RecordRevision(ctx, "nightly", []byte("steps: [b]\n"), "edit")
+	if err != nil || second.ID == first.ID {
+		t.Fatalf("changed content should add a revision, got %+v err=%v", second, err)
+	}
+
+	revs, err := st.Revisions(ctx, "nightly")
+	if err != nil || len(revs) != 2 || revs[0].ID != second.ID {
+		t.Fatalf("unexpected history %+v err=%v", revs, err)
+	}
+	loaded, err := st.LoadedRevision(ctx, "nightly")
+	if err != nil || loaded == nil || loaded.ID != first.ID {
+		t.Fatalf("expected first revision to be loaded, got %+v err=%v", loaded, err)
+	}
+	if found, err := st.FindRevision(ctx, "nightly", second.ShortHash()); err != nil || found.ID != second.ID {
+		t.Fatalf("find by short hash: %+v err=%v", found, err)
+	}
+}
+
+func TestApproveWorkflow(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.ApproveWorkflow(ctx, "nightly", []byte("steps: [a]\n"), "approve"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("approving an unknown job: %v", err)
+	}
+	if err := st.UpsertJob(ctx, Job{Name: "nightly", Repo: "/src", yamlPath: "/src/nightly.yml", cron: "0 2 * * *"}); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("steps: [a]\n")
+	if err := st.ApproveWorkflow(ctx, "nightly", content, "approve"); err != nil {
+		t.Fatal(err)
+	}
+	job, err := st.GetJob(ctx, "nightly")
+	if err != nil || job.ApprovedHash != dsl.Hash(content) {
+		t.Fatalf("approved hash %q, want %q (err=%v)", job.ApprovedHash, dsl.Hash(content), err)
+	}
+	if revs, err := st.Revisions(ctx, "nightly"); err != nil || len(revs) != 1 || revs[0].Source != "approve" {
+		t.Fatalf("approval should record a revision: %+v err=%v", revs, err)
+	}
+
+	// Re-registering the job, as edit and the watcher do, keeps the approval.
+	if err := st.UpsertJob(ctx, Job{Name: "nightly", Repo: "/src", yamlPath: "/src/nightly.yml", cron: "0 3 * * *"}); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := st.GetJob(ctx, "nightly"); job.ApprovedHash != dsl.Hash(content) {
+		t.Fatalf("upsert dropped the approval: %q", job.ApprovedHash)
+	}
+}
@@ -0,0 +1,469 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RunStatusRunning marks a run that has started but not yet finished.
+const RunStatusRunning = "running"
+
+// RunStatusInterrupted marks a run whose process died before finishing.
+const RunStatusInterrupted = "interrupted"
+
+// RunStatusCancelled marks a run stopped by `devagent cancel`.
+const RunStatusCancelled = "cancelled"
+
+// RunStatusSkipped marks a scheduled run that did not execute because the
+// repo had not changed since the previous run.
+const RunStatusSkipped = "skipped"
+
+// RunStatusHoliday marks a scheduled run that did not execute because the
+// day is a holiday of the workflow's schedule.holidays calendar.
+const RunStatusHoliday = "skipped(holiday)"
+
+// RunStatusUnapproved marks a scheduled run the daemon refused because the
+// workflow file changed since it was last approved.
+const RunStatusUnapproved = "unapproved"
+
+// CancelSignal is sent to the process owning a run after a cancellation has
+// been requested, so it notices without waiting for the next heartbeat.
+const CancelSignal = syscall.SIGUSR1
+
+// HeartbeatInterval is how often an active run refreshes its heartbeat.
+const HeartbeatInterval = 30 * time.Second
+
+// Run records a single execution of a job.
+type Run struct {
+	ID     int64
+	Job    string
+	Status string
+	PID    int
+	RunDir string
+	// WorkflowHash identifies the workflow revision the run executed.
+	WorkflowHash string
+	StartedAt    time.Time
+	HeartbeatAt  time.Time
+	EndedAt      sql.NullTime
+	Usage        RunUsage
+	// Commit is the repo HEAD when the run started, if known.
+	Commit string
+	// Step is the 1-based step, out of StepTotal, a running execution was
+	// on at its last step heartbeat, OutputAt when that step last wrote
+	// output, and Stalled whether it had gone logs.stalled_after without
+	// any.
+	Step          int
+	StepTotal     int
+	StepCmd       string
+	StepStartedAt sql.NullTime
+	OutputAt      sql.NullTime
+	Stalled       bool
+}
+
+// StepProgress is the step a running execution is on.
+type StepProgress struct {
+	Step       int
+	Total      int
+	Cmd        string
+	StartedAt  time.Time
+	LastOutput time.Time
+	Stalled    bool
+}
+
+// RunUsage is the resources consumed by the steps of a run.
+type RunUsage struct {
+	CPUSec       float64 `json:"cpu_sec,omitempty" yaml:"cpu_sec,omitempty"`
+	MaxRSSBytes  int64   `json:"max_rss_bytes,omitempty" yaml:"max_rss_bytes,omitempty"`
+	WrittenBytes int64   `json:"written_bytes,omitempty" yaml:"written_bytes,omitempty"`
+}
+
+// RunTracker keeps a run's heartbeat fresh until Finish is called.
+type RunTracker struct {
+	store *Store
+	id    int64
+	job   string
+	stop  chan struct{}
+	once  sync.Once
+
+	mu       sync.Mutex
+	onCancel func()
+}
+
+// activeRuns holds the trackers of runs owned by this process so a
+// CancelSignal can be routed to the right one.
+var (
+	activeMu   sync.Mutex
+	activeRuns = map[int64]*RunTracker{}
+	watchOnce  sync.Once
+)
+
+func watchCancelSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, CancelSignal)
+	go func() {
+		for range ch {
+			activeMu.Lock()
+			trackers := make([]*RunTracker, 0, len(activeRuns))
+			for _, t := range activeRuns {
+				trackers = append(trackers, t)
+			}
+			activeMu.Unlock()
+			for _, t := range trackers {
+				t.checkCancel()
+			}
+		}
+	}()
+}
+
+// BeginRun records a running execution of job owned by the current process
+// and starts a heartbeat so crashed runs can be detected later. workflowHash
+// identifies the workflow revision being run.
+func (s *Store) BeginRun(ctx context.Context, job, workflowHash string) (*RunTracker, error) {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO runs(job, status, pid, workflow_hash, started_at, heartbeat_at) VALUES(?, ?, ?, ?, ?, ?)
+`, job, RunStatusRunning, os.Getpid(), workflowHash, now, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.record(ctx, "run.start", job, fmt.Sprintf("run %d", id)); err != nil {
+		return nil, err
+	}
+	t := &RunTracker{store: s, id: id, job: job, stop: make(chan struct{})}
+	watchOnce.Do(watchCancelSignal)
+	activeMu.Lock()
+	activeRuns[id] = t
+	activeMu.Unlock()
+	go t.heartbeat()
+	return t, nil
+}
+
+// ID returns the run identifier.
+func (t *RunTracker) ID() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.id
+}
+
+func (t *RunTracker) heartbeat() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			_, _ = t.store.db.Exec(`UPDATE runs SET heartbeat_at = ? WHERE id = ?`, time.Now().UTC(), t.id)
+			t.checkCancel()
+		}
+	}
+}
+
+// OnCancel registers fn to be called once a cancellation of this run is
+// requested. It is a no-op on a nil tracker.
+func (t *RunTracker) OnCancel(fn func()) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.onCancel = fn
+	t.mu.Unlock()
+}
+
+func (t *RunTracker) checkCancel() {
+	var requested bool
+	if err := t.store.db.QueryRow(`SELECT cancel_requested FROM runs WHERE id = ?`, t.id).Scan(&requested); err != nil || !requested {
+		return
+	}
+	t.mu.Lock()
+	fn := t.onCancel
+	t.onCancel = nil
+	t.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// Finish stops the heartbeat and records the final status and run directory.
+// It is a no-op on a nil tracker so callers can run untracked when the store
+// is unavailable.
+func (t *RunTracker) Finish(ctx context.Context, status, runDir string) error {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() { close(t.stop) })
+	activeMu.Lock()
+	delete(activeRuns, t.id)
+	activeMu.Unlock()
+	now := time.Now().UTC()
+	_, err := t.store.db.ExecContext(ctx, `
+UPDATE runs SET status = ?, run_dir = ?, heartbeat_at = ?, ended_at = ? WHERE id = ?
+`, status, runDir, now, now, t.id)
+	if err != nil {
+		return err
+	}
+	return t.store.record(ctx, "run.finish", t.job, fmt.Sprintf("run %d: %s", t.id, status))
+}
+
+// RecordUsage stores the resources used by the run's steps. It is a no-op
+// on a nil tracker.
+func (t *RunTracker) RecordUsage(ctx context.Context, usage RunUsage) error {
+	if t == nil {
+		return nil
+	}
+	_, err := t.store.db.ExecContext(ctx, `
+UPDATE runs SET cpu_sec = ?, max_rss_bytes = ?, written_bytes = ? WHERE id = ?
+`, usage.CPUSec, usage.MaxRSSBytes, usage.WrittenBytes, t.id)
+	return err
+}
+
+// RecordCommit stores the repo HEAD the run executed against. It is a no-op
+// on a nil tracker.
+func (t *RunTracker) RecordCommit(ctx context.Context, commit string) error {
+	if t == nil {
+		return nil
+	}
+	_, err := t.store.db.ExecContext(ctx, `UPDATE runs SET commit_sha = ? WHERE id = ?`, commit, t.id)
+	return err
+}
+
+// RecordProgress stores the step the run is on and when it last wrote
+// output, and refreshes the heartbeat. It is a no-op on a nil tracker.
+func (t *RunTracker) RecordProgress(ctx context.Context, p StepProgress) error {
+	if t == nil {
+		return nil
+	}
+	_, err := t.store.db.ExecContext(ctx, `
+UPDATE runs SET step = ?, step_total = ?, step_cmd = ?, step_started_at = ?, output_at = ?, stalled = ?, heartbeat_at = ? WHERE id = ?
+`, p.Step, p.Total, p.Cmd, p.StartedAt.UTC(), p.LastOutput.UTC(), p.Stalled, time.Now().UTC(), t.id)
+	return err
+}
+
+// RecordSkippedRun records a run of job that was skipped at commit without
+// executing any step.
+func (s *Store) RecordSkippedRun(ctx context.Context, job, workflowHash, commit string) error {
+	return s.recordSkip(ctx, job, RunStatusSkipped, workflowHash, commit, fmt.Sprintf("unchanged at %.12s", commit))
+}
+
+// RecordHolidayRun records a run of job that was skipped for holiday.
+func (s *Store) RecordHolidayRun(ctx context.Context, job, workflowHash, holiday string) error {
+	return s.recordSkip(ctx, job, RunStatusHoliday, workflowHash, "", "holiday: "+holiday)
+}
+
+func (s *Store) recordSkip(ctx context.Context, job, status, workflowHash, commit, detail string) error {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO runs(job, status, pid, workflow_hash, commit_sha, started_at, heartbeat_at, ended_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+`, job, status, os.Getpid(), workflowHash, commit, now, now, now)
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, "run.skip", job, detail)
+}
+
+// LastRunCommit returns the commit recorded by the most recent successful
+// run of job, or "" when none recorded one. Failed, cancelled and
+// interrupted runs do not count, so a commit that broke the job is retried.
+func (s *Store) LastRunCommit(ctx context.Context, job string) (string, error) {
+	var commit string
+	err := s.db.QueryRowContext(ctx, `
+SELECT commit_sha
+FROM runs
+WHERE job = ? AND commit_sha != '' AND status = 'success'
+ORDER BY started_at DESC, id DESC
+LIMIT 1
+`, job).Scan(&commit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return commit, err
+}
+
+const runSelectColumns = `id, job, status, pid, run_dir, workflow_hash, started_at, heartbeat_at, ended_at, cpu_sec, max_rss_bytes, written_bytes, commit_sha, step, step_total, step_cmd, step_started_at, output_at, stalled`
+
+func scanRun(row rowScanner) (Run, error) {
+	var run Run
+	err := row.Scan(&run.ID, &run.Job, &run.Status, &run.PID, &run.RunDir, &run.WorkflowHash, &run.StartedAt, &run.HeartbeatAt, &run.EndedAt, &run.Usage.CPUSec, &run.Usage.MaxRSSBytes, &run.Usage.WrittenBytes, &run.Commit, &run.Step, &run.StepTotal, &run.StepCmd, &run.StepStartedAt, &run.OutputAt, &run.Stalled)
+	return run, err
+}
+
+// JobUsage totals the resources used by a job's runs.
+type JobUsage struct {
+	Job  string
+	Runs int
+	// Usage sums CPU time and bytes written; MaxRSSBytes is the largest
+	// peak of any run.
+	Usage RunUsage
+}
+
+// UsageByJob totals resource usage per job over the runs started since the
+// given time, heaviest CPU users first.
+func (s *Store) UsageByJob(ctx context.Context, since time.Time) ([]JobUsage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT job, COUNT(*), COALESCE(SUM(cpu_sec), 0), COALESCE(MAX(max_rss_bytes), 0), COALESCE(SUM(written_bytes), 0)
+FROM runs
+WHERE started_at >= ? AND status != ?
+GROUP BY job
+ORDER BY SUM(cpu_sec) DESC, job
+`, since.UTC(), RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []JobUsage
+	for rows.Next() {
+		var u JobUsage
+		if err := rows.Scan(&u.Job, &u.Runs, &u.Usage.CPUSec, &u.Usage.MaxRSSBytes, &u.Usage.WrittenBytes); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// GetRun returns the run with the given ID, or nil if there is none.
+func (s *Store) GetRun(ctx context.Context, id int64) (*Run, error) {
+	run, err := scanRun(s.db.QueryRowContext(ctx, `SELECT `+runSelectColumns+` FROM runs WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// RunningRuns returns runs still marked as running.
+func (s *Store) RunningRuns(ctx context.Context) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+runSelectColumns+`
+FROM runs
+WHERE status = ?
+ORDER BY started_at
+`, RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// RunsBetween returns the finished runs started in [since, until), oldest
+// first.
+func (s *Store) RunsBetween(ctx context.Context, since, until time.Time) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+runSelectColumns+`
+FROM runs
+WHERE started_at >= ? AND started_at < ? AND status != ?
+ORDER BY started_at
+`, since.UTC(), until.UTC(), RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// FindRunningRun resolves ref, either a run ID or a job name, to a run that
+// is still in progress. It returns nil when nothing matches.
+func (s *Store) FindRunningRun(ctx context.Context, ref string) (*Run, error) {
+	query := `SELECT ` + runSelectColumns + ` FROM runs WHERE job = ? AND status = ? ORDER BY started_at DESC LIMIT 1`
+	var arg interface{} = ref
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		query = `SELECT ` + runSelectColumns + ` FROM runs WHERE id = ? AND status = ?`
+		arg = id
+	}
+	run, err := scanRun(s.db.QueryRowContext(ctx, query, arg, RunStatusRunning))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// RequestCancel flags a running execution for cancellation. The owning
+// process picks the flag up on CancelSignal or its next heartbeat.
+func (s *Store) RequestCancel(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE runs SET cancel_requested = 1 WHERE id = ? AND status = ?`, id, RunStatusRunning)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("run %d is not running", id)
+	}
+	return s.record(ctx, "run.cancel", s.runJob(ctx, id), fmt.Sprintf("run %d", id))
+}
+
+// MarkRunInterrupted records that a running execution died without finishing.
+func (s *Store) MarkRunInterrupted(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `
+UPDATE runs SET status = ?, ended_at = ? WHERE id = ? AND status = ?
+`, RunStatusInterrupted, time.Now().UTC(), id, RunStatusRunning)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil
+	}
+	return s.record(ctx, "run.interrupt", s.runJob(ctx, id), fmt.Sprintf("run %d", id))
+}
+
+// runJob returns the job a run belongs to, empty if it is unknown.
+func (s *Store) runJob(ctx context.Context, id int64) string {
+	var job string
+	_ = s.db.QueryRowContext(ctx, `SELECT job FROM runs WHERE id = ?`, id).Scan(&job)
+	return job
+}
+
+// LastFailedRun returns the most recent finished run of job that did not
+// succeed, get cancelled or get skipped, or nil if there is none.
+func (s *Store) LastFailedRun(ctx context.Context, job string) (*Run, error) {
+	run, err := scanRun(s.db.QueryRowContext(ctx, `
+SELECT `+runSelectColumns+`
+FROM runs
+WHERE job = ? AND status NOT IN ('success', ?, ?, ?, ?)
+ORDER BY started_at DESC, id DESC
+LIMIT 1
+`, job, RunStatusRunning, RunStatusCancelled, RunStatusSkipped, RunStatusHoliday))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRunLifecycle(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	done, err := st.BeginRun(ctx, "nightly", "")
+	if err != nil {
+		t.Fatalf("begin run: %v", err)
+	}
+	if err := done.Finish(ctx, "success", "/tmp/run"); err != nil {
+		t.Fatalf("finish run: %v", err)
+	}
+	crashed, err := st.BeginRun(ctx, "nightly", "")
+	if err != nil {
+		t.Fatalf("begin run: %v", err)
+	}
+
+	running, err := st.RunningRuns(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].ID != crashed.ID() {
+		t.Fatalf("expected only the unfinished run, got %+v", running)
+	}
+	if err := st.MarkRunInterrupted(ctx, crashed.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if running, _ := st.RunningRuns(ctx); len(running) != 0 {
+		t.Fatalf("interrupted run still reported as running: %+v", running)
+	}
+	failed, err := st.LastFailedRun(ctx, "nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed == nil || failed.ID != crashed.ID() {
+		t.Fatalf("expected the interrupted run as last failure, got %+v", failed)
+	}
+}
+
+func TestRequestCancel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	tracker, err := st.BeginRun(ctx, "nightly", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled := make(chan struct{})
+	tracker.OnCancel(func() { close(cancelled) })
+
+	run, err := st.FindRunningRun(ctx, "nightly")
+	if err != nil || run == nil || run.ID != tracker.ID() {
+		t.Fatalf("expected to find run by job, got %+v err=%v", run, err)
+	}
+	if byID, err := st.FindRunningRun(ctx, strconv.FormatInt(tracker.ID(), 10)); err != nil || byID == nil {
+		t.Fatalf("expected to find run by id, got %+v err=%v", byID, err)
+	}
+	if err := st.RequestCancel(ctx, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	tracker.checkCancel()
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("cancel callback was not invoked")
+	}
+
+	if err := tracker.Finish(ctx, RunStatusCancelled, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RequestCancel(ctx, run.ID); err == nil {
+		t.Fatal("expected error cancelling a finished run")
+	}
+}
+
+func TestUsageByJob(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	for _, u := range []RunUsage{{CPUSec: 2, MaxRSSBytes: 100, WrittenBytes: 10}, {CPUSec: 3, MaxRSSBytes: 50, WrittenBytes: 5}} {
+		tracker, err := st.BeginRun(ctx, "build", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tracker.RecordUsage(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		if err := tracker.Finish(ctx, "success", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.BeginRun(ctx, "lint", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := st.UsageByJob(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := JobUsage{Job: "build", Runs: 2, Usage: RunUsage{CPUSec: 5, MaxRSSBytes: 100, WrittenBytes: 15}}
+	if len(usage) != 1 || usage[0] != want {
+		t.Fatalf("got %+v, want only %+v (running runs excluded)", usage, want)
+	}
+}
+
+func TestTestHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	coverage := 81.5
+	for i, tests := range []*RunTests{{Passed: 10, Failed: 2}, nil, {Passed: 12, Skipped: 1, Coverage: &coverage}} {
+		run, err := st.BeginRun(ctx, "nightly", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tests != nil {
+			if err := run.RecordTests(ctx, *tests); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := run.Finish(ctx, "success", "/tmp/run"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := st.TestHistory(ctx, "nightly", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected the 2 runs with test results, got %+v", history)
+	}
+	newest, oldest := history[0].Tests, history[1].Tests
+	if newest.Passed != 12 || newest.Skipped != 1 || newest.Coverage == nil || *newest.Coverage != 81.5 {
+		t.Fatalf("unexpected newest run %+v", newest)
+	}
+	if oldest.Failed != 2 || oldest.Coverage != nil {
+		t.Fatalf("unexpected oldest run %+v", oldest)
+	}
+}
+
+func TestBenchHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		run, err := st.BeginRun(ctx, "bench", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := []BenchResult{{Name: "BenchmarkParse", Unit: "ns/op", Value: float64(i * 100)}, {Name: "BenchmarkParse", Unit: "B/op", Value: 64}}
+		if err := run.RecordBenchmarks(ctx, "bench", results); err != nil {
+			t.Fatal(err)
+		}
+		if err := run.Finish(ctx, "success", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := st.BenchHistory(ctx, "bench", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || len(history[0].Results) != 2 {
+		t.Fatalf("expected the last 2 runs with 2 results each, got %+v", history)
+	}
+	if history[0].Results[0].Value != 300 || history[1].Results[0].Value != 200 {
+		t.Fatalf("expected newest first, got %+v", history)
+	}
+}
+
+func TestConcurrentStoresDoNotLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctx := context.Background()
+	stores := make([]*Store, 4)
+	for i := range stores {
+		st, err := Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		stores[i] = st
+	}
+	var mode string
+	if err := stores[0].db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("journal mode %q, err=%v", mode, err)
+	}
+
+	// Each store stands in for a process: the daemon and CLI commands
+	// writing the same file at once.
+	errs := make(chan error, len(stores))
+	for i, st := range stores {
+		go func(i int, st *Store) {
+			job := "job" + strconv.Itoa(i)
+			for n := 0; n < 20; n++ {
+				run, err := st.BeginRun(ctx, job, "")
+				if err == nil {
+					err = run.Finish(ctx, "success", "")
+				}
+				if err == nil {
+					err = st.UpdateRunResult(ctx, job, "success", time.Now())
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(i, st)
+	}
+	for range stores {
+		if err := <-errs; err != nil {
+			t.Fatalf("concurrent write: %v", err)
+		}
+	}
+}
+
+func TestRenameAndMoveJob(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	if err := st.UpsertJob(ctx, NewJob("tests", "/src/api", "0 2 * * *", "", "", "/src/api/.devagent.yml")); err != nil {
+		t.Fatal(err)
+	}
+	downstream := NewJob("deploy", "/src/api", "", "", "", "/src/api/deploy.yml")
+	downstream.after = "tests"
+	if err := st.UpsertJob(ctx, downstream); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SaveOutputs(ctx, "tests", map[string]string{"version": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := st.BeginRun(ctx, "tests", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Finish(ctx, "success", "/src/api/devagent_runs/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.RenameJob(ctx, "tests", "deploy"); !errors.Is(err, ErrJobExists) {
+		t.Fatalf("expected ErrJobExists, got %v", err)
+	}
+	if err := st.RenameJob(ctx, "nope", "other"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+	if err := st.RenameJob(ctx, "tests", "checks"); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := st.GetJob(ctx, "tests"); job != nil {
+		t.Fatal("the old name is still registered")
+	}
+	if job, _ := st.GetJob(ctx, "deploy"); job == nil || job.After() != "checks" {
+		t.Fatalf("dependent not updated: %+v", job)
+	}
+	if outputs, _ := st.OutputsFor(ctx, []string{"checks"}); outputs["checks"]["version"] != "1" {
+		t.Fatalf("outputs not renamed: %v", outputs)
+	}
+	moved, err := st.GetRun(ctx, run.ID())
+	if err != nil || moved.Job != "checks" {
+		t.Fatalf("run not renamed: %+v %v", moved, err)
+	}
+
+	if err := st.MoveJob(ctx, "checks", "/home/me/api", "/home/me/api/.devagent.yml"); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := st.GetJob(ctx, "checks")
+	if job.Repo != "/home/me/api" || job.YAMLPath() != "/home/me/api/.devagent.yml" {
+		t.Fatalf("job not moved: %+v", job)
+	}
+	if moved, _ := st.GetRun(ctx, run.ID()); moved.RunDir != "/home/me/api/devagent_runs/1" {
+		t.Fatalf("run directory not moved: %q", moved.RunDir)
+	}
+	if err := st.MoveJob(ctx, "nope", "/x", "/x/y.yml"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestBulkChangesAreAllOrNothing(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	for _, name := range []string{"a", "b"} {
+		if err := st.UpsertJob(ctx, NewJob(name, "/repo", "0 2 * * *", "", "", "/repo/"+name+".yml")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := st.SetPausedJobs(ctx, []string{"a", "missing"}, true); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+	if job, _ := st.GetJob(ctx, "a"); job.Paused {
+		t.Fatal("a was paused although the bulk pause failed")
+	}
+	if err := st.SetPausedJobs(ctx, []string{"a", "b"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RemoveJobs(ctx, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, _ := st.ListJobs(ctx); len(jobs) != 0 {
+		t.Fatalf("expected no jobs, got %+v", jobs)
+	}
+}
+
+func TestRecordProgress(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+
+	tracker, err := st.BeginRun(ctx, "nightly", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quietSince := time.Now().Add(-20 * time.Minute)
+	stepStart := quietSince.Add(-time.Minute)
+	if err := tracker.RecordProgress(ctx, StepProgress{Step: 2, Total: 5, Cmd: "make test", StartedAt: stepStart, LastOutput: quietSince, Stalled: true}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := st.FindRunningRun(ctx, "nightly")
+	if err != nil || run == nil {
+		t.Fatalf("find running run: %v %v", run, err)
+	}
+	if run.Step != 2 || run.StepTotal != 5 || run.StepCmd != "make test" || !run.StepStartedAt.Time.Equal(stepStart.UTC()) || !run.Stalled || !run.OutputAt.Valid || !run.OutputAt.Time.Equal(quietSince.UTC()) {
+		t.Fatalf("progress not recorded: %+v", run)
+	}
+	if err := (*RunTracker)(nil).RecordProgress(ctx, StepProgress{Step: 1}); err != nil {
+		t.Fatalf("nil tracker: %v", err)
+	}
+	_ = tracker.Finish(ctx, "success", "")
+}
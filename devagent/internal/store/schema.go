@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+)
+
+// SchemaInfo describes the schema of a store file as found on disk.
+type SchemaInfo struct {
+	// Exists is false when no store has been created at the path yet.
+	Exists bool
+	// Version is the schema version recorded in the store, 0 for stores
+	// from before versions were recorded.
+	Version int
+	// Missing lists the tables and columns, as table.column, that opening
+	// the store with this binary would add.
+	Missing []string
+}
+
+// NeedsMigration reports whether opening the store with this binary
+// upgrades its schema.
+func (i SchemaInfo) NeedsMigration() bool {
+	return i.Exists && (i.Version < SchemaVersion || len(i.Missing) > 0)
+}
+
+// Compatible reports whether this binary can use the store: it is not from
+// a newer devagent whose schema this binary does not know.
+func (i SchemaInfo) Compatible() bool {
+	return i.Version <= SchemaVersion
+}
+
+// schemaTables are the tables ensureSchema creates.
+var schemaTables = []string{"jobs", "job_outputs", "runs", "workflow_revisions", "bench_results", "audit_log", "digests", "llm_calls", "daemon_lock"}
+
+// InspectSchema reads the schema of the store at path without changing it.
+// Unlike Open, it does not create or migrate the store.
+func InspectSchema(ctx context.Context, path string) (SchemaInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return SchemaInfo{}, nil
+		}
+		return SchemaInfo{}, err
+	}
+	db, err := sql.Open("sqlite", dsn(path, "query_only(true)"))
+	if err != nil {
+		return SchemaInfo{}, err
+	}
+	defer db.Close()
+
+	info := SchemaInfo{Exists: true}
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&info.Version); err != nil {
+		return SchemaInfo{}, err
+	}
+	for _, table := range schemaTables {
+		columns, err := tableColumns(ctx, db, table)
+		if err != nil {
+			return SchemaInfo{}, err
+		}
+		if len(columns) == 0 {
+			info.Missing = append(info.Missing, table)
+			continue
+		}
+		var migrations []columnMigration
+		switch table {
+		case "jobs":
+			migrations = jobMigrations
+		case "runs":
+			migrations = runMigrations
+		}
+		for _, m := range migrations {
+			if !columns[m.column] {
+				info.Missing = append(info.Missing, table+"."+m.column)
+			}
+		}
+	}
+	return info, nil
+}
+
+// tableColumns returns the columns of table, none if it does not exist.
+func tableColumns(ctx context.Context, db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectSchema(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctx := context.Background()
+	path, err := StatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := InspectSchema(ctx, path); err != nil || info.Exists || info.NeedsMigration() || !info.Compatible() {
+		t.Fatalf("missing store: %+v %v", info, err)
+	}
+
+	// A store from before versions were recorded, lacking a later column.
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", dsn(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE jobs (name TEXT PRIMARY KEY, repo TEXT NOT NULL, cron TEXT NOT NULL, natural TEXT, timezone TEXT, yaml_path TEXT NOT NULL, last_status TEXT, last_run TIMESTAMP, updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	info, err := InspectSchema(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 0 || !info.NeedsMigration() || !info.Compatible() || info.Missing[0] != "jobs.failure_streak" {
+		t.Fatalf("old store: %+v", info)
+	}
+	if info, _ := InspectSchema(ctx, path); len(info.Missing) == 0 {
+		t.Fatal("inspecting the store migrated it")
+	}
+
+	st, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+	info, err = InspectSchema(ctx, path)
+	if err != nil || info.Version != SchemaVersion || info.NeedsMigration() || len(info.Missing) > 0 {
+		t.Fatalf("after Open: %+v %v", info, err)
+	}
+
+	// A newer binary's store keeps its version and is reported incompatible.
+	db, err = sql.Open("sqlite", dsn(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, SchemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if st, err = Open(); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+	if info, _ = InspectSchema(ctx, path); info.Version != SchemaVersion+1 || info.Compatible() {
+		t.Fatalf("newer store: %+v", info)
+	}
+}
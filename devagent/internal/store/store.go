@@ -4,13 +4,25 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
+
+	"devagent/internal/dsl"
+	"devagent/internal/paths"
 )
 
+// ErrJobNotFound is returned when an operation names an unknown job.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobExists is returned when renaming a job to a name already in use.
+var ErrJobExists = errors.New("job already exists")
+
 // Store wraps the SQLite database used by the daemon.
 type Store struct {
 	db *sql.DB
@@ -24,9 +36,21 @@ type Job struct {
 	natural    string
 	timezone   string
 	yamlPath   string
+	after      string
+	at         string
+	every      string
+	calendar   string
 	LastStatus sql.NullString
 	LastRun    sql.NullTime
 	UpdatedAt  time.Time
+	// FailureStreak counts consecutive failed runs; it resets on success and
+	// is left unchanged by cancelled and skipped runs.
+	FailureStreak int
+	// Paused jobs stay registered but are not scheduled by the daemon.
+	Paused bool
+	// ApprovedHash is the hash of the workflow file content last approved
+	// through devagent; the daemon refuses to run other content.
+	ApprovedHash string
 }
 
 // NewJob constructs a Job instance.
@@ -41,6 +65,16 @@ func NewJob(name, repo, cron, natural, timezone, yamlPath string) Job {
 	}
 }
 
+// JobFromWorkflow builds the registry entry for a workflow saved at yamlPath.
+func JobFromWorkflow(wf *dsl.Workflow, yamlPath string) Job {
+	job := NewJob(wf.Name, wf.Repo, wf.Schedule.Cron, wf.Schedule.Natural, wf.Schedule.Timezone, yamlPath)
+	job.after = wf.Schedule.After
+	job.at = wf.Schedule.At
+	job.every = wf.Schedule.Every
+	job.calendar = wf.Schedule.Calendar
+	return job
+}
+
 // Cron returns the cron specification.
 func (j Job) Cron() string { return j.cron }
 
@@ -53,18 +87,31 @@ func (j Job) Timezone() string { return j.timezone }
 // YAMLPath returns the workflow file path.
 func (j Job) YAMLPath() string { return j.yamlPath }
 
-// Open initialises the database at the default path.
+// After returns the upstream job this job depends on, if any.
+func (j Job) After() string { return j.after }
+
+// At returns the one-shot run time for jobs that fire exactly once.
+func (j Job) At() string { return j.at }
+
+// Every returns the fixed run interval for interval-scheduled jobs.
+func (j Job) Every() string { return j.every }
+
+// Calendar returns the .ics source whose events schedule the job.
+func (j Job) Calendar() string { return j.calendar }
+
+// Open initialises the database at StatePath.
 func Open() (*Store, error) {
-	home, err := os.UserHomeDir()
+	dbPath, err := StatePath()
 	if err != nil {
 		return nil, err
 	}
-	dir := filepath.Join(home, ".devagent")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
 		return nil, err
 	}
-	dbPath := filepath.Join(dir, "state.db")
-	db, err := sql.Open("sqlite", dbPath)
+	// WAL lets the daemon and CLI commands read while another process
+	// writes; journal_mode is recorded in the file, the other settings are
+	// per connection.
+	db, err := sql.Open("sqlite", dsn(dbPath, "journal_mode(WAL)", "synchronous(NORMAL)"))
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +123,24 @@ func Open() (*Store, error) {
 	return store, nil
 }
 
+// busyTimeout is how long a connection waits for another process's write
+// lock before failing with "database is locked".
+const busyTimeout = 10 * time.Second
+
+// dsn returns the data source name for the database at path with the
+// busy timeout and the given extra pragmas. Transactions take the write
+// lock when they begin, so one that reads before writing cannot fail
+// midway on a lock the busy timeout does not retry.
+func dsn(path string, pragmas ...string) string {
+	q := url.Values{}
+	q.Set("_txlock", "immediate")
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	for _, pragma := range pragmas {
+		q.Add("_pragma", pragma)
+	}
+	return path + "?" + q.Encode()
+}
+
 // Close releases the underlying database.
 func (s *Store) Close() error {
 	if s == nil || s.db == nil {
@@ -97,33 +162,245 @@ last_status TEXT,
 last_run TIMESTAMP,
 updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
+CREATE TABLE IF NOT EXISTS job_outputs (
+job TEXT NOT NULL,
+key TEXT NOT NULL,
+value TEXT NOT NULL,
+updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+PRIMARY KEY (job, key)
+);
+CREATE TABLE IF NOT EXISTS runs (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+job TEXT NOT NULL,
+status TEXT NOT NULL,
+pid INTEGER NOT NULL,
+run_dir TEXT NOT NULL DEFAULT '',
+started_at TIMESTAMP NOT NULL,
+heartbeat_at TIMESTAMP NOT NULL,
+ended_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS runs_job_started ON runs(job, started_at);
+CREATE TABLE IF NOT EXISTS workflow_revisions (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+job TEXT NOT NULL,
+hash TEXT NOT NULL,
+content TEXT NOT NULL,
+source TEXT NOT NULL,
+created_at TIMESTAMP NOT NULL,
+loaded_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS workflow_revisions_job ON workflow_revisions(job, id);
+CREATE TABLE IF NOT EXISTS bench_results (
+run_id INTEGER NOT NULL,
+job TEXT NOT NULL,
+name TEXT NOT NULL,
+unit TEXT NOT NULL,
+value REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bench_results_job ON bench_results(job, run_id);
+CREATE TABLE IF NOT EXISTS audit_log (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+at TIMESTAMP NOT NULL,
+actor TEXT NOT NULL,
+source TEXT NOT NULL,
+action TEXT NOT NULL,
+job TEXT NOT NULL DEFAULT '',
+detail TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_job ON audit_log(job, id);
+CREATE TABLE IF NOT EXISTS digests (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+period_start TIMESTAMP NOT NULL,
+period_end TIMESTAMP NOT NULL,
+sent_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS llm_calls (
+id INTEGER PRIMARY KEY AUTOINCREMENT,
+at TIMESTAMP NOT NULL,
+model TEXT NOT NULL,
+purpose TEXT NOT NULL,
+input_tokens INTEGER NOT NULL,
+output_tokens INTEGER NOT NULL,
+cost_usd REAL
+);
+CREATE INDEX IF NOT EXISTS llm_calls_at ON llm_calls(at);
+CREATE TABLE IF NOT EXISTS daemon_lock (
+id INTEGER PRIMARY KEY CHECK (id = 1),
+pid INTEGER NOT NULL,
+host TEXT NOT NULL,
+started_at TIMESTAMP NOT NULL,
+heartbeat_at TIMESTAMP NOT NULL,
+released INTEGER NOT NULL DEFAULT 0,
+contender_pid INTEGER NOT NULL DEFAULT 0,
+contender_read_only INTEGER NOT NULL DEFAULT 0,
+contended_at TIMESTAMP
+);
 `)
+	if err != nil {
+		return err
+	}
+	if err := s.migrateColumns("jobs", jobMigrations); err != nil {
+		return err
+	}
+	if err := s.migrateColumns("runs", runMigrations); err != nil {
+		return err
+	}
+	// Record the schema version, never lowering one written by a newer
+	// binary.
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version < SchemaVersion {
+		_, err = s.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, SchemaVersion))
+	}
 	return err
 }
 
+// SchemaVersion is the version of the store schema this binary creates,
+// recorded in the database's user_version. Bump it with every change to
+// ensureSchema or the migrations below; stores from before versions were
+// recorded read as version 0.
+const SchemaVersion = 2
+
+// columnMigration adds a column to an existing table.
+type columnMigration struct {
+	column string
+	ddl    string
+}
+
+// jobMigrations lists columns added after the initial schema. Existing
+// databases are upgraded in place by adding whichever columns are missing.
+var jobMigrations = []columnMigration{
+	{column: "failure_streak", ddl: "failure_streak INTEGER NOT NULL DEFAULT 0"},
+	{column: "paused", ddl: "paused INTEGER NOT NULL DEFAULT 0"},
+	{column: "after_job", ddl: "after_job TEXT NOT NULL DEFAULT ''"},
+	{column: "run_at", ddl: "run_at TEXT NOT NULL DEFAULT ''"},
+	{column: "every_interval", ddl: "every_interval TEXT NOT NULL DEFAULT ''"},
+	{column: "calendar", ddl: "calendar TEXT NOT NULL DEFAULT ''"},
+	{column: "approved_hash", ddl: "approved_hash TEXT NOT NULL DEFAULT ''"},
+}
+
+// runMigrations lists columns added to the runs table.
+var runMigrations = []columnMigration{
+	{column: "cancel_requested", ddl: "cancel_requested INTEGER NOT NULL DEFAULT 0"},
+	{column: "workflow_hash", ddl: "workflow_hash TEXT NOT NULL DEFAULT ''"},
+	{column: "cpu_sec", ddl: "cpu_sec REAL NOT NULL DEFAULT 0"},
+	{column: "max_rss_bytes", ddl: "max_rss_bytes INTEGER NOT NULL DEFAULT 0"},
+	{column: "written_bytes", ddl: "written_bytes INTEGER NOT NULL DEFAULT 0"},
+	{column: "commit_sha", ddl: "commit_sha TEXT NOT NULL DEFAULT ''"},
+	{column: "tests_passed", ddl: "tests_passed INTEGER"},
+	{column: "tests_failed", ddl: "tests_failed INTEGER"},
+	{column: "tests_skipped", ddl: "tests_skipped INTEGER"},
+	{column: "coverage", ddl: "coverage REAL"},
+	{column: "step", ddl: "step INTEGER NOT NULL DEFAULT 0"},
+	{column: "step_total", ddl: "step_total INTEGER NOT NULL DEFAULT 0"},
+	{column: "step_cmd", ddl: "step_cmd TEXT NOT NULL DEFAULT ''"},
+	{column: "step_started_at", ddl: "step_started_at TIMESTAMP"},
+	{column: "output_at", ddl: "output_at TIMESTAMP"},
+	{column: "stalled", ddl: "stalled INTEGER NOT NULL DEFAULT 0"},
+}
+
+func (s *Store) migrateColumns(table string, migrations []columnMigration) error {
+	rows, err := s.db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]struct{})
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = struct{}{}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := existing[m.column]; ok {
+			continue
+		}
+		if _, err := s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + m.ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const jobSelectColumns = `name, repo, cron, natural, timezone, yaml_path, last_status, last_run, updated_at, failure_streak, paused, after_job, run_at, every_interval, calendar, approved_hash`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(&job.Name, &job.Repo, &job.cron, &job.natural, &job.timezone, &job.yamlPath, &job.LastStatus, &job.LastRun, &job.UpdatedAt, &job.FailureStreak, &job.Paused, &job.after, &job.at, &job.every, &job.calendar, &job.ApprovedHash)
+	return job, err
+}
+
 // UpsertJob stores or updates a job definition.
 func (s *Store) UpsertJob(ctx context.Context, job Job) error {
 	if s == nil {
 		return errors.New("store is nil")
 	}
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO jobs(name, repo, cron, natural, timezone, yaml_path, updated_at)
-VALUES(?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	previous, err := scanJob(tx.QueryRowContext(ctx, `SELECT `+jobSelectColumns+` FROM jobs WHERE name = ?`, job.Name))
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO jobs(name, repo, cron, natural, timezone, yaml_path, after_job, run_at, every_interval, calendar, updated_at)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 ON CONFLICT(name) DO UPDATE SET
 repo=excluded.repo,
 cron=excluded.cron,
 natural=excluded.natural,
 timezone=excluded.timezone,
 yaml_path=excluded.yaml_path,
+after_job=excluded.after_job,
+run_at=excluded.run_at,
+every_interval=excluded.every_interval,
+calendar=excluded.calendar,
 updated_at=CURRENT_TIMESTAMP;
-`, job.Name, job.Repo, job.cron, job.natural, job.timezone, job.yamlPath)
-	return err
+`, job.Name, job.Repo, job.cron, job.natural, job.timezone, job.yamlPath, job.after, job.at, job.every, job.calendar)
+	if err != nil {
+		return err
+	}
+	action, detail := "job.create", job.yamlPath
+	if exists {
+		action, detail = "job.update", strings.Join(Differences(previous, job), ", ")
+	}
+	event, err := audit(ctx, tx, action, job.Name, detail)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forwardAudit(event)
+	return nil
 }
 
 // ListJobs returns all jobs.
 func (s *Store) ListJobs(ctx context.Context) ([]Job, error) {
 	rows, err := s.db.QueryContext(ctx, `
-SELECT name, repo, cron, natural, timezone, yaml_path, last_status, last_run, updated_at
+SELECT `+jobSelectColumns+`
 FROM jobs
 ORDER BY name
 `)
@@ -134,8 +411,8 @@ ORDER BY name
 
 	var jobs []Job
 	for rows.Next() {
-		var job Job
-		if err := rows.Scan(&job.Name, &job.Repo, &job.cron, &job.natural, &job.timezone, &job.yamlPath, &job.LastStatus, &job.LastRun, &job.UpdatedAt); err != nil {
+		job, err := scanJob(rows)
+		if err != nil {
 			return nil, err
 		}
 		jobs = append(jobs, job)
@@ -143,29 +420,259 @@ ORDER BY name
 	return jobs, rows.Err()
 }
 
-// RemoveJob deletes a job by name.
+// RemoveJob deletes a job by name along with its published outputs and
+// workflow history.
 func (s *Store) RemoveJob(ctx context.Context, name string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE name = ?`, name)
-	return err
+	return s.RemoveJobs(ctx, []string{name})
+}
+
+// RemoveJobs deletes several jobs like RemoveJob, all or none of them.
+func (s *Store) RemoveJobs(ctx context.Context, names []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var events []AuditEvent
+	for _, name := range names {
+		for _, query := range []string{
+			`DELETE FROM job_outputs WHERE job = ?`,
+			`DELETE FROM workflow_revisions WHERE job = ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, name); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM jobs WHERE name = ?`, name)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			continue
+		}
+		event, err := audit(ctx, tx, "job.remove", name, "")
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forwardAudit(events...)
+	return nil
+}
+
+// RenameJob renames a job together with its outputs, workflow history and
+// runs, and points the jobs that run after it at the new name.
+func (s *Store) RenameJob(ctx context.Context, oldName, newName string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM jobs WHERE name = ?`, newName).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("%w: %s", ErrJobExists, newName)
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE jobs SET name = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?`, newName, oldName)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, oldName)
+	}
+	for _, query := range []string{
+		`UPDATE job_outputs SET job = ? WHERE job = ?`,
+		`UPDATE workflow_revisions SET job = ? WHERE job = ?`,
+		`UPDATE runs SET job = ? WHERE job = ?`,
+		`UPDATE bench_results SET job = ? WHERE job = ?`,
+		`UPDATE jobs SET after_job = ? WHERE after_job = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, newName, oldName); err != nil {
+			return err
+		}
+	}
+	event, err := audit(ctx, tx, "job.rename", newName, "renamed from "+oldName)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forwardAudit(event)
+	return nil
+}
+
+// MoveJob points a job at a new repo and workflow file. Run directories
+// under the old repo are rewritten to the same place under the new one.
+func (s *Store) MoveJob(ctx context.Context, name, repo, yamlPath string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var oldRepo string
+	if err := tx.QueryRowContext(ctx, `SELECT repo FROM jobs WHERE name = ?`, name).Scan(&oldRepo); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE jobs SET repo = ?, yaml_path = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?`, repo, yamlPath, name); err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, run_dir FROM runs WHERE job = ? AND run_dir != ''`, name)
+	if err != nil {
+		return err
+	}
+	moved := make(map[int64]string)
+	pathMap := map[string]string{oldRepo: repo}
+	for rows.Next() {
+		var id int64
+		var runDir string
+		if err := rows.Scan(&id, &runDir); err != nil {
+			rows.Close()
+			return err
+		}
+		if mapped := mapPath(runDir, pathMap); mapped != runDir {
+			moved[id] = mapped
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, runDir := range moved {
+		if _, err := tx.ExecContext(ctx, `UPDATE runs SET run_dir = ? WHERE id = ?`, runDir, id); err != nil {
+			return err
+		}
+	}
+	event, err := audit(ctx, tx, "job.move", name, oldRepo+" -> "+repo)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forwardAudit(event)
+	return nil
+}
+
+// SaveOutputs replaces the outputs a job publishes to downstream jobs.
+func (s *Store) SaveOutputs(ctx context.Context, name string, outputs map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM job_outputs WHERE job = ?`, name); err != nil {
+		return err
+	}
+	for key, value := range outputs {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO job_outputs(job, key, value) VALUES(?, ?, ?)`, name, key, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// OutputsFor returns the last published outputs of the named jobs, keyed by
+// job then output name.
+func (s *Store) OutputsFor(ctx context.Context, names []string) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string, len(names))
+	for _, name := range names {
+		rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM job_outputs WHERE job = ?`, name)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]string)
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			values[key] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		out[name] = values
+	}
+	return out, nil
 }
 
-// UpdateRunResult stores the outcome of a job run.
+// UpdateRunResult stores the outcome of a job run and maintains the failure streak.
 func (s *Store) UpdateRunResult(ctx context.Context, name, status string, runAt time.Time) error {
 	_, err := s.db.ExecContext(ctx, `
-UPDATE jobs SET last_status = ?, last_run = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?
-`, status, runAt.UTC(), name)
+UPDATE jobs SET
+last_status = ?,
+last_run = ?,
+failure_streak = CASE ? WHEN 'success' THEN 0 WHEN 'cancelled' THEN failure_streak WHEN 'skipped' THEN failure_streak WHEN 'skipped(holiday)' THEN failure_streak WHEN 'unapproved' THEN failure_streak ELSE failure_streak + 1 END,
+updated_at = CURRENT_TIMESTAMP
+WHERE name = ?
+`, status, runAt.UTC(), status, name)
 	return err
 }
 
+// SetPaused pauses or resumes a job. Resuming also clears the failure streak
+// so backoff starts fresh.
+func (s *Store) SetPaused(ctx context.Context, name string, paused bool) error {
+	return s.SetPausedJobs(ctx, []string{name}, paused)
+}
+
+// SetPausedJobs pauses or resumes several jobs like SetPaused; if any of
+// them is unknown, none is changed.
+func (s *Store) SetPausedJobs(ctx context.Context, names []string, paused bool) error {
+	query := `UPDATE jobs SET paused = 1, updated_at = CURRENT_TIMESTAMP WHERE name = ?`
+	if !paused {
+		query = `UPDATE jobs SET paused = 0, failure_streak = 0, updated_at = CURRENT_TIMESTAMP WHERE name = ?`
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	action := "job.pause"
+	if !paused {
+		action = "job.resume"
+	}
+	var events []AuditEvent
+	for _, name := range names {
+		res, err := tx.ExecContext(ctx, query, name)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+		}
+		event, err := audit(ctx, tx, action, name, "")
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forwardAudit(events...)
+	return nil
+}
+
 // GetJob fetches a job by name.
 func (s *Store) GetJob(ctx context.Context, name string) (*Job, error) {
 	row := s.db.QueryRowContext(ctx, `
-SELECT name, repo, cron, natural, timezone, yaml_path, last_status, last_run, updated_at
+SELECT `+jobSelectColumns+`
 FROM jobs
 WHERE name = ?
 `, name)
-	var job Job
-	if err := row.Scan(&job.Name, &job.Repo, &job.cron, &job.natural, &job.timezone, &job.yamlPath, &job.LastStatus, &job.LastRun, &job.UpdatedAt); err != nil {
+	job, err := scanJob(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -181,22 +688,26 @@ func (s *Store) JobsForSchedule(ctx context.Context) ([]Job, error) {
 
 // LocksDir returns the directory used for lock files.
 func LocksDir() (string, error) {
-	home, err := os.UserHomeDir()
+	state, err := paths.StateDir()
 	if err != nil {
 		return "", err
 	}
-	dir := filepath.Join(home, ".devagent", "locks")
+	dir := filepath.Join(state, "locks")
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return "", err
 	}
 	return dir, nil
 }
 
-// StatePath returns the database path for documentation.
+// StatePath returns the database path: $DEVAGENT_STORE (set by the global
+// --store flag) or state.db in the state directory.
 func StatePath() (string, error) {
-	home, err := os.UserHomeDir()
+	if path := os.Getenv("DEVAGENT_STORE"); path != "" {
+		return filepath.Abs(path)
+	}
+	dir, err := paths.StateDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".devagent", "state.db"), nil
+	return filepath.Join(dir, "state.db"), nil
 }
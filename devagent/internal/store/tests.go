@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RunTests is the test and coverage totals parsed from a run's reports.
+type RunTests struct {
+	Passed  int `json:"passed" yaml:"passed"`
+	Failed  int `json:"failed" yaml:"failed"`
+	Skipped int `json:"skipped" yaml:"skipped"`
+	// Coverage is a percentage, nil when the run had no coverage report.
+	Coverage *float64 `json:"coverage,omitempty" yaml:"coverage,omitempty"`
+}
+
+// TestRun is a run that recorded test results.
+type TestRun struct {
+	ID        int64
+	Status    string
+	StartedAt time.Time
+	Tests     RunTests
+}
+
+// RecordTests stores the test results parsed from the run's reports. It is
+// a no-op on a nil tracker.
+func (t *RunTracker) RecordTests(ctx context.Context, tests RunTests) error {
+	if t == nil {
+		return nil
+	}
+	_, err := t.store.db.ExecContext(ctx, `
+UPDATE runs SET tests_passed = ?, tests_failed = ?, tests_skipped = ?, coverage = ? WHERE id = ?
+`, tests.Passed, tests.Failed, tests.Skipped, tests.Coverage, t.id)
+	return err
+}
+
+// TestHistory returns up to limit of job's finished runs that recorded test
+// results, newest first.
+func (s *Store) TestHistory(ctx context.Context, job string, limit int) ([]TestRun, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, status, started_at, tests_passed, tests_failed, tests_skipped, coverage
+FROM runs
+WHERE job = ? AND tests_passed IS NOT NULL AND status != ?
+ORDER BY started_at DESC, id DESC
+LIMIT ?
+`, job, RunStatusRunning, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []TestRun
+	for rows.Next() {
+		var (
+			run      TestRun
+			coverage sql.NullFloat64
+		)
+		if err := rows.Scan(&run.ID, &run.Status, &run.StartedAt, &run.Tests.Passed, &run.Tests.Failed, &run.Tests.Skipped, &coverage); err != nil {
+			return nil, err
+		}
+		if coverage.Valid {
+			run.Tests.Coverage = &coverage.Float64
+		}
+		out = append(out, run)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,59 @@
+// Package sysload reads the system load average and free disk space that
+// jobs can require before the daemon starts them.
+package sysload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Average returns the one-minute load average, from /proc/loadavg on Linux
+// and `sysctl vm.loadavg` on macOS.
+func Average(ctx context.Context) (float64, error) {
+	var text string
+	if runtime.GOOS == "darwin" {
+		out, err := exec.CommandContext(ctx, "sysctl", "-n", "vm.loadavg").Output()
+		if err != nil {
+			return 0, fmt.Errorf("sysctl: %w", err)
+		}
+		text = string(out)
+	} else {
+		data, err := os.ReadFile("/proc/loadavg")
+		if err != nil {
+			return 0, err
+		}
+		text = string(data)
+	}
+	return parseLoad(text)
+}
+
+// parseLoad takes the first number of "0.52 0.58 0.59 ..." or the sysctl
+// form "{ 0.52 0.58 0.59 }".
+func parseLoad(text string) (float64, error) {
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(text), "{}"))
+	if len(fields) == 0 {
+		return 0, errors.New("empty load average")
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse load average %q: %w", fields[0], err)
+	}
+	return load, nil
+}
+
+// FreeDisk returns the bytes available to unprivileged users on the
+// filesystem holding path.
+func FreeDisk(path string) (int64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), nil
+}
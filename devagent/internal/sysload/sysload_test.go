@@ -0,0 +1,30 @@
+package sysload
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseLoad(t *testing.T) {
+	for text, want := range map[string]float64{
+		"0.52 0.58 0.59 1/467 12345\n": 0.52,
+		"{ 3.10 2.05 1.90 }\n":         3.10,
+	} {
+		got, err := parseLoad(text)
+		if err != nil || got != want {
+			t.Errorf("parseLoad(%q) = %v, %v; want %v", text, got, err, want)
+		}
+	}
+	if _, err := parseLoad(""); err == nil {
+		t.Error("expected an error for empty input")
+	}
+}
+
+func TestProbesReadThisMachine(t *testing.T) {
+	if _, err := Average(context.Background()); err != nil {
+		t.Fatalf("load average: %v", err)
+	}
+	if free, err := FreeDisk(t.TempDir()); err != nil || free <= 0 {
+		t.Fatalf("free disk = %d, %v", free, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package templates holds the built-in workflows `devagent templates apply`
+// creates for a repo without planning them from a specification.
+package templates
+
+import (
+	"embed"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"devagent/internal/dsl"
+)
+
+// The template files are workflows without a name or repo; meta.spec
+// doubles as the description `devagent templates list` shows.
+//
+//go:embed *.yml
+var files embed.FS
+
+// Template describes a built-in workflow.
+type Template struct {
+	Name        string
+	Description string
+}
+
+// List returns the built-in templates sorted by name.
+func List() []Template {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil
+	}
+	var out []Template
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".yml")
+		wf, err := load(name)
+		if err != nil {
+			continue
+		}
+		tmpl := Template{Name: name}
+		if wf.Meta != nil {
+			tmpl.Description = wf.Meta.Spec
+		}
+		out = append(out, tmpl)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Names returns the names of the built-in templates.
+func Names() []string {
+	var names []string
+	for _, tmpl := range List() {
+		names = append(names, tmpl.Name)
+	}
+	return names
+}
+
+// Render returns the template called name as a workflow for repo, named
+// after the repo's directory and the template, e.g. api-npm-audit.
+func Render(name, repo string) (*dsl.Workflow, error) {
+	wf, err := load(name)
+	if err != nil {
+		return nil, err
+	}
+	wf.Name = filepath.Base(repo) + "-" + name
+	wf.Repo = repo
+	if wf.Meta == nil {
+		wf.Meta = &dsl.Meta{}
+	}
+	wf.Meta.Planner = "template " + name
+	wf.Meta.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := wf.Validate(); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return wf, nil
+}
+
+func load(name string) (*dsl.Workflow, error) {
+	data, err := files.ReadFile(name + ".yml")
+	if err != nil || strings.ContainsAny(name, "/.") {
+		return nil, fmt.Errorf("unknown template %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	var wf dsl.Workflow
+	if err := yaml.Unmarshal(data, &wf); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return &wf, nil
+}
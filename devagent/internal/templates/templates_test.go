@@ -0,0 +1,41 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderAll(t *testing.T) {
+	list := List()
+	if len(list) < 5 {
+		t.Fatalf("expected the built-in templates, got %+v", list)
+	}
+	for _, tmpl := range list {
+		if tmpl.Description == "" {
+			t.Errorf("%s has no description", tmpl.Name)
+		}
+		wf, err := Render(tmpl.Name, "/src/api")
+		if err != nil {
+			t.Errorf("%s: %v", tmpl.Name, err)
+			continue
+		}
+		if wf.Name != "api-"+tmpl.Name || wf.Repo != "/src/api" {
+			t.Errorf("%s: name %q repo %q", tmpl.Name, wf.Name, wf.Repo)
+		}
+		if wf.Meta.Planner != "template "+tmpl.Name || wf.Meta.GeneratedAt == "" {
+			t.Errorf("%s: meta %+v", tmpl.Name, wf.Meta)
+		}
+		if len(wf.Steps) == 0 {
+			t.Errorf("%s has no steps", tmpl.Name)
+		}
+	}
+}
+
+func TestRenderUnknown(t *testing.T) {
+	for _, name := range []string{"nope", "../deps", "deps.yml"} {
+		_, err := Render(name, "/src/api")
+		if err == nil || !strings.Contains(err.Error(), "unknown template") {
+			t.Errorf("%s: expected unknown template error, got %v", name, err)
+		}
+	}
+}
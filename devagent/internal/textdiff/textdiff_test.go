@@ -0,0 +1,26 @@
+package textdiff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	a := "version: 1\nname: nightly\nschedule:\n  cron: \"0 2 * * *\"\nsteps:\n  - run: make test\n"
+	b := "version: 1\nname: nightly\nschedule:\n  cron: \"0 3 * * *\"\nsteps:\n  - run: make test\n  - run: make lint\n"
+	want := `--- old
++++ new
+@@ -1,6 +1,7 @@
+ version: 1
+ name: nightly
+ schedule:
+-  cron: "0 2 * * *"
++  cron: "0 3 * * *"
+ steps:
+   - run: make test
++  - run: make lint
+`
+	if got := Unified(a, b, "old", "new"); got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+	if got := Unified(a, a, "old", "new"); got != "" {
+		t.Fatalf("identical input should produce no diff, got %q", got)
+	}
+}
@@ -0,0 +1,139 @@
+package top
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// terminal drives the user's terminal through stty, which puts it in a mode
+// where keys arrive unechoed as they are pressed and a read waits at most a
+// tenth of a second, so the screen keeps refreshing without input.
+type terminal struct {
+	in    *os.File
+	out   io.Writer
+	saved string // stty settings to restore
+	shown string // the screen last drawn
+}
+
+// openTerminal switches in to the UI's mode and out to the alternate
+// screen.
+func openTerminal(in *os.File, out io.Writer) (*terminal, error) {
+	if info, err := in.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, errors.New("devagent top needs a terminal")
+	}
+	saved, err := stty(in, "-g")
+	if err != nil {
+		return nil, fmt.Errorf("read terminal settings: %w", err)
+	}
+	t := &terminal{in: in, out: out, saved: saved}
+	if err := t.enter(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *terminal) enter() error {
+	if _, err := stty(t.in, "-icanon", "-echo", "-isig", "min", "0", "time", "1"); err != nil {
+		return fmt.Errorf("set terminal mode: %w", err)
+	}
+	// Alternate screen, hidden cursor.
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	t.shown = ""
+	return nil
+}
+
+// close restores the screen and the terminal settings.
+func (t *terminal) close() {
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+	_, _ = stty(t.in, t.saved)
+}
+
+// suspend restores the terminal while fn runs, e.g. a pager.
+func (t *terminal) suspend(fn func() error) error {
+	t.close()
+	err := fn()
+	if enterErr := t.enter(); enterErr != nil && err == nil {
+		err = enterErr
+	}
+	return err
+}
+
+// size returns the terminal's width and height, or zeros when unknown.
+func (t *terminal) size() (width, height int) {
+	out, err := stty(t.in, "size")
+	if err != nil {
+		return 0, 0
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0
+	}
+	height, _ = strconv.Atoi(fields[0])
+	width, _ = strconv.Atoi(fields[1])
+	return width, height
+}
+
+// draw replaces the screen with screen unless it is already shown.
+func (t *terminal) draw(screen string) {
+	if screen == t.shown {
+		return
+	}
+	t.shown = screen
+	// Each line, and then the screen, is cleared to its end so a shorter
+	// frame leaves nothing behind. The last line ends without a newline so
+	// a full screen does not scroll.
+	lines := strings.Split(screen, "\n")
+	fmt.Fprint(t.out, "\x1b[H"+strings.Join(lines, "\x1b[K\n")+"\x1b[K\x1b[J")
+}
+
+// readKey waits up to a tenth of a second for a key, returning "" when
+// none was pressed.
+func (t *terminal) readKey() (string, error) {
+	buf := make([]byte, 16)
+	n, err := t.in.Read(buf)
+	if n == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			return "", nil
+		}
+		return "", err
+	}
+	return parseKey(buf[:n]), nil
+}
+
+// parseKey names the key whose bytes are in b.
+func parseKey(b []byte) string {
+	switch s := string(b); s {
+	case "\x1b[A", "\x1bOA":
+		return keyUp
+	case "\x1b[B", "\x1bOB":
+		return keyDown
+	case "\x1b[H", "\x1bOH", "\x1b[1~":
+		return keyHome
+	case "\x1b[F", "\x1bOF", "\x1b[4~":
+		return keyEnd
+	case "\r", "\n":
+		return keyEnter
+	case "\x03":
+		return keyCtrlC
+	case "\x1b":
+		return keyEscape
+	default:
+		if strings.HasPrefix(s, "\x1b") {
+			return ""
+		}
+		return s[:1]
+	}
+}
+
+// stty runs stty on the terminal f and returns its trimmed output.
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
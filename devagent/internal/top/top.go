@@ -0,0 +1,348 @@
+// Package top implements `devagent top`, a terminal UI that lists the
+// registered jobs with their next run times and the live step progress of
+// runs in flight, and triggers, pauses or opens the logs of the selected
+// job.
+package top
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"devagent/internal/api"
+	"devagent/internal/util"
+)
+
+// Job is one row of the job list.
+type Job struct {
+	api.JobStatus
+	// Schedule describes when the job runs, e.g. "cron=0 2 * * *".
+	Schedule string
+	// Next is when the daemon next runs the job; zero when unknown.
+	Next time.Time
+}
+
+// Options connects the UI to the job registry.
+type Options struct {
+	// Load returns the jobs to show, in display order.
+	Load func(ctx context.Context) ([]Job, error)
+	// Trigger starts a run of job now, returning a message to show.
+	Trigger func(job string) (string, error)
+	// SetPaused pauses or resumes job.
+	SetPaused func(job string, paused bool) error
+	// OpenLogs shows the log of job's latest run. It is called with the
+	// terminal restored, and the UI resumes when it returns.
+	OpenLogs func(job string) error
+	// Refresh is how often the jobs are reloaded; 0 means every second.
+	Refresh time.Duration
+	// In is the terminal; Out is where the screen is drawn.
+	In  *os.File
+	Out io.Writer
+}
+
+// Run shows the UI until the user quits or ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	if opts.Refresh <= 0 {
+		opts.Refresh = time.Second
+	}
+	term, err := openTerminal(opts.In, opts.Out)
+	if err != nil {
+		return err
+	}
+	defer term.close()
+
+	m := &model{}
+	reload := func() {
+		jobs, err := opts.Load(ctx)
+		if err != nil {
+			m.message = fmt.Sprintf("load error: %v", err)
+			return
+		}
+		m.setJobs(jobs)
+		m.width, m.height = term.size()
+	}
+	reload()
+	loaded := time.Now()
+	for ctx.Err() == nil {
+		term.draw(m.render(time.Now()))
+		key, err := term.readKey()
+		if err != nil {
+			return err
+		}
+		if time.Since(loaded) >= opts.Refresh {
+			reload()
+			loaded = time.Now()
+		}
+		action, job := m.handle(key)
+		switch action {
+		case actionQuit:
+			return nil
+		case actionTrigger:
+			if msg, err := opts.Trigger(job.Name); err != nil {
+				m.message = fmt.Sprintf("cannot run %s: %v", job.Name, err)
+			} else {
+				m.message = msg
+			}
+		case actionTogglePause:
+			if err := opts.SetPaused(job.Name, !job.Paused); err != nil {
+				m.message = fmt.Sprintf("cannot %s %s: %v", pauseVerb(!job.Paused), job.Name, err)
+			} else {
+				m.message = fmt.Sprintf("%sd %s", pauseVerb(!job.Paused), job.Name)
+			}
+		case actionLogs:
+			err := term.suspend(func() error { return opts.OpenLogs(job.Name) })
+			if err != nil {
+				m.message = fmt.Sprintf("logs of %s: %v", job.Name, err)
+			}
+		}
+		if action != actionNone {
+			reload()
+			loaded = time.Now()
+		}
+	}
+	return nil
+}
+
+func pauseVerb(pause bool) string {
+	if pause {
+		return "pause"
+	}
+	return "resume"
+}
+
+// action is what a key press asks Run to do.
+type action int
+
+const (
+	actionNone action = iota
+	actionQuit
+	actionTrigger
+	actionTogglePause
+	actionLogs
+)
+
+// Keys read from the terminal, other than printable characters.
+const (
+	keyUp     = "up"
+	keyDown   = "down"
+	keyEnter  = "enter"
+	keyCtrlC  = "ctrl-c"
+	keyEscape = "esc"
+	keyHome   = "home"
+	keyEnd    = "end"
+)
+
+// model is the state of the UI: the jobs shown, the selected one and the
+// last message.
+type model struct {
+	jobs     []Job
+	cursor   int
+	offset   int // first job row on screen
+	message  string
+	width    int
+	height   int
+	selected string // name of the selected job, kept across reloads
+}
+
+// setJobs replaces the job list, keeping the selected job selected.
+func (m *model) setJobs(jobs []Job) {
+	m.jobs = jobs
+	m.cursor = 0
+	for i, job := range jobs {
+		if job.Name == m.selected {
+			m.cursor = i
+		}
+	}
+	if len(jobs) > 0 {
+		m.selected = jobs[m.cursor].Name
+	}
+}
+
+// handle applies key to the model and returns the action it asks for, with
+// the selected job.
+func (m *model) handle(key string) (action, Job) {
+	if key == "q" || key == keyCtrlC || key == keyEscape {
+		return actionQuit, Job{}
+	}
+	if len(m.jobs) == 0 {
+		return actionNone, Job{}
+	}
+	switch key {
+	case keyUp, "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case keyDown, "j":
+		if m.cursor < len(m.jobs)-1 {
+			m.cursor++
+		}
+	case keyHome, "g":
+		m.cursor = 0
+	case keyEnd, "G":
+		m.cursor = len(m.jobs) - 1
+	case "r":
+		return actionTrigger, m.jobs[m.cursor]
+	case "p":
+		return actionTogglePause, m.jobs[m.cursor]
+	case "l", keyEnter:
+		return actionLogs, m.jobs[m.cursor]
+	}
+	m.selected = m.jobs[m.cursor].Name
+	return actionNone, Job{}
+}
+
+// render draws the screen as of now.
+func (m *model) render(now time.Time) string {
+	width := m.width
+	if width <= 0 {
+		width = 100
+	}
+	var lines []string
+	running := 0
+	for _, job := range m.jobs {
+		if job.Running != nil {
+			running++
+		}
+	}
+	title := fmt.Sprintf("devagent top: %d %s, %d running", len(m.jobs), util.Plural(len(m.jobs), "job"), running)
+	clock := now.Format("15:04:05")
+	lines = append(lines, title+strings.Repeat(" ", max(1, width-len(title)-len(clock)))+clock, "")
+	if len(m.jobs) == 0 {
+		lines = append(lines, "  no jobs scheduled")
+	}
+
+	header := fmt.Sprintf("  %-24s %-22s %-12s %-24s %s", "JOB", "SCHEDULE", "NEXT", "LAST RUN", "STATUS")
+	var rows []string
+	cursorRow := 0
+	for i, job := range m.jobs {
+		marker := "  "
+		if i == m.cursor {
+			marker = "> "
+			cursorRow = len(rows)
+		}
+		rows = append(rows, fmt.Sprintf("%s%-24s %-22s %-12s %-24s %s", marker, clip(job.Name, 24), clip(job.Schedule, 22), nextDesc(job, now), lastDesc(job), statusDesc(job)))
+		if p := job.Running; p != nil {
+			rows = append(rows, "    "+progressDesc(*p, now))
+		}
+	}
+
+	// Keep the selected job on screen below the header and above the
+	// message and key help.
+	visible := len(rows)
+	if m.height > 0 {
+		visible = m.height - len(lines) - 4
+		if visible < 1 {
+			visible = 1
+		}
+	}
+	if cursorRow < m.offset {
+		m.offset = cursorRow
+	}
+	if cursorRow >= m.offset+visible {
+		m.offset = cursorRow - visible + 1
+	}
+	if m.offset > len(rows)-visible {
+		m.offset = max(0, len(rows)-visible)
+	}
+	end := min(len(rows), m.offset+visible)
+	if len(m.jobs) > 0 {
+		lines = append(lines, header)
+		lines = append(lines, rows[m.offset:end]...)
+	}
+
+	lines = append(lines, "", m.message, "↑/↓ select  r run now  p pause/resume  l logs  q quit")
+	for i, line := range lines {
+		lines[i] = clip(line, width)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nextDesc describes when job runs next, relative to now.
+func nextDesc(job Job, now time.Time) string {
+	switch {
+	case job.Paused:
+		return "paused"
+	case job.After != "":
+		return "after " + job.After
+	case job.Next.IsZero():
+		return "-"
+	case !job.Next.After(now):
+		return "due"
+	}
+	return "in " + shortDuration(job.Next.Sub(now))
+}
+
+func lastDesc(job Job) string {
+	if job.LastRun == nil {
+		return "never"
+	}
+	return job.LastRun.Local().Format("2006-01-02 15:04")
+}
+
+func statusDesc(job Job) string {
+	if job.Running != nil {
+		return "running"
+	}
+	status := job.LastStatus
+	if status == "" {
+		status = "-"
+	}
+	if job.FailureStreak > 1 {
+		status += fmt.Sprintf(" (%d in a row)", job.FailureStreak)
+	}
+	return status
+}
+
+// progressDesc shows a run's step with a bar, e.g.
+// "[####------] step 4/10 go test ./... 2m3s".
+func progressDesc(p api.RunProgress, now time.Time) string {
+	if p.Step == 0 || p.Total == 0 {
+		return fmt.Sprintf("starting, %s", shortDuration(now.Sub(p.StartedAt)))
+	}
+	const barWidth = 10
+	done := (p.Step - 1) * barWidth / p.Total
+	bar := "[" + strings.Repeat("#", done) + strings.Repeat("-", barWidth-done) + "]"
+	elapsed := now.Sub(p.StartedAt)
+	if p.StepStartedAt != nil {
+		elapsed = now.Sub(*p.StepStartedAt)
+	}
+	line := fmt.Sprintf("%s step %d/%d %s %s", bar, p.Step, p.Total, p.Cmd, shortDuration(elapsed))
+	if p.Stalled && p.LastOutput != nil {
+		line += fmt.Sprintf(", stalled: no output for %s", shortDuration(now.Sub(*p.LastOutput)))
+	}
+	return line
+}
+
+// shortDuration formats d to at most two units, e.g. 3d4h, 11h54m or 4m12s.
+func shortDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Second)
+	days, hours := int(d/(24*time.Hour)), int(d/time.Hour)%24
+	minutes, seconds := int(d/time.Minute)%60, int(d/time.Second)%60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm%ds", minutes, seconds)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// clip shortens s to width characters.
+func clip(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(r[:width])
+	}
+	return string(r[:width-1]) + "…"
+}
@@ -0,0 +1,83 @@
+package top
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"devagent/internal/api"
+)
+
+func TestRenderAndKeys(t *testing.T) {
+	now := time.Date(2026, 3, 9, 14, 5, 6, 0, time.Local)
+	last := now.Add(-12 * time.Hour)
+	stepStart := now.Add(-4*time.Minute - 12*time.Second)
+	jobs := []Job{
+		{JobStatus: api.JobStatus{Name: "nightly", LastStatus: "success", LastRun: &last}, Schedule: "cron=0 2 * * *", Next: now.Add(11*time.Hour + 54*time.Minute)},
+		{JobStatus: api.JobStatus{Name: "tests", LastStatus: "success", Running: &api.RunProgress{Job: "tests", StartedAt: now.Add(-5 * time.Minute), Step: 4, Total: 10, Cmd: "go test ./...", StepStartedAt: &stepStart}}, Schedule: "every=1h", Next: now.Add(-time.Minute)},
+		{JobStatus: api.JobStatus{Name: "docs", LastStatus: "failed", FailureStreak: 2, Paused: true}, Schedule: "after=nightly"},
+	}
+	m := &model{width: 120}
+	m.setJobs(jobs)
+
+	screen := m.render(now)
+	for _, want := range []string{
+		"devagent top: 3 jobs, 1 running",
+		"> nightly",
+		"in 11h54m",
+		"failed (2 in a row)",
+		"[###-------] step 4/10 go test ./... 4m12s",
+		"due",
+		"  docs",
+		"paused",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("expected %q on screen:\n%s", want, screen)
+		}
+	}
+
+	if action, _ := m.handle("j"); action != actionNone || m.cursor != 1 {
+		t.Fatalf("j should move down, cursor %d", m.cursor)
+	}
+	if action, job := m.handle("r"); action != actionTrigger || job.Name != "tests" {
+		t.Fatalf("r should trigger tests, got %v %s", action, job.Name)
+	}
+	m.handle(keyEnd)
+	if action, job := m.handle("p"); action != actionTogglePause || job.Name != "docs" || !job.Paused {
+		t.Fatalf("p should toggle docs, got %v %+v", action, job)
+	}
+	// The selection follows the job when the list is reloaded.
+	m.setJobs(append([]Job{{JobStatus: api.JobStatus{Name: "api"}}}, jobs...))
+	if _, job := m.handle(keyEnter); job.Name != "docs" {
+		t.Fatalf("expected docs still selected, got %s", job.Name)
+	}
+	if action, _ := m.handle("q"); action != actionQuit {
+		t.Fatal("q should quit")
+	}
+}
+
+func TestRenderScrollsToCursor(t *testing.T) {
+	var jobs []Job
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		jobs = append(jobs, Job{JobStatus: api.JobStatus{Name: "job-" + name}})
+	}
+	m := &model{width: 80, height: 9}
+	m.setJobs(jobs)
+	m.handle(keyEnd)
+	screen := m.render(time.Now())
+	if !strings.Contains(screen, "> job-h") || strings.Contains(screen, "job-a") {
+		t.Fatalf("expected the list scrolled to the last job:\n%s", screen)
+	}
+	if lines := strings.Count(screen, "\n") + 1; lines > 9 {
+		t.Fatalf("screen has %d lines for a height of 9:\n%s", lines, screen)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	cases := map[string]string{"\x1b[A": keyUp, "\x1bOB": keyDown, "\r": keyEnter, "\x03": keyCtrlC, "q": "q", "\x1b[5~": ""}
+	for in, want := range cases {
+		if got := parseKey([]byte(in)); got != want {
+			t.Errorf("parseKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
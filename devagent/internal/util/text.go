@@ -0,0 +1,9 @@
+package util
+
+// Plural returns word, with an "s" appended unless n is 1.
+func Plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,11 @@
+package util
+
+import "testing"
+
+func TestPlural(t *testing.T) {
+	for n, want := range map[int]string{0: "runs", 1: "run", 2: "runs"} {
+		if got := Plural(n, "run"); got != want {
+			t.Errorf("Plural(%d) = %q, want %q", n, got, want)
+		}
+	}
+}